@@ -1,23 +1,44 @@
 #!/usr/bin/env bash
 
+# SIGNING_KEY is the path to the PEM encoded Ed25519 private key the checksums
+# of the release are signed with. It can be created with:
+#
+#   openssl genpkey -algorithm ed25519 -out blazectl-release.pem
+#
+# Its public key is embedded into the binaries, so that self-update only
+# installs releases signed with the same key.
+if [ -z "${SIGNING_KEY}" ]; then
+  echo "Please set SIGNING_KEY to the path of the Ed25519 private key used to sign the release."
+  exit 1
+fi
+
+set -e
+
+PUBLIC_KEY=$(openssl pkey -in "${SIGNING_KEY}" -pubout -outform DER | tail -c 32 | openssl base64 -A)
+LDFLAGS="-X github.com/samply/blazectl/update.PublicKey=${PUBLIC_KEY}"
+
 mkdir -p builds
 
-CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build
+CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags "${LDFLAGS}"
 tar czf builds/blazectl-${VERSION}-linux-amd64.tar.gz blazectl
 rm blazectl
 
-CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build
+CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags "${LDFLAGS}"
 tar czf builds/blazectl-${VERSION}-linux-arm64.tar.gz blazectl
 rm blazectl
 
-CGO_ENABLED=0 GOOS=darwin GOARCH=amd64 go build
+CGO_ENABLED=0 GOOS=darwin GOARCH=amd64 go build -ldflags "${LDFLAGS}"
 tar czf builds/blazectl-${VERSION}-darwin-amd64.tar.gz blazectl
 rm blazectl
 
-CGO_ENABLED=0 GOOS=darwin GOARCH=arm64 go build
+CGO_ENABLED=0 GOOS=darwin GOARCH=arm64 go build -ldflags "${LDFLAGS}"
 tar czf builds/blazectl-${VERSION}-darwin-arm64.tar.gz blazectl
 rm blazectl
 
-CGO_ENABLED=0 GOOS=windows GOARCH=amd64 go build
+CGO_ENABLED=0 GOOS=windows GOARCH=amd64 go build -ldflags "${LDFLAGS}"
 zip -q builds/blazectl-${VERSION}-windows-amd64.zip blazectl.exe
 rm blazectl.exe
+
+cd builds
+sha256sum blazectl-${VERSION}-*.tar.gz blazectl-${VERSION}-*.zip > blazectl-${VERSION}-checksums.txt
+openssl pkeyutl -sign -inkey "${SIGNING_KEY}" -rawin -in blazectl-${VERSION}-checksums.txt | openssl base64 -A > blazectl-${VERSION}-checksums.txt.sig
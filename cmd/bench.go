@@ -0,0 +1,360 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchTagSystem is the system of the tags marking the resources uploaded by
+// a round of bench-upload.
+const benchTagSystem = "https://github.com/samply/blazectl/bench-upload"
+
+// benchDeleteChunkSize is the number of DELETE entries of one batch used to
+// clean up after a round of bench-upload.
+const benchDeleteChunkSize = 100
+
+var benchServers []string
+var benchConcurrencies []int
+var benchRounds int
+var benchKeep bool
+
+// tagBundleResources adds tag to the meta of every resource of the bundle in
+// content.
+func tagBundleResources(content []byte, tag fm.Coding) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var bundle map[string]any
+	if err := decoder.Decode(&bundle); err != nil {
+		return nil, err
+	}
+
+	entries, _ := bundle["entry"].([]any)
+	for _, entry := range entries {
+		entryMap, _ := entry.(map[string]any)
+		resource, ok := entryMap["resource"].(map[string]any)
+		if !ok {
+			continue
+		}
+		meta, ok := resource["meta"].(map[string]any)
+		if !ok {
+			meta = make(map[string]any)
+			resource["meta"] = meta
+		}
+		tags, _ := meta["tag"].([]any)
+		meta["tag"] = append(tags, map[string]any{"system": *tag.System, "code": *tag.Code})
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(bundle); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newBenchTag returns a new random tag for a round of bench-upload.
+func newBenchTag() (fm.Coding, error) {
+	code, err := uuid.NewRandom()
+	if err != nil {
+		return fm.Coding{}, err
+	}
+	return createCoding(benchTagSystem, code.String()), nil
+}
+
+// deleteTaggedResources deletes all resources having tag using batches of
+// DELETE entries. Returns the number of deleted resources.
+func deleteTaggedResources(client *fhir.Client, tag fm.Coding) (int, error) {
+	bundleChannel := make(chan downloadBundle, 2)
+	go downloadResources(client, "", "_tag="+*tag.System+"|"+*tag.Code+"&_elements=id", false, bundleChannel)
+
+	var references []string
+	for bundle := range bundleChannel {
+		if bundle.err != nil {
+			return 0, fmt.Errorf("error while searching the tagged resources: %w", bundle.err)
+		}
+		if bundle.errResponse != nil {
+			return 0, fmt.Errorf("error while searching the tagged resources:\n\n%s", bundle.errResponse.String())
+		}
+		if len(bundle.rawEntries) == 0 {
+			continue
+		}
+		var entries []struct {
+			Resource patientResource `json:"resource"`
+		}
+		if err := json.Unmarshal(bundle.rawEntries, &entries); err != nil {
+			return 0, fmt.Errorf("could not parse the bundle entries from JSON: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.Resource.ResourceType != "" && entry.Resource.ResourceType != "OperationOutcome" {
+				references = append(references, entry.Resource.ResourceType+"/"+entry.Resource.Id)
+			}
+		}
+	}
+
+	var deleted int
+	for start := 0; start < len(references); start += benchDeleteChunkSize {
+		end := min(start+benchDeleteChunkSize, len(references))
+		n, err := deleteResources(client, references[start:end])
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteResources deletes the resources with the given references using a
+// batch. Returns the number of successful deletes.
+func deleteResources(client *fhir.Client, references []string) (int, error) {
+	batch := fm.Bundle{Type: fm.BundleTypeBatch}
+	for _, reference := range references {
+		batch.Entry = append(batch.Entry, fm.BundleEntry{
+			Request: &fm.BundleEntryRequest{Method: fm.HTTPVerbDELETE, Url: reference},
+		})
+	}
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return 0, err
+	}
+	req, err := client.NewTransactionRequest(bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return 0, fmt.Errorf("error while deleting resources:\n\n%s", errorResponse.String())
+	}
+	bundle, err := fhir.ReadBundle(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	statusCodes := make(map[int]int)
+	for _, entry := range bundle.Entry {
+		statusCodes[entryErrorResponse(entry.Response).StatusCode]++
+	}
+	deleted := countSuccessful(statusCodes)
+	if deleted < len(references) {
+		return deleted, fmt.Errorf("%d of %d resources could not be deleted", len(references)-deleted, len(references))
+	}
+	return deleted, nil
+}
+
+// collectBundles returns all bundles found in files, so that every round of
+// bench-upload uploads the same bundles without inspecting the files again.
+func collectBundles(files processableFiles) []bundle {
+	producer := newUploadBundleProducer(noopProgress{}, inspectionConcurrency)
+	summaryCh := producer.createUploadBundles(files)
+	var bundles []bundle
+	for b := range producer.res {
+		bundles = append(bundles, b)
+	}
+	<-summaryCh
+	return bundles
+}
+
+// benchRound uploads bundles with the given concurrency and returns the
+// aggregated results together with the duration of the upload.
+func benchRound(client *fhir.Client, bundles []bundle, concurrency int) (aggregatedUploadResults, time.Duration) {
+	uploadResultCh := make(chan bundleUploadResult)
+	aggregatedUploadResultsCh := make(chan aggregatedUploadResults)
+	go aggregateUploadResults(uploadResultCh, aggregatedUploadResultsCh, noopProgress{}, nil)
+
+	bundleCh := make(chan bundle)
+	go func() {
+		for _, b := range bundles {
+			bundleCh <- b
+		}
+		close(bundleCh)
+	}()
+
+	start := time.Now()
+	newUploadBundleConsumer(client, uploadResultCh).uploadBundles(context.Background(), bundleCh, concurrency)
+	duration := time.Since(start)
+	close(uploadResultCh)
+	client.CloseIdleConnections()
+	return <-aggregatedUploadResultsCh, duration
+}
+
+// benchResult is the result of one round of bench-upload.
+type benchResult struct {
+	server      string
+	concurrency int
+	round       int
+	results     aggregatedUploadResults
+	duration    time.Duration
+}
+
+// failedBundles returns the number of bundles which couldn't be uploaded.
+func (r benchResult) failedBundles() int {
+	return len(r.results.errors) + len(r.results.errorResponses)
+}
+
+// fmtBenchResults formats the results of all rounds as table with one row per
+// round.
+func fmtBenchResults(units util.UnitFormat, results []benchResult) (string, error) {
+	builder := strings.Builder{}
+	w := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tCONCURRENCY\tROUND\tRESOURCES\tDURATION\tRATE\tREQU. 50\tREQU. 95\tREQU. 99\tFAILED")
+	for _, result := range results {
+		resources := result.results.uploadedResources()
+		latencies := []string{"-", "-", "-"}
+		if result.results.requestDurations.Count() > 0 {
+			stats := result.results.requestDurations.Statistics()
+			latencies = []string{units.Latency(stats.Q50), units.Latency(stats.Q95), units.Latency(stats.Q99)}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%.2f/s\t%s\t%d\n", result.server, result.concurrency, result.round,
+			resources, units.Duration(result.duration), float64(resources)/result.duration.Seconds(),
+			strings.Join(latencies, "\t"), result.failedBundles())
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+var benchUploadCmd = &cobra.Command{
+	Use:   "bench-upload [directory]",
+	Short: "Benchmark uploads of transaction bundles",
+	Long: `Uploads the transaction bundles of a directory several times and prints
+a table comparing the throughput and latencies of all rounds.
+
+Each combination of the servers given by --server and the concurrencies
+given by --concurrency is uploaded --rounds times. All resources uploaded in
+a round are tagged with a random code of the system
+https://github.com/samply/blazectl/bench-upload and are deleted after the
+round, so that every round starts with the same data on the server. Use
+--keep to keep the resources of the last round.
+
+Examples:
+  blazectl bench-upload --server http://localhost:8080/fhir -c 1,2,4,8 --rounds 3 my/bundles
+  blazectl bench-upload --server http://server-a:8080/fhir,http://server-b:8080/fhir my/bundles`,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires a directory argument")
+		}
+		if info, err := os.Stat(args[0]); os.IsNotExist(err) {
+			return fmt.Errorf("directory `%s` doesn't exist", args[0])
+		} else if !info.IsDir() {
+			return fmt.Errorf("`%s` isn't a directory", args[0])
+		} else {
+			return nil
+		}
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if benchRounds < 1 {
+			return fmt.Errorf("invalid number of rounds %d, expected at least 1", benchRounds)
+		}
+		for _, c := range benchConcurrencies {
+			if c < 1 {
+				return fmt.Errorf("invalid concurrency %d, expected at least 1", c)
+			}
+		}
+
+		files, err := findProcessableFiles(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		bundles := collectBundles(files)
+		if len(bundles) == 0 {
+			fmt.Println("Found no bundles to upload.")
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "Found %d bundles in %s.\n", len(bundles), args[0])
+
+		var results []benchResult
+		for _, benchServer := range benchServers {
+			server = benchServer
+			if err := createClient(); err != nil {
+				return err
+			}
+			for _, c := range benchConcurrencies {
+				for round := 1; round <= benchRounds; round++ {
+					tag, err := newBenchTag()
+					if err != nil {
+						return err
+					}
+					uploadTag = &tag
+
+					fmt.Fprintf(os.Stderr, "Upload to %s with concurrency %d, round %d ...\n", server, c, round)
+					roundResults, duration := benchRound(client, bundles, c)
+					results = append(results, benchResult{
+						server:      server,
+						concurrency: c,
+						round:       round,
+						results:     roundResults,
+						duration:    duration,
+					})
+
+					last := round == benchRounds && c == benchConcurrencies[len(benchConcurrencies)-1]
+					if benchKeep && last {
+						continue
+					}
+					deleted, err := deleteTaggedResources(client, tag)
+					if err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+					fmt.Fprintf(os.Stderr, "Deleted %d resources.\n", deleted)
+				}
+			}
+		}
+
+		table, err := fmtBenchResults(unitFormat(), results)
+		if err != nil {
+			return err
+		}
+		fmt.Print("\n" + table)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(benchUploadCmd)
+
+	benchUploadCmd.Flags().StringSliceVar(&benchServers, "server", nil, "the base URLs of the servers to compare")
+	benchUploadCmd.Flags().IntSliceVarP(&benchConcurrencies, "concurrency", "c", []int{2}, "the numbers of parallel uploads to compare")
+	benchUploadCmd.Flags().IntVar(&benchRounds, "rounds", 1, "number of uploads of each combination of server and concurrency")
+	benchUploadCmd.Flags().BoolVar(&benchKeep, "keep", false, "keep the resources uploaded in the last round of each server")
+	benchUploadCmd.Flags().IntVar(&inspectionConcurrency, "inspection-concurrency", 4, "number of NDJSON files inspected in parallel")
+	benchUploadCmd.Flags().StringVar(&uploadPrefer, "prefer", "minimal", "return preference for transaction responses, one of minimal, representation or OperationOutcome")
+
+	_ = benchUploadCmd.MarkFlagRequired("server")
+}
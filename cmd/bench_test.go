@@ -0,0 +1,155 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTagBundleResources(t *testing.T) {
+	tag := createCoding(benchTagSystem, "0")
+	content := []byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "Patient", "id": "0"}, "request": {"method": "PUT", "url": "Patient/0"}},
+  {"resource": {"resourceType": "Observation", "meta": {"tag": [{"code": "a"}]}, "valueQuantity": {"value": 1.50}},
+   "request": {"method": "POST", "url": "Observation"}},
+  {"request": {"method": "DELETE", "url": "Patient/1"}}
+]}`)
+
+	tagged, err := tagBundleResources(content, tag)
+
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "Patient", "id": "0", "meta": {"tag": [{"system": "`+benchTagSystem+`", "code": "0"}]}},
+   "request": {"method": "PUT", "url": "Patient/0"}},
+  {"resource": {"resourceType": "Observation", "meta": {"tag": [{"code": "a"}, {"system": "`+benchTagSystem+`", "code": "0"}]},
+   "valueQuantity": {"value": 1.50}}, "request": {"method": "POST", "url": "Observation"}},
+  {"request": {"method": "DELETE", "url": "Patient/1"}}
+]}`, string(tagged))
+		assert.Contains(t, string(tagged), "1.50")
+	}
+}
+
+func TestDeleteTaggedResources(t *testing.T) {
+	tag := createCoding(benchTagSystem, "0")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/", r.URL.Path)
+		if r.Method == "GET" {
+			assert.Equal(t, benchTagSystem+"|0", r.URL.Query().Get("_tag"))
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [
+              {"resource": {"resourceType": "Patient", "id": "0"}, "search": {"mode": "match"}},
+              {"resource": {"resourceType": "Observation", "id": "1"}, "search": {"mode": "match"}}]}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var batch fm.Bundle
+		if assert.NoError(t, json.Unmarshal(body, &batch)) {
+			assert.Equal(t, fm.BundleTypeBatch, batch.Type)
+			if assert.Len(t, batch.Entry, 2) {
+				assert.Equal(t, fm.HTTPVerbDELETE, batch.Entry[0].Request.Method)
+				assert.Equal(t, "Patient/0", batch.Entry[0].Request.Url)
+				assert.Equal(t, "Observation/1", batch.Entry[1].Request.Url)
+			}
+		}
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "batch-response", "entry": [
+          {"response": {"status": "204"}}, {"response": {"status": "204"}}]}`))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	deleted, err := deleteTaggedResources(fhir.NewClient(*baseURL, nil), tag)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, deleted)
+	}
+}
+
+func TestFmtBenchResults(t *testing.T) {
+	results := []benchResult{
+		{
+			server:      "http://localhost:8080/fhir",
+			concurrency: 2,
+			round:       1,
+			results: aggregatedUploadResults{
+				requestDurations: util.NewDurationDigest(1, 2, 3),
+				entryStatusCodes: map[int]int{201: 100},
+			},
+			duration: 4 * time.Second,
+		},
+		{
+			server:      "http://localhost:8080/fhir",
+			concurrency: 4,
+			round:       1,
+			results: aggregatedUploadResults{
+				errors: map[bundleIdentifier]error{{filename: "a.json"}: assert.AnError},
+			},
+			duration: 2 * time.Second,
+		},
+	}
+
+	table, err := fmtBenchResults(util.UnitFormat{Raw: true}, results)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `SERVER                      CONCURRENCY  ROUND  RESOURCES  DURATION  RATE     REQU. 50  REQU. 95  REQU. 99  FAILED
+http://localhost:8080/fhir  2            1      100        4.000     25.00/s  1.500     2.849     2.969     0
+http://localhost:8080/fhir  4            1      0          2.000     0.00/s   -         -         -         1
+`, table)
+}
+
+func TestBenchRound(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(body), benchTagSystem)
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "transaction-response", "entry": [
+          {"response": {"status": "201"}}]}`))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	bundle := `{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "Patient"}, "request": {"method": "POST", "url": "Patient"}}]}`
+	for _, name := range []string{"a.json", "b.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(bundle), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := findProcessableFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundles := collectBundles(files)
+	assert.Len(t, bundles, 2)
+
+	tag := createCoding(benchTagSystem, "0")
+	uploadTag = &tag
+	defer func() { uploadTag = nil }()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	results, _ := benchRound(fhir.NewClient(*baseURL, nil), bundles, 2)
+
+	assert.Equal(t, 2, results.totalProcessedBundles)
+	assert.Equal(t, 2, results.uploadedResources())
+}
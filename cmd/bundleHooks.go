@@ -0,0 +1,73 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+var preBundleCmd string
+var postBundleCmd string
+
+// bundleHookCommand creates the shell command running command for the bundle
+// with bundleId. The file and number of the bundle are passed in the
+// environment variables BLAZECTL_FILE and BLAZECTL_BUNDLE together with env.
+func bundleHookCommand(command string, bundleId bundleIdentifier, env ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"BLAZECTL_FILE="+bundleId.filename,
+		"BLAZECTL_BUNDLE="+strconv.Itoa(bundleId.bundleNumber))
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// runPreBundleCmd runs command with content of the bundle with bundleId on
+// its standard input and returns its standard output, which is uploaded
+// instead of content.
+func runPreBundleCmd(command string, bundleId bundleIdentifier, content []byte) ([]byte, error) {
+	cmd := bundleHookCommand(command, bundleId)
+	cmd.Stdin = bytes.NewReader(content)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("the pre-bundle command `%s` failed: %w", command, err)
+	}
+	return out.Bytes(), nil
+}
+
+// runPostBundleCmd runs command with the response to the upload of the
+// bundle with bundleId on its standard input. The status code of the response
+// is passed in the environment variable BLAZECTL_STATUS. The output of the
+// command goes to the standard output and error of blazectl.
+func runPostBundleCmd(command string, bundleId bundleIdentifier, statusCode int, response []byte) error {
+	cmd := bundleHookCommand(command, bundleId, "BLAZECTL_STATUS="+strconv.Itoa(statusCode))
+	cmd.Stdin = bytes.NewReader(response)
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("the post-bundle command `%s` failed: %w", command, err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRunPreBundleCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+	bundleId := bundleIdentifier{filename: "bundles.ndjson", bundleNumber: 3}
+
+	t.Run("Transforms", func(t *testing.T) {
+		content, err := runPreBundleCmd("tr a b", bundleId, []byte("aa"))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "bb", string(content))
+		}
+	})
+
+	t.Run("Environment", func(t *testing.T) {
+		content, err := runPreBundleCmd(`cat > /dev/null; printf "$BLAZECTL_FILE $BLAZECTL_BUNDLE"`, bundleId, []byte("{}"))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "bundles.ndjson 3", string(content))
+		}
+	})
+
+	t.Run("Failing", func(t *testing.T) {
+		_, err := runPreBundleCmd("cat > /dev/null; exit 3", bundleId, []byte("{}"))
+
+		assert.EqualError(t, err, "the pre-bundle command `cat > /dev/null; exit 3` failed: exit status 3")
+	})
+}
+
+func TestRunPostBundleCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+	bundleId := bundleIdentifier{filename: "bundles.ndjson", bundleNumber: 3}
+
+	t.Run("Response", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "response.json")
+
+		err := runPostBundleCmd(`(echo "$BLAZECTL_BUNDLE $BLAZECTL_STATUS"; cat) > `+path, bundleId, 422, []byte("{}"))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "3 422\n{}", readFile(t, path))
+		}
+	})
+
+	t.Run("Failing", func(t *testing.T) {
+		err := runPostBundleCmd("exit 1", bundleId, 200, nil)
+
+		assert.EqualError(t, err, "the post-bundle command `exit 1` failed: exit status 1")
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var chunkByLastUpdated string
+var chunkStart string
+var chunkEnd string
+
+// parseChunkSize parses the size of a chunk, which is either a number of
+// days, like 1d, a number of weeks, like 2w, or a Go duration, like 12h.
+func parseChunkSize(s string) (time.Duration, error) {
+	var size time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(s, "d"):
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		size = time.Duration(days) * 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		var weeks int
+		weeks, err = strconv.Atoi(strings.TrimSuffix(s, "w"))
+		size = time.Duration(weeks) * 7 * 24 * time.Hour
+	default:
+		size, err = time.ParseDuration(s)
+	}
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid --chunk-by-lastupdated value `%s`, expected a positive number of days like 1d, weeks like 2w or a duration like 12h", s)
+	}
+	return size, nil
+}
+
+// lastUpdatedChunk is the range of _lastUpdated from start inclusive to end
+// exclusive.
+type lastUpdatedChunk struct {
+	start, end time.Time
+}
+
+// lastUpdatedChunks splits the range from start to end into chunks of size.
+// The last chunk ends at end.
+func lastUpdatedChunks(start time.Time, end time.Time, size time.Duration) []lastUpdatedChunk {
+	var chunks []lastUpdatedChunk
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(size) {
+		chunkEnd := chunkStart.Add(size)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		chunks = append(chunks, lastUpdatedChunk{start: chunkStart, end: chunkEnd})
+	}
+	return chunks
+}
+
+// query returns fhirSearchQuery restricted to the resources last updated
+// within the chunk.
+func (c lastUpdatedChunk) query(fhirSearchQuery string) (string, error) {
+	query, err := url.ParseQuery(fhirSearchQuery)
+	if err != nil {
+		return "", err
+	}
+	query.Add("_lastUpdated", "ge"+c.start.UTC().Format(time.RFC3339))
+	query.Add("_lastUpdated", "lt"+c.end.UTC().Format(time.RFC3339))
+	return query.Encode(), nil
+}
+
+// findOldestLastUpdated searches the resource matching fhirSearchQuery which
+// was updated first and returns its last update truncated to seconds. Returns
+// false if there is no matching resource.
+func findOldestLastUpdated(client *fhir.Client, resourceType string, fhirSearchQuery string) (time.Time, bool, error) {
+	query, err := url.ParseQuery(fhirSearchQuery)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	query.Set("_sort", "_lastUpdated")
+	query.Set("_count", "1")
+
+	var req *http.Request
+	if resourceType == "" {
+		req, err = client.NewSearchSystemRequest(query)
+	} else {
+		req, err = client.NewSearchTypeRequest(resourceType, query)
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return time.Time{}, false, errors.New(errorResponse.String())
+	}
+	bundle, err := fm.UnmarshalBundle(body)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error while reading the search result: %w", err)
+	}
+	for _, entry := range bundle.Entry {
+		if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode == fm.SearchEntryModeOutcome {
+			continue
+		}
+		var resource struct {
+			Meta struct {
+				LastUpdated string `json:"lastUpdated"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal(entry.Resource, &resource); err != nil {
+			return time.Time{}, false, fmt.Errorf("error while reading the oldest resource: %w", err)
+		}
+		lastUpdated, err := time.Parse(time.RFC3339Nano, resource.Meta.LastUpdated)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("error while reading the last update of the oldest resource: %w", err)
+		}
+		return lastUpdated.Truncate(time.Second), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// planChunks returns the chunks of the download from --chunk-start, or the
+// last update of the oldest resource, to --chunk-end, or now.
+func planChunks(client *fhir.Client, resourceType string, fhirSearchQuery string, size time.Duration) ([]lastUpdatedChunk, error) {
+	end := time.Now()
+	if chunkEnd != "" {
+		var err error
+		if end, err = parseInstant(chunkEnd); err != nil {
+			return nil, fmt.Errorf("invalid --chunk-end value `%s`, expected a FHIR instant or date", chunkEnd)
+		}
+	}
+	if chunkStart != "" {
+		start, err := parseInstant(chunkStart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --chunk-start value `%s`, expected a FHIR instant or date", chunkStart)
+		}
+		return lastUpdatedChunks(start, end, size), nil
+	}
+	start, found, err := findOldestLastUpdated(client, resourceType, fhirSearchQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error while searching the oldest resource, use --chunk-start instead: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return lastUpdatedChunks(start, end, size), nil
+}
+
+// downloadChunkedResources downloads the resources of each chunk one after
+// another. On errors, the start of the failed chunk is printed, so that the
+// download can be restarted from there.
+func downloadChunkedResources(client *fhir.Client, resourceType string, fhirSearchQuery string, usePost bool,
+	chunks []lastUpdatedChunk, resChannel chan<- downloadBundle) {
+	defer close(resChannel)
+	for i, chunk := range chunks {
+		query, err := chunk.query(fhirSearchQuery)
+		if err != nil {
+			resChannel <- downloadBundleError("could not parse the FHIR search query: %v\n", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Downloading chunk %d of %d, last updated from %s to %s ...\n", i+1, len(chunks),
+			chunk.start.UTC().Format(time.RFC3339), chunk.end.UTC().Format(time.RFC3339))
+
+		chunkChannel := make(chan downloadBundle, downloadBuffer())
+		go downloadResources(client, resourceType, query, usePost, chunkChannel)
+		for bundle := range chunkChannel {
+			if bundle.err != nil || bundle.errResponse != nil {
+				fmt.Fprintf(os.Stderr, "Chunk %d failed. Restart the download with --chunk-start %s.\n", i+1,
+					chunk.start.UTC().Format(time.RFC3339))
+			}
+			resChannel <- bundle
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChunkSize(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"1d":  24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+	} {
+		size, err := parseChunkSize(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, size, s)
+		}
+	}
+
+	for _, s := range []string{"", "0d", "-1h", "xd", "day"} {
+		_, err := parseChunkSize(s)
+		assert.EqualError(t, err, "invalid --chunk-by-lastupdated value `"+s+"`, expected a positive number of days like 1d, weeks like 2w or a duration like 12h")
+	}
+}
+
+func TestLastUpdatedChunks(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	chunks := lastUpdatedChunks(start, start.Add(60*time.Hour), 24*time.Hour)
+
+	assert.Equal(t, []lastUpdatedChunk{
+		{start: start, end: start.Add(24 * time.Hour)},
+		{start: start.Add(24 * time.Hour), end: start.Add(48 * time.Hour)},
+		{start: start.Add(48 * time.Hour), end: start.Add(60 * time.Hour)},
+	}, chunks)
+	assert.Empty(t, lastUpdatedChunks(start, start, time.Hour))
+}
+
+func TestLastUpdatedChunkQuery(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chunk := lastUpdatedChunk{start: start, end: start.Add(24 * time.Hour)}
+
+	query, err := chunk.query("gender=female")
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "_lastUpdated=ge2024-01-01T00%3A00%3A00Z&_lastUpdated=lt2024-01-02T00%3A00%3A00Z&gender=female", query)
+	}
+}
+
+func TestFindOldestLastUpdated(t *testing.T) {
+	t.Run("found", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/Patient", r.URL.Path)
+			assert.Equal(t, "_lastUpdated", r.URL.Query().Get("_sort"))
+			assert.Equal(t, "1", r.URL.Query().Get("_count"))
+			assert.Equal(t, "female", r.URL.Query().Get("gender"))
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset",
+				"entry": [{"resource": {"resourceType": "Patient", "id": "0", "meta": {"lastUpdated": "2024-01-02T03:04:05.678Z"}}}]}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		oldest, found, err := findOldestLastUpdated(client, "Patient", "gender=female")
+
+		if assert.NoError(t, err) && assert.True(t, found) {
+			assert.True(t, oldest.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset"}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		_, found, err := findOldestLastUpdated(client, "Patient", "")
+
+		if assert.NoError(t, err) {
+			assert.False(t, found)
+		}
+	})
+}
+
+func TestDownloadChunkedResources(t *testing.T) {
+	var queries [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query()["_lastUpdated"])
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset",
+			"entry": [{"resource": {"resourceType": "Patient", "id": "0"}, "search": {"mode": "match"}}]}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chunks := lastUpdatedChunks(start, start.Add(48*time.Hour), 24*time.Hour)
+
+	bundleChannel := make(chan downloadBundle)
+	go downloadChunkedResources(client, "Patient", "", false, chunks, bundleChannel)
+
+	var pages int
+	for bundle := range bundleChannel {
+		assert.NoError(t, bundle.err)
+		pages++
+	}
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, [][]string{
+		{"ge2024-01-01T00:00:00Z", "lt2024-01-02T00:00:00Z"},
+		{"ge2024-01-02T00:00:00Z", "lt2024-01-03T00:00:00Z"},
+	}, queries)
+}
+
+func TestDownloadChunkedResourcesFailedChunk(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Query()["_lastUpdated"][0], "ge2024-01-02") {
+			w.Header().Set("Content-Type", "application/fhir+json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "exception"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset",
+			"entry": [{"resource": {"resourceType": "Patient", "id": "0"}, "search": {"mode": "match"}}]}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chunks := lastUpdatedChunks(start, start.Add(48*time.Hour), 24*time.Hour)
+	path := filepath.Join(t.TempDir(), "export.ndjson")
+	output := newPartWriter(path, 0, 0)
+	var stats commandStats
+	writer := newPageWriter(output, newDownloadStats(&stats), nil)
+	writeChannel := make(chan downloadBundle, 16)
+	writerDone := make(chan struct{})
+	go func() {
+		writer.writePages(writeChannel)
+		close(writerDone)
+	}()
+
+	bundleChannel := make(chan downloadBundle)
+	go downloadChunkedResources(client, "Patient", "", false, chunks, bundleChannel)
+
+	// like the download command, stop writing at the first failed page
+	var failed bool
+	for bundle := range bundleChannel {
+		if bundle.err != nil || bundle.errResponse != nil {
+			failed = true
+			assert.NoError(t, stopWriting(writeChannel, writerDone, output))
+			break
+		}
+		writeChannel <- bundle
+	}
+	for range bundleChannel {
+	}
+
+	assert.True(t, failed)
+	assert.Equal(t, "{\"resourceType\":\"Patient\",\"id\":\"0\"}\n", readFile(t, path))
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/data"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// codeListEntry is one code of a code list file.
+type codeListEntry struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+// readCodeList reads the code list file with the given name. CSV files need
+// a header with the columns system and code and an optional column display.
+// JSON files contain an array of objects with the same keys.
+func readCodeList(filename string) ([]codeListEntry, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = util.StripBOM(content); err != nil {
+		return nil, fmt.Errorf("error while reading the code list %s: %w", filename, err)
+	}
+
+	var entries []codeListEntry
+	switch {
+	case hasSuffixFold(filename, ".csv"):
+		entries, err = readCsvCodeList(bytes.NewReader(content))
+	case hasSuffixFold(filename, ".json"):
+		entries, err = readJsonCodeList(content)
+	default:
+		return nil, fmt.Errorf("the code list %s isn't a .csv or .json file", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the code list %s: %w", filename, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("the code list %s is empty", filename)
+	}
+	return entries, nil
+}
+
+func readCsvCodeList(r io.Reader) ([]codeListEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{"system": -1, "code": -1, "display": -1}
+	for i, name := range header {
+		if _, ok := columns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+	}
+	if columns["system"] < 0 || columns["code"] < 0 {
+		return nil, errors.New("missing system or code column in the header")
+	}
+	column := func(record []string, name string) string {
+		if i := columns[name]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var entries []codeListEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		entry := codeListEntry{System: column(record, "system"), Code: column(record, "code"), Display: column(record, "display")}
+		if entry.System == "" || entry.Code == "" {
+			return nil, fmt.Errorf("line %d: missing system or code", line)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func readJsonCodeList(content []byte) ([]codeListEntry, error) {
+	var entries []codeListEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if entry.System == "" || entry.Code == "" {
+			return nil, fmt.Errorf("entry %d: missing system or code", i)
+		}
+	}
+	return entries, nil
+}
+
+// valueSetId returns the id of the ValueSet with the given canonical URL.
+// The id is derived from the URL, so that evaluating a measure again updates
+// its ValueSets instead of creating ValueSets with the same URL.
+func valueSetId(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return "blazectl-" + hex.EncodeToString(hash[:8])
+}
+
+// createValueSetResource creates a ValueSet with the codes of entries. The
+// codes are included by system in the order of their first occurrence and
+// are listed in an expansion as well, so that servers don't need to expand
+// the ValueSet.
+func createValueSetResource(url string, entries []codeListEntry, now time.Time) *fm.ValueSet {
+	id := valueSetId(url)
+	var include []fm.ValueSetComposeInclude
+	systems := make(map[string]int)
+	contains := make([]fm.ValueSetExpansionContains, 0, len(entries))
+	for _, entry := range entries {
+		i, ok := systems[entry.System]
+		if !ok {
+			i = len(include)
+			systems[entry.System] = i
+			include = append(include, fm.ValueSetComposeInclude{System: &entry.System})
+		}
+		concept := fm.ValueSetComposeIncludeConcept{Code: entry.Code}
+		if entry.Display != "" {
+			concept.Display = &entry.Display
+		}
+		include[i].Concept = append(include[i].Concept, concept)
+		contains = append(contains, fm.ValueSetExpansionContains{System: &entry.System, Code: &entry.Code, Display: concept.Display})
+	}
+	total := len(contains)
+	return &fm.ValueSet{
+		Id:        &id,
+		Url:       &url,
+		Status:    fm.PublicationStatusActive,
+		Compose:   &fm.ValueSetCompose{Include: include},
+		Expansion: &fm.ValueSetExpansion{Timestamp: now.UTC().Format(time.RFC3339), Total: &total, Contains: contains},
+	}
+}
+
+// createValueSetEntries creates the bundle entries updating the ValueSets of
+// m with the codes of their code lists.
+func createValueSetEntries(m data.Measure, now time.Time) ([]fm.BundleEntry, error) {
+	entries := make([]fm.BundleEntry, 0, len(m.ValueSet))
+	for _, valueSet := range m.ValueSet {
+		codes, err := readCodeList(valueSet.File)
+		if err != nil {
+			return nil, err
+		}
+		resource := createValueSetResource(valueSet.Url, codes, now)
+		resourceBytes, err := json.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fm.BundleEntry{
+			Resource: resourceBytes,
+			Request: &fm.BundleEntryRequest{
+				Method: fm.HTTPVerbPUT,
+				Url:    "ValueSet/" + *resource.Id,
+			},
+		})
+	}
+	return entries, nil
+}
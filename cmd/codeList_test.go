@@ -0,0 +1,156 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/samply/blazectl/data"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCodeList(t *testing.T, name string, content string) string {
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal("can't create a temp code list file")
+	}
+	return filename
+}
+
+func TestReadCodeList(t *testing.T) {
+	t.Run("CSV", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.CSV", "\xef\xbb\xbfcode,Display,System\nE10,Type 1,http://hl7.org/fhir/sid/icd-10\nE11,,http://hl7.org/fhir/sid/icd-10\n")
+
+		entries, err := readCodeList(filename)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []codeListEntry{
+				{System: "http://hl7.org/fhir/sid/icd-10", Code: "E10", Display: "Type 1"},
+				{System: "http://hl7.org/fhir/sid/icd-10", Code: "E11"},
+			}, entries)
+		}
+	})
+
+	t.Run("CSVWithoutDisplay", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.csv", "system,code\nhttp://loinc.org,718-7\n")
+
+		entries, err := readCodeList(filename)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []codeListEntry{{System: "http://loinc.org", Code: "718-7"}}, entries)
+		}
+	})
+
+	t.Run("CSVMissingColumn", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.csv", "code\nE10\n")
+
+		_, err := readCodeList(filename)
+
+		assert.EqualError(t, err, "error while reading the code list "+filename+": missing system or code column in the header")
+	})
+
+	t.Run("CSVMissingCode", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.csv", "system,code\nhttp://loinc.org,718-7\nhttp://loinc.org,\n")
+
+		_, err := readCodeList(filename)
+
+		assert.EqualError(t, err, "error while reading the code list "+filename+": line 3: missing system or code")
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.json", `[{"system": "http://loinc.org", "code": "718-7", "display": "Hemoglobin"}]`)
+
+		entries, err := readCodeList(filename)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []codeListEntry{{System: "http://loinc.org", Code: "718-7", Display: "Hemoglobin"}}, entries)
+		}
+	})
+
+	t.Run("JSONMissingSystem", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.json", `[{"code": "718-7"}]`)
+
+		_, err := readCodeList(filename)
+
+		assert.EqualError(t, err, "error while reading the code list "+filename+": entry 0: missing system or code")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.csv", "system,code\n")
+
+		_, err := readCodeList(filename)
+
+		assert.EqualError(t, err, "the code list "+filename+" is empty")
+	})
+
+	t.Run("UnknownExtension", func(t *testing.T) {
+		filename := writeCodeList(t, "codes.txt", "E10")
+
+		_, err := readCodeList(filename)
+
+		assert.EqualError(t, err, "the code list "+filename+" isn't a .csv or .json file")
+	})
+}
+
+func TestValueSetId(t *testing.T) {
+	id := valueSetId("http://example.com/ValueSet/diabetes")
+
+	assert.Equal(t, id, valueSetId("http://example.com/ValueSet/diabetes"))
+	assert.NotEqual(t, id, valueSetId("http://example.com/ValueSet/other"))
+	assert.Regexp(t, "^blazectl-[0-9a-f]{16}$", id)
+}
+
+func TestCreateValueSetResource(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	valueSet := createValueSetResource("http://example.com/ValueSet/diabetes", []codeListEntry{
+		{System: "http://hl7.org/fhir/sid/icd-10", Code: "E10", Display: "Type 1"},
+		{System: "http://snomed.info/sct", Code: "44054006"},
+		{System: "http://hl7.org/fhir/sid/icd-10", Code: "E11"},
+	}, now)
+
+	content, _ := json.Marshal(valueSet)
+	assert.JSONEq(t, `{
+  "resourceType": "ValueSet",
+  "id": "`+valueSetId("http://example.com/ValueSet/diabetes")+`",
+  "url": "http://example.com/ValueSet/diabetes",
+  "status": "active",
+  "compose": {"include": [
+    {"system": "http://hl7.org/fhir/sid/icd-10", "concept": [{"code": "E10", "display": "Type 1"}, {"code": "E11"}]},
+    {"system": "http://snomed.info/sct", "concept": [{"code": "44054006"}]}
+  ]},
+  "expansion": {"timestamp": "2024-10-01T12:00:00Z", "total": 3, "contains": [
+    {"system": "http://hl7.org/fhir/sid/icd-10", "code": "E10", "display": "Type 1"},
+    {"system": "http://snomed.info/sct", "code": "44054006"},
+    {"system": "http://hl7.org/fhir/sid/icd-10", "code": "E11"}
+  ]}
+}`, string(content))
+}
+
+func TestCreateValueSetEntries(t *testing.T) {
+	filename := writeCodeList(t, "codes.csv", "system,code\nhttp://loinc.org,718-7\n")
+	m := data.Measure{ValueSet: []data.ValueSet{{Url: "http://example.com/ValueSet/hemoglobin", File: filename}}}
+
+	entries, err := createValueSetEntries(m, time.Now())
+
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, fm.HTTPVerbPUT, entries[0].Request.Method)
+		assert.Equal(t, "ValueSet/"+valueSetId("http://example.com/ValueSet/hemoglobin"), entries[0].Request.Url)
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+var cohortFile string
+var cohortGroup string
+var cohortConcurrency int
+
+// readCohort reads the lines of a cohort file. Each line holds a Patient id or
+// a Patient identifier as system|value. Empty lines and lines starting with #
+// are skipped.
+func readCohort(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// resolveCohortPatient returns the id of the Patient of a line of a cohort
+// file. Identifiers are resolved by searching for a Patient with that
+// identifier, which has to be unique.
+func resolveCohortPatient(client *fhir.Client, line string) (string, error) {
+	if !strings.Contains(line, "|") {
+		return strings.TrimPrefix(line, "Patient/"), nil
+	}
+
+	req, err := client.NewSearchTypeRequest("Patient", url.Values{"identifier": []string{line}, "_elements": []string{"id"}})
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return "", fmt.Errorf("error while searching the Patient with identifier %s:\n\n%s", line, errorResponse.String())
+	}
+	bundle, err := fhir.ReadBundle(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var ids []string
+	for _, entry := range bundle.Entry {
+		var patient struct {
+			Id string `json:"id"`
+		}
+		if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode != fm.SearchEntryModeMatch {
+			continue
+		}
+		if err := json.Unmarshal(entry.Resource, &patient); err == nil && patient.Id != "" {
+			ids = append(ids, patient.Id)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("no Patient with identifier %s found", line)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("%d Patients with identifier %s found", len(ids), line)
+	}
+}
+
+// loadCohort reads the cohort file at path and resolves all its lines to
+// Patient ids. Duplicate patients are removed keeping the order of the file.
+func loadCohort(client *fhir.Client, path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines, err := readCohort(file)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the cohort file %s: %v", path, err)
+	}
+	seen := make(map[string]bool, len(lines))
+	ids := make([]string, 0, len(lines))
+	for _, line := range lines {
+		id, err := resolveCohortPatient(client, line)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// fetchGroup fetches the Group with the given id.
+func fetchGroup(client *fhir.Client, id string) (fm.Group, error) {
+	req, err := client.NewReadRequest("Group", id)
+	if err != nil {
+		return fm.Group{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fm.Group{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fm.Group{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return fm.Group{}, fmt.Errorf("error while fetching the Group with id %s:\n\n%s", id, errorResponse.String())
+	}
+	group, err := fm.UnmarshalGroup(body)
+	if err != nil {
+		return fm.Group{}, fmt.Errorf("error while reading the Group with id %s: %w", id, err)
+	}
+	return group, nil
+}
+
+// loadGroup resolves the members of the Group with the given id to Patient
+// ids client-side, so that servers without group-level operations can be
+// used. Inactive members are skipped. Members which are Groups themselves
+// are resolved recursively. Duplicate patients are removed.
+func loadGroup(client *fhir.Client, id string) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	var resolve func(id string) error
+	resolve = func(id string) error {
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+		group, err := fetchGroup(client, id)
+		if err != nil {
+			return err
+		}
+		for _, member := range group.Member {
+			if (member.Inactive != nil && *member.Inactive) || member.Entity.Reference == nil {
+				continue
+			}
+			reference := *member.Entity.Reference
+			if memberId, err := idFromLocation(reference, "Patient"); err == nil {
+				if !seen[memberId] {
+					seen[memberId] = true
+					ids = append(ids, memberId)
+				}
+			} else if groupId, err := idFromLocation(reference, "Group"); err == nil {
+				if err := resolve(groupId); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("unsupported member `%s` of Group/%s, expected a Patient or Group", reference, id)
+			}
+		}
+		return nil
+	}
+	if err := resolve(strings.TrimPrefix(id, "Group/")); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// cohortSelected returns whether a cohort was given by --cohort or --group.
+func cohortSelected() bool {
+	return cohortFile != "" || cohortGroup != ""
+}
+
+// loadCohortOrDie loads the cohort given by --cohort or --group and prints the
+// number of patients. Exits on errors.
+func loadCohortOrDie(client *fhir.Client) []string {
+	if cohortGroup != "" {
+		ids, err := loadGroup(client, cohortGroup)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Resolved %d patients of Group/%s.\n", len(ids), strings.TrimPrefix(cohortGroup, "Group/"))
+		return ids
+	}
+	ids, err := loadCohort(client, cohortFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Read cohort of %d patients from %s.\n", len(ids), cohortFile)
+	return ids
+}
+
+// forEachPatient calls fn with each of the patient ids using at most
+// concurrency goroutines at the same time. Returns after all calls finished.
+func forEachPatient(ids []string, concurrency int, fn func(id string)) {
+	util.ForEach(ids, concurrency, fn)
+}
+
+// downloadCohortResources downloads the resources of the compartments of all
+// patients. With resourceType, the compartment search of that type is used.
+// Otherwise, all resources of the compartment are downloaded using
+// Patient/$everything. The pages of all patients are sent to resChannel, which
+// is closed after all patients are downloaded.
+func downloadCohortResources(client *fhir.Client, ids []string, resourceType string, fhirSearchQuery string,
+	concurrency int, resChannel chan<- downloadBundle) {
+	defer close(resChannel)
+	forEachPatient(ids, concurrency, func(id string) {
+		path := "Patient/" + id + "/$everything"
+		if resourceType != "" {
+			path = "Patient/" + id + "/" + resourceType
+		}
+		patientChannel := make(chan downloadBundle, downloadBuffer())
+		go downloadResources(client, path, fhirSearchQuery, false, patientChannel)
+		for bundle := range patientChannel {
+			resChannel <- bundle
+		}
+	})
+}
+
+// addCohortFlags adds the --cohort, --group and --cohort-concurrency flags to
+// cmd.
+func addCohortFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&cohortFile, "cohort", "", "file with one Patient id or identifier (system|value) per line to restrict the command to")
+	cmd.Flags().StringVar(&cohortGroup, "group", "", "id of a Group whose Patient members the command is restricted to")
+	cmd.Flags().IntVar(&cohortConcurrency, "cohort-concurrency", 4, "number of patients of the cohort processed in parallel")
+	cmd.MarkFlagsMutuallyExclusive("cohort", "group")
+}
+
+// evaluateCohortMeasure evaluates the measure with the given canonical URL for
+// each of the patients separately and merges the resulting MeasureReports into
+// one summary report. Returns the first error of any patient.
+func evaluateCohortMeasure(client *fhir.Client, measureUrl string, ids []string, concurrency int) ([]byte, error) {
+	var mutex sync.Mutex
+	reports := make(map[string]fm.MeasureReport, len(ids))
+	var firstErr error
+	forEachPatient(ids, concurrency, func(id string) {
+		measureReport, err := evaluateSubjectMeasureWithRetry(client, measureUrl, "Patient/"+id)
+		var report fm.MeasureReport
+		if err == nil {
+			if report, err = fm.UnmarshalMeasureReport(measureReport); err != nil {
+				err = fmt.Errorf("error while reading the MeasureReport of Patient/%s: %w", id, err)
+			}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		reports[id] = report
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	ordered := make([]fm.MeasureReport, 0, len(ids))
+	for _, id := range ids {
+		ordered = append(ordered, reports[id])
+	}
+	merged, err := mergeMeasureReports(ordered)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
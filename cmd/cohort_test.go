@@ -0,0 +1,222 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadCohort(t *testing.T) {
+	lines, err := readCohort(strings.NewReader("# cohort\n0\n\n  1  \nhttp://example.com/mrn|4711\n"))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"0", "1", "http://example.com/mrn|4711"}, lines)
+	}
+}
+
+func newCohortServer(t *testing.T) *fhir.Client {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/Patient", r.URL.Path)
+		switch r.URL.Query().Get("identifier") {
+		case "http://example.com/mrn|4711":
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [
+              {"resource": {"resourceType": "Patient", "id": "2"}, "search": {"mode": "match"}}]}`))
+		case "http://example.com/mrn|4712":
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [
+              {"resource": {"resourceType": "Patient", "id": "3"}, "search": {"mode": "match"}},
+              {"resource": {"resourceType": "Patient", "id": "4"}, "search": {"mode": "match"}}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset"}`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	return fhir.NewClient(*baseURL, nil)
+}
+
+func TestResolveCohortPatient(t *testing.T) {
+	client := newCohortServer(t)
+
+	t.Run("id", func(t *testing.T) {
+		id, err := resolveCohortPatient(client, "0")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "0", id)
+		}
+	})
+
+	t.Run("reference", func(t *testing.T) {
+		id, err := resolveCohortPatient(client, "Patient/1")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "1", id)
+		}
+	})
+
+	t.Run("identifier", func(t *testing.T) {
+		id, err := resolveCohortPatient(client, "http://example.com/mrn|4711")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "2", id)
+		}
+	})
+
+	t.Run("ambiguous identifier", func(t *testing.T) {
+		_, err := resolveCohortPatient(client, "http://example.com/mrn|4712")
+
+		assert.ErrorContains(t, err, "2 Patients with identifier http://example.com/mrn|4712 found")
+	})
+
+	t.Run("unknown identifier", func(t *testing.T) {
+		_, err := resolveCohortPatient(client, "http://example.com/mrn|4713")
+
+		assert.ErrorContains(t, err, "no Patient with identifier http://example.com/mrn|4713 found")
+	})
+}
+
+func TestLoadCohort(t *testing.T) {
+	client := newCohortServer(t)
+	path := filepath.Join(t.TempDir(), "cohort.txt")
+	if err := os.WriteFile(path, []byte("0\nhttp://example.com/mrn|4711\nPatient/0\n2\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := loadCohort(client, path)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"0", "2", "1"}, ids)
+	}
+}
+
+func TestForEachPatient(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	var mutex sync.Mutex
+	var visited []string
+
+	forEachPatient([]string{"0", "1", "2", "3", "4", "5"}, 2, func(id string) {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		mutex.Lock()
+		visited = append(visited, id)
+		mutex.Unlock()
+		running.Add(-1)
+	})
+
+	sort.Strings(visited)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5"}, visited)
+	assert.LessOrEqual(t, maxRunning.Load(), int32(2))
+}
+
+func TestDownloadCohortResources(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Split(r.URL.Path, "/")[2]
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [
+          {"resource": {"resourceType": "Observation", "id": "` + id + `"}, "search": {"mode": "match"}}]}`))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("compartment search", func(t *testing.T) {
+		bundleChannel := make(chan downloadBundle, 2)
+		go downloadCohortResources(client, []string{"0", "1", "2"}, "Observation", "", 2, bundleChannel)
+
+		var paths []string
+		for bundle := range bundleChannel {
+			if assert.NoError(t, bundle.err) {
+				paths = append(paths, bundle.associatedRequestURL.Path)
+			}
+		}
+		sort.Strings(paths)
+		assert.Equal(t, []string{"/Patient/0/Observation", "/Patient/1/Observation", "/Patient/2/Observation"}, paths)
+	})
+
+	t.Run("everything", func(t *testing.T) {
+		bundleChannel := make(chan downloadBundle, 2)
+		go downloadCohortResources(client, []string{"0"}, "", "", 2, bundleChannel)
+
+		bundle := <-bundleChannel
+		if assert.NoError(t, bundle.err) {
+			assert.Equal(t, "/Patient/0/$everything", bundle.associatedRequestURL.Path)
+		}
+		_, ok := <-bundleChannel
+		assert.False(t, ok)
+	})
+}
+
+func TestLoadGroup(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Group/0":
+			_, _ = w.Write([]byte(`{"resourceType": "Group", "id": "0", "type": "person", "actual": true, "member": [
+              {"entity": {"reference": "Patient/0"}},
+              {"entity": {"reference": "Patient/1"}, "inactive": true},
+              {"entity": {"reference": "Group/1"}},
+              {"entity": {"reference": "Patient/2"}}]}`))
+		case "/Group/1":
+			_, _ = w.Write([]byte(`{"resourceType": "Group", "id": "1", "type": "person", "actual": true, "member": [
+              {"entity": {"reference": "Patient/3"}},
+              {"entity": {"reference": "Patient/0"}},
+              {"entity": {"reference": "Group/0"}}]}`))
+		case "/Group/2":
+			_, _ = w.Write([]byte(`{"resourceType": "Group", "id": "2", "type": "device", "actual": true, "member": [
+              {"entity": {"reference": "Device/0"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("nested groups", func(t *testing.T) {
+		ids, err := loadGroup(client, "Group/0")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"0", "3", "2"}, ids)
+		}
+	})
+
+	t.Run("unsupported member", func(t *testing.T) {
+		_, err := loadGroup(client, "2")
+
+		assert.ErrorContains(t, err, "unsupported member `Device/0` of Group/2")
+	})
+
+	t.Run("missing group", func(t *testing.T) {
+		_, err := loadGroup(client, "3")
+
+		assert.ErrorContains(t, err, "error while fetching the Group with id 3")
+	})
+}
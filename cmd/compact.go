@@ -15,12 +15,11 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
 	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/spf13/cobra"
-	"io"
 	"net/http"
 	"os"
 	"slices"
@@ -49,73 +48,255 @@ var indexColumnFamilies = []string{
 }
 var otherColumnFamilies = []string{"default"}
 
-var compactCmd = &cobra.Command{
-	Use:   "compact",
-	Short: "Compact a Database Column Family",
-	Long:  "Initiates compaction of a column family of a RocksDB database.",
-	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		switch len(args) {
-		case 0:
-			return databases, cobra.ShellCompDirectiveNoFileComp
-		case 1:
-			switch args[0] {
-			case "index":
-				return indexColumnFamilies, cobra.ShellCompDirectiveNoFileComp
-			default:
-				return otherColumnFamilies, cobra.ShellCompDirectiveNoFileComp
-			}
-		default:
-			return []string{}, cobra.ShellCompDirectiveNoFileComp
-		}
-	},
-	Args: func(cmd *cobra.Command, args []string) error {
-		if len(args) != 2 {
-			return fmt.Errorf("requires exactly 2 arguments: database and column-family")
-		}
-		switch args[0] {
-		case "index":
-			if !slices.Contains(indexColumnFamilies, args[1]) {
-				return fmt.Errorf("invalid column family. Must be one of: %s", strings.Join(indexColumnFamilies, ", "))
-			}
-		default:
-			if args[1] != "default" {
-				return fmt.Errorf("invalid column family. Must be: default")
-			}
-		}
-		return nil
-	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		err := createClient()
-		if err != nil {
-			return err
-		}
+var compactAll bool
 
-		req, err := client.NewPostSystemOperationRequest("compact", true, createParameters(args[0], args[1]))
-		if err != nil {
-			return err
+// compactCodes are the databases and column families accepted by the
+// $compact operation. Only the index database has column families other than
+// the default one.
+type compactCodes struct {
+	databases           []string
+	indexColumnFamilies []string
+}
+
+// defaultCompactCodes are the databases and column families known at build
+// time. They are used if the server doesn't report them.
+var defaultCompactCodes = compactCodes{databases: databases, indexColumnFamilies: indexColumnFamilies}
+
+// fetchCompactCodes fetches the databases and column families accepted by the
+// server from the bindings of the parameters of the $compact
+// OperationDefinition. Parameters without binding keep the default codes.
+func fetchCompactCodes(client *fhir.Client) (compactCodes, error) {
+	operationDefinition, err := fetchOperationDefinition(client, "compact")
+	if err != nil {
+		return compactCodes{}, err
+	}
+	codes := defaultCompactCodes
+	serverDatabases, err := fetchParameterCodes(client, operationDefinition, "database")
+	if err != nil {
+		return compactCodes{}, err
+	}
+	if len(serverDatabases) > 0 {
+		codes.databases = serverDatabases
+	}
+	serverColumnFamilies, err := fetchParameterCodes(client, operationDefinition, "column-family")
+	if err != nil {
+		return compactCodes{}, err
+	}
+	if len(serverColumnFamilies) > 0 {
+		codes.indexColumnFamilies = slices.DeleteFunc(serverColumnFamilies, func(columnFamily string) bool {
+			return slices.Contains(otherColumnFamilies, columnFamily)
+		})
+	}
+	return codes, nil
+}
+
+// resolveCompactCodes returns the codes reported by the server or the default
+// codes if the server doesn't report them. With quiet, no warning is printed,
+// which is needed during shell completion.
+func resolveCompactCodes(client *fhir.Client, quiet bool) compactCodes {
+	codes, err := fetchCompactCodes(client)
+	if err != nil {
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Using the built-in databases and column families because the server doesn't report them: %v\n", err)
 		}
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
+		return defaultCompactCodes
+	}
+	return codes
+}
+
+// columnFamilies returns the column families of the given database.
+func (c compactCodes) columnFamilies(database string) []string {
+	if database == "index" {
+		return c.indexColumnFamilies
+	}
+	return otherColumnFamilies
+}
+
+// allColumnFamilies returns all pairs of database and column family.
+func (c compactCodes) allColumnFamilies() [][2]string {
+	var targets [][2]string
+	for _, database := range c.databases {
+		for _, columnFamily := range c.columnFamilies(database) {
+			targets = append(targets, [2]string{database, columnFamily})
 		}
-		defer resp.Body.Close()
+	}
+	return targets
+}
+
+// validate returns an error if database or columnFamily isn't known.
+func (c compactCodes) validate(database string, columnFamily string) error {
+	if !slices.Contains(c.databases, database) {
+		return fmt.Errorf("invalid database. Must be one of: %s", strings.Join(c.databases, ", "))
+	}
+	if columnFamilies := c.columnFamilies(database); !slices.Contains(columnFamilies, columnFamily) {
+		return fmt.Errorf("invalid column family. Must be one of: %s", strings.Join(columnFamilies, ", "))
+	}
+	return nil
+}
 
-		if resp.StatusCode == 202 {
-			response, err := compactCmdPollAsyncStatus(client, resp.Header.Get("Content-Location"), 100*time.Millisecond)
+// newCompactCmd creates the compact command. It is used both as subcommand of
+// the db command and as deprecated top-level command.
+func newCompactCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compact [database] [column-family]",
+		Short: "Compact a Database Column Family",
+		Long: `Initiates compaction of a column family of a RocksDB database.
+
+With --all, all column families of all databases, or of the given database
+only, are compacted one after another. Afterwards, the duration of each
+compaction is printed.
+
+The databases and column families are taken from the $compact
+OperationDefinition of the server if available. Otherwise, the built-in
+list is used.`,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if compactAll && len(args) > 0 {
+				return []string{}, cobra.ShellCompDirectiveNoFileComp
+			}
+			codes := defaultCompactCodes
+			if server != "" && createClient() == nil {
+				codes = resolveCompactCodes(client, true)
+			}
+			switch len(args) {
+			case 0:
+				return codes.databases, cobra.ShellCompDirectiveNoFileComp
+			case 1:
+				return codes.columnFamilies(args[0]), cobra.ShellCompDirectiveNoFileComp
+			default:
+				return []string{}, cobra.ShellCompDirectiveNoFileComp
+			}
+		},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if compactAll {
+				if len(args) > 1 {
+					return fmt.Errorf("accepts at most one database argument with --all")
+				}
+				return nil
+			}
+			if len(args) != 2 {
+				return fmt.Errorf("requires exactly 2 arguments: database and column-family")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := createClient()
 			if err != nil {
 				return err
 			}
-			if response.Status == "200" {
-				fmt.Printf("Successfully compacted column family `%s` in database `%s`.\n", args[1], args[0])
-			} else {
-				fmt.Println("Error while compacting.")
+
+			codes := resolveCompactCodes(client, false)
+			if !compactAll {
+				if err := codes.validate(args[0], args[1]); err != nil {
+					return err
+				}
+				_, err := compact(client, args[0], args[1])
+				return err
+			}
+
+			targets := codes.allColumnFamilies()
+			if len(args) == 1 {
+				if !slices.Contains(codes.databases, args[0]) {
+					return fmt.Errorf("invalid database. Must be one of: %s", strings.Join(codes.databases, ", "))
+				}
+				targets = slices.DeleteFunc(targets, func(target [2]string) bool { return target[0] != args[0] })
+			}
+			results := make([]compactResult, 0, len(targets))
+			for i, target := range targets {
+				fmt.Printf("[%d/%d] Compacting column family `%s` in database `%s` (%.0f%% done)...\n", i+1,
+					len(targets), target[1], target[0], float64(i)*100/float64(len(targets)))
+				start := time.Now()
+				ok, err := compact(client, target[0], target[1])
+				if err != nil {
+					return err
+				}
+				results = append(results, compactResult{database: target[0], columnFamily: target[1],
+					duration: time.Since(start), ok: ok})
 			}
+			fmt.Println()
+			fmt.Print(fmtCompactResults(unitFormat(), results))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	cmd.Flags().BoolVar(&compactAll, "all", false, "compact all column families of all databases or of the given database")
+	cmd.Flags().DurationVar(&pollInterval, "poll-interval", 100*time.Millisecond, "initial wait between polls of the async status endpoint")
+	cmd.Flags().DurationVar(&pollTimeout, "poll-timeout", 0, "abort polling the async status endpoint after this duration (0 means no timeout)")
+
+	_ = cmd.MarkFlagRequired("server")
+	return cmd
+}
+
+// compactResult is the outcome of the compaction of one column family.
+type compactResult struct {
+	database     string
+	columnFamily string
+	duration     time.Duration
+	ok           bool
+}
+
+// fmtCompactResults formats the duration and outcome of every compaction
+// followed by the total duration.
+func fmtCompactResults(units util.UnitFormat, results []compactResult) string {
+	maxLen := len("Total")
+	var total time.Duration
+	for _, result := range results {
+		maxLen = maxInt(maxLen, len(result.database)+len(result.columnFamily)+3)
+		total += result.duration
+	}
+	format := "%-" + fmt.Sprintf("%d", maxLen) + "s : %12s  %s\n"
+
+	builder := strings.Builder{}
+	for _, result := range results {
+		status := "ok"
+		if !result.ok {
+			status = "failed"
+		}
+		builder.WriteString(fmt.Sprintf(format, result.database+" / "+result.columnFamily,
+			units.Duration(result.duration), status))
+	}
+	builder.WriteString(strings.Repeat("-", maxLen+15) + "\n")
+	builder.WriteString(fmt.Sprintf("%-"+fmt.Sprintf("%d", maxLen)+"s : %12s\n", "Total", units.Duration(total)))
+	return builder.String()
+}
+
+// compact compacts the given column family of the given database and prints
+// the outcome. Returns whether the compaction was successful.
+func compact(client *fhir.Client, database string, columnFamily string) (bool, error) {
+	req, err := client.NewPostSystemOperationRequest("compact", true, createParameters(database, columnFamily))
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 202 {
+		entries, err := compactCmdPollAsyncStatus(client, resp.Header.Get("Content-Location"),
+			retryAfter(resp, pollInterval), newPollTimeout())
+		if err != nil {
+			return false, err
+		}
+		entryErrors := asyncResponseEntryErrors(entries)
+		if len(entries) > 0 && len(entryErrors) == 0 {
+			fmt.Printf("Successfully compacted column family `%s` in database `%s`.\n", columnFamily, database)
+			return true, nil
 		} else {
 			fmt.Println("Error while compacting.")
+			for i := range entries {
+				if errorResponse, ok := entryErrors[i]; ok {
+					fmt.Printf("Entry: %d\n", i)
+					fmt.Printf("%s", util.Indent(4, errorResponse.String()))
+				}
+			}
 		}
+	} else {
+		fmt.Println("Error while compacting.")
+	}
 
-		return nil
-	},
+	return false, nil
 }
 
 func createParameters(database string, columnFamily string) fm.Parameters {
@@ -133,8 +314,18 @@ func createParameters(database string, columnFamily string) fm.Parameters {
 	}
 }
 
-func compactCmdPollAsyncStatus(client *fhir.Client, location string, wait time.Duration) (*fm.BundleEntryResponse, error) {
+func compactCmdPollAsyncStatus(client *fhir.Client, location string, wait time.Duration,
+	timeout <-chan time.Time) ([]fm.BundleEntry, error) {
 	select {
+	case <-timeout:
+		fmt.Fprintf(os.Stderr, "Poll timeout of %s exceeded. Cancel async request...\n", pollTimeout)
+
+		if err := cancelAsyncRequest(client, location); err != nil {
+			return nil, fmt.Errorf("poll timeout of %s exceeded, failed to cancel the async request at status endpoint %s: %w",
+				pollTimeout, location, err)
+		}
+		return nil, fmt.Errorf("poll timeout of %s exceeded, cancelled the async request at status endpoint %s",
+			pollTimeout, location)
 	case <-time.After(wait):
 		fmt.Fprintf(os.Stderr, "Poll status endpoint at %s...\n", location)
 		req, err := http.NewRequest("GET", location, nil)
@@ -149,52 +340,25 @@ func compactCmdPollAsyncStatus(client *fhir.Client, location string, wait time.D
 		defer resp.Body.Close()
 
 		if resp.StatusCode == 200 {
-			batchResponse, err := fhir.ReadBundle(resp.Body)
-			if err != nil {
-				return nil, fmt.Errorf("error while reading the async response Bundle: %w", err)
-			}
-
-			if len(batchResponse.Entry) != 1 {
-				return nil, fmt.Errorf("expected one entry in async response Bundle but was %d entries", len(batchResponse.Entry))
-			}
-
-			return batchResponse.Entry[0].Response, nil
+			return readAsyncResponseEntries(resp.Body)
 		} else if resp.StatusCode == 202 {
-			// exponential wait up to 10 seconds
+			// exponential wait up to 10 seconds unless the server tells us otherwise
 			if wait < 10*time.Second {
 				wait *= 2
 			}
-			return compactCmdPollAsyncStatus(client, location, wait)
+			return compactCmdPollAsyncStatus(client, location, retryAfter(resp, wait), timeout)
 		} else {
 			return compactCmdHandleErrorResponse(resp)
 		}
 	}
 }
 
-func compactCmdHandleErrorResponse(resp *http.Response) (*fm.BundleEntryResponse, error) {
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/fhir+json") {
-		operationOutcome := fm.OperationOutcome{}
-
-		err = json.Unmarshal(body, &operationOutcome)
-		if err == nil {
-			err = &operationOutcomeError{outcome: &operationOutcome}
-		}
-
-		return nil, fmt.Errorf("Error while compacting a column family:\n\n%w", err)
-	} else {
-		return nil, fmt.Errorf("Error while compacting a column family:\n\n%s", body)
-	}
+func compactCmdHandleErrorResponse(resp *http.Response) ([]fm.BundleEntry, error) {
+	return nil, fmt.Errorf("Error while compacting a column family:\n\n%w", fhir.ReadHTTPStatusError(resp))
 }
 
 func init() {
-	rootCmd.AddCommand(compactCmd)
-
-	compactCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
-
-	_ = compactCmd.MarkFlagRequired("server")
+	deprecatedCompactCmd := newCompactCmd()
+	deprecatedCompactCmd.Deprecated = "use `db compact` instead"
+	rootCmd.AddCommand(deprecatedCompactCmd)
 }
@@ -15,8 +15,17 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 )
 
 func TestCreateParameters(t *testing.T) {
@@ -28,3 +37,120 @@ func TestCreateParameters(t *testing.T) {
 	assert.Equal(t, "column-family", parameters.Parameter[1].Name)
 	assert.Equal(t, "resource-as-of-index", *parameters.Parameter[1].ValueCode)
 }
+
+func TestCompactCmdPollAsyncStatus(t *testing.T) {
+	t.Run("async response with multiple entries", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response := fm.Bundle{
+				Type: fm.BundleTypeBatchResponse,
+				Entry: []fm.BundleEntry{
+					{Response: &fm.BundleEntryResponse{Status: "200"}},
+					{Response: &fm.BundleEntryResponse{Status: "200 OK"}},
+				},
+			}
+
+			w.WriteHeader(http.StatusOK)
+			encoder := json.NewEncoder(w)
+			if err := encoder.Encode(response); err != nil {
+				t.Error(err)
+			}
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		entries, err := compactCmdPollAsyncStatus(client, server.URL, 0, nil)
+		if err != nil {
+			t.Fatalf("error while polling the async status: %v", err)
+		}
+
+		assert.Equal(t, 2, len(entries))
+		assert.Empty(t, asyncResponseEntryErrors(entries))
+	})
+
+	t.Run("poll timeout cancels the async request", func(t *testing.T) {
+		var methods []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+		timeout := make(chan time.Time, 1)
+		timeout <- time.Now()
+
+		_, err := compactCmdPollAsyncStatus(client, server.URL, time.Hour, timeout)
+
+		assert.EqualError(t, err, fmt.Sprintf("poll timeout of %s exceeded, cancelled the async request at status endpoint %s",
+			pollTimeout, server.URL))
+		assert.Equal(t, []string{"DELETE"}, methods)
+	})
+
+	t.Run("poll timeout with failing cancel", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/fhir+json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "not-found"}]}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+		timeout := make(chan time.Time, 1)
+		timeout <- time.Now()
+
+		_, err := compactCmdPollAsyncStatus(client, server.URL, time.Hour, timeout)
+
+		assert.ErrorContains(t, err, "failed to cancel the async request at status endpoint "+server.URL)
+	})
+}
+
+func TestAllColumnFamilies(t *testing.T) {
+	targets := defaultCompactCodes.allColumnFamilies()
+
+	assert.Equal(t, len(indexColumnFamilies)+2, len(targets))
+	assert.Equal(t, [2]string{"index", "search-param-value-index"}, targets[0])
+	assert.Equal(t, [2]string{"transaction", "default"}, targets[len(indexColumnFamilies)])
+	assert.Equal(t, [2]string{"resource", "default"}, targets[len(targets)-1])
+}
+
+func TestFetchCompactCodes(t *testing.T) {
+	ts := newOperationServer(t, "default", "search-param-value-index", "new-index")
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	codes, err := fetchCompactCodes(fhir.NewClient(*baseURL, nil))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"index", "transaction", "resource"}, codes.databases)
+		assert.Equal(t, []string{"search-param-value-index", "new-index"}, codes.columnFamilies("index"))
+		assert.Equal(t, []string{"default"}, codes.columnFamilies("resource"))
+		assert.NoError(t, codes.validate("index", "new-index"))
+	}
+}
+
+func TestCompactCodesValidate(t *testing.T) {
+	assert.NoError(t, defaultCompactCodes.validate("index", "resource-as-of-index"))
+	assert.NoError(t, defaultCompactCodes.validate("transaction", "default"))
+	assert.EqualError(t, defaultCompactCodes.validate("foo", "default"),
+		"invalid database. Must be one of: index, transaction, resource")
+	assert.EqualError(t, defaultCompactCodes.validate("resource", "resource-as-of-index"),
+		"invalid column family. Must be one of: default")
+}
+
+func TestFmtCompactResults(t *testing.T) {
+	results := []compactResult{
+		{database: "index", columnFamily: "search-param-value-index", duration: 12 * time.Second, ok: true},
+		{database: "resource", columnFamily: "default", duration: 1500 * time.Millisecond},
+	}
+
+	assert.Equal(t, ""+
+		"index / search-param-value-index :       12.000  ok\n"+
+		"resource / default               :        1.500  failed\n"+
+		"-----------------------------------------------\n"+
+		"Total                            :       13.500\n",
+		fmtCompactResults(util.UnitFormat{Raw: true}, results))
+}
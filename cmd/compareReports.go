@@ -0,0 +1,201 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var onlyChanges bool
+
+// reportCountKey identifies a count of a MeasureReport.
+type reportCountKey struct {
+	group      int
+	stratifier string
+	stratum    string
+	population string
+}
+
+// reportCountDiff is a count of two MeasureReports. The count is nil if it
+// doesn't exist in the report.
+type reportCountDiff struct {
+	reportCountKey
+	a *int
+	b *int
+}
+
+func (d reportCountDiff) differs() bool {
+	return d.a == nil || d.b == nil || *d.a != *d.b
+}
+
+// reportCounts returns the counts of report by key in the order of
+// measureReportRows.
+func reportCounts(report fm.MeasureReport) ([]reportCountKey, map[reportCountKey]int) {
+	var keys []reportCountKey
+	counts := make(map[reportCountKey]int)
+	for _, row := range measureReportRows(report) {
+		key := reportCountKey{row.group, row.stratifier, row.stratum, row.population}
+		if _, ok := counts[key]; !ok {
+			keys = append(keys, key)
+		}
+		counts[key] += row.count
+	}
+	return keys, counts
+}
+
+// compareMeasureReports compares the counts of the groups and strata of a and
+// b. The counts of a come first in their order, followed by the counts only
+// found in b.
+func compareMeasureReports(a fm.MeasureReport, b fm.MeasureReport) []reportCountDiff {
+	keysA, countsA := reportCounts(a)
+	keysB, countsB := reportCounts(b)
+
+	diffs := make([]reportCountDiff, 0, len(keysA))
+	for _, key := range keysA {
+		diff := reportCountDiff{reportCountKey: key, a: intPtr(countsA[key])}
+		if count, ok := countsB[key]; ok {
+			diff.b = intPtr(count)
+		}
+		diffs = append(diffs, diff)
+	}
+	for _, key := range keysB {
+		if _, ok := countsA[key]; !ok {
+			diffs = append(diffs, reportCountDiff{reportCountKey: key, b: intPtr(countsB[key])})
+		}
+	}
+	return diffs
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// fmtCount formats count or a dash if the count doesn't exist.
+func fmtCount(count *int) string {
+	if count == nil {
+		return "-"
+	}
+	return strconv.Itoa(*count)
+}
+
+// fmtCountDelta formats the difference between the counts a and b as signed
+// number and as percentage of a. The percentage is a dash if a is zero or
+// one of the counts doesn't exist.
+func fmtCountDelta(a *int, b *int) (string, string) {
+	if a == nil || b == nil {
+		return "-", "-"
+	}
+	if *a == *b {
+		return "0", "0.0%"
+	}
+	delta := fmt.Sprintf("%+d", *b-*a)
+	if *a == 0 {
+		return delta, "-"
+	}
+	return delta, fmt.Sprintf("%+.1f%%", float64(*b-*a)/float64(*a)*100)
+}
+
+// renderReportComparison renders diffs as table. With onlyChanges, only the
+// counts which differ are included. Returns the number of counts which differ.
+func renderReportComparison(diffs []reportCountDiff, onlyChanges bool) (string, int, error) {
+	builder := strings.Builder{}
+	w := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tSTRATIFIER\tSTRATUM\tPOPULATION\tA\tB\tDELTA\tCHANGE")
+	changed := 0
+	for _, diff := range diffs {
+		if diff.differs() {
+			changed++
+		} else if onlyChanges {
+			continue
+		}
+		delta, change := fmtCountDelta(diff.a, diff.b)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", diff.group, diff.stratifier, diff.stratum, diff.population,
+			fmtCount(diff.a), fmtCount(diff.b), delta, change)
+	}
+	if err := w.Flush(); err != nil {
+		return "", 0, err
+	}
+	builder.WriteString(fmt.Sprintf("\n%d of %d counts differ\n", changed, len(diffs)))
+	return builder.String(), changed, nil
+}
+
+// readMeasureReportFile reads the MeasureReport in JSON format from the file
+// at path.
+func readMeasureReportFile(path string) (fm.MeasureReport, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fm.MeasureReport{}, err
+	}
+	report, err := fm.UnmarshalMeasureReport(content)
+	if err != nil {
+		return fm.MeasureReport{}, fmt.Errorf("error while reading the MeasureReport %s: %w", path, err)
+	}
+	return report, nil
+}
+
+var compareReportsCmd = &cobra.Command{
+	Use:   "compare-reports <a.json> <b.json>",
+	Short: "Compare the counts of two MeasureReports",
+	Long: `Compares the counts of two MeasureReports in JSON format, like the ones of
+evaluate-measure or fetch-report, group by group and stratum by stratum. For
+each count, the counts of both reports, their difference and the change in
+percent of the count of the first report are printed. Counts only found in
+one of the reports are printed with a dash for the other report. With
+--only-changes, counts which are equal are left out.
+
+This is useful to verify that a server upgrade or a re-import of the data
+didn't change the results of a measure.
+
+Like diff, the command exits with status 1 if the counts differ.
+
+Example:
+  blazectl compare-reports before.json after.json`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := readMeasureReportFile(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := readMeasureReportFile(args[1])
+		if err != nil {
+			return err
+		}
+
+		if a.Measure != b.Measure {
+			fmt.Printf("The reports belong to different measures: %s and %s\n\n", a.Measure, b.Measure)
+		}
+		rendered, changed, err := renderReportComparison(compareMeasureReports(a, b), onlyChanges)
+		if err != nil {
+			return err
+		}
+		fmt.Print(rendered)
+		if changed > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compareReportsCmd)
+
+	compareReportsCmd.Flags().BoolVar(&onlyChanges, "only-changes", false, "print only the counts which differ")
+}
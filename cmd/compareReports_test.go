@@ -0,0 +1,143 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMeasureReportAfter = `{
+  "resourceType": "MeasureReport",
+  "status": "complete",
+  "type": "summary",
+  "measure": "urn:uuid:0",
+  "group": [{
+    "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 4}],
+    "stratifier": [{
+      "code": [{"text": "gender"}],
+      "stratum": [
+        {"value": {"text": "female"}, "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 2}]},
+        {"value": {"text": "other"}, "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 2}]}
+      ]
+    }]
+  }]
+}`
+
+func TestCompareMeasureReports(t *testing.T) {
+	a, err := fm.UnmarshalMeasureReport([]byte(testMeasureReport))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := fm.UnmarshalMeasureReport([]byte(testMeasureReportAfter))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	diffs := compareMeasureReports(a, b)
+
+	assert.Equal(t, []reportCountDiff{
+		{reportCountKey{1, "", "", "initial-population"}, intPtr(3), intPtr(4)},
+		{reportCountKey{1, "gender", "female", "initial-population"}, intPtr(2), intPtr(2)},
+		{reportCountKey{1, "gender", "male", "initial-population"}, intPtr(1), nil},
+		{reportCountKey{1, "gender", "other", "initial-population"}, nil, intPtr(2)},
+	}, diffs)
+}
+
+func TestFmtCountDelta(t *testing.T) {
+	tests := []struct {
+		a, b          *int
+		delta, change string
+	}{
+		{intPtr(3), intPtr(4), "+1", "+33.3%"},
+		{intPtr(4), intPtr(3), "-1", "-25.0%"},
+		{intPtr(2), intPtr(2), "0", "0.0%"},
+		{intPtr(0), intPtr(0), "0", "0.0%"},
+		{intPtr(0), intPtr(2), "+2", "-"},
+		{intPtr(1), nil, "-", "-"},
+		{nil, intPtr(1), "-", "-"},
+	}
+	for _, test := range tests {
+		delta, change := fmtCountDelta(test.a, test.b)
+		assert.Equal(t, test.delta, delta)
+		assert.Equal(t, test.change, change)
+	}
+}
+
+func TestRenderReportComparison(t *testing.T) {
+	diffs := []reportCountDiff{
+		{reportCountKey{1, "", "", "initial-population"}, intPtr(3), intPtr(4)},
+		{reportCountKey{1, "gender", "female", "initial-population"}, intPtr(2), intPtr(2)},
+		{reportCountKey{1, "gender", "male", "initial-population"}, intPtr(1), nil},
+	}
+
+	t.Run("all counts", func(t *testing.T) {
+		rendered, changed, err := renderReportComparison(diffs, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		assert.Equal(t, 2, changed)
+		assert.Equal(t, `GROUP  STRATIFIER  STRATUM  POPULATION          A  B  DELTA  CHANGE
+1                           initial-population  3  4  +1     +33.3%
+1      gender      female   initial-population  2  2  0      0.0%
+1      gender      male     initial-population  1  -  -      -
+
+2 of 3 counts differ
+`, rendered)
+	})
+
+	t.Run("only changes", func(t *testing.T) {
+		rendered, changed, err := renderReportComparison(diffs, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		assert.Equal(t, 2, changed)
+		assert.NotContains(t, rendered, "female")
+		assert.True(t, strings.HasSuffix(rendered, "2 of 3 counts differ\n"))
+	})
+}
+
+func TestReadMeasureReportFile(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "report.json")
+		if err := os.WriteFile(path, []byte(testMeasureReport), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		report, err := readMeasureReportFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		assert.Equal(t, "urn:uuid:0", report.Measure)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "report.json")
+		if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err := readMeasureReportFile(path)
+
+		assert.ErrorContains(t, err, "error while reading the MeasureReport "+path)
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var onConflict string
+
+// onConflictValues are the strategies for bundles rejected with a conflict
+// which can be chosen by the --on-conflict flag.
+var onConflictValues = []string{"fail", "skip", "overwrite"}
+
+func checkOnConflict(strategy string) error {
+	for _, value := range onConflictValues {
+		if strategy == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --on-conflict value `%s`, expected one of %s", strategy, strings.Join(onConflictValues, ", "))
+}
+
+// isConflict returns whether statusCode signals a conflict with the current
+// state of the server, like an existing resource or an outdated version.
+func isConflict(statusCode int) bool {
+	return statusCode == http.StatusConflict || statusCode == http.StatusPreconditionFailed
+}
+
+// currentVersion returns the ETag of the current version of the resource with
+// the given type and id. Returns an empty ETag if the resource doesn't exist.
+func currentVersion(client *fhir.Client, resourceType string, id string) (string, error) {
+	req, err := client.NewReadRequest(resourceType, id)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			return etag, nil
+		}
+		var resource struct {
+			Meta struct {
+				VersionId string `json:"versionId"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal(body, &resource); err != nil || resource.Meta.VersionId == "" {
+			return "", fmt.Errorf("the current version of %s/%s is unknown", resourceType, id)
+		}
+		return fmt.Sprintf("W/\"%s\"", resource.Meta.VersionId), nil
+	case http.StatusNotFound, http.StatusGone:
+		return "", nil
+	default:
+		errorResponse := util.NewErrorResponse(resp, body)
+		return "", fmt.Errorf("error while reading the current version of %s/%s:\n\n%s", resourceType, id,
+			errorResponse.String())
+	}
+}
+
+// overwritingBundle rewrites the requests of all entries of the bundle in
+// content whose resource has an id into updates of that resource. Updates of
+// existing resources are conditioned on their current version, so that
+// concurrent changes still lead to a conflict. Entries of resources without
+// id are kept as they are. Returns the rewritten bundle together with the
+// number of rewritten entries.
+func overwritingBundle(client *fhir.Client, content []byte) ([]byte, int, error) {
+	var bundle map[string]json.RawMessage
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return nil, 0, err
+	}
+	var entries []map[string]json.RawMessage
+	if rawEntries, ok := bundle["entry"]; ok {
+		if err := json.Unmarshal(rawEntries, &entries); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var rewritten int
+	for _, entry := range entries {
+		var resource struct {
+			ResourceType string `json:"resourceType"`
+			Id           string `json:"id"`
+		}
+		if err := json.Unmarshal(entry["resource"], &resource); err != nil || resource.ResourceType == "" || resource.Id == "" {
+			continue
+		}
+		etag, err := currentVersion(client, resource.ResourceType, resource.Id)
+		if err != nil {
+			return nil, 0, err
+		}
+		request := map[string]string{"method": "PUT", "url": resource.ResourceType + "/" + resource.Id}
+		if etag != "" {
+			request["ifMatch"] = etag
+		}
+		if entry["request"], err = json.Marshal(request); err != nil {
+			return nil, 0, err
+		}
+		rewritten++
+	}
+
+	rawEntries, err := json.Marshal(entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	bundle["entry"] = rawEntries
+	overwriting, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, 0, err
+	}
+	return overwriting, rewritten, nil
+}
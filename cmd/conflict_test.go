@@ -0,0 +1,206 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckOnConflict(t *testing.T) {
+	assert.NoError(t, checkOnConflict("fail"))
+	assert.NoError(t, checkOnConflict("skip"))
+	assert.NoError(t, checkOnConflict("overwrite"))
+	assert.EqualError(t, checkOnConflict("merge"), "invalid --on-conflict value `merge`, expected one of fail, skip, overwrite")
+}
+
+// newConflictServer returns a server holding Patient/0 in version 2 and
+// Patient/1 in version 3 without ETag. Transactions are rejected with status
+// 409 unless all their entries are updates.
+func newConflictServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/Patient/0":
+			w.Header().Set("ETag", `W/"2"`)
+			_, _ = w.Write([]byte(`{"resourceType": "Patient", "id": "0", "meta": {"versionId": "2"}}`))
+		case r.Method == "GET" && r.URL.Path == "/Patient/1":
+			_, _ = w.Write([]byte(`{"resourceType": "Patient", "id": "1", "meta": {"versionId": "3"}}`))
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "POST":
+			var bundle struct {
+				Entry []struct {
+					Request struct {
+						Method string `json:"method"`
+					} `json:"request"`
+				} `json:"entry"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, entry := range bundle.Entry {
+				if entry.Request.Method != "PUT" {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+			}
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "transaction-response", "entry": [
+  {"response": {"status": "200"}}, {"response": {"status": "200"}}, {"response": {"status": "201"}}
+]}`))
+		}
+	}))
+}
+
+func TestCurrentVersion(t *testing.T) {
+	server := newConflictServer()
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("ETag", func(t *testing.T) {
+		etag, err := currentVersion(client, "Patient", "0")
+		if assert.NoError(t, err) {
+			assert.Equal(t, `W/"2"`, etag)
+		}
+	})
+
+	t.Run("VersionId", func(t *testing.T) {
+		etag, err := currentVersion(client, "Patient", "1")
+		if assert.NoError(t, err) {
+			assert.Equal(t, `W/"3"`, etag)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		etag, err := currentVersion(client, "Patient", "2")
+		if assert.NoError(t, err) {
+			assert.Empty(t, etag)
+		}
+	})
+}
+
+const conflictingBundle = `{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "Patient", "id": "0"}, "request": {"method": "POST", "url": "Patient"}},
+  {"resource": {"resourceType": "Patient", "id": "1"}, "request": {"method": "PUT", "url": "Patient/1", "ifMatch": "W/\"1\""}},
+  {"resource": {"resourceType": "Patient", "id": "2"}, "request": {"method": "POST", "url": "Patient", "ifNoneExist": "identifier=a|2"}},
+  {"resource": {"resourceType": "Observation"}, "request": {"method": "POST", "url": "Observation"}}
+]}`
+
+func TestOverwritingBundle(t *testing.T) {
+	server := newConflictServer()
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	overwriting, rewritten, err := overwritingBundle(client, []byte(conflictingBundle))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, rewritten)
+		assert.JSONEq(t, `{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "Patient", "id": "0"}, "request": {"method": "PUT", "url": "Patient/0", "ifMatch": "W/\"2\""}},
+  {"resource": {"resourceType": "Patient", "id": "1"}, "request": {"method": "PUT", "url": "Patient/1", "ifMatch": "W/\"3\""}},
+  {"resource": {"resourceType": "Patient", "id": "2"}, "request": {"method": "PUT", "url": "Patient/2"}},
+  {"resource": {"resourceType": "Observation"}, "request": {"method": "POST", "url": "Observation"}}
+]}`, string(overwriting))
+	}
+}
+
+func TestUploadBundleConflict(t *testing.T) {
+	server := newConflictServer()
+	defer server.Close()
+
+	dir := t.TempDir()
+	bundlePath := filepath.Join(dir, "bundle.json")
+	content := []byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "Patient", "id": "0"}, "request": {"method": "POST", "url": "Patient"}},
+  {"resource": {"resourceType": "Patient", "id": "1"}, "request": {"method": "POST", "url": "Patient"}},
+  {"resource": {"resourceType": "Patient", "id": "2"}, "request": {"method": "POST", "url": "Patient"}}
+]}`)
+	if err := os.WriteFile(bundlePath, content, 0644); err != nil {
+		t.Fatal("can't create a temp json file")
+	}
+	bundleId := &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: int64(len(content))}
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	upload := func(t *testing.T, strategy string) uploadInfo {
+		onConflict = strategy
+		defer func() { onConflict = "fail" }()
+
+		info, err := uploadBundle(context.Background(), client, bundleId)
+		if err != nil {
+			t.Fatalf("error while uploading the bundle: %v", err)
+		}
+		return info
+	}
+
+	t.Run("Fail", func(t *testing.T) {
+		info := upload(t, "fail")
+
+		assert.Equal(t, 409, info.statusCode)
+		assert.Empty(t, info.conflict)
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		info := upload(t, "skip")
+
+		assert.Equal(t, 409, info.statusCode)
+		assert.Equal(t, "skip", info.conflict)
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		info := upload(t, "overwrite")
+
+		assert.Equal(t, 200, info.statusCode)
+		assert.Equal(t, "overwrite", info.conflict)
+		assert.Equal(t, map[int]int{200: 2, 201: 1}, info.entryStatusCodes)
+	})
+}
+
+func TestAggregateUploadResultsConflicts(t *testing.T) {
+	uploadResultCh := make(chan bundleUploadResult)
+	aggregatedUploadResultsCh := make(chan aggregatedUploadResults)
+	go aggregateUploadResults(uploadResultCh, aggregatedUploadResultsCh, noopProgress{}, nil)
+
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "skipped.json"},
+		uploadInfo: uploadInfo{statusCode: 409, conflict: "skip"}}
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "overwritten.json"},
+		uploadInfo: uploadInfo{statusCode: 200, conflict: "overwrite"}}
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "failed.json"},
+		uploadInfo: uploadInfo{statusCode: 412}}
+	close(uploadResultCh)
+
+	results := <-aggregatedUploadResultsCh
+	assert.Equal(t, 1, results.skipped)
+	assert.Equal(t, 1, results.overwritten)
+	assert.Len(t, results.errorResponses, 1)
+	assert.Contains(t, results.errorResponses, bundleIdentifier{filename: "failed.json"})
+	assert.Contains(t, fmtUploadReport(util.UnitFormat{Raw: true}, results, time.Second),
+		"Status Codes     [code:count]                          200:1, 412:1, 409 (skipped):1\n")
+}
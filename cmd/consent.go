@@ -0,0 +1,167 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+)
+
+var consentFilter bool
+
+// consentPolicy holds the active Consent resources of all patients by
+// patient id.
+type consentPolicy map[string][]fm.Consent
+
+// provisionPermits returns whether provision permits sharing resources of the
+// given type. Nested provisions whose class matches the resource type, or
+// which have no class, override the decision of their parent. A provision
+// without type inherits the decision of its parent.
+func provisionPermits(provision fm.ConsentProvision, resourceType string, inherited bool) bool {
+	permit := inherited
+	if provision.Type != nil {
+		permit = *provision.Type == fm.ConsentProvisionTypePermit
+	}
+	for _, nested := range provision.Provision {
+		if len(nested.Class) == 0 || hasClass(nested, resourceType) {
+			permit = provisionPermits(nested, resourceType, permit)
+		}
+	}
+	return permit
+}
+
+func hasClass(provision fm.ConsentProvision, resourceType string) bool {
+	for _, class := range provision.Class {
+		if class.Code != nil && *class.Code == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// permits returns whether the patient with the given id has consented to share
+// resources of the given type. Patients without active Consent haven't
+// consented. If one of several Consents denies sharing, sharing is denied.
+func (p consentPolicy) permits(patientId string, resourceType string) bool {
+	consents := p[patientId]
+	if len(consents) == 0 {
+		return false
+	}
+	for _, consent := range consents {
+		if consent.Provision == nil || !provisionPermits(*consent.Provision, resourceType, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchConsentPolicy downloads all active Consent resources and groups them by
+// the id of their patient.
+func fetchConsentPolicy(client *fhir.Client) (consentPolicy, error) {
+	bundleChannel := make(chan downloadBundle, 2)
+	go downloadResources(client, "Consent", "status=active", false, bundleChannel)
+
+	policy := make(consentPolicy)
+	var err error
+	for bundle := range bundleChannel {
+		if err != nil {
+			continue
+		}
+		if bundle.err != nil {
+			err = fmt.Errorf("error while downloading the Consent resources: %w", bundle.err)
+			continue
+		}
+		var entries []fm.BundleEntry
+		if len(bundle.rawEntries) > 0 {
+			if err = json.Unmarshal(bundle.rawEntries, &entries); err != nil {
+				continue
+			}
+		}
+		for _, entry := range entries {
+			if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode != fm.SearchEntryModeMatch {
+				continue
+			}
+			consent, err := fm.UnmarshalConsent(entry.Resource)
+			if err != nil || consent.Patient == nil || consent.Patient.Reference == nil {
+				continue
+			}
+			if patientId, err := idFromLocation(*consent.Patient.Reference, "Patient"); err == nil {
+				policy[patientId] = append(policy[patientId], consent)
+			}
+		}
+	}
+	return policy, err
+}
+
+// patientResource is the part of a resource needed to find its patient.
+type patientResource struct {
+	ResourceType string        `json:"resourceType"`
+	Id           string        `json:"id"`
+	Subject      *fm.Reference `json:"subject"`
+	Patient      *fm.Reference `json:"patient"`
+}
+
+// patientId returns the id of the patient the resource belongs to. Returns
+// false if the resource doesn't refer to a patient by subject or patient.
+func (r patientResource) patientId() (string, bool) {
+	if r.ResourceType == "Patient" {
+		return r.Id, true
+	}
+	for _, reference := range []*fm.Reference{r.Subject, r.Patient} {
+		if reference != nil && reference.Reference != nil {
+			if id, err := idFromLocation(*reference.Reference, "Patient"); err == nil {
+				return id, true
+			}
+		}
+	}
+	return "", false
+}
+
+// filterConsentedEntries removes the entries of resources whose patient
+// hasn't consented to share resources of their type from the raw bundle
+// entries in data. Resources not belonging to a patient and outcomes are
+// kept. Returns the remaining entries together with the number of removed
+// entries.
+func filterConsentedEntries(data []byte, policy consentPolicy) ([]byte, int, error) {
+	if len(data) == 0 {
+		return data, 0, nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, 0, fmt.Errorf("could not parse the bundle entries from JSON: %v", err)
+	}
+
+	kept := make([]json.RawMessage, 0, len(entries))
+	for _, rawEntry := range entries {
+		var entry struct {
+			Resource patientResource `json:"resource"`
+		}
+		if err := json.Unmarshal(rawEntry, &entry); err != nil {
+			return nil, 0, fmt.Errorf("could not parse a bundle entry from JSON: %v", err)
+		}
+		if patientId, ok := entry.Resource.patientId(); ok && !policy.permits(patientId, entry.Resource.ResourceType) {
+			continue
+		}
+		kept = append(kept, rawEntry)
+	}
+
+	filtered, err := json.Marshal(kept)
+	if err != nil {
+		return nil, 0, err
+	}
+	return filtered, len(entries) - len(kept), nil
+}
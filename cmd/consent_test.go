@@ -0,0 +1,101 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// denyExceptConsent permits only Observations.
+const denyExceptConsent = `{"resourceType": "Consent", "status": "active", "patient": {"reference": "Patient/0"},
+  "scope": {}, "category": [], "provision": {"type": "deny", "provision": [
+    {"type": "permit", "class": [{"system": "http://hl7.org/fhir/resource-types", "code": "Observation"}]}]}}`
+
+// permitExceptConsent permits everything except MedicationStatements.
+const permitExceptConsent = `{"resourceType": "Consent", "status": "active", "patient": {"reference": "Patient/1"},
+  "scope": {}, "category": [], "provision": {"type": "permit", "provision": [
+    {"type": "deny", "class": [{"system": "http://hl7.org/fhir/resource-types", "code": "MedicationStatement"}]}]}}`
+
+func mustUnmarshalConsent(t *testing.T, data string) fm.Consent {
+	consent, err := fm.UnmarshalConsent([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return consent
+}
+
+func TestConsentPolicyPermits(t *testing.T) {
+	policy := consentPolicy{
+		"0": {mustUnmarshalConsent(t, denyExceptConsent)},
+		"1": {mustUnmarshalConsent(t, permitExceptConsent)},
+		"2": {mustUnmarshalConsent(t, permitExceptConsent), mustUnmarshalConsent(t, denyExceptConsent)},
+	}
+
+	assert.True(t, policy.permits("0", "Observation"))
+	assert.False(t, policy.permits("0", "Condition"))
+	assert.True(t, policy.permits("1", "Condition"))
+	assert.False(t, policy.permits("1", "MedicationStatement"))
+	assert.True(t, policy.permits("2", "Observation"))
+	assert.False(t, policy.permits("2", "Condition"))
+	assert.False(t, policy.permits("3", "Observation"))
+}
+
+func TestFilterConsentedEntries(t *testing.T) {
+	policy := consentPolicy{"0": {mustUnmarshalConsent(t, denyExceptConsent)}}
+	data := []byte(`[
+  {"resource": {"resourceType": "Patient", "id": "0"}},
+  {"resource": {"resourceType": "Observation", "id": "0", "subject": {"reference": "Patient/0"}}},
+  {"resource": {"resourceType": "Condition", "id": "0", "subject": {"reference": "Patient/0"}}},
+  {"resource": {"resourceType": "Observation", "id": "1", "subject": {"reference": "Patient/1"}}},
+  {"resource": {"resourceType": "AllergyIntolerance", "id": "0", "patient": {"reference": "http://localhost/fhir/Patient/1"}}},
+  {"resource": {"resourceType": "Organization", "id": "0"}}
+]`)
+
+	filtered, excluded, err := filterConsentedEntries(data, policy)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 4, excluded)
+		assert.JSONEq(t, `[
+  {"resource": {"resourceType": "Observation", "id": "0", "subject": {"reference": "Patient/0"}}},
+  {"resource": {"resourceType": "Organization", "id": "0"}}
+]`, string(filtered))
+	}
+}
+
+func TestFetchConsentPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/Consent", r.URL.Path)
+		assert.Equal(t, "active", r.URL.Query().Get("status"))
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [
+          {"resource": ` + denyExceptConsent + `, "search": {"mode": "match"}},
+          {"resource": ` + permitExceptConsent + `, "search": {"mode": "match"}}]}`))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	policy, err := fetchConsentPolicy(fhir.NewClient(*baseURL, nil))
+
+	if assert.NoError(t, err) {
+		assert.Len(t, policy, 2)
+		assert.True(t, policy.permits("0", "Observation"))
+		assert.True(t, policy.permits("1", "Observation"))
+	}
+}
@@ -22,6 +22,7 @@ import (
 	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/spf13/cobra"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
 	"strings"
@@ -64,7 +65,44 @@ func extractResourceTypesWithSearchTypeInteraction(capabilityStatement fm.Capabi
 }
 
 func fetchResourcesTotal(client *fhir.Client, resourceTypes []fm.ResourceType) (map[fm.ResourceType]int, error) {
-	bundle := buildCountBundle(resourceTypes)
+	searches := make([]countSearch, 0, len(resourceTypes))
+	for _, resourceType := range resourceTypes {
+		searches = append(searches, countSearch{resourceType: resourceType})
+	}
+	totals, err := fetchSearchTotals(client, searches)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[fm.ResourceType]int)
+	for i, total := range totals {
+		if total != nil {
+			counts[resourceTypes[i]] = *total
+		}
+	}
+	return counts, nil
+}
+
+// countSearch is a type-level search with an optional single search parameter
+// whose total is counted.
+type countSearch struct {
+	resourceType fm.ResourceType
+	param, value string
+}
+
+func (search countSearch) url() string {
+	query := url.Values{}
+	if search.param != "" {
+		query.Set(search.param, search.value)
+	}
+	query.Set("_summary", "count")
+	return search.resourceType.Code() + "?" + query.Encode()
+}
+
+// fetchSearchTotals executes all searches with _summary=count in one batch
+// and returns their totals in the same order. A total is nil if the server
+// didn't return one.
+func fetchSearchTotals(client *fhir.Client, searches []countSearch) ([]*int, error) {
+	bundle := buildCountBundle(searches)
 	payload, err := json.Marshal(bundle)
 	if err != nil {
 		return nil, err
@@ -86,22 +124,22 @@ func fetchResourcesTotal(client *fhir.Client, resourceTypes []fm.ResourceType) (
 		if err != nil {
 			return nil, err
 		}
-		if len(batchResponse.Entry) != len(resourceTypes) {
+		if len(batchResponse.Entry) != len(searches) {
 			return nil, fmt.Errorf("expect %d bundle entries but got %d",
-				len(resourceTypes), len(batchResponse.Entry))
+				len(searches), len(batchResponse.Entry))
 		}
-		return extractTotalCounts(batchResponse, resourceTypes)
+		return extractTotalCounts(batchResponse)
 	}
 	return nil, fmt.Errorf("non-OK status while performing a batch interaction: %s", resp.Status)
 }
 
-func buildCountBundle(resourceTypes []fm.ResourceType) fm.Bundle {
+func buildCountBundle(searches []countSearch) fm.Bundle {
 	entries := make([]fm.BundleEntry, 0, 100)
-	for _, resourceType := range resourceTypes {
+	for _, search := range searches {
 		entries = append(entries, fm.BundleEntry{
 			Request: &fm.BundleEntryRequest{
 				Method: fm.HTTPVerbGET,
-				Url:    resourceType.Code() + "?_summary=count",
+				Url:    search.url(),
 			},
 		})
 	}
@@ -111,8 +149,8 @@ func buildCountBundle(resourceTypes []fm.ResourceType) fm.Bundle {
 	}
 }
 
-func extractTotalCounts(batchResponse fm.Bundle, resourceTypes []fm.ResourceType) (map[fm.ResourceType]int, error) {
-	counts := make(map[fm.ResourceType]int)
+func extractTotalCounts(batchResponse fm.Bundle) ([]*int, error) {
+	totals := make([]*int, 0, len(batchResponse.Entry))
 	for i, entry := range batchResponse.Entry {
 		if entry.Response == nil {
 			return nil, fmt.Errorf("missing response in entry with index %d", i)
@@ -128,11 +166,181 @@ func extractTotalCounts(batchResponse fm.Bundle, resourceTypes []fm.ResourceType
 		if err != nil {
 			return nil, err
 		}
-		if searchsetBundle.Total != nil {
-			counts[resourceTypes[i]] = *searchsetBundle.Total
+		totals = append(totals, searchsetBundle.Total)
+	}
+	return totals, nil
+}
+
+var countBy string
+var countByValues []string
+var countBySample int
+
+// metaSearchParams maps the values of the --by flag to the search parameters
+// of the corresponding meta element.
+var metaSearchParams = map[string]string{
+	"profile":  "_profile",
+	"tag":      "_tag",
+	"security": "_security",
+}
+
+// metaValues returns the values of meta selected by by. Profiles are returned
+// as canonical URLs and tags and security labels as system|code tokens.
+func metaValues(by string, meta *fm.Meta) []string {
+	if meta == nil {
+		return nil
+	}
+	var values []string
+	switch by {
+	case "profile":
+		values = append(values, meta.Profile...)
+	case "tag":
+		values = append(values, codingTokens(meta.Tag)...)
+	case "security":
+		values = append(values, codingTokens(meta.Security)...)
+	}
+	return values
+}
+
+func codingTokens(codings []fm.Coding) []string {
+	tokens := make([]string, 0, len(codings))
+	for _, coding := range codings {
+		if coding.Code == nil {
+			continue
+		}
+		if coding.System != nil {
+			tokens = append(tokens, *coding.System+"|"+*coding.Code)
+		} else {
+			tokens = append(tokens, *coding.Code)
 		}
 	}
-	return counts, nil
+	return tokens
+}
+
+// discoverMetaValues returns the distinct meta values selected by by of the
+// first sample resources of resourceType in sorted order.
+func discoverMetaValues(client *fhir.Client, resourceType fm.ResourceType, by string, sample int) ([]string, error) {
+	query := url.Values{
+		"_elements": []string{"meta"},
+		"_count":    []string{fmt.Sprintf("%d", sample)},
+	}
+	req, err := client.NewSearchTypeRequest(resourceType.Code(), query)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-OK status while searching %s resources: %s", resourceType.Code(), resp.Status)
+	}
+	bundle, err := fhir.ReadBundle(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	distinct := make(map[string]bool)
+	for _, entry := range bundle.Entry {
+		var resource struct {
+			Meta *fm.Meta `json:"meta"`
+		}
+		if err := json.Unmarshal(entry.Resource, &resource); err != nil {
+			return nil, err
+		}
+		for _, value := range metaValues(by, resource.Meta) {
+			distinct[value] = true
+		}
+	}
+	values := make([]string, 0, len(distinct))
+	for value := range distinct {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+type metaValueCount struct {
+	value string
+	count int
+}
+
+// fetchMetaCounts counts the resources of each of resourceTypes by the meta
+// values selected by by. The values are either given or discovered from a
+// sample of the resources of each type. Values without resources are omitted.
+func fetchMetaCounts(client *fhir.Client, resourceTypes []fm.ResourceType, by string, values []string,
+	sample int) (map[fm.ResourceType][]metaValueCount, error) {
+	var searches []countSearch
+	for _, resourceType := range resourceTypes {
+		typeValues := values
+		if len(typeValues) == 0 {
+			var err error
+			typeValues, err = discoverMetaValues(client, resourceType, by, sample)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, value := range typeValues {
+			searches = append(searches, countSearch{resourceType: resourceType, param: metaSearchParams[by], value: value})
+		}
+	}
+
+	breakdown := make(map[fm.ResourceType][]metaValueCount)
+	if len(searches) == 0 {
+		return breakdown, nil
+	}
+	totals, err := fetchSearchTotals(client, searches)
+	if err != nil {
+		return nil, err
+	}
+	for i, total := range totals {
+		if total != nil && *total > 0 {
+			search := searches[i]
+			breakdown[search.resourceType] = append(breakdown[search.resourceType], metaValueCount{value: search.value, count: *total})
+		}
+	}
+	return breakdown, nil
+}
+
+// fmtMetaCounts formats the counts of the meta values below the count of
+// each resource type in the order of resourceTypes.
+func fmtMetaCounts(resourceTypes []fm.ResourceType, counts map[fm.ResourceType]int,
+	breakdown map[fm.ResourceType][]metaValueCount) string {
+	maxLen, total := 0, 0
+	for _, resourceType := range resourceTypes {
+		if counts[resourceType] == 0 {
+			continue
+		}
+		maxLen = maxInt(maxLen, len(resourceType.Code()))
+		total += counts[resourceType]
+		for _, valueCount := range breakdown[resourceType] {
+			maxLen = maxInt(maxLen, len(valueCount.value)+2)
+		}
+	}
+	maxCount := len(fmt.Sprintf("%d", total))
+	format := "%-" + fmt.Sprintf("%d", maxLen) + "s : %" + fmt.Sprintf("%d", maxCount) + "d\n"
+
+	builder := strings.Builder{}
+	for _, resourceType := range resourceTypes {
+		if counts[resourceType] == 0 {
+			continue
+		}
+		builder.WriteString(fmt.Sprintf(format, resourceType.Code(), counts[resourceType]))
+		for _, valueCount := range breakdown[resourceType] {
+			builder.WriteString(fmt.Sprintf(format, "  "+valueCount.value, valueCount.count))
+		}
+	}
+	builder.WriteString(strings.Repeat("-", maxLen+maxCount+3) + "\n")
+	builder.WriteString(fmt.Sprintf(format, "total", total))
+	return builder.String()
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 // countResourcesCmd represents the countResources command
@@ -141,8 +349,25 @@ var countResourcesCmd = &cobra.Command{
 	Short: "Counts all resources by type",
 	Long: `Uses the capability statement to detect all resource types supported
 on a server and issues an empty search for each resource type with 
-_summary=count to count all resources by type.`,
+_summary=count to count all resources by type.
+
+With --by profile, tag or security, the resources of each type are further
+counted by the values of meta.profile, meta.tag or meta.security using the
+_profile, _tag or _security search parameters. The values are discovered
+from the first --sample resources of each type or can be given by --values.
+Tags and security labels are given as system|code.
+
+Examples:
+  blazectl count-resources --server http://localhost:8080/fhir
+  blazectl count-resources --server http://localhost:8080/fhir --by profile`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, ok := metaSearchParams[countBy]; countBy != "" && !ok {
+			return fmt.Errorf("invalid --by value `%s`, expected one of profile, tag or security", countBy)
+		}
+		if len(countByValues) > 0 && countBy == "" {
+			return fmt.Errorf("the flag --values requires --by")
+		}
+
 		err := createClient()
 		if err != nil {
 			return err
@@ -161,6 +386,23 @@ _summary=count to count all resources by type.`,
 			os.Exit(1)
 		}
 
+		if countBy != "" {
+			countedTypes := make([]fm.ResourceType, 0, len(counts))
+			for _, resourceType := range resourceTypes {
+				if counts[resourceType] != 0 {
+					countedTypes = append(countedTypes, resourceType)
+				}
+			}
+			breakdown, err := fetchMetaCounts(client, countedTypes, countBy, countByValues, countBySample)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			client.CloseIdleConnections()
+			fmt.Print(fmtMetaCounts(resourceTypes, counts, breakdown))
+			return nil
+		}
+
 		client.CloseIdleConnections()
 
 		resourceTypeCodes := make([]string, 0, len(counts))
@@ -200,6 +442,9 @@ func init() {
 	rootCmd.AddCommand(countResourcesCmd)
 
 	countResourcesCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	countResourcesCmd.Flags().StringVar(&countBy, "by", "", "also count by meta element, one of profile, tag or security")
+	countResourcesCmd.Flags().StringSliceVar(&countByValues, "values", nil, "profiles or system|code tokens to count by instead of discovering them")
+	countResourcesCmd.Flags().IntVar(&countBySample, "sample", 1000, "number of resources of each type to discover meta values from")
 
 	_ = countResourcesCmd.MarkFlagRequired("server")
 }
@@ -75,3 +75,65 @@ func TestFetchResourcesTotal(t *testing.T) {
 	}
 	assert.Equal(t, 23, result[fm.ResourceTypePatient])
 }
+
+func TestMetaValues(t *testing.T) {
+	system := "http://example.com/tags"
+	code := "test"
+	meta := &fm.Meta{
+		Profile:  []string{"http://example.com/profile"},
+		Tag:      []fm.Coding{{System: &system, Code: &code}, {Code: &code}, {System: &system}},
+		Security: []fm.Coding{{System: &system, Code: &code}},
+	}
+
+	assert.Equal(t, []string{"http://example.com/profile"}, metaValues("profile", meta))
+	assert.Equal(t, []string{"http://example.com/tags|test", "test"}, metaValues("tag", meta))
+	assert.Equal(t, []string{"http://example.com/tags|test"}, metaValues("security", meta))
+	assert.Nil(t, metaValues("profile", nil))
+}
+
+func TestFetchMetaCounts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			assert.Equal(t, "/Patient", r.URL.Path)
+			assert.Equal(t, "meta", r.URL.Query().Get("_elements"))
+			assert.Equal(t, "10", r.URL.Query().Get("_count"))
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [
+  {"resource": {"resourceType": "Patient", "meta": {"profile": ["http://example.com/b"]}}},
+  {"resource": {"resourceType": "Patient", "meta": {"profile": ["http://example.com/a", "http://example.com/b"]}}},
+  {"resource": {"resourceType": "Patient"}}
+]}`))
+			return
+		}
+
+		bundle, err := fhir.ReadBundle(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if !assert.Len(t, bundle.Entry, 2) {
+			return
+		}
+		assert.Equal(t, "Patient?_profile=http%3A%2F%2Fexample.com%2Fa&_summary=count", bundle.Entry[0].Request.Url)
+		assert.Equal(t, "Patient?_profile=http%3A%2F%2Fexample.com%2Fb&_summary=count", bundle.Entry[1].Request.Url)
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "batch-response", "entry": [
+  {"resource": {"resourceType": "Bundle", "type": "searchset", "total": 1}, "response": {"status": "200"}},
+  {"resource": {"resourceType": "Bundle", "type": "searchset", "total": 2}, "response": {"status": "200"}}
+]}`))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+	breakdown, err := fetchMetaCounts(client, []fm.ResourceType{fm.ResourceTypePatient}, "profile", nil, 10)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []metaValueCount{{value: "http://example.com/a", count: 1}, {value: "http://example.com/b", count: 2}},
+			breakdown[fm.ResourceTypePatient])
+		assert.Equal(t, `Patient                : 3
+  http://example.com/a : 1
+  http://example.com/b : 2
+--------------------------
+total                  : 3
+`, fmtMetaCounts([]fm.ResourceType{fm.ResourceTypePatient, fm.ResourceTypeObservation},
+			map[fm.ResourceType]int{fm.ResourceTypePatient: 3}, breakdown))
+	}
+}
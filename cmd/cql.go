@@ -0,0 +1,207 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/data"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var cqlExpression string
+var patientList bool
+
+// createCqlMeasure creates a measure with a single Patient based population
+// defined by the given expression of the CQL library file.
+func createCqlMeasure(libraryFilename string, expression string) data.Measure {
+	return data.Measure{
+		Library: libraryFilename,
+		Group: []data.Group{
+			{
+				Type: "Patient",
+				Population: []data.Population{
+					{Code: "initial-population", Expression: expression},
+				},
+			},
+		},
+	}
+}
+
+// readPopulation returns the count and the subject results reference of the
+// population of the first group of measureReport.
+func readPopulation(measureReport []byte) (count int, subjectResults string, err error) {
+	report, err := fm.UnmarshalMeasureReport(measureReport)
+	if err != nil {
+		return 0, "", fmt.Errorf("error while reading the MeasureReport: %w", err)
+	}
+	if len(report.Group) == 0 || len(report.Group[0].Population) == 0 {
+		return 0, "", errors.New("missing population in MeasureReport")
+	}
+	population := report.Group[0].Population[0]
+	if population.Count != nil {
+		count = *population.Count
+	}
+	if population.SubjectResults != nil && population.SubjectResults.Reference != nil {
+		subjectResults = *population.SubjectResults.Reference
+	}
+	return count, subjectResults, nil
+}
+
+// fetchSubjectList fetches the List with the given literal reference like
+// List/0 and returns the references of its items.
+func fetchSubjectList(client *fhir.Client, reference string) ([]string, error) {
+	resourceType, id, found := strings.Cut(reference, "/")
+	if !found || resourceType != "List" {
+		return nil, fmt.Errorf("expected a reference to a List but was `%s`", reference)
+	}
+
+	req, err := client.NewReadRequest(resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return nil, errors.New(errorResponse.String())
+	}
+
+	list, err := fm.UnmarshalList(body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the subject list: %w", err)
+	}
+	subjects := make([]string, 0, len(list.Entry))
+	for _, entry := range list.Entry {
+		if entry.Item.Reference != nil {
+			subjects = append(subjects, *entry.Item.Reference)
+		}
+	}
+	return subjects, nil
+}
+
+// cqlCmd represents the cql command
+var cqlCmd = &cobra.Command{
+	Use:   "cql [library-file]",
+	Short: "Evaluates an ad-hoc CQL library",
+	Long: `Evaluates an expression of a CQL library over all patients and prints the
+number of patients for which the expression is true. With --patient-list, the
+references of these patients are printed instead, one per line. With --set
+key=value, {{ .key }} is substituted by value in the CQL libraries.
+
+In contrast to evaluate-measure, no measure file is needed. The Measure and
+Library resources are created on the fly.
+
+Examples:
+  blazectl cql --server "http://localhost:8080/fhir" diabetes.cql
+  blazectl cql --server "http://localhost:8080/fhir" --expression Diabetes --patient-list diabetes.cql`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one library-file argument")
+		}
+		if info, err := os.Stat(args[0]); os.IsNotExist(err) {
+			return fmt.Errorf("library file `%s` doesn't exist", args[0])
+		} else if info.IsDir() {
+			return fmt.Errorf("`%s` is a directory", args[0])
+		} else {
+			return nil
+		}
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		if templateVars, err = parseVariables(setFlags); err != nil {
+			return err
+		}
+
+		err = createClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		measureUrl, err := createMeasure(client, createCqlMeasure(args[0], cqlExpression))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if patientList {
+			measureReportType = "subject-list"
+		}
+
+		fmt.Fprintf(os.Stderr, "Evaluate expression %s of %s on %s ...\n\n", cqlExpression, args[0], server)
+
+		measureReport, err := evaluateMeasureWithRetry(client, measureUrl)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		count, subjectResults, err := readPopulation(measureReport)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if !patientList {
+			fmt.Println(count)
+			return nil
+		}
+		if subjectResults == "" {
+			fmt.Println("the server returned no patient list")
+			os.Exit(1)
+		}
+		subjects, err := fetchSubjectList(client, subjectResults)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, subject := range subjects {
+			fmt.Println(subject)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cqlCmd)
+
+	cqlCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	cqlCmd.Flags().StringVar(&cqlExpression, "expression", "InInitialPopulation", "the name of the expression defining the patients")
+	addSetFlag(cqlCmd)
+	cqlCmd.Flags().BoolVar(&patientList, "patient-list", false, "print the references of the patients instead of their number")
+	cqlCmd.Flags().BoolVarP(&forceSync, "force-sync", "", false, "force synchronous responses")
+	cqlCmd.Flags().DurationVar(&pollInterval, "poll-interval", 100*time.Millisecond, "initial wait between polls of the async status endpoint")
+	cqlCmd.Flags().DurationVar(&pollTimeout, "poll-timeout", 0, "abort polling the async status endpoint after this duration (0 means no timeout)")
+
+	_ = cqlCmd.MarkFlagRequired("server")
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/samply/blazectl/data"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var cqlLibraryPattern = regexp.MustCompile(`(?m)^\s*library\s+("?)([A-Za-z_][\w.]*)"?(?:\s+version\s+'([^']*)')?`)
+var cqlIncludePattern = regexp.MustCompile(`(?m)^\s*include\s+("?)([A-Za-z_][\w.]*)"?(?:\s+version\s+'([^']*)')?`)
+
+// A cqlLibraryIdentifier is the name and optional version of a CQL library.
+type cqlLibraryIdentifier struct {
+	name    string
+	version string
+}
+
+func (id cqlLibraryIdentifier) String() string {
+	if id.version == "" {
+		return id.name
+	}
+	return id.name + " version '" + id.version + "'"
+}
+
+// parseCqlLibraryIdentifier returns the identifier declared by the library
+// statement of a CQL library. The name is empty if there is no such statement.
+func parseCqlLibraryIdentifier(cql []byte) cqlLibraryIdentifier {
+	match := cqlLibraryPattern.FindSubmatch(cql)
+	if match == nil {
+		return cqlLibraryIdentifier{}
+	}
+	return cqlLibraryIdentifier{name: string(match[2]), version: string(match[3])}
+}
+
+// parseCqlIncludes returns the identifiers of the libraries included by a CQL
+// library.
+func parseCqlIncludes(cql []byte) []cqlLibraryIdentifier {
+	var includes []cqlLibraryIdentifier
+	for _, match := range cqlIncludePattern.FindAllSubmatch(cql, -1) {
+		includes = append(includes, cqlLibraryIdentifier{name: string(match[2]), version: string(match[3])})
+	}
+	return includes
+}
+
+// findCqlLibraryFile returns the file of the included library in dir. Files
+// named <name>-<version>.cql are preferred over files named <name>.cql. Returns
+// an empty string if there is no such file.
+func findCqlLibraryFile(dir string, id cqlLibraryIdentifier) string {
+	var candidates []string
+	if id.version != "" {
+		candidates = append(candidates, filepath.Join(dir, id.name+"-"+id.version+".cql"))
+	}
+	candidates = append(candidates, filepath.Join(dir, id.name+".cql"))
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// CreateLibraryResources creates the Library resource of the measure m with
+// the given canonical URL together with one Library resource for each CQL
+// library it includes directly or indirectly. Included libraries are searched
+// in the directory of the library of m. Libraries not found there, like
+// FHIRHelpers, are expected to be available on the server. The variables given
+// by --set are substituted into all libraries.
+//
+// Each Library lists the Libraries it includes as depends-on related artifact
+// and carries the name and version of its CQL library.
+func CreateLibraryResources(m data.Measure, libraryUrl string) ([]fm.Library, error) {
+	mainLibrary, err := CreateLibraryResource(m, libraryUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var includedLibraries []fm.Library
+	urls := make(map[string]string)
+	var addIncludes func(library *fm.Library, filename string, visiting map[string]bool) error
+	addIncludes = func(library *fm.Library, filename string, visiting map[string]bool) error {
+		cql, err := readCqlLibrary(filename)
+		if err != nil {
+			return fmt.Errorf("error while reading the CQL library file: %v", err)
+		}
+		id := parseCqlLibraryIdentifier(cql)
+		if id.name != "" {
+			library.Name = &id.name
+		}
+		if id.version != "" {
+			library.Version = &id.version
+		}
+
+		for _, include := range parseCqlIncludes(cql) {
+			includeFilename := findCqlLibraryFile(filepath.Dir(m.Library), include)
+			if includeFilename == "" {
+				continue
+			}
+			if visiting[includeFilename] {
+				return fmt.Errorf("error while reading the CQL library file %s: cyclic include of %s", filename, include)
+			}
+
+			url, ok := urls[includeFilename]
+			if !ok {
+				url, err = RandomUrl()
+				if err != nil {
+					return err
+				}
+				urls[includeFilename] = url
+
+				includedLibrary, err := CreateLibraryResource(data.Measure{Library: includeFilename}, url)
+				if err != nil {
+					return err
+				}
+				visiting[includeFilename] = true
+				if err := addIncludes(includedLibrary, includeFilename, visiting); err != nil {
+					return err
+				}
+				delete(visiting, includeFilename)
+				includedLibraries = append(includedLibraries, *includedLibrary)
+			}
+
+			canonical := url
+			if include.version != "" {
+				canonical += "|" + include.version
+			}
+			library.RelatedArtifact = append(library.RelatedArtifact, fm.RelatedArtifact{
+				Type:     fm.RelatedArtifactTypeDependsOn,
+				Resource: &canonical,
+			})
+		}
+		return nil
+	}
+
+	if err := addIncludes(mainLibrary, m.Library, map[string]bool{filepath.Clean(m.Library): true}); err != nil {
+		return nil, err
+	}
+	return append([]fm.Library{*mainLibrary}, includedLibraries...), nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/data"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCqlLibraryIdentifier(t *testing.T) {
+	assert.Equal(t, cqlLibraryIdentifier{name: "Main", version: "1.0.0"},
+		parseCqlLibraryIdentifier([]byte("library Main version '1.0.0'\nusing FHIR version '4.0.0'")))
+	assert.Equal(t, cqlLibraryIdentifier{name: "all"}, parseCqlLibraryIdentifier([]byte(`library "all"`)))
+	assert.Equal(t, cqlLibraryIdentifier{}, parseCqlLibraryIdentifier([]byte("define Foo: true")))
+}
+
+func TestParseCqlIncludes(t *testing.T) {
+	includes := parseCqlIncludes([]byte(`library Main
+using FHIR version '4.0.0'
+include FHIRHelpers version '4.0.0'
+  include Common called C
+define Foo: C.Bar`))
+
+	assert.Equal(t, []cqlLibraryIdentifier{{name: "FHIRHelpers", version: "4.0.0"}, {name: "Common"}}, includes)
+}
+
+func writeCqlFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCreateLibraryResources(t *testing.T) {
+	t.Run("without includes", func(t *testing.T) {
+		libraries, err := CreateLibraryResources(data.Measure{Library: "all.cql"}, "library-url")
+
+		assert.NoError(t, err)
+		assert.Len(t, libraries, 1)
+		assert.Equal(t, "all", *libraries[0].Name)
+		assert.Empty(t, libraries[0].RelatedArtifact)
+	})
+
+	t.Run("with includes", func(t *testing.T) {
+		dir := writeCqlFiles(t, map[string]string{
+			"main.cql":       "library Main\ninclude FHIRHelpers version '4.0.0'\ninclude Common version '1.0' called C\ninclude Util",
+			"Common-1.0.cql": "library Common version '1.0'\ninclude Util",
+			"Util.cql":       "library Util",
+			"Common-2.0.cql": "library Common version '2.0'",
+			"Unrelated.cql":  "library Unrelated",
+		})
+
+		libraries, err := CreateLibraryResources(data.Measure{Library: filepath.Join(dir, "main.cql")}, "library-url")
+		if err != nil {
+			t.Fatalf("error while creating the libraries: %v", err)
+		}
+
+		assert.Len(t, libraries, 3)
+		main, util, common := libraries[0], libraries[1], libraries[2]
+		assert.Equal(t, "Main", *main.Name)
+		assert.Equal(t, "library-url", *main.Url)
+		assert.Equal(t, "Util", *util.Name)
+		assert.Equal(t, "Common", *common.Name)
+		assert.Equal(t, "1.0", *common.Version)
+
+		assert.Len(t, main.RelatedArtifact, 2)
+		assert.Equal(t, *common.Url+"|1.0", *main.RelatedArtifact[0].Resource)
+		assert.Equal(t, *util.Url, *main.RelatedArtifact[1].Resource)
+		assert.Len(t, common.RelatedArtifact, 1)
+		assert.Equal(t, *util.Url, *common.RelatedArtifact[0].Resource)
+	})
+
+	t.Run("with cyclic includes", func(t *testing.T) {
+		dir := writeCqlFiles(t, map[string]string{
+			"Main.cql":  "library Main\ninclude Other",
+			"Other.cql": "library Other\ninclude Main",
+		})
+
+		_, err := CreateLibraryResources(data.Measure{Library: filepath.Join(dir, "Main.cql")}, "library-url")
+
+		assert.ErrorContains(t, err, "cyclic include of Main")
+	})
+}
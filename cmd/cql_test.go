@@ -0,0 +1,113 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCreateCqlMeasure(t *testing.T) {
+	m := createCqlMeasure("all.cql", "InInitialPopulation")
+
+	measure, err := CreateMeasureResource(m, "measure-url", "library-url")
+	if err != nil {
+		t.Fatalf("error while creating the Measure: %v", err)
+	}
+
+	assert.Empty(t, measure.Group[0].Extension)
+	assert.Equal(t, "InInitialPopulation", *measure.Group[0].Population[0].Criteria.Expression)
+
+	library, err := CreateLibraryResource(m, "library-url")
+	if err != nil {
+		t.Fatalf("error while creating the Library: %v", err)
+	}
+	assert.Equal(t, "text/cql", *library.Content[0].ContentType)
+}
+
+func TestReadPopulation(t *testing.T) {
+	t.Run("with count", func(t *testing.T) {
+		count, subjectResults, err := readPopulation([]byte(`{"resourceType": "MeasureReport",
+			"status": "complete", "type": "summary", "measure": "foo", "period": {},
+			"group": [{"population": [{"count": 42}]}]}`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 42, count)
+		assert.Empty(t, subjectResults)
+	})
+
+	t.Run("with subject results", func(t *testing.T) {
+		_, subjectResults, err := readPopulation([]byte(`{"resourceType": "MeasureReport",
+			"status": "complete", "type": "subject-list", "measure": "foo", "period": {},
+			"group": [{"population": [{"count": 1, "subjectResults": {"reference": "List/0"}}]}]}`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "List/0", subjectResults)
+	})
+
+	t.Run("without population", func(t *testing.T) {
+		_, _, err := readPopulation([]byte(`{"resourceType": "MeasureReport",
+			"status": "complete", "type": "summary", "measure": "foo", "period": {}}`))
+
+		assert.EqualError(t, err, "missing population in MeasureReport")
+	})
+}
+
+func TestFetchSubjectList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/List/0", r.URL.Path)
+		_, _ = w.Write([]byte(`{"resourceType": "List", "status": "current", "mode": "working",
+			"entry": [{"item": {"reference": "Patient/0"}}, {"item": {"reference": "Patient/1"}}]}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("success", func(t *testing.T) {
+		subjects, err := fetchSubjectList(client, "List/0")
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Patient/0", "Patient/1"}, subjects)
+	})
+
+	t.Run("other reference", func(t *testing.T) {
+		_, err := fetchSubjectList(client, "Group/0")
+
+		assert.EqualError(t, err, "expected a reference to a List but was `Group/0`")
+	})
+}
+
+func TestEvaluateMeasureWithReportType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "subject-list", r.URL.Query().Get("reportType"))
+		_, _ = w.Write([]byte(`{"resourceType": "MeasureReport"}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	measureReportType = "subject-list"
+	defer func() { measureReportType = "" }()
+
+	_, err := evaluateMeasure(client, "foo")
+
+	assert.NoError(t, err)
+}
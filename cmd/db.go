@@ -0,0 +1,113 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var waitForJobCompletion bool
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Database maintenance",
+	Long: `Runs maintenance operations like compaction and re-indexing on the
+databases of the server and shows the progress of the jobs started by them.`,
+}
+
+var dbReIndexCmd = &cobra.Command{
+	Use:   "re-index [search-param-url]",
+	Short: "Re-index all resources for a SearchParameter",
+	Long: `Starts a re-index job on the server which indexes all existing resources
+for the SearchParameter with the given canonical URL and waits until the job is
+finished, printing its progress in percent.
+
+Example:
+
+  blazectl db re-index --server http://localhost:8080/fhir \
+    http://hl7.org/fhir/SearchParameter/Resource-profile`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one SearchParameter URL argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := createClient(); err != nil {
+			return err
+		}
+
+		if err := reIndex(client, args[0]); err != nil {
+			printErrorAndExit(err)
+		}
+		fmt.Printf("Successfully re-indexed all resources for SearchParameter %s.\n", args[0])
+		return nil
+	},
+}
+
+var dbJobCmd = &cobra.Command{
+	Use:   "job [id]",
+	Short: "Show the status of a job",
+	Long: `Shows the status of the job with the given id together with its progress
+in percent if the job reports it. With --wait, the job is polled until it is
+finished.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one job id argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := createClient(); err != nil {
+			return err
+		}
+
+		j, err := fetchJob(client, args[0])
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		fmt.Printf("Job %s: %s\n", j.Id, j)
+
+		if waitForJobCompletion && !j.isFinished() {
+			j, err = waitForJob(client, j.Id, pollInterval, newPollTimeout(), func(j job) {
+				fmt.Printf("Job %s: %s\n", j.Id, j)
+			})
+			if err != nil {
+				printErrorAndExit(err)
+			}
+		}
+		if j.Status == "failed" {
+			printErrorAndExit(fmt.Errorf("job %s failed: %s", j.Id, j.errorMessage()))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(newCompactCmd(), dbReIndexCmd, dbJobCmd)
+
+	for _, cmd := range []*cobra.Command{dbReIndexCmd, dbJobCmd} {
+		cmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+		cmd.Flags().DurationVar(&pollInterval, "poll-interval", 100*time.Millisecond, "initial wait between polls of the job status")
+		cmd.Flags().DurationVar(&pollTimeout, "poll-timeout", 0, "abort polling the job status after this duration (0 means no timeout)")
+		_ = cmd.MarkFlagRequired("server")
+	}
+	dbJobCmd.Flags().BoolVar(&waitForJobCompletion, "wait", false, "poll the job until it is finished")
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// canonicalJSON returns the JSON of resource with the keys of all objects
+// sorted and without whitespace. Numbers are kept as they are.
+func canonicalJSON(resource []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(resource))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// sortExport sorts the NDJSON export at path by type and id into a new file
+// in dir, each line prefixed by its key and a tab. Returns the path of the
+// sorted file.
+func sortExport(path string, dir string, bufferSize int64) (string, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	runDir, err := os.MkdirTemp(dir, "runs-")
+	if err != nil {
+		input.Close()
+		return "", err
+	}
+	defer os.RemoveAll(runDir)
+	runs, err := splitRuns(input, runDir, "id", bufferSize)
+	input.Close()
+	if err != nil {
+		return "", fmt.Errorf("error while sorting %s: %w", path, err)
+	}
+
+	output, err := os.CreateTemp(dir, "sorted-*")
+	if err != nil {
+		return "", err
+	}
+	buf := bufio.NewWriter(output)
+	if err := mergeRuns(runs, buf, true); err != nil {
+		output.Close()
+		return "", fmt.Errorf("error while sorting %s: %w", path, err)
+	}
+	if err := buf.Flush(); err != nil {
+		output.Close()
+		return "", err
+	}
+	return output.Name(), output.Close()
+}
+
+// exportCursor iterates over the resources of a sorted export. Of resources
+// contained more than once, only the last line is used.
+type exportCursor struct {
+	run     *runReader
+	pending bool
+	key     string
+	line    []byte
+	eof     bool
+}
+
+func newExportCursor(r io.Reader) (*exportCursor, error) {
+	c := &exportCursor{run: &runReader{reader: bufio.NewReader(r)}}
+	if err := c.run.next(); err == nil {
+		c.pending = true
+	} else if err != io.EOF {
+		return nil, err
+	}
+	return c, c.advance()
+}
+
+// advance moves to the next resource.
+func (c *exportCursor) advance() error {
+	if !c.pending {
+		c.eof = true
+		return nil
+	}
+	c.key, c.line = c.run.key, c.run.line
+	for {
+		err := c.run.next()
+		if err == io.EOF {
+			c.pending = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.run.key != c.key {
+			return nil
+		}
+		c.line = c.run.line
+	}
+}
+
+// exportDiff counts the differences between two exports.
+type exportDiff struct {
+	added, removed, changed, unchanged int
+}
+
+func (d exportDiff) differs() bool {
+	return d.added+d.removed+d.changed > 0
+}
+
+func (d exportDiff) String() string {
+	return fmt.Sprintf("Resources        [added, removed, changed, unchanged]  %d, %d, %d, %d\n",
+		d.added, d.removed, d.changed, d.unchanged)
+}
+
+// diffExports compares the sorted exports old and new by type and id and
+// writes a line for each added, removed and changed resource to w.
+func diffExports(old io.Reader, new io.Reader, w io.Writer) (exportDiff, error) {
+	var diff exportDiff
+	o, err := newExportCursor(old)
+	if err != nil {
+		return diff, err
+	}
+	n, err := newExportCursor(new)
+	if err != nil {
+		return diff, err
+	}
+
+	for !o.eof || !n.eof {
+		switch {
+		case n.eof || (!o.eof && o.key < n.key):
+			diff.removed++
+			fmt.Fprintf(w, "removed %s\n", o.key)
+			err = o.advance()
+		case o.eof || n.key < o.key:
+			diff.added++
+			fmt.Fprintf(w, "added   %s\n", n.key)
+			err = n.advance()
+		default:
+			oldJSON, err := canonicalJSON(o.line)
+			if err != nil {
+				return diff, fmt.Errorf("could not read %s of the old export: %v", o.key, err)
+			}
+			newJSON, err := canonicalJSON(n.line)
+			if err != nil {
+				return diff, fmt.Errorf("could not read %s of the new export: %v", n.key, err)
+			}
+			if bytes.Equal(oldJSON, newJSON) {
+				diff.unchanged++
+			} else {
+				diff.changed++
+				fmt.Fprintf(w, "changed %s\n", o.key)
+			}
+			if err := o.advance(); err != nil {
+				return diff, err
+			}
+			if err := n.advance(); err != nil {
+				return diff, err
+			}
+		}
+		if err != nil {
+			return diff, err
+		}
+	}
+	return diff, nil
+}
+
+var diffExportCmd = &cobra.Command{
+	Use:   "diff-export <old.ndjson> <new.ndjson>",
+	Short: "Compare two NDJSON exports",
+	Long: `Compares two NDJSON exports, like the output of two download runs, by
+type and id of their resources and prints a line for each added, removed and
+changed resource, followed by the number of added, removed, changed and
+unchanged resources.
+
+Resources are compared by their canonical JSON, so that the order of
+properties and whitespace don't matter. If an export contains a resource more
+than once, the last one is used.
+
+Both exports are sorted by an external merge sort, which sorts up to
+--sort-buffer bytes in memory and spills them to temporary files, so that
+exports larger than the memory can be compared.
+
+Like diff, the command exits with status 1 if the exports differ.
+
+Example:
+  blazectl diff-export january.ndjson february.ndjson`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := os.MkdirTemp(filepath.Dir(args[1]), ".blazectl-diff-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		var sorted [2]*os.File
+		for i, path := range args {
+			sortedPath, err := sortExport(path, dir, sortBuffer)
+			if err != nil {
+				return err
+			}
+			if sorted[i], err = os.Open(sortedPath); err != nil {
+				return err
+			}
+			defer sorted[i].Close()
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		diff, err := diffExports(sorted[0], sorted[1], out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "\n%s", diff)
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		if diff.differs() {
+			sorted[0].Close()
+			sorted[1].Close()
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffExportCmd)
+
+	diffExportCmd.Flags().Int64Var(&sortBuffer, "sort-buffer", 64<<20, "number of bytes sorted in memory before spilling to temporary files")
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	a, _ := canonicalJSON([]byte(`{"resourceType": "Patient", "id": "0", "multipleBirthInteger": 2}`))
+	b, _ := canonicalJSON([]byte(`{"multipleBirthInteger":2,"id":"0","resourceType":"Patient"}`))
+
+	assert.Equal(t, string(a), string(b))
+
+	c, _ := canonicalJSON([]byte(`{"valueDecimal": 1.0}`))
+
+	assert.Equal(t, `{"valueDecimal":1.0}`, string(c))
+}
+
+func TestDiffExports(t *testing.T) {
+	old := "Patient/0\t{\"resourceType\":\"Patient\",\"id\":\"0\",\"gender\":\"male\"}\n" +
+		"Patient/1\t{\"resourceType\":\"Patient\",\"id\":\"1\"}\n" +
+		"Patient/2\t{\"resourceType\":\"Patient\",\"id\":\"2\",\"gender\":\"female\"}\n"
+	new := "Patient/0\t{\"id\":\"0\",\"gender\":\"male\",\"resourceType\":\"Patient\"}\n" +
+		"Patient/2\t{\"resourceType\":\"Patient\",\"id\":\"2\",\"gender\":\"other\"}\n" +
+		"Patient/3\t{\"resourceType\":\"Patient\",\"id\":\"3\"}\n" +
+		"Patient/3\t{\"resourceType\":\"Patient\",\"id\":\"3\",\"active\":true}\n"
+
+	var out strings.Builder
+	diff, err := diffExports(strings.NewReader(old), strings.NewReader(new), &out)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, exportDiff{added: 1, removed: 1, changed: 1, unchanged: 1}, diff)
+		assert.Equal(t, "removed Patient/1\nchanged Patient/2\nadded   Patient/3\n", out.String())
+		assert.True(t, diff.differs())
+		assert.Equal(t, "Resources        [added, removed, changed, unchanged]  1, 1, 1, 1\n", diff.String())
+	}
+}
+
+func TestDiffExportsEmpty(t *testing.T) {
+	diff, err := diffExports(strings.NewReader(""), strings.NewReader(""), &strings.Builder{})
+
+	if assert.NoError(t, err) {
+		assert.False(t, diff.differs())
+	}
+}
+
+func TestSortExport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export.ndjson")
+	if err := os.WriteFile(path, []byte("{\"resourceType\":\"Patient\",\"id\":\"1\"}\n{\"resourceType\":\"Observation\",\"id\":\"0\"}\n"), 0644); err != nil {
+		t.Fatal("can't create a temp file")
+	}
+
+	sorted, err := sortExport(path, dir, 1)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Observation/0\t{\"resourceType\":\"Observation\",\"id\":\"0\"}\nPatient/1\t{\"resourceType\":\"Patient\",\"id\":\"1\"}\n",
+			readFile(t, sorted))
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var docsDir string
+
+// docsCommands returns cmd and all its available subcommands in depth-first
+// order. Hidden commands and the help command are left out.
+func docsCommands(cmd *cobra.Command) []*cobra.Command {
+	commands := []*cobra.Command{cmd}
+	for _, child := range cmd.Commands() {
+		if child.IsAvailableCommand() && !child.IsAdditionalHelpTopicCommand() {
+			commands = append(commands, docsCommands(child)...)
+		}
+	}
+	return commands
+}
+
+// docsName returns the full name of cmd with its parents joined by sep, like
+// blazectl_search-param_list.
+func docsName(cmd *cobra.Command, sep string) string {
+	return strings.ReplaceAll(cmd.CommandPath(), " ", sep)
+}
+
+// seeAlso returns the parent and the subcommands of cmd, which the
+// documentation of cmd links to.
+func seeAlso(cmd *cobra.Command) []*cobra.Command {
+	var related []*cobra.Command
+	if cmd.HasParent() {
+		related = append(related, cmd.Parent())
+	}
+	for _, child := range docsCommands(cmd)[1:] {
+		if child.Parent() == cmd {
+			related = append(related, child)
+		}
+	}
+	return related
+}
+
+// genMarkdown returns the markdown reference of cmd.
+func genMarkdown(cmd *cobra.Command) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "## %s\n\n%s\n\n", cmd.CommandPath(), cmd.Short)
+	if cmd.Long != "" {
+		fmt.Fprintf(&buf, "### Synopsis\n\n```\n%s\n```\n\n", cmd.Long)
+	}
+	if cmd.Runnable() {
+		fmt.Fprintf(&buf, "```\n%s\n```\n\n", cmd.UseLine())
+	}
+	if cmd.Example != "" {
+		fmt.Fprintf(&buf, "### Examples\n\n```\n%s\n```\n\n", cmd.Example)
+	}
+	if flags := cmd.NonInheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&buf, "### Options\n\n```\n%s```\n\n", flags.FlagUsages())
+	}
+	if flags := cmd.InheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&buf, "### Options inherited from parent commands\n\n```\n%s```\n\n", flags.FlagUsages())
+	}
+	if related := seeAlso(cmd); len(related) > 0 {
+		buf.WriteString("### SEE ALSO\n\n")
+		for _, r := range related {
+			fmt.Fprintf(&buf, "* [%s](%s.md) - %s\n", r.CommandPath(), docsName(r, "_"), r.Short)
+		}
+	}
+	return buf.String()
+}
+
+// roffEscape escapes text for roff, so that backslashes and lines starting
+// with a dot or an apostrophe aren't read as requests.
+func roffEscape(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\e`)
+	text = strings.ReplaceAll(text, "-", `\-`)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// roffParagraphs formats text as roff paragraphs. Indented lines, like the
+// examples, are printed as they are.
+func roffParagraphs(text string) string {
+	var buf bytes.Buffer
+	indented := false
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		isIndented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		switch {
+		case isIndented && !indented:
+			buf.WriteString(".PP\n.nf\n")
+		case !isIndented && indented:
+			buf.WriteString(".fi\n")
+		}
+		indented = isIndented
+		if strings.TrimSpace(line) == "" {
+			if !indented {
+				buf.WriteString(".PP\n")
+			}
+			continue
+		}
+		buf.WriteString(roffEscape(line) + "\n")
+	}
+	if indented {
+		buf.WriteString(".fi\n")
+	}
+	return buf.String()
+}
+
+// roffFlags formats the available flags of flags as roff tagged paragraphs.
+func roffFlags(flags *pflag.FlagSet) string {
+	var buf bytes.Buffer
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden {
+			return
+		}
+		buf.WriteString(".TP\n")
+		if flag.Shorthand != "" && flag.ShorthandDeprecated == "" {
+			fmt.Fprintf(&buf, `\fB\-%s\fP, `, flag.Shorthand)
+		}
+		fmt.Fprintf(&buf, `\fB\-\-%s\fP`, roffEscape(flag.Name))
+		if varname, _ := pflag.UnquoteUsage(flag); varname != "" {
+			fmt.Fprintf(&buf, " \\fI%s\\fP", roffEscape(varname))
+		}
+		_, usage := pflag.UnquoteUsage(flag)
+		if flag.DefValue != "" && flag.DefValue != "false" && flag.DefValue != "[]" && flag.DefValue != "0" {
+			usage += " (default " + flag.DefValue + ")"
+		}
+		buf.WriteString("\n" + roffEscape(usage) + "\n")
+	})
+	return buf.String()
+}
+
+// genMan returns the man page of cmd in section 1.
+func genMan(cmd *cobra.Command) string {
+	var buf bytes.Buffer
+	name := docsName(cmd, "-")
+	fmt.Fprintf(&buf, ".TH \"%s\" \"1\" \"\" \"blazectl %s\" \"blazectl Manual\"\n", strings.ToUpper(name), cmd.Root().Version)
+	fmt.Fprintf(&buf, ".SH NAME\n%s \\- %s\n", roffEscape(name), roffEscape(cmd.Short))
+	fmt.Fprintf(&buf, ".SH SYNOPSIS\n\\fB%s\\fP%s\n", roffEscape(cmd.CommandPath()),
+		roffEscape(strings.TrimPrefix(cmd.UseLine(), cmd.CommandPath())))
+	description := cmd.Long
+	if description == "" {
+		description = cmd.Short
+	}
+	fmt.Fprintf(&buf, ".SH DESCRIPTION\n%s", roffParagraphs(description))
+	if cmd.Example != "" {
+		fmt.Fprintf(&buf, ".SH EXAMPLES\n.nf\n%s\n.fi\n", roffEscape(cmd.Example))
+	}
+	if flags := cmd.NonInheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&buf, ".SH OPTIONS\n%s", roffFlags(flags))
+	}
+	if flags := cmd.InheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&buf, ".SH OPTIONS INHERITED FROM PARENT COMMANDS\n%s", roffFlags(flags))
+	}
+	if related := seeAlso(cmd); len(related) > 0 {
+		names := make([]string, 0, len(related))
+		for _, r := range related {
+			names = append(names, fmt.Sprintf("\\fB%s\\fP(1)", roffEscape(docsName(r, "-"))))
+		}
+		fmt.Fprintf(&buf, ".SH SEE ALSO\n%s\n", strings.Join(names, ", "))
+	}
+	return buf.String()
+}
+
+// writeDocs writes the documentation of root and all its subcommands into
+// dir, one file per command, named after the command by name and generated by
+// gen.
+func writeDocs(root *cobra.Command, dir string, name func(cmd *cobra.Command) string, gen func(cmd *cobra.Command) string) error {
+	root.InitDefaultHelpCmd()
+	root.InitDefaultCompletionCmd()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, cmd := range docsCommands(root) {
+		if err := os.WriteFile(filepath.Join(dir, name(cmd)), []byte(gen(cmd)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var docsCmd = &cobra.Command{
+	Use:   "docs",
+	Short: "Generate man pages and a markdown reference",
+	Long: `Generates the documentation of all commands from their help texts, either as
+man pages or as markdown reference, one file per command. This is intended
+for packagers, which can ship the documentation together with the shell
+completion scripts generated by the completion command.`,
+}
+
+var docsManCmd = &cobra.Command{
+	Use:   "man",
+	Short: "Generate man pages",
+	Long: `Generates a man page in section 1 for each command, like blazectl-download.1,
+into the directory given by --dir.
+
+Example:
+  blazectl docs man --dir /usr/local/share/man/man1`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return writeDocs(rootCmd, docsDir, func(cmd *cobra.Command) string {
+			return docsName(cmd, "-") + ".1"
+		}, genMan)
+	},
+}
+
+var docsMarkdownCmd = &cobra.Command{
+	Use:   "markdown",
+	Short: "Generate a markdown reference",
+	Long: `Generates a markdown file for each command, like blazectl_download.md,
+into the directory given by --dir. The files link to each other.
+
+Example:
+  blazectl docs markdown --dir docs`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return writeDocs(rootCmd, docsDir, func(cmd *cobra.Command) string {
+			return docsName(cmd, "_") + ".md"
+		}, genMarkdown)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(docsCmd)
+	docsCmd.AddCommand(docsManCmd)
+	docsCmd.AddCommand(docsMarkdownCmd)
+
+	docsCmd.PersistentFlags().StringVar(&docsDir, "dir", ".", "the directory to write the documentation to")
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenMarkdown(t *testing.T) {
+	markdown := genMarkdown(tailCmd)
+
+	assert.True(t, strings.HasPrefix(markdown, "## blazectl tail\n\nFollow changes of resources\n\n### Synopsis\n"))
+	assert.Contains(t, markdown, "```\nblazectl tail [resource-type] [flags]\n```\n")
+	assert.Contains(t, markdown, "### Options\n\n```\n")
+	assert.Contains(t, markdown, "--interval")
+	assert.Contains(t, markdown, "### Options inherited from parent commands\n")
+	assert.Contains(t, markdown, "* [blazectl](blazectl.md) - Control your FHIR® Server from the Command Line\n")
+}
+
+func TestGenMan(t *testing.T) {
+	man := genMan(docsMarkdownCmd)
+
+	assert.True(t, strings.HasPrefix(man, ".TH \"BLAZECTL-DOCS-MARKDOWN\" \"1\" \"\" \"blazectl "+rootCmd.Version+"\" \"blazectl Manual\"\n"))
+	assert.Contains(t, man, ".SH NAME\nblazectl\\-docs\\-markdown \\- Generate a markdown reference\n")
+	assert.Contains(t, man, ".SH SYNOPSIS\n\\fBblazectl docs markdown\\fP\n")
+	assert.Contains(t, man, ".PP\n.nf\n  blazectl docs markdown \\-\\-dir docs\n.fi\n")
+	assert.Contains(t, man, ".TP\n\\fB\\-\\-dir\\fP \\fIstring\\fP\nthe directory to write the documentation to (default .)\n")
+	assert.Contains(t, man, ".SH SEE ALSO\n\\fBblazectl\\-docs\\fP(1)\n")
+}
+
+func TestRoffEscape(t *testing.T) {
+	assert.Equal(t, `a\e\-b`, roffEscape(`a\-b`))
+	assert.Equal(t, "\\&.TH\n\\&'x", roffEscape(".TH\n'x"))
+}
+
+func TestWriteDocs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "man")
+
+	err := writeDocs(rootCmd, dir, func(cmd *cobra.Command) string {
+		return docsName(cmd, "-") + ".1"
+	}, genMan)
+
+	if assert.NoError(t, err) {
+		for _, name := range []string{"blazectl.1", "blazectl-download.1", "blazectl-search-param-list.1", "blazectl-completion-bash.1"} {
+			assert.FileExists(t, filepath.Join(dir, name))
+		}
+		assert.NoFileExists(t, filepath.Join(dir, "blazectl-help.1"))
+		data, _ := os.ReadFile(filepath.Join(dir, "blazectl.1"))
+		assert.Contains(t, string(data), "\\fBblazectl\\-download\\fP(1)")
+	}
+}
@@ -17,6 +17,8 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"crypto"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/samply/blazectl/fhir"
@@ -28,6 +30,8 @@ import (
 	"net/http/httptrace"
 	"net/url"
 	"os"
+	"os/signal"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -36,19 +40,68 @@ import (
 var outputFile string
 var fhirSearchQuery string
 var usePost bool
+var rewriteNextLinks bool
+var maxBufferedPages int
+var maxRepeatedPages int
 
 type commandStats struct {
 	totalPages                            int
 	resourcesPerPage                      []int
-	requestDurations, processingDurations []float64
+	requestDurations, processingDurations util.DurationDigest // without the warm-up
 	totalBytesIn                          int64
 	totalDuration                         time.Duration
 	inlineOperationOutcomes               []*fm.OperationOutcome
+	excludedResources                     int
+	duplicateResources                    int
 	error                                 *util.ErrorResponse
+	warmupPages                           int
+	bytesOut                              int64
+	writeDuration, writeStalls            time.Duration
+	peakMemory                            uint64
+}
+
+// newDownloadStats creates an aggregator adding the statistics of downloaded
+// and written pages to stats.
+func newDownloadStats(stats *commandStats) *util.Aggregator[commandStats, commandStats] {
+	return util.NewAggregator(stats, (*commandStats).add, (*commandStats).clone)
+}
+
+// add adds the statistics of delta, like the ones of a downloaded or a written
+// page, to cs. The durations of pages downloaded during the warm-up are only
+// counted.
+func (cs *commandStats) add(delta commandStats) {
+	cs.totalPages += delta.totalPages
+	cs.resourcesPerPage = append(cs.resourcesPerPage, delta.resourcesPerPage...)
+	if delta.warmupPages == 0 {
+		cs.requestDurations.Merge(&delta.requestDurations)
+		cs.processingDurations.Merge(&delta.processingDurations)
+	}
+	cs.totalBytesIn += delta.totalBytesIn
+	cs.inlineOperationOutcomes = append(cs.inlineOperationOutcomes, delta.inlineOperationOutcomes...)
+	cs.excludedResources += delta.excludedResources
+	cs.duplicateResources += delta.duplicateResources
+	if delta.error != nil {
+		cs.error = delta.error
+	}
+	cs.warmupPages += delta.warmupPages
+	cs.bytesOut += delta.bytesOut
+	cs.writeDuration += delta.writeDuration
+	cs.writeStalls += delta.writeStalls
+}
+
+// clone returns a copy of cs which doesn't share slices.
+func (cs *commandStats) clone() commandStats {
+	c := *cs
+	c.resourcesPerPage = slices.Clone(cs.resourcesPerPage)
+	c.requestDurations = cs.requestDurations.Clone()
+	c.processingDurations = cs.processingDurations.Clone()
+	c.inlineOperationOutcomes = slices.Clone(cs.inlineOperationOutcomes)
+	return c
 }
 
 func (cs *commandStats) String() string {
 
+	units := unitFormat()
 	builder := strings.Builder{}
 	builder.WriteString(fmt.Sprintf("Pages		[total]			%d\n", cs.totalPages))
 
@@ -68,20 +121,45 @@ func (cs *commandStats) String() string {
 		builder.WriteString(fmt.Sprintf("Resources/Page	[min, mean, max]	%d, %d, %d\n", cs.resourcesPerPage[0], totalResources/len(cs.resourcesPerPage), cs.resourcesPerPage[len(cs.resourcesPerPage)-1]))
 	}
 
-	builder.WriteString(fmt.Sprintf("Duration	[total]			%s\n", util.FmtDurationHumanReadable(cs.totalDuration)))
+	if consentFilter {
+		builder.WriteString(fmt.Sprintf("Excluded	[no consent]		%d\n", cs.excludedResources))
+	}
+
+	if suppressDuplicates {
+		builder.WriteString(fmt.Sprintf("Duplicates	[suppressed]		%d\n", cs.duplicateResources))
+	}
+
+	builder.WriteString(fmt.Sprintf("Duration	[total]			%s\n", units.Duration(cs.totalDuration)))
 
-	if len(cs.requestDurations) > 0 {
-		p := util.CalculateDurationStatistics(cs.requestDurations)
-		builder.WriteString(fmt.Sprintf("Requ. Latencies	[mean, 50, 95, 99, max]	%s, %s, %s, %s, %s\n", p.Mean, p.Q50, p.Q95, p.Q99, p.Max))
+	if statsWarmup.enabled() {
+		builder.WriteString(fmt.Sprintf("Warm-up		[excluded, given]	%d, %s\n", cs.warmupPages, statsWarmup))
 	}
 
-	if len(cs.processingDurations) > 0 {
-		p := util.CalculateDurationStatistics(cs.processingDurations)
-		builder.WriteString(fmt.Sprintf("Proc. Latencies	[mean, 50, 95, 99, max]	%s, %s, %s, %s, %s\n", p.Mean, p.Q50, p.Q95, p.Q99, p.Max))
+	if cs.requestDurations.Count() > 0 {
+		p := cs.requestDurations.Statistics()
+		builder.WriteString(fmt.Sprintf("Requ. Latencies	[min, mean, 50, 95, 99, max, stddev]	%s\n", fmtDurationStatistics(units, p)))
 	}
 
-	totalRequests := len(cs.requestDurations)
-	builder.WriteString(fmt.Sprintf("Bytes In	[total, mean]		%s, %s\n", util.FmtBytesHumanReadable(float32(cs.totalBytesIn)), util.FmtBytesHumanReadable(float32(cs.totalBytesIn)/float32(totalRequests))))
+	if cs.processingDurations.Count() > 0 {
+		p := cs.processingDurations.Statistics()
+		builder.WriteString(fmt.Sprintf("Proc. Latencies	[min, mean, 50, 95, 99, max, stddev]	%s\n", fmtDurationStatistics(units, p)))
+	}
+
+	totalRequests := cs.requestDurations.Count() + cs.warmupPages
+	builder.WriteString(fmt.Sprintf("Bytes In	[total, mean]		%s, %s\n", units.Bytes(float64(cs.totalBytesIn)), units.Bytes(float64(cs.totalBytesIn)/float64(totalRequests))))
+
+	if cs.bytesOut > 0 {
+		if cs.writeDuration > 0 {
+			builder.WriteString(fmt.Sprintf("Bytes Out	[total, write rate]	%s, %s/s\n", units.Bytes(float64(cs.bytesOut)), units.Bytes(float64(cs.bytesOut)/cs.writeDuration.Seconds())))
+		} else {
+			builder.WriteString(fmt.Sprintf("Bytes Out	[total]			%s\n", units.Bytes(float64(cs.bytesOut))))
+		}
+		builder.WriteString(fmt.Sprintf("Write Stalls	[total]			%s\n", units.Duration(cs.writeStalls)))
+	}
+
+	if cs.peakMemory > 0 {
+		builder.WriteString(fmt.Sprintf("Memory		[peak]			%s\n", units.Bytes(float64(cs.peakMemory))))
+	}
 
 	if len(cs.inlineOperationOutcomes) > 0 {
 		builder.WriteString("\nServer Warnings & Information:\n")
@@ -110,6 +188,9 @@ type downloadBundle struct {
 	err                  error
 	stats                *networkStats
 	errResponse          *util.ErrorResponse
+	// total is the number of resources the search will return, as reported
+	// by the server in the first page. It's nil for all other pages.
+	total *int
 }
 
 // downloadBundleError creates a downloadResource instance with an error attached to it.
@@ -120,156 +201,39 @@ func downloadBundleError(format string, a ...interface{}) downloadBundle {
 	}
 }
 
-var resourceTypes = []string{
-	"Account",
-	"ActivityDefinition",
-	"AdverseEvent",
-	"AllergyIntolerance",
-	"Appointment",
-	"AppointmentResponse",
-	"AuditEvent",
-	"Basic",
-	"Binary",
-	"BiologicallyDerivedProduct",
-	"BodyStructure",
-	"Bundle",
-	"CapabilityStatement",
-	"CarePlan",
-	"CareTeam",
-	"CatalogEntry",
-	"ChargeItem",
-	"ChargeItemDefinition",
-	"Claim",
-	"ClaimResponse",
-	"ClinicalImpression",
-	"CodeSystem",
-	"Communication",
-	"CommunicationRequest",
-	"CompartmentDefinition",
-	"Composition",
-	"ConceptMap",
-	"Condition",
-	"Consent",
-	"Contract",
-	"Coverage",
-	"CoverageEligibilityRequest",
-	"CoverageEligibilityResponse",
-	"DetectedIssue",
-	"Device",
-	"DeviceDefinition",
-	"DeviceMetric",
-	"DeviceRequest",
-	"DeviceUseStatement",
-	"DiagnosticReport",
-	"DocumentManifest",
-	"DocumentReference",
-	"EffectEvidenceSynthesis",
-	"Encounter",
-	"Endpoint",
-	"EnrollmentRequest",
-	"EnrollmentResponse",
-	"EpisodeOfCare",
-	"EventDefinition",
-	"Evidence",
-	"EvidenceVariable",
-	"ExampleScenario",
-	"ExplanationOfBenefit",
-	"FamilyMemberHistory",
-	"Flag",
-	"Goal",
-	"GraphDefinition",
-	"Group",
-	"GuidanceResponse",
-	"HealthcareService",
-	"ImagingStudy",
-	"Immunization",
-	"ImmunizationEvaluation",
-	"ImmunizationRecommendation",
-	"ImplementationGuide",
-	"InsurancePlan",
-	"Invoice",
-	"Library",
-	"Linkage",
-	"List",
-	"Location",
-	"Measure",
-	"MeasureReport",
-	"Media",
-	"Medication",
-	"MedicationAdministration",
-	"MedicationDispense",
-	"MedicationKnowledge",
-	"MedicationRequest",
-	"MedicationStatement",
-	"MedicinalProduct",
-	"MedicinalProductAuthorization",
-	"MedicinalProductContraindication",
-	"MedicinalProductIndication",
-	"MedicinalProductIngredient",
-	"MedicinalProductInteraction",
-	"MedicinalProductManufactured",
-	"MedicinalProductPackaged",
-	"MedicinalProductPharmaceutical",
-	"MedicinalProductUndesirableEffect",
-	"MessageDefinition",
-	"MessageHeader",
-	"MolecularSequence",
-	"NamingSystem",
-	"NutritionOrder",
-	"Observation",
-	"ObservationDefinition",
-	"OperationDefinition",
-	"OperationOutcome",
-	"Organization",
-	"OrganizationAffiliation",
-	"Patient",
-	"PaymentNotice",
-	"PaymentReconciliation",
-	"Person",
-	"PlanDefinition",
-	"Practitioner",
-	"PractitionerRole",
-	"Procedure",
-	"Provenance",
-	"Questionnaire",
-	"QuestionnaireResponse",
-	"RelatedPerson",
-	"RequestGroup",
-	"ResearchDefinition",
-	"ResearchElementDefinition",
-	"ResearchStudy",
-	"ResearchSubject",
-	"RiskAssessment",
-	"RiskEvidenceSynthesis",
-	"Schedule",
-	"SearchParameter",
-	"ServiceRequest",
-	"Slot",
-	"Specimen",
-	"SpecimenDefinition",
-	"StructureDefinition",
-	"StructureMap",
-	"Subscription",
-	"Substance",
-	"SubstanceNucleicAcid",
-	"SubstancePolymer",
-	"SubstanceProtein",
-	"SubstanceReferenceInformation",
-	"SubstanceSourceMaterial",
-	"SubstanceSpecification",
-	"SupplyDelivery",
-	"SupplyRequest",
-	"Task",
-	"TerminologyCapabilities",
-	"TestReport",
-	"TestScript",
-	"ValueSet",
-	"VerificationResult",
-	"VisionPrescription",
+// resourceTypes are the names of all concrete FHIR R4 resource types.
+var resourceTypes = fhir.ResourceTypes()
+
+// completeResourceTypes returns a completion function of up to maxArgs resource
+// type arguments, where zero means no limit. The resource types are taken from
+// the CapabilityStatement of the server if --server is given and from the
+// built-in list otherwise. Resource types already given are left out.
+func completeResourceTypes(maxArgs int) func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if maxArgs > 0 && len(args) >= maxArgs {
+			return []string{}, cobra.ShellCompDirectiveNoFileComp
+		}
+		types := resourceTypes
+		if server != "" && createClient() == nil {
+			if supported, err := fetchResourceTypesWithSearchTypeInteraction(client); err == nil && len(supported) > 0 {
+				types = make([]string, 0, len(supported))
+				for _, resourceType := range supported {
+					types = append(types, resourceType.Code())
+				}
+			}
+		}
+		completions := make([]string, 0, len(types))
+		for _, resourceType := range types {
+			if !slices.Contains(args, resourceType) {
+				completions = append(completions, resourceType)
+			}
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
 }
 
 var downloadCmd = &cobra.Command{
-	Use:   "download [resource-type]",
+	Use:   "download [resource-type]...",
 	Short: "Download resources in NDJSON format",
 	Long: `Downloads resources using FHIR search, extracts the resources from
 the returned bundles and outputs one resource per line in NDJSON format.
@@ -278,42 +242,324 @@ If the optional resource-type is given, the corresponding type-level
 search will be used. Otherwise, the system-level search will be used and
 all resources of the whole system will be downloaded. 
 
+With more than one resource-type, the type-level search of each type is used
+and up to --concurrency types are downloaded in parallel into the same
+output. The query is applied to each type.
+
 The --query flag will take an optional FHIR search query that will be used
 to constrain the resources to download.
 
+The searches request the total number of resources with _total=accurate, so
+that the progress shows the percentage of downloaded resources. Use --total
+estimate or --total none for servers which are slow to count. A _total in the
+query takes precedence.
+
+Servers ignore search parameters they don't know, so that a typo in the
+query silently results in too many or no resources. With --lint-query, the
+parameters of the query are checked against the search parameters the server
+advertises in its CapabilityStatement for the resource type first. For each
+parameter not advertised, a warning is printed together with the closest
+advertised parameter, if any. The download is done anyway.
+
+With --preview, the search is issued with _summary=count first and the
+number of resources it will return is printed. The download only starts
+after confirmation, which has to be given by --yes if stdin isn't a terminal.
+
 With the flag --use-post you can ensure that the FHIR search query specified
 with --query is send as POST request in the body.
 
+Relative next links are resolved against the URL of the previous request.
+With the flag --rewrite-next-links, scheme and host of all next links are
+replaced by the ones of the server base URL. Use this for servers behind
+proxies which return links containing their internal address.
+
 Resources will be either streamed to STDOUT, delimited by newline, or
 stored in a file if the --output-file flag is given.
 
+With --consent-filter, all active Consent resources are downloaded first.
+Resources belonging to a patient, by being the Patient itself or by their
+subject or patient reference, are only written if the patient has an active
+Consent whose provisions permit sharing resources of their type. Provision
+classes are matched against the resource type. Resources of patients without
+active Consent are excluded. Resources not belonging to a patient are kept.
+
+With --cohort, only the resources of the patients listed in the cohort file
+are downloaded. The file contains one Patient id or identifier in the form
+system|value per line. If resource-type is given, the compartment search of
+each patient is used. Otherwise, all resources of each patient are downloaded
+using Patient/$everything. Up to --cohort-concurrency patients are downloaded
+in parallel and the statistics cover all patients.
+
+With --group, the patients are the members of the Group with the given id
+instead. Group members are resolved by blazectl, so that no support of
+group-level operations by the server is needed. Without resource-type, this
+exports all data of the Group like Group/$export would do.
+
+With --chunk-by-lastupdated, the download is split into sequential searches
+of _lastUpdated ranges of the given size, like 1d, 2w or 12h, each fully
+paged. This avoids the expiry of the paging state of servers on downloads
+running for days. The chunks start at the last update of the oldest resource
+or at --chunk-start and end now or at --chunk-end. If a chunk fails, its
+start is printed, so that the download can be restarted from there with
+--chunk-start.
+
+With --recover-expired-pages, the search is sorted by _lastUpdated. If the
+server reports an expired paging context or a missing page, the search is
+re-issued for the resources last updated since the last downloaded one,
+instead of failing the download. Resources downloaded before are skipped.
+
+With --suppress-duplicates, the type, id and version id of all downloaded
+resources are remembered and resources downloaded more than once, because of
+paging anomalies of the server or overlapping searches, are only written
+once. The statistics show the number of suppressed duplicates. The memory
+needed grows with the number of resources.
+
+With --warmup, the pages downloaded during connection setup and server cache
+warm-up are excluded from the latency statistics. The warm-up is either a
+number of pages, like 10, or a duration since the start, like 30s.
+
+With --max-file-size or --max-resources-per-file, the output rolls over into
+part files named after the output file, like export-0001.ndjson,
+export-0002.ndjson and so on. A part is only split between resources, so a
+single resource larger than --max-file-size gets a part of its own.
+
+With --output-sqlite, the resources are written into a new SQLite database
+with a table resources of the columns id, type, last_updated and resource,
+which holds the JSON of the resource. The table has a primary key on type and
+id and an index on last_updated. The sqlite3 command line shell has to be
+installed.
+
+With --output-postgres, the resources are bulk loaded with COPY into an
+existing PostgreSQL table, given by --postgres-table, using the psql command
+line client with the given connection string. The flag --postgres-columns
+names the four columns for id, type, last updated and the JSON of the
+resources. The rows are only visible if the whole download succeeds.
+
+With --output-kafka and --kafka-topic, each resource is published as message
+with the key type/id to the topic on the given brokers, using the kcat
+command line client.
+
+With --index, an NDJSON index is written which maps the type and id of each
+resource to the output file, the byte offset and the length of its line, so
+that single resources can be extracted from large exports without a full scan.
+
+With --zstd, the output files are written as seekable zstd archives, like
+export.ndjson.zst. They consist of independently compressed frames of about
+1 MiB of whole resources followed by a seek table. With --index, the index
+also holds the offset and length of the compressed frame of each resource and
+the offset of the resource within its decompressed frame. Limits like
+--max-file-size apply to the uncompressed size.
+
+With --output-cmd, the NDJSON stream is piped into the standard input of the
+given shell command instead, like a loader of a downstream database. Together
+with --max-file-size or --max-resources-per-file, the command is restarted for
+each part with the part number in the environment variable BLAZECTL_PART. The
+download fails if the command exits with an error.
+
+With --sort, the output file is sorted by lastUpdated or by id after the
+download, so that the output of repeated downloads can be compared. Sorting
+by lastUpdated orders resources by their last update and then by type and
+id, sorting by id orders them by type and id. An external merge sort is used,
+which sorts up to --sort-buffer bytes in memory and spills them to temporary
+files next to the output file.
+
+With --manifest, a JSON manifest is written after the download, listing the
+output files with their number of resources, their size in bytes and their
+SHA-256 checksum, so that downstream consumers can verify the integrity of the
+export. With --sign-key, the manifest is signed with the given PEM encoded
+PKCS #8 private key (Ed25519, ECDSA or RSA). The signature covers the compact
+JSON of the manifest without signature.
+
+Downloaded pages are written by a separate writer, so that a slow disk
+doesn't stall the download of the next pages. Up to --write-buffer pages are
+buffered for the writer. The statistics show the number of bytes written
+together with the write rate while writing and the total time the download
+had to wait for the writer. Long write stalls indicate an I/O-bound run.
+
+With --max-buffered-pages, at most the given number of downloaded pages are
+buffered, all of them for the writer, so that a slow writer stalls the
+download instead of growing the memory. Pages are handed over from the
+searches without buffering. The memory needed grows with the number of
+buffered pages times the page size, which can be limited with _count in the
+query. The statistics show the peak memory held by blazectl.
+
+On interrupt (Ctrl-C), the statistics of the pages downloaded so far are
+printed. Use --summary-file to also write the statistics to a file.
+
 Examples:
   blazectl download --server http://localhost:8080/fhir Patient > all-patients.ndjson
   blazectl download --server http://localhost:8080/fhir Patient -q "gender=female" -o female-patients.ndjson
-  blazectl download --server http://localhost:8080/fhir > all-resources.ndjson`,
-	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return resourceTypes, cobra.ShellCompDirectiveNoFileComp
-	},
+  blazectl download --server http://localhost:8080/fhir > all-resources.ndjson
+  blazectl download --server http://localhost:8080/fhir Observation --cohort cohort.txt -o observations.ndjson
+  blazectl download --server http://localhost:8080/fhir --group study-cohort -o study.ndjson
+  blazectl download --server http://localhost:8080/fhir -o export.ndjson --max-resources-per-file 1000000 --manifest manifest.json
+  blazectl download --server http://localhost:8080/fhir Patient --output-cmd 'gzip > patients-$BLAZECTL_PART.ndjson.gz' --max-resources-per-file 100000`,
+	ValidArgsFunction: completeResourceTypes(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		err := createClient()
+		var err error
+		if statsWarmup, err = parseWarmup(warmupFlag); err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			if cohortSelected() {
+				return fmt.Errorf("the flags --cohort or --group can't be used with more than one resource type")
+			}
+			if chunkByLastUpdated != "" {
+				return fmt.Errorf("the flag --chunk-by-lastupdated can't be used with more than one resource type")
+			}
+		}
+		if cohortSelected() && usePost {
+			return fmt.Errorf("the flags --cohort or --group and --use-post can't be used together")
+		}
+		if cohortSelected() && previewDownload {
+			return fmt.Errorf("the flags --cohort or --group and --preview can't be used together")
+		}
+		if assumeYes && !previewDownload {
+			return fmt.Errorf("the flag --yes requires --preview")
+		}
+		if err := checkSearchTotal(searchTotal); err != nil {
+			return err
+		}
+		if recoverExpiredPages {
+			if cohortSelected() {
+				return fmt.Errorf("the flags --cohort or --group and --recover-expired-pages can't be used together")
+			}
+			if query, err := url.ParseQuery(fhirSearchQuery); err == nil && query.Has("_sort") {
+				return fmt.Errorf("the flag --recover-expired-pages can't be used with a query containing _sort")
+			}
+		}
+		var chunkSize time.Duration
+		if chunkByLastUpdated != "" {
+			if cohortSelected() {
+				return fmt.Errorf("the flags --cohort or --group and --chunk-by-lastupdated can't be used together")
+			}
+			if chunkSize, err = parseChunkSize(chunkByLastUpdated); err != nil {
+				return err
+			}
+		} else if chunkStart != "" || chunkEnd != "" {
+			return fmt.Errorf("the flags --chunk-start and --chunk-end require --chunk-by-lastupdated")
+		}
+		if manifestFile != "" && outputFile == "" {
+			return fmt.Errorf("the flag --manifest requires --output-file")
+		}
+		if selectedOutputs() > 1 {
+			return fmt.Errorf("only one of the flags --output-file, --output-cmd, --output-sqlite, --output-postgres and --output-kafka can be used")
+		}
+		if (maxFileSize > 0 || maxResourcesPerFile > 0) && outputFile == "" && outputCmd == "" {
+			return fmt.Errorf("the flags --max-file-size and --max-resources-per-file require --output-file or --output-cmd")
+		}
+		if (outputKafka != "") != (kafkaTopic != "") {
+			return fmt.Errorf("the flags --output-kafka and --kafka-topic have to be used together")
+		}
+		if sortBy != "" {
+			if err := checkSortBy(sortBy); err != nil {
+				return err
+			}
+			if outputFile == "" {
+				return fmt.Errorf("the flag --sort requires --output-file")
+			}
+			if maxFileSize > 0 || maxResourcesPerFile > 0 || indexFile != "" || zstdOutput {
+				return fmt.Errorf("the flag --sort can't be used together with --max-file-size, --max-resources-per-file, --index or --zstd")
+			}
+		}
+		if indexFile != "" && outputFile == "" {
+			return fmt.Errorf("the flag --index requires --output-file")
+		}
+		if zstdOutput && outputFile == "" {
+			return fmt.Errorf("the flag --zstd requires --output-file")
+		}
+		if signKeyFile != "" && manifestFile == "" {
+			return fmt.Errorf("the flag --sign-key requires --manifest")
+		}
+		if maxBufferedPages < 0 {
+			return fmt.Errorf("invalid --max-buffered-pages value `%d`, expected a positive number", maxBufferedPages)
+		}
+		if maxBufferedPages > 0 && cmd.Flags().Changed("write-buffer") {
+			return fmt.Errorf("the flags --max-buffered-pages and --write-buffer can't be used together")
+		}
+		var signKey crypto.Signer
+		if signKeyFile != "" {
+			if signKey, err = readSigningKey(signKeyFile); err != nil {
+				return err
+			}
+		}
+		err = createClient()
 		if err != nil {
 			return err
 		}
-		var stats commandStats
+		if lintQuery {
+			if err := lintDownloadQuery(client, args, fhirSearchQuery, os.Stderr); err != nil {
+				return err
+			}
+		}
+		if previewDownload {
+			start, err := previewDownloadTotals(client, args, fhirSearchQuery)
+			if err != nil {
+				return err
+			}
+			if !start {
+				fmt.Fprintln(os.Stderr, "The download was cancelled.")
+				return nil
+			}
+		}
+		stats := newDownloadStats(&commandStats{})
+		var downloadedPages int
 		startTime := time.Now()
-
-		var file *os.File
-		if outputFile == "" {
-			file = os.Stdout
-		} else {
-			file = createOutputFileOrDie(outputFile)
+		memory := startMemoryMonitor(100 * time.Millisecond)
+
+		var sink flushWriter
+		var output *partWriter
+		var sqlite *sqliteWriter
+		var postgres *postgresWriter
+		var kafka *kafkaWriter
+		var outputs []io.Closer
+		switch {
+		case outputFile != "":
+			if zstdOutput {
+				output = newSeekablePartWriter(outputFile, maxFileSize, maxResourcesPerFile, seekableFrameSize)
+			} else {
+				output = newPartWriter(outputFile, maxFileSize, maxResourcesPerFile)
+			}
+			if indexFile != "" {
+				output.index = newResourceIndex(indexFile)
+			}
+			sink = output
+			outputs = append(outputs, output)
+			defer output.Close()
+		case outputCmd != "":
+			if output, err = newCommandWriter(outputCmd, maxFileSize, maxResourcesPerFile); err != nil {
+				return err
+			}
+			sink = output
+			outputs = append(outputs, output)
+			defer output.Close()
+		case outputSqlite != "":
+			if sqlite, err = newSqliteWriter(outputSqlite); err != nil {
+				return err
+			}
+			sink = sqlite
+			outputs = append(outputs, sqlite)
+			defer sqlite.Close()
+		case outputPostgres != "":
+			if postgres, err = newPostgresWriter(outputPostgres, postgresTable, postgresColumns); err != nil {
+				return err
+			}
+			sink = postgres
+			outputs = append(outputs, postgres)
+			defer postgres.Close()
+		case outputKafka != "":
+			if kafka, err = newKafkaWriter(outputKafka, kafkaTopic); err != nil {
+				return err
+			}
+			sink = kafka
+			outputs = append(outputs, kafka)
+			defer kafka.Close()
+		default:
+			sink = bufio.NewWriter(os.Stdout)
+			defer sink.Flush()
 		}
-		sink := bufio.NewWriter(file)
-		defer file.Close()
-		defer file.Sync()
-		defer sink.Flush()
 
-		bundleChannel := make(chan downloadBundle, 2)
+		bundleChannel := make(chan downloadBundle, downloadBuffer())
 
 		var resourceType string
 		if len(args) > 0 {
@@ -322,40 +568,208 @@ Examples:
 			resourceType = ""
 		}
 
-		go downloadResources(client, resourceType, fhirSearchQuery, usePost, bundleChannel)
+		summary := createSummaryFileOrDie()
+		if summary != nil {
+			defer summary.Close()
+		}
+
+		var policy consentPolicy
+		if consentFilter {
+			policy, err = fetchConsentPolicy(client)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Found active Consent resources of %d patients.\n", len(policy))
+		}
+
+		progress, err := createDownloadProgress()
+		if err != nil {
+			return err
+		}
+
+		if cohortSelected() {
+			ids := loadCohortOrDie(client)
+			go downloadCohortResources(client, ids, resourceType, fhirSearchQuery, cohortConcurrency, bundleChannel)
+		} else if len(args) > 1 {
+			go downloadTypesResources(client, args, fhirSearchQuery, usePost, concurrency, bundleChannel)
+		} else if chunkSize > 0 {
+			chunks, err := planChunks(client, resourceType, fhirSearchQuery, chunkSize)
+			if err != nil {
+				return err
+			}
+			go downloadChunkedResources(client, resourceType, fhirSearchQuery, usePost, chunks, bundleChannel)
+		} else {
+			go downloadResources(client, resourceType, fhirSearchQuery, usePost, bundleChannel)
+		}
 
-		for bundle := range bundleChannel {
-			stats.totalPages++
+		// pages are written by a separate worker, so that a slow disk doesn't
+		// stall the download of the next pages
+		writer := newPageWriter(sink, stats, policy)
+		writer.progress = progress
+		if suppressDuplicates {
+			writer.duplicates = newDuplicateFilter()
+		}
+		writeChannel := make(chan downloadBundle, writerBuffer())
+		writerDone := make(chan struct{})
+		go func() {
+			writer.writePages(writeChannel)
+			close(writerDone)
+		}()
+
+		interruptChan := make(chan os.Signal, 1)
+		signal.Notify(interruptChan, os.Interrupt)
+
+		for {
+			var bundle downloadBundle
+			var ok bool
+			select {
+			case <-interruptChan:
+				writer.lock()
+				sink.Flush()
+				progress.finish()
+				interim := stats.Final()
+				interim.totalDuration = time.Since(startTime)
+				interim.peakMemory = memory.peakMemory()
+				writeSummary(os.Stderr, summary, "Download interrupted. The statistics only cover the pages downloaded so far.\n"+interim.String())
+				os.Exit(interruptedExitCode)
+			case bundle, ok = <-bundleChannel:
+			}
+			if !ok {
+				break
+			}
 
 			if bundle.err != nil || bundle.errResponse != nil {
+				if err := stopWriting(writeChannel, writerDone, outputs...); err != nil {
+					fmt.Printf("Failed to write downloaded resources: %v\n", err)
+				}
+				progress.finish()
 				fmt.Printf("Failed to download resources: %v\n", bundle.err)
 
-				stats.error = bundle.errResponse
-				stats.totalDuration = time.Since(startTime)
-				fmt.Println(stats.String())
+				stats.Add(commandStats{totalPages: 1, error: bundle.errResponse})
+				final := stats.Final()
+				final.totalDuration = time.Since(startTime)
+				final.peakMemory = memory.peakMemory()
+				writeSummary(os.Stdout, summary, final.String()+"\n")
 				os.Exit(1)
-			} else {
-				stats.requestDurations = append(stats.requestDurations, bundle.stats.requestDuration)
-				stats.processingDurations = append(stats.processingDurations, bundle.stats.processingDuration)
-				stats.totalBytesIn += bundle.stats.totalBytesIn
-
-				resources, inlineOutcomes, err := writeResources(&bundle.rawEntries, sink)
-				stats.resourcesPerPage = append(stats.resourcesPerPage, resources)
-				stats.inlineOperationOutcomes = append(stats.inlineOperationOutcomes, inlineOutcomes...)
+			}
+			page := commandStats{
+				totalPages:          1,
+				requestDurations:    util.NewDurationDigest(bundle.stats.requestDuration),
+				processingDurations: util.NewDurationDigest(bundle.stats.processingDuration),
+				totalBytesIn:        bundle.stats.totalBytesIn,
+			}
+			if statsWarmup.includes(downloadedPages, time.Since(startTime)) {
+				page.warmupPages = 1
+			}
+			downloadedPages++
+			stats.Add(page)
+			if bundle.total != nil {
+				progress.addTotal(*bundle.total)
+			}
 
+			stallStart := time.Now()
+			writeChannel <- bundle
+			writer.stalled(time.Since(stallStart))
+		}
+		close(writeChannel)
+		<-writerDone
+		progress.finish()
+		final := stats.Final()
+		final.totalDuration = time.Since(startTime)
+		final.peakMemory = memory.Stop()
+		if output != nil {
+			if err := output.Close(); err != nil {
+				return err
+			}
+			if sortBy != "" {
+				hash, err := sortFile(outputFile, sortBy, sortBuffer)
 				if err != nil {
-					fmt.Printf("Failed to write downloaded resources received from request to URL %s: %v\n", bundle.associatedRequestURL.String(), err)
-					os.Exit(2)
+					return err
+				}
+				output.parts[0].hash = hash
+			}
+			if len(output.parts) > 1 {
+				if outputCmd != "" {
+					fmt.Fprintf(os.Stderr, "Ran the output command for %d parts.\n", len(output.parts))
+				} else {
+					fmt.Fprintf(os.Stderr, "Wrote %d part files.\n", len(output.parts))
 				}
 			}
 		}
-
-		stats.totalDuration = time.Since(startTime)
-		fmt.Fprintf(os.Stderr, stats.String())
+		if sqlite != nil {
+			if err := sqlite.Close(); err != nil {
+				return err
+			}
+		}
+		if postgres != nil {
+			if err := postgres.Close(); err != nil {
+				return err
+			}
+		}
+		if kafka != nil {
+			if err := kafka.Close(); err != nil {
+				return err
+			}
+		}
+		if manifestFile != "" {
+			if err := writeManifest(manifestFile, output.manifestEntries(manifestFile), signKey); err != nil {
+				return err
+			}
+		}
+		writeSummary(os.Stderr, summary, final.String())
 		return nil
 	},
 }
 
+// stopWriting closes the queue of the page writer, waits until the writer
+// wrote and flushed all queued pages and closes outputs. It's used on
+// failures, so that the pages downloaded before, like the ones of completed
+// chunks, aren't lost.
+func stopWriting(writeChannel chan<- downloadBundle, writerDone <-chan struct{}, outputs ...io.Closer) error {
+	close(writeChannel)
+	<-writerDone
+	var firstErr error
+	for _, output := range outputs {
+		if err := output.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// downloadBuffer returns the number of pages buffered between a search and
+// the download. With --max-buffered-pages, pages are handed over directly.
+func downloadBuffer() int {
+	if maxBufferedPages > 0 {
+		return 0
+	}
+	return 2
+}
+
+// writerBuffer returns the number of pages buffered for the writer.
+func writerBuffer() int {
+	if maxBufferedPages > 0 {
+		return maxBufferedPages
+	}
+	return maxInt(0, writeBuffer)
+}
+
+// downloadTypesResources downloads the resources of all resourceTypes with up
+// to concurrency types in parallel. The pages of all types are sent to
+// resChannel, which is closed after all types are downloaded.
+func downloadTypesResources(client *fhir.Client, resourceTypes []string, fhirSearchQuery string, usePost bool,
+	concurrency int, resChannel chan<- downloadBundle) {
+	defer close(resChannel)
+	util.ForEach(resourceTypes, concurrency, func(resourceType string) {
+		typeChannel := make(chan downloadBundle, downloadBuffer())
+		go downloadResources(client, resourceType, fhirSearchQuery, usePost, typeChannel)
+		for bundle := range typeChannel {
+			resChannel <- bundle
+		}
+	})
+}
+
 // downloadResources tries to download all resources of a given resource type from a FHIR server using
 // the given client. Resources that are downloaded can optionally be limited by a given FHIR search query.
 // The download respects pagination, i.e. it follows pagination links until there is no other next link.
@@ -371,11 +785,24 @@ func downloadResources(client *fhir.Client, resourceType string, fhirSearchQuery
 		resChannel <- downloadBundleError("could not parse the FHIR search query: %v\n", err)
 		return
 	}
+	if searchTotal != "" && !query.Has("_total") {
+		query.Set("_total", searchTotal)
+	}
+	var recovery *pagingRecovery
+	searchQuery := query
+	if recoverExpiredPages {
+		query.Set("_sort", "_lastUpdated")
+		recovery = newPagingRecovery()
+	}
 
 	var requestStart time.Time
 	var processingStart time.Time
 	var request *http.Request
 	var nextPageURL *url.URL
+	loopDetector := newPageLoopDetector(maxRepeatedPages)
+	// the total is only taken from the first page, and not again if the
+	// search is re-issued after the paging context expired
+	totalReported := false
 	for ok := true; ok; ok = nextPageURL != nil {
 		var stats networkStats
 
@@ -427,19 +854,19 @@ func downloadResources(client *fhir.Client, resourceType string, fhirSearchQuery
 			stats.requestDuration = time.Since(requestStart).Seconds()
 			stats.totalBytesIn += int64(len(responseBody))
 
-			outcome, err := fm.UnmarshalOperationOutcome(responseBody)
-			if err != nil {
-				bundle := downloadBundleError("request to FHIR server with URL %s had a non-ok response status (%d) but the expected operation outcome could not be parsed: %v", request.URL, response.StatusCode, err)
-				bundle.stats = &stats
-				resChannel <- bundle
-				return
+			if recovery != nil && nextPageURL != nil && recovery.recoveries < maxRecoveries &&
+				isExpiredPage(response.StatusCode, responseBody) {
+				recovery.recoveries++
+				query = recovery.query(searchQuery)
+				request = nil
+				fmt.Fprintf(os.Stderr, "The paging context expired at %s, re-issuing the search for resources last updated since %s.\n",
+					nextPageURL, recovery.lastUpdated)
+				continue
 			}
 
+			errorResponse := util.NewErrorResponse(response, responseBody)
 			bundle := downloadBundleError("request to FHIR server with URL %s had a non-ok response status (%d)", request.URL, response.StatusCode)
-			bundle.errResponse = &util.ErrorResponse{
-				StatusCode:       response.StatusCode,
-				OperationOutcome: &outcome,
-			}
+			bundle.errResponse = &errorResponse
 			bundle.stats = &stats
 			resChannel <- bundle
 			return
@@ -455,6 +882,7 @@ func downloadResources(client *fhir.Client, resourceType string, fhirSearchQuery
 		stats.totalBytesIn += int64(len(responseBody))
 
 		essentialResource := struct {
+			Total   *int            `bson:"total,omitempty" json:"total,omitempty"`
 			Entries json.RawMessage `bson:"entry,omitempty" json:"entry,omitempty"`
 			Links   []fm.BundleLink `bson:"link,omitempty" json:"link,omitempty"`
 		}{}
@@ -463,18 +891,106 @@ func downloadResources(client *fhir.Client, resourceType string, fhirSearchQuery
 			resChannel <- downloadBundleError("could not parse FHIR server response after request to URL %s: %v\n", request.URL, err)
 			return
 		}
-		resChannel <- downloadBundle{
+		rawEntries := []byte(essentialResource.Entries)
+		if recovery != nil {
+			if rawEntries, err = recovery.page(rawEntries); err != nil {
+				resChannel <- downloadBundleError("could not parse the entries of the FHIR server response after request to URL %s: %v\n", request.URL, err)
+				return
+			}
+		}
+		bundle := downloadBundle{
 			associatedRequestURL: *request.URL,
-			rawEntries:           essentialResource.Entries,
+			rawEntries:           rawEntries,
 			stats:                &stats,
 		}
+		if !totalReported {
+			bundle.total = essentialResource.Total
+			totalReported = true
+		}
+		resChannel <- bundle
 
-		nextPageURL, err = getNextPageURL(essentialResource.Links)
+		nextPageURL, err = getNextPageURL(essentialResource.Links, request.URL)
 		if err != nil {
 			resChannel <- downloadBundleError("could not parse the next page link within the FHIR server response after request to URL %s: %v\n", request.URL, err)
 			return
 		}
+		if nextPageURL == nil {
+			nextPageURL, err = getNextPageURLFromHeader(response.Header, request.URL)
+			if err != nil {
+				resChannel <- downloadBundleError("could not parse the Link header of the FHIR server response after request to URL %s: %v\n", request.URL, err)
+				return
+			}
+		}
+		if nextPageURL != nil && rewriteNextLinks {
+			nextPageURL = rewriteToBaseURL(nextPageURL, client.BaseURL())
+		}
+
+		if err := loopDetector.check(essentialResource.Entries, nextPageURL); err != nil {
+			resChannel <- downloadBundleError("aborting the download after request to URL %s: %v\n", request.URL, err)
+			return
+		}
+	}
+}
+
+// pageLoopDetector detects buggy servers which make the download loop forever
+// by returning the same next link or the same page content over and over again.
+type pageLoopDetector struct {
+	maxRepeats     int
+	nextURLCounts  map[string]int
+	lastHash       [sha256.Size]byte
+	repeatedHashes int
+}
+
+// newPageLoopDetector creates a pageLoopDetector which reports a loop as soon
+// as a page was repeated maxRepeats times. A maxRepeats of zero disables the
+// detection.
+func newPageLoopDetector(maxRepeats int) *pageLoopDetector {
+	return &pageLoopDetector{
+		maxRepeats:    maxRepeats,
+		nextURLCounts: make(map[string]int),
+	}
+}
+
+// check registers a downloaded page with its raw entries and the URL of the
+// next page. Returns an error if either the next page URL or the page content
+// was repeated maxRepeats times.
+func (d *pageLoopDetector) check(rawEntries []byte, nextPageURL *url.URL) error {
+	if d.maxRepeats <= 0 {
+		return nil
+	}
+
+	if nextPageURL != nil {
+		d.nextURLCounts[nextPageURL.String()]++
+		if count := d.nextURLCounts[nextPageURL.String()]; count > d.maxRepeats {
+			return fmt.Errorf("the server returned the next link %s %d times, which indicates a pagination loop", nextPageURL, count)
+		}
 	}
+
+	if len(rawEntries) > 0 {
+		hash := sha256.Sum256(rawEntries)
+		if hash == d.lastHash {
+			d.repeatedHashes++
+			if d.repeatedHashes >= d.maxRepeats {
+				return fmt.Errorf("the server returned %d identical pages in a row, which indicates a pagination loop", d.repeatedHashes+1)
+			}
+		} else {
+			d.lastHash = hash
+			d.repeatedHashes = 0
+		}
+	}
+
+	return nil
+}
+
+// selectedOutputs returns the number of output flags given.
+func selectedOutputs() int {
+	var n int
+	for _, output := range []string{outputFile, outputCmd, outputSqlite, outputPostgres, outputKafka} {
+		if output != "" {
+			n++
+		}
+	}
+	return n
 }
 
 // createOutputFileOrDie creates the output file at the given filepath if it does not already exist
@@ -555,23 +1071,58 @@ func writeResources(data *[]byte, sink io.Writer) (int, []*fm.OperationOutcome,
 // set of links.
 // The extraction respects the FHIR specification with regard to how links are
 // defined: https://www.iana.org/assignments/link-relations/link-relations.xhtml#link-relations-1
+// Relative URLs are resolved against the URL of the request.
 //
 // Returns the URL to the next resource bundle page if there is any or nil.
 // An error is returned if there is a URL, but it can not be parsed.
-func getNextPageURL(links []fm.BundleLink) (*url.URL, error) {
+func getNextPageURL(links []fm.BundleLink, requestURL *url.URL) (*url.URL, error) {
 	if len(links) == 0 {
 		return nil, nil
 	}
 
 	for _, link := range links {
 		if link.Relation == "next" {
-			return url.ParseRequestURI(link.Url)
+			nextPageURL, err := url.Parse(link.Url)
+			if err != nil {
+				return nil, err
+			}
+			return requestURL.ResolveReference(nextPageURL), nil
 		}
 	}
 
 	return nil, nil
 }
 
+// rewriteToBaseURL replaces scheme, user info and host of the given URL by the
+// ones of the base URL. This is useful for servers behind proxies which
+// generate links with their internal address.
+func rewriteToBaseURL(u *url.URL, baseURL url.URL) *url.URL {
+	rewritten := *u
+	rewritten.Scheme = baseURL.Scheme
+	rewritten.User = baseURL.User
+	rewritten.Host = baseURL.Host
+	return &rewritten
+}
+
+// getNextPageURLFromHeader extracts the URL to the next resource bundle page
+// from the Link headers of a response. Relative URLs are resolved against the
+// URL of the request.
+//
+// Returns the URL to the next resource bundle page if there is any or nil.
+// An error is returned if the Link header is malformed.
+func getNextPageURLFromHeader(header http.Header, requestURL *url.URL) (*url.URL, error) {
+	values := header.Values("Link")
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	links, err := fhir.ParseLinkHeader(values, requestURL)
+	if err != nil {
+		return nil, err
+	}
+	return fhir.NextLink(links), nil
+}
+
 func init() {
 	rootCmd.AddCommand(downloadCmd)
 
@@ -579,7 +1130,42 @@ func init() {
 	downloadCmd.Flags().StringVarP(&outputFile, "output-file", "o", "", "write to file instead of stdout")
 	downloadCmd.Flags().StringVarP(&fhirSearchQuery, "query", "q", "", "FHIR search query")
 	downloadCmd.Flags().BoolVarP(&usePost, "use-post", "p", false, "use POST to execute the search")
+	downloadCmd.Flags().BoolVar(&lintQuery, "lint-query", false, "warn about parameters of the query not advertised by the server for the resource type")
+	downloadCmd.Flags().BoolVar(&previewDownload, "preview", false, "print the number of resources to download and ask for confirmation first")
+	downloadCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "start the download after --preview without asking for confirmation")
+	downloadCmd.Flags().StringVar(&searchTotal, "total", "accurate", "the _total requested from the server to show the progress, one of accurate, estimate or none")
+	addConcurrencyFlag(downloadCmd, "number of resource types downloaded in parallel if more than one is given")
+	downloadCmd.Flags().IntVar(&maxRepeatedPages, "max-repeated-pages", 3, "abort once the server repeated a next link or the page content this many times (0 disables the check)")
+	downloadCmd.Flags().StringVar(&summaryFile, "summary-file", "", "also write the final or, on interrupt, partial statistics to this file")
+	downloadCmd.Flags().BoolVar(&consentFilter, "consent-filter", false, "exclude resources of patients without an active Consent permitting to share them")
+	addCohortFlags(downloadCmd)
+	downloadCmd.Flags().StringVar(&chunkByLastUpdated, "chunk-by-lastupdated", "", "split the download into sequential searches of _lastUpdated ranges of this size, like 1d, 2w or 12h")
+	downloadCmd.Flags().StringVar(&chunkStart, "chunk-start", "", "start the chunks at this FHIR instant or date instead of the last update of the oldest resource")
+	downloadCmd.Flags().StringVar(&chunkEnd, "chunk-end", "", "end the chunks at this FHIR instant or date instead of now")
+	downloadCmd.Flags().BoolVar(&recoverExpiredPages, "recover-expired-pages", false, "sort by _lastUpdated and re-issue the search for newer resources if the paging context expires")
+	downloadCmd.Flags().BoolVar(&suppressDuplicates, "suppress-duplicates", false, "skip resources whose type, id and version id were downloaded before")
+	downloadCmd.Flags().StringVar(&warmupFlag, "warmup", "", "exclude the first pages or seconds, like 10 or 30s, from the latency statistics")
+	downloadCmd.Flags().IntVar(&writeBuffer, "write-buffer", 16, "number of downloaded pages buffered for the writer")
+	downloadCmd.Flags().IntVar(&maxBufferedPages, "max-buffered-pages", 0, "buffer at most this many downloaded pages in total, stalling the download instead (0 uses --write-buffer)")
+	downloadCmd.Flags().Int64Var(&maxFileSize, "max-file-size", 0, "roll over into part files of at most this many bytes (0 disables)")
+	downloadCmd.Flags().IntVar(&maxResourcesPerFile, "max-resources-per-file", 0, "roll over into part files of at most this many resources (0 disables)")
+	downloadCmd.Flags().StringVar(&indexFile, "index", "", "write an index of the offsets of all resources in the output files to this file")
+	downloadCmd.Flags().BoolVar(&zstdOutput, "zstd", false, "write the output files as seekable zstd archives")
+	downloadCmd.Flags().StringVar(&outputCmd, "output-cmd", "", "pipe the output into the standard input of this shell command instead of stdout")
+	downloadCmd.Flags().StringVar(&outputSqlite, "output-sqlite", "", "write the resources into a new SQLite database using the sqlite3 command line shell")
+	downloadCmd.Flags().StringVar(&outputPostgres, "output-postgres", "", "bulk load the resources into a PostgreSQL table using psql with this connection string")
+	downloadCmd.Flags().StringVar(&postgresTable, "postgres-table", "resources", "the table to load the resources into with --output-postgres")
+	downloadCmd.Flags().StringVar(&postgresColumns, "postgres-columns", "id,type,last_updated,resource", "the columns for id, type, last updated and JSON of the resources with --output-postgres")
+	downloadCmd.Flags().StringVar(&outputKafka, "output-kafka", "", "publish the resources to Kafka using kcat with this comma separated list of brokers")
+	downloadCmd.Flags().StringVar(&kafkaTopic, "kafka-topic", "", "the Kafka topic to publish the resources to with --output-kafka")
+	downloadCmd.Flags().StringVar(&sortBy, "sort", "", "sort the output file by lastUpdated or id after the download")
+	downloadCmd.Flags().Int64Var(&sortBuffer, "sort-buffer", 64<<20, "number of bytes sorted in memory before spilling to temporary files with --sort")
+	downloadCmd.Flags().StringVar(&manifestFile, "manifest", "", "write a manifest with resource counts, sizes and SHA-256 checksums of the output files to this file")
+	downloadCmd.Flags().StringVar(&signKeyFile, "sign-key", "", "sign the manifest with this PEM encoded PKCS #8 private key")
+	downloadCmd.Flags().BoolVar(&rewriteNextLinks, "rewrite-next-links", false, "rewrite scheme and host of next links to the ones of the server base URL")
 
 	_ = downloadCmd.MarkFlagRequired("server")
 	_ = downloadCmd.MarkFlagFilename("output-file", "ndjson")
+	_ = downloadCmd.MarkFlagFilename("manifest", "json")
+	_ = downloadCmd.MarkFlagFilename("index", "ndjson")
 }
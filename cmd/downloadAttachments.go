@@ -0,0 +1,301 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var attachmentsOutputDir string
+
+// attachmentExtensions are the file extensions used for common content types
+// of attachments. Attachments of other content types are written without
+// extension.
+var attachmentExtensions = map[string]string{
+	"application/pdf":   ".pdf",
+	"application/json":  ".json",
+	"application/xml":   ".xml",
+	"application/dicom": ".dcm",
+	"image/jpeg":        ".jpg",
+	"image/png":         ".png",
+	"image/gif":         ".gif",
+	"image/tiff":        ".tiff",
+	"text/plain":        ".txt",
+	"text/html":         ".html",
+	"text/xml":          ".xml",
+	"text/csv":          ".csv",
+}
+
+// attachmentStats are the statistics of the download of attachments.
+type attachmentStats struct {
+	documents   int
+	attachments int
+	failed      int
+	bytesIn     int64
+	duration    time.Duration
+}
+
+func (s attachmentStats) format(units util.UnitFormat) string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Documents        [total]                               %d\n", s.documents))
+	builder.WriteString(fmt.Sprintf("Attachments      [total, failed]                       %d, %d\n", s.attachments, s.failed))
+	builder.WriteString(fmt.Sprintf("Bytes In         [total]                               %s\n", units.Bytes(float64(s.bytesIn))))
+	builder.WriteString(fmt.Sprintf("Duration         [total]                               %s\n", units.Duration(s.duration)))
+	return builder.String()
+}
+
+// attachmentFileName returns the name of the file of the attachment with the
+// given index of the DocumentReference with the given id. The index is only
+// appended if the DocumentReference has more than one attachment.
+func attachmentFileName(id string, index int, count int, contentType *string) string {
+	name := id
+	if count > 1 {
+		name = fmt.Sprintf("%s-%d", id, index+1)
+	}
+	if contentType != nil {
+		if mediaType, _, err := mime.ParseMediaType(*contentType); err == nil {
+			name += attachmentExtensions[mediaType]
+		}
+	}
+	return name
+}
+
+// resolveAttachmentURL resolves the URL of an attachment against the base URL
+// of the server. Absolute URLs not pointing to the server are rejected, so that
+// credentials aren't sent to other hosts.
+func resolveAttachmentURL(baseURL url.URL, attachmentURL string) (*url.URL, error) {
+	u, err := url.Parse(attachmentURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attachment URL `%s`: %v", attachmentURL, err)
+	}
+	base := baseURL
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+	if !u.IsAbs() {
+		return base.ResolveReference(u), nil
+	}
+	if !strings.HasPrefix(u.String(), base.String()) {
+		return nil, fmt.Errorf("the attachment URL %s doesn't point to the server", attachmentURL)
+	}
+	return u, nil
+}
+
+// fetchAttachment fetches the content at u and writes it to w. The content type
+// of the attachment is requested, so that Binary endpoints return the raw
+// content. If the server returns a Binary resource instead, its data is
+// decoded.
+func fetchAttachment(client *fhir.Client, u *url.URL, contentType *string, w io.Writer) (int64, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if contentType != nil {
+		req.Header.Set("Accept", *contentType)
+	} else {
+		req.Header.Set("Accept", "*/*")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return 0, fmt.Errorf("error while fetching the attachment at %s:\n\n%s", u, errorResponse.String())
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/fhir+json" {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		binary, err := fm.UnmarshalBinary(body)
+		if err != nil {
+			return 0, fmt.Errorf("error while reading the Binary resource at %s: %v", u, err)
+		}
+		if binary.Data == nil {
+			return 0, fmt.Errorf("the Binary resource at %s has no data", u)
+		}
+		return writeBase64(w, *binary.Data)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// writeBase64 decodes the base64 encoded data and writes it to w.
+func writeBase64(w io.Writer, data string) (int64, error) {
+	return io.Copy(w, base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+}
+
+// downloadAttachment writes the content of attachment into a new file with
+// the given name in dir. Inline data is decoded and URLs are fetched from the
+// server. Existing files are not overwritten.
+func downloadAttachment(client *fhir.Client, dir string, name string, attachment fm.Attachment) (int64, error) {
+	if attachment.Data == nil && attachment.Url == nil {
+		return 0, fmt.Errorf("the attachment %s has neither data nor url", name)
+	}
+	var u *url.URL
+	if attachment.Data == nil {
+		var err error
+		if u, err = resolveAttachmentURL(client.BaseURL(), *attachment.Url); err != nil {
+			return 0, err
+		}
+	}
+
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if attachment.Data != nil {
+		n, err = writeBase64(file, *attachment.Data)
+	} else {
+		n, err = fetchAttachment(client, u, attachment.ContentType, file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return 0, err
+	}
+	return n, nil
+}
+
+// downloadDocumentAttachments downloads all attachments of the
+// DocumentReference resources of rawEntries into dir. Failed attachments are
+// reported on stderr and counted in stats.
+func downloadDocumentAttachments(client *fhir.Client, dir string, rawEntries []byte, stats *attachmentStats) error {
+	if len(rawEntries) == 0 {
+		return nil
+	}
+	var entries []fm.BundleEntry
+	if err := json.Unmarshal(rawEntries, &entries); err != nil {
+		return fmt.Errorf("could not parse the bundle entries from JSON: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode != fm.SearchEntryModeMatch {
+			continue
+		}
+		documentReference, err := fm.UnmarshalDocumentReference(entry.Resource)
+		if err != nil {
+			return fmt.Errorf("could not parse a DocumentReference from JSON: %v", err)
+		}
+		if documentReference.Id == nil {
+			continue
+		}
+		stats.documents++
+
+		for i, content := range documentReference.Content {
+			name := attachmentFileName(*documentReference.Id, i, len(documentReference.Content), content.Attachment.ContentType)
+			stats.attachments++
+			n, err := downloadAttachment(client, dir, name, content.Attachment)
+			if err != nil {
+				stats.failed++
+				fmt.Fprintf(os.Stderr, "Failed to download the attachment of DocumentReference/%s: %v\n", *documentReference.Id, err)
+				continue
+			}
+			stats.bytesIn += n
+		}
+	}
+	return nil
+}
+
+var downloadAttachmentsCmd = &cobra.Command{
+	Use:   "download-attachments",
+	Short: "Download the attachments of DocumentReferences",
+	Long: `Searches DocumentReference resources and downloads the content of their
+attachments into files in the output directory.
+
+The files are named by the id of the DocumentReference, followed by the
+position of the attachment if there is more than one, and an extension
+derived from the content type. Existing files are not overwritten.
+
+Inline data is decoded. Attachment URLs, usually pointing to Binary
+resources, are fetched from the server requesting the content type of the
+attachment, so that the raw content is returned. Relative URLs are resolved
+against the server base URL. URLs pointing to other hosts are not fetched.
+
+The --query flag will take an optional FHIR search query that will be used
+to constrain the DocumentReferences.
+
+Examples:
+  blazectl download-attachments --server http://localhost:8080/fhir -d documents
+  blazectl download-attachments --server http://localhost:8080/fhir -q "patient=Patient/0" -d documents`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(attachmentsOutputDir, 0755); err != nil {
+			return err
+		}
+
+		var stats attachmentStats
+		startTime := time.Now()
+
+		bundleChannel := make(chan downloadBundle, 2)
+		go downloadResources(client, "DocumentReference", fhirSearchQuery, false, bundleChannel)
+
+		for bundle := range bundleChannel {
+			if bundle.err != nil || bundle.errResponse != nil {
+				fmt.Printf("Failed to download DocumentReferences: %v\n", bundle.err)
+				if bundle.errResponse != nil {
+					fmt.Print(util.Indent(2, bundle.errResponse.String()))
+				}
+				os.Exit(1)
+			}
+			if err := downloadDocumentAttachments(client, attachmentsOutputDir, bundle.rawEntries, &stats); err != nil {
+				fmt.Printf("Failed to download attachments received from request to URL %s: %v\n", bundle.associatedRequestURL.String(), err)
+				os.Exit(2)
+			}
+		}
+		client.CloseIdleConnections()
+
+		stats.duration = time.Since(startTime)
+		fmt.Fprint(os.Stderr, stats.format(unitFormat()))
+		if stats.failed > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(downloadAttachmentsCmd)
+
+	downloadAttachmentsCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	downloadAttachmentsCmd.Flags().StringVarP(&fhirSearchQuery, "query", "q", "", "FHIR search query for DocumentReferences")
+	downloadAttachmentsCmd.Flags().StringVarP(&attachmentsOutputDir, "output-dir", "d", "", "directory to write the attachments to")
+
+	_ = downloadAttachmentsCmd.MarkFlagRequired("server")
+	_ = downloadAttachmentsCmd.MarkFlagRequired("output-dir")
+}
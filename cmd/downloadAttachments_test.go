@@ -0,0 +1,104 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAttachmentFileName(t *testing.T) {
+	pdf, unknown := "application/pdf; charset=binary", "application/x-foo"
+
+	assert.Equal(t, "0.pdf", attachmentFileName("0", 0, 1, &pdf))
+	assert.Equal(t, "0-2.pdf", attachmentFileName("0", 1, 2, &pdf))
+	assert.Equal(t, "0", attachmentFileName("0", 0, 1, &unknown))
+	assert.Equal(t, "0", attachmentFileName("0", 0, 1, nil))
+}
+
+func TestResolveAttachmentURL(t *testing.T) {
+	baseURL, _ := url.Parse("http://localhost:8080/fhir")
+
+	t.Run("relative", func(t *testing.T) {
+		u, err := resolveAttachmentURL(*baseURL, "Binary/0")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "http://localhost:8080/fhir/Binary/0", u.String())
+		}
+	})
+
+	t.Run("absolute on server", func(t *testing.T) {
+		u, err := resolveAttachmentURL(*baseURL, "http://localhost:8080/fhir/Binary/0")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "http://localhost:8080/fhir/Binary/0", u.String())
+		}
+	})
+
+	t.Run("other host", func(t *testing.T) {
+		_, err := resolveAttachmentURL(*baseURL, "http://example.com/fhir/Binary/0")
+		assert.EqualError(t, err, "the attachment URL http://example.com/fhir/Binary/0 doesn't point to the server")
+	})
+}
+
+func TestDownloadDocumentAttachments(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Binary/raw":
+			assert.Equal(t, "text/plain", r.Header.Get("Accept"))
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("raw content"))
+		case "/Binary/resource":
+			w.Header().Set("Content-Type", "application/fhir+json")
+			_, _ = w.Write([]byte(`{"resourceType": "Binary", "contentType": "text/plain", "data": "YmluYXJ5"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+	dir := t.TempDir()
+
+	rawEntries := []byte(`[
+      {"resource": {"resourceType": "DocumentReference", "id": "0", "status": "current",
+       "content": [{"attachment": {"contentType": "text/plain", "data": "aW5saW5l"}}]}, "search": {"mode": "match"}},
+      {"resource": {"resourceType": "DocumentReference", "id": "1", "status": "current",
+       "content": [{"attachment": {"contentType": "text/plain", "url": "Binary/raw"}},
+                   {"attachment": {"url": "` + ts.URL + `/Binary/resource"}},
+                   {"attachment": {"url": "Binary/missing"}}]}, "search": {"mode": "match"}},
+      {"resource": {"resourceType": "Patient", "id": "0"}, "search": {"mode": "include"}}
+    ]`)
+
+	var stats attachmentStats
+	err := downloadDocumentAttachments(client, dir, rawEntries, &stats)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, attachmentStats{documents: 2, attachments: 4, failed: 1, bytesIn: 23}, stats)
+
+		content, _ := os.ReadFile(filepath.Join(dir, "0.txt"))
+		assert.Equal(t, "inline", string(content))
+		content, _ = os.ReadFile(filepath.Join(dir, "1-1.txt"))
+		assert.Equal(t, "raw content", string(content))
+		content, _ = os.ReadFile(filepath.Join(dir, "1-2"))
+		assert.Equal(t, "binary", string(content))
+		assert.NoFileExists(t, filepath.Join(dir, "1-3"))
+	}
+}
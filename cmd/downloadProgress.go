@@ -0,0 +1,220 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/samply/blazectl/util"
+	"github.com/vbauerster/mpb/v7"
+	"github.com/vbauerster/mpb/v7/cwriter"
+	"github.com/vbauerster/mpb/v7/decor"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// searchTotal is the _total parameter added to the searches of downloads. It's
+// not added if empty or if the query already contains _total.
+var searchTotal string
+
+// checkSearchTotal checks the value of --total.
+func checkSearchTotal(value string) error {
+	switch value {
+	case "accurate", "estimate", "none":
+		return nil
+	default:
+		return fmt.Errorf("invalid --total value `%s`, expected one of accurate, estimate or none", value)
+	}
+}
+
+// downloadProgress shows the progress of a download. Progress is shown on
+// stderr, because the downloaded resources may go to stdout.
+type downloadProgress interface {
+	// addTotal adds the total reported by a search to the number of resources
+	// to download.
+	addTotal(total int)
+	// increment adds the resources of a written page.
+	increment(resources int, bytes int64)
+	// finish stops showing the progress.
+	finish()
+}
+
+// createDownloadProgress creates the progress selected by --progress and
+// --no-progress like createProgress, but on stderr.
+func createDownloadProgress() (downloadProgress, error) {
+	mode := progressMode
+	if noProgress {
+		mode = "none"
+	}
+	switch mode {
+	case "auto":
+		if cwriter.IsTerminal(int(os.Stderr.Fd())) {
+			return newBarDownloadProgress(os.Stderr), nil
+		}
+		return newPlainDownloadProgress(os.Stderr, plainProgressInterval), nil
+	case "bar":
+		return newBarDownloadProgress(os.Stderr), nil
+	case "plain":
+		return newPlainDownloadProgress(os.Stderr, plainProgressInterval), nil
+	case "none":
+		return noopDownloadProgress{}, nil
+	default:
+		return nil, fmt.Errorf("invalid --progress value `%s`, expected one of auto, bar, plain or none", progressMode)
+	}
+}
+
+// fmtDownloadCount formats the number of downloaded resources together with
+// the total and the percentage if the total is known.
+func fmtDownloadCount(resources int64, total int64, totalKnown bool) string {
+	if !totalKnown {
+		return fmt.Sprintf("%d resources", resources)
+	}
+	percentage := 100.0
+	if total > 0 {
+		percentage = min(100, float64(resources)/float64(total)*100)
+	}
+	return fmt.Sprintf("%5.1f %% (%d of %d resources)", percentage, resources, total)
+}
+
+type barDownloadProgress struct {
+	progress   *mpb.Progress
+	bar        *mpb.Bar
+	resources  *atomic.Int64
+	bytes      *atomic.Int64
+	total      *atomic.Int64
+	totalKnown *atomic.Bool
+}
+
+// newBarDownloadProgress creates a progress bar whose total grows with the
+// totals reported by the searches. As long as no total is known, only the
+// number of downloaded resources is shown.
+func newBarDownloadProgress(out io.Writer) barDownloadProgress {
+	p := mpb.New(mpb.WithOutput(out))
+	resources := &atomic.Int64{}
+	bytes := &atomic.Int64{}
+	total := &atomic.Int64{}
+	totalKnown := &atomic.Bool{}
+	return barDownloadProgress{progress: p,
+		bar: p.AddBar(0,
+			mpb.BarRemoveOnComplete(),
+			mpb.PrependDecorators(
+				decor.Name("download", decor.WC{W: 9, C: decor.DidentRight}),
+			),
+			mpb.AppendDecorators(
+				decor.Any(func(_ decor.Statistics) string {
+					return fmtDownloadCount(resources.Load(), total.Load(), totalKnown.Load())
+				}, decor.WC{W: 36}),
+				throughputDecorator(newThroughputMeter(time.Now, resources, bytes)),
+			),
+		),
+		resources:  resources,
+		bytes:      bytes,
+		total:      total,
+		totalKnown: totalKnown,
+	}
+}
+
+func (p barDownloadProgress) addTotal(total int) {
+	p.totalKnown.Store(true)
+	p.bar.SetTotal(p.total.Add(int64(total)), false)
+}
+
+func (p barDownloadProgress) increment(resources int, bytes int64) {
+	p.resources.Add(int64(resources))
+	p.bytes.Add(bytes)
+	p.bar.IncrBy(resources)
+}
+
+func (p barDownloadProgress) finish() {
+	p.bar.SetTotal(-1, true)
+	p.progress.Wait()
+}
+
+// plainDownloadProgress prints the progress of the download as a line every
+// interval instead of a progress bar.
+type plainDownloadProgress struct {
+	out              io.Writer
+	start            time.Time
+	mutex            sync.Mutex
+	total, resources int64
+	totalKnown       bool
+	bytes            int64
+	stop             chan struct{}
+	stopped          chan struct{}
+}
+
+func newPlainDownloadProgress(out io.Writer, interval time.Duration) *plainDownloadProgress {
+	p := &plainDownloadProgress{out: out, start: time.Now(), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.printLine()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *plainDownloadProgress) printLine() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	elapsed := time.Since(p.start).Seconds()
+	fmt.Fprintf(p.out, "download %s, %.0f res/s, %s/s\n", fmtDownloadCount(p.resources, p.total, p.totalKnown),
+		float64(p.resources)/elapsed, util.FmtBytesHumanReadable(float32(float64(p.bytes)/elapsed)))
+}
+
+func (p *plainDownloadProgress) addTotal(total int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.total += int64(total)
+	p.totalKnown = true
+}
+
+func (p *plainDownloadProgress) increment(resources int, bytes int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.resources += int64(resources)
+	p.bytes += bytes
+}
+
+// finish stops printing lines. The summary of the download follows instead of
+// a last line.
+func (p *plainDownloadProgress) finish() {
+	close(p.stop)
+	<-p.stopped
+}
+
+type noopDownloadProgress struct {
+}
+
+func (noopDownloadProgress) addTotal(_ int) {
+	// nothing to do here
+}
+
+func (noopDownloadProgress) increment(_ int, _ int64) {
+	// nothing to do here
+}
+
+func (noopDownloadProgress) finish() {
+	// nothing to do here
+}
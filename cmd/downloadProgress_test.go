@@ -0,0 +1,111 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCheckSearchTotal(t *testing.T) {
+	assert.NoError(t, checkSearchTotal("accurate"))
+	assert.NoError(t, checkSearchTotal("estimate"))
+	assert.NoError(t, checkSearchTotal("none"))
+	assert.EqualError(t, checkSearchTotal("exact"), "invalid --total value `exact`, expected one of accurate, estimate or none")
+}
+
+func TestFmtDownloadCount(t *testing.T) {
+	assert.Equal(t, "42 resources", fmtDownloadCount(42, 0, false))
+	assert.Equal(t, " 25.0 % (25 of 100 resources)", fmtDownloadCount(25, 100, true))
+	assert.Equal(t, "100.0 % (110 of 100 resources)", fmtDownloadCount(110, 100, true))
+	assert.Equal(t, "100.0 % (0 of 0 resources)", fmtDownloadCount(0, 0, true))
+}
+
+func TestCreateDownloadProgress(t *testing.T) {
+	defer func() {
+		progressMode = "auto"
+		noProgress = false
+	}()
+
+	t.Run("none", func(t *testing.T) {
+		progressMode = "none"
+
+		progress, err := createDownloadProgress()
+
+		assert.NoError(t, err)
+		assert.IsType(t, noopDownloadProgress{}, progress)
+	})
+
+	t.Run("no-progress", func(t *testing.T) {
+		progressMode = "bar"
+		noProgress = true
+
+		progress, err := createDownloadProgress()
+
+		assert.NoError(t, err)
+		assert.IsType(t, noopDownloadProgress{}, progress)
+		noProgress = false
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		progressMode = "foo"
+
+		_, err := createDownloadProgress()
+
+		assert.EqualError(t, err, "invalid --progress value `foo`, expected one of auto, bar, plain or none")
+	})
+}
+
+func TestPlainDownloadProgress(t *testing.T) {
+	t.Run("with total", func(t *testing.T) {
+		var out bytes.Buffer
+		progress := newPlainDownloadProgress(&out, time.Hour)
+		progress.addTotal(60)
+		progress.addTotal(40)
+		progress.increment(25, 1024)
+
+		progress.printLine()
+		progress.finish()
+
+		assert.Regexp(t, regexp.MustCompile(`^download  25\.0 % \(25 of 100 resources\), \d+ res/s, .+/s\n$`), out.String())
+	})
+
+	t.Run("without total", func(t *testing.T) {
+		var out bytes.Buffer
+		progress := newPlainDownloadProgress(&out, time.Hour)
+		progress.increment(25, 1024)
+
+		progress.printLine()
+		progress.finish()
+
+		assert.Regexp(t, regexp.MustCompile(`^download 25 resources, \d+ res/s, .+/s\n$`), out.String())
+	})
+}
+
+func TestBarDownloadProgress(t *testing.T) {
+	var out bytes.Buffer
+	progress := newBarDownloadProgress(&out)
+	progress.addTotal(2)
+	progress.increment(1, 100)
+	progress.increment(1, 100)
+
+	progress.finish()
+
+	assert.Equal(t, int64(2), progress.resources.Load())
+	assert.Equal(t, int64(2), progress.total.Load())
+}
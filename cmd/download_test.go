@@ -19,14 +19,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
 	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestDownloadResources(t *testing.T) {
@@ -306,6 +311,433 @@ func TestDownloadResources(t *testing.T) {
 		assert.Equal(t, 2, bundles)
 		assert.Equal(t, 2, requestCounter)
 	})
+
+	t.Run("Total", func(t *testing.T) {
+		searchTotal = "accurate"
+		defer func() { searchTotal = "" }()
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/Patient" {
+				assert.Equal(t, "accurate", r.URL.Query().Get("_total"))
+				_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "total": 2,
+					"entry": [{"resource": {"resourceType": "Patient", "id": "0"}}],
+					"link": [{"relation": "next", "url": "` + server.URL + `/__page"}]}`))
+			} else {
+				_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "total": 2,
+					"entry": [{"resource": {"resourceType": "Patient", "id": "1"}}]}`))
+			}
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		bundleChannel := make(chan downloadBundle)
+		go downloadResources(client, "Patient", "", false, bundleChannel)
+
+		var totals []*int
+		for bundle := range bundleChannel {
+			assert.Nil(t, bundle.err)
+			totals = append(totals, bundle.total)
+		}
+		if assert.Len(t, totals, 2) {
+			assert.Equal(t, 2, *totals[0])
+			assert.Nil(t, totals[1])
+		}
+	})
+
+	t.Run("TotalInQuery", func(t *testing.T) {
+		searchTotal = "accurate"
+		defer func() { searchTotal = "" }()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, []string{"estimate"}, r.URL.Query()["_total"])
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset"}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		bundleChannel := make(chan downloadBundle)
+		go downloadResources(client, "Patient", "_total=estimate", false, bundleChannel)
+
+		for bundle := range bundleChannel {
+			assert.Nil(t, bundle.err)
+			assert.Nil(t, bundle.total)
+		}
+	})
+
+	t.Run("PaginationLoop", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			searchMode := fm.SearchEntryModeMatch
+			response := fm.Bundle{
+				Type: fm.BundleTypeSearchset,
+				Entry: []fm.BundleEntry{{
+					Resource: []byte("{\"foo\": \"bar\"}"),
+					Search: &fm.BundleEntrySearch{
+						Mode: &searchMode,
+					},
+				}},
+				Link: []fm.BundleLink{{
+					Relation: "next",
+					Url:      "__page/1",
+				}},
+			}
+
+			encoder := json.NewEncoder(w)
+			if err := encoder.Encode(response); err != nil {
+				t.Error(err)
+			}
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		var lastBundle downloadBundle
+		bundleChannel := make(chan downloadBundle)
+
+		go downloadResources(client, "foo", "", false, bundleChannel)
+		for bundle := range bundleChannel {
+			lastBundle = bundle
+		}
+		assert.ErrorContains(t, lastBundle.err, "pagination loop")
+	})
+}
+
+func TestDownloadTypesResources(t *testing.T) {
+	var mutex sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		paths = append(paths, r.URL.Path)
+		mutex.Unlock()
+		searchMode := fm.SearchEntryModeMatch
+		response := fm.Bundle{
+			Type: fm.BundleTypeSearchset,
+			Entry: []fm.BundleEntry{{
+				Resource: []byte(fmt.Sprintf("{\"resourceType\": \"%s\"}", r.URL.Path[1:])),
+				Search:   &fm.BundleEntrySearch{Mode: &searchMode},
+			}},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	var entries []string
+	bundleChannel := make(chan downloadBundle)
+
+	go downloadTypesResources(client, []string{"Patient", "Observation", "Condition"}, "", false, 2, bundleChannel)
+	for bundle := range bundleChannel {
+		assert.Nil(t, bundle.err)
+		entries = append(entries, string(bundle.rawEntries))
+	}
+
+	sort.Strings(paths)
+	assert.Equal(t, []string{"/Condition", "/Observation", "/Patient"}, paths)
+	assert.Len(t, entries, 3)
+}
+
+func TestCompleteResourceTypes(t *testing.T) {
+	t.Run("BuiltIn", func(t *testing.T) {
+		server = ""
+
+		completions, directive := completeResourceTypes(0)(downloadCmd, []string{"Patient"}, "")
+
+		assert.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive)
+		assert.Contains(t, completions, "Observation")
+		assert.NotContains(t, completions, "Patient")
+	})
+
+	t.Run("FromServer", func(t *testing.T) {
+		fhirServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/metadata", r.URL.Path)
+			_, _ = w.Write([]byte(`{"resourceType": "CapabilityStatement", "rest": [{"mode": "server", "resource": [
+  {"type": "Patient", "interaction": [{"code": "search-type"}]},
+  {"type": "Observation", "interaction": [{"code": "search-type"}]},
+  {"type": "Binary", "interaction": [{"code": "read"}]}
+]}]}`))
+		}))
+		defer fhirServer.Close()
+		server = fhirServer.URL
+		defer func() { server = "" }()
+
+		completions, _ := completeResourceTypes(0)(downloadCmd, nil, "")
+
+		assert.Equal(t, []string{"Patient", "Observation"}, completions)
+	})
+
+	t.Run("MaxArgs", func(t *testing.T) {
+		server = ""
+
+		completions, _ := completeResourceTypes(1)(tailCmd, []string{"Patient"}, "")
+
+		assert.Empty(t, completions)
+	})
+}
+
+func TestDownloadStats(t *testing.T) {
+	stats := newDownloadStats(&commandStats{})
+
+	stats.Add(commandStats{totalPages: 1, requestDurations: util.NewDurationDigest(2), totalBytesIn: 10, warmupPages: 1})
+	stats.Add(commandStats{resourcesPerPage: []int{3}, bytesOut: 20, writeDuration: time.Second})
+	stats.Add(commandStats{totalPages: 1, requestDurations: util.NewDurationDigest(1), totalBytesIn: 5})
+
+	snapshot := stats.Snapshot()
+	_ = snapshot.String()
+	stats.Add(commandStats{writeStalls: time.Second})
+
+	// String sorts the snapshot without touching the aggregated stats
+	assert.Equal(t, 2, snapshot.totalPages)
+	assert.Equal(t, time.Duration(0), snapshot.writeStalls)
+	final := stats.Final()
+	assert.Equal(t, 2, final.totalPages)
+	assert.Equal(t, util.NewDurationDigest(1), final.requestDurations)
+	assert.Equal(t, []int{3}, final.resourcesPerPage)
+	assert.Equal(t, int64(15), final.totalBytesIn)
+	assert.Equal(t, int64(20), final.bytesOut)
+	assert.Equal(t, 1, final.warmupPages)
+	assert.Equal(t, time.Second, final.writeStalls)
+}
+
+func TestCommandStatsString(t *testing.T) {
+	stats := commandStats{
+		totalPages:          2,
+		resourcesPerPage:    []int{10, 20},
+		requestDurations:    util.NewDurationDigest(0.5, 1.5),
+		processingDurations: util.NewDurationDigest(0.25, 1.25),
+		totalBytesIn:        3072,
+		totalDuration:       62 * time.Second,
+	}
+
+	t.Run("humanized", func(t *testing.T) {
+		assert.Equal(t, `Pages		[total]			2
+Resources 	[total]			30
+Resources/Page	[min, mean, max]	10, 15, 20
+Duration	[total]			1m2s
+Requ. Latencies	[min, mean, 50, 95, 99, max, stddev]	500ms, 1s, 500ms, 1.4s, 1.48s, 1.5s, 707ms
+Proc. Latencies	[min, mean, 50, 95, 99, max, stddev]	250ms, 750ms, 250ms, 1.15s, 1.23s, 1.25s, 707ms
+Bytes In	[total, mean]		3.00 KiB, 1.50 KiB
+`, stats.String())
+	})
+
+	t.Run("raw units", func(t *testing.T) {
+		rawUnits = true
+		defer func() { rawUnits = false }()
+
+		assert.Equal(t, `Pages		[total]			2
+Resources 	[total]			30
+Resources/Page	[min, mean, max]	10, 15, 20
+Duration	[total]			62.000
+Requ. Latencies	[min, mean, 50, 95, 99, max, stddev]	0.500, 1.000, 0.500, 1.400, 1.480, 1.500, 0.707
+Proc. Latencies	[min, mean, 50, 95, 99, max, stddev]	0.250, 0.750, 0.250, 1.150, 1.230, 1.250, 0.707
+Bytes In	[total, mean]		3072, 1536
+`, stats.String())
+	})
+
+	t.Run("warm-up", func(t *testing.T) {
+		statsWarmup = warmup{samples: 1}
+		defer func() { statsWarmup = warmup{} }()
+		stats := commandStats{
+			totalPages:          3,
+			resourcesPerPage:    []int{10, 10, 10},
+			requestDurations:    util.NewDurationDigest(0.5, 1.5),
+			processingDurations: util.NewDurationDigest(0.25, 1.25),
+			warmupPages:         1,
+			totalBytesIn:        3072,
+			totalDuration:       62 * time.Second,
+		}
+
+		assert.Equal(t, `Pages		[total]			3
+Resources 	[total]			30
+Resources/Page	[min, mean, max]	10, 10, 10
+Duration	[total]			1m2s
+Warm-up		[excluded, given]	1, 1
+Requ. Latencies	[min, mean, 50, 95, 99, max, stddev]	500ms, 1s, 500ms, 1.4s, 1.48s, 1.5s, 707ms
+Proc. Latencies	[min, mean, 50, 95, 99, max, stddev]	250ms, 750ms, 250ms, 1.15s, 1.23s, 1.25s, 707ms
+Bytes In	[total, mean]		3.00 KiB, 1.00 KiB
+`, stats.String())
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		suppressDuplicates = true
+		defer func() { suppressDuplicates = false }()
+		stats := stats
+		stats.duplicateResources = 5
+
+		assert.Contains(t, stats.String(), "Resources/Page	[min, mean, max]	10, 15, 20\nDuplicates	[suppressed]		5\nDuration")
+	})
+
+	t.Run("write throughput", func(t *testing.T) {
+		stats := stats
+		stats.bytesOut = 2048
+		stats.writeDuration = 500 * time.Millisecond
+		stats.writeStalls = 2 * time.Second
+
+		assert.Contains(t, stats.String(), `Bytes In	[total, mean]		3.00 KiB, 1.50 KiB
+Bytes Out	[total, write rate]	2.00 KiB, 4.00 KiB/s
+Write Stalls	[total]			2s
+`)
+	})
+
+	t.Run("write throughput without write duration", func(t *testing.T) {
+		for _, bytesOut := range []int64{0, 2048} {
+			stats := stats
+			stats.bytesOut = bytesOut
+
+			assert.NotContains(t, stats.String(), "Inf")
+			assert.NotContains(t, stats.String(), "NaN")
+		}
+		stats := stats
+		stats.bytesOut = 2048
+
+		assert.Contains(t, stats.String(), "Bytes Out	[total]			2.00 KiB\nWrite Stalls	[total]			0s\n")
+	})
+
+	t.Run("peak memory", func(t *testing.T) {
+		stats := stats
+		stats.peakMemory = 48 << 20
+
+		assert.Contains(t, stats.String(), "Bytes In	[total, mean]		3.00 KiB, 1.50 KiB\nMemory		[peak]			48.00 MiB\n")
+	})
+}
+
+func TestBufferedPages(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		assert.Equal(t, 2, downloadBuffer())
+		assert.Equal(t, writeBuffer, writerBuffer())
+	})
+
+	t.Run("MaxBufferedPages", func(t *testing.T) {
+		maxBufferedPages = 4
+		defer func() { maxBufferedPages = 0 }()
+
+		assert.Equal(t, 0, downloadBuffer())
+		assert.Equal(t, 4, writerBuffer())
+	})
+}
+
+func TestPageLoopDetector(t *testing.T) {
+	nextPageURL, _ := url.ParseRequestURI("http://localhost:8080/fhir/__page/1")
+
+	t.Run("RepeatedNextLink", func(t *testing.T) {
+		detector := newPageLoopDetector(2)
+
+		assert.Nil(t, detector.check([]byte("[1]"), nextPageURL))
+		assert.Nil(t, detector.check([]byte("[2]"), nextPageURL))
+		assert.NotNil(t, detector.check([]byte("[3]"), nextPageURL))
+	})
+
+	t.Run("RepeatedPageContent", func(t *testing.T) {
+		detector := newPageLoopDetector(2)
+
+		for i := 0; i < 2; i++ {
+			u := nextPageURL.JoinPath(fmt.Sprint(i))
+			assert.Nil(t, detector.check([]byte("[1]"), u))
+		}
+		assert.NotNil(t, detector.check([]byte("[1]"), nextPageURL.JoinPath("2")))
+	})
+
+	t.Run("ChangingPageContent", func(t *testing.T) {
+		detector := newPageLoopDetector(2)
+
+		for i := 0; i < 5; i++ {
+			u := nextPageURL.JoinPath(fmt.Sprint(i))
+			assert.Nil(t, detector.check([]byte(fmt.Sprintf("[%d]", i)), u))
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		detector := newPageLoopDetector(0)
+
+		for i := 0; i < 5; i++ {
+			assert.Nil(t, detector.check([]byte("[1]"), nextPageURL))
+		}
+	})
+}
+
+func TestGetNextPageURL(t *testing.T) {
+	requestURL, _ := url.ParseRequestURI("http://localhost:8080/fhir/Patient?_count=10")
+
+	t.Run("NoLinks", func(t *testing.T) {
+		nextPageURL, err := getNextPageURL(nil, requestURL)
+
+		assert.Nil(t, err)
+		assert.Nil(t, nextPageURL)
+	})
+
+	t.Run("AbsoluteNextLink", func(t *testing.T) {
+		links := []fm.BundleLink{{Relation: "next", Url: "http://other:8080/fhir/__page/1"}}
+
+		nextPageURL, err := getNextPageURL(links, requestURL)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://other:8080/fhir/__page/1", nextPageURL.String())
+	})
+
+	t.Run("RelativeNextLink", func(t *testing.T) {
+		links := []fm.BundleLink{{Relation: "next", Url: "__page/1?_count=10"}}
+
+		nextPageURL, err := getNextPageURL(links, requestURL)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://localhost:8080/fhir/__page/1?_count=10", nextPageURL.String())
+	})
+
+	t.Run("InvalidNextLink", func(t *testing.T) {
+		links := []fm.BundleLink{{Relation: "next", Url: "http://[::1"}}
+
+		_, err := getNextPageURL(links, requestURL)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRewriteToBaseURL(t *testing.T) {
+	baseURL, _ := url.ParseRequestURI("https://example.com/fhir")
+	nextPageURL, _ := url.ParseRequestURI("http://internal:8080/fhir/__page/1?_count=10")
+
+	rewritten := rewriteToBaseURL(nextPageURL, *baseURL)
+
+	assert.Equal(t, "https://example.com/fhir/__page/1?_count=10", rewritten.String())
+	assert.Equal(t, "http://internal:8080/fhir/__page/1?_count=10", nextPageURL.String())
+}
+
+func TestGetNextPageURLFromHeader(t *testing.T) {
+	requestURL, _ := url.ParseRequestURI("http://localhost:8080/fhir/Patient")
+
+	t.Run("NoLinkHeader", func(t *testing.T) {
+		nextPageURL, err := getNextPageURLFromHeader(http.Header{}, requestURL)
+
+		assert.Nil(t, err)
+		assert.Nil(t, nextPageURL)
+	})
+
+	t.Run("RelativeNextLink", func(t *testing.T) {
+		header := http.Header{}
+		header.Add("Link", `<Patient?_page=2&code=a,b>; rel="next", <Patient>; rel="self"`)
+
+		nextPageURL, err := getNextPageURLFromHeader(header, requestURL)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://localhost:8080/fhir/Patient?_page=2&code=a,b", nextPageURL.String())
+	})
+
+	t.Run("MalformedLinkHeader", func(t *testing.T) {
+		header := http.Header{}
+		header.Add("Link", `http://localhost:8080/fhir/Patient; rel="next"`)
+
+		_, err := getNextPageURLFromHeader(header, requestURL)
+
+		assert.NotNil(t, err)
+	})
 }
 
 func TestWriteResource(t *testing.T) {
@@ -0,0 +1,84 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+var suppressDuplicates bool
+
+// duplicateFilter remembers the versions of all resources downloaded so far,
+// so that resources returned more than once, because of paging anomalies of
+// the server or overlapping searches, are only written once.
+type duplicateFilter struct {
+	seen map[string]struct{}
+}
+
+func newDuplicateFilter() *duplicateFilter {
+	return &duplicateFilter{seen: make(map[string]struct{})}
+}
+
+// filter removes the entries of resources whose type, id and version id were
+// seen before from the raw bundle entries in data. Outcomes are kept. Returns
+// the remaining entries together with the number of removed entries.
+func (f *duplicateFilter) filter(data []byte) ([]byte, int, error) {
+	if len(data) == 0 {
+		return data, 0, nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, 0, fmt.Errorf("could not parse the bundle entries from JSON: %v", err)
+	}
+
+	kept := make([]json.RawMessage, 0, len(entries))
+	for _, rawEntry := range entries {
+		var entry struct {
+			Resource struct {
+				ResourceType string `json:"resourceType"`
+				Id           string `json:"id"`
+				Meta         struct {
+					VersionId string `json:"versionId"`
+				} `json:"meta"`
+			} `json:"resource"`
+			Search struct {
+				Mode string `json:"mode"`
+			} `json:"search"`
+		}
+		if err := json.Unmarshal(rawEntry, &entry); err != nil {
+			return nil, 0, fmt.Errorf("could not parse a bundle entry from JSON: %v", err)
+		}
+		if entry.Search.Mode == "outcome" || entry.Resource.Id == "" {
+			kept = append(kept, rawEntry)
+			continue
+		}
+		key := entry.Resource.ResourceType + "/" + entry.Resource.Id + "/" + entry.Resource.Meta.VersionId
+		if _, ok := f.seen[key]; ok {
+			continue
+		}
+		f.seen[key] = struct{}{}
+		kept = append(kept, rawEntry)
+	}
+
+	if len(kept) == len(entries) {
+		return data, 0, nil
+	}
+	filtered, err := json.Marshal(kept)
+	if err != nil {
+		return nil, 0, err
+	}
+	return filtered, len(entries) - len(kept), nil
+}
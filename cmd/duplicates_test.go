@@ -0,0 +1,55 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDuplicateFilter(t *testing.T) {
+	t.Run("outcomes and resources without id are kept", func(t *testing.T) {
+		f := newDuplicateFilter()
+		data := []byte(`[{"resource": {"resourceType": "OperationOutcome"}, "search": {"mode": "outcome"}},
+			{"resource": {"resourceType": "Bundle"}}]`)
+
+		_, _, _ = f.filter(data)
+		filtered, removed, err := f.filter(data)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, removed)
+			assert.Equal(t, string(data), string(filtered))
+		}
+	})
+
+	t.Run("without version id", func(t *testing.T) {
+		f := newDuplicateFilter()
+
+		_, _, _ = f.filter([]byte(`[{"resource": {"resourceType": "Patient", "id": "0"}}]`))
+		filtered, removed, err := f.filter([]byte(`[{"resource": {"resourceType": "Patient", "id": "0"}},
+			{"resource": {"resourceType": "Observation", "id": "0"}}]`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, removed)
+			assert.JSONEq(t, `[{"resource": {"resourceType": "Observation", "id": "0"}}]`, string(filtered))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, _, err := newDuplicateFilter().filter([]byte("{"))
+
+		assert.ErrorContains(t, err, "could not parse the bundle entries from JSON")
+	})
+}
@@ -12,22 +12,35 @@ import (
 	"github.com/samply/blazectl/util"
 	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var forceSync bool
 
+// measureReportType is the reportType parameter of $evaluate-measure. The
+// server default is used if empty.
+var measureReportType string
+var pollInterval time.Duration
+var pollTimeout time.Duration
+
 func CreateMeasureResource(m data.Measure, measureUrl string, libraryUrl string) (*fm.Measure, error) {
 	if len(m.Group) == 0 {
 		return nil, fmt.Errorf("missing group")
 	}
+	scoring := m.Scoring
+	if scoring == "" {
+		scoring = "cohort"
+	} else if _, ok := measureScorings[scoring]; !ok {
+		return nil, fmt.Errorf("unknown scoring `%s`, expected one of %s", scoring, strings.Join(measureScoringCodes, ", "))
+	}
 	measure := fm.Measure{
 		Url:    &measureUrl,
 		Status: fm.PublicationStatusActive,
@@ -39,13 +52,13 @@ func CreateMeasureResource(m data.Measure, measureUrl string, libraryUrl string)
 		Library: []string{libraryUrl},
 		Scoring: &fm.CodeableConcept{
 			Coding: []fm.Coding{
-				createCoding("http://terminology.hl7.org/CodeSystem/measure-scoring", "cohort"),
+				createCoding("http://terminology.hl7.org/CodeSystem/measure-scoring", scoring),
 			},
 		},
 		Group: make([]fm.MeasureGroup, 0, len(m.Group)),
 	}
 	for i, group := range m.Group {
-		g, err := createMeasureGroup(group)
+		g, err := createMeasureGroup(group, scoring)
 		if err != nil {
 			return nil, fmt.Errorf("error in group[%d]: %v", i, err)
 		}
@@ -54,7 +67,7 @@ func CreateMeasureResource(m data.Measure, measureUrl string, libraryUrl string)
 	return &measure, nil
 }
 
-func createMeasureGroup(g data.Group) (*fm.MeasureGroup, error) {
+func createMeasureGroup(g data.Group, scoring string) (*fm.MeasureGroup, error) {
 	if len(g.Population) == 0 {
 		return nil, fmt.Errorf("missing population")
 	}
@@ -77,6 +90,9 @@ func createMeasureGroup(g data.Group) (*fm.MeasureGroup, error) {
 		}
 		group.Population = append(group.Population, *p)
 	}
+	if err := checkScoringPopulations(scoring, g.Population); err != nil {
+		return nil, err
+	}
 	for i, stratifier := range g.Stratifier {
 		s, err := createMeasureGroupStratifier(stratifier)
 		if err != nil {
@@ -87,27 +103,158 @@ func createMeasureGroup(g data.Group) (*fm.MeasureGroup, error) {
 	return &group, nil
 }
 
+// measurePopulationCodes are the codes of the populations of measure groups.
+var measurePopulationCodes = []string{"initial-population", "numerator", "numerator-exclusion", "denominator",
+	"denominator-exclusion", "denominator-exception", "measure-population", "measure-population-exclusion",
+	"measure-observation"}
+
+// measureAggregateMethods are the methods to aggregate the observations of
+// measure-observation populations.
+var measureAggregateMethods = []string{"sum", "average", "median", "minimum", "maximum", "count"}
+
+// scoringPopulations are the populations a scoring type requires in each group
+// and the ones it allows in addition.
+type scoringPopulations struct {
+	required []string
+	optional []string
+}
+
+// measureScoringCodes are the codes of the supported scoring types.
+var measureScoringCodes = []string{"cohort", "proportion", "ratio", "continuous-variable"}
+
+var measureScorings = map[string]scoringPopulations{
+	"cohort": {
+		required: []string{"initial-population"},
+	},
+	"proportion": {
+		required: []string{"initial-population", "denominator", "numerator"},
+		optional: []string{"denominator-exclusion", "denominator-exception", "numerator-exclusion"},
+	},
+	"ratio": {
+		required: []string{"initial-population", "denominator", "numerator"},
+		optional: []string{"denominator-exclusion", "numerator-exclusion", "measure-observation"},
+	},
+	"continuous-variable": {
+		required: []string{"initial-population", "measure-population", "measure-observation"},
+		optional: []string{"measure-population-exclusion"},
+	},
+}
+
+// populationCode returns the code of population, which defaults to
+// initial-population.
+func populationCode(population data.Population) string {
+	if population.Code == "" {
+		return "initial-population"
+	}
+	return population.Code
+}
+
+// checkScoringPopulations checks that populations contain all populations
+// required by scoring and no population it doesn't allow. Each population may
+// occur only once, except the initial population of ratio measures, which may
+// have separate initial populations for the numerator and the denominator.
+func checkScoringPopulations(scoring string, populations []data.Population) error {
+	allowed := measureScorings[scoring]
+	counts := make(map[string]int)
+	for i, population := range populations {
+		code := populationCode(population)
+		if !slices.Contains(allowed.required, code) && !slices.Contains(allowed.optional, code) {
+			return fmt.Errorf("population[%d]: population `%s` isn't allowed with %s scoring", i, code, scoring)
+		}
+		counts[code]++
+		if counts[code] > 1 && (scoring != "ratio" || code != "initial-population" || counts[code] > 2) {
+			return fmt.Errorf("population[%d]: duplicate population `%s`", i, code)
+		}
+	}
+	for _, code := range allowed.required {
+		if counts[code] == 0 {
+			return fmt.Errorf("missing population `%s` required by %s scoring", code, scoring)
+		}
+	}
+	return nil
+}
+
+// createMeasureGroupPopulation creates the population of a measure group. The
+// population is an initial population if no code is given. Measure
+// observations need an aggregate method, which is given in the
+// cqfm-aggregateMethod extension.
 func createMeasureGroupPopulation(population data.Population) (*fm.MeasureGroupPopulation, error) {
 	if population.Expression == "" {
 		return nil, fmt.Errorf("missing expression name")
 	}
-	return &fm.MeasureGroupPopulation{
+	code := populationCode(population)
+	if !slices.Contains(measurePopulationCodes, code) {
+		return nil, fmt.Errorf("unknown code `%s`, expected one of %s", code, strings.Join(measurePopulationCodes, ", "))
+	}
+	result := &fm.MeasureGroupPopulation{
 		Code: &fm.CodeableConcept{
 			Coding: []fm.Coding{
-				createCoding("http://terminology.hl7.org/CodeSystem/measure-population", "initial-population"),
+				createCoding("http://terminology.hl7.org/CodeSystem/measure-population", code),
 			},
 		},
 		Criteria: fm.Expression{
 			Language:   "text/cql-identifier",
 			Expression: &population.Expression,
 		},
-	}, nil
+	}
+	if code != "measure-observation" {
+		if population.AggregateMethod != "" {
+			return nil, fmt.Errorf("aggregate method is only allowed with measure-observation populations")
+		}
+		return result, nil
+	}
+	if population.AggregateMethod == "" {
+		return nil, fmt.Errorf("missing aggregate method")
+	}
+	if !slices.Contains(measureAggregateMethods, population.AggregateMethod) {
+		return nil, fmt.Errorf("unknown aggregate method `%s`, expected one of %s", population.AggregateMethod,
+			strings.Join(measureAggregateMethods, ", "))
+	}
+	result.Extension = []fm.Extension{
+		{
+			Url:       "http://hl7.org/fhir/us/cqfmeasures/StructureDefinition/cqfm-aggregateMethod",
+			ValueCode: &population.AggregateMethod,
+		},
+	}
+	return result, nil
 }
 
+// createMeasureGroupStratifier creates the stratifier of a measure group,
+// either with a single expression or with components, which stratify by the
+// combination of their values.
 func createMeasureGroupStratifier(stratifier data.Stratifier) (*fm.MeasureGroupStratifier, error) {
 	if stratifier.Code == "" {
 		return nil, fmt.Errorf("missing code")
 	}
+	if len(stratifier.Component) > 0 {
+		if stratifier.Expression != "" {
+			return nil, fmt.Errorf("expression and component can't be used together")
+		}
+		result := &fm.MeasureGroupStratifier{
+			Code: &fm.CodeableConcept{
+				Text: &stratifier.Code,
+			},
+			Component: make([]fm.MeasureGroupStratifierComponent, 0, len(stratifier.Component)),
+		}
+		for i, component := range stratifier.Component {
+			if component.Code == "" {
+				return nil, fmt.Errorf("component[%d]: missing code", i)
+			}
+			if component.Expression == "" {
+				return nil, fmt.Errorf("component[%d]: missing expression name", i)
+			}
+			result.Component = append(result.Component, fm.MeasureGroupStratifierComponent{
+				Code: &fm.CodeableConcept{
+					Text: &component.Code,
+				},
+				Criteria: fm.Expression{
+					Language:   "text/cql-identifier",
+					Expression: &component.Expression,
+				},
+			})
+		}
+		return result, nil
+	}
 	if stratifier.Expression == "" {
 		return nil, fmt.Errorf("missing expression name")
 	}
@@ -130,7 +277,7 @@ func CreateLibraryResource(m data.Measure, libraryUrl string) (*fm.Library, erro
 	if m.Library == "" {
 		return nil, fmt.Errorf("error while reading the measure file: missing CQL library filename")
 	}
-	libraryFile, err := os.ReadFile(m.Library)
+	libraryFile, err := readCqlLibrary(m.Library)
 	if err != nil {
 		return nil, fmt.Errorf("error while reading the CQL library file: %v", err)
 	}
@@ -165,21 +312,6 @@ func createBundleEntry(url string, resource []byte) fm.BundleEntry {
 	}
 }
 
-func readMeasureFile(filename string) (*data.Measure, error) {
-	file, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	measure := data.Measure{}
-
-	err = yaml.Unmarshal(file, &measure)
-	if err != nil {
-		return nil, err
-	}
-	return &measure, nil
-}
-
 func RandomUrl() (string, error) {
 	myUuid, err := uuid.NewRandom()
 	if err != nil {
@@ -189,78 +321,36 @@ func RandomUrl() (string, error) {
 	return "urn:uuid:" + myUuid.String(), nil
 }
 
-func isTransient(issue fm.OperationOutcomeIssue) bool {
-	switch issue.Code {
-	case fm.IssueTypeTransient,
-		fm.IssueTypeLockError,
-		fm.IssueTypeNoStore,
-		fm.IssueTypeException,
-		fm.IssueTypeTimeout,
-		fm.IssueTypeIncomplete,
-		fm.IssueTypeThrottled:
-		return true
-	default:
-		return false
-	}
-}
-
-type operationOutcomeError struct {
-	outcome *fm.OperationOutcome
-}
-
-func (err *operationOutcomeError) Error() string {
-	return util.FmtOperationOutcomes([]*fm.OperationOutcome{err.outcome})
+func isRetryable(err error) bool {
+	var outcomeErr *fhir.OperationOutcomeError
+	return errors.As(err, &outcomeErr) && outcomeErr.Retryable()
 }
 
-type retryableError interface {
-	retryable() bool
+func handleErrorResponse(measureUrl string, resp *http.Response) ([]byte, error) {
+	return nil, fmt.Errorf("Error while evaluating the measure with canonical URL %s:\n\n%w",
+		measureUrl, fhir.ReadHTTPStatusError(resp))
 }
 
-func (err *operationOutcomeError) retryable() bool {
-	for _, issue := range err.outcome.Issue {
-		if isTransient(issue) {
-			return true
-		}
-	}
-	return false
+func evaluateMeasure(client *fhir.Client, measureUrl string) ([]byte, error) {
+	return evaluateSubjectMeasure(client, measureUrl, "")
 }
 
-func isRetryable(err error) bool {
-	if re, ok := err.(retryableError); ok {
-		return re.retryable()
+// evaluateSubjectMeasure evaluates the measure with the given canonical URL
+// only for subject, like Patient/0. The whole population is used if subject is
+// empty.
+func evaluateSubjectMeasure(client *fhir.Client, measureUrl string, subject string) ([]byte, error) {
+	parameters := url.Values{
+		"measure":     []string{measureUrl},
+		"periodStart": []string{"1900"},
+		"periodEnd":   []string{"2200"},
 	}
-	return false
-}
-
-func handleErrorResponse(measureUrl string, resp *http.Response) ([]byte, error) {
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if subject != "" {
+		parameters.Set("subject", subject)
 	}
-
-	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/fhir+json") {
-		operationOutcome := fm.OperationOutcome{}
-
-		err = json.Unmarshal(body, &operationOutcome)
-		if err == nil {
-			err = &operationOutcomeError{outcome: &operationOutcome}
-		}
-
-		return nil, fmt.Errorf("Error while evaluating the measure with canonical URL %s:\n\n%w",
-			measureUrl, err)
-	} else {
-		return nil, fmt.Errorf("Error while evaluating the measure with canonical URL %s:\n\n%s",
-			measureUrl, body)
+	if measureReportType != "" {
+		parameters.Set("reportType", measureReportType)
 	}
-}
-
-func evaluateMeasure(client *fhir.Client, measureUrl string) ([]byte, error) {
-	req, err := client.NewTypeOperationRequest("Measure", "evaluate-measure", !forceSync,
-		url.Values{
-			"measure":     []string{measureUrl},
-			"periodStart": []string{"1900"},
-			"periodEnd":   []string{"2200"},
-		})
+	req, err := client.NewTypeOperationRequest("Measure", "evaluate-measure", !forceSync, parameters)
 	if err != nil {
 		return nil, err
 	}
@@ -282,47 +372,154 @@ func evaluateMeasure(client *fhir.Client, measureUrl string) ([]byte, error) {
 		contentLocation := resp.Header.Get("Content-Location")
 		interruptChan := make(chan os.Signal, 1)
 		signal.Notify(interruptChan, os.Interrupt)
-		return pollAsyncStatus(client, measureUrl, contentLocation, 100*time.Millisecond, interruptChan)
+		entries, err := pollAsyncStatus(client, measureUrl, contentLocation, retryAfter(resp, pollInterval),
+			newPollTimeout(), interruptChan)
+		if err != nil {
+			return nil, err
+		}
+		return singleAsyncResponseResource(entries)
 	} else {
 		return handleErrorResponse(measureUrl, resp)
 	}
 }
 
+// retryAfter returns the duration the server asks us to wait in the Retry-After
+// header of resp. Both the delay-seconds and the HTTP-date form are supported.
+// Returns fallback if the header is missing or invalid.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return fallback
+}
+
+// newPollTimeout returns a channel which fires after the configured poll
+// timeout. Returns a nil channel, which never fires, if no timeout is set.
+func newPollTimeout() <-chan time.Time {
+	if pollTimeout <= 0 {
+		return nil
+	}
+	return time.After(pollTimeout)
+}
+
+func cancelAsyncRequest(client *fhir.Client, location string) error {
+	req, err := http.NewRequest("DELETE", location, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 202 {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	operationOutcome := fm.OperationOutcome{}
+
+	err = json.Unmarshal(body, &operationOutcome)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("Error while cancelling the async request at status endpoint %s:\n\n%w",
+		location, fhir.NewOperationOutcomeError(&operationOutcome))
+}
+
+// readAsyncResponseEntries reads the batch-response Bundle returned by an async
+// status endpoint after the request completed. All entries are returned with
+// their individual response status and outcome, so that the caller can decide
+// how to process them.
+func readAsyncResponseEntries(r io.Reader) ([]fm.BundleEntry, error) {
+	batchResponse, err := fhir.ReadBundle(r)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the async response Bundle: %w", err)
+	}
+	return batchResponse.Entry, nil
+}
+
+// singleAsyncResponseResource returns the resource of the only entry of an
+// async response Bundle. Returns an error if there isn't exactly one entry.
+func singleAsyncResponseResource(entries []fm.BundleEntry) ([]byte, error) {
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("expected one entry in async response Bundle but was %d entries", len(entries))
+	}
+	return entries[0].Resource, nil
+}
+
+// entryErrorResponse converts the response of a bundle entry into an
+// ErrorResponse holding its status code and outcome. A missing or invalid
+// response is described by an error outcome.
+func entryErrorResponse(response *fm.BundleEntryResponse) util.ErrorResponse {
+	if response == nil {
+		return util.ErrorResponse{OperationOutcome: util.NewErrorOutcome("missing response")}
+	}
+	statusCode, err := strconv.Atoi(strings.SplitN(response.Status, " ", 2)[0])
+	if err != nil {
+		return util.ErrorResponse{
+			OperationOutcome: util.NewErrorOutcome(fmt.Sprintf("invalid response status `%s`", response.Status)),
+		}
+	}
+	errorResponse := util.ErrorResponse{StatusCode: statusCode}
+	if len(response.Outcome) > 0 {
+		errorResponse.OperationOutcome = util.ReadOperationOutcome(response.Outcome)
+	}
+	return errorResponse
+}
+
+func isSuccessfulStatus(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
+
+// asyncResponseEntryErrors returns an ErrorResponse for every entry of an async
+// response Bundle which doesn't have a successful (2xx) status. The map is keyed
+// by the index of the entry.
+func asyncResponseEntryErrors(entries []fm.BundleEntry) map[int]util.ErrorResponse {
+	errorResponses := make(map[int]util.ErrorResponse)
+	for i, entry := range entries {
+		if errorResponse := entryErrorResponse(entry.Response); !isSuccessfulStatus(errorResponse.StatusCode) {
+			errorResponses[i] = errorResponse
+		}
+	}
+	return errorResponses
+}
+
 func pollAsyncStatus(client *fhir.Client, measureUrl string, location string, wait time.Duration,
-	interruptChan chan os.Signal) ([]byte, error) {
+	timeout <-chan time.Time, interruptChan chan os.Signal) ([]fm.BundleEntry, error) {
 	select {
 	case <-interruptChan:
 		fmt.Fprintf(os.Stderr, "Cancel async request...\n")
 
-		req, err := http.NewRequest("DELETE", location, nil)
-		if err != nil {
+		if err := cancelAsyncRequest(client, location); err != nil {
 			return nil, err
 		}
+		return nil, fmt.Errorf("sucessfully cancelled the async request at status endpoint %s", location)
+	case <-timeout:
+		fmt.Fprintf(os.Stderr, "Poll timeout of %s exceeded. Cancel async request...\n", pollTimeout)
 
-		resp, err := client.Do(req)
-		if err != nil {
+		if err := cancelAsyncRequest(client, location); err != nil {
 			return nil, err
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode == 202 {
-			return nil, fmt.Errorf("sucessfully cancelled the async request at status endpoint %s", location)
-		} else {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return nil, err
-			}
-
-			operationOutcome := fm.OperationOutcome{}
-
-			err = json.Unmarshal(body, &operationOutcome)
-			if err != nil {
-				return nil, err
-			}
-
-			return nil, fmt.Errorf("Error while cancelling the async request at status endpoint %s:\n\n%w",
-				location, &operationOutcomeError{outcome: &operationOutcome})
-		}
+		return nil, fmt.Errorf("poll timeout of %s exceeded, cancelled the async request at status endpoint %s",
+			pollTimeout, location)
 	case <-time.After(wait):
 		fmt.Fprintf(os.Stderr, "Poll status endpoint at %s...\n", location)
 		req, err := http.NewRequest("GET", location, nil)
@@ -337,34 +534,30 @@ func pollAsyncStatus(client *fhir.Client, measureUrl string, location string, wa
 		defer resp.Body.Close()
 
 		if resp.StatusCode == 200 {
-			batchResponse, err := fhir.ReadBundle(resp.Body)
-			if err != nil {
-				return nil, fmt.Errorf("error while reading the async response Bundle: %w", err)
-			}
-
-			if len(batchResponse.Entry) != 1 {
-				return nil, fmt.Errorf("expected one entry in async response Bundle but was %d entries", len(batchResponse.Entry))
-			}
-
-			return batchResponse.Entry[0].Resource, nil
+			return readAsyncResponseEntries(resp.Body)
 		} else if resp.StatusCode == 202 {
-			// exponential wait up to 10 seconds
+			// exponential wait up to 10 seconds unless the server tells us otherwise
 			if wait < 10*time.Second {
 				wait *= 2
 			}
-			return pollAsyncStatus(client, measureUrl, location, wait, interruptChan)
+			return pollAsyncStatus(client, measureUrl, location, retryAfter(resp, wait), timeout, interruptChan)
 		} else {
-			return handleErrorResponse(measureUrl, resp)
+			_, err := handleErrorResponse(measureUrl, resp)
+			return nil, err
 		}
 	}
 }
 
 func evaluateMeasureWithRetry(client *fhir.Client, measureUrl string) ([]byte, error) {
+	return evaluateSubjectMeasureWithRetry(client, measureUrl, "")
+}
+
+func evaluateSubjectMeasureWithRetry(client *fhir.Client, measureUrl string, subject string) ([]byte, error) {
 	var lastErr error
 	for wait := 100 * time.Millisecond; wait < 5*time.Second; wait *= 2 {
-		measureReport, err := evaluateMeasure(client, measureUrl)
+		measureReport, err := evaluateSubjectMeasure(client, measureUrl, subject)
 		lastErr = err
-		if !isRetryable(errors.Unwrap(err)) {
+		if !isRetryable(err) {
 			return measureReport, err
 		}
 		fmt.Fprintf(os.Stderr, "Retry evaluating the measure...\n")
@@ -373,14 +566,105 @@ func evaluateMeasureWithRetry(client *fhir.Client, measureUrl string) ([]byte, e
 	return nil, lastErr
 }
 
+// createMeasure creates the Measure and Library resources of m on the server
+// and creates or updates its ValueSets. Returns the canonical URL of the
+// created Measure.
+func createMeasure(client *fhir.Client, m data.Measure) (string, error) {
+	measureUrl, err := RandomUrl()
+	if err != nil {
+		return "", err
+	}
+
+	libraryUrl, err := RandomUrl()
+	if err != nil {
+		return "", err
+	}
+
+	measure, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+	if err != nil {
+		return "", fmt.Errorf("error while reading the measure file: %v", err)
+	}
+
+	libraries, err := CreateLibraryResources(m, libraryUrl)
+	if err != nil {
+		return "", err
+	}
+
+	measureBytes, err := json.Marshal(measure)
+	if err != nil {
+		return "", err
+	}
+
+	valueSets, err := createValueSetEntries(m, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	bundle := fm.Bundle{Type: fm.BundleTypeTransaction, Entry: valueSets}
+	for _, library := range libraries {
+		libraryBytes, err := json.Marshal(library)
+		if err != nil {
+			return "", err
+		}
+		bundle.Entry = append(bundle.Entry, createBundleEntry("Library", libraryBytes))
+	}
+	bundle.Entry = append(bundle.Entry, createBundleEntry("Measure", measureBytes))
+
+	bundleBytes, err := json.Marshal(bundle)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := client.NewTransactionRequest(bytes.NewReader(bundleBytes))
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		_, err := io.Copy(io.Discard, resp.Body)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		_, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("can't create the Measure and/or Library Resource")
+	}
+
+	return measureUrl, nil
+}
+
 var evaluateMeasureCmd = &cobra.Command{
 	Use:   "evaluate-measure [measure-file]",
 	Short: "Evaluates a Measure",
 	Long: `Given a measure in YAML form, creates the required FHIR resources, 
 evaluates that measure and returns the measure report.
 
+With --cohort, the measure is only evaluated for the patients listed in the
+cohort file. The file contains one Patient id or identifier in the form
+system|value per line. Each patient is evaluated as subject on its own, up to
+--cohort-concurrency patients in parallel, and the counts of all patients are
+merged into one summary MeasureReport. With --group, the members of the Group
+with the given id are used instead.
+
 Examples:
   blazectl evaluate-measure --server "http://localhost:8080/fhir" stratifier-condition-code.yml
+  blazectl evaluate-measure --server "http://localhost:8080/fhir" --cohort cohort.txt stratifier-condition-code.yml
+
+With --set key=value, {{ .key }} is substituted by value in the measure file
+and all CQL libraries, so that one measure can be evaluated with different
+code lists or date ranges. The files are only treated as Go templates if
+--set is given.
+
+  blazectl evaluate-measure --server "http://localhost:8080/fhir" --set year=2023 measure.yml
 
 See: https://github.com/samply/blaze/blob/main/docs/cql-queries/blazectl.md`,
 	Args: func(cmd *cobra.Command, args []string) error {
@@ -396,106 +680,69 @@ See: https://github.com/samply/blaze/blob/main/docs/cql-queries/blazectl.md`,
 		}
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		m, err := readMeasureFile(args[0])
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		var err error
+		if templateVars, err = parseVariables(setFlags); err != nil {
+			return err
 		}
 
-		measureUrl, err := RandomUrl()
+		m, err := data.ReadMeasureFileWith(args[0], templateVars)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		libraryUrl, err := RandomUrl()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		measure, err := CreateMeasureResource(*m, measureUrl, libraryUrl)
-		if err != nil {
-			fmt.Printf("error while reading the measure file: %v\n", err)
-			os.Exit(1)
-		}
-
-		library, err := CreateLibraryResource(*m, libraryUrl)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		measureBytes, err := json.Marshal(measure)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		libraryBytes, err := json.Marshal(library)
+		err = createClient()
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		bundle := fm.Bundle{
-			Type: fm.BundleTypeTransaction,
-			Entry: []fm.BundleEntry{
-				createBundleEntry("Library", libraryBytes),
-				createBundleEntry("Measure", measureBytes),
-			},
-		}
-
-		bundleBytes, err := json.Marshal(bundle)
+		measureUrl, err := createMeasure(client, *m)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		err = createClient()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		fmt.Fprintf(os.Stderr, "Evaluate measure with canonical URL %s on %s ...\n\n", measureUrl, server)
 
-		req, err := client.NewTransactionRequest(bytes.NewReader(bundleBytes))
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		var measureReport []byte
+		if cohortSelected() {
+			measureReport, err = evaluateCohortMeasure(client, measureUrl, loadCohortOrDie(client), cohortConcurrency)
+		} else {
+			measureReport, err = evaluateMeasureWithRetry(client, measureUrl)
 		}
-
-		resp, err := client.Do(req)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode == 200 {
-			_, err := io.Copy(io.Discard, resp.Body)
+		if saveReport {
+			id, err := saveMeasureReport(client, measureReport)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
-		} else {
-			_, err := io.ReadAll(resp.Body)
-			if err != nil {
+			fmt.Fprintf(os.Stderr, "Saved MeasureReport with id %s.\n\n", id)
+		}
+
+		if reportCsvFile != "" {
+			if err := writeMeasureReportCsvFile(measureReport, reportCsvFile); err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
-			return fmt.Errorf("can't create the Measure and/or Library Resource")
 		}
 
-		fmt.Fprintf(os.Stderr, "Evaluate measure with canonical URL %s on %s ...\n\n", measureUrl, server)
-
-		measureReport, err := evaluateMeasureWithRetry(client, measureUrl)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if renderReport {
+			rendered, err := renderMeasureReport(measureReport)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Print(rendered)
+		} else {
+			fmt.Println(string(measureReport))
 		}
 
-		fmt.Println(string(measureReport))
-
 		return nil
 	},
 }
@@ -505,6 +752,13 @@ func init() {
 
 	evaluateMeasureCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
 	evaluateMeasureCmd.Flags().BoolVarP(&forceSync, "force-sync", "", false, "force synchronous responses")
+	evaluateMeasureCmd.Flags().BoolVar(&saveReport, "save-report", false, "store the MeasureReport on the server and print its id")
+	evaluateMeasureCmd.Flags().BoolVar(&renderReport, "render", false, "print the counts of the MeasureReport as table")
+	evaluateMeasureCmd.Flags().StringVar(&reportCsvFile, "report-csv", "", "write the counts of the MeasureReport as CSV into this file")
+	evaluateMeasureCmd.Flags().DurationVar(&pollInterval, "poll-interval", 100*time.Millisecond, "initial wait between polls of the async status endpoint")
+	evaluateMeasureCmd.Flags().DurationVar(&pollTimeout, "poll-timeout", 0, "abort polling the async status endpoint after this duration (0 means no timeout)")
+	addCohortFlags(evaluateMeasureCmd)
+	addSetFlag(evaluateMeasureCmd)
 
 	_ = evaluateMeasureCmd.MarkFlagRequired("server")
 }
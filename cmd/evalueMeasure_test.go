@@ -11,7 +11,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreateMeasureResource(t *testing.T) {
@@ -185,6 +187,336 @@ func TestCreateMeasureResource(t *testing.T) {
 		assert.Equal(t, "foo", *resource.Group[0].Stratifier[0].Code.Text)
 	})
 
+	t.Run("with numerator and denominator populations", func(t *testing.T) {
+		m := data.Measure{
+			Scoring: "proportion",
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+						{Code: "denominator", Expression: "InDenominator"},
+						{Code: "numerator", Expression: "InNumerator"},
+					},
+				},
+			},
+		}
+
+		resource, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err != nil {
+			t.Fatalf("error while generating the measure resource: %v", err)
+		}
+
+		assert.Equal(t, 3, len(resource.Group[0].Population))
+		assert.Equal(t, "initial-population", *resource.Group[0].Population[0].Code.Coding[0].Code)
+		assert.Equal(t, "denominator", *resource.Group[0].Population[1].Code.Coding[0].Code)
+		assert.Equal(t, "InDenominator", *resource.Group[0].Population[1].Criteria.Expression)
+		assert.Equal(t, "numerator", *resource.Group[0].Population[2].Code.Coding[0].Code)
+		assert.Equal(t, "proportion", *resource.Scoring.Coding[0].Code)
+	})
+
+	t.Run("with unknown scoring", func(t *testing.T) {
+		m := data.Measure{
+			Scoring: "foo",
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "unknown scoring `foo`, expected one of cohort, proportion, ratio, continuous-variable", err.Error())
+	})
+
+	t.Run("with proportion scoring and missing numerator", func(t *testing.T) {
+		m := data.Measure{
+			Scoring: "proportion",
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+						{Code: "denominator", Expression: "InDenominator"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: missing population `numerator` required by proportion scoring", err.Error())
+	})
+
+	t.Run("with cohort scoring and numerator", func(t *testing.T) {
+		m := data.Measure{
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+						{Code: "numerator", Expression: "InNumerator"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: population[1]: population `numerator` isn't allowed with cohort scoring", err.Error())
+	})
+
+	t.Run("with duplicate population", func(t *testing.T) {
+		m := data.Measure{
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+						{Expression: "InOtherPopulation"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: population[1]: duplicate population `initial-population`", err.Error())
+	})
+
+	t.Run("with ratio scoring and two initial populations", func(t *testing.T) {
+		m := data.Measure{
+			Scoring: "ratio",
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation1"},
+						{Expression: "InInitialPopulation2"},
+						{Code: "denominator", Expression: "InDenominator"},
+						{Code: "numerator", Expression: "InNumerator"},
+					},
+				},
+			},
+		}
+
+		resource, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err != nil {
+			t.Fatalf("error while generating the measure resource: %v", err)
+		}
+
+		assert.Equal(t, "ratio", *resource.Scoring.Coding[0].Code)
+		assert.Equal(t, 4, len(resource.Group[0].Population))
+	})
+
+	t.Run("with continuous-variable scoring", func(t *testing.T) {
+		m := data.Measure{
+			Scoring: "continuous-variable",
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+						{Code: "measure-population", Expression: "InMeasurePopulation"},
+						{Code: "measure-observation", Expression: "LengthOfStay", AggregateMethod: "median"},
+					},
+				},
+			},
+		}
+
+		resource, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err != nil {
+			t.Fatalf("error while generating the measure resource: %v", err)
+		}
+
+		assert.Equal(t, "continuous-variable", *resource.Scoring.Coding[0].Code)
+		observation := resource.Group[0].Population[2]
+		assert.Equal(t, "measure-observation", *observation.Code.Coding[0].Code)
+		assert.Equal(t, 1, len(observation.Extension))
+		assert.Equal(t, "http://hl7.org/fhir/us/cqfmeasures/StructureDefinition/cqfm-aggregateMethod", observation.Extension[0].Url)
+		assert.Equal(t, "median", *observation.Extension[0].ValueCode)
+		assert.Empty(t, resource.Group[0].Population[1].Extension)
+	})
+
+	t.Run("with measure observation without aggregate method", func(t *testing.T) {
+		m := data.Measure{
+			Scoring: "continuous-variable",
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+						{Code: "measure-population", Expression: "InMeasurePopulation"},
+						{Code: "measure-observation", Expression: "LengthOfStay"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: population[2]: missing aggregate method", err.Error())
+	})
+
+	t.Run("with unknown aggregate method", func(t *testing.T) {
+		m := data.Measure{
+			Scoring: "continuous-variable",
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+						{Code: "measure-population", Expression: "InMeasurePopulation"},
+						{Code: "measure-observation", Expression: "LengthOfStay", AggregateMethod: "mean"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: population[2]: unknown aggregate method `mean`, expected one of sum, average, median, minimum, maximum, count", err.Error())
+	})
+
+	t.Run("with aggregate method on initial population", func(t *testing.T) {
+		m := data.Measure{
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation", AggregateMethod: "sum"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: population[0]: aggregate method is only allowed with measure-observation populations", err.Error())
+	})
+
+	t.Run("with unknown population code", func(t *testing.T) {
+		m := data.Measure{
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Code: "foo", Expression: "InInitialPopulation"},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: population[0]: unknown code `foo`, expected one of "+
+			strings.Join(measurePopulationCodes, ", "), err.Error())
+	})
+
+	t.Run("with one stratifier with components", func(t *testing.T) {
+		m := data.Measure{
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+					},
+					Stratifier: []data.Stratifier{
+						{
+							Code: "age-gender",
+							Component: []data.StratifierComponent{
+								{Code: "age", Expression: "AgeClass"},
+								{Code: "gender", Expression: "Gender"},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		resource, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err != nil {
+			t.Fatalf("error while generating the measure resource: %v", err)
+		}
+
+		stratifier := resource.Group[0].Stratifier[0]
+		assert.Equal(t, "age-gender", *stratifier.Code.Text)
+		assert.Nil(t, stratifier.Criteria)
+		assert.Equal(t, 2, len(stratifier.Component))
+		assert.Equal(t, "age", *stratifier.Component[0].Code.Text)
+		assert.Equal(t, "AgeClass", *stratifier.Component[0].Criteria.Expression)
+		assert.Equal(t, "text/cql-identifier", stratifier.Component[0].Criteria.Language)
+		assert.Equal(t, "gender", *stratifier.Component[1].Code.Text)
+		assert.Equal(t, "Gender", *stratifier.Component[1].Criteria.Expression)
+	})
+
+	t.Run("with one stratifier with expression and components", func(t *testing.T) {
+		m := data.Measure{
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+					},
+					Stratifier: []data.Stratifier{
+						{
+							Code:       "age-gender",
+							Expression: "AgeClass",
+							Component:  []data.StratifierComponent{{Code: "gender", Expression: "Gender"}},
+						},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: stratifier[0]: expression and component can't be used together", err.Error())
+	})
+
+	t.Run("with one stratifier with a component with missing expression", func(t *testing.T) {
+		m := data.Measure{
+			Group: []data.Group{
+				{
+					Population: []data.Population{
+						{Expression: "InInitialPopulation"},
+					},
+					Stratifier: []data.Stratifier{
+						{
+							Code:      "age-gender",
+							Component: []data.StratifierComponent{{Code: "gender"}},
+						},
+					},
+				},
+			},
+		}
+
+		_, err := CreateMeasureResource(m, measureUrl, libraryUrl)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		assert.Equal(t, "error in group[0]: stratifier[0]: component[0]: missing expression name", err.Error())
+	})
+
 	t.Run("with one Condition group", func(t *testing.T) {
 		m := data.Measure{
 			Group: []data.Group{
@@ -548,4 +880,96 @@ func TestEvaluateMeasure(t *testing.T) {
 		assert.Equal(t, 0, len(measureReport))
 		assert.Nil(t, err)
 	})
+
+	t.Run("async response with poll timeout", func(t *testing.T) {
+		var cancelled bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/Measure/$evaluate-measure":
+				w.Header().Set("Content-Location", fmt.Sprintf("http://%s/async-poll", r.Host))
+				w.WriteHeader(http.StatusAccepted)
+			case "/async-poll":
+				if r.Method == "DELETE" {
+					cancelled = true
+				}
+				w.WriteHeader(http.StatusAccepted)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		pollTimeout = 300 * time.Millisecond
+		defer func() { pollTimeout = 0 }()
+
+		_, err := evaluateMeasure(client, "foo")
+
+		assert.Contains(t, err.Error(), "poll timeout of 300ms exceeded")
+		assert.True(t, cancelled)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Run("missing header", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+
+		assert.Equal(t, time.Second, retryAfter(resp, time.Second))
+	})
+
+	t.Run("delay seconds", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{"Retry-After": []string{"120"}}}
+
+		assert.Equal(t, 2*time.Minute, retryAfter(resp, time.Second))
+	})
+
+	t.Run("HTTP date in the past", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{"Retry-After": []string{"Wed, 21 Oct 2015 07:28:00 GMT"}}}
+
+		assert.Equal(t, time.Duration(0), retryAfter(resp, time.Second))
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{"Retry-After": []string{"soon"}}}
+
+		assert.Equal(t, time.Second, retryAfter(resp, time.Second))
+	})
+}
+
+func TestAsyncResponseEntryErrors(t *testing.T) {
+	t.Run("successful entries", func(t *testing.T) {
+		entries := []fm.BundleEntry{
+			{Response: &fm.BundleEntryResponse{Status: "200"}},
+			{Response: &fm.BundleEntryResponse{Status: "201 Created"}},
+		}
+
+		assert.Empty(t, asyncResponseEntryErrors(entries))
+	})
+
+	t.Run("entry with error outcome", func(t *testing.T) {
+		outcome, _ := json.Marshal(fm.OperationOutcome{
+			Issue: []fm.OperationOutcomeIssue{{
+				Severity: fm.IssueSeverityError,
+				Code:     fm.IssueTypeNotFound,
+			}},
+		})
+		entries := []fm.BundleEntry{
+			{Response: &fm.BundleEntryResponse{Status: "200"}},
+			{Response: &fm.BundleEntryResponse{Status: "404", Outcome: outcome}},
+		}
+
+		errorResponses := asyncResponseEntryErrors(entries)
+
+		assert.Equal(t, 1, len(errorResponses))
+		assert.Equal(t, 404, errorResponses[1].StatusCode)
+		assert.Equal(t, fm.IssueTypeNotFound, errorResponses[1].OperationOutcome.Issue[0].Code)
+	})
+
+	t.Run("entry without response", func(t *testing.T) {
+		errorResponses := asyncResponseEntryErrors([]fm.BundleEntry{{}})
+
+		assert.Equal(t, "missing response", *errorResponses[0].OperationOutcome.Issue[0].Diagnostics)
+	})
 }
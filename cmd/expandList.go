@@ -0,0 +1,148 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+var resolveList bool
+
+// resolveChunkSize is the number of ids searched with one _id search while
+// resolving the references of a List.
+const resolveChunkSize = 100
+
+// groupReferencesByType groups literal references like Patient/0 by resource
+// type. The types are returned in the order of their first occurrence. Only
+// relative references can be resolved.
+func groupReferencesByType(references []string) (map[string][]string, []string, error) {
+	ids := make(map[string][]string)
+	var types []string
+	for _, reference := range references {
+		resourceType, id, found := strings.Cut(reference, "/")
+		if !found || id == "" || strings.Contains(id, "/") {
+			return nil, nil, fmt.Errorf("can't resolve the reference `%s`, expected a relative reference like Patient/0", reference)
+		}
+		if _, ok := ids[resourceType]; !ok {
+			types = append(types, resourceType)
+		}
+		ids[resourceType] = append(ids[resourceType], id)
+	}
+	return ids, types, nil
+}
+
+// resolveReferences downloads the resources the references point to using _id
+// searches and writes them to sink in NDJSON format. Returns the number of
+// written resources.
+func resolveReferences(client *fhir.Client, references []string, sink io.Writer) (int, error) {
+	ids, types, err := groupReferencesByType(references)
+	if err != nil {
+		return 0, err
+	}
+
+	var resources int
+	for _, resourceType := range types {
+		typeIds := ids[resourceType]
+		for start := 0; start < len(typeIds); start += resolveChunkSize {
+			end := min(start+resolveChunkSize, len(typeIds))
+			bundleChannel := make(chan downloadBundle, 2)
+			go downloadResources(client, resourceType, "_id="+strings.Join(typeIds[start:end], ","), true, bundleChannel)
+
+			for bundle := range bundleChannel {
+				if bundle.err != nil {
+					return resources, fmt.Errorf("error while resolving %s resources: %w", resourceType, bundle.err)
+				}
+				if bundle.errResponse != nil {
+					return resources, fmt.Errorf("error while resolving %s resources:\n\n%s", resourceType, bundle.errResponse.String())
+				}
+				n, _, err := writeResources(&bundle.rawEntries, sink)
+				resources += n
+				if err != nil {
+					return resources, err
+				}
+			}
+		}
+	}
+	return resources, nil
+}
+
+var expandListCmd = &cobra.Command{
+	Use:   "expand-list [list-id]",
+	Short: "Print the references of a List",
+	Long: `Fetches the List resource with the given id, like the subject list of a
+MeasureReport, and prints the references of its items, one per line.
+
+With --resolve, the referenced resources are downloaded instead and printed
+in NDJSON format, so that they can be processed like the output of the
+download command. Only relative references like Patient/0 can be resolved.
+
+Examples:
+  blazectl expand-list --server "http://localhost:8080/fhir" DCVGKQ3GVQBHQKLP > cohort.txt
+  blazectl expand-list --server "http://localhost:8080/fhir" --resolve DCVGKQ3GVQBHQKLP > patients.ndjson`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one list-id argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		references, err := fetchSubjectList(client, "List/"+strings.TrimPrefix(args[0], "List/"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if !resolveList {
+			for _, reference := range references {
+				fmt.Println(reference)
+			}
+			return nil
+		}
+
+		sink := bufio.NewWriter(os.Stdout)
+		resources, err := resolveReferences(client, references, sink)
+		if flushErr := sink.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Resolved %d resources of %d references.\n", resources, len(references))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(expandListCmd)
+
+	expandListCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	expandListCmd.Flags().BoolVar(&resolveList, "resolve", false, "print the referenced resources in NDJSON format instead of the references")
+
+	_ = expandListCmd.MarkFlagRequired("server")
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGroupReferencesByType(t *testing.T) {
+	t.Run("relative references", func(t *testing.T) {
+		ids, types, err := groupReferencesByType([]string{"Patient/0", "Observation/0", "Patient/1"})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"Patient", "Observation"}, types)
+			assert.Equal(t, map[string][]string{"Patient": {"0", "1"}, "Observation": {"0"}}, ids)
+		}
+	})
+
+	t.Run("absolute reference", func(t *testing.T) {
+		_, _, err := groupReferencesByType([]string{"http://localhost/fhir/Patient/0"})
+
+		assert.ErrorContains(t, err, "can't resolve the reference `http://localhost/fhir/Patient/0`")
+	})
+}
+
+func TestResolveReferences(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/Patient/_search", r.URL.Path)
+		_ = r.ParseForm()
+		var entries []string
+		for _, id := range strings.Split(r.PostForm.Get("_id"), ",") {
+			entries = append(entries, `{"resource": {"resourceType": "Patient", "id": "`+id+`"}, "search": {"mode": "match"}}`)
+		}
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [` + strings.Join(entries, ",") + `]}`))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	var sink bytes.Buffer
+	resources, err := resolveReferences(client, []string{"Patient/0", "Patient/1"}, &sink)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, resources)
+		assert.Equal(t, `{"resourceType":"Patient","id":"0"}
+{"resourceType":"Patient","id":"1"}
+`, sink.String())
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var skipEmptyResults bool
+var rawResults bool
+
+// pathResultReference returns the reference of resource like Patient/0 to
+// identify the result of the resource in the output.
+func pathResultReference(resource interface{}) string {
+	object, ok := resource.(map[string]interface{})
+	if !ok {
+		return "-"
+	}
+	resourceType, _ := object["resourceType"].(string)
+	id, _ := object["id"].(string)
+	return resourceType + "/" + id
+}
+
+// writePathResult writes the result of the evaluation of resource to w. By
+// default, the reference of the resource is followed by a tab and the result
+// as JSON array. With raw, each item of the result is written on its own line,
+// strings without quotes.
+func writePathResult(w io.Writer, resource interface{}, result []interface{}, raw bool) error {
+	if raw {
+		for _, item := range result {
+			if s, ok := item.(string); ok {
+				if _, err := fmt.Fprintln(w, s); err != nil {
+					return err
+				}
+				continue
+			}
+			value, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w, string(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if result == nil {
+		result = []interface{}{}
+	}
+	value, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\t%s\n", pathResultReference(resource), value)
+	return err
+}
+
+// evaluatePathOnResources evaluates path on each resource read from r, which
+// contains resources in JSON format one after another like NDJSON, and writes
+// the results to w. Resources with an empty result are left out with
+// skipEmpty.
+func evaluatePathOnResources(path *fhir.Path, r io.Reader, w io.Writer, skipEmpty bool, raw bool) error {
+	reader := bufio.NewReader(r)
+	if _, err := util.SkipBOM(reader); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(reader)
+	for n := 1; ; n++ {
+		resource, err := fhir.DecodeResource(decoder)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error while reading resource %d: %w", n, err)
+		}
+		result, err := path.Evaluate(resource)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pathResultReference(resource), err)
+		}
+		if skipEmpty && len(result) == 0 {
+			continue
+		}
+		if err := writePathResult(w, resource, result, raw); err != nil {
+			return err
+		}
+	}
+}
+
+// evaluatePathOnFile evaluates path on the resources of the file with name.
+func evaluatePathOnFile(path *fhir.Path, name string, w io.Writer, skipEmpty bool, raw bool) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := evaluatePathOnResources(path, file, w, skipEmpty, raw); err != nil {
+		return fmt.Errorf("error in %s: %w", name, err)
+	}
+	return nil
+}
+
+var fhirPathCmd = &cobra.Command{
+	Use:   "fhirpath <expression> [file.ndjson]...",
+	Short: "Evaluate a FHIRPath expression on resources",
+	Long: `Evaluates a FHIRPath expression on each resource of the given NDJSON files
+or, without files, of stdin and prints the result per resource. No server is
+needed, so the command can be used to explore the expressions of extractions
+before using them on a server.
+
+Each result is printed as the reference of the resource followed by a tab and
+the result as JSON array. With --raw, only the items of the results are
+printed, one per line with strings unquoted. Resources with an empty result
+are left out with --skip-empty.
+
+The files may also contain single resources in pretty-printed JSON.
+
+Paths, indexers, the operators and the common functions like where, select,
+exists, count, first, startsWith, matches, substring, extension and ofType are
+supported. Dates are compared as strings. Quantities, resolve() and the
+terminology functions are not supported.
+
+Examples:
+  blazectl fhirpath "Patient.name.where(use = 'official').family" Patient.ndjson
+  blazectl download Observation | blazectl fhirpath "code.coding.code" --raw`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := fhir.ParsePath(args[0])
+		if err != nil {
+			return err
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		defer out.Flush()
+		if len(args) == 1 {
+			return evaluatePathOnResources(path, os.Stdin, out, skipEmptyResults, rawResults)
+		}
+		for _, name := range args[1:] {
+			if err := evaluatePathOnFile(path, name, out, skipEmptyResults, rawResults); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fhirPathCmd)
+
+	fhirPathCmd.Flags().BoolVar(&skipEmptyResults, "skip-empty", false, "leave out resources with an empty result")
+	fhirPathCmd.Flags().BoolVar(&rawResults, "raw", false, "print each item of the results on its own line, strings unquoted")
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fhirPathTestResources = `{"resourceType": "Patient", "id": "0", "gender": "female", "name": [{"given": ["Jane", "Marie"]}]}
+{"resourceType": "Patient", "id": "1", "name": [{"given": ["John"]}]}
+`
+
+func TestEvaluatePathOnResources(t *testing.T) {
+	path, err := fhir.ParsePath("name.given")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		var out bytes.Buffer
+		err := evaluatePathOnResources(path, strings.NewReader(fhirPathTestResources), &out, false, false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Patient/0\t[\"Jane\",\"Marie\"]\nPatient/1\t[\"John\"]\n", out.String())
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		var out bytes.Buffer
+		err := evaluatePathOnResources(path, strings.NewReader(fhirPathTestResources), &out, false, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Jane\nMarie\nJohn\n", out.String())
+	})
+
+	t.Run("SkipEmpty", func(t *testing.T) {
+		path, err := fhir.ParsePath("gender")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = evaluatePathOnResources(path, strings.NewReader(fhirPathTestResources), &out, true, false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Patient/0\t[\"female\"]\n", out.String())
+	})
+
+	t.Run("EmptyResult", func(t *testing.T) {
+		path, err := fhir.ParsePath("birthDate")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = evaluatePathOnResources(path, strings.NewReader(fhirPathTestResources), &out, false, false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Patient/0\t[]\nPatient/1\t[]\n", out.String())
+	})
+
+	t.Run("NonStringItems", func(t *testing.T) {
+		path, err := fhir.ParsePath("name.given.count() | name.exists()")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = evaluatePathOnResources(path, strings.NewReader(fhirPathTestResources), &out, false, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "2\ntrue\n1\ntrue\n", out.String())
+	})
+
+	t.Run("PrettyPrintedWithBOM", func(t *testing.T) {
+		var out bytes.Buffer
+		err := evaluatePathOnResources(path, strings.NewReader("\xEF\xBB\xBF{\n  \"resourceType\": \"Patient\",\n  \"id\": \"0\"\n}\n"), &out, false, false)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Patient/0\t[]\n", out.String())
+	})
+
+	t.Run("InvalidJson", func(t *testing.T) {
+		var out bytes.Buffer
+		err := evaluatePathOnResources(path, strings.NewReader(fhirPathTestResources+"{\n"), &out, false, false)
+
+		assert.EqualError(t, err, "error while reading resource 3: unexpected EOF")
+	})
+
+	t.Run("EvaluationError", func(t *testing.T) {
+		path, err := fhir.ParsePath("name.given.single()")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = evaluatePathOnResources(path, strings.NewReader(fhirPathTestResources), &out, false, false)
+
+		assert.EqualError(t, err, "Patient/0: error while evaluating `name.given.single()`: error in function single: expected a single item, but got 2")
+	})
+}
+
+func TestEvaluatePathOnFile(t *testing.T) {
+	path, err := fhir.ParsePath("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "Patient.ndjson")
+		if err := os.WriteFile(name, []byte(fhirPathTestResources), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err := evaluatePathOnFile(path, name, &out, false, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "0\n1\n", out.String())
+	})
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "Patient.ndjson")
+		if err := os.WriteFile(name, []byte("["), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := evaluatePathOnFile(path, name, &bytes.Buffer{}, false, false)
+
+		assert.EqualError(t, err, "error in "+name+": error while reading resource 1: unexpected EOF")
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var indexFile string
+
+// indexEntry locates one resource in the output files. The offset and length
+// are in bytes, the length excludes the trailing newline. In seekable zstd
+// archives, the offset is relative to the decompressed content of the frame.
+type indexEntry struct {
+	Type   string      `json:"type"`
+	Id     string      `json:"id"`
+	Path   string      `json:"path"`
+	Frame  *indexFrame `json:"frame,omitempty"`
+	Offset int64       `json:"offset"`
+	Length int         `json:"length"`
+}
+
+// indexFrame locates the compressed frame of a seekable zstd archive, which
+// holds a resource.
+type indexFrame struct {
+	Offset int64 `json:"offset"`
+	Length int   `json:"length"`
+}
+
+// resourceIndex writes an NDJSON index of the resource type and id of each
+// written resource to the location of the resource, so that single resources
+// can be extracted from large exports without a full scan.
+type resourceIndex struct {
+	path    string
+	file    *os.File
+	buf     *bufio.Writer
+	pending []indexEntry
+}
+
+// newResourceIndex creates the index at path. Exits if the file already
+// exists.
+func newResourceIndex(path string) *resourceIndex {
+	file := createOutputFileOrDie(path)
+	return &resourceIndex{path: path, file: file, buf: bufio.NewWriter(file)}
+}
+
+// relativePath returns path relative to the directory of the file at base,
+// with forward slashes. Returns path if it can't be made relative.
+func relativePath(base string, path string) string {
+	if relative, err := filepath.Rel(filepath.Dir(base), path); err == nil {
+		return filepath.ToSlash(relative)
+	}
+	return path
+}
+
+// add records that resource was written at offset into the output file at
+// path. Resources written into a seekable zstd archive are pending until
+// their frame is written.
+func (x *resourceIndex) add(path string, offset int64, resource []byte, seekable bool) error {
+	var r struct {
+		ResourceType string `json:"resourceType"`
+		Id           string `json:"id"`
+	}
+	if err := json.Unmarshal(resource, &r); err != nil {
+		return fmt.Errorf("could not index resource at offset %d of %s: %v", offset, path, err)
+	}
+	entry := indexEntry{
+		Type:   r.ResourceType,
+		Id:     r.Id,
+		Path:   relativePath(x.path, path),
+		Offset: offset,
+		Length: len(resource),
+	}
+	if seekable {
+		x.pending = append(x.pending, entry)
+		return nil
+	}
+	return x.write(entry)
+}
+
+// addFrame records the pending resources which are part of frame.
+func (x *resourceIndex) addFrame(frame seekableFrame) error {
+	end := frame.decompressedOffset + int64(frame.decompressedSize)
+	n := 0
+	for ; n < len(x.pending) && x.pending[n].Offset < end; n++ {
+		entry := x.pending[n]
+		entry.Frame = &indexFrame{Offset: frame.offset, Length: frame.compressedSize}
+		entry.Offset -= frame.decompressedOffset
+		if err := x.write(entry); err != nil {
+			return err
+		}
+	}
+	x.pending = x.pending[:copy(x.pending, x.pending[n:])]
+	return nil
+}
+
+func (x *resourceIndex) write(entry indexEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := x.buf.Write(line); err != nil {
+		return err
+	}
+	return x.buf.WriteByte('\n')
+}
+
+func (x *resourceIndex) Flush() error {
+	return x.buf.Flush()
+}
+
+func (x *resourceIndex) Close() error {
+	if err := x.buf.Flush(); err != nil {
+		x.file.Close()
+		return err
+	}
+	return x.file.Close()
+}
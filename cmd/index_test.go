@@ -0,0 +1,95 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelativePath(t *testing.T) {
+	assert.Equal(t, "data/patients.ndjson", relativePath(filepath.Join("export", "index.ndjson"), filepath.Join("export", "data", "patients.ndjson")))
+	assert.Equal(t, "patients.ndjson", relativePath("index.ndjson", "patients.ndjson"))
+}
+
+func TestResourceIndex(t *testing.T) {
+	t.Run("Parts", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "export.ndjson")
+		w := newPartWriter(path, 0, 2)
+		w.index = newResourceIndex(filepath.Join(dir, "index.ndjson"))
+
+		writeNdjson(t, w, `{"resourceType":"Patient","id":"0"}`, `{"resourceType":"Patient","id":"1"}`,
+			`{"resourceType":"Observation","id":"2"}`)
+
+		if assert.NoError(t, w.Close()) {
+			assert.Equal(t, `{"type":"Patient","id":"0","path":"export-0001.ndjson","offset":0,"length":35}
+{"type":"Patient","id":"1","path":"export-0001.ndjson","offset":36,"length":35}
+{"type":"Observation","id":"2","path":"export-0002.ndjson","offset":0,"length":39}
+`, readFile(t, filepath.Join(dir, "index.ndjson")))
+			data := readFile(t, partPath(path, 1))
+			assert.Equal(t, `{"resourceType":"Patient","id":"1"}`, data[36:36+35])
+		}
+	})
+
+	t.Run("Seekable", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "export.ndjson.zst")
+		w := newSeekablePartWriter(path, 0, 0, 40)
+		w.index = newResourceIndex(filepath.Join(dir, "index.ndjson"))
+		resources := []string{`{"resourceType":"Patient","id":"0"}`, `{"resourceType":"Patient","id":"1"}`,
+			`{"resourceType":"Observation","id":"2"}`}
+
+		writeNdjson(t, w, resources[:2]...)
+		assert.NoError(t, w.Flush())
+		writeNdjson(t, w, resources[2:]...)
+
+		if assert.NoError(t, w.Close()) {
+			archive := []byte(readFile(t, path))
+			assert.Equal(t, strings.Join(resources, "\n")+"\n", decompress(t, archive))
+			lines := strings.Split(strings.TrimSpace(readFile(t, filepath.Join(dir, "index.ndjson"))), "\n")
+			if assert.Len(t, lines, 3) {
+				for i, line := range lines {
+					var entry indexEntry
+					if err := json.Unmarshal([]byte(line), &entry); err != nil {
+						t.Fatal(err)
+					}
+					assert.Equal(t, "export.ndjson.zst", entry.Path)
+					if assert.NotNil(t, entry.Frame) {
+						frame := decompress(t, archive[entry.Frame.Offset:entry.Frame.Offset+int64(entry.Frame.Length)])
+						assert.Equal(t, resources[i], frame[entry.Offset:entry.Offset+int64(entry.Length)])
+					}
+				}
+				assert.Contains(t, lines[1], `"frame":{"offset":0,`)
+				assert.Contains(t, lines[2], `"offset":0,"length":39}`)
+				assert.NotContains(t, lines[2], `"frame":{"offset":0,`)
+			}
+		}
+	})
+
+	t.Run("InvalidResource", func(t *testing.T) {
+		dir := t.TempDir()
+		w := newPartWriter(filepath.Join(dir, "export.ndjson"), 0, 0)
+		w.index = newResourceIndex(filepath.Join(dir, "index.ndjson"))
+
+		_, err := w.Write([]byte("foo"))
+
+		assert.ErrorContains(t, err, "could not index resource at offset 0 of ")
+		_ = w.Close()
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	jobTypeSystem             = "https://samply.github.io/blaze/fhir/CodeSystem/JobType"
+	reIndexJobProfile         = "https://samply.github.io/blaze/fhir/StructureDefinition/ReIndexJob"
+	reIndexJobParameterSystem = "https://samply.github.io/blaze/fhir/CodeSystem/ReIndexJobParameter"
+)
+
+// A job is an async job of the Blaze admin API represented by a Task resource.
+//
+// The Task model of the FHIR models isn't used, because it can't be marshaled
+// without the value[x] elements of inputs and outputs.
+type job struct {
+	ResourceType string             `json:"resourceType"`
+	Id           string             `json:"id,omitempty"`
+	Meta         *fm.Meta           `json:"meta,omitempty"`
+	Status       string             `json:"status"`
+	Intent       string             `json:"intent"`
+	Code         fm.CodeableConcept `json:"code"`
+	AuthoredOn   string             `json:"authoredOn,omitempty"`
+	Input        []jobParameter     `json:"input,omitempty"`
+	Output       []jobParameter     `json:"output,omitempty"`
+}
+
+// A jobParameter is an input or output of a job.
+type jobParameter struct {
+	Type             fm.CodeableConcept `json:"type"`
+	ValueCanonical   *string            `json:"valueCanonical,omitempty"`
+	ValueString      *string            `json:"valueString,omitempty"`
+	ValueUnsignedInt *int               `json:"valueUnsignedInt,omitempty"`
+}
+
+// newReIndexJob creates a job which re-indexes all resources for the search
+// parameter with the given canonical URL.
+func newReIndexJob(searchParamUrl string) job {
+	return job{
+		ResourceType: "Task",
+		Meta:         &fm.Meta{Profile: []string{reIndexJobProfile}},
+		Status:       "ready",
+		Intent:       "order",
+		Code:         newCodeableConcept(jobTypeSystem, "re-index"),
+		AuthoredOn:   time.Now().Format(time.RFC3339),
+		Input: []jobParameter{
+			{
+				Type:           newCodeableConcept(reIndexJobParameterSystem, "search-param-url"),
+				ValueCanonical: &searchParamUrl,
+			},
+		},
+	}
+}
+
+func newCodeableConcept(system string, code string) fm.CodeableConcept {
+	return fm.CodeableConcept{Coding: []fm.Coding{{System: &system, Code: &code}}}
+}
+
+// output returns the output of the job with the given code regardless of its
+// code system or nil if there is no such output.
+func (j job) output(code string) *jobParameter {
+	for i, output := range j.Output {
+		for _, coding := range output.Type.Coding {
+			if coding.Code != nil && *coding.Code == code {
+				return &j.Output[i]
+			}
+		}
+	}
+	return nil
+}
+
+// progress returns the number of processed resources and the total number of
+// resources of the job. Returns false if the job didn't report its progress
+// yet.
+func (j job) progress() (processed int, total int, ok bool) {
+	totalOutput := j.output("total-resources")
+	processedOutput := j.output("resources-processed")
+	if totalOutput == nil || totalOutput.ValueUnsignedInt == nil {
+		return 0, 0, false
+	}
+	if processedOutput != nil && processedOutput.ValueUnsignedInt != nil {
+		processed = *processedOutput.ValueUnsignedInt
+	}
+	return processed, *totalOutput.ValueUnsignedInt, true
+}
+
+// String returns the status of the job together with its progress in percent
+// if available.
+func (j job) String() string {
+	processed, total, ok := j.progress()
+	if !ok {
+		return j.Status
+	}
+	percent := 100.0
+	if total > 0 {
+		percent = float64(processed) * 100 / float64(total)
+	}
+	return fmt.Sprintf("%s, %d of %d resources processed (%.1f%%)", j.Status, processed, total, percent)
+}
+
+// isFinished returns true iff the job will not change its status anymore.
+func (j job) isFinished() bool {
+	return j.Status == "completed" || j.Status == "failed" || j.Status == "cancelled"
+}
+
+// errorMessage returns the error message of a failed job.
+func (j job) errorMessage() string {
+	if output := j.output("error"); output != nil && output.ValueString != nil {
+		return *output.ValueString
+	}
+	return "unknown error"
+}
+
+// readJobResponse reads the job returned in resp or returns an error if resp
+// has a status other than expectedStatus.
+func readJobResponse(resp *http.Response, expectedStatus int) (job, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return job{}, err
+	}
+	if resp.StatusCode != expectedStatus {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return job{}, errors.New(errorResponse.String())
+	}
+
+	var j job
+	if err := json.Unmarshal(body, &j); err != nil {
+		return job{}, fmt.Errorf("error while reading the job: %w", err)
+	}
+	return j, nil
+}
+
+// createJob creates the given job using the admin API of the server. Returns
+// the created job including its id.
+func createJob(client *fhir.Client, j job) (job, error) {
+	body, err := json.Marshal(j)
+	if err != nil {
+		return job{}, err
+	}
+
+	req, err := client.Admin().NewCreateRequest("Task", bytes.NewReader(body))
+	if err != nil {
+		return job{}, err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return job{}, err
+	}
+	defer resp.Body.Close()
+
+	return readJobResponse(resp, http.StatusCreated)
+}
+
+// fetchJob fetches the job with the given id using the admin API of the server.
+func fetchJob(client *fhir.Client, id string) (job, error) {
+	req, err := client.Admin().NewReadRequest("Task", id)
+	if err != nil {
+		return job{}, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return job{}, err
+	}
+	defer resp.Body.Close()
+
+	return readJobResponse(resp, http.StatusOK)
+}
+
+// waitForJob polls the job with the given id until it is finished. Calls
+// onUpdate with every fetched state of the job. Returns the finished job.
+func waitForJob(client *fhir.Client, id string, wait time.Duration, timeout <-chan time.Time,
+	onUpdate func(job)) (job, error) {
+	for {
+		select {
+		case <-timeout:
+			return job{}, fmt.Errorf("poll timeout of %s exceeded while waiting on job %s", pollTimeout, id)
+		case <-time.After(wait):
+			j, err := fetchJob(client, id)
+			if err != nil {
+				return job{}, err
+			}
+			onUpdate(j)
+			if j.isFinished() {
+				return j, nil
+			}
+			// exponential wait up to 10 seconds
+			if wait < 10*time.Second {
+				wait *= 2
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewReIndexJob(t *testing.T) {
+	data, err := json.Marshal(newReIndexJob("http://example.com/SearchParameter/foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var task map[string]interface{}
+	if err := json.Unmarshal(data, &task); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "Task", task["resourceType"])
+	assert.Equal(t, "ready", task["status"])
+	assert.Equal(t, "order", task["intent"])
+	assert.Equal(t, []interface{}{reIndexJobProfile}, task["meta"].(map[string]interface{})["profile"])
+	assert.Equal(t, "re-index", task["code"].(map[string]interface{})["coding"].([]interface{})[0].(map[string]interface{})["code"])
+	input := task["input"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "http://example.com/SearchParameter/foo", input["valueCanonical"])
+	assert.NotContains(t, task, "output")
+}
+
+func jobOutput(code string, value string) string {
+	return fmt.Sprintf(`{"type": {"coding": [{"code": "%s"}]}, %s}`, code, value)
+}
+
+func readTestJob(t *testing.T, data string) job {
+	var j job
+	if err := json.Unmarshal([]byte(data), &j); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestJobProgress(t *testing.T) {
+	t.Run("without outputs", func(t *testing.T) {
+		j := readTestJob(t, `{"status": "ready"}`)
+
+		_, _, ok := j.progress()
+
+		assert.False(t, ok)
+		assert.Equal(t, "ready", j.String())
+	})
+
+	t.Run("with outputs", func(t *testing.T) {
+		j := readTestJob(t, `{"status": "in-progress", "output": [`+
+			jobOutput("total-resources", `"valueUnsignedInt": 200`)+`, `+
+			jobOutput("resources-processed", `"valueUnsignedInt": 50`)+`]}`)
+
+		processed, total, ok := j.progress()
+
+		assert.True(t, ok)
+		assert.Equal(t, 50, processed)
+		assert.Equal(t, 200, total)
+		assert.Equal(t, "in-progress, 50 of 200 resources processed (25.0%)", j.String())
+	})
+
+	t.Run("without resources", func(t *testing.T) {
+		j := readTestJob(t, `{"status": "completed", "output": [`+
+			jobOutput("total-resources", `"valueUnsignedInt": 0`)+`]}`)
+
+		assert.Equal(t, "completed, 0 of 0 resources processed (100.0%)", j.String())
+	})
+}
+
+func TestJobErrorMessage(t *testing.T) {
+	j := readTestJob(t, `{"status": "failed", "output": [`+jobOutput("error", `"valueString": "msg-174412"`)+`]}`)
+
+	assert.True(t, j.isFinished())
+	assert.Equal(t, "msg-174412", j.errorMessage())
+}
+
+func TestCreateAndWaitForJob(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		polls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == "POST" && r.URL.Path == "/__admin/Task":
+				body, _ := io.ReadAll(r.Body)
+				var task map[string]interface{}
+				if err := json.Unmarshal(body, &task); err != nil {
+					t.Error(err)
+				}
+				assert.Equal(t, "Task", task["resourceType"])
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"resourceType": "Task", "id": "AAA", "status": "ready"}`))
+			case r.Method == "GET" && r.URL.Path == "/__admin/Task/AAA":
+				polls++
+				status := "in-progress"
+				if polls == 2 {
+					status = "completed"
+				}
+				_, _ = fmt.Fprintf(w, `{"resourceType": "Task", "id": "AAA", "status": "%s", "output": [%s, %s]}`, status,
+					jobOutput("total-resources", `"valueUnsignedInt": 10`),
+					jobOutput("resources-processed", fmt.Sprintf(`"valueUnsignedInt": %d`, polls*5)))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		j, err := createJob(client, newReIndexJob("foo"))
+		if err != nil {
+			t.Fatalf("error while creating the job: %v", err)
+		}
+		assert.Equal(t, "AAA", j.Id)
+
+		var updates []string
+		j, err = waitForJob(client, j.Id, 0, nil, func(j job) { updates = append(updates, j.String()) })
+		if err != nil {
+			t.Fatalf("error while waiting for the job: %v", err)
+		}
+
+		assert.Equal(t, "completed", j.Status)
+		assert.Equal(t, []string{
+			"in-progress, 5 of 10 resources processed (50.0%)",
+			"completed, 10 of 10 resources processed (100.0%)",
+		}, updates)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "invalid", "diagnostics": "msg-175509"}]}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		_, err := createJob(client, newReIndexJob("foo"))
+
+		assert.ErrorContains(t, err, "StatusCode  : 400")
+		assert.ErrorContains(t, err, "msg-175509")
+	})
+}
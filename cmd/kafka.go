@@ -0,0 +1,83 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"os/exec"
+)
+
+var outputKafka string
+var kafkaTopic string
+
+// kafkaWriter publishes each resource of an NDJSON stream as message to a
+// Kafka topic with the key type/id. The messages are produced by the kcat
+// command line client, which has to be installed.
+type kafkaWriter struct {
+	input  *commandInput
+	buf    *bufio.Writer
+	line   []byte
+	closed bool
+}
+
+// newKafkaWriter starts kcat producing to topic on the comma separated list
+// of brokers.
+func newKafkaWriter(brokers string, topic string) (*kafkaWriter, error) {
+	input, err := startInput(exec.Command("kcat", "-P", "-b", brokers, "-t", topic, "-K", "\t"), "kcat")
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaWriter{input: input, buf: bufio.NewWriter(input)}, nil
+}
+
+// Write publishes a message for each complete line of the NDJSON stream.
+func (w *kafkaWriter) Write(p []byte) (int, error) {
+	return writeLines(&w.line, p, w.publish)
+}
+
+// publish writes the message of resource as line of the key, a tab and the
+// resource. Compact JSON never contains a raw tab.
+func (w *kafkaWriter) publish(resource []byte) error {
+	row, err := readResourceRow(resource)
+	if err != nil {
+		return err
+	}
+	if _, err := w.buf.WriteString(row.resourceType + "/" + row.id + "\t"); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(resource); err != nil {
+		return err
+	}
+	return w.buf.WriteByte('\n')
+}
+
+// Flush passes the messages written so far to kcat.
+func (w *kafkaWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// Close waits until kcat has delivered all messages and exited. Closing twice
+// has no effect.
+func (w *kafkaWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.Flush(); err != nil {
+		w.input.Close()
+		return err
+	}
+	return w.input.Close()
+}
@@ -0,0 +1,33 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKafkaWriterPublish(t *testing.T) {
+	var buf bytes.Buffer
+	w := kafkaWriter{buf: bufio.NewWriter(&buf)}
+
+	_, err := w.Write([]byte("{\"resourceType\":\"Patient\",\"id\":\"0\",\"name\":[{\"text\":\"a\\tb\"}]}\n"))
+
+	if assert.NoError(t, err) && assert.NoError(t, w.Flush()) {
+		assert.Equal(t, "Patient/0\t{\"resourceType\":\"Patient\",\"id\":\"0\",\"name\":[{\"text\":\"a\\tb\"}]}\n", buf.String())
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/spf13/cobra"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var lastUpdatedFrom string
+var lastUpdatedTo string
+var lastUpdatedInterval string
+
+// lastUpdatedBarWidth is the width of the bar of the largest bucket.
+const lastUpdatedBarWidth = 50
+
+// lastUpdatedBucket is a half-open range [start, end) of lastUpdated times.
+type lastUpdatedBucket struct {
+	label      string
+	start, end time.Time
+}
+
+// parseLastUpdatedDate parses a date of the form YYYY-MM-DD or YYYY-MM.
+func parseLastUpdatedDate(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	if date, err := time.Parse("2006-01", value); err == nil {
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date `%s`, expected YYYY-MM-DD or YYYY-MM", value)
+}
+
+// lastUpdatedBuckets returns consecutive buckets of one day or one month
+// starting with the bucket containing from and ending with the bucket
+// containing to.
+func lastUpdatedBuckets(from time.Time, to time.Time, interval string) ([]lastUpdatedBucket, error) {
+	var start time.Time
+	var next func(time.Time) time.Time
+	var layout string
+	switch interval {
+	case "day":
+		start = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+		layout = "2006-01-02"
+	case "month":
+		start = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+		layout = "2006-01"
+	default:
+		return nil, fmt.Errorf("invalid interval `%s`, expected day or month", interval)
+	}
+	if to.Before(from) {
+		return nil, errors.New("the end date is before the start date")
+	}
+
+	var buckets []lastUpdatedBucket
+	for !start.After(to) {
+		end := next(start)
+		buckets = append(buckets, lastUpdatedBucket{label: start.Format(layout), start: start, end: end})
+		start = end
+	}
+	return buckets, nil
+}
+
+// countLastUpdated counts the resources of resourceType, or all resources if
+// resourceType is empty, last updated within bucket.
+func countLastUpdated(client *fhir.Client, resourceType string, bucket lastUpdatedBucket) (int, error) {
+	query := url.Values{
+		"_lastUpdated": []string{"ge" + bucket.start.Format("2006-01-02"), "lt" + bucket.end.Format("2006-01-02")},
+		"_summary":     []string{"count"},
+	}
+	var req *http.Request
+	var err error
+	if resourceType == "" {
+		req, err = client.NewSearchSystemRequest(query)
+	} else {
+		req, err = client.NewSearchTypeRequest(resourceType, query)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return 0, fmt.Errorf("error while counting resources last updated in %s:\n\n%s", bucket.label, errorResponse.String())
+	}
+	bundle, err := fhir.ReadBundle(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if bundle.Total == nil {
+		return 0, fmt.Errorf("missing total in the search result of %s", bucket.label)
+	}
+	return *bundle.Total, nil
+}
+
+// fmtLastUpdatedHistogram formats the counts of all buckets as horizontal bar
+// chart followed by the total.
+func fmtLastUpdatedHistogram(buckets []lastUpdatedBucket, counts []int) string {
+	maxLabel, maxCount, total := 5, 0, 0
+	for i, bucket := range buckets {
+		maxLabel = maxInt(maxLabel, len(bucket.label))
+		maxCount = maxInt(maxCount, counts[i])
+		total += counts[i]
+	}
+	countWidth := len(fmt.Sprintf("%d", total))
+	format := "%-" + fmt.Sprintf("%d", maxLabel) + "s : %" + fmt.Sprintf("%d", countWidth) + "d"
+
+	builder := strings.Builder{}
+	for i, bucket := range buckets {
+		builder.WriteString(fmt.Sprintf(format, bucket.label, counts[i]))
+		if maxCount > 0 && counts[i] > 0 {
+			builder.WriteString(" " + strings.Repeat("#", maxInt(1, counts[i]*lastUpdatedBarWidth/maxCount)))
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString(strings.Repeat("-", maxLabel+countWidth+3) + "\n")
+	builder.WriteString(fmt.Sprintf(format, "total", total) + "\n")
+	return builder.String()
+}
+
+var lastUpdatedCmd = &cobra.Command{
+	Use:   "last-updated [resource-type]",
+	Short: "Counts resources by the time of their last update",
+	Long: `Counts resources by meta.lastUpdated per day or month and prints a
+histogram. Each bucket is counted by a search with a _lastUpdated range and
+_summary=count. Dates are interpreted by the server, usually in UTC.
+
+If the optional resource-type is given, only resources of that type are
+counted. Otherwise, the system-level search is used.
+
+The report shows the load over time and is useful for retention and
+archival planning.
+
+Examples:
+  blazectl last-updated --server http://localhost:8080/fhir --from 2024-01
+  blazectl last-updated --server http://localhost:8080/fhir Observation --from 2024-09-01 --to 2024-09-30 --interval day`,
+	ValidArgsFunction: completeResourceTypes(1),
+	Args:              cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, err := parseLastUpdatedDate(lastUpdatedFrom)
+		if err != nil {
+			return err
+		}
+		to := time.Now().UTC()
+		if lastUpdatedTo != "" {
+			if to, err = parseLastUpdatedDate(lastUpdatedTo); err != nil {
+				return err
+			}
+		}
+		buckets, err := lastUpdatedBuckets(from, to, lastUpdatedInterval)
+		if err != nil {
+			return err
+		}
+
+		err = createClient()
+		if err != nil {
+			return err
+		}
+
+		var resourceType string
+		if len(args) > 0 {
+			resourceType = args[0]
+		}
+
+		counts := make([]int, 0, len(buckets))
+		for _, bucket := range buckets {
+			count, err := countLastUpdated(client, resourceType, bucket)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			counts = append(counts, count)
+		}
+		client.CloseIdleConnections()
+
+		fmt.Print(fmtLastUpdatedHistogram(buckets, counts))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lastUpdatedCmd)
+
+	lastUpdatedCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	lastUpdatedCmd.Flags().StringVar(&lastUpdatedFrom, "from", "", "first day or month to count (YYYY-MM-DD or YYYY-MM)")
+	lastUpdatedCmd.Flags().StringVar(&lastUpdatedTo, "to", "", "last day or month to count (YYYY-MM-DD or YYYY-MM), defaults to today")
+	lastUpdatedCmd.Flags().StringVar(&lastUpdatedInterval, "interval", "month", "size of the buckets, one of day or month")
+
+	_ = lastUpdatedCmd.MarkFlagRequired("server")
+	_ = lastUpdatedCmd.MarkFlagRequired("from")
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLastUpdatedBuckets(t *testing.T) {
+	t.Run("month", func(t *testing.T) {
+		buckets, err := lastUpdatedBuckets(time.Date(2023, 11, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), "month")
+
+		if assert.NoError(t, err) {
+			assert.Len(t, buckets, 3)
+			assert.Equal(t, "2023-11", buckets[0].label)
+			assert.Equal(t, time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC), buckets[0].start)
+			assert.Equal(t, "2024-01", buckets[2].label)
+			assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), buckets[2].end)
+		}
+	})
+
+	t.Run("day", func(t *testing.T) {
+		buckets, err := lastUpdatedBuckets(time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "day")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"2024-02-28", "2024-02-29", "2024-03-01"},
+				[]string{buckets[0].label, buckets[1].label, buckets[2].label})
+		}
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		_, err := lastUpdatedBuckets(time.Now(), time.Now(), "week")
+		assert.EqualError(t, err, "invalid interval `week`, expected day or month")
+	})
+
+	t.Run("end before start", func(t *testing.T) {
+		_, err := lastUpdatedBuckets(time.Now(), time.Now().AddDate(0, -1, 0), "day")
+		assert.EqualError(t, err, "the end date is before the start date")
+	})
+}
+
+func TestParseLastUpdatedDate(t *testing.T) {
+	date, err := parseLastUpdatedDate("2024-09")
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), date)
+	}
+
+	_, err = parseLastUpdatedDate("09/2024")
+	assert.EqualError(t, err, "invalid date `09/2024`, expected YYYY-MM-DD or YYYY-MM")
+}
+
+func TestCountLastUpdated(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/Observation", r.URL.Path)
+		assert.Equal(t, []string{"ge2024-09-01", "lt2024-10-01"}, r.URL.Query()["_lastUpdated"])
+		assert.Equal(t, "count", r.URL.Query().Get("_summary"))
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "total": 42}`))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+	bucket := lastUpdatedBucket{label: "2024-09", start: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+		end: time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)}
+
+	count, err := countLastUpdated(client, "Observation", bucket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 42, count)
+	}
+}
+
+func TestFmtLastUpdatedHistogram(t *testing.T) {
+	buckets := []lastUpdatedBucket{{label: "2024-08"}, {label: "2024-09"}, {label: "2024-10"}}
+
+	assert.Equal(t, "2024-08 :  50 #########################\n"+
+		"2024-09 : 100 ##################################################\n"+
+		"2024-10 :   0\n"+
+		"-------------\n"+
+		"total   : 150\n", fmtLastUpdatedHistogram(buckets, []int{50, 100, 0}))
+}
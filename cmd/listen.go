@@ -0,0 +1,300 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+var listenAddr string
+var listenPath string
+var subscriptionCriteria string
+var subscriptionEndpoint string
+
+// notificationResources returns the resources of a rest-hook notification.
+// Notification bundles of type history or subscription-notification are
+// unwrapped, leaving out the SubscriptionStatus and the Parameters of the
+// subscription backport. Other resources are returned as they are. An empty
+// body, like the one of a notification without payload, has no resources.
+func notificationResources(body []byte) ([]json.RawMessage, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	var notification struct {
+		ResourceType string `json:"resourceType"`
+		Type         string `json:"type"`
+		Entry        []struct {
+			Resource json.RawMessage `json:"resource"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("could not parse the notification: %v", err)
+	}
+	if notification.ResourceType != "Bundle" ||
+		(notification.Type != "history" && notification.Type != "subscription-notification") {
+		return []json.RawMessage{body}, nil
+	}
+	var resources []json.RawMessage
+	for _, entry := range notification.Entry {
+		if len(entry.Resource) == 0 {
+			continue
+		}
+		var resource struct {
+			ResourceType string `json:"resourceType"`
+		}
+		if err := json.Unmarshal(entry.Resource, &resource); err != nil {
+			return nil, fmt.Errorf("could not parse a resource of the notification: %v", err)
+		}
+		if resource.ResourceType == "SubscriptionStatus" || resource.ResourceType == "Parameters" {
+			continue
+		}
+		resources = append(resources, entry.Resource)
+	}
+	return resources, nil
+}
+
+// notificationHandler appends the resources of each notification posted to
+// it as NDJSON to the sink.
+type notificationHandler struct {
+	mutex         sync.Mutex
+	sink          flushWriter
+	notifications int
+	resources     int
+}
+
+func (h *notificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "notifications have to be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resources, err := notificationResources(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.write(resources); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the resources of a notification: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *notificationHandler) write(resources []json.RawMessage) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.notifications++
+	var buf bytes.Buffer
+	for _, resource := range resources {
+		buf.Reset()
+		if err := json.Compact(&buf, resource); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		if _, err := h.sink.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		h.resources++
+	}
+	return h.sink.Flush()
+}
+
+// createSubscription creates a rest-hook Subscription with the given criteria
+// notifying the endpoint with the full resources. Returns the id of the
+// Subscription.
+func createSubscription(client *fhir.Client, criteria string, endpoint string) (string, error) {
+	payload := "application/fhir+json"
+	subscription := fm.Subscription{
+		Status:   fm.SubscriptionStatusRequested,
+		Reason:   "blazectl listen",
+		Criteria: criteria,
+		Channel: fm.SubscriptionChannel{
+			Type:     fm.SubscriptionChannelTypeRestHook,
+			Endpoint: &endpoint,
+			Payload:  &payload,
+		},
+	}
+	body, err := subscription.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return createResource(client, "Subscription", "application/fhir+json", bytes.NewReader(body), int64(len(body)))
+}
+
+// deleteSubscription deletes the Subscription with the given id.
+func deleteSubscription(client *fhir.Client, id string) error {
+	req, err := client.NewDeleteRequest("Subscription", id)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if isSuccessfulStatus(resp.StatusCode) {
+		return nil
+	}
+	errorResponse := util.ReadErrorResponse(resp)
+	return errors.New(errorResponse.String())
+}
+
+// openNotificationSink opens the output of the listen command, which is the
+// file given by --output-file, appending to it, the command given by
+// --output-cmd or stdout. Returns the sink together with the function
+// closing it.
+func openNotificationSink() (flushWriter, func() error, error) {
+	switch {
+	case outputFile != "":
+		file, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewWriter(file), file.Close, nil
+	case outputCmd != "":
+		output, err := newCommandWriter(outputCmd, 0, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		return output, output.Close, nil
+	default:
+		return bufio.NewWriter(os.Stdout), func() error { return nil }, nil
+	}
+}
+
+var listenCmd = &cobra.Command{
+	Use:   "listen",
+	Short: "Receive rest-hook Subscription notifications",
+	Long: `Runs a local HTTP listener, which can be used as endpoint of a rest-hook
+Subscription, and appends the resources of all notifications posted to it as
+NDJSON to stdout, to the file given by --output-file or to the standard input
+of the shell command given by --output-cmd.
+
+Notification bundles are unwrapped into their resources, leaving out the
+SubscriptionStatus. Notifications without payload are counted but don't add
+any resources.
+
+With --criteria, a Subscription with the criteria and the URL given by
+--endpoint is created on the server given by --server and deleted again when
+the listener is stopped with Ctrl-C. The endpoint is the URL under which the
+server reaches the listener.
+
+Examples:
+  blazectl listen --addr :8090 -o changes.ndjson
+  blazectl listen --addr :8090 --server http://localhost:8080/fhir --criteria "Observation?code=http://loinc.org|8310-5" --endpoint http://host.docker.internal:8090/`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if outputFile != "" && outputCmd != "" {
+			return fmt.Errorf("the flags --output-file and --output-cmd can't be used together")
+		}
+		if subscriptionCriteria != "" && (server == "" || subscriptionEndpoint == "") {
+			return fmt.Errorf("the flag --criteria requires --server and --endpoint")
+		}
+
+		sink, closeSink, err := openNotificationSink()
+		if err != nil {
+			return err
+		}
+		handler := &notificationHandler{sink: sink}
+		mux := http.NewServeMux()
+		mux.Handle(listenPath, handler)
+
+		listener, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			return err
+		}
+		httpServer := &http.Server{Handler: mux}
+		serverDone := make(chan error, 1)
+		go func() {
+			serverDone <- httpServer.Serve(listener)
+		}()
+		fmt.Fprintf(os.Stderr, "Listening on %s for notifications ...\n", listener.Addr())
+
+		var subscriptionId string
+		if subscriptionCriteria != "" {
+			if err := createClient(); err != nil {
+				return err
+			}
+			if subscriptionId, err = createSubscription(client, subscriptionCriteria, subscriptionEndpoint); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Created Subscription/%s.\n", subscriptionId)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		select {
+		case <-ctx.Done():
+		case err := <-serverDone:
+			return err
+		}
+
+		if subscriptionId != "" {
+			if err := deleteSubscription(client, subscriptionId); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to delete Subscription/%s: %v\n", subscriptionId, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Deleted Subscription/%s.\n", subscriptionId)
+			}
+		}
+		if err := httpServer.Shutdown(context.Background()); err != nil {
+			return err
+		}
+		if err := sink.Flush(); err != nil {
+			return err
+		}
+		if err := closeSink(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Received %d notifications with %d resources.\n", handler.notifications, handler.resources)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listenCmd)
+
+	listenCmd.Flags().StringVar(&listenAddr, "addr", "localhost:8090", "the address to listen on")
+	listenCmd.Flags().StringVar(&listenPath, "path", "/", "the path to receive notifications on")
+	listenCmd.Flags().StringVarP(&outputFile, "output-file", "o", "", "append to file instead of writing to stdout")
+	listenCmd.Flags().StringVar(&outputCmd, "output-cmd", "", "pipe the resources into the standard input of this shell command instead of stdout")
+	listenCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to create a Subscription on")
+	listenCmd.Flags().StringVar(&subscriptionCriteria, "criteria", "", "create a rest-hook Subscription with this criteria for the time of listening")
+	listenCmd.Flags().StringVar(&subscriptionEndpoint, "endpoint", "", "the URL of the listener as reachable from the server")
+
+	_ = listenCmd.MarkFlagFilename("output-file", "ndjson")
+}
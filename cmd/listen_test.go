@@ -0,0 +1,130 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNotificationResources(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		resources, err := notificationResources([]byte("  "))
+
+		if assert.NoError(t, err) {
+			assert.Empty(t, resources)
+		}
+	})
+
+	t.Run("resource", func(t *testing.T) {
+		resources, err := notificationResources([]byte(`{"resourceType": "Patient", "id": "0"}`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []json.RawMessage{json.RawMessage(`{"resourceType": "Patient", "id": "0"}`)}, resources)
+		}
+	})
+
+	t.Run("subscription notification", func(t *testing.T) {
+		resources, err := notificationResources([]byte(`{"resourceType": "Bundle", "type": "subscription-notification",
+			"entry": [{"resource": {"resourceType": "SubscriptionStatus"}},
+			  {"fullUrl": "Patient/0"},
+			  {"resource": {"resourceType": "Patient", "id": "0"}}]}`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []json.RawMessage{json.RawMessage(`{"resourceType": "Patient", "id": "0"}`)}, resources)
+		}
+	})
+
+	t.Run("searchset bundle", func(t *testing.T) {
+		resources, err := notificationResources([]byte(`{"resourceType": "Bundle", "type": "searchset"}`))
+
+		if assert.NoError(t, err) {
+			assert.Len(t, resources, 1)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := notificationResources([]byte("foo"))
+
+		assert.ErrorContains(t, err, "could not parse the notification")
+	})
+}
+
+func TestNotificationHandler(t *testing.T) {
+	t.Run("post", func(t *testing.T) {
+		var buf bytes.Buffer
+		handler := &notificationHandler{sink: bufio.NewWriter(&buf)}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"resourceType": "Bundle", "type": "history",
+			"entry": [{"resource": {"resourceType": "Patient", "id": "0"}}, {"resource": {"resourceType": "Patient", "id": "1"}}]}`)))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "{\"resourceType\":\"Patient\",\"id\":\"0\"}\n{\"resourceType\":\"Patient\",\"id\":\"1\"}\n", buf.String())
+		assert.Equal(t, 1, handler.notifications)
+		assert.Equal(t, 2, handler.resources)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		handler := &notificationHandler{sink: bufio.NewWriter(io.Discard)}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		handler := &notificationHandler{sink: bufio.NewWriter(io.Discard)}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("foo")))
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, 0, handler.notifications)
+	})
+}
+
+func TestCreateSubscription(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/Subscription", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"resourceType": "Subscription", "status": "requested", "reason": "blazectl listen",
+			"criteria": "Patient?gender=female",
+			"channel": {"type": "rest-hook", "endpoint": "http://localhost:8090/", "payload": "application/fhir+json"}}`, string(body))
+		w.Header().Set("Location", "/Subscription/AAA/_history/1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	id, err := createSubscription(client, "Patient?gender=female", "http://localhost:8090/")
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "AAA", id)
+	}
+}
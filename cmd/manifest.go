@@ -0,0 +1,163 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+var manifestFile string
+var signKeyFile string
+
+// hashingWriter passes all data to the underlying writer while counting the
+// bytes and computing their SHA-256 checksum.
+type hashingWriter struct {
+	w     io.Writer
+	hash  hash.Hash
+	bytes int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, hash: sha256.New()}
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.hash.Write(p[:n])
+	w.bytes += int64(n)
+	return n, err
+}
+
+// sum returns the hex encoded SHA-256 checksum of the data written so far.
+func (w *hashingWriter) sum() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// manifestEntry describes one output file of a download. The path is
+// relative to the manifest.
+type manifestEntry struct {
+	Path      string `json:"path"`
+	Resources int    `json:"resources"`
+	Bytes     int64  `json:"bytes"`
+	Sha256    string `json:"sha256"`
+}
+
+// manifestSignature is the base64 encoded signature of the compact JSON of a
+// manifest without signature.
+type manifestSignature struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+type manifest struct {
+	Files     []manifestEntry    `json:"files"`
+	Signature *manifestSignature `json:"signature,omitempty"`
+}
+
+// newManifestEntry describes the output file at path, whose content was
+// written through w, relative to the manifest at manifestPath.
+func newManifestEntry(manifestPath string, path string, resources int, w *hashingWriter) manifestEntry {
+	return manifestEntry{Path: relativePath(manifestPath, path), Resources: resources, Bytes: w.bytes, Sha256: w.sum()}
+}
+
+// readSigningKey reads a PEM encoded PKCS #8 private key from the file at
+// path. Ed25519, ECDSA and RSA keys are supported.
+func readSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("the signing key %s isn't PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the signing key %s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("the signing key %s can't be used for signing", path)
+	}
+	return signer, nil
+}
+
+// sign signs the manifest with key. Ed25519 keys sign the payload itself,
+// ECDSA and RSA keys its SHA-256 digest.
+func (m *manifest) sign(key crypto.Signer) error {
+	payload, err := json.Marshal(manifest{Files: m.Files})
+	if err != nil {
+		return err
+	}
+
+	var algorithm string
+	var signature []byte
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		algorithm = "Ed25519"
+		signature, err = key.Sign(rand.Reader, payload, crypto.Hash(0))
+	case *ecdsa.PrivateKey:
+		algorithm = "ECDSA-SHA256"
+		digest := sha256.Sum256(payload)
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case *rsa.PrivateKey:
+		algorithm = "RSA-SHA256"
+		digest := sha256.Sum256(payload)
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return errors.New("unsupported signing key, expected an Ed25519, ECDSA or RSA key")
+	}
+	if err != nil {
+		return err
+	}
+	m.Signature = &manifestSignature{Algorithm: algorithm, Value: base64.StdEncoding.EncodeToString(signature)}
+	return nil
+}
+
+// writeManifest writes the manifest of files to the new file at path, signed
+// with key if given.
+func writeManifest(path string, files []manifestEntry, key crypto.Signer) error {
+	m := manifest{Files: files}
+	if key != nil {
+		if err := m.sign(key); err != nil {
+			return fmt.Errorf("error while signing the manifest: %w", err)
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := createOutputFileOrDie(path)
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newHashingWriter(&buf)
+
+	_, _ = w.Write([]byte("{\"resourceType\":\"Patient\"}\n"))
+
+	assert.Equal(t, "{\"resourceType\":\"Patient\"}\n", buf.String())
+	assert.Equal(t, int64(27), w.bytes)
+	sum := sha256.Sum256(buf.Bytes())
+	assert.Equal(t, hex.EncodeToString(sum[:]), w.sum())
+}
+
+func TestNewManifestEntry(t *testing.T) {
+	w := newHashingWriter(&bytes.Buffer{})
+	_, _ = w.Write([]byte("a"))
+
+	entry := newManifestEntry(filepath.Join("export", "manifest.json"), filepath.Join("export", "data", "patients.ndjson"), 1, w)
+
+	assert.Equal(t, manifestEntry{
+		Path:      "data/patients.ndjson",
+		Resources: 1,
+		Bytes:     1,
+		Sha256:    "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+	}, entry)
+}
+
+func writeKey(t *testing.T, key any) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("error while encoding the key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal("can't create a temp key file")
+	}
+	return path
+}
+
+func TestManifestSign(t *testing.T) {
+	files := []manifestEntry{{Path: "patients.ndjson", Resources: 1, Bytes: 1, Sha256: "ab"}}
+	payload, _ := json.Marshal(manifest{Files: files})
+
+	t.Run("Ed25519", func(t *testing.T) {
+		publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+		key, err := readSigningKey(writeKey(t, privateKey))
+		if err != nil {
+			t.Fatalf("error while reading the key: %v", err)
+		}
+
+		m := manifest{Files: files}
+		if assert.NoError(t, m.sign(key)) {
+			assert.Equal(t, "Ed25519", m.Signature.Algorithm)
+			signature, _ := base64.StdEncoding.DecodeString(m.Signature.Value)
+			assert.True(t, ed25519.Verify(publicKey, payload, signature))
+		}
+	})
+
+	t.Run("ECDSA", func(t *testing.T) {
+		privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		key, err := readSigningKey(writeKey(t, privateKey))
+		if err != nil {
+			t.Fatalf("error while reading the key: %v", err)
+		}
+
+		m := manifest{Files: files}
+		if assert.NoError(t, m.sign(key)) {
+			assert.Equal(t, "ECDSA-SHA256", m.Signature.Algorithm)
+			signature, _ := base64.StdEncoding.DecodeString(m.Signature.Value)
+			digest := sha256.Sum256(payload)
+			assert.True(t, ecdsa.VerifyASN1(&privateKey.PublicKey, digest[:], signature))
+		}
+	})
+}
+
+func TestReadSigningKey(t *testing.T) {
+	t.Run("NotPEM", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		if err := os.WriteFile(path, []byte("foo"), 0600); err != nil {
+			t.Fatal("can't create a temp key file")
+		}
+
+		_, err := readSigningKey(path)
+
+		assert.EqualError(t, err, "the signing key "+path+" isn't PEM encoded")
+	})
+}
+
+func TestWriteManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	files := []manifestEntry{{Path: "patients.ndjson", Resources: 2, Bytes: 10, Sha256: "ab"}}
+
+	if assert.NoError(t, writeManifest(path, files, nil)) {
+		data, _ := os.ReadFile(path)
+		assert.JSONEq(t, `{"files": [{"path": "patients.ndjson", "resources": 2, "bytes": 10, "sha256": "ab"}]}`, string(data))
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var saveReport bool
+var renderReport bool
+var reportCsvFile string
+
+// saveMeasureReport creates the given MeasureReport on the server. Returns the
+// id assigned by the server.
+func saveMeasureReport(client *fhir.Client, measureReport []byte) (string, error) {
+	req, err := client.NewCreateRequest("MeasureReport", bytes.NewReader(measureReport))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return "", fmt.Errorf("error while saving the MeasureReport:\n\n%s", errorResponse.String())
+	}
+
+	var saved struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &saved); err != nil {
+		return "", fmt.Errorf("error while reading the saved MeasureReport: %w", err)
+	}
+	return saved.Id, nil
+}
+
+// fetchMeasureReport fetches the MeasureReport with the given id.
+func fetchMeasureReport(client *fhir.Client, id string) ([]byte, error) {
+	req, err := client.NewReadRequest("MeasureReport", id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return nil, fmt.Errorf("error while fetching the MeasureReport with id %s:\n\n%s", id, errorResponse.String())
+	}
+	return body, nil
+}
+
+// measureReportRow is a single count of a MeasureReport. Stratifier and
+// stratum are empty for the counts of whole groups.
+type measureReportRow struct {
+	group      int
+	stratifier string
+	stratum    string
+	population string
+	count      int
+}
+
+// measureReportRows flattens the counts of all populations of the groups and
+// strata of report into rows. Groups are numbered starting with 1.
+func measureReportRows(report fm.MeasureReport) []measureReportRow {
+	var rows []measureReportRow
+	for i, group := range report.Group {
+		for _, population := range group.Population {
+			rows = append(rows, measureReportRow{
+				group:      i + 1,
+				population: codeableConceptText(population.Code),
+				count:      intValue(population.Count),
+			})
+		}
+		for _, stratifier := range group.Stratifier {
+			stratifierCodes := make([]string, 0, len(stratifier.Code))
+			for _, code := range stratifier.Code {
+				stratifierCodes = append(stratifierCodes, codeableConceptText(&code))
+			}
+			for _, stratum := range stratifier.Stratum {
+				for _, population := range stratum.Population {
+					rows = append(rows, measureReportRow{
+						group:      i + 1,
+						stratifier: strings.Join(stratifierCodes, ","),
+						stratum:    stratumValue(stratum),
+						population: codeableConceptText(population.Code),
+						count:      intValue(population.Count),
+					})
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// stratumValue returns the value of stratum. The values of the components of
+// multi-component strata are joined by commas.
+func stratumValue(stratum fm.MeasureReportGroupStratifierStratum) string {
+	if stratum.Value != nil {
+		return codeableConceptText(stratum.Value)
+	}
+	values := make([]string, 0, len(stratum.Component))
+	for _, component := range stratum.Component {
+		values = append(values, codeableConceptText(&component.Value))
+	}
+	return strings.Join(values, ",")
+}
+
+// codeableConceptText returns the text of concept or the code of its first
+// coding if it has no text.
+func codeableConceptText(concept *fm.CodeableConcept) string {
+	if concept == nil {
+		return ""
+	}
+	if concept.Text != nil {
+		return *concept.Text
+	}
+	for _, coding := range concept.Coding {
+		if coding.Code != nil {
+			return *coding.Code
+		}
+	}
+	return ""
+}
+
+func intValue(value *int) int {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+// mergeMeasureReports merges the MeasureReports of the same Measure, evaluated
+// for different subjects, into one summary report. The counts of populations
+// of groups are added by position. The counts of strata are added by stratum
+// value, so that strata only occurring in some reports are kept. The subject
+// and subject results of the individual reports are dropped.
+func mergeMeasureReports(reports []fm.MeasureReport) (fm.MeasureReport, error) {
+	if len(reports) == 0 {
+		return fm.MeasureReport{}, errors.New("no MeasureReports to merge")
+	}
+	merged := reports[0]
+	merged.Id = nil
+	merged.Type = fm.MeasureReportTypeSummary
+	merged.Subject = nil
+	merged.Group = nil
+	for _, report := range reports {
+		if report.Measure != merged.Measure {
+			return fm.MeasureReport{}, fmt.Errorf("can't merge MeasureReports of the different Measures %s and %s",
+				merged.Measure, report.Measure)
+		}
+		for i, group := range report.Group {
+			if i == len(merged.Group) {
+				merged.Group = append(merged.Group, fm.MeasureReportGroup{Code: group.Code})
+			}
+			mergeMeasureReportGroup(&merged.Group[i], group)
+		}
+	}
+	return merged, nil
+}
+
+func mergeMeasureReportGroup(merged *fm.MeasureReportGroup, group fm.MeasureReportGroup) {
+	for i, population := range group.Population {
+		if i == len(merged.Population) {
+			merged.Population = append(merged.Population, fm.MeasureReportGroupPopulation{Code: population.Code})
+		}
+		merged.Population[i].Count = addCount(merged.Population[i].Count, population.Count)
+	}
+	for i, stratifier := range group.Stratifier {
+		if i == len(merged.Stratifier) {
+			merged.Stratifier = append(merged.Stratifier, fm.MeasureReportGroupStratifier{Code: stratifier.Code})
+		}
+		for _, stratum := range stratifier.Stratum {
+			mergeMeasureReportStratum(&merged.Stratifier[i], stratum)
+		}
+	}
+}
+
+func mergeMeasureReportStratum(merged *fm.MeasureReportGroupStratifier, stratum fm.MeasureReportGroupStratifierStratum) {
+	value := stratumValue(stratum)
+	index := -1
+	for i := range merged.Stratum {
+		if stratumValue(merged.Stratum[i]) == value {
+			index = i
+		}
+	}
+	if index < 0 {
+		merged.Stratum = append(merged.Stratum, fm.MeasureReportGroupStratifierStratum{
+			Value:     stratum.Value,
+			Component: stratum.Component,
+		})
+		index = len(merged.Stratum) - 1
+	}
+	for i, population := range stratum.Population {
+		if i == len(merged.Stratum[index].Population) {
+			merged.Stratum[index].Population = append(merged.Stratum[index].Population,
+				fm.MeasureReportGroupStratifierStratumPopulation{Code: population.Code})
+		}
+		merged.Stratum[index].Population[i].Count = addCount(merged.Stratum[index].Population[i].Count, population.Count)
+	}
+}
+
+func addCount(a *int, b *int) *int {
+	sum := intValue(a) + intValue(b)
+	return &sum
+}
+
+// renderMeasureReport renders the MeasureReport in JSON format as human
+// readable table of its counts.
+func renderMeasureReport(measureReport []byte) (string, error) {
+	report, err := fm.UnmarshalMeasureReport(measureReport)
+	if err != nil {
+		return "", fmt.Errorf("error while reading the MeasureReport: %w", err)
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Measure     : %s\n", report.Measure))
+	if report.Period.Start != nil && report.Period.End != nil {
+		builder.WriteString(fmt.Sprintf("Period      : %s - %s\n", *report.Period.Start, *report.Period.End))
+	}
+	builder.WriteString("\n")
+
+	w := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tSTRATIFIER\tSTRATUM\tPOPULATION\tCOUNT")
+	for _, row := range measureReportRows(report) {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\n", row.group, row.stratifier, row.stratum, row.population, row.count)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// writeMeasureReportCsv writes the counts of the MeasureReport in JSON format
+// as CSV with the columns group, stratifier, stratum, population and count.
+func writeMeasureReportCsv(measureReport []byte, w io.Writer) error {
+	report, err := fm.UnmarshalMeasureReport(measureReport)
+	if err != nil {
+		return fmt.Errorf("error while reading the MeasureReport: %w", err)
+	}
+
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write([]string{"group", "stratifier", "stratum", "population", "count"}); err != nil {
+		return err
+	}
+	for _, row := range measureReportRows(report) {
+		record := []string{strconv.Itoa(row.group), row.stratifier, row.stratum, row.population, strconv.Itoa(row.count)}
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// writeMeasureReportCsvFile writes the counts of the MeasureReport in JSON
+// format as CSV into the file with the given name.
+func writeMeasureReportCsvFile(measureReport []byte, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := writeMeasureReportCsv(measureReport, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var fetchReportCmd = &cobra.Command{
+	Use:   "fetch-report [id]",
+	Short: "Fetches a MeasureReport",
+	Long: `Fetches the MeasureReport with the given id, for example one saved by
+evaluate-measure --save-report, and prints it in JSON format. With --render,
+the counts of the MeasureReport are printed as table instead.
+
+Examples:
+  blazectl fetch-report --server "http://localhost:8080/fhir" DCVGKQ3GVQBHQKLP
+  blazectl fetch-report --server "http://localhost:8080/fhir" --render DCVGKQ3GVQBHQKLP`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one id argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		measureReport, err := fetchMeasureReport(client, args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if !renderReport {
+			fmt.Println(string(measureReport))
+			return nil
+		}
+		rendered, err := renderMeasureReport(measureReport)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(rendered)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fetchReportCmd)
+
+	fetchReportCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	fetchReportCmd.Flags().BoolVar(&renderReport, "render", false, "print the counts of the MeasureReport as table")
+
+	_ = fetchReportCmd.MarkFlagRequired("server")
+}
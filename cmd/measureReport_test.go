@@ -0,0 +1,198 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testMeasureReport = `{
+  "resourceType": "MeasureReport",
+  "status": "complete",
+  "type": "summary",
+  "measure": "urn:uuid:0",
+  "period": {"start": "1900", "end": "2200"},
+  "group": [{
+    "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 3}],
+    "stratifier": [{
+      "code": [{"text": "gender"}],
+      "stratum": [
+        {"value": {"text": "female"}, "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 2}]},
+        {"value": {"text": "male"}, "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 1}]}
+      ]
+    }]
+  }]
+}`
+
+func TestSaveMeasureReport(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "/MeasureReport", r.URL.Path)
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, testMeasureReport, string(body))
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"resourceType": "MeasureReport", "id": "AAA"}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		id, err := saveMeasureReport(client, []byte(testMeasureReport))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "AAA", id)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		_, err := saveMeasureReport(client, []byte(testMeasureReport))
+
+		assert.ErrorContains(t, err, "StatusCode  : 403")
+	})
+}
+
+func TestFetchMeasureReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/MeasureReport/AAA" {
+			_, _ = w.Write([]byte(testMeasureReport))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	measureReport, err := fetchMeasureReport(client, "AAA")
+	assert.NoError(t, err)
+	assert.Equal(t, testMeasureReport, string(measureReport))
+
+	_, err = fetchMeasureReport(client, "BBB")
+	assert.ErrorContains(t, err, "error while fetching the MeasureReport with id BBB")
+}
+
+func TestMeasureReportRows(t *testing.T) {
+	report, err := fm.UnmarshalMeasureReport([]byte(testMeasureReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []measureReportRow{
+		{group: 1, population: "initial-population", count: 3},
+		{group: 1, stratifier: "gender", stratum: "female", population: "initial-population", count: 2},
+		{group: 1, stratifier: "gender", stratum: "male", population: "initial-population", count: 1},
+	}, measureReportRows(report))
+}
+
+func TestRenderMeasureReport(t *testing.T) {
+	rendered, err := renderMeasureReport([]byte(testMeasureReport))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `Measure     : urn:uuid:0
+Period      : 1900 - 2200
+
+GROUP  STRATIFIER  STRATUM  POPULATION          COUNT
+1                           initial-population  3
+1      gender      female   initial-population  2
+1      gender      male     initial-population  1
+`, rendered)
+}
+
+func TestWriteMeasureReportCsv(t *testing.T) {
+	builder := strings.Builder{}
+
+	err := writeMeasureReportCsv([]byte(testMeasureReport), &builder)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `group,stratifier,stratum,population,count
+1,,,initial-population,3
+1,gender,female,initial-population,2
+1,gender,male,initial-population,1
+`, builder.String())
+}
+
+func TestMergeMeasureReports(t *testing.T) {
+	report, err := fm.UnmarshalMeasureReport([]byte(testMeasureReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := fm.UnmarshalMeasureReport([]byte(`{
+  "resourceType": "MeasureReport",
+  "status": "complete",
+  "type": "individual",
+  "measure": "urn:uuid:0",
+  "subject": {"reference": "Patient/0"},
+  "group": [{
+    "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 1}],
+    "stratifier": [{
+      "code": [{"text": "gender"}],
+      "stratum": [
+        {"value": {"text": "other"}, "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 1}]},
+        {"value": {"text": "female"}, "population": [{"code": {"coding": [{"code": "initial-population"}]}, "count": 1}]}
+      ]
+    }]
+  }]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("counts are added", func(t *testing.T) {
+		merged, err := mergeMeasureReports([]fm.MeasureReport{report, other})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, fm.MeasureReportTypeSummary, merged.Type)
+			assert.Nil(t, merged.Subject)
+			assert.Equal(t, []measureReportRow{
+				{group: 1, population: "initial-population", count: 4},
+				{group: 1, stratifier: "gender", stratum: "female", population: "initial-population", count: 3},
+				{group: 1, stratifier: "gender", stratum: "male", population: "initial-population", count: 1},
+				{group: 1, stratifier: "gender", stratum: "other", population: "initial-population", count: 1},
+			}, measureReportRows(merged))
+		}
+	})
+
+	t.Run("different measures", func(t *testing.T) {
+		other.Measure = "urn:uuid:1"
+
+		_, err := mergeMeasureReports([]fm.MeasureReport{report, other})
+
+		assert.ErrorContains(t, err, "different Measures")
+	})
+
+	t.Run("no reports", func(t *testing.T) {
+		_, err := mergeMeasureReports(nil)
+
+		assert.Error(t, err)
+	})
+}
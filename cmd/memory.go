@@ -0,0 +1,77 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// memoryMonitor samples the memory blazectl holds from the operating system
+// and keeps its peak. Reading the memory statistics stops the world for a
+// moment, so the interval shouldn't be too short.
+type memoryMonitor struct {
+	mutex sync.Mutex
+	peak  uint64
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startMemoryMonitor starts sampling the memory every interval.
+func startMemoryMonitor(interval time.Duration) *memoryMonitor {
+	m := &memoryMonitor{stop: make(chan struct{}), done: make(chan struct{})}
+	m.sample()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *memoryMonitor) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if memory := stats.Sys - stats.HeapReleased; memory > m.peak {
+		m.peak = memory
+	}
+}
+
+// peakMemory returns the peak memory sampled so far, including a sample taken
+// now.
+func (m *memoryMonitor) peakMemory() uint64 {
+	m.sample()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.peak
+}
+
+// Stop stops sampling and returns the peak memory.
+func (m *memoryMonitor) Stop() uint64 {
+	close(m.stop)
+	<-m.done
+	return m.peakMemory()
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var memorySink []byte
+
+func TestMemoryMonitor(t *testing.T) {
+	monitor := startMemoryMonitor(time.Millisecond)
+
+	memorySink = make([]byte, 64<<20)
+	for i := range memorySink {
+		memorySink[i] = 1
+	}
+	time.Sleep(10 * time.Millisecond)
+	memorySink = nil
+
+	assert.GreaterOrEqual(t, monitor.Stop(), uint64(64<<20))
+}
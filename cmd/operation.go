@@ -0,0 +1,404 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemOperations returns the system-level operations of capabilityStatement.
+func systemOperations(capabilityStatement fm.CapabilityStatement) []fm.CapabilityStatementRestResourceOperation {
+	var operations []fm.CapabilityStatementRestResourceOperation
+	for _, rest := range capabilityStatement.Rest {
+		if rest.Mode == fm.RestfulCapabilityModeServer {
+			operations = append(operations, rest.Operation...)
+		}
+	}
+	return operations
+}
+
+// fetchOperationDefinition fetches the OperationDefinition of the system-level
+// operation with the given name. The canonical URL of the definition is taken
+// from the capability statement of the server.
+func fetchOperationDefinition(client *fhir.Client, name string) (fm.OperationDefinition, error) {
+	capabilityStatement, err := fetchCapabilityStatement(client)
+	if err != nil {
+		return fm.OperationDefinition{}, err
+	}
+
+	var definition string
+	for _, operation := range systemOperations(capabilityStatement) {
+		if operation.Name == name {
+			definition = operation.Definition
+		}
+	}
+	if definition == "" {
+		return fm.OperationDefinition{}, fmt.Errorf("the server doesn't support the operation $%s", name)
+	}
+
+	req, err := client.NewSearchTypeRequest("OperationDefinition", url.Values{"url": []string{definition}})
+	if err != nil {
+		return fm.OperationDefinition{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fm.OperationDefinition{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return fm.OperationDefinition{}, fmt.Errorf("error while fetching the OperationDefinition %s:\n\n%s",
+			definition, errorResponse.String())
+	}
+	bundle, err := fhir.ReadBundle(resp.Body)
+	if err != nil {
+		return fm.OperationDefinition{}, err
+	}
+	if len(bundle.Entry) == 0 {
+		return fm.OperationDefinition{}, fmt.Errorf("the OperationDefinition %s was not found", definition)
+	}
+	return fm.UnmarshalOperationDefinition(bundle.Entry[0].Resource)
+}
+
+// inParameter returns the in parameter with the given name of operationDefinition.
+func inParameter(operationDefinition fm.OperationDefinition, name string) (fm.OperationDefinitionParameter, bool) {
+	for _, parameter := range operationDefinition.Parameter {
+		if parameter.Use == fm.OperationParameterUseIn && parameter.Name == name {
+			return parameter, true
+		}
+	}
+	return fm.OperationDefinitionParameter{}, false
+}
+
+// inParameterNames returns the names of all in parameters of operationDefinition.
+func inParameterNames(operationDefinition fm.OperationDefinition) []string {
+	var names []string
+	for _, parameter := range operationDefinition.Parameter {
+		if parameter.Use == fm.OperationParameterUseIn {
+			names = append(names, parameter.Name)
+		}
+	}
+	return names
+}
+
+// expandValueSet returns the codes of the expansion of the ValueSet with the
+// given canonical URL as computed by the server.
+func expandValueSet(client *fhir.Client, valueSet string) ([]string, error) {
+	req, err := client.NewTypeOperationRequest("ValueSet", "expand", false, url.Values{"url": []string{valueSet}})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return nil, fmt.Errorf("error while expanding the ValueSet %s:\n\n%s", valueSet, errorResponse.String())
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	expandedValueSet, err := fm.UnmarshalValueSet(body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the expansion of the ValueSet %s: %v", valueSet, err)
+	}
+	if expandedValueSet.Expansion == nil {
+		return nil, nil
+	}
+	var codes []string
+	for _, contains := range expandedValueSet.Expansion.Contains {
+		if contains.Code != nil {
+			codes = append(codes, *contains.Code)
+		}
+	}
+	return codes, nil
+}
+
+// fetchParameterCodes returns the codes allowed for the in parameter with the
+// given name of operationDefinition by expanding the ValueSet of its binding.
+// Returns no codes if the parameter has no binding.
+func fetchParameterCodes(client *fhir.Client, operationDefinition fm.OperationDefinition, name string) ([]string, error) {
+	parameter, ok := inParameter(operationDefinition, name)
+	if !ok || parameter.Binding == nil {
+		return nil, nil
+	}
+	return expandValueSet(client, parameter.Binding.ValueSet)
+}
+
+// operationParameter creates a parameter with the given name holding value
+// typed according to the definition of the parameter. Unknown types are sent
+// as string.
+func operationParameter(definition fm.OperationDefinitionParameter, value string) (fm.ParametersParameter, error) {
+	parameter := fm.ParametersParameter{Name: definition.Name}
+	var typ string
+	if definition.Type != nil {
+		typ = *definition.Type
+	}
+	switch typ {
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return parameter, fmt.Errorf("invalid boolean `%s` of parameter %s", value, definition.Name)
+		}
+		parameter.ValueBoolean = &b
+	case "integer", "positiveInt", "unsignedInt":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return parameter, fmt.Errorf("invalid integer `%s` of parameter %s", value, definition.Name)
+		}
+		switch typ {
+		case "positiveInt":
+			parameter.ValuePositiveInt = &i
+		case "unsignedInt":
+			parameter.ValueUnsignedInt = &i
+		default:
+			parameter.ValueInteger = &i
+		}
+	case "code":
+		parameter.ValueCode = &value
+	case "id":
+		parameter.ValueId = &value
+	case "uri":
+		parameter.ValueUri = &value
+	case "url":
+		parameter.ValueUrl = &value
+	case "canonical":
+		parameter.ValueCanonical = &value
+	default:
+		parameter.ValueString = &value
+	}
+	return parameter, nil
+}
+
+// createOperationParameters creates the Parameters resource of the given
+// name=value arguments according to operationDefinition. Values of parameters
+// with a required binding are checked against the allowed codes returned by
+// codes.
+func createOperationParameters(operationDefinition fm.OperationDefinition, args []string,
+	codes func(name string) ([]string, error)) (fm.Parameters, error) {
+	parameters := fm.Parameters{}
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fm.Parameters{}, fmt.Errorf("invalid parameter `%s`, expected name=value", arg)
+		}
+		definition, ok := inParameter(operationDefinition, name)
+		if !ok {
+			return fm.Parameters{}, fmt.Errorf("unknown parameter `%s`, expected one of: %s", name,
+				strings.Join(inParameterNames(operationDefinition), ", "))
+		}
+		if definition.Binding != nil && definition.Binding.Strength == fm.BindingStrengthRequired {
+			allowed, err := codes(name)
+			if err != nil {
+				return fm.Parameters{}, err
+			}
+			if len(allowed) > 0 && !slices.Contains(allowed, value) {
+				return fm.Parameters{}, fmt.Errorf("invalid value `%s` of parameter %s. Must be one of: %s", value,
+					name, strings.Join(allowed, ", "))
+			}
+		}
+		parameter, err := operationParameter(definition, value)
+		if err != nil {
+			return fm.Parameters{}, err
+		}
+		parameters.Parameter = append(parameters.Parameter, parameter)
+	}
+	return parameters, nil
+}
+
+// completeOperationArgs completes the operation name as first argument and
+// name=value pairs of its parameters as further arguments.
+func completeOperationArgs(args []string, toComplete string) []string {
+	if server == "" || createClient() != nil {
+		return nil
+	}
+	if len(args) == 0 {
+		capabilityStatement, err := fetchCapabilityStatement(client)
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for _, operation := range systemOperations(capabilityStatement) {
+			names = append(names, operation.Name)
+		}
+		return names
+	}
+
+	operationDefinition, err := fetchOperationDefinition(client, args[0])
+	if err != nil {
+		return nil
+	}
+	if name, _, ok := strings.Cut(toComplete, "="); ok {
+		codes, err := fetchParameterCodes(client, operationDefinition, name)
+		if err != nil {
+			return nil
+		}
+		completions := make([]string, 0, len(codes))
+		for _, code := range codes {
+			completions = append(completions, name+"="+code)
+		}
+		return completions
+	}
+	var completions []string
+	for _, name := range inParameterNames(operationDefinition) {
+		completions = append(completions, name+"=")
+	}
+	return completions
+}
+
+func operationCmdPollAsyncStatus(client *fhir.Client, location string, wait time.Duration,
+	timeout <-chan time.Time) ([]fm.BundleEntry, error) {
+	select {
+	case <-timeout:
+		return nil, fmt.Errorf("poll timeout of %s exceeded while waiting on status endpoint %s", pollTimeout, location)
+	case <-time.After(wait):
+		fmt.Fprintf(os.Stderr, "Poll status endpoint at %s...\n", location)
+		req, err := http.NewRequest("GET", location, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == 200 {
+			return readAsyncResponseEntries(resp.Body)
+		} else if resp.StatusCode == 202 {
+			// exponential wait up to 10 seconds unless the server tells us otherwise
+			if wait < 10*time.Second {
+				wait *= 2
+			}
+			return operationCmdPollAsyncStatus(client, location, retryAfter(resp, wait), timeout)
+		} else {
+			return nil, fmt.Errorf("error while polling the status endpoint %s:\n\n%w", location,
+				fhir.ReadHTTPStatusError(resp))
+		}
+	}
+}
+
+// runOperation invokes the system-level operation with the given name and
+// parameters, preferring an async response, and returns the resulting
+// resource.
+func runOperation(client *fhir.Client, name string, parameters fm.Parameters) ([]byte, error) {
+	req, err := client.NewPostSystemOperationRequest(name, true, parameters)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+		entries, err := operationCmdPollAsyncStatus(client, resp.Header.Get("Content-Location"),
+			retryAfter(resp, pollInterval), newPollTimeout())
+		if err != nil {
+			return nil, err
+		}
+		if errorResponse, ok := asyncResponseEntryErrors(entries)[0]; ok {
+			return nil, fmt.Errorf("error while running the operation $%s:\n\n%s", name, errorResponse.String())
+		}
+		return singleAsyncResponseResource(entries)
+	case isSuccessfulStatus(resp.StatusCode):
+		return io.ReadAll(resp.Body)
+	default:
+		return nil, fmt.Errorf("error while running the operation $%s:\n\n%w", name, fhir.ReadHTTPStatusError(resp))
+	}
+}
+
+var operationCmd = &cobra.Command{
+	Use:   "operation [name] [parameter=value]...",
+	Short: "Runs a system-level operation",
+	Long: `Runs a system-level operation like the admin operations of Blaze with
+the given parameters and prints the resulting resource.
+
+The parameters are validated and typed according to the OperationDefinition
+referenced in the capability statement of the server. Values of parameters
+bound to a ValueSet are checked against its expansion. Shell completion of
+operation names, parameter names and values uses the same information.
+
+The operation is requested asynchronously. If the server accepts the async
+request, the status endpoint is polled until the operation is finished.
+
+Examples:
+  blazectl operation --server http://localhost:8080/fhir compact database=index column-family=resource-as-of-index`,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeOperationArgs(args, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	},
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(args[0], "$")
+		operationDefinition, err := fetchOperationDefinition(client, name)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		parameters, err := createOperationParameters(operationDefinition, args[1:], func(name string) ([]string, error) {
+			return fetchParameterCodes(client, operationDefinition, name)
+		})
+		if err != nil {
+			return err
+		}
+
+		result, err := runOperation(client, name, parameters)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, result, "", "  "); err == nil {
+			result = indented.Bytes()
+		}
+		fmt.Println(string(result))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(operationCmd)
+
+	operationCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	operationCmd.Flags().DurationVar(&pollInterval, "poll-interval", 100*time.Millisecond, "initial wait between polls of the async status endpoint")
+	operationCmd.Flags().DurationVar(&pollTimeout, "poll-timeout", 0, "abort polling the async status endpoint after this duration (0 means no timeout)")
+
+	_ = operationCmd.MarkFlagRequired("server")
+}
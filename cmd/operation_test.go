@@ -0,0 +1,168 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const compactOperationDefinition = `{
+  "resourceType": "OperationDefinition",
+  "url": "https://samply.github.io/blaze/fhir/OperationDefinition/compact",
+  "name": "Compact",
+  "status": "active",
+  "kind": "operation",
+  "code": "compact",
+  "system": true,
+  "type": false,
+  "instance": false,
+  "parameter": [
+    {"name": "database", "use": "in", "min": 1, "max": "1", "type": "code",
+     "binding": {"strength": "required", "valueSet": "https://samply.github.io/blaze/fhir/ValueSet/Database"}},
+    {"name": "column-family", "use": "in", "min": 1, "max": "1", "type": "code",
+     "binding": {"strength": "required", "valueSet": "https://samply.github.io/blaze/fhir/ValueSet/ColumnFamily"}}
+  ]
+}`
+
+// newOperationServer returns a server reporting the $compact operation whose
+// column families include the given ones.
+func newOperationServer(t *testing.T, columnFamilies ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			_, _ = w.Write([]byte(`{"resourceType": "CapabilityStatement", "status": "active", "kind": "instance",
+				"rest": [{"mode": "server", "operation": [{"name": "compact",
+				"definition": "https://samply.github.io/blaze/fhir/OperationDefinition/compact"}]}]}`))
+		case "/OperationDefinition":
+			assert.Equal(t, "https://samply.github.io/blaze/fhir/OperationDefinition/compact", r.URL.Query().Get("url"))
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [{"resource": ` +
+				compactOperationDefinition + `}]}`))
+		case "/ValueSet/$expand":
+			codes := []string{"index", "transaction", "resource"}
+			if r.URL.Query().Get("url") == "https://samply.github.io/blaze/fhir/ValueSet/ColumnFamily" {
+				codes = columnFamilies
+			}
+			contains := ""
+			for i, code := range codes {
+				if i > 0 {
+					contains += ","
+				}
+				contains += `{"code": "` + code + `"}`
+			}
+			_, _ = w.Write([]byte(`{"resourceType": "ValueSet", "status": "active", "expansion": {"timestamp": "2024-01-01", "contains": [` +
+				contains + `]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestFetchOperationDefinition(t *testing.T) {
+	ts := newOperationServer(t)
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("supported operation", func(t *testing.T) {
+		operationDefinition, err := fetchOperationDefinition(client, "compact")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "compact", operationDefinition.Code)
+			assert.Equal(t, []string{"database", "column-family"}, inParameterNames(operationDefinition))
+		}
+	})
+
+	t.Run("unsupported operation", func(t *testing.T) {
+		_, err := fetchOperationDefinition(client, "foo")
+
+		assert.EqualError(t, err, "the server doesn't support the operation $foo")
+	})
+
+	t.Run("parameter codes", func(t *testing.T) {
+		operationDefinition, _ := fetchOperationDefinition(client, "compact")
+		codes, err := fetchParameterCodes(client, operationDefinition, "database")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"index", "transaction", "resource"}, codes)
+		}
+	})
+}
+
+func TestCreateOperationParameters(t *testing.T) {
+	operationDefinition, _ := fm.UnmarshalOperationDefinition([]byte(compactOperationDefinition))
+	codes := func(name string) ([]string, error) {
+		return []string{"index", "transaction"}, nil
+	}
+
+	t.Run("valid parameters", func(t *testing.T) {
+		parameters, err := createOperationParameters(operationDefinition, []string{"database=index"}, codes)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "database", parameters.Parameter[0].Name)
+			assert.Equal(t, "index", *parameters.Parameter[0].ValueCode)
+		}
+	})
+
+	t.Run("value not in binding", func(t *testing.T) {
+		_, err := createOperationParameters(operationDefinition, []string{"database=foo"}, codes)
+
+		assert.EqualError(t, err, "invalid value `foo` of parameter database. Must be one of: index, transaction")
+	})
+
+	t.Run("unknown parameter", func(t *testing.T) {
+		_, err := createOperationParameters(operationDefinition, []string{"foo=bar"}, codes)
+
+		assert.EqualError(t, err, "unknown parameter `foo`, expected one of: database, column-family")
+	})
+
+	t.Run("missing value", func(t *testing.T) {
+		_, err := createOperationParameters(operationDefinition, []string{"database"}, codes)
+
+		assert.EqualError(t, err, "invalid parameter `database`, expected name=value")
+	})
+
+	t.Run("failing expansion", func(t *testing.T) {
+		_, err := createOperationParameters(operationDefinition, []string{"database=index"}, func(string) ([]string, error) {
+			return nil, errors.New("expansion failed")
+		})
+
+		assert.EqualError(t, err, "expansion failed")
+	})
+}
+
+func TestOperationParameter(t *testing.T) {
+	integerType, booleanType := "integer", "boolean"
+
+	parameter, err := operationParameter(fm.OperationDefinitionParameter{Name: "count", Type: &integerType}, "42")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 42, *parameter.ValueInteger)
+	}
+
+	_, err = operationParameter(fm.OperationDefinitionParameter{Name: "force", Type: &booleanType}, "maybe")
+	assert.EqualError(t, err, "invalid boolean `maybe` of parameter force")
+
+	parameter, err = operationParameter(fm.OperationDefinitionParameter{Name: "comment"}, "foo")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "foo", *parameter.ValueString)
+	}
+}
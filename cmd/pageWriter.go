@@ -0,0 +1,141 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/samply/blazectl/util"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var writeBuffer int
+
+// flushWriter is a buffered writer like bufio.Writer or partWriter.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// pageWriter writes the resources of downloaded pages to a sink and records
+// the resources per page and the write throughput in stats and, if set, in
+// progress. The sink is guarded by sinkMutex. With duplicates, resources written before are
+// skipped.
+type pageWriter struct {
+	sinkMutex  sync.Mutex
+	sink       flushWriter
+	stats      *util.Aggregator[commandStats, commandStats]
+	policy     consentPolicy
+	duplicates *duplicateFilter
+	progress   downloadProgress
+}
+
+func newPageWriter(sink flushWriter, stats *util.Aggregator[commandStats, commandStats], policy consentPolicy) *pageWriter {
+	return &pageWriter{sink: sink, stats: stats, policy: policy}
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w     io.Writer
+	bytes int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// writePage writes the resources of bundle to the sink, filtered by the
+// consent policy if --consent-filter is given and without duplicates if
+// --suppress-duplicates is given.
+func (w *pageWriter) writePage(bundle downloadBundle) error {
+	w.sinkMutex.Lock()
+	defer w.sinkMutex.Unlock()
+
+	start := time.Now()
+	var excluded int
+	if consentFilter {
+		filtered, n, err := filterConsentedEntries(bundle.rawEntries, w.policy)
+		if err != nil {
+			return fmt.Errorf("Failed to filter downloaded resources received from request to URL %s: %v", bundle.associatedRequestURL.String(), err)
+		}
+		bundle.rawEntries = filtered
+		excluded = n
+	}
+	var duplicates int
+	if w.duplicates != nil {
+		filtered, n, err := w.duplicates.filter(bundle.rawEntries)
+		if err != nil {
+			return fmt.Errorf("Failed to filter downloaded resources received from request to URL %s: %v", bundle.associatedRequestURL.String(), err)
+		}
+		bundle.rawEntries = filtered
+		duplicates = n
+	}
+
+	sink := countingWriter{w: w.sink}
+	resources, inlineOutcomes, err := writeResources(&bundle.rawEntries, &sink)
+	duration := time.Since(start)
+
+	w.stats.Add(commandStats{
+		excludedResources:       excluded,
+		duplicateResources:      duplicates,
+		resourcesPerPage:        []int{resources},
+		inlineOperationOutcomes: inlineOutcomes,
+		bytesOut:                sink.bytes,
+		writeDuration:           duration,
+	})
+	if w.progress != nil {
+		w.progress.increment(resources+excluded+duplicates, sink.bytes)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write downloaded resources received from request to URL %s: %v", bundle.associatedRequestURL.String(), err)
+	}
+	return nil
+}
+
+// writePages writes all pages received from pages and flushes the sink
+// afterwards. Exits on errors.
+func (w *pageWriter) writePages(pages <-chan downloadBundle) {
+	for bundle := range pages {
+		if err := w.writePage(bundle); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+
+	w.sinkMutex.Lock()
+	defer w.sinkMutex.Unlock()
+	start := time.Now()
+	err := w.sink.Flush()
+	w.stats.Add(commandStats{writeDuration: time.Since(start)})
+	if err != nil {
+		fmt.Printf("Failed to write downloaded resources: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+// stalled records that the download waited duration for the writer.
+func (w *pageWriter) stalled(duration time.Duration) {
+	w.stats.Add(commandStats{writeStalls: duration})
+}
+
+// lock waits until the current page is written and locks the sink, so that
+// it can be flushed on interrupt.
+func (w *pageWriter) lock() {
+	w.sinkMutex.Lock()
+}
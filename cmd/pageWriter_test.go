@@ -0,0 +1,80 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPageWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var stats commandStats
+	writer := newPageWriter(bufio.NewWriter(&buf), newDownloadStats(&stats), nil)
+
+	pages := make(chan downloadBundle, 2)
+	pages <- downloadBundle{rawEntries: []byte(`[
+  {"resource": {"resourceType": "Patient", "id": "0"}, "search": {"mode": "match"}},
+  {"resource": {"resourceType": "Patient", "id": "1"}, "search": {"mode": "match"}}
+]`)}
+	pages <- downloadBundle{rawEntries: []byte(`[
+  {"resource": {"resourceType": "Patient", "id": "2"}, "search": {"mode": "match"}},
+  {"resource": {"resourceType": "OperationOutcome"}, "search": {"mode": "outcome"}}
+]`)}
+	close(pages)
+
+	writer.writePages(pages)
+	writer.stalled(time.Second)
+
+	assert.Equal(t, `{"resourceType":"Patient","id":"0"}
+{"resourceType":"Patient","id":"1"}
+{"resourceType":"Patient","id":"2"}
+`, buf.String())
+	assert.Equal(t, []int{2, 1}, stats.resourcesPerPage)
+	assert.Len(t, stats.inlineOperationOutcomes, 1)
+	assert.Equal(t, int64(buf.Len()), stats.bytesOut)
+	assert.Positive(t, stats.writeDuration)
+	assert.Equal(t, time.Second, stats.writeStalls)
+}
+
+func TestPageWriterSuppressDuplicates(t *testing.T) {
+	var buf bytes.Buffer
+	var stats commandStats
+	writer := newPageWriter(bufio.NewWriter(&buf), newDownloadStats(&stats), nil)
+	writer.duplicates = newDuplicateFilter()
+
+	pages := make(chan downloadBundle, 2)
+	pages <- downloadBundle{rawEntries: []byte(`[
+  {"resource": {"resourceType": "Patient", "id": "0", "meta": {"versionId": "1"}}, "search": {"mode": "match"}},
+  {"resource": {"resourceType": "Patient", "id": "1", "meta": {"versionId": "1"}}, "search": {"mode": "match"}}
+]`)}
+	pages <- downloadBundle{rawEntries: []byte(`[
+  {"resource": {"resourceType": "Patient", "id": "1", "meta": {"versionId": "1"}}, "search": {"mode": "match"}},
+  {"resource": {"resourceType": "Patient", "id": "1", "meta": {"versionId": "2"}}, "search": {"mode": "match"}}
+]`)}
+	close(pages)
+
+	writer.writePages(pages)
+
+	assert.Equal(t, `{"resourceType":"Patient","id":"0","meta":{"versionId":"1"}}
+{"resourceType":"Patient","id":"1","meta":{"versionId":"1"}}
+{"resourceType":"Patient","id":"1","meta":{"versionId":"2"}}
+`, buf.String())
+	assert.Equal(t, []int{2, 1}, stats.resourcesPerPage)
+	assert.Equal(t, 1, stats.duplicateResources)
+}
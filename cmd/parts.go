@@ -0,0 +1,299 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var maxFileSize int64
+var maxResourcesPerFile int
+var outputCmd string
+
+// outputPart is one part written by a partWriter, either a file or the
+// standard input of a command. Parts written as seekable zstd archive count
+// the uncompressed bytes, while the hash covers the compressed ones.
+type outputPart struct {
+	path      string
+	out       io.WriteCloser
+	hash      *hashingWriter
+	seekable  *seekableWriter
+	buf       *bufio.Writer
+	bytes     int64
+	resources int
+}
+
+func (p *outputPart) close() error {
+	if err := p.buf.Flush(); err != nil {
+		return err
+	}
+	if p.seekable != nil {
+		if err := p.seekable.Close(); err != nil {
+			p.out.Close()
+			return err
+		}
+	}
+	return p.out.Close()
+}
+
+// syncedFile is a file which is synced to disk before it is closed.
+type syncedFile struct {
+	*os.File
+}
+
+func (f syncedFile) Close() error {
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
+
+// commandInput is the standard input of a running command. Closing it waits
+// for the command to exit.
+type commandInput struct {
+	io.WriteCloser
+	command string
+	cmd     *exec.Cmd
+}
+
+// startCommand runs command in the shell of the operating system with the
+// number of the part in the environment variable BLAZECTL_PART and returns
+// its standard input. The output of the command goes to the standard output
+// and error of blazectl.
+func startCommand(command string, part int) (*commandInput, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "BLAZECTL_PART="+strconv.Itoa(part))
+	return startInput(cmd, command)
+}
+
+// startInput starts cmd, named command in errors, and returns its standard
+// input.
+func startInput(cmd *exec.Cmd, command string) (*commandInput, error) {
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error while starting the output command `%s`: %w", command, err)
+	}
+	return &commandInput{WriteCloser: stdin, command: command, cmd: cmd}, nil
+}
+
+func (c *commandInput) Close() error {
+	closeErr := c.WriteCloser.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("the output command `%s` failed: %w", c.command, err)
+	}
+	return closeErr
+}
+
+// partOpener opens the part with the given number, starting at 1, and returns
+// its path, which is empty for parts without file.
+type partOpener func(number int) (string, io.WriteCloser, error)
+
+// partWriter writes an NDJSON stream into a sequence of parts, like the files
+// export-0001.ndjson, export-0002.ndjson and so on, rolling over to the next
+// part before a resource which would exceed maxBytes or maxResources. Parts
+// are only split between resources. Without limits, a single part is written.
+// With a frame size, each part is written as seekable zstd archive with frames
+// of about that many uncompressed bytes.
+type partWriter struct {
+	open         partOpener
+	maxBytes     int64
+	maxResources int
+	frameSize    int
+	parts        []*outputPart
+	index        *resourceIndex
+	atBoundary   bool
+	closed       bool
+}
+
+func newPartWriterWith(open partOpener, maxBytes int64, maxResources int, frameSize int) (*partWriter, error) {
+	w := &partWriter{open: open, maxBytes: maxBytes, maxResources: maxResources, frameSize: frameSize, atBoundary: true}
+	if err := w.createPart(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// newPartWriter creates the first part of the output at path. Exits if the
+// file already exists.
+func newPartWriter(path string, maxBytes int64, maxResources int) *partWriter {
+	return newSeekablePartWriter(path, maxBytes, maxResources, 0)
+}
+
+// newSeekablePartWriter creates the first part of the output at path, which
+// is written as seekable zstd archive with frames of about frameSize
+// uncompressed bytes unless frameSize is zero. Exits if the file already
+// exists.
+func newSeekablePartWriter(path string, maxBytes int64, maxResources int, frameSize int) *partWriter {
+	limited := maxBytes > 0 || maxResources > 0
+	w, err := newPartWriterWith(func(number int) (string, io.WriteCloser, error) {
+		partFile := path
+		if limited {
+			partFile = partPath(path, number)
+		}
+		return partFile, syncedFile{createOutputFileOrDie(partFile)}, nil
+	}, maxBytes, maxResources, frameSize)
+	if err != nil {
+		fmt.Printf("could not create the output file %s: %v\n", path, err)
+		os.Exit(4)
+	}
+	return w
+}
+
+// newCommandWriter starts command and streams the output into its standard
+// input. With limits, a new command is started for each part.
+func newCommandWriter(command string, maxBytes int64, maxResources int) (*partWriter, error) {
+	return newPartWriterWith(func(number int) (string, io.WriteCloser, error) {
+		input, err := startCommand(command, number)
+		return "", input, err
+	}, maxBytes, maxResources, 0)
+}
+
+// partPath returns the path of the part with the given number, inserting the
+// number before the extension of path. The extension of compressed files
+// includes the one of their content, like .ndjson.zst.
+func partPath(path string, number int) string {
+	ext := filepath.Ext(path)
+	if ext == ".zst" {
+		ext = filepath.Ext(strings.TrimSuffix(path, ext)) + ext
+	}
+	return fmt.Sprintf("%s-%04d%s", strings.TrimSuffix(path, ext), number, ext)
+}
+
+func (w *partWriter) limited() bool {
+	return w.maxBytes > 0 || w.maxResources > 0
+}
+
+func (w *partWriter) createPart() error {
+	path, out, err := w.open(len(w.parts) + 1)
+	if err != nil {
+		return err
+	}
+	part := &outputPart{path: path, out: out, hash: newHashingWriter(out)}
+	if w.frameSize > 0 {
+		if part.seekable, err = newSeekableWriter(part.hash, w.frameSize, w.frameWritten); err != nil {
+			out.Close()
+			return err
+		}
+		part.buf = bufio.NewWriter(part.seekable)
+	} else {
+		part.buf = bufio.NewWriter(part.hash)
+	}
+	w.parts = append(w.parts, part)
+	return nil
+}
+
+// frameWritten records the resources of frame in the index.
+func (w *partWriter) frameWritten(frame seekableFrame) error {
+	if w.index != nil {
+		return w.index.addFrame(frame)
+	}
+	return nil
+}
+
+func (w *partWriter) current() *outputPart {
+	return w.parts[len(w.parts)-1]
+}
+
+// full returns whether the current part can't take a resource of size bytes.
+// Empty parts take every resource.
+func (w *partWriter) full(size int64) bool {
+	part := w.current()
+	if part.bytes == 0 {
+		return false
+	}
+	return (w.maxResources > 0 && part.resources >= w.maxResources) ||
+		(w.maxBytes > 0 && part.bytes+size+1 > w.maxBytes)
+}
+
+// Write writes p to the current part. A write starting a new line starts a
+// new part if the current part is full. With an index, such a write has to
+// contain the whole resource.
+func (w *partWriter) Write(p []byte) (int, error) {
+	if w.atBoundary && w.limited() && w.full(int64(len(p))) {
+		if err := w.current().close(); err != nil {
+			return 0, err
+		}
+		if err := w.createPart(); err != nil {
+			return 0, err
+		}
+	}
+	part := w.current()
+	if w.atBoundary && w.index != nil && len(p) > 0 && p[0] != '\n' {
+		if err := w.index.add(part.path, part.bytes, p, part.seekable != nil); err != nil {
+			return 0, err
+		}
+	}
+	n, err := part.buf.Write(p)
+	part.bytes += int64(n)
+	part.resources += bytes.Count(p[:n], []byte{'\n'})
+	w.atBoundary = n > 0 && p[n-1] == '\n'
+	return n, err
+}
+
+// Flush flushes the buffer of the current part and the index.
+func (w *partWriter) Flush() error {
+	if w.index != nil {
+		if err := w.index.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.current().buf.Flush()
+}
+
+// Close flushes and closes the current part and the index. Closing twice has
+// no effect.
+func (w *partWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.current().close()
+	if w.index != nil {
+		if indexErr := w.index.Close(); err == nil {
+			err = indexErr
+		}
+	}
+	return err
+}
+
+// manifestEntries describes all parts relative to the manifest at
+// manifestPath. The writer has to be closed before.
+func (w *partWriter) manifestEntries(manifestPath string) []manifestEntry {
+	entries := make([]manifestEntry, 0, len(w.parts))
+	for _, part := range w.parts {
+		entries = append(entries, newManifestEntry(manifestPath, part.path, part.resources, part.hash))
+	}
+	return entries
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPartPath(t *testing.T) {
+	assert.Equal(t, "export-0001.ndjson", partPath("export.ndjson", 1))
+	assert.Equal(t, filepath.Join("out", "export-0012.ndjson"), partPath(filepath.Join("out", "export.ndjson"), 12))
+	assert.Equal(t, "export-0002", partPath("export", 2))
+	assert.Equal(t, "export-0003.ndjson.zst", partPath("export.ndjson.zst", 3))
+}
+
+// writeNdjson writes the resources through w the way writeResources does.
+func writeNdjson(t *testing.T, w *partWriter, resources ...string) {
+	for _, resource := range resources {
+		if _, err := w.Write([]byte(resource)); err != nil {
+			t.Fatalf("error while writing: %v", err)
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			t.Fatalf("error while writing: %v", err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error while reading %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestPartWriter(t *testing.T) {
+	t.Run("Unlimited", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "export.ndjson")
+		w := newPartWriter(path, 0, 0)
+
+		writeNdjson(t, w, `{"id":"0"}`, `{"id":"1"}`)
+
+		if assert.NoError(t, w.Close()) {
+			assert.Len(t, w.parts, 1)
+			assert.Equal(t, "{\"id\":\"0\"}\n{\"id\":\"1\"}\n", readFile(t, path))
+			assert.Equal(t, 2, w.parts[0].resources)
+		}
+	})
+
+	t.Run("MaxResources", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "export.ndjson")
+		w := newPartWriter(path, 0, 2)
+
+		writeNdjson(t, w, `{"id":"0"}`, `{"id":"1"}`, `{"id":"2"}`)
+
+		if assert.NoError(t, w.Close()) {
+			assert.Len(t, w.parts, 2)
+			assert.Equal(t, "{\"id\":\"0\"}\n{\"id\":\"1\"}\n", readFile(t, partPath(path, 1)))
+			assert.Equal(t, "{\"id\":\"2\"}\n", readFile(t, partPath(path, 2)))
+			assert.NoFileExists(t, path)
+		}
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "export.ndjson")
+		w := newPartWriter(path, 25, 0)
+
+		// the third resource is larger than the limit and gets a part of its own
+		writeNdjson(t, w, `{"id":"0"}`, `{"id":"1"}`, `{"id":"2","active":true}`, `{"id":"3"}`)
+
+		if assert.NoError(t, w.Close()) {
+			assert.Len(t, w.parts, 3)
+			assert.Equal(t, "{\"id\":\"0\"}\n{\"id\":\"1\"}\n", readFile(t, partPath(path, 1)))
+			assert.Equal(t, "{\"id\":\"2\",\"active\":true}\n", readFile(t, partPath(path, 2)))
+			assert.Equal(t, "{\"id\":\"3\"}\n", readFile(t, partPath(path, 3)))
+		}
+	})
+
+	t.Run("ManifestEntries", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "export.ndjson")
+		w := newPartWriter(path, 0, 1)
+
+		writeNdjson(t, w, `{"id":"0"}`, `{"id":"1"}`)
+
+		if assert.NoError(t, w.Close()) {
+			entries := w.manifestEntries(filepath.Join(dir, "manifest.json"))
+			if assert.Len(t, entries, 2) {
+				assert.Equal(t, "export-0001.ndjson", entries[0].Path)
+				assert.Equal(t, 1, entries[0].Resources)
+				assert.Equal(t, int64(11), entries[0].Bytes)
+				assert.Equal(t, "export-0002.ndjson", entries[1].Path)
+			}
+		}
+	})
+}
+
+func TestCommandWriter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test commands need a POSIX shell")
+	}
+
+	t.Run("PerPart", func(t *testing.T) {
+		dir := t.TempDir()
+		w, err := newCommandWriter("cat > "+filepath.Join(dir, "part-$BLAZECTL_PART.ndjson"), 0, 2)
+		if err != nil {
+			t.Fatalf("error while starting the command: %v", err)
+		}
+
+		writeNdjson(t, w, `{"id":"0"}`, `{"id":"1"}`, `{"id":"2"}`)
+
+		if assert.NoError(t, w.Close()) {
+			assert.Len(t, w.parts, 2)
+			assert.Equal(t, "{\"id\":\"0\"}\n{\"id\":\"1\"}\n", readFile(t, filepath.Join(dir, "part-1.ndjson")))
+			assert.Equal(t, "{\"id\":\"2\"}\n", readFile(t, filepath.Join(dir, "part-2.ndjson")))
+		}
+	})
+
+	t.Run("Failing", func(t *testing.T) {
+		w, err := newCommandWriter("cat > /dev/null; exit 3", 0, 0)
+		if err != nil {
+			t.Fatalf("error while starting the command: %v", err)
+		}
+
+		writeNdjson(t, w, `{"id":"0"}`)
+
+		assert.EqualError(t, w.Close(), "the output command `cat > /dev/null; exit 3` failed: exit status 3")
+	})
+}
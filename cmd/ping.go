@@ -0,0 +1,182 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"time"
+)
+
+var pingCount int
+var pingInterval time.Duration
+
+// pingTiming holds the durations of the phases of a single ping. The TLS
+// handshake is zero for plain HTTP connections.
+type pingTiming struct {
+	connect      time.Duration
+	tlsHandshake time.Duration
+	roundTrip    time.Duration
+}
+
+// ping fetches the CapabilityStatement over a new connection and measures
+// the TCP connect, the TLS handshake and the whole round trip including
+// reading the response.
+func ping(client *fhir.Client) (pingTiming, error) {
+	req, err := client.NewCapabilitiesRequest()
+	if err != nil {
+		return pingTiming{}, err
+	}
+	// don't reuse connections, so that every ping measures connect and handshake
+	req.Close = true
+
+	var timing pingTiming
+	var connectStart, tlsHandshakeStart time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				timing.connect = time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsHandshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				timing.tlsHandshake = time.Since(tlsHandshakeStart)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return pingTiming{}, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return pingTiming{}, err
+	}
+	timing.roundTrip = time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		return pingTiming{}, fmt.Errorf("non-OK status while fetching the capability statement: %s", resp.Status)
+	}
+	return timing, nil
+}
+
+type pingStatistics struct {
+	total, failed                    int
+	connect, tlsHandshake, roundTrip []float64
+	errors                           map[string]int
+}
+
+func runPings(client *fhir.Client, count int, interval time.Duration) pingStatistics {
+	stats := pingStatistics{total: count, errors: make(map[string]int)}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		timing, err := ping(client)
+		if err != nil {
+			stats.failed++
+			stats.errors[err.Error()]++
+			continue
+		}
+		stats.connect = append(stats.connect, timing.connect.Seconds())
+		if timing.tlsHandshake > 0 {
+			stats.tlsHandshake = append(stats.tlsHandshake, timing.tlsHandshake.Seconds())
+		}
+		stats.roundTrip = append(stats.roundTrip, timing.roundTrip.Seconds())
+	}
+	return stats
+}
+
+func (stats pingStatistics) format(units util.UnitFormat) string {
+	s := fmt.Sprintf("Pings            [total, failed]                       %d, %d\n", stats.total, stats.failed)
+	if len(stats.connect) > 0 {
+		s += fmt.Sprintf("TCP Connect      [min, mean, 50, 95, 99, max, stddev]  %s\n",
+			fmtDurationStatistics(units, util.CalculateDurationStatistics(stats.connect)))
+	}
+	if len(stats.tlsHandshake) > 0 {
+		s += fmt.Sprintf("TLS Handshake    [min, mean, 50, 95, 99, max, stddev]  %s\n",
+			fmtDurationStatistics(units, util.CalculateDurationStatistics(stats.tlsHandshake)))
+	}
+	if len(stats.roundTrip) > 0 {
+		s += fmt.Sprintf("Round Trip       [min, mean, 50, 95, 99, max, stddev]  %s\n",
+			fmtDurationStatistics(units, util.CalculateDurationStatistics(stats.roundTrip)))
+	}
+	if len(stats.errors) > 0 {
+		s += "\nErrors:\n"
+		for err, freq := range stats.errors {
+			s += fmt.Sprintf("%dx %s\n", freq, err)
+		}
+	}
+	return s
+}
+
+var pingCmd = &cobra.Command{
+	Use:   "ping",
+	Short: "Measures the latency to a server",
+	Long: `Fetches the CapabilityStatement of a server several times, each time over
+a new connection, and prints statistics of the durations of the TCP connect,
+the TLS handshake and the whole round trip.
+
+Use ping to diagnose network issues before blaming the server for slow
+uploads or downloads.
+
+Examples:
+  blazectl ping --server "http://localhost:8080/fhir"
+  blazectl ping --server "https://blaze.example.com/fhir" --count 20`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Ping %s %d times ...\n\n", server, pingCount)
+
+		stats := runPings(client, pingCount, pingInterval)
+		fmt.Print(stats.format(unitFormat()))
+
+		if stats.failed > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pingCmd)
+
+	pingCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	pingCmd.Flags().IntVarP(&pingCount, "count", "n", 5, "number of pings")
+	pingCmd.Flags().DurationVar(&pingInterval, "interval", 100*time.Millisecond, "wait between pings")
+
+	_ = pingCmd.MarkFlagRequired("server")
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func metadataHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/metadata", r.URL.Path)
+		w.Header().Set("Content-Type", "application/fhir+json")
+		_, _ = w.Write([]byte(`{"resourceType": "CapabilityStatement"}`))
+	}
+}
+
+func TestPing(t *testing.T) {
+	t.Run("HTTP", func(t *testing.T) {
+		server := httptest.NewServer(metadataHandler(t))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		timing, err := ping(fhir.NewClient(*baseURL, nil))
+
+		if assert.NoError(t, err) {
+			assert.Positive(t, timing.connect)
+			assert.Zero(t, timing.tlsHandshake)
+			assert.GreaterOrEqual(t, timing.roundTrip, timing.connect)
+		}
+	})
+
+	t.Run("HTTPS", func(t *testing.T) {
+		server := httptest.NewTLSServer(metadataHandler(t))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		timing, err := ping(fhir.NewClientInsecure(*baseURL, nil))
+
+		if assert.NoError(t, err) {
+			assert.Positive(t, timing.tlsHandshake)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		_, err := ping(fhir.NewClient(*baseURL, nil))
+
+		assert.EqualError(t, err, "non-OK status while fetching the capability statement: 503 Service Unavailable")
+	})
+}
+
+func TestRunPings(t *testing.T) {
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(metadataHandler(t))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	stats := runPings(fhir.NewClient(*baseURL, nil), 3, 0)
+
+	assert.Equal(t, 3, stats.total)
+	assert.Zero(t, stats.failed)
+	assert.Len(t, stats.roundTrip, 3)
+	assert.Empty(t, stats.tlsHandshake)
+	assert.Equal(t, int32(3), connections.Load())
+	assert.Contains(t, stats.format(util.UnitFormat{}), "Pings            [total, failed]                       3, 0\n")
+	assert.NotContains(t, stats.format(util.UnitFormat{}), "TLS Handshake")
+}
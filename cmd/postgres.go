@@ -0,0 +1,142 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var outputPostgres string
+var postgresTable string
+var postgresColumns string
+
+// quoteIdentifier quotes name as SQL identifier, so that its case is kept and
+// it can't inject SQL.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// validIdentifier returns true if name can be used as quoted identifier.
+// PostgreSQL doesn't allow empty identifiers or NUL characters.
+func validIdentifier(name string) bool {
+	return name != "" && !strings.ContainsRune(name, 0)
+}
+
+// quoteTable quotes table, which may be qualified by a schema like
+// public.resources, as SQL identifier.
+func quoteTable(table string) (string, error) {
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("invalid --postgres-table value `%s`, expected a table name optionally qualified by a schema like public.resources", table)
+	}
+	for i, part := range parts {
+		if !validIdentifier(part) {
+			return "", fmt.Errorf("invalid --postgres-table value `%s`, expected a table name optionally qualified by a schema like public.resources", table)
+		}
+		parts[i] = quoteIdentifier(part)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// copyStatement returns the COPY statement loading CSV rows from the standard
+// input into the columns for id, type, last updated and resource of table.
+// Table and column names are quoted, so that they are used exactly as given.
+func copyStatement(table string, columns string) (string, error) {
+	quotedTable, err := quoteTable(table)
+	if err != nil {
+		return "", err
+	}
+	names := strings.Split(columns, ",")
+	if len(names) != 4 {
+		return "", fmt.Errorf("invalid --postgres-columns value `%s`, expected four comma separated columns for id, type, last updated and resource", columns)
+	}
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if !validIdentifier(name) {
+			return "", fmt.Errorf("invalid --postgres-columns value `%s`, expected four comma separated columns for id, type, last updated and resource", columns)
+		}
+		names[i] = quoteIdentifier(name)
+	}
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN WITH (FORMAT csv)", quotedTable, strings.Join(names, ", ")), nil
+}
+
+// postgresWriter bulk loads an NDJSON stream into a PostgreSQL table with
+// COPY. The rows are sent by the psql command line client, which has to be
+// installed. The table has to exist.
+type postgresWriter struct {
+	input  *commandInput
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	line   []byte
+	closed bool
+}
+
+// newPostgresWriter starts psql on the database of the connection string conn
+// and the COPY into the columns of table.
+func newPostgresWriter(conn string, table string, columns string) (*postgresWriter, error) {
+	statement, err := copyStatement(table, columns)
+	if err != nil {
+		return nil, err
+	}
+	input, err := startInput(exec.Command("psql", "--no-psqlrc", "--set", "ON_ERROR_STOP=1",
+		"--dbname", conn, "--command", statement), "psql")
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(input)
+	return &postgresWriter{input: input, buf: buf, csv: csv.NewWriter(buf)}, nil
+}
+
+// Write sends a row for each complete line of the NDJSON stream.
+func (w *postgresWriter) Write(p []byte) (int, error) {
+	return writeLines(&w.line, p, w.copyRow)
+}
+
+// copyRow sends the row of resource. A missing last updated is sent as
+// unquoted empty field, which COPY reads as NULL.
+func (w *postgresWriter) copyRow(resource []byte) error {
+	row, err := readResourceRow(resource)
+	if err != nil {
+		return err
+	}
+	return w.csv.Write([]string{row.id, row.resourceType, row.lastUpdated, string(resource)})
+}
+
+// Flush passes the rows sent so far to psql.
+func (w *postgresWriter) Flush() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	return w.buf.Flush()
+}
+
+// Close ends the COPY and waits for psql to exit. The rows are only visible
+// in the table if the whole COPY succeeds. Closing twice has no effect.
+func (w *postgresWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.Flush(); err != nil {
+		w.input.Close()
+		return err
+	}
+	return w.input.Close()
+}
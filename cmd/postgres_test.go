@@ -0,0 +1,83 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCopyStatement(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		statement, err := copyStatement("resources", "id,type,last_updated,resource")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `COPY "resources" ("id", "type", "last_updated", "resource") FROM STDIN WITH (FORMAT csv)`, statement)
+		}
+	})
+
+	t.Run("SchemaAndMixedCase", func(t *testing.T) {
+		statement, err := copyStatement("fhir.Resources", "Id, Type, lastUpdated, Resource")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `COPY "fhir"."Resources" ("Id", "Type", "lastUpdated", "Resource") FROM STDIN WITH (FORMAT csv)`, statement)
+		}
+	})
+
+	t.Run("Injection", func(t *testing.T) {
+		statement, err := copyStatement(`resources" (id) FROM STDIN; DROP TABLE patients; --`, `id,type,last_updated,"resource`)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `COPY "resources"" (id) FROM STDIN; DROP TABLE patients; --" ("id", "type", "last_updated", """resource") FROM STDIN WITH (FORMAT csv)`, statement)
+		}
+	})
+
+	t.Run("InvalidTable", func(t *testing.T) {
+		for _, table := range []string{"", "a.b.c", "public.", "re\x00s"} {
+			_, err := copyStatement(table, "id,type,last_updated,resource")
+
+			assert.EqualError(t, err, "invalid --postgres-table value `"+table+"`, expected a table name optionally qualified by a schema like public.resources")
+		}
+	})
+
+	t.Run("EmptyColumn", func(t *testing.T) {
+		_, err := copyStatement("resources", "id,,last_updated,resource")
+
+		assert.EqualError(t, err, "invalid --postgres-columns value `id,,last_updated,resource`, expected four comma separated columns for id, type, last updated and resource")
+	})
+
+	t.Run("WrongNumberOfColumns", func(t *testing.T) {
+		_, err := copyStatement("resources", "id, resource")
+
+		assert.EqualError(t, err, "invalid --postgres-columns value `id, resource`, expected four comma separated columns for id, type, last updated and resource")
+	})
+}
+
+func TestPostgresWriterCopyRow(t *testing.T) {
+	var buf bytes.Buffer
+	w := postgresWriter{csv: csv.NewWriter(&buf)}
+
+	_, err := w.Write([]byte("{\"resourceType\":\"Patient\",\"id\":\"0\",\"meta\":{\"lastUpdated\":\"2024-01-02T03:04:05Z\"}}\n" +
+		"{\"resourceType\":\"Patient\",\"id\":\"1\"}\n"))
+
+	if assert.NoError(t, err) {
+		w.csv.Flush()
+		assert.Equal(t, `0,Patient,2024-01-02T03:04:05Z,"{""resourceType"":""Patient"",""id"":""0"",""meta"":{""lastUpdated"":""2024-01-02T03:04:05Z""}}"
+1,Patient,,"{""resourceType"":""Patient"",""id"":""1""}"
+`, buf.String())
+	}
+}
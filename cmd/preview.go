@@ -0,0 +1,158 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var previewDownload bool
+var assumeYes bool
+
+// previewTotal is the number of resources a search of resourceType will
+// return. The resource type is empty for the system-level search.
+type previewTotal struct {
+	resourceType string
+	total        int
+}
+
+// countSearchTotal executes the search of resourceType, or the system-level
+// search if resourceType is empty, with fhirSearchQuery and _summary=count
+// and returns the total reported by the server.
+func countSearchTotal(client *fhir.Client, resourceType string, fhirSearchQuery string) (int, error) {
+	query, err := url.ParseQuery(fhirSearchQuery)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --query value `%s`: %w", fhirSearchQuery, err)
+	}
+	query.Set("_summary", "count")
+	for _, param := range []string{"_count", "_sort", "_include", "_revinclude", "_elements"} {
+		query.Del(param)
+	}
+
+	var req *http.Request
+	if resourceType == "" {
+		req, err = client.NewSearchSystemRequest(query)
+	} else {
+		req, err = client.NewSearchTypeRequest(resourceType, query)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return 0, fmt.Errorf("error while counting the resources to download:\n\n%s", errorResponse.String())
+	}
+	bundle, err := fhir.ReadBundle(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if bundle.Total == nil {
+		return 0, fmt.Errorf("the server didn't return the number of resources to download")
+	}
+	return *bundle.Total, nil
+}
+
+// fetchPreviewTotals counts the resources the download of resourceTypes, or
+// of all resources if none is given, will return.
+func fetchPreviewTotals(client *fhir.Client, resourceTypes []string, fhirSearchQuery string) ([]previewTotal, error) {
+	if len(resourceTypes) == 0 {
+		resourceTypes = []string{""}
+	}
+	totals := make([]previewTotal, 0, len(resourceTypes))
+	for _, resourceType := range resourceTypes {
+		total, err := countSearchTotal(client, resourceType, fhirSearchQuery)
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, previewTotal{resourceType: resourceType, total: total})
+	}
+	return totals, nil
+}
+
+// fmtPreview formats the totals of the resource types followed by their sum if
+// there is more than one resource type.
+func fmtPreview(totals []previewTotal) string {
+	if len(totals) == 1 {
+		if totals[0].resourceType == "" {
+			return fmt.Sprintf("The search will return %d resources.\n", totals[0].total)
+		}
+		return fmt.Sprintf("The search will return %d %s resources.\n", totals[0].total, totals[0].resourceType)
+	}
+
+	maxLen, sum := 0, 0
+	for _, total := range totals {
+		maxLen = maxInt(maxLen, len(total.resourceType))
+		sum += total.total
+	}
+	builder := strings.Builder{}
+	builder.WriteString("The search will return:\n")
+	for _, total := range totals {
+		builder.WriteString(fmt.Sprintf("  %-*s : %d\n", maxLen, total.resourceType, total.total))
+	}
+	builder.WriteString(fmt.Sprintf("  %-*s : %d\n", maxLen, "total", sum))
+	return builder.String()
+}
+
+// isTerminal returns true if file is a terminal.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks question on out and returns true if the answer read from in
+// is yes. Everything else, including no answer at all, means no.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// previewDownloadTotals prints the number of resources the download of
+// resourceTypes will return to stderr. Returns true if the download should be
+// done, either because of --yes or because the user confirmed it. Without
+// --yes, stdin has to be a terminal.
+func previewDownloadTotals(client *fhir.Client, resourceTypes []string, fhirSearchQuery string) (bool, error) {
+	totals, err := fetchPreviewTotals(client, resourceTypes, fhirSearchQuery)
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprint(os.Stderr, fmtPreview(totals))
+	if assumeYes {
+		return true, nil
+	}
+	if !isTerminal(os.Stdin) {
+		return false, fmt.Errorf("the download can't be confirmed because stdin isn't a terminal, please use --yes")
+	}
+	return confirm(os.Stdin, os.Stderr, "Start the download?"), nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFetchPreviewTotals(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "count", r.URL.Query().Get("_summary"))
+		assert.False(t, r.URL.Query().Has("_count"))
+		switch r.URL.Path {
+		case "/Observation":
+			assert.Equal(t, "http://loinc.org|8310-5", r.URL.Query().Get("code"))
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "total": 80000000}`))
+		case "/Patient":
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "total": 1000}`))
+		case "/":
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "total": 90000000}`))
+		case "/Condition":
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"resourceType": "OperationOutcome"}`))
+		}
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("one type", func(t *testing.T) {
+		totals, err := fetchPreviewTotals(client, []string{"Observation"}, "code=http://loinc.org|8310-5&_count=1000")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []previewTotal{{"Observation", 80000000}}, totals)
+		}
+	})
+
+	t.Run("two types", func(t *testing.T) {
+		totals, err := fetchPreviewTotals(client, []string{"Observation", "Patient"}, "code=http://loinc.org|8310-5")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []previewTotal{{"Observation", 80000000}, {"Patient", 1000}}, totals)
+		}
+	})
+
+	t.Run("system-level search", func(t *testing.T) {
+		totals, err := fetchPreviewTotals(client, nil, "")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []previewTotal{{"", 90000000}}, totals)
+		}
+	})
+
+	t.Run("missing total", func(t *testing.T) {
+		_, err := fetchPreviewTotals(client, []string{"Condition"}, "")
+
+		assert.EqualError(t, err, "the server didn't return the number of resources to download")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := fetchPreviewTotals(client, []string{"Foo"}, "")
+
+		assert.ErrorContains(t, err, "error while counting the resources to download")
+	})
+}
+
+func TestFmtPreview(t *testing.T) {
+	t.Run("one type", func(t *testing.T) {
+		assert.Equal(t, "The search will return 42 Observation resources.\n",
+			fmtPreview([]previewTotal{{"Observation", 42}}))
+	})
+
+	t.Run("system-level search", func(t *testing.T) {
+		assert.Equal(t, "The search will return 42 resources.\n", fmtPreview([]previewTotal{{"", 42}}))
+	})
+
+	t.Run("two types", func(t *testing.T) {
+		assert.Equal(t, `The search will return:
+  Observation : 42
+  Patient     : 3
+  total       : 45
+`, fmtPreview([]previewTotal{{"Observation", 42}, {"Patient", 3}}))
+	})
+}
+
+func TestConfirm(t *testing.T) {
+	for answer, expected := range map[string]bool{"y\n": true, "Yes\n": true, " y \n": true, "n\n": false, "\n": false, "": false, "foo\n": false} {
+		var out bytes.Buffer
+
+		assert.Equal(t, expected, confirm(strings.NewReader(answer), &out, "Start?"), "answer %q", answer)
+		assert.Equal(t, "Start? [y/N] ", out.String())
+	}
+}
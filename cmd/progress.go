@@ -0,0 +1,151 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/samply/blazectl/util"
+	"github.com/vbauerster/mpb/v7/cwriter"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var progressMode string
+
+// plainProgressInterval is the interval of the lines printed by --progress=plain.
+const plainProgressInterval = 10 * time.Second
+
+// createProgress creates the progress selected by --progress and
+// --no-progress. In auto mode, the progress bar is only shown if the standard
+// output is a terminal, because progress bars written into logs are
+// unreadable.
+func createProgress() (progress, error) {
+	mode := progressMode
+	if noProgress {
+		mode = "none"
+	}
+	switch mode {
+	case "auto":
+		if cwriter.IsTerminal(int(os.Stdout.Fd())) {
+			return createRealProgress(), nil
+		}
+		return newPlainProgress(os.Stdout, plainProgressInterval), nil
+	case "bar":
+		return createRealProgress(), nil
+	case "plain":
+		return newPlainProgress(os.Stdout, plainProgressInterval), nil
+	case "none":
+		return noopProgress{}, nil
+	default:
+		return nil, fmt.Errorf("invalid --progress value `%s`, expected one of auto, bar, plain or none", progressMode)
+	}
+}
+
+// plainProgress prints the progress of the upload as a line every interval
+// instead of a progress bar, for logs of CI systems and consoles which can't
+// show progress bars.
+type plainProgress struct {
+	out              io.Writer
+	start            time.Time
+	mutex            sync.Mutex
+	total, uploaded  int64
+	resources, bytes int64
+	totalFinal       bool
+	stop             chan struct{}
+	stopOnce         sync.Once
+	stopped          chan struct{}
+}
+
+func newPlainProgress(out io.Writer, interval time.Duration) *plainProgress {
+	p := &plainProgress{out: out, start: time.Now(), stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.printLine()
+			case <-p.stop:
+				p.printLine()
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *plainProgress) printLine() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	elapsed := time.Since(p.start).Seconds()
+	throughput := fmt.Sprintf("%.0f res/s, %s/s", float64(p.resources)/elapsed,
+		util.FmtBytesHumanReadable(float32(float64(p.bytes)/elapsed)))
+	if p.totalFinal && p.total > 0 {
+		fmt.Fprintf(p.out, "upload %5.1f %% (%d of %d bundles), %s\n", float64(p.uploaded)/float64(p.total)*100,
+			p.uploaded, p.total, throughput)
+	} else {
+		fmt.Fprintf(p.out, "upload %d of at least %d bundles, %s\n", p.uploaded, p.total, throughput)
+	}
+}
+
+// finish stops printing lines after a last one.
+func (p *plainProgress) finish() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// finishIfComplete finishes if all bundles are uploaded. The mutex has to be
+// held.
+func (p *plainProgress) finishIfComplete() {
+	if p.totalFinal && p.uploaded >= p.total {
+		p.finish()
+	}
+}
+
+func (p *plainProgress) addBundle() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.total++
+}
+
+func (p *plainProgress) doneAddingBundles() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.totalFinal = true
+	p.finishIfComplete()
+}
+
+func (p *plainProgress) trackFileScan(_ string, _ int64, r io.Reader) (io.Reader, func()) {
+	return r, func() {}
+}
+
+func (p *plainProgress) increment(resources int, bytes int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.uploaded++
+	p.resources += int64(resources)
+	p.bytes += bytes
+	p.finishIfComplete()
+}
+
+func (p *plainProgress) abort() {
+	p.finish()
+}
+
+func (p *plainProgress) wait() {
+	<-p.stopped
+}
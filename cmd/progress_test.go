@@ -0,0 +1,182 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/vbauerster/mpb/v7/decor"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a buffer which can be written and read concurrently.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestCreateProgress(t *testing.T) {
+	t.Run("None", func(t *testing.T) {
+		progressMode = "none"
+		defer func() { progressMode = "auto" }()
+
+		progress, err := createProgress()
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, noopProgress{}, progress)
+		}
+	})
+
+	t.Run("NoProgress", func(t *testing.T) {
+		noProgress = true
+		defer func() { noProgress = false }()
+
+		progress, err := createProgress()
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, noopProgress{}, progress)
+		}
+	})
+
+	t.Run("AutoWithoutTerminal", func(t *testing.T) {
+		progress, err := createProgress()
+
+		if assert.NoError(t, err) {
+			assert.IsType(t, &plainProgress{}, progress)
+			progress.abort()
+			progress.wait()
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		progressMode = "fancy"
+		defer func() { progressMode = "auto" }()
+
+		_, err := createProgress()
+
+		assert.EqualError(t, err, "invalid --progress value `fancy`, expected one of auto, bar, plain or none")
+	})
+}
+
+func TestPlainProgress(t *testing.T) {
+	t.Run("Complete", func(t *testing.T) {
+		var out syncBuffer
+		progress := newPlainProgress(&out, time.Hour)
+		progress.addBundle()
+		progress.addBundle()
+		progress.doneAddingBundles()
+		progress.increment(10, 1024)
+		progress.increment(10, 1024)
+		progress.wait()
+
+		assert.Regexp(t, `^upload 100\.0 % \(2 of 2 bundles\), \d+ res/s, .+/s\n$`, out.String())
+	})
+
+	t.Run("Periodic", func(t *testing.T) {
+		var out syncBuffer
+		progress := newPlainProgress(&out, time.Millisecond)
+		progress.addBundle()
+		assert.Eventually(t, func() bool {
+			return strings.Contains(out.String(), "upload 0 of at least 1 bundles, ")
+		}, time.Second, time.Millisecond)
+		progress.abort()
+		progress.wait()
+	})
+
+	t.Run("NoBundles", func(t *testing.T) {
+		var out syncBuffer
+		progress := newPlainProgress(&out, time.Hour)
+		progress.doneAddingBundles()
+		progress.wait()
+
+		assert.Contains(t, out.String(), "upload 0 of at least 0 bundles, ")
+	})
+}
+
+func TestFmtThroughput(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources int64
+		bytes     int64
+		elapsed   time.Duration
+		expected  string
+	}{
+		{"OneSecond", 100, 2048, time.Second, "100 res/s, 2.00 KiB/s"},
+		{"TwoSeconds", 100, 3 << 20, 2 * time.Second, "50 res/s, 1.50 MiB/s"},
+		{"Fraction", 1, 512, 4 * time.Second, "0 res/s, 128.00 B/s"},
+		{"Nothing", 0, 0, time.Second, "0 res/s, 0.00 B/s"},
+		{"ZeroElapsed", 100, 2048, 0, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, fmtThroughput(test.resources, test.bytes, test.elapsed))
+		})
+	}
+}
+
+func TestThroughputDecorator(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resources := &atomic.Int64{}
+	bytes := &atomic.Int64{}
+	decorator := throughputDecorator(newThroughputMeter(func() time.Time { return now }, resources, bytes))
+
+	// advance runs the given number of seconds uploading rate resources of 1 KiB
+	// per second and renders the decorator each second
+	advance := func(seconds int, rate int64) string {
+		var rendered string
+		for i := 0; i < seconds; i++ {
+			now = now.Add(time.Second)
+			resources.Add(rate)
+			bytes.Add(rate << 10)
+			rendered = strings.TrimSpace(decorator.Decor(decor.Statistics{}))
+		}
+		return rendered
+	}
+
+	t.Run("ZeroElapsed", func(t *testing.T) {
+		assert.Equal(t, "", strings.TrimSpace(decorator.Decor(decor.Statistics{})))
+	})
+
+	t.Run("SlowStart", func(t *testing.T) {
+		assert.Equal(t, "10 res/s, 10.00 KiB/s", advance(2, 10))
+		assert.Equal(t, "100 res/s, 100.00 KiB/s", advance(10, 100))
+	})
+
+	t.Run("Stall", func(t *testing.T) {
+		assert.Equal(t, "0 res/s, 0.00 B/s", advance(10, 0))
+	})
+
+	t.Run("AfterStall", func(t *testing.T) {
+		// the window of 5 seconds covers 2 seconds of the stall
+		assert.Equal(t, "120 res/s, 120.00 KiB/s", advance(3, 200))
+	})
+}
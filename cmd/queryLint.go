@@ -0,0 +1,171 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var lintQuery bool
+
+// resultParameters are the search parameters controlling the search result
+// instead of filtering resources. They are never listed in a
+// CapabilityStatement.
+var resultParameters = []string{"_count", "_sort", "_include", "_revinclude", "_summary", "_elements", "_total",
+	"_contained", "_containedType", "_format", "_pretty", "_has", "_type"}
+
+// advertisedSearchParams returns the names of the search parameters the server
+// advertises in capabilityStatement for resourceType together with the ones
+// advertised for all types. The search parameters of the system-level search
+// are returned if resourceType is empty. Returns false if the server doesn't
+// support the search of resourceType.
+func advertisedSearchParams(capabilityStatement fm.CapabilityStatement, resourceType string) ([]string, bool) {
+	var names []string
+	found := resourceType == ""
+	for _, rest := range capabilityStatement.Rest {
+		if rest.Mode != fm.RestfulCapabilityModeServer {
+			continue
+		}
+		for _, param := range rest.SearchParam {
+			names = append(names, param.Name)
+		}
+		for _, resource := range rest.Resource {
+			if resource.Type.Code() != resourceType {
+				continue
+			}
+			found = true
+			for _, param := range resource.SearchParam {
+				names = append(names, param.Name)
+			}
+		}
+	}
+	return names, found
+}
+
+// searchParamName returns the name of the search parameter of the query
+// parameter key without modifier and chain.
+func searchParamName(key string) string {
+	if i := strings.IndexAny(key, ":."); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// closestSearchParam returns the name out of names closest to name, ignoring
+// case. Names differing by more than a third of the length of name, but at
+// least two edits, aren't considered. Returns an empty string if no name is
+// close enough.
+func closestSearchParam(name string, names []string) string {
+	closest := ""
+	closestDistance := maxInt(2, len(name)/3) + 1
+	for _, candidate := range names {
+		distance := editDistance(strings.ToLower(name), strings.ToLower(candidate))
+		if distance < closestDistance {
+			closest = candidate
+			closestDistance = distance
+		}
+	}
+	return closest
+}
+
+// lintSearchQuery checks that all parameters of query are advertised by the
+// server in capabilityStatement for the search of resourceType. Returns a
+// warning for each parameter which isn't advertised, with the closest
+// advertised parameter as suggestion.
+func lintSearchQuery(capabilityStatement fm.CapabilityStatement, resourceType string, query url.Values) []string {
+	advertised, supported := advertisedSearchParams(capabilityStatement, resourceType)
+	if !supported {
+		return []string{fmt.Sprintf("the server doesn't advertise the search of the resource type %s", resourceType)}
+	}
+	names := append(advertised, resultParameters...)
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var warnings []string
+	target := resourceType
+	if target == "" {
+		target = "the system-level search"
+	}
+	for _, key := range keys {
+		name := searchParamName(key)
+		if slices.Contains(names, name) {
+			continue
+		}
+		warning := fmt.Sprintf("the search parameter `%s` isn't advertised by the server for %s and may be ignored", name, target)
+		if suggestion := closestSearchParam(name, names); suggestion != "" {
+			warning += fmt.Sprintf(", did you mean `%s`?", suggestion)
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+// lintDownloadQuery lints the query of a download of resourceTypes, or of all
+// resources if none is given, against the CapabilityStatement of the server and
+// writes the warnings to w.
+func lintDownloadQuery(client *fhir.Client, resourceTypes []string, fhirSearchQuery string, w io.Writer) error {
+	query, err := url.ParseQuery(fhirSearchQuery)
+	if err != nil {
+		return fmt.Errorf("invalid --query value `%s`: %w", fhirSearchQuery, err)
+	}
+	if len(query) == 0 {
+		return nil
+	}
+	capabilityStatement, err := fetchCapabilityStatement(client)
+	if err != nil {
+		return fmt.Errorf("error while linting the query: %w", err)
+	}
+	if len(resourceTypes) == 0 {
+		resourceTypes = []string{""}
+	}
+	for _, resourceType := range resourceTypes {
+		for _, warning := range lintSearchQuery(capabilityStatement, resourceType, query) {
+			fmt.Fprintf(w, "Warning: %s\n", warning)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const testSearchCapabilityStatement = `{
+  "resourceType": "CapabilityStatement",
+  "status": "active",
+  "kind": "instance",
+  "rest": [{
+    "mode": "server",
+    "resource": [
+      {"type": "Patient", "searchParam": [{"name": "birthdate", "type": "date"}, {"name": "gender", "type": "token"}]},
+      {"type": "Observation", "searchParam": [{"name": "code", "type": "token"}, {"name": "subject", "type": "reference"}]}
+    ],
+    "searchParam": [{"name": "_id", "type": "token"}, {"name": "_lastUpdated", "type": "date"}]
+  }]
+}`
+
+func TestSearchParamName(t *testing.T) {
+	assert.Equal(t, "code", searchParamName("code"))
+	assert.Equal(t, "code", searchParamName("code:in"))
+	assert.Equal(t, "subject", searchParamName("subject:Patient.name"))
+	assert.Equal(t, "subject", searchParamName("subject.name"))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("gender", "gender"))
+	assert.Equal(t, 1, editDistance("birthdat", "birthdate"))
+	assert.Equal(t, 2, editDistance("brithdate", "birthdate"))
+	assert.Equal(t, 3, editDistance("", "foo"))
+}
+
+func TestClosestSearchParam(t *testing.T) {
+	names := []string{"birthdate", "gender", "_id", "_lastUpdated"}
+
+	assert.Equal(t, "birthdate", closestSearchParam("brithdate", names))
+	assert.Equal(t, "_lastUpdated", closestSearchParam("_lastupdated", names))
+	assert.Equal(t, "gender", closestSearchParam("gendre", names))
+	assert.Equal(t, "", closestSearchParam("family", names))
+}
+
+func TestLintSearchQuery(t *testing.T) {
+	capabilityStatement, err := fhir.ReadCapabilityStatement(bytes.NewReader([]byte(testSearchCapabilityStatement)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("advertised parameters", func(t *testing.T) {
+		query, _ := url.ParseQuery("code:in=foo&subject:Patient.gender=male&_id=0&_count=100&_sort=_lastUpdated")
+
+		assert.Empty(t, lintSearchQuery(capabilityStatement, "Observation", query))
+	})
+
+	t.Run("typo", func(t *testing.T) {
+		query, _ := url.ParseQuery("brithdate=2020&gender=male")
+
+		assert.Equal(t, []string{
+			"the search parameter `brithdate` isn't advertised by the server for Patient and may be ignored, did you mean `birthdate`?",
+		}, lintSearchQuery(capabilityStatement, "Patient", query))
+	})
+
+	t.Run("unknown parameter", func(t *testing.T) {
+		query, _ := url.ParseQuery("family=Doe")
+
+		assert.Equal(t, []string{
+			"the search parameter `family` isn't advertised by the server for Patient and may be ignored",
+		}, lintSearchQuery(capabilityStatement, "Patient", query))
+	})
+
+	t.Run("parameter of another type", func(t *testing.T) {
+		query, _ := url.ParseQuery("gender=male")
+
+		assert.Len(t, lintSearchQuery(capabilityStatement, "Observation", query), 1)
+	})
+
+	t.Run("system-level search", func(t *testing.T) {
+		query, _ := url.ParseQuery("_lastUpdated=gt2024&code=foo")
+
+		assert.Equal(t, []string{
+			"the search parameter `code` isn't advertised by the server for the system-level search and may be ignored",
+		}, lintSearchQuery(capabilityStatement, "", query))
+	})
+
+	t.Run("unsupported resource type", func(t *testing.T) {
+		query, _ := url.ParseQuery("code=foo")
+
+		assert.Equal(t, []string{"the server doesn't advertise the search of the resource type Condition"},
+			lintSearchQuery(capabilityStatement, "Condition", query))
+	})
+}
+
+func TestLintDownloadQuery(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/metadata", r.URL.Path)
+		_, _ = w.Write([]byte(testSearchCapabilityStatement))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("with typo", func(t *testing.T) {
+		var out bytes.Buffer
+
+		err := lintDownloadQuery(client, []string{"Patient", "Observation"}, "gendr=male", &out)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Warning: the search parameter `gendr` isn't advertised by the server for Patient and may be ignored, did you mean `gender`?\n"+
+				"Warning: the search parameter `gendr` isn't advertised by the server for Observation and may be ignored\n", out.String())
+		}
+	})
+
+	t.Run("without query", func(t *testing.T) {
+		requests = 0
+		var out bytes.Buffer
+
+		err := lintDownloadQuery(client, nil, "", &out)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, requests)
+			assert.Empty(t, out.String())
+		}
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		err := lintDownloadQuery(client, nil, "%zz", &bytes.Buffer{})
+
+		assert.ErrorContains(t, err, "invalid --query value `%zz`")
+	})
+}
@@ -0,0 +1,342 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var populateSubject string
+var responsesOutputFile string
+
+// populateQuestionnaire invokes $populate on the Questionnaire with the given
+// id for subject and returns the resulting QuestionnaireResponse.
+func populateQuestionnaire(client *fhir.Client, id string, subject string) ([]byte, error) {
+	parameters := fm.Parameters{
+		Parameter: []fm.ParametersParameter{
+			{Name: "subject", ValueReference: &fm.Reference{Reference: &subject}},
+		},
+	}
+	payload, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+	req, err := client.NewPostInstanceOperationRequest("Questionnaire", id, "populate", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return nil, fmt.Errorf("error while populating the Questionnaire with id %s:\n\n%s", id, errorResponse.String())
+	}
+	return populatedResponse(body)
+}
+
+// populatedResponse returns the QuestionnaireResponse of the result of
+// $populate. The result is either the QuestionnaireResponse itself or a
+// Parameters resource holding it.
+func populatedResponse(result []byte) ([]byte, error) {
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+		Parameter    []struct {
+			Resource json.RawMessage `json:"resource"`
+		} `json:"parameter"`
+	}
+	if err := json.Unmarshal(result, &resource); err != nil {
+		return nil, fmt.Errorf("error while reading the result of $populate: %w", err)
+	}
+	if resource.ResourceType == "QuestionnaireResponse" {
+		return result, nil
+	}
+	for _, parameter := range resource.Parameter {
+		var nested struct {
+			ResourceType string `json:"resourceType"`
+		}
+		if err := json.Unmarshal(parameter.Resource, &nested); err == nil && nested.ResourceType == "QuestionnaireResponse" {
+			return parameter.Resource, nil
+		}
+	}
+	return nil, errors.New("the result of $populate contains no QuestionnaireResponse")
+}
+
+// responseRow is a QuestionnaireResponse flattened into the answers of its
+// items by linkId.
+type responseRow struct {
+	id            string
+	questionnaire string
+	subject       string
+	authored      string
+	answers       map[string][]string
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// answerValue returns the value of answer as string. Codings are represented
+// by their code, quantities by value and unit and references by their literal
+// reference.
+func answerValue(answer fm.QuestionnaireResponseItemAnswer) string {
+	switch {
+	case answer.ValueBoolean != nil:
+		return strconv.FormatBool(*answer.ValueBoolean)
+	case answer.ValueDecimal != nil:
+		return answer.ValueDecimal.String()
+	case answer.ValueInteger != nil:
+		return strconv.Itoa(*answer.ValueInteger)
+	case answer.ValueDate != nil:
+		return *answer.ValueDate
+	case answer.ValueDateTime != nil:
+		return *answer.ValueDateTime
+	case answer.ValueTime != nil:
+		return *answer.ValueTime
+	case answer.ValueString != nil:
+		return *answer.ValueString
+	case answer.ValueUri != nil:
+		return *answer.ValueUri
+	case answer.ValueCoding != nil:
+		return stringValue(answer.ValueCoding.Code)
+	case answer.ValueQuantity != nil && answer.ValueQuantity.Value != nil:
+		return strings.TrimSpace(answer.ValueQuantity.Value.String() + " " + stringValue(answer.ValueQuantity.Unit))
+	case answer.ValueReference != nil:
+		return stringValue(answer.ValueReference.Reference)
+	case answer.ValueAttachment != nil:
+		return stringValue(answer.ValueAttachment.Url)
+	}
+	return ""
+}
+
+// flattenResponseItems collects the answers of items and all their nested
+// items into answers by linkId. The linkIds are appended to linkIds in the
+// order of their first occurrence.
+func flattenResponseItems(items []fm.QuestionnaireResponseItem, answers map[string][]string, linkIds *[]string,
+	seen map[string]bool) {
+	for _, item := range items {
+		for _, answer := range item.Answer {
+			if !seen[item.LinkId] {
+				seen[item.LinkId] = true
+				*linkIds = append(*linkIds, item.LinkId)
+			}
+			answers[item.LinkId] = append(answers[item.LinkId], answerValue(answer))
+			flattenResponseItems(answer.Item, answers, linkIds, seen)
+		}
+		flattenResponseItems(item.Item, answers, linkIds, seen)
+	}
+}
+
+// responseTable holds flattened QuestionnaireResponses together with the
+// linkIds of all their answers.
+type responseTable struct {
+	rows    []responseRow
+	linkIds []string
+	seen    map[string]bool
+}
+
+// addResponses adds the QuestionnaireResponses of the raw bundle entries in
+// data to the table.
+func (t *responseTable) addResponses(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	var entries []fm.BundleEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("could not parse the bundle entries from JSON: %v", err)
+	}
+	if t.seen == nil {
+		t.seen = make(map[string]bool)
+	}
+	for _, entry := range entries {
+		if entry.Search != nil && entry.Search.Mode != nil && *entry.Search.Mode != fm.SearchEntryModeMatch {
+			continue
+		}
+		response, err := fm.UnmarshalQuestionnaireResponse(entry.Resource)
+		if err != nil {
+			return fmt.Errorf("could not parse a QuestionnaireResponse from JSON: %v", err)
+		}
+		row := responseRow{
+			id:            stringValue(response.Id),
+			questionnaire: stringValue(response.Questionnaire),
+			authored:      stringValue(response.Authored),
+			answers:       make(map[string][]string),
+		}
+		if response.Subject != nil {
+			row.subject = stringValue(response.Subject.Reference)
+		}
+		flattenResponseItems(response.Item, row.answers, &t.linkIds, t.seen)
+		t.rows = append(t.rows, row)
+	}
+	return nil
+}
+
+// writeCsv writes the table as CSV with the columns id, questionnaire,
+// subject, authored and one column per linkId. Multiple answers of the same
+// linkId are joined by a pipe.
+func (t *responseTable) writeCsv(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+	header := append([]string{"id", "questionnaire", "subject", "authored"}, t.linkIds...)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		record := []string{row.id, row.questionnaire, row.subject, row.authored}
+		for _, linkId := range t.linkIds {
+			record = append(record, strings.Join(row.answers[linkId], "|"))
+		}
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+var populateCmd = &cobra.Command{
+	Use:   "populate [questionnaire-id]",
+	Short: "Populates a Questionnaire for a subject",
+	Long: `Invokes the $populate operation on the Questionnaire with the given id
+for the subject given by --subject and prints the resulting
+QuestionnaireResponse pre-filled with the data of the subject.
+
+Examples:
+  blazectl populate --server "http://localhost:8080/fhir" --subject Patient/0 phq-9`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one questionnaire-id argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		response, err := populateQuestionnaire(client, strings.TrimPrefix(args[0], "Questionnaire/"), populateSubject)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, response, "", "  "); err == nil {
+			response = indented.Bytes()
+		}
+		fmt.Println(string(response))
+		return nil
+	},
+}
+
+var downloadResponsesCmd = &cobra.Command{
+	Use:   "download-responses",
+	Short: "Download QuestionnaireResponses as CSV",
+	Long: `Searches QuestionnaireResponse resources and writes them as CSV with one
+row per QuestionnaireResponse.
+
+The columns are id, questionnaire, subject and authored followed by one
+column per linkId of all answered items, including nested items, in the order
+of their first occurrence. Multiple answers of the same linkId are joined by
+a pipe. Codings are written as their code, quantities as value and unit and
+references as their literal reference.
+
+The --query flag will take an optional FHIR search query that will be used
+to constrain the QuestionnaireResponses, usually by questionnaire, because
+responses of different Questionnaires don't share their linkIds.
+
+Examples:
+  blazectl download-responses --server "http://localhost:8080/fhir" -q "questionnaire=http://example.com/phq-9" -o phq-9.csv`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			return err
+		}
+
+		var table responseTable
+		bundleChannel := make(chan downloadBundle, 2)
+		go downloadResources(client, "QuestionnaireResponse", fhirSearchQuery, false, bundleChannel)
+
+		for bundle := range bundleChannel {
+			if bundle.err != nil || bundle.errResponse != nil {
+				fmt.Printf("Failed to download QuestionnaireResponses: %v\n", bundle.err)
+				if bundle.errResponse != nil {
+					fmt.Print(util.Indent(2, bundle.errResponse.String()))
+				}
+				os.Exit(1)
+			}
+			if err := table.addResponses(bundle.rawEntries); err != nil {
+				fmt.Printf("Failed to read QuestionnaireResponses received from request to URL %s: %v\n", bundle.associatedRequestURL.String(), err)
+				os.Exit(2)
+			}
+		}
+
+		var file *os.File
+		if responsesOutputFile == "" {
+			file = os.Stdout
+		} else {
+			file = createOutputFileOrDie(responsesOutputFile)
+		}
+		if err := table.writeCsv(file); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %d QuestionnaireResponses with %d linkIds.\n", len(table.rows), len(table.linkIds))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(populateCmd)
+	rootCmd.AddCommand(downloadResponsesCmd)
+
+	populateCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	populateCmd.Flags().StringVar(&populateSubject, "subject", "", "the subject to populate the Questionnaire for, like Patient/0")
+
+	_ = populateCmd.MarkFlagRequired("server")
+	_ = populateCmd.MarkFlagRequired("subject")
+
+	downloadResponsesCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	downloadResponsesCmd.Flags().StringVarP(&fhirSearchQuery, "query", "q", "", "FHIR search query for QuestionnaireResponses")
+	downloadResponsesCmd.Flags().StringVarP(&responsesOutputFile, "output-file", "o", "", "write to file instead of stdout")
+
+	_ = downloadResponsesCmd.MarkFlagRequired("server")
+}
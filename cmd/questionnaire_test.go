@@ -0,0 +1,95 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPopulateQuestionnaire(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/Questionnaire/0/$populate", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		var parameters fm.Parameters
+		if assert.NoError(t, json.Unmarshal(body, &parameters)) && assert.Len(t, parameters.Parameter, 1) {
+			assert.Equal(t, "subject", parameters.Parameter[0].Name)
+			assert.Equal(t, "Patient/0", *parameters.Parameter[0].ValueReference.Reference)
+		}
+		_, _ = w.Write([]byte(`{"resourceType": "Parameters", "parameter": [
+          {"name": "response", "resource": {"resourceType": "QuestionnaireResponse", "status": "in-progress"}}]}`))
+	}))
+	defer ts.Close()
+
+	baseURL, _ := url.ParseRequestURI(ts.URL)
+	response, err := populateQuestionnaire(fhir.NewClient(*baseURL, nil), "0", "Patient/0")
+
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"resourceType": "QuestionnaireResponse", "status": "in-progress"}`, string(response))
+	}
+}
+
+func TestPopulatedResponse(t *testing.T) {
+	t.Run("QuestionnaireResponse", func(t *testing.T) {
+		response, err := populatedResponse([]byte(`{"resourceType": "QuestionnaireResponse"}`))
+
+		if assert.NoError(t, err) {
+			assert.JSONEq(t, `{"resourceType": "QuestionnaireResponse"}`, string(response))
+		}
+	})
+
+	t.Run("Parameters without QuestionnaireResponse", func(t *testing.T) {
+		_, err := populatedResponse([]byte(`{"resourceType": "Parameters", "parameter": [
+          {"name": "issues", "resource": {"resourceType": "OperationOutcome"}}]}`))
+
+		assert.ErrorContains(t, err, "contains no QuestionnaireResponse")
+	})
+}
+
+func TestResponseTable(t *testing.T) {
+	var table responseTable
+	err := table.addResponses([]byte(`[
+  {"resource": {"resourceType": "QuestionnaireResponse", "id": "0", "status": "completed",
+    "questionnaire": "http://example.com/q", "subject": {"reference": "Patient/0"}, "authored": "2024-01-02",
+    "item": [
+      {"linkId": "1", "answer": [{"valueCoding": {"code": "yes"}, "item": [{"linkId": "1.1", "answer": [{"valueInteger": 3}]}]}]},
+      {"linkId": "2", "item": [{"linkId": "2.1", "answer": [{"valueString": "a"}, {"valueString": "b"}]}]}
+    ]}, "search": {"mode": "match"}},
+  {"resource": {"resourceType": "QuestionnaireResponse", "id": "1", "status": "completed",
+    "item": [
+      {"linkId": "3", "answer": [{"valueQuantity": {"value": 72.5, "unit": "kg"}}]},
+      {"linkId": "1", "answer": [{"valueBoolean": false}]}
+    ]}, "search": {"mode": "match"}}
+]`))
+
+	if assert.NoError(t, err) {
+		var buf bytes.Buffer
+		if assert.NoError(t, table.writeCsv(&buf)) {
+			assert.Equal(t, `id,questionnaire,subject,authored,1,1.1,2.1,3
+0,http://example.com/q,Patient/0,2024-01-02,yes,3,a|b,
+1,,,,false,,,72.5 kg
+`, buf.String())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var recoverExpiredPages bool
+
+// maxRecoveries is the number of recoveries in a row without downloading a
+// page in between, after which the download fails.
+const maxRecoveries = 3
+
+// isExpiredPage returns whether the response to a paginated request says that
+// the paging context expired or the page wasn't found.
+func isExpiredPage(statusCode int, body []byte) bool {
+	if statusCode == http.StatusNotFound || statusCode == http.StatusGone {
+		return true
+	}
+	var outcome struct {
+		ResourceType string `json:"resourceType"`
+		Issue        []struct {
+			Code        string `json:"code"`
+			Diagnostics string `json:"diagnostics"`
+		} `json:"issue"`
+	}
+	if err := json.Unmarshal(body, &outcome); err != nil || outcome.ResourceType != "OperationOutcome" {
+		return false
+	}
+	for _, issue := range outcome.Issue {
+		if issue.Code == "not-found" || strings.Contains(strings.ToLower(issue.Diagnostics), "expired") {
+			return true
+		}
+	}
+	return false
+}
+
+// pagingRecovery tracks the newest last update of the resources downloaded by
+// a search sorted by _lastUpdated, so that the search can be re-issued for
+// the resources last updated since then if the paging context expires. The
+// resources last updated exactly then were already downloaded and are
+// removed from the pages of the re-issued search.
+type pagingRecovery struct {
+	lastUpdated     string
+	lastUpdatedTime time.Time
+	seen            map[string]bool
+	// recoveries in a row without downloading a page in between
+	recoveries int
+}
+
+func newPagingRecovery() *pagingRecovery {
+	return &pagingRecovery{seen: make(map[string]bool)}
+}
+
+// query returns the search query of the re-issued search.
+func (r *pagingRecovery) query(query url.Values) url.Values {
+	resumed := url.Values{}
+	for key, values := range query {
+		resumed[key] = append([]string(nil), values...)
+	}
+	if r.lastUpdated != "" {
+		resumed.Add("_lastUpdated", "ge"+r.lastUpdated)
+	}
+	return resumed
+}
+
+// page removes the resources downloaded before from the raw entries of a page
+// and tracks the last update of the others. Included resources aren't
+// sorted and are left as they are.
+func (r *pagingRecovery) page(rawEntries []byte) ([]byte, error) {
+	r.recoveries = 0
+	if len(rawEntries) == 0 {
+		return rawEntries, nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(rawEntries, &entries); err != nil {
+		return nil, err
+	}
+
+	kept := make([]json.RawMessage, 0, len(entries))
+	for _, rawEntry := range entries {
+		var entry struct {
+			Resource struct {
+				ResourceType string `json:"resourceType"`
+				Id           string `json:"id"`
+				Meta         struct {
+					LastUpdated string `json:"lastUpdated"`
+				} `json:"meta"`
+			} `json:"resource"`
+			Search struct {
+				Mode string `json:"mode"`
+			} `json:"search"`
+		}
+		if err := json.Unmarshal(rawEntry, &entry); err != nil {
+			return nil, err
+		}
+		if entry.Search.Mode != "" && entry.Search.Mode != "match" {
+			kept = append(kept, rawEntry)
+			continue
+		}
+		key := entry.Resource.ResourceType + "/" + entry.Resource.Id
+		lastUpdated, err := time.Parse(time.RFC3339Nano, entry.Resource.Meta.LastUpdated)
+		if err != nil {
+			kept = append(kept, rawEntry)
+			continue
+		}
+		if lastUpdated.Equal(r.lastUpdatedTime) && r.seen[key] {
+			continue
+		}
+		kept = append(kept, rawEntry)
+		if lastUpdated.After(r.lastUpdatedTime) {
+			r.lastUpdated = entry.Resource.Meta.LastUpdated
+			r.lastUpdatedTime = lastUpdated
+			r.seen = make(map[string]bool)
+		}
+		if lastUpdated.Equal(r.lastUpdatedTime) {
+			r.seen[key] = true
+		}
+	}
+	if len(kept) == len(entries) {
+		return rawEntries, nil
+	}
+	return json.Marshal(kept)
+}
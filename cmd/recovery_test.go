@@ -0,0 +1,119 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsExpiredPage(t *testing.T) {
+	assert.True(t, isExpiredPage(http.StatusNotFound, nil))
+	assert.True(t, isExpiredPage(http.StatusGone, nil))
+	assert.True(t, isExpiredPage(http.StatusUnprocessableEntity, []byte(`{"resourceType": "OperationOutcome",
+		"issue": [{"severity": "error", "code": "processing", "diagnostics": "The paging context has Expired."}]}`)))
+	assert.True(t, isExpiredPage(http.StatusBadRequest, []byte(`{"resourceType": "OperationOutcome",
+		"issue": [{"severity": "error", "code": "not-found"}]}`)))
+	assert.False(t, isExpiredPage(http.StatusBadRequest, []byte(`{"resourceType": "OperationOutcome",
+		"issue": [{"severity": "error", "code": "invalid"}]}`)))
+	assert.False(t, isExpiredPage(http.StatusInternalServerError, []byte("foo")))
+}
+
+func TestPagingRecoveryQuery(t *testing.T) {
+	recovery := newPagingRecovery()
+	query := url.Values{"_sort": []string{"_lastUpdated"}}
+
+	assert.Equal(t, query, recovery.query(query))
+
+	_, _ = recovery.page([]byte(`[{"resource": {"resourceType": "Patient", "id": "0", "meta": {"lastUpdated": "2024-01-02T00:00:00Z"}}}]`))
+
+	assert.Equal(t, url.Values{"_sort": []string{"_lastUpdated"}, "_lastUpdated": []string{"ge2024-01-02T00:00:00Z"}},
+		recovery.query(query))
+	assert.Equal(t, url.Values{"_sort": []string{"_lastUpdated"}}, query)
+}
+
+func TestPagingRecoveryPage(t *testing.T) {
+	recovery := newPagingRecovery()
+	first := `[{"resource": {"resourceType": "Patient", "id": "0", "meta": {"lastUpdated": "2024-01-01T00:00:00Z"}}},
+		{"resource": {"resourceType": "Patient", "id": "1", "meta": {"lastUpdated": "2024-01-02T00:00:00Z"}}}]`
+
+	entries, err := recovery.page([]byte(first))
+	if assert.NoError(t, err) {
+		assert.Equal(t, first, string(entries))
+	}
+
+	// the re-issued search returns Patient/1 again together with a new resource
+	// updated at the same time and an included resource
+	entries, err = recovery.page([]byte(`[{"resource": {"resourceType": "Patient", "id": "1", "meta": {"lastUpdated": "2024-01-02T00:00:00Z"}}},
+		{"resource": {"resourceType": "Patient", "id": "2", "meta": {"lastUpdated": "2024-01-02T00:00:00Z"}}, "search": {"mode": "match"}},
+		{"resource": {"resourceType": "Organization", "id": "0"}, "search": {"mode": "include"}}]`))
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `[{"resource": {"resourceType": "Patient", "id": "2", "meta": {"lastUpdated": "2024-01-02T00:00:00Z"}}, "search": {"mode": "match"}},
+			{"resource": {"resourceType": "Organization", "id": "0"}, "search": {"mode": "include"}}]`, string(entries))
+	}
+}
+
+func TestDownloadResourcesRecoverExpiredPages(t *testing.T) {
+	recoverExpiredPages = true
+	defer func() { recoverExpiredPages = false }()
+
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		switch {
+		case r.URL.Query().Get("__page") == "2":
+			w.WriteHeader(http.StatusGone)
+		case len(r.URL.Query()["_lastUpdated"]) > 0:
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "entry": [
+				{"resource": {"resourceType": "Patient", "id": "1", "meta": {"lastUpdated": "2024-01-02T00:00:00Z"}}, "search": {"mode": "match"}},
+				{"resource": {"resourceType": "Patient", "id": "2", "meta": {"lastUpdated": "2024-01-03T00:00:00Z"}}, "search": {"mode": "match"}}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset",
+				"link": [{"relation": "next", "url": "Patient?__page=2"}],
+				"entry": [
+				  {"resource": {"resourceType": "Patient", "id": "0", "meta": {"lastUpdated": "2024-01-01T00:00:00Z"}}, "search": {"mode": "match"}},
+				  {"resource": {"resourceType": "Patient", "id": "1", "meta": {"lastUpdated": "2024-01-02T00:00:00Z"}}, "search": {"mode": "match"}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	bundleChannel := make(chan downloadBundle)
+	go downloadResources(client, "Patient", "", false, bundleChannel)
+
+	var pages []string
+	for bundle := range bundleChannel {
+		if assert.NoError(t, bundle.err) {
+			var sink strings.Builder
+			_, _, err := writeResources(&bundle.rawEntries, &sink)
+			assert.NoError(t, err)
+			pages = append(pages, sink.String())
+		}
+	}
+
+	assert.Equal(t, []string{"_sort=_lastUpdated", "__page=2", "_lastUpdated=ge2024-01-02T00%3A00%3A00Z&_sort=_lastUpdated"}, queries)
+	assert.Equal(t, []string{
+		`{"resourceType":"Patient","id":"0","meta":{"lastUpdated":"2024-01-01T00:00:00Z"}}` + "\n" +
+			`{"resourceType":"Patient","id":"1","meta":{"lastUpdated":"2024-01-02T00:00:00Z"}}` + "\n",
+		`{"resourceType":"Patient","id":"2","meta":{"lastUpdated":"2024-01-03T00:00:00Z"}}` + "\n",
+	}, pages)
+}
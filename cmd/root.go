@@ -17,18 +17,30 @@ package cmd
 import (
 	"fmt"
 	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
 	"github.com/spf13/cobra"
 	"net/url"
 	"os"
+	"strings"
 )
 
 var server string
 var disableTlsSecurity bool
 var caCert string
+var systemRoots bool
+var tlsMinVersion string
+var tlsCiphers []string
+var pinSha256 []string
 var basicAuthUser string
 var basicAuthPassword string
 var bearerToken string
 var noProgress bool
+var rawUnits bool
+var recordDir string
+var replayDir string
+var acceptMediaType string
+var contentMediaType string
+var concurrency int
 
 var client *fhir.Client
 
@@ -41,16 +53,92 @@ func createClient() error {
 	if disableTlsSecurity {
 		client = fhir.NewClientInsecure(*fhirServerBaseUrl, clientAuth())
 	} else if caCert != "" {
-		client, err = fhir.NewClientCa(*fhirServerBaseUrl, clientAuth(), caCert)
+		roots, err := rootCAs()
 		if err != nil {
 			return err
 		}
+		client = fhir.NewClient(*fhirServerBaseUrl, clientAuth())
+		client.SetRootCAs(roots)
 	} else {
 		client = fhir.NewClient(*fhirServerBaseUrl, clientAuth())
 	}
+
+	client.SetMediaTypes(acceptMediaType, contentMediaType)
+
+	if err := configureTLS(client); err != nil {
+		return err
+	}
+
+	if recordDir != "" && replayDir != "" {
+		return fmt.Errorf("the flags --record and --replay can't be used together")
+	}
+	if recordDir != "" {
+		if err := client.Record(recordDir); err != nil {
+			return err
+		}
+	}
+	if replayDir != "" {
+		client.Replay(replayDir)
+	}
+
+	if otelEndpoint != "" {
+		tracerProvider, err = newTracerProvider(otelEndpoint)
+		if err != nil {
+			return err
+		}
+		client.EnableTracing(tracerProvider)
+	}
 	return nil
 }
 
+// configureTLS applies the --tls-min-version, --tls-ciphers and --pin-sha256
+// flags to c.
+func configureTLS(c *fhir.Client) error {
+	var minVersion uint16
+	if tlsMinVersion != "" {
+		var err error
+		if minVersion, err = fhir.ParseTLSVersion(tlsMinVersion); err != nil {
+			return fmt.Errorf("invalid --tls-min-version value `%s`, expected one of 1.0, 1.1, 1.2 or 1.3", tlsMinVersion)
+		}
+	}
+	cipherSuites, err := fhir.ParseCipherSuites(tlsCiphers)
+	if err != nil {
+		return fmt.Errorf("invalid --tls-ciphers value: %v", err)
+	}
+	c.SetTLSVersionAndCiphers(minVersion, cipherSuites)
+
+	pins := make([]fhir.CertificatePin, 0, len(pinSha256))
+	for _, value := range pinSha256 {
+		pin, err := fhir.ParseCertificatePin(value)
+		if err != nil {
+			return err
+		}
+		pins = append(pins, pin)
+	}
+	c.SetCertificatePins(pins)
+	return nil
+}
+
+// addConcurrencyFlag adds the --concurrency flag, which is shared by all
+// commands running requests in parallel, to cmd. All commands use the same
+// default, because they share the variable.
+func addConcurrencyFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "c", 2, usage)
+}
+
+// unitFormat returns the format of durations and bytes in statistics selected
+// by the --raw-units flag.
+func unitFormat() util.UnitFormat {
+	return util.UnitFormat{Raw: rawUnits}
+}
+
+// fmtDurationStatistics formats min, mean, percentiles, max and standard
+// deviation of stats separated by commas.
+func fmtDurationStatistics(units util.UnitFormat, stats util.DurationStatistics) string {
+	return strings.Join([]string{units.Latency(stats.Min), units.Latency(stats.Mean), units.Latency(stats.Q50),
+		units.Latency(stats.Q95), units.Latency(stats.Q99), units.Latency(stats.Max), units.Latency(stats.StdDev)}, ", ")
+}
+
 func clientAuth() fhir.Auth {
 	if basicAuthUser != "" && basicAuthPassword != "" {
 		return fhir.BasicAuth{User: basicAuthUser, Password: basicAuthPassword}
@@ -75,17 +163,38 @@ and count resources and evaluate measures.`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	shutdownTracing()
+	if err != nil {
+		recordRunError(err)
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
 func init() {
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if cmd != selfUpdateCmd {
+			printUpdateNotice()
+		}
+		recordRun(cmd, args)
+	}
+
 	rootCmd.PersistentFlags().BoolVarP(&disableTlsSecurity, "insecure", "k", false, "allow insecure server connections when using SSL")
-	rootCmd.PersistentFlags().StringVar(&caCert, "certificate-authority", "", "path to a cert file for the certificate authority")
+	rootCmd.PersistentFlags().StringVar(&caCert, "certificate-authority", "", "path to a cert file or a directory of cert files for the certificate authority")
+	rootCmd.PersistentFlags().BoolVar(&systemRoots, "system-roots", false, "trust the system roots in addition to --certificate-authority")
+	rootCmd.PersistentFlags().StringVar(&tlsMinVersion, "tls-min-version", "", "minimum TLS version, one of 1.0, 1.1, 1.2 or 1.3 (default 1.2)")
+	rootCmd.PersistentFlags().StringSliceVar(&tlsCiphers, "tls-ciphers", nil, "comma separated IANA names of the cipher suites to offer for TLS 1.2 and below")
+	rootCmd.PersistentFlags().StringSliceVar(&pinSha256, "pin-sha256", nil, "only connect to servers with a certificate whose public key or fingerprint has this SHA-256 hash, can be repeated")
 	rootCmd.PersistentFlags().StringVar(&basicAuthUser, "user", "", "user information for basic authentication")
 	rootCmd.PersistentFlags().StringVar(&basicAuthPassword, "password", "", "password information for basic authentication")
 	rootCmd.PersistentFlags().StringVar(&bearerToken, "token", "", "bearer token for authentication")
+	rootCmd.PersistentFlags().StringVar(&acceptMediaType, "accept", "application/fhir+json", "media type sent in the Accept header of FHIR requests")
+	rootCmd.PersistentFlags().StringVar(&contentMediaType, "content-type", "application/fhir+json", "media type sent in the Content-Type header of FHIR requests with body")
 	rootCmd.PersistentFlags().BoolVarP(&noProgress, "no-progress", "", false, "don't show progress bar")
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", "auto", "progress output, one of auto, bar, plain or none, where auto shows a bar only on terminals")
+	rootCmd.PersistentFlags().StringVar(&otelEndpoint, "otel-endpoint", "", "URL of an OTLP/HTTP endpoint to send OpenTelemetry spans of all requests to")
+	rootCmd.PersistentFlags().StringVar(&recordDir, "record", "", "store all responses of the server in this directory")
+	rootCmd.PersistentFlags().StringVar(&replayDir, "replay", "", "serve all responses from this directory written by --record instead of contacting the server")
+	rootCmd.PersistentFlags().BoolVar(&rawUnits, "raw-units", false, "print durations in seconds and sizes in bytes as plain numbers in statistics")
 }
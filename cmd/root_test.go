@@ -32,4 +32,13 @@ func TestCreateClient(t *testing.T) {
 			t.Fatal("Expected the command to succeed if a valid URL is provided as a server information.")
 		}
 	})
+
+	t.Run("FailsWithInvalidTLSVersion", func(t *testing.T) {
+		server = "localhost:9200"
+		tlsMinVersion = "1.4"
+		defer func() { tlsMinVersion = "" }()
+		if err := createClient(); err == nil || err.Error() != "invalid --tls-min-version value `1.4`, expected one of 1.0, 1.1, 1.2 or 1.3" {
+			t.Fatalf("Expected the command to fail with an invalid TLS version but was: %v", err)
+		}
+	})
 }
@@ -0,0 +1,242 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/samply/blazectl/util"
+	"github.com/spf13/cobra"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+var sampleFraction float64
+var sampleSize int
+var sampleSeed int64
+
+// forEachNDJSONLine calls fn with each non-blank line of the NDJSON files at
+// paths, or of stdin if no paths are given, together with the name of the
+// input and the number of the line.
+func forEachNDJSONLine(paths []string, stdin io.Reader, fn func(name string, number int, line []byte) error) error {
+	if len(paths) == 0 {
+		return forEachLine("stdin", stdin, fn)
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = forEachLine(path, file, fn)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachLine(name string, r io.Reader, fn func(name string, number int, line []byte) error) error {
+	reader := bufio.NewReader(r)
+	if _, err := util.SkipBOM(reader); err != nil {
+		return fmt.Errorf("error while reading %s: %w", name, err)
+	}
+	for number := 1; ; number++ {
+		line, err := readLine(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error while reading %s: %w", name, err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := fn(name, number, line); err != nil {
+			return err
+		}
+	}
+}
+
+// sampler selects lines out of a stream of lines.
+type sampler interface {
+	// add offers the next line to the sampler.
+	add(line []byte) error
+	// finish writes the lines still held by the sampler.
+	finish() error
+	// counts returns the number of lines selected and seen.
+	counts() (selected int, seen int)
+}
+
+// fractionSampler writes each line with the probability of fraction right
+// away, so that it needs no memory. The number of selected lines varies
+// around fraction of all lines.
+type fractionSampler struct {
+	w              io.Writer
+	fraction       float64
+	rng            *rand.Rand
+	selected, seen int
+}
+
+func (s *fractionSampler) add(line []byte) error {
+	s.seen++
+	if s.rng.Float64() >= s.fraction {
+		return nil
+	}
+	s.selected++
+	return writeNDJSONLine(s.w, line)
+}
+
+func (s *fractionSampler) finish() error {
+	return nil
+}
+
+func (s *fractionSampler) counts() (int, int) {
+	return s.selected, s.seen
+}
+
+// sampledLine is a line selected by a reservoirSampler together with its
+// position in the input.
+type sampledLine struct {
+	ordinal int
+	line    []byte
+}
+
+// reservoirSampler selects exactly size lines, or all lines if there are
+// fewer, with equal probability by reservoir sampling. Only the selected
+// lines are held in memory. They are written in the order of the input.
+type reservoirSampler struct {
+	w     io.Writer
+	size  int
+	rng   *rand.Rand
+	lines []sampledLine
+	seen  int
+}
+
+func (s *reservoirSampler) add(line []byte) error {
+	s.seen++
+	if len(s.lines) < s.size {
+		s.lines = append(s.lines, sampledLine{ordinal: s.seen, line: line})
+		return nil
+	}
+	if i := s.rng.Intn(s.seen); i < s.size {
+		s.lines[i] = sampledLine{ordinal: s.seen, line: line}
+	}
+	return nil
+}
+
+func (s *reservoirSampler) finish() error {
+	sort.Slice(s.lines, func(i, j int) bool { return s.lines[i].ordinal < s.lines[j].ordinal })
+	for _, l := range s.lines {
+		if err := writeNDJSONLine(s.w, l.line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *reservoirSampler) counts() (int, int) {
+	return len(s.lines), s.seen
+}
+
+func writeNDJSONLine(w io.Writer, line []byte) error {
+	if _, err := w.Write(line); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{'\n'})
+	return err
+}
+
+// createSampler creates the sampler selected by --fraction or --size, which
+// writes to w.
+func createSampler(w io.Writer, fraction float64, size int, rng *rand.Rand) (sampler, error) {
+	switch {
+	case fraction != 0 && size != 0:
+		return nil, fmt.Errorf("the flags --fraction and --size can't be used together")
+	case fraction != 0:
+		if fraction < 0 || fraction > 1 {
+			return nil, fmt.Errorf("invalid --fraction value `%g`, expected a number between 0 and 1", fraction)
+		}
+		return &fractionSampler{w: w, fraction: fraction, rng: rng}, nil
+	case size != 0:
+		if size < 0 {
+			return nil, fmt.Errorf("invalid --size value `%d`, expected a positive number", size)
+		}
+		return &reservoirSampler{w: w, size: size, rng: rng}, nil
+	default:
+		return nil, fmt.Errorf("please use either --fraction or --size")
+	}
+}
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample [file.ndjson]...",
+	Short: "Sample resources of NDJSON files",
+	Long: `Selects a random sample of the resources of the given NDJSON files, like
+the output of download, or of stdin if no files are given and writes them to
+stdout. This is useful to create small but representative test datasets out
+of large exports.
+
+With --fraction, each resource is selected with the given probability while
+reading, so that about that fraction of all resources is selected and no
+memory is needed. With --size, exactly that number of resources is selected
+by reservoir sampling, holding only the selected resources in memory. In
+both cases, the selected resources keep the order of the input.
+
+The sample is reproducible with --seed. The number of selected resources is
+printed to stderr at the end.
+
+Examples:
+  blazectl sample --fraction 0.01 Patient.ndjson > Patient-sample.ndjson
+  blazectl sample --size 1000 --seed 42 < Observation.ndjson > Observation-sample.ndjson`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		seed := sampleSeed
+		if !cmd.Flags().Changed("seed") {
+			seed = time.Now().UnixNano()
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		s, err := createSampler(out, sampleFraction, sampleSize, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			return err
+		}
+		err = forEachNDJSONLine(args, os.Stdin, func(_ string, _ int, line []byte) error {
+			return s.add(line)
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.finish(); err != nil {
+			return err
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		selected, seen := s.counts()
+		fmt.Fprintf(os.Stderr, "Sampled %d of %d resources.\n", selected, seen)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sampleCmd)
+
+	sampleCmd.Flags().Float64Var(&sampleFraction, "fraction", 0, "probability between 0 and 1 with which each resource is selected")
+	sampleCmd.Flags().IntVar(&sampleSize, "size", 0, "number of resources to select")
+	sampleCmd.Flags().Int64Var(&sampleSeed, "seed", 0, "seed of the random numbers to make the sample reproducible")
+}
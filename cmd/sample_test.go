@@ -0,0 +1,217 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sampleTestLines returns n NDJSON lines of Patients with ids from 0 to n-1.
+func sampleTestLines(n int) string {
+	builder := strings.Builder{}
+	for i := 0; i < n; i++ {
+		builder.WriteString(fmt.Sprintf("{\"resourceType\":\"Patient\",\"id\":\"%d\"}\n", i))
+	}
+	return builder.String()
+}
+
+func runSampler(t *testing.T, s sampler, input string) {
+	err := forEachNDJSONLine(nil, strings.NewReader(input), func(_ string, _ int, line []byte) error {
+		return s.add(line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForEachNDJSONLine(t *testing.T) {
+	t.Run("Stdin", func(t *testing.T) {
+		var lines []string
+		err := forEachNDJSONLine(nil, strings.NewReader("\xEF\xBB\xBF{\"a\":1}\n\n{\"b\":2}"), func(name string, number int, line []byte) error {
+			lines = append(lines, fmt.Sprintf("%s:%d:%s", name, number, line))
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"stdin:1:{\"a\":1}", "stdin:3:{\"b\":2}"}, lines)
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		dir := t.TempDir()
+		a := filepath.Join(dir, "a.ndjson")
+		b := filepath.Join(dir, "b.ndjson")
+		if err := os.WriteFile(a, []byte("{\"a\":1}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(b, []byte("{\"b\":2}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var lines []string
+		err := forEachNDJSONLine([]string{a, b}, nil, func(name string, number int, line []byte) error {
+			lines = append(lines, fmt.Sprintf("%s:%d:%s", filepath.Base(name), number, line))
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a.ndjson:1:{\"a\":1}", "b.ndjson:1:{\"b\":2}"}, lines)
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		err := forEachNDJSONLine([]string{filepath.Join(t.TempDir(), "missing.ndjson")}, nil, func(_ string, _ int, _ []byte) error {
+			return nil
+		})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestCreateSampler(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+
+	t.Run("Fraction", func(t *testing.T) {
+		s, err := createSampler(&bytes.Buffer{}, 0.5, 0, rng)
+
+		assert.NoError(t, err)
+		assert.IsType(t, &fractionSampler{}, s)
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		s, err := createSampler(&bytes.Buffer{}, 0, 10, rng)
+
+		assert.NoError(t, err)
+		assert.IsType(t, &reservoirSampler{}, s)
+	})
+
+	t.Run("Both", func(t *testing.T) {
+		_, err := createSampler(&bytes.Buffer{}, 0.5, 10, rng)
+
+		assert.EqualError(t, err, "the flags --fraction and --size can't be used together")
+	})
+
+	t.Run("None", func(t *testing.T) {
+		_, err := createSampler(&bytes.Buffer{}, 0, 0, rng)
+
+		assert.EqualError(t, err, "please use either --fraction or --size")
+	})
+
+	t.Run("InvalidFraction", func(t *testing.T) {
+		_, err := createSampler(&bytes.Buffer{}, 1.5, 0, rng)
+
+		assert.EqualError(t, err, "invalid --fraction value `1.5`, expected a number between 0 and 1")
+	})
+
+	t.Run("InvalidSize", func(t *testing.T) {
+		_, err := createSampler(&bytes.Buffer{}, 0, -1, rng)
+
+		assert.EqualError(t, err, "invalid --size value `-1`, expected a positive number")
+	})
+}
+
+func TestFractionSampler(t *testing.T) {
+	t.Run("All", func(t *testing.T) {
+		var out bytes.Buffer
+		s := &fractionSampler{w: &out, fraction: 1, rng: rand.New(rand.NewSource(0))}
+
+		runSampler(t, s, sampleTestLines(10))
+
+		assert.Equal(t, sampleTestLines(10), out.String())
+		selected, seen := s.counts()
+		assert.Equal(t, 10, selected)
+		assert.Equal(t, 10, seen)
+	})
+
+	t.Run("AboutTheFraction", func(t *testing.T) {
+		var out bytes.Buffer
+		s := &fractionSampler{w: &out, fraction: 0.1, rng: rand.New(rand.NewSource(0))}
+
+		runSampler(t, s, sampleTestLines(10000))
+
+		selected, seen := s.counts()
+		assert.Equal(t, 10000, seen)
+		assert.InDelta(t, 1000, selected, 100)
+		assert.Equal(t, selected, strings.Count(out.String(), "\n"))
+	})
+
+	t.Run("Reproducible", func(t *testing.T) {
+		var a, b bytes.Buffer
+		runSampler(t, &fractionSampler{w: &a, fraction: 0.1, rng: rand.New(rand.NewSource(42))}, sampleTestLines(100))
+		runSampler(t, &fractionSampler{w: &b, fraction: 0.1, rng: rand.New(rand.NewSource(42))}, sampleTestLines(100))
+
+		assert.Equal(t, a.String(), b.String())
+	})
+}
+
+func TestReservoirSampler(t *testing.T) {
+	t.Run("FewerLinesThanSize", func(t *testing.T) {
+		var out bytes.Buffer
+		s := &reservoirSampler{w: &out, size: 10, rng: rand.New(rand.NewSource(0))}
+
+		runSampler(t, s, sampleTestLines(3))
+
+		assert.Equal(t, sampleTestLines(3), out.String())
+		selected, seen := s.counts()
+		assert.Equal(t, 3, selected)
+		assert.Equal(t, 3, seen)
+	})
+
+	t.Run("ExactSizeInInputOrder", func(t *testing.T) {
+		var out bytes.Buffer
+		s := &reservoirSampler{w: &out, size: 10, rng: rand.New(rand.NewSource(0))}
+
+		runSampler(t, s, sampleTestLines(1000))
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		assert.Len(t, lines, 10)
+		ordinals := make([]int, len(lines))
+		for i, line := range lines {
+			if _, err := fmt.Sscanf(line, "{\"resourceType\":\"Patient\",\"id\":\"%d\"}", &ordinals[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assert.IsIncreasing(t, ordinals)
+		selected, seen := s.counts()
+		assert.Equal(t, 10, selected)
+		assert.Equal(t, 1000, seen)
+	})
+
+	t.Run("Uniform", func(t *testing.T) {
+		// each of 10 lines should be selected in about half of the samples of size 5
+		selections := make(map[string]int)
+		rng := rand.New(rand.NewSource(0))
+		for i := 0; i < 2000; i++ {
+			var out bytes.Buffer
+			runSampler(t, &reservoirSampler{w: &out, size: 5, rng: rng}, sampleTestLines(10))
+			for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+				selections[line]++
+			}
+		}
+
+		assert.Len(t, selections, 10)
+		for line, n := range selections {
+			assert.InDelta(t, 1000, n, 150, line)
+		}
+	})
+}
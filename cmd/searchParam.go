@@ -0,0 +1,303 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// searchParameterSummary is the part of a SearchParameter listed by the
+// search-param list command.
+type searchParameterSummary struct {
+	Id         string   `json:"id"`
+	Url        string   `json:"url"`
+	Code       string   `json:"code"`
+	Base       []string `json:"base"`
+	Expression string   `json:"expression"`
+}
+
+// fetchSearchParameters fetches all SearchParameter resources of the server
+// following the next links of the search result pages.
+func fetchSearchParameters(client *fhir.Client) ([]searchParameterSummary, error) {
+	req, err := client.NewSearchTypeRequest("SearchParameter", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	var searchParameters []searchParameterSummary
+	for req != nil {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			errorResponse := util.NewErrorResponse(resp, body)
+			return nil, errors.New(errorResponse.String())
+		}
+
+		bundle, err := fm.UnmarshalBundle(body)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the search result: %w", err)
+		}
+		for _, entry := range bundle.Entry {
+			var searchParameter searchParameterSummary
+			if err := json.Unmarshal(entry.Resource, &searchParameter); err != nil {
+				return nil, fmt.Errorf("error while reading a SearchParameter: %w", err)
+			}
+			searchParameters = append(searchParameters, searchParameter)
+		}
+
+		nextPageURL, err := getNextPageURL(bundle.Link, req.URL)
+		if err != nil {
+			return nil, err
+		}
+		req = nil
+		if nextPageURL != nil {
+			if req, err = client.NewPaginatedRequest(nextPageURL); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return searchParameters, nil
+}
+
+// createSearchParameter creates the given SearchParameter. Returns the id
+// assigned by the server.
+func createSearchParameter(client *fhir.Client, searchParameter []byte) (string, error) {
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(searchParameter, &resource); err != nil {
+		return "", fmt.Errorf("error while reading the SearchParameter: %w", err)
+	}
+	if resource.ResourceType != "SearchParameter" {
+		return "", fmt.Errorf("expected resourceType `SearchParameter` but was `%s`", resource.ResourceType)
+	}
+
+	req, err := client.NewCreateRequest("SearchParameter", bytes.NewReader(searchParameter))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return "", errors.New(errorResponse.String())
+	}
+
+	var created searchParameterSummary
+	if err := json.Unmarshal(body, &created); err != nil {
+		return "", fmt.Errorf("error while reading the created SearchParameter: %w", err)
+	}
+	return created.Id, nil
+}
+
+// deleteSearchParameter deletes the SearchParameter with the given id.
+func deleteSearchParameter(client *fhir.Client, id string) error {
+	req, err := client.NewDeleteRequest("SearchParameter", id)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if isSuccessfulStatus(resp.StatusCode) {
+		return nil
+	}
+	errorResponse := util.ReadErrorResponse(resp)
+	return errors.New(errorResponse.String())
+}
+
+// reIndex creates a re-index job for the search parameter with the given
+// canonical URL and waits until it is finished, printing its progress.
+func reIndex(client *fhir.Client, searchParamUrl string) error {
+	j, err := createJob(client, newReIndexJob(searchParamUrl))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created re-index job %s.\n", j.Id)
+
+	j, err = waitForJob(client, j.Id, pollInterval, newPollTimeout(), func(j job) {
+		fmt.Printf("Job %s: %s\n", j.Id, j)
+	})
+	if err != nil {
+		return err
+	}
+	if j.Status != "completed" {
+		return fmt.Errorf("re-index job %s %s: %s", j.Id, j.Status, j.errorMessage())
+	}
+	return nil
+}
+
+func printErrorAndExit(err error) {
+	fmt.Print(strings.TrimSuffix(err.Error(), "\n") + "\n")
+	os.Exit(1)
+}
+
+// searchParamCmd represents the search-param command
+var searchParamCmd = &cobra.Command{
+	Use:   "search-param",
+	Short: "Manage custom SearchParameters",
+	Long: `Lists, creates and deletes SearchParameter resources and re-indexes
+existing resources after a SearchParameter was created.`,
+}
+
+var searchParamListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all SearchParameters",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := createClient(); err != nil {
+			return err
+		}
+
+		searchParameters, err := fetchSearchParameters(client)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tURL\tCODE\tBASE\tEXPRESSION")
+		for _, sp := range searchParameters {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", sp.Id, sp.Url, sp.Code, strings.Join(sp.Base, ","), sp.Expression)
+		}
+		return w.Flush()
+	},
+}
+
+var searchParamCreateCmd = &cobra.Command{
+	Use:   "create [file.json]",
+	Short: "Create a SearchParameter",
+	Long: `Creates the SearchParameter given in a JSON file. Existing resources
+aren't indexed for the new SearchParameter before running re-index.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one file argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := createClient(); err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		id, err := createSearchParameter(client, data)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		fmt.Printf("Created SearchParameter with id %s.\n", id)
+		return nil
+	},
+}
+
+var searchParamDeleteCmd = &cobra.Command{
+	Use:   "delete [id]",
+	Short: "Delete a SearchParameter",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one id argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := createClient(); err != nil {
+			return err
+		}
+
+		if err := deleteSearchParameter(client, args[0]); err != nil {
+			printErrorAndExit(err)
+		}
+		fmt.Printf("Deleted SearchParameter with id %s.\n", args[0])
+		return nil
+	},
+}
+
+var searchParamReIndexCmd = &cobra.Command{
+	Use:   "re-index [url]",
+	Short: "Re-index all resources for a SearchParameter",
+	Long: `Starts a re-index job on the server which indexes all existing resources
+for the SearchParameter with the given canonical URL and waits until the job is
+finished, printing its progress.
+
+Example:
+
+  blazectl search-param re-index --server http://localhost:8080/fhir \
+    http://example.com/fhir/SearchParameter/patient-nickname`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one SearchParameter URL argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := createClient(); err != nil {
+			return err
+		}
+
+		if err := reIndex(client, args[0]); err != nil {
+			printErrorAndExit(err)
+		}
+		fmt.Printf("Successfully re-indexed all resources for SearchParameter %s.\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(searchParamCmd)
+	searchParamCmd.AddCommand(searchParamListCmd, searchParamCreateCmd, searchParamDeleteCmd, searchParamReIndexCmd)
+
+	searchParamCmd.PersistentFlags().StringVar(&server, "server", "", "the base URL of the server to use")
+	searchParamReIndexCmd.Flags().DurationVar(&pollInterval, "poll-interval", 100*time.Millisecond, "initial wait between polls of the job status")
+	searchParamReIndexCmd.Flags().DurationVar(&pollTimeout, "poll-timeout", 0, "abort polling the job status after this duration (0 means no timeout)")
+
+	_ = searchParamCmd.MarkPersistentFlagRequired("server")
+}
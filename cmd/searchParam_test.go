@@ -0,0 +1,101 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestFetchSearchParameters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/SearchParameter", r.URL.Path)
+		if r.URL.Query().Get("__page") == "" {
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset",
+				"link": [{"relation": "next", "url": "SearchParameter?__page=2"}],
+				"entry": [{"resource": {"resourceType": "SearchParameter", "id": "0", "url": "http://example.com/a",
+				  "code": "a", "base": ["Patient", "Person"], "expression": "Patient.a"}}]}`))
+		} else {
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset",
+				"entry": [{"resource": {"resourceType": "SearchParameter", "id": "1", "url": "http://example.com/b",
+				  "code": "b", "base": ["Observation"], "expression": "Observation.b"}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	searchParameters, err := fetchSearchParameters(client)
+	if err != nil {
+		t.Fatalf("error while fetching SearchParameters: %v", err)
+	}
+
+	assert.Equal(t, []searchParameterSummary{
+		{Id: "0", Url: "http://example.com/a", Code: "a", Base: []string{"Patient", "Person"}, Expression: "Patient.a"},
+		{Id: "1", Url: "http://example.com/b", Code: "b", Base: []string{"Observation"}, Expression: "Observation.b"},
+	}, searchParameters)
+}
+
+func TestCreateSearchParameter(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "/SearchParameter", r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"resourceType": "SearchParameter", "id": "AAA"}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		id, err := createSearchParameter(client, []byte(`{"resourceType": "SearchParameter"}`))
+		if err != nil {
+			t.Fatalf("error while creating the SearchParameter: %v", err)
+		}
+
+		assert.Equal(t, "AAA", id)
+	})
+
+	t.Run("other resource type", func(t *testing.T) {
+		_, err := createSearchParameter(nil, []byte(`{"resourceType": "Patient"}`))
+
+		assert.EqualError(t, err, "expected resourceType `SearchParameter` but was `Patient`")
+	})
+}
+
+func TestDeleteSearchParameter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "DELETE", r.Method)
+		if r.URL.Path == "/SearchParameter/AAA" {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte("msg-180209"))
+		}
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	assert.NoError(t, deleteSearchParameter(client, "AAA"))
+	assert.ErrorContains(t, deleteSearchParameter(client, "BBB"), "msg-180209")
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"math"
+)
+
+var zstdOutput bool
+
+// seekableFrameSize is the size in bytes of the uncompressed content after
+// which a frame of a seekable zstd archive is completed at the next line end.
+const seekableFrameSize = 1 << 20
+
+// The magic numbers of the seek table of the zstd seekable format, see
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	seekTableSkippableMagic = 0x184D2A5E
+	seekTableFooterMagic    = 0x8F92EAB1
+)
+
+// seekableFrame is one independently compressed frame of a seekable zstd
+// archive. The offset is the position of the compressed frame in the archive.
+// The decompressed offset is the position of its content in the decompressed
+// stream.
+type seekableFrame struct {
+	offset             int64
+	compressedSize     int
+	decompressedOffset int64
+	decompressedSize   int
+}
+
+// seekableWriter compresses an NDJSON stream into a seekable zstd archive. The
+// stream is split into independently compressed frames, which end at line
+// ends, so that each resource can be decompressed from its frame alone.
+// Closing the writer appends the seek table listing all frames, which
+// standard zstd tools skip.
+type seekableWriter struct {
+	w                  io.Writer
+	encoder            *zstd.Encoder
+	frameSize          int
+	buf                []byte
+	compressed         []byte
+	frames             []seekableFrame
+	offset             int64
+	decompressedOffset int64
+	onFrame            func(frame seekableFrame) error
+}
+
+// newSeekableWriter creates a seekable zstd archive written to w with frames
+// of about frameSize uncompressed bytes. If onFrame is not nil, it's called
+// after each frame was written.
+func newSeekableWriter(w io.Writer, frameSize int, onFrame func(frame seekableFrame) error) (*seekableWriter, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &seekableWriter{w: w, encoder: encoder, frameSize: frameSize, onFrame: onFrame}, nil
+}
+
+// Write buffers p and writes a frame up to the last line end as soon as the
+// buffer holds at least the frame size. Lines longer than the frame size get
+// a frame of their own.
+func (s *seekableWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	if len(s.buf) >= s.frameSize {
+		if end := bytes.LastIndexByte(s.buf, '\n') + 1; end > 0 {
+			if err := s.writeFrame(s.buf[:end]); err != nil {
+				return 0, err
+			}
+			s.buf = s.buf[:copy(s.buf, s.buf[end:])]
+		}
+	}
+	return len(p), nil
+}
+
+func (s *seekableWriter) writeFrame(content []byte) error {
+	if len(content) > math.MaxUint32 {
+		return fmt.Errorf("a frame of %d bytes is too large for a seekable zstd archive", len(content))
+	}
+	s.compressed = s.encoder.EncodeAll(content, s.compressed[:0])
+	if len(s.compressed) > math.MaxUint32 {
+		return fmt.Errorf("a frame of %d compressed bytes is too large for a seekable zstd archive", len(s.compressed))
+	}
+	if _, err := s.w.Write(s.compressed); err != nil {
+		return err
+	}
+	frame := seekableFrame{offset: s.offset, compressedSize: len(s.compressed),
+		decompressedOffset: s.decompressedOffset, decompressedSize: len(content)}
+	s.frames = append(s.frames, frame)
+	s.offset += int64(frame.compressedSize)
+	s.decompressedOffset += int64(frame.decompressedSize)
+	if s.onFrame != nil {
+		return s.onFrame(frame)
+	}
+	return nil
+}
+
+// seekTable returns the seek table of frames as skippable frame without
+// checksums.
+func seekTable(frames []seekableFrame) []byte {
+	table := make([]byte, 0, 8+len(frames)*8+9)
+	table = binary.LittleEndian.AppendUint32(table, seekTableSkippableMagic)
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(frames)*8+9))
+	for _, frame := range frames {
+		table = binary.LittleEndian.AppendUint32(table, uint32(frame.compressedSize))
+		table = binary.LittleEndian.AppendUint32(table, uint32(frame.decompressedSize))
+	}
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(frames)))
+	table = append(table, 0)
+	return binary.LittleEndian.AppendUint32(table, seekTableFooterMagic)
+}
+
+// Close writes the remaining content as last frame followed by the seek
+// table. The underlying writer isn't closed.
+func (s *seekableWriter) Close() error {
+	defer s.encoder.Close()
+	if len(s.buf) > 0 {
+		if err := s.writeFrame(s.buf); err != nil {
+			return err
+		}
+		s.buf = s.buf[:0]
+	}
+	_, err := s.w.Write(seekTable(s.frames))
+	return err
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// readSeekTable reads the compressed and decompressed sizes of the frames
+// from the seek table at the end of archive.
+func readSeekTable(t *testing.T, archive []byte) [][2]uint32 {
+	footer := archive[len(archive)-9:]
+	assert.Equal(t, uint32(seekTableFooterMagic), binary.LittleEndian.Uint32(footer[5:]))
+	assert.Equal(t, byte(0), footer[4])
+	n := int(binary.LittleEndian.Uint32(footer))
+	table := archive[len(archive)-8-n*8-9:]
+	assert.Equal(t, uint32(seekTableSkippableMagic), binary.LittleEndian.Uint32(table))
+	assert.Equal(t, uint32(n*8+9), binary.LittleEndian.Uint32(table[4:]))
+	sizes := make([][2]uint32, n)
+	for i := range sizes {
+		entry := table[8+i*8:]
+		sizes[i] = [2]uint32{binary.LittleEndian.Uint32(entry), binary.LittleEndian.Uint32(entry[4:])}
+	}
+	return sizes
+}
+
+func decompress(t *testing.T, compressed []byte) string {
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	content, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		t.Fatalf("error while decompressing: %v", err)
+	}
+	return string(content)
+}
+
+func TestSeekTable(t *testing.T) {
+	table := seekTable([]seekableFrame{{compressedSize: 3, decompressedSize: 5}, {compressedSize: 7, decompressedSize: 11}})
+
+	assert.Equal(t, []byte{
+		0x5E, 0x2A, 0x4D, 0x18, 25, 0, 0, 0,
+		3, 0, 0, 0, 5, 0, 0, 0,
+		7, 0, 0, 0, 11, 0, 0, 0,
+		2, 0, 0, 0, 0, 0xB1, 0xEA, 0x92, 0x8F,
+	}, table)
+}
+
+func TestSeekableWriter(t *testing.T) {
+	t.Run("Frames", func(t *testing.T) {
+		var out bytes.Buffer
+		var frames []seekableFrame
+		w, err := newSeekableWriter(&out, 20, func(frame seekableFrame) error {
+			frames = append(frames, frame)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := "{\"id\":\"0\"}\n{\"id\":\"1\"}\n{\"id\":\"2\",\"active\":true}\n{\"id\":\"3\"}\n"
+
+		// frames end at the last line end of the buffered chunks
+		for _, chunk := range []string{content[:15], content[15:30], content[30:]} {
+			_, err := io.WriteString(w, chunk)
+			assert.NoError(t, err)
+		}
+
+		if assert.NoError(t, w.Close()) {
+			archive := out.Bytes()
+			assert.Equal(t, content, decompress(t, archive))
+			if assert.Len(t, frames, 2) {
+				assert.Equal(t, [][2]uint32{
+					{uint32(frames[0].compressedSize), 22},
+					{uint32(frames[1].compressedSize), 36},
+				}, readSeekTable(t, archive))
+				for _, frame := range frames {
+					compressed := archive[frame.offset : frame.offset+int64(frame.compressedSize)]
+					end := frame.decompressedOffset + int64(frame.decompressedSize)
+					assert.Equal(t, content[frame.decompressedOffset:end], decompress(t, compressed))
+				}
+			}
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		var out bytes.Buffer
+		w, err := newSeekableWriter(&out, 20, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assert.NoError(t, w.Close()) {
+			assert.Equal(t, "", decompress(t, out.Bytes()))
+			assert.Empty(t, readSeekTable(t, out.Bytes()))
+		}
+	})
+}
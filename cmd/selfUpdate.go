@@ -0,0 +1,153 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/update"
+	"github.com/spf13/cobra"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// updateNoticeEnv is the environment variable which enables the notice about
+// new versions if set to true.
+const updateNoticeEnv = "BLAZECTL_UPDATE_NOTICE"
+
+// updateCheckInterval is the minimum duration between two fetches of the
+// latest release for the update notice.
+const updateCheckInterval = 24 * time.Hour
+
+var selfUpdateCheckOnly bool
+
+// updateCheck is stored between invocations so that GitHub is asked for the
+// latest release only once per updateCheckInterval.
+type updateCheck struct {
+	Checked       time.Time `json:"checked"`
+	LatestVersion string    `json:"latestVersion"`
+}
+
+// updateNotice returns a notice if a version newer than current is available
+// and an empty string otherwise. The last check is cached in cacheFile. All
+// errors are ignored, because the notice should never disturb the command.
+func updateNotice(httpClient *http.Client, releaseURL string, current string, cacheFile string, now time.Time) string {
+	var check updateCheck
+	if content, err := os.ReadFile(cacheFile); err == nil {
+		_ = json.Unmarshal(content, &check)
+	}
+
+	if now.Sub(check.Checked) >= updateCheckInterval {
+		release, err := update.FetchLatestRelease(httpClient, releaseURL)
+		if err != nil {
+			return ""
+		}
+		check = updateCheck{Checked: now, LatestVersion: release.Version()}
+		if content, err := json.Marshal(check); err == nil {
+			_ = os.MkdirAll(filepath.Dir(cacheFile), 0755)
+			_ = os.WriteFile(cacheFile, content, 0644)
+		}
+	}
+
+	if check.LatestVersion != "" && update.IsNewer(current, check.LatestVersion) {
+		return fmt.Sprintf("A new version of blazectl is available: %s (current %s). Run `blazectl self-update` to install it.\n",
+			check.LatestVersion, current)
+	}
+	return ""
+}
+
+func printUpdateNotice() {
+	if os.Getenv(updateNoticeEnv) != "true" {
+		return
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return
+	}
+	httpClient := &http.Client{Timeout: 2 * time.Second}
+	notice := updateNotice(httpClient, update.LatestReleaseURL, rootCmd.Version,
+		filepath.Join(cacheDir, "blazectl", "update-check.json"), time.Now())
+	fmt.Fprint(os.Stderr, notice)
+}
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Updates blazectl to the latest version",
+	Long: `Checks GitHub for the latest release of blazectl. If it is newer than the
+running version, the release archive for the current platform is downloaded,
+its SHA-256 checksum is verified against the checksums published with the
+release and the running binary is replaced. The checksums have to be signed
+with the release key embedded into blazectl at build time, so that a
+tampered release is never installed.
+
+Set the environment variable BLAZECTL_UPDATE_NOTICE=true to get a notice on
+standard error if a new version is available. GitHub is asked at most once a
+day.
+
+Examples:
+  blazectl self-update
+  blazectl self-update --check`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		httpClient := &http.Client{Timeout: 5 * time.Minute}
+		release, err := update.FetchLatestRelease(httpClient, update.LatestReleaseURL)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		current := rootCmd.Version
+		if !update.IsNewer(current, release.Version()) {
+			fmt.Printf("blazectl %s is up-to-date.\n", current)
+			return nil
+		}
+		if selfUpdateCheckOnly {
+			fmt.Printf("A new version of blazectl is available: %s (current %s).\n", release.Version(), current)
+			return nil
+		}
+
+		publicKey, err := update.ParsePublicKey(update.PublicKey)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		executable, err = filepath.EvalSymlinks(executable)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Updating blazectl %s to %s ...\n", current, release.Version())
+		if err := update.Install(httpClient, release, publicKey, runtime.GOOS, runtime.GOARCH, executable); err != nil {
+			fmt.Printf("error while updating: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Installed blazectl %s at %s.\n", release.Version(), executable)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(selfUpdateCmd)
+
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCheckOnly, "check", false, "only check whether a new version is available")
+}
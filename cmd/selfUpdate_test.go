@@ -0,0 +1,62 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateNotice(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"tag_name": "v0.18.0"}`))
+	}))
+	defer server.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "blazectl", "update-check.json")
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("newer version available", func(t *testing.T) {
+		notice := updateNotice(server.Client(), server.URL, "0.17.0", cacheFile, now)
+		assert.Equal(t, "A new version of blazectl is available: 0.18.0 (current 0.17.0). Run `blazectl self-update` to install it.\n", notice)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("the latest release is cached", func(t *testing.T) {
+		notice := updateNotice(server.Client(), server.URL, "0.17.0", cacheFile, now.Add(time.Hour))
+		assert.Contains(t, notice, "0.18.0")
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("the cache expires", func(t *testing.T) {
+		updateNotice(server.Client(), server.URL, "0.17.0", cacheFile, now.Add(25*time.Hour))
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("up-to-date", func(t *testing.T) {
+		assert.Empty(t, updateNotice(server.Client(), server.URL, "0.18.0", cacheFile, now.Add(25*time.Hour)))
+	})
+
+	t.Run("errors are ignored", func(t *testing.T) {
+		otherCacheFile := filepath.Join(t.TempDir(), "update-check.json")
+		assert.Empty(t, updateNotice(server.Client(), "http://127.0.0.1:0", "0.17.0", otherCacheFile, now))
+	})
+}
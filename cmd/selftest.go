@@ -0,0 +1,368 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const selftestIdentifierSystem = "https://github.com/samply/blazectl/selftest"
+
+const selftestLibrary = `library Selftest version '1.0.0'
+using FHIR version '4.0.0'
+
+context Patient
+
+define InInitialPopulation:
+  true
+`
+
+// selftestState is shared between the steps of a self test. The ids of the
+// uploaded resources are set by the upload step.
+type selftestState struct {
+	client        *fhir.Client
+	identifier    string
+	patientId     string
+	observationId string
+}
+
+type selftestStep struct {
+	name string
+	run  func(state *selftestState) error
+}
+
+type selftestResult struct {
+	name     string
+	err      error
+	skipped  bool
+	duration time.Duration
+}
+
+var selftestSteps = []selftestStep{
+	{name: "upload", run: selftestUpload},
+	{name: "count", run: selftestCount},
+	{name: "search", run: selftestSearch},
+	{name: "download", run: selftestDownload},
+	{name: "evaluate-measure", run: selftestEvaluateMeasure},
+	{name: "delete", run: selftestDelete},
+}
+
+// runSelftest runs all steps in order. If the first step, which uploads the
+// test data, fails, all other steps are skipped.
+func runSelftest(state *selftestState, steps []selftestStep) []selftestResult {
+	results := make([]selftestResult, 0, len(steps))
+	for i, step := range steps {
+		if i > 0 && results[0].err != nil {
+			results = append(results, selftestResult{name: step.name, skipped: true})
+			continue
+		}
+		start := time.Now()
+		err := step.run(state)
+		results = append(results, selftestResult{name: step.name, err: err, duration: time.Since(start)})
+	}
+	return results
+}
+
+func fmtSelftestResults(units util.UnitFormat, results []selftestResult) string {
+	builder := strings.Builder{}
+	passed := 0
+	for _, result := range results {
+		switch {
+		case result.skipped:
+			builder.WriteString(fmt.Sprintf("%-18s SKIP\n", result.name))
+		case result.err != nil:
+			builder.WriteString(fmt.Sprintf("%-18s FAIL  %s\n", result.name, units.Duration(result.duration)))
+			builder.WriteString(util.Indent(4, strings.TrimSuffix(result.err.Error(), "\n")))
+			builder.WriteString("\n")
+		default:
+			passed++
+			builder.WriteString(fmt.Sprintf("%-18s PASS  %s\n", result.name, units.Duration(result.duration)))
+		}
+	}
+	builder.WriteString(fmt.Sprintf("\n%d of %d steps passed\n", passed, len(results)))
+	return builder.String()
+}
+
+func selftestBundle(identifier string) fm.Bundle {
+	patientFullUrl := "urn:uuid:" + uuid.NewString()
+	patient, _ := json.Marshal(fm.Patient{
+		Identifier: []fm.Identifier{{System: stringPtr(selftestIdentifierSystem), Value: &identifier}},
+	})
+	observation, _ := json.Marshal(fm.Observation{
+		Status:  fm.ObservationStatusFinal,
+		Code:    fm.CodeableConcept{Text: stringPtr("blazectl self test")},
+		Subject: &fm.Reference{Reference: &patientFullUrl},
+	})
+	return fm.Bundle{
+		Type: fm.BundleTypeTransaction,
+		Entry: []fm.BundleEntry{
+			{
+				FullUrl:  &patientFullUrl,
+				Resource: patient,
+				Request:  &fm.BundleEntryRequest{Method: fm.HTTPVerbPOST, Url: "Patient"},
+			},
+			{
+				Resource: observation,
+				Request:  &fm.BundleEntryRequest{Method: fm.HTTPVerbPOST, Url: "Observation"},
+			},
+		},
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// locationId returns the id of a location like Patient/0/_history/1.
+func locationId(location string) string {
+	segments := strings.Split(strings.Trim(location, "/"), "/")
+	for i, segment := range segments {
+		if segment == "_history" && i > 0 {
+			return segments[i-1]
+		}
+	}
+	return segments[len(segments)-1]
+}
+
+func selftestUpload(state *selftestState) error {
+	requestBody, err := json.Marshal(selftestBundle(state.identifier))
+	if err != nil {
+		return err
+	}
+	req, err := state.client.NewTransactionRequest(bytes.NewReader(requestBody))
+	if err != nil {
+		return err
+	}
+	resp, err := state.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.NewErrorResponse(resp, responseBody)
+		return errors.New(errorResponse.String())
+	}
+
+	mappings, err := readIdMappings(requestBody, responseBody)
+	if err != nil {
+		return err
+	}
+	for _, mapping := range mappings {
+		if mapping.location == "" {
+			return fmt.Errorf("missing location of entry %d in the transaction response", mapping.entryIndex)
+		}
+	}
+	state.patientId = locationId(mappings[0].location)
+	state.observationId = locationId(mappings[1].location)
+	return nil
+}
+
+func selftestCount(state *selftestState) error {
+	counts, err := fetchResourcesTotal(state.client, []fm.ResourceType{fm.ResourceTypePatient})
+	if err != nil {
+		return err
+	}
+	if counts[fm.ResourceTypePatient] < 1 {
+		return fmt.Errorf("expected at least one Patient but counted %d", counts[fm.ResourceTypePatient])
+	}
+	return nil
+}
+
+func selftestSearch(state *selftestState) error {
+	query := url.Values{
+		"identifier": []string{selftestIdentifierSystem + "|" + state.identifier},
+		"_summary":   []string{"count"},
+	}
+	req, err := state.client.NewSearchTypeRequest("Patient", query)
+	if err != nil {
+		return err
+	}
+	resp, err := state.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return errors.New(errorResponse.String())
+	}
+	bundle, err := fhir.ReadBundle(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bundle.Total == nil || *bundle.Total != 1 {
+		total := 0
+		if bundle.Total != nil {
+			total = *bundle.Total
+		}
+		return fmt.Errorf("expected to find one Patient with the test identifier but found %d", total)
+	}
+	return nil
+}
+
+func selftestDownload(state *selftestState) error {
+	bundleChannel := make(chan downloadBundle, 2)
+	go downloadResources(state.client, "Observation", "subject=Patient/"+state.patientId, false, bundleChannel)
+	defer func() {
+		for range bundleChannel {
+		}
+	}()
+
+	resources := 0
+	for bundle := range bundleChannel {
+		if bundle.errResponse != nil {
+			return errors.New(bundle.errResponse.String())
+		}
+		if bundle.err != nil {
+			return bundle.err
+		}
+		n, _, err := writeResources(&bundle.rawEntries, io.Discard)
+		if err != nil {
+			return err
+		}
+		resources += n
+	}
+	if resources != 1 {
+		return fmt.Errorf("expected to download one Observation of the test Patient but downloaded %d", resources)
+	}
+	return nil
+}
+
+func selftestEvaluateMeasure(state *selftestState) error {
+	dir, err := os.MkdirTemp("", "blazectl-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	libraryFilename := filepath.Join(dir, "selftest.cql")
+	if err := os.WriteFile(libraryFilename, []byte(selftestLibrary), 0644); err != nil {
+		return err
+	}
+
+	measureUrl, err := createMeasure(state.client, createCqlMeasure(libraryFilename, "InInitialPopulation"))
+	if err != nil {
+		return err
+	}
+	measureReport, err := evaluateMeasureWithRetry(state.client, measureUrl)
+	if err != nil {
+		return err
+	}
+	count, _, err := readPopulation(measureReport)
+	if err != nil {
+		return err
+	}
+	if count < 1 {
+		return fmt.Errorf("expected at least one Patient in the initial population but was %d", count)
+	}
+	return nil
+}
+
+func selftestDelete(state *selftestState) error {
+	for _, resource := range [][2]string{{"Observation", state.observationId}, {"Patient", state.patientId}} {
+		req, err := state.client.NewDeleteRequest(resource[0], resource[1])
+		if err != nil {
+			return err
+		}
+		resp, err := state.client.Do(req)
+		if err != nil {
+			return err
+		}
+		if !isSuccessfulStatus(resp.StatusCode) {
+			errorResponse := util.ReadErrorResponse(resp)
+			resp.Body.Close()
+			return fmt.Errorf("error while deleting %s/%s:\n\n%s", resource[0], resource[1], errorResponse.String())
+		}
+		resp.Body.Close()
+	}
+
+	req, err := state.client.NewReadRequest("Patient", state.patientId)
+	if err != nil {
+		return err
+	}
+	resp, err := state.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusGone && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("expected the deleted Patient/%s to be gone but the status was %s", state.patientId, resp.Status)
+	}
+	return nil
+}
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Runs an end-to-end test against a server",
+	Long: `Runs a scripted end-to-end scenario against a server and reports pass or
+fail for each step. A Patient with an Observation is uploaded, counted,
+searched, downloaded and evaluated by a trivial measure. Finally both
+resources are deleted again.
+
+The self test is meant for smoke testing new server deployments. It creates
+Measure and Library resources which are not deleted afterwards.
+
+Examples:
+  blazectl selftest --server "http://localhost:8080/fhir"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Running self test against %s ...\n\n", server)
+
+		state := &selftestState{client: client, identifier: uuid.NewString()}
+		results := runSelftest(state, selftestSteps)
+		fmt.Print(fmtSelftestResults(unitFormat(), results))
+
+		for _, result := range results {
+			if result.err != nil || result.skipped {
+				os.Exit(1)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(selftestCmd)
+
+	selftestCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+
+	_ = selftestCmd.MarkFlagRequired("server")
+}
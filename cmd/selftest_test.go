@@ -0,0 +1,92 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRunSelftest(t *testing.T) {
+	pass := func(state *selftestState) error { return nil }
+	fail := func(state *selftestState) error { return errors.New("error-101512") }
+
+	t.Run("failing step", func(t *testing.T) {
+		results := runSelftest(&selftestState{}, []selftestStep{{"a", pass}, {"b", fail}, {"c", pass}})
+
+		assert.NoError(t, results[0].err)
+		assert.EqualError(t, results[1].err, "error-101512")
+		assert.NoError(t, results[2].err)
+		assert.False(t, results[2].skipped)
+	})
+
+	t.Run("failing first step", func(t *testing.T) {
+		results := runSelftest(&selftestState{}, []selftestStep{{"a", fail}, {"b", pass}})
+
+		assert.Error(t, results[0].err)
+		assert.True(t, results[1].skipped)
+
+		assert.Equal(t, "a                  FAIL  0s\n    error-101512\nb                  SKIP\n\n0 of 2 steps passed\n",
+			fmtSelftestResults(util.UnitFormat{}, results))
+	})
+}
+
+func TestLocationId(t *testing.T) {
+	assert.Equal(t, "0", locationId("Patient/0/_history/1"))
+	assert.Equal(t, "0", locationId("http://localhost:8080/fhir/Patient/0/_history/1"))
+	assert.Equal(t, "0", locationId("Patient/0"))
+}
+
+func TestSelftestUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/fhir+json")
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "transaction-response", "entry": [
+  {"response": {"status": "201", "location": "Patient/DC4A/_history/1"}},
+  {"response": {"status": "201", "location": "Observation/DC4B/_history/1"}}
+]}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	state := &selftestState{client: fhir.NewClient(*baseURL, nil), identifier: "id-101933"}
+
+	if assert.NoError(t, selftestUpload(state)) {
+		assert.Equal(t, "DC4A", state.patientId)
+		assert.Equal(t, "DC4B", state.observationId)
+	}
+}
+
+func TestSelftestSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/Patient", r.URL.Path)
+		assert.Equal(t, selftestIdentifierSystem+"|id-102211", r.URL.Query().Get("identifier"))
+		w.Header().Set("Content-Type", "application/fhir+json")
+		_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "searchset", "total": 0}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	state := &selftestState{client: fhir.NewClient(*baseURL, nil), identifier: "id-102211"}
+
+	assert.EqualError(t, selftestSearch(state), "expected to find one Patient with the test identifier but found 0")
+}
@@ -0,0 +1,276 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var sortBy string
+var sortBuffer int64
+
+// sortByValues are the keys the output can be sorted by with --sort.
+var sortByValues = []string{"lastUpdated", "id"}
+
+func checkSortBy(key string) error {
+	for _, value := range sortByValues {
+		if key == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --sort value `%s`, expected one of %s", key, strings.Join(sortByValues, ", "))
+}
+
+// sortKey returns the key of an NDJSON line sorting by type and id or by the
+// last update in UTC, followed by type and id. The key never contains a tab.
+func sortKey(by string, line []byte) (string, error) {
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+		Id           string `json:"id"`
+		Meta         struct {
+			LastUpdated string `json:"lastUpdated"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(line, &resource); err != nil {
+		return "", fmt.Errorf("could not read resource: %v", err)
+	}
+	key := resource.ResourceType + "/" + resource.Id
+	if by == "lastUpdated" {
+		lastUpdated, err := time.Parse(time.RFC3339Nano, resource.Meta.LastUpdated)
+		if err != nil {
+			return "", fmt.Errorf("could not read the last update of %s: %v", key, err)
+		}
+		key = lastUpdated.UTC().Format("2006-01-02T15:04:05.000000000Z") + " " + key
+	}
+	return strings.ReplaceAll(key, "\t", " "), nil
+}
+
+// sortLine is an NDJSON line together with its sort key.
+type sortLine struct {
+	key  string
+	line []byte
+}
+
+// writeRun sorts lines and writes them into a new temporary file in dir, each
+// line prefixed by its key and a tab.
+func writeRun(dir string, lines []sortLine) (string, error) {
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].key < lines[j].key })
+	file, err := os.CreateTemp(dir, "run-*")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(file)
+	for _, l := range lines {
+		w.WriteString(l.key)
+		w.WriteByte('\t')
+		w.Write(l.line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+// readLine reads the next line of r without the trailing newline. Returns
+// io.EOF at the end.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return line, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return line[:len(line)-1], nil
+}
+
+// splitRuns reads the NDJSON lines of r and writes them as sorted runs of at
+// most bufferSize bytes into temporary files in dir.
+func splitRuns(r io.Reader, dir string, by string, bufferSize int64) ([]string, error) {
+	reader := bufio.NewReader(r)
+	var runs []string
+	var lines []sortLine
+	var size int64
+	for {
+		line, err := readLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return runs, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		key, err := sortKey(by, line)
+		if err != nil {
+			return runs, err
+		}
+		lines = append(lines, sortLine{key: key, line: line})
+		size += int64(len(line) + len(key))
+		if size >= bufferSize {
+			run, err := writeRun(dir, lines)
+			if err != nil {
+				return runs, err
+			}
+			runs = append(runs, run)
+			lines, size = nil, 0
+		}
+	}
+	if len(lines) > 0 {
+		run, err := writeRun(dir, lines)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// runReader is the current line of a run during the merge.
+type runReader struct {
+	reader  *bufio.Reader
+	key     string
+	line    []byte
+	ordinal int
+}
+
+// next reads the next line of the run. Returns io.EOF at the end.
+func (r *runReader) next() error {
+	line, err := readLine(r.reader)
+	if err != nil {
+		return err
+	}
+	key, resource, _ := bytes.Cut(line, []byte{'\t'})
+	r.key, r.line = string(key), resource
+	return nil
+}
+
+// runHeap orders the runs by the key of their current line. Equal keys are
+// taken from the earlier run first, so that the sort is stable.
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].ordinal < h[j].ordinal
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the sorted runs into w. With keys, each line is prefixed
+// by its key and a tab like in the runs.
+func mergeRuns(runs []string, w io.Writer, withKeys bool) error {
+	h := make(runHeap, 0, len(runs))
+	for i, run := range runs {
+		file, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r := &runReader{reader: bufio.NewReader(file), ordinal: i}
+		if err := r.next(); err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		h = append(h, r)
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		r := h[0]
+		if withKeys {
+			if _, err := io.WriteString(w, r.key+"\t"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(r.line); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+		if err := r.next(); err == io.EOF {
+			heap.Pop(&h)
+		} else if err != nil {
+			return err
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
+
+// sortFile sorts the NDJSON file at path by the given key with an external
+// merge sort, using at most about bufferSize bytes of memory for the lines.
+// The sorted file replaces the file at path. Returns the hashing writer the
+// sorted content was written through.
+func sortFile(path string, by string, bufferSize int64) (*hashingWriter, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".blazectl-sort-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := splitRuns(input, dir, by, bufferSize)
+	input.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error while sorting %s: %w", path, err)
+	}
+
+	output, err := os.Create(filepath.Join(dir, "sorted"))
+	if err != nil {
+		return nil, err
+	}
+	hash := newHashingWriter(output)
+	buf := bufio.NewWriter(hash)
+	if err := mergeRuns(runs, buf, false); err != nil {
+		output.Close()
+		return nil, fmt.Errorf("error while sorting %s: %w", path, err)
+	}
+	if err := buf.Flush(); err != nil {
+		output.Close()
+		return nil, err
+	}
+	if err := (syncedFile{output}).Close(); err != nil {
+		return nil, err
+	}
+	return hash, os.Rename(output.Name(), path)
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckSortBy(t *testing.T) {
+	assert.NoError(t, checkSortBy("lastUpdated"))
+	assert.NoError(t, checkSortBy("id"))
+	assert.EqualError(t, checkSortBy("name"), "invalid --sort value `name`, expected one of lastUpdated, id")
+}
+
+func TestSortKey(t *testing.T) {
+	t.Run("id", func(t *testing.T) {
+		key, err := sortKey("id", []byte(`{"resourceType":"Patient","id":"0"}`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Patient/0", key)
+		}
+	})
+
+	t.Run("lastUpdated", func(t *testing.T) {
+		key, err := sortKey("lastUpdated", []byte(`{"resourceType":"Patient","id":"0","meta":{"lastUpdated":"2024-01-02T03:04:05.1+01:00"}}`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "2024-01-02T02:04:05.100000000Z Patient/0", key)
+		}
+	})
+
+	t.Run("missing lastUpdated", func(t *testing.T) {
+		_, err := sortKey("lastUpdated", []byte(`{"resourceType":"Patient","id":"0"}`))
+
+		assert.ErrorContains(t, err, "could not read the last update of Patient/0")
+	})
+}
+
+func TestSortFile(t *testing.T) {
+	resources := []string{
+		`{"resourceType":"Patient","id":"2","meta":{"lastUpdated":"2024-01-01T00:00:00Z"}}`,
+		`{"resourceType":"Observation","id":"1","meta":{"lastUpdated":"2024-01-03T00:00:00Z"}}`,
+		`{"resourceType":"Patient","id":"10","meta":{"lastUpdated":"2024-01-02T00:00:00+01:00"}}`,
+		`{"resourceType":"Patient","id":"1","meta":{"lastUpdated":"2024-01-02T00:00:00Z"}}`,
+	}
+
+	for _, test := range []struct {
+		by       string
+		expected []int
+	}{
+		{"id", []int{1, 3, 2, 0}},
+		{"lastUpdated", []int{0, 2, 3, 1}},
+	} {
+		t.Run(test.by, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "export.ndjson")
+			var content string
+			for _, resource := range resources {
+				content += resource + "\n"
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal("can't create a temp file")
+			}
+
+			// a tiny buffer creates a run per resource
+			hash, err := sortFile(path, test.by, 1)
+
+			if assert.NoError(t, err) {
+				var expected string
+				for _, i := range test.expected {
+					expected += resources[i] + "\n"
+				}
+				assert.Equal(t, expected, readFile(t, path))
+				sum := sha256.Sum256([]byte(expected))
+				assert.Equal(t, hex.EncodeToString(sum[:]), hash.sum())
+				entries, _ := os.ReadDir(filepath.Dir(path))
+				assert.Len(t, entries, 1)
+			}
+		})
+	}
+}
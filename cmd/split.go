@@ -0,0 +1,163 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+var splitByType bool
+var splitOutputDir string
+
+// resourceTypePattern matches valid resource types, which are used as file
+// names, so that no resource can write outside of the output directory.
+var resourceTypePattern = regexp.MustCompile(`^[A-Z][A-Za-z]+$`)
+
+// typeSplitter writes resources into one NDJSON file per resource type, named
+// after the type, in dir. The files are created when the first resource of
+// their type is written and must not exist before.
+type typeSplitter struct {
+	dir    string
+	files  map[string]*outputPart
+	counts map[string]int
+}
+
+func newTypeSplitter(dir string) *typeSplitter {
+	return &typeSplitter{dir: dir, files: make(map[string]*outputPart), counts: make(map[string]int)}
+}
+
+// lineResourceType returns the type of the resource in line. Fails if the type
+// isn't a valid resource type.
+func lineResourceType(line []byte) (string, error) {
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(line, &resource); err != nil {
+		return "", fmt.Errorf("could not read resource: %v", err)
+	}
+	if resource.ResourceType == "" {
+		return "", fmt.Errorf("missing resourceType")
+	}
+	if !resourceTypePattern.MatchString(resource.ResourceType) {
+		return "", fmt.Errorf("invalid resourceType `%s`", resource.ResourceType)
+	}
+	return resource.ResourceType, nil
+}
+
+func (s *typeSplitter) write(line []byte) error {
+	resourceType, err := lineResourceType(line)
+	if err != nil {
+		return err
+	}
+	part, ok := s.files[resourceType]
+	if !ok {
+		path := filepath.Join(s.dir, resourceType+".ndjson")
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if os.IsExist(err) {
+				return fmt.Errorf("the output file %s does already exist", path)
+			}
+			return err
+		}
+		part = &outputPart{path: path, out: syncedFile{file}, buf: bufio.NewWriter(file)}
+		s.files[resourceType] = part
+	}
+	s.counts[resourceType]++
+	return writeNDJSONLine(part.buf, line)
+}
+
+// Close closes all files. Returns the first error.
+func (s *typeSplitter) Close() error {
+	var firstErr error
+	for _, part := range s.files {
+		if err := part.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// String returns the number of resources written per file ordered by type.
+func (s *typeSplitter) String() string {
+	types := make([]string, 0, len(s.counts))
+	maxLen := 0
+	for resourceType := range s.counts {
+		types = append(types, resourceType)
+		maxLen = maxInt(maxLen, len(s.files[resourceType].path))
+	}
+	sort.Strings(types)
+	var result string
+	for _, resourceType := range types {
+		result += fmt.Sprintf("%-*s : %d\n", maxLen, s.files[resourceType].path, s.counts[resourceType])
+	}
+	return result
+}
+
+var splitCmd = &cobra.Command{
+	Use:   "split --by-type [file.ndjson]...",
+	Short: "Split NDJSON files by resource type",
+	Long: `Splits the resources of the given NDJSON files, like the output of download,
+or of stdin if no files are given, by their type into one NDJSON file per
+type. The files are named after the type, like Patient.ndjson, and written
+into --output-dir, which defaults to the current directory. Existing files
+are never overwritten. Resources with an invalid resourceType, which could
+write outside of --output-dir, are rejected.
+
+The resources are streamed, so that files larger than the memory can be
+split. At the end, the number of resources written into each file is
+printed.
+
+Example:
+  blazectl download --query "_lastUpdated=gt2024-01-01" | blazectl split --by-type -d out`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !splitByType {
+			return fmt.Errorf("the flag --by-type is required, because splitting by type is the only way to split")
+		}
+		if err := os.MkdirAll(splitOutputDir, 0755); err != nil {
+			return err
+		}
+		splitter := newTypeSplitter(splitOutputDir)
+		err := forEachNDJSONLine(args, os.Stdin, func(name string, number int, line []byte) error {
+			if err := splitter.write(line); err != nil {
+				return fmt.Errorf("error in %s [Line: %d]: %w", name, number, err)
+			}
+			return nil
+		})
+		if closeErr := splitter.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Print(splitter)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(splitCmd)
+
+	splitCmd.Flags().BoolVar(&splitByType, "by-type", false, "write the resources of each type into their own file")
+	splitCmd.Flags().StringVarP(&splitOutputDir, "output-dir", "d", ".", "directory to write the files to")
+
+	_ = splitCmd.MarkFlagRequired("by-type")
+}
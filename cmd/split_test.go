@@ -0,0 +1,126 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLineResourceType(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		resourceType, err := lineResourceType([]byte(`{"resourceType": "Patient", "id": "0"}`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Patient", resourceType)
+	})
+
+	t.Run("MissingResourceType", func(t *testing.T) {
+		_, err := lineResourceType([]byte(`{"id": "0"}`))
+
+		assert.EqualError(t, err, "missing resourceType")
+	})
+
+	t.Run("InvalidJson", func(t *testing.T) {
+		_, err := lineResourceType([]byte(`{`))
+
+		assert.EqualError(t, err, "could not read resource: unexpected end of JSON input")
+	})
+
+	t.Run("InvalidResourceType", func(t *testing.T) {
+		for _, resourceType := range []string{"../Patient", "/tmp/Patient", "Patient/..", "patient", "P", "Pa tient"} {
+			_, err := lineResourceType([]byte(`{"resourceType": "` + resourceType + `"}`))
+
+			assert.EqualError(t, err, "invalid resourceType `"+resourceType+"`", resourceType)
+		}
+	})
+}
+
+func TestTypeSplitter(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		dir := t.TempDir()
+		splitter := newTypeSplitter(dir)
+
+		assert.NoError(t, splitter.write([]byte(`{"resourceType":"Patient","id":"0"}`)))
+		assert.NoError(t, splitter.write([]byte(`{"resourceType":"Observation","id":"0"}`)))
+		assert.NoError(t, splitter.write([]byte(`{"resourceType":"Patient","id":"1"}`)))
+		assert.NoError(t, splitter.Close())
+
+		patients, err := os.ReadFile(filepath.Join(dir, "Patient.ndjson"))
+		assert.NoError(t, err)
+		assert.Equal(t, "{\"resourceType\":\"Patient\",\"id\":\"0\"}\n{\"resourceType\":\"Patient\",\"id\":\"1\"}\n", string(patients))
+		observations, err := os.ReadFile(filepath.Join(dir, "Observation.ndjson"))
+		assert.NoError(t, err)
+		assert.Equal(t, "{\"resourceType\":\"Observation\",\"id\":\"0\"}\n", string(observations))
+
+		observationPath := filepath.Join(dir, "Observation.ndjson")
+		patientPath := filepath.Join(dir, "Patient.ndjson")
+		assert.Equal(t, observationPath+" : 1\n"+patientPath+"     : 2\n", splitter.String())
+	})
+
+	t.Run("ExistingFile", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "Patient.ndjson")
+		if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		splitter := newTypeSplitter(dir)
+
+		err := splitter.write([]byte(`{"resourceType":"Patient","id":"0"}`))
+
+		assert.EqualError(t, err, "the output file "+path+" does already exist")
+		assert.NoError(t, splitter.Close())
+		content, _ := os.ReadFile(path)
+		assert.Equal(t, "{}\n", string(content))
+	})
+
+	t.Run("InvalidResource", func(t *testing.T) {
+		splitter := newTypeSplitter(t.TempDir())
+
+		err := splitter.write([]byte(`{"id":"0"}`))
+
+		assert.EqualError(t, err, "missing resourceType")
+		assert.NoError(t, splitter.Close())
+	})
+
+	t.Run("PathTraversal", func(t *testing.T) {
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "out")
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		splitter := newTypeSplitter(dir)
+
+		err := splitter.write([]byte(`{"resourceType":"../Patient","id":"0"}`))
+
+		assert.EqualError(t, err, "invalid resourceType `../Patient`")
+		assert.NoError(t, splitter.Close())
+		_, err = os.Stat(filepath.Join(parent, "Patient.ndjson"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestSplitCmd(t *testing.T) {
+	t.Run("ByTypeFalse", func(t *testing.T) {
+		splitByType = false
+		splitOutputDir = t.TempDir()
+
+		err := splitCmd.RunE(splitCmd, nil)
+
+		assert.EqualError(t, err, "the flag --by-type is required, because splitting by type is the only way to split")
+	})
+}
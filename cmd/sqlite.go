@@ -0,0 +1,194 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var outputSqlite string
+
+// sqliteBatchSize is the number of rows inserted in one transaction.
+const sqliteBatchSize = 10000
+
+const sqliteSchema = `CREATE TABLE resources (
+  id TEXT NOT NULL,
+  type TEXT NOT NULL,
+  last_updated TEXT,
+  resource TEXT NOT NULL,
+  PRIMARY KEY (type, id)
+);
+`
+
+const sqliteIndexes = `CREATE INDEX resources_last_updated ON resources (last_updated);
+`
+
+// sqliteWriter writes an NDJSON stream as rows of the table resources into a
+// new SQLite database. The rows are inserted by the sqlite3 command line
+// shell, which has to be installed.
+type sqliteWriter struct {
+	input  *commandInput
+	buf    *bufio.Writer
+	line   []byte
+	rows   int
+	inTx   bool
+	closed bool
+}
+
+// newSqliteWriter starts sqlite3 on the new database at path and creates the
+// schema.
+func newSqliteWriter(path string) (*sqliteWriter, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("the output file %s does already exist", path)
+	}
+	input, err := startInput(exec.Command("sqlite3", "-bail", path), "sqlite3")
+	if err != nil {
+		return nil, err
+	}
+	w := &sqliteWriter{input: input, buf: bufio.NewWriter(input)}
+	if _, err := w.buf.WriteString(sqliteSchema); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// sqliteString returns s as SQL string literal.
+func sqliteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// resourceRow holds the columns of a resource in database outputs.
+type resourceRow struct {
+	id           string
+	resourceType string
+	lastUpdated  string
+}
+
+func readResourceRow(resource []byte) (resourceRow, error) {
+	var r struct {
+		ResourceType string `json:"resourceType"`
+		Id           string `json:"id"`
+		Meta         struct {
+			LastUpdated string `json:"lastUpdated"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(resource, &r); err != nil {
+		return resourceRow{}, fmt.Errorf("could not read resource: %v", err)
+	}
+	return resourceRow{id: r.Id, resourceType: r.ResourceType, lastUpdated: r.Meta.LastUpdated}, nil
+}
+
+// insertStatement returns the statement inserting resource.
+func insertStatement(resource []byte) (string, error) {
+	row, err := readResourceRow(resource)
+	if err != nil {
+		return "", err
+	}
+	lastUpdated := "NULL"
+	if row.lastUpdated != "" {
+		lastUpdated = sqliteString(row.lastUpdated)
+	}
+	return fmt.Sprintf("INSERT OR REPLACE INTO resources VALUES (%s, %s, %s, %s);\n",
+		sqliteString(row.id), sqliteString(row.resourceType), lastUpdated, sqliteString(string(resource))), nil
+}
+
+// writeLines appends p to the pending bytes in line and calls f with each
+// complete, non-empty line.
+func writeLines(line *[]byte, p []byte, f func([]byte) error) (int, error) {
+	*line = append(*line, p...)
+	for {
+		i := bytes.IndexByte(*line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if i > 0 {
+			if err := f((*line)[:i]); err != nil {
+				return 0, err
+			}
+		}
+		*line = (*line)[i+1:]
+	}
+}
+
+// Write inserts a row for each complete line of the NDJSON stream.
+func (w *sqliteWriter) Write(p []byte) (int, error) {
+	return writeLines(&w.line, p, w.insert)
+}
+
+func (w *sqliteWriter) insert(resource []byte) error {
+	statement, err := insertStatement(resource)
+	if err != nil {
+		return err
+	}
+	if !w.inTx {
+		if _, err := w.buf.WriteString("BEGIN;\n"); err != nil {
+			return err
+		}
+		w.inTx = true
+	}
+	if _, err := w.buf.WriteString(statement); err != nil {
+		return err
+	}
+	w.rows++
+	if w.rows%sqliteBatchSize == 0 {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *sqliteWriter) commit() error {
+	if !w.inTx {
+		return nil
+	}
+	w.inTx = false
+	_, err := w.buf.WriteString("COMMIT;\n")
+	return err
+}
+
+// Flush commits the rows inserted so far and passes them to sqlite3.
+func (w *sqliteWriter) Flush() error {
+	if err := w.commit(); err != nil {
+		return err
+	}
+	return w.buf.Flush()
+}
+
+// Close commits the remaining rows, creates the indexes and waits for sqlite3
+// to exit. Closing twice has no effect.
+func (w *sqliteWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.Flush(); err != nil {
+		w.input.Close()
+		return err
+	}
+	if _, err := w.buf.WriteString(sqliteIndexes); err != nil {
+		w.input.Close()
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.input.Close()
+		return err
+	}
+	return w.input.Close()
+}
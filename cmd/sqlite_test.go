@@ -0,0 +1,74 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteString(t *testing.T) {
+	assert.Equal(t, "'foo'", sqliteString("foo"))
+	assert.Equal(t, "'O''Brien'", sqliteString("O'Brien"))
+}
+
+func TestInsertStatement(t *testing.T) {
+	t.Run("LastUpdated", func(t *testing.T) {
+		statement, err := insertStatement([]byte(`{"resourceType":"Patient","id":"0","meta":{"lastUpdated":"2024-01-02T03:04:05Z"}}`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `INSERT OR REPLACE INTO resources VALUES ('0', 'Patient', '2024-01-02T03:04:05Z', '{"resourceType":"Patient","id":"0","meta":{"lastUpdated":"2024-01-02T03:04:05Z"}}');`+"\n", statement)
+		}
+	})
+
+	t.Run("WithoutLastUpdated", func(t *testing.T) {
+		statement, err := insertStatement([]byte(`{"resourceType":"Patient","id":"0","name":[{"family":"O'Brien"}]}`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `INSERT OR REPLACE INTO resources VALUES ('0', 'Patient', NULL, '{"resourceType":"Patient","id":"0","name":[{"family":"O''Brien"}]}');`+"\n", statement)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := insertStatement([]byte("foo"))
+
+		assert.ErrorContains(t, err, "could not read resource")
+	})
+}
+
+func TestSqliteWriter(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 isn't installed")
+	}
+
+	path := filepath.Join(t.TempDir(), "export.db")
+	w, err := newSqliteWriter(path)
+	if err != nil {
+		t.Fatalf("error while starting sqlite3: %v", err)
+	}
+
+	_, _ = w.Write([]byte("{\"resourceType\":\"Patient\",\"id\":\"0\"}\n{\"resourceType\":\"Patient\",\"id\":\"1\"}\n"))
+
+	if assert.NoError(t, w.Close()) {
+		out, err := exec.Command("sqlite3", path, "SELECT type || '/' || id FROM resources ORDER BY id").Output()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Patient/0\nPatient/1\n", string(out))
+		}
+		_, err = newSqliteWriter(path)
+		assert.EqualError(t, err, "the output file "+path+" does already exist")
+	}
+}
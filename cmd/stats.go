@@ -0,0 +1,173 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/spf13/cobra"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// databaseStats are the statistics of a database returned by the admin API of
+// Blaze under __admin/dbs.
+type databaseStats struct {
+	Name                 string `json:"name"`
+	EstimateLiveDataSize int64  `json:"estimateLiveDataSize"`
+	UsableSpace          int64  `json:"usableSpace"`
+	ColumnFamilies       []columnFamilyStats
+}
+
+// columnFamilyStats are the statistics of a column family returned by the
+// admin API of Blaze under __admin/dbs/{database}/column-families.
+type columnFamilyStats struct {
+	Name                 string `json:"name"`
+	EstimateNumKeys      int64  `json:"estimateNumKeys"`
+	EstimateLiveDataSize int64  `json:"estimateLiveDataSize"`
+	LiveSstFilesSize     int64  `json:"liveSstFilesSize"`
+}
+
+// size returns the on-disk size of the database which is the sum of the
+// sizes of the SST files of all column families.
+func (db databaseStats) size() int64 {
+	var size int64
+	for _, columnFamily := range db.ColumnFamilies {
+		size += columnFamily.LiveSstFilesSize
+	}
+	return size
+}
+
+// fetchAdminJSON fetches the JSON document at the path below the admin API of
+// the server and unmarshals it into v.
+func fetchAdminJSON(client *fhir.Client, v any, path ...string) error {
+	baseURL := client.Admin().BaseURL()
+	req, err := http.NewRequest("GET", baseURL.JoinPath(path...).String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.ReadErrorResponse(resp)
+		return fmt.Errorf("error while fetching %s:\n\n%s", req.URL, errorResponse.String())
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error while reading %s: %v", req.URL, err)
+	}
+	return nil
+}
+
+// fetchDatabaseStats fetches the statistics of all databases and their column
+// families from the admin API of Blaze.
+func fetchDatabaseStats(client *fhir.Client) ([]databaseStats, error) {
+	var dbs []databaseStats
+	if err := fetchAdminJSON(client, &dbs, "dbs"); err != nil {
+		return nil, err
+	}
+	for i := range dbs {
+		if err := fetchAdminJSON(client, &dbs[i].ColumnFamilies, "dbs", dbs[i].Name, "column-families"); err != nil {
+			return nil, err
+		}
+	}
+	return dbs, nil
+}
+
+// fmtDatabaseStats formats the databases and their column families together
+// with their number of keys and on-disk size. Like du, the largest databases
+// and column families come first.
+func fmtDatabaseStats(units util.UnitFormat, dbs []databaseStats) string {
+	sorted := make([]databaseStats, len(dbs))
+	copy(sorted, dbs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].size() > sorted[j].size() })
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%-40s %15s %12s\n", "Database / Column Family", "Keys", "Size"))
+	var total int64
+	for _, db := range sorted {
+		builder.WriteString(fmt.Sprintf("%-40s %15s %12s\n", db.Name, "", units.Bytes(float64(db.size()))))
+		columnFamilies := make([]columnFamilyStats, len(db.ColumnFamilies))
+		copy(columnFamilies, db.ColumnFamilies)
+		sort.SliceStable(columnFamilies, func(i, j int) bool {
+			return columnFamilies[i].LiveSstFilesSize > columnFamilies[j].LiveSstFilesSize
+		})
+		for _, columnFamily := range columnFamilies {
+			builder.WriteString(fmt.Sprintf("  %-38s %15d %12s\n", columnFamily.Name, columnFamily.EstimateNumKeys,
+				units.Bytes(float64(columnFamily.LiveSstFilesSize))))
+		}
+		total += db.size()
+	}
+	builder.WriteString(strings.Repeat("-", 69) + "\n")
+	builder.WriteString(fmt.Sprintf("%-40s %15s %12s\n", "total", "", units.Bytes(float64(total))))
+	return builder.String()
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Shows resource counts and database sizes",
+	Long: `Shows the number of resources by type together with the number of keys
+and the on-disk size of all databases and their column families of Blaze.
+The largest databases and column families are listed first.
+
+The resource counts are taken from the type statistics of Blaze using
+searches with _summary=count. The database statistics are fetched from the
+admin API of Blaze. Use them to decide which column families to compact.
+
+Examples:
+  blazectl stats --server http://localhost:8080/fhir`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			return err
+		}
+
+		resourceTypes, err := fetchResourceTypesWithSearchTypeInteraction(client)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		counts, err := fetchResourcesTotal(client, resourceTypes)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		dbs, err := fetchDatabaseStats(client)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		client.CloseIdleConnections()
+
+		fmt.Printf("Resources on %s\n\n", server)
+		fmt.Print(fmtMetaCounts(resourceTypes, counts, nil))
+		fmt.Println()
+		fmt.Print(fmtDatabaseStats(unitFormat(), dbs))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+
+	_ = statsCmd.MarkFlagRequired("server")
+}
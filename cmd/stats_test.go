@@ -0,0 +1,86 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestFetchDatabaseStats(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/json", r.Header.Get("Accept"))
+			switch r.URL.Path {
+			case "/fhir/__admin/dbs":
+				_, _ = w.Write([]byte(`[{"name": "index", "estimateLiveDataSize": 300}]`))
+			case "/fhir/__admin/dbs/index/column-families":
+				_, _ = w.Write([]byte(`[{"name": "resource-as-of-index", "estimateNumKeys": 10, "liveSstFilesSize": 100},
+					{"name": "search-param-value-index", "estimateNumKeys": 20, "liveSstFilesSize": 200}]`))
+			default:
+				t.Errorf("unexpected path %s", r.URL.Path)
+			}
+		}))
+		defer ts.Close()
+
+		baseURL, _ := url.ParseRequestURI(ts.URL + "/fhir")
+		dbs, err := fetchDatabaseStats(fhir.NewClient(*baseURL, nil))
+
+		if assert.NoError(t, err) {
+			assert.Len(t, dbs, 1)
+			assert.Equal(t, "index", dbs[0].Name)
+			assert.Len(t, dbs[0].ColumnFamilies, 2)
+			assert.Equal(t, int64(20), dbs[0].ColumnFamilies[1].EstimateNumKeys)
+			assert.Equal(t, int64(300), dbs[0].size())
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer ts.Close()
+
+		baseURL, _ := url.ParseRequestURI(ts.URL)
+		_, err := fetchDatabaseStats(fhir.NewClient(*baseURL, nil))
+
+		assert.ErrorContains(t, err, "error while fetching "+ts.URL+"/__admin/dbs")
+	})
+}
+
+func TestFmtDatabaseStats(t *testing.T) {
+	dbs := []databaseStats{
+		{Name: "transaction", ColumnFamilies: []columnFamilyStats{{Name: "default", EstimateNumKeys: 3, LiveSstFilesSize: 1024}}},
+		{Name: "index", ColumnFamilies: []columnFamilyStats{
+			{Name: "resource-as-of-index", EstimateNumKeys: 10, LiveSstFilesSize: 2048},
+			{Name: "search-param-value-index", EstimateNumKeys: 20, LiveSstFilesSize: 4096}}},
+	}
+
+	assert.Equal(t, ""+
+		"Database / Column Family                            Keys         Size\n"+
+		"index                                                            6144\n"+
+		"  search-param-value-index                            20         4096\n"+
+		"  resource-as-of-index                                10         2048\n"+
+		"transaction                                                      1024\n"+
+		"  default                                              3         1024\n"+
+		"---------------------------------------------------------------------\n"+
+		"total                                                            7168\n",
+		fmtDatabaseStats(util.UnitFormat{Raw: true}, dbs))
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+var summaryFile string
+
+// interruptedExitCode is the conventional exit code of a process terminated
+// by SIGINT.
+const interruptedExitCode = 130
+
+// createSummaryFileOrDie creates the file given by --summary-file. Returns
+// nil if the flag isn't set. The file is created before any work is done, so
+// that an existing file is detected early.
+func createSummaryFileOrDie() *os.File {
+	if summaryFile == "" {
+		return nil
+	}
+	return createOutputFileOrDie(summaryFile)
+}
+
+// writeSummary writes summary to w and to file unless file is nil. The file
+// is synced, so that the summary is durable even if the process is killed
+// afterwards. The summary is also added to the record of the last run.
+func writeSummary(w io.Writer, file *os.File, summary string) {
+	fmt.Fprint(w, summary)
+	recordRunSummary(summary)
+	if file == nil {
+		return
+	}
+	if _, err := file.WriteString(summary); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while writing the summary file %s: %v\n", file.Name(), err)
+		return
+	}
+	if err := file.Sync(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while syncing the summary file %s: %v\n", file.Name(), err)
+	}
+}
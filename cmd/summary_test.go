@@ -0,0 +1,49 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSummary(t *testing.T) {
+	t.Run("without file", func(t *testing.T) {
+		var buf bytes.Buffer
+		writeSummary(&buf, nil, "summary\n")
+
+		assert.Equal(t, "summary\n", buf.String())
+	})
+
+	t.Run("with file", func(t *testing.T) {
+		summaryFile = filepath.Join(t.TempDir(), "summary.txt")
+		defer func() { summaryFile = "" }()
+
+		file := createSummaryFileOrDie()
+		defer file.Close()
+
+		var buf bytes.Buffer
+		writeSummary(&buf, file, "summary\n")
+
+		assert.Equal(t, "summary\n", buf.String())
+		content, err := os.ReadFile(summaryFile)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "summary\n", string(content))
+		}
+	})
+}
@@ -0,0 +1,302 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+var supportBundleFile string
+
+// redacted replaces secrets in flag values, like url.URL.Redacted does for
+// passwords in URLs.
+const redacted = "xxxxx"
+
+// recordRunEnv is the environment variable which enables the record of the
+// last run if set to true. Runs aren't recorded by default, so that nothing
+// is written to disk without the consent of the user.
+const recordRunEnv = "BLAZECTL_RECORD_RUN"
+
+// safeFlags are the string flags whose values are recorded in the last run.
+// The values of all other string flags and all arguments are redacted, because
+// they may contain secrets, like tokens in queries, passwords in connection
+// strings or credentials in commands.
+var safeFlags = map[string]bool{
+	"server": true, "accept": true, "content-type": true, "progress": true, "tls-min-version": true,
+	"tls-ciphers": true, "on-conflict": true, "prefer": true, "sort": true, "total": true,
+	"chunk-by-lastupdated": true, "chunk-start": true, "chunk-end": true, "since": true, "interval": true,
+	"warmup": true, "output-file": true, "output-dir": true, "summary-file": true, "report-csv": true,
+	"id-map-file": true, "index": true, "manifest": true, "record": true, "replay": true,
+	"postgres-table": true, "postgres-columns": true,
+}
+
+// safeFlagTypes are the types of flags, like numbers, whose values can't
+// contain secrets.
+var safeFlagTypes = map[string]bool{"bool": true, "int": true, "int32": true, "int64": true, "uint": true,
+	"uint64": true, "float64": true, "duration": true, "count": true}
+
+// lastRun is the record of the last run of blazectl, which is included in
+// support bundles. All values which may contain secrets are redacted before
+// the record is saved.
+type lastRun struct {
+	Started time.Time         `json:"started"`
+	Version string            `json:"version"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Flags   map[string]string `json:"flags,omitempty"`
+	Summary string            `json:"summary,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// currentRun is the record of the running command. It's nil if the command
+// isn't recorded.
+var currentRun *lastRun
+
+// redactValue redacts the value of flag unless the flag is known to be safe.
+// Passwords in URLs are redacted also in the values of safe flags.
+func redactValue(flag *pflag.Flag) string {
+	value := flag.Value.String()
+	if value == "" || safeFlagTypes[flag.Value.Type()] {
+		return value
+	}
+	if !safeFlags[flag.Name] {
+		return redacted
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
+// newLastRun records the run of cmd with args. Only the flags given on the
+// command line are recorded. Arguments are redacted.
+func newLastRun(cmd *cobra.Command, args []string, version string, started time.Time) *lastRun {
+	run := &lastRun{Started: started, Version: version, Command: cmd.CommandPath()}
+	for range args {
+		run.Args = append(run.Args, redacted)
+	}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if run.Flags == nil {
+			run.Flags = make(map[string]string)
+		}
+		run.Flags[flag.Name] = redactValue(flag)
+	})
+	return run
+}
+
+// lastRunFile returns the path of the record of the last run in the user
+// cache directory.
+func lastRunFile() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "blazectl", "last-run.json"), nil
+}
+
+// saveRun saves run to the file at path, replacing the previous run.
+func saveRun(path string, run *lastRun) error {
+	content, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+// readRun reads the run saved at path. Returns nil if no run was saved.
+func readRun(path string) (*lastRun, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var run lastRun
+	if err := json.Unmarshal(content, &run); err != nil {
+		return nil, fmt.Errorf("error while reading the last run %s: %w", path, err)
+	}
+	return &run, nil
+}
+
+// recordRun starts recording the run of cmd if enabled by recordRunEnv.
+// Commands only giving information, like version or help, aren't recorded, so
+// that they don't replace the run to report. Recording is best effort and
+// never fails the command.
+func recordRun(cmd *cobra.Command, args []string) {
+	if os.Getenv(recordRunEnv) != "true" {
+		return
+	}
+	switch cmd.Name() {
+	case supportBundleCmd.Name(), versionCmd.Name(), docsCmd.Name(), "help", "completion",
+		cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return
+	}
+	if cmd.HasParent() && (cmd.Parent() == docsCmd || cmd.Parent().Name() == "completion") {
+		return
+	}
+	currentRun = newLastRun(cmd, args, rootCmd.Version, time.Now())
+	saveCurrentRun()
+}
+
+func saveCurrentRun() {
+	if currentRun == nil {
+		return
+	}
+	if path, err := lastRunFile(); err == nil {
+		_ = saveRun(path, currentRun)
+	}
+}
+
+// recordRunSummary adds the statistics of the running command to its record.
+func recordRunSummary(summary string) {
+	if currentRun == nil {
+		return
+	}
+	currentRun.Summary = summary
+	saveCurrentRun()
+}
+
+// recordRunError adds the error the running command failed with to its
+// record.
+func recordRunError(err error) {
+	if currentRun == nil {
+		return
+	}
+	currentRun.Error = err.Error()
+	saveCurrentRun()
+}
+
+// fmtSystemInfo formats the version of blazectl, the Go runtime and the
+// operating system.
+func fmtSystemInfo(version string) string {
+	return fmt.Sprintf("blazectl    : %s\nGo          : %s\nOS          : %s\nArchitecture: %s\nCPUs        : %d\n",
+		version, runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.NumCPU())
+}
+
+// archiveEntry is a file in a support bundle.
+type archiveEntry struct {
+	name    string
+	content []byte
+}
+
+// writeSupportBundle writes a ZIP archive with the system information and
+// the last run, if any, to w. Returns the names of the files in the archive.
+func writeSupportBundle(w io.Writer, systemInfo string, run *lastRun) ([]string, error) {
+	files := []archiveEntry{{"system.txt", []byte(systemInfo)}}
+	if run != nil {
+		content, err := json.MarshalIndent(run, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, archiveEntry{"last-run.json", append(content, '\n')})
+		if run.Summary != "" {
+			files = append(files, archiveEntry{"last-run-summary.txt", []byte(run.Summary)})
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		out, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := out.Write(file.content); err != nil {
+			return nil, err
+		}
+		names = append(names, file.name)
+	}
+	return names, archive.Close()
+}
+
+var supportBundleCmd = &cobra.Command{
+	Use:   "support-bundle",
+	Short: "Collects information for bug reports",
+	Long: `Writes a ZIP archive with the version of blazectl, the operating system and
+the last run of blazectl, which can be attached to a GitHub issue.
+
+The last run covers the command, its arguments and the flags given on the
+command line, the final statistics and error lists of the upload and
+download commands and the error the command failed with. Arguments and the
+values of flags which may contain secrets, like --query, --password or
+--output-cmd, are redacted. Only numbers, switches and the values of flags
+known to be safe, like --server without password or --output-file, are kept.
+The last run is kept in the user cache directory and nothing is ever sent
+anywhere. Please check the content of the archive before attaching it to an
+issue.
+
+Runs are only recorded if the environment variable BLAZECTL_RECORD_RUN is
+set to true, so reproduce the problem with it before creating the support
+bundle.
+
+Examples:
+  blazectl support-bundle
+  blazectl support-bundle -o support.zip`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var run *lastRun
+		if path, err := lastRunFile(); err == nil {
+			if run, err = readRun(path); err != nil {
+				return err
+			}
+		}
+
+		if supportBundleFile == "" {
+			supportBundleFile = "blazectl-support-" + time.Now().Format("20060102-150405") + ".zip"
+		}
+		file := createOutputFileOrDie(supportBundleFile)
+		names, err := writeSupportBundle(file, fmtSystemInfo(rootCmd.Version), run)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Wrote the support bundle %s with:\n", supportBundleFile)
+		for _, name := range names {
+			fmt.Printf("  %s\n", name)
+		}
+		if run == nil {
+			fmt.Printf("No last run of blazectl was found. Set %s=true to record runs.\n", recordRunEnv)
+		}
+		fmt.Println("Please check its content before attaching it to an issue.")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(supportBundleCmd)
+
+	supportBundleCmd.Flags().StringVarP(&supportBundleFile, "output-file", "o", "", "write the archive to this file instead of blazectl-support-<time>.zip")
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+var tailInterval time.Duration
+var tailSince string
+
+// parseInstant parses a FHIR instant or a date.
+func parseInstant(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// historyTail polls the history of a resource type or the whole system for
+// resources changed since the newest change seen so far.
+type historyTail struct {
+	client       *fhir.Client
+	resourceType string
+	since        string
+	sinceTime    time.Time
+	// the versions last updated at since, which are returned again by the
+	// next poll, because _since is inclusive
+	seen map[string]bool
+}
+
+func newHistoryTail(client *fhir.Client, resourceType string, since time.Time) *historyTail {
+	return &historyTail{
+		client:       client,
+		resourceType: resourceType,
+		since:        since.UTC().Format(time.RFC3339Nano),
+		sinceTime:    since,
+		seen:         make(map[string]bool),
+	}
+}
+
+// fetch fetches all pages of the history since the newest change seen so far.
+func (t *historyTail) fetch() ([]fm.BundleEntry, error) {
+	req, err := t.client.NewHistoryRequest(t.resourceType, url.Values{"_since": []string{t.since}})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fm.BundleEntry
+	for req != nil {
+		resp, err := t.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			errorResponse := util.NewErrorResponse(resp, body)
+			return nil, errors.New(errorResponse.String())
+		}
+
+		bundle, err := fm.UnmarshalBundle(body)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the history: %w", err)
+		}
+		entries = append(entries, bundle.Entry...)
+
+		nextPageURL, err := getNextPageURL(bundle.Link, req.URL)
+		if err != nil {
+			return nil, err
+		}
+		req = nil
+		if nextPageURL != nil {
+			if req, err = t.client.NewPaginatedRequest(nextPageURL); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// poll writes the resources changed since the last poll as NDJSON to w, the
+// oldest first. Deletions have no resource and are skipped, as are versions
+// already written and versions older than the newest change seen so far.
+// Returns the number of resources written.
+func (t *historyTail) poll(w io.Writer) (int, error) {
+	entries, err := t.fetch()
+	if err != nil {
+		return 0, err
+	}
+
+	var written int
+	var buf bytes.Buffer
+	// the history is ordered from the newest to the oldest change
+	for i := len(entries) - 1; i >= 0; i-- {
+		if len(entries[i].Resource) == 0 {
+			continue
+		}
+		var resource struct {
+			ResourceType string `json:"resourceType"`
+			Id           string `json:"id"`
+			Meta         struct {
+				VersionId   string `json:"versionId"`
+				LastUpdated string `json:"lastUpdated"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal(entries[i].Resource, &resource); err != nil {
+			return written, fmt.Errorf("error while reading a resource of the history: %w", err)
+		}
+		key := resource.ResourceType + "/" + resource.Id + "/_history/" + resource.Meta.VersionId
+		lastUpdated, err := time.Parse(time.RFC3339Nano, resource.Meta.LastUpdated)
+		if t.seen[key] || (err == nil && lastUpdated.Before(t.sinceTime)) {
+			continue
+		}
+
+		buf.Reset()
+		if err := json.Compact(&buf, entries[i].Resource); err != nil {
+			return written, err
+		}
+		buf.WriteByte('\n')
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return written, err
+		}
+		written++
+
+		if err == nil {
+			if lastUpdated.After(t.sinceTime) {
+				t.since = resource.Meta.LastUpdated
+				t.sinceTime = lastUpdated
+				t.seen = make(map[string]bool)
+			}
+			if lastUpdated.Equal(t.sinceTime) {
+				t.seen[key] = true
+			}
+		}
+	}
+	return written, nil
+}
+
+var tailCmd = &cobra.Command{
+	Use:   "tail [resource-type]",
+	Short: "Follow changes of resources",
+	Long: `Polls the history of the given resource type or of the whole system
+every --interval for resources changed since the newest change seen so far
+and streams them to stdout in NDJSON format, the oldest first. Like tail -f,
+it runs until it's interrupted with Ctrl-C.
+
+Deletions are not streamed. By default, only changes after the start of
+tail, according to the clock of the client, are streamed. Use --since with a
+FHIR instant or a date to also stream earlier changes.
+
+Failed polls are reported and retried at the next interval.
+
+Examples:
+  blazectl tail --server http://localhost:8080/fhir
+  blazectl tail --server http://localhost:8080/fhir Observation --interval 30s
+  blazectl tail --server http://localhost:8080/fhir Patient --since 2024-01-01 > patients.ndjson`,
+	ValidArgsFunction: completeResourceTypes(1),
+	Args:              cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since := time.Now()
+		if tailSince != "" {
+			var err error
+			if since, err = parseInstant(tailSince); err != nil {
+				return fmt.Errorf("invalid --since value `%s`, expected a FHIR instant or date", tailSince)
+			}
+		}
+		if err := createClient(); err != nil {
+			return err
+		}
+
+		var resourceType string
+		if len(args) == 1 {
+			resourceType = args[0]
+		}
+		tail := newHistoryTail(client, resourceType, since)
+		sink := bufio.NewWriter(os.Stdout)
+		for {
+			if _, err := tail.poll(sink); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to poll the history: %v\n", err)
+			}
+			if err := sink.Flush(); err != nil {
+				return err
+			}
+			time.Sleep(tailInterval)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tailCmd)
+
+	tailCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	tailCmd.Flags().DurationVar(&tailInterval, "interval", 10*time.Second, "wait between polls")
+	tailCmd.Flags().StringVar(&tailSince, "since", "", "stream changes since this FHIR instant or date instead of the start")
+
+	_ = tailCmd.MarkFlagRequired("server")
+}
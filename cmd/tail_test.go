@@ -0,0 +1,99 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseInstant(t *testing.T) {
+	instant, err := parseInstant("2024-01-02T03:04:05.123+01:00")
+	if assert.NoError(t, err) {
+		assert.True(t, instant.Equal(time.Date(2024, 1, 2, 2, 4, 5, 123000000, time.UTC)))
+	}
+
+	date, err := parseInstant("2024-01-02")
+	if assert.NoError(t, err) {
+		assert.True(t, date.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)))
+	}
+
+	_, err = parseInstant("yesterday")
+	assert.Error(t, err)
+}
+
+func TestHistoryTailPoll(t *testing.T) {
+	var sinces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/Patient/_history", r.URL.Path)
+		if r.URL.Query().Get("__page") == "" {
+			sinces = append(sinces, r.URL.Query().Get("_since"))
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "history",
+				"link": [{"relation": "next", "url": "_history?__page=2"}],
+				"entry": [
+				  {"resource": {"resourceType": "Patient", "id": "1", "meta": {"versionId": "3", "lastUpdated": "2024-01-02T00:00:00Z"}}},
+				  {"request": {"method": "DELETE", "url": "Patient/2"}}]}`))
+		} else {
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "history",
+				"entry": [{"resource": {"resourceType": "Patient", "id": "0", "meta": {"versionId": "2", "lastUpdated": "2024-01-01T00:00:00Z"}}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+	tail := newHistoryTail(client, "Patient", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var buf bytes.Buffer
+	written, err := tail.poll(&buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, written)
+		assert.Equal(t, `{"resourceType":"Patient","id":"0","meta":{"versionId":"2","lastUpdated":"2024-01-01T00:00:00Z"}}
+{"resourceType":"Patient","id":"1","meta":{"versionId":"3","lastUpdated":"2024-01-02T00:00:00Z"}}
+`, buf.String())
+	}
+
+	// the server returns the same history again, because _since is inclusive
+	buf.Reset()
+	written, err = tail.poll(&buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, written)
+		assert.Empty(t, buf.String())
+	}
+
+	assert.Equal(t, []string{"2023-01-01T00:00:00Z", "2024-01-02T00:00:00Z"}, sinces)
+}
+
+func TestHistoryTailPollError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+	tail := newHistoryTail(client, "", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	_, err := tail.poll(&bytes.Buffer{})
+
+	assert.Error(t, err)
+	assert.Equal(t, "2023-01-01T00:00:00Z", tail.since)
+}
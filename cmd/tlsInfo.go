@@ -0,0 +1,81 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/spf13/cobra"
+	"net/url"
+	"os"
+	"time"
+)
+
+// rootCAs returns the certificate pool of the --certificate-authority flag,
+// together with the system roots if --system-roots is given, or nil if only
+// the system roots should be used.
+func rootCAs() (*x509.CertPool, error) {
+	if caCert == "" {
+		return nil, nil
+	}
+	return fhir.ReadCertPool(caCert, systemRoots)
+}
+
+var tlsInfoCmd = &cobra.Command{
+	Use:   "tls-info",
+	Short: "Shows the TLS connection to a server",
+	Long: `Connects to a server over TLS and shows the negotiated protocol version
+and cipher suite together with the certificate chain of the server, its SANs
+and expiry. The chain is verified against the system roots or the
+certificate authority given by --certificate-authority. The connection is
+established even if the verification fails, so that the chain can be
+inspected.
+
+Examples:
+  blazectl tls-info --server "https://blaze.example.com/fhir"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		baseURL, err := url.ParseRequestURI(server)
+		if err != nil {
+			fmt.Printf("could not parse server's base URL: %v\n", err)
+			os.Exit(1)
+		}
+		roots, err := rootCAs()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		info, err := fhir.FetchTLSInfo(*baseURL, roots, 10*time.Second)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(info)
+
+		if info.VerifyErr != nil {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tlsInfoCmd)
+
+	tlsInfoCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+
+	_ = tlsInfoCmd.MarkFlagRequired("server")
+}
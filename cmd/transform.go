@@ -0,0 +1,94 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var transformFile string
+
+// uploadTransform is the template given by --transform. It's nil if the flag
+// isn't set.
+var uploadTransform *template.Template
+
+// templateFuncs are the functions available in templates in addition to the
+// built-in functions of text/template.
+var templateFuncs = template.FuncMap{
+	// replace replaces all occurrences of old in s by new. The string comes
+	// last, so that it can be piped in.
+	"replace": func(old string, new string, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+	// env returns the value of the environment variable with name.
+	"env": os.Getenv,
+	// json encodes v as JSON, which is useful for quoting strings.
+	"json": func(v any) (string, error) {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	},
+}
+
+// transformData is the data a --transform template is executed with.
+type transformData struct {
+	// Content is the bundle or resource as JSON.
+	Content string
+	// Value is the decoded bundle or resource. Numbers are kept as
+	// json.Number, so that they are printed as given.
+	Value any
+	// File is the file the bundle or resource was read from.
+	File string
+	// Index is the number of the bundle or the line of the resource in File,
+	// both starting at 1.
+	Index int
+}
+
+// parseTransform parses the template file at path with templateFuncs.
+func parseTransform(path string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Option("missingkey=error").ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing the transform template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// applyTransform executes tmpl with the bundle or resource in content, which
+// was read from file at index, and returns the output.
+func applyTransform(tmpl *template.Template, content []byte, file string, index int) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("error while decoding the JSON to transform: %w", err)
+	}
+
+	var buf bytes.Buffer
+	data := transformData{Content: string(content), Value: value, File: file, Index: index}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("error while transforming: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTemplate(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "transform.tmpl")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal("can't create a temp template file")
+	}
+	return path
+}
+
+func TestParseTransform(t *testing.T) {
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := parseTransform(writeTemplate(t, "{{ .Content "))
+
+		assert.ErrorContains(t, err, "error while parsing the transform template: ")
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := parseTransform(filepath.Join(t.TempDir(), "transform.tmpl"))
+
+		assert.ErrorContains(t, err, "error while parsing the transform template: ")
+	})
+}
+
+func TestApplyTransform(t *testing.T) {
+	transform := func(t *testing.T, text string, content string) (string, error) {
+		tmpl, err := parseTransform(writeTemplate(t, text))
+		if err != nil {
+			t.Fatalf("error while parsing the template: %v", err)
+		}
+		out, err := applyTransform(tmpl, []byte(content), "bundles.ndjson", 2)
+		return string(out), err
+	}
+
+	t.Run("Replace", func(t *testing.T) {
+		out, err := transform(t, `{{ .Content | replace "urn:old" "urn:new" }}`, `{"system": "urn:old"}`)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `{"system": "urn:new"}`, out)
+		}
+	})
+
+	t.Run("FileAndIndex", func(t *testing.T) {
+		out, err := transform(t, `{{ .File }} {{ .Index }}`, `{}`)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "bundles.ndjson 2", out)
+		}
+	})
+
+	t.Run("Value", func(t *testing.T) {
+		out, err := transform(t, `{"resourceType": {{ json .Value.resourceType }}, "count": {{ .Value.count }}}`,
+			`{"resourceType": "Patient", "count": 1.50}`)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `{"resourceType": "Patient", "count": 1.50}`, out)
+		}
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		t.Setenv("BLAZECTL_TEST_SOURCE", "http://example.com/<import>")
+
+		out, err := transform(t, `{{ env "BLAZECTL_TEST_SOURCE" | json }}`, `{}`)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `"http://example.com/<import>"`, out)
+		}
+	})
+
+	t.Run("InvalidJSON", func(t *testing.T) {
+		_, err := transform(t, `{{ .Content }}`, `{`)
+
+		assert.ErrorContains(t, err, "error while decoding the JSON to transform: ")
+	})
+
+	t.Run("MissingKey", func(t *testing.T) {
+		_, err := transform(t, `{{ .Value.id }}`, `{}`)
+
+		assert.ErrorContains(t, err, "error while transforming: ")
+	})
+}
@@ -16,8 +16,13 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/samply/blazectl/fhir"
@@ -27,12 +32,19 @@ import (
 	"github.com/vbauerster/mpb/v7"
 	"github.com/vbauerster/mpb/v7/decor"
 	"io"
+	"maps"
 	"net/http"
 	"net/http/httptrace"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -60,10 +72,120 @@ type bundle struct {
 
 type uploadInfo struct {
 	statusCode         int
+	requestId          string
+	correlationId      string
 	error              []byte
+	response           []byte // the response body, only kept for --post-bundle-cmd
 	bytesOut, bytesIn  int64
 	requestDuration    time.Duration
 	processingDuration time.Duration
+	entryStatusCodes   map[int]int
+	entryOutcomes      map[int]util.ErrorResponse
+	idMappings         []idMapping
+	externalized       externalizedAttachments
+	splits             int
+	conflict           string // the --on-conflict strategy applied to the bundle
+	verified           int
+	mismatches         map[int]string
+}
+
+// responseBundle is the part of a transaction or batch response bundle needed
+// to report the outcome of the individual entries.
+type responseBundle struct {
+	Entry []struct {
+		Response *fm.BundleEntryResponse `json:"response,omitempty"`
+	} `json:"entry,omitempty"`
+}
+
+// readEntryOutcomes parses a transaction or batch response bundle and returns
+// the frequencies of the entry status codes together with the ErrorResponses
+// of all entries which either failed or carry an outcome. The ErrorResponses
+// are keyed by the index of the entry.
+func readEntryOutcomes(body []byte) (map[int]int, map[int]util.ErrorResponse, error) {
+	var bundle responseBundle
+	if err := json.Unmarshal(body, &bundle); err != nil {
+		return nil, nil, err
+	}
+
+	statusCodes := make(map[int]int)
+	outcomes := make(map[int]util.ErrorResponse)
+	for i, entry := range bundle.Entry {
+		errorResponse := entryErrorResponse(entry.Response)
+		statusCodes[errorResponse.StatusCode]++
+		if !isSuccessfulStatus(errorResponse.StatusCode) || errorResponse.OperationOutcome != nil {
+			outcomes[i] = errorResponse
+		}
+	}
+	return statusCodes, outcomes, nil
+}
+
+// idMapping maps an entry of an uploaded bundle to the location the server
+// assigned to the resource of that entry.
+type idMapping struct {
+	entryIndex   int
+	fullUrl      string
+	resourceType string
+	id           string
+	location     string
+}
+
+// requestBundle is the part of a transaction or batch bundle needed to
+// identify the resources of the individual entries.
+type requestBundle struct {
+	Entry []struct {
+		FullUrl  string `json:"fullUrl,omitempty"`
+		Resource struct {
+			ResourceType string `json:"resourceType,omitempty"`
+			Id           string `json:"id,omitempty"`
+		} `json:"resource,omitempty"`
+	} `json:"entry,omitempty"`
+}
+
+// readIdMappings pairs the entries of an uploaded bundle with the entries of
+// its response bundle by position and returns the original fullUrl and id of
+// each entry together with the location assigned by the server.
+func readIdMappings(requestBody []byte, responseBody []byte) ([]idMapping, error) {
+	var request requestBundle
+	if err := json.Unmarshal(requestBody, &request); err != nil {
+		return nil, fmt.Errorf("error while reading the uploaded bundle: %w", err)
+	}
+	var response responseBundle
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("error while reading the response bundle: %w", err)
+	}
+	if len(request.Entry) != len(response.Entry) {
+		return nil, fmt.Errorf("expected %d entries in the response bundle but got %d",
+			len(request.Entry), len(response.Entry))
+	}
+
+	mappings := make([]idMapping, 0, len(request.Entry))
+	for i, entry := range request.Entry {
+		mapping := idMapping{
+			entryIndex:   i,
+			fullUrl:      entry.FullUrl,
+			resourceType: entry.Resource.ResourceType,
+			id:           entry.Resource.Id,
+		}
+		if r := response.Entry[i].Response; r != nil && r.Location != nil {
+			mapping.location = *r.Location
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// idMapHeader is the header of the CSV file written by --id-map-file.
+var idMapHeader = []string{"file", "bundle", "entry", "full-url", "resource-type", "id", "location"}
+
+func writeIdMappings(w *csv.Writer, bundleId bundleIdentifier, mappings []idMapping) error {
+	for _, m := range mappings {
+		record := []string{bundleId.filename, strconv.Itoa(bundleId.bundleNumber), strconv.Itoa(m.entryIndex),
+			m.fullUrl, m.resourceType, m.id, m.location}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type CountingReader struct {
@@ -77,50 +199,194 @@ func (r *CountingReader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
-// Uploads a single bundle and returns either the status code of the response or
-// an error.
-func uploadBundle(client *fhir.Client, bundleId *bundleIdentifier) (uploadInfo, error) {
-	file, err := os.Open(bundleId.filename)
-	if err != nil {
-		return uploadInfo{}, err
-	}
-	defer file.Close()
-
+// openBundle returns a reader of the bundle with bundleId in file together
+// with a function returning the size of the bundle. A UTF-8 byte order mark at
+// the start of the file is skipped and UTF-16 encoded files are rejected.
+func openBundle(file *os.File, bundleId *bundleIdentifier) (io.Reader, func() int64, error) {
 	var reader io.Reader
 	var bundleSize func() int64
-	if strings.HasSuffix(bundleId.filename, ".json") {
-		reader = bufio.NewReader(file)
+	var bom int64
+	var err error
+	if hasSuffixFold(bundleId.filename, ".json") {
+		if reader, bom, err = skipBOM(file); err != nil {
+			return nil, nil, err
+		}
 		bundleSize = func() int64 {
-			return bundleId.endBytes - bundleId.startBytes
+			return bundleId.endBytes - bundleId.startBytes - bom
 		}
-	} else if strings.HasSuffix(bundleId.filename, ".json.gz") {
+	} else if hasSuffixFold(bundleId.filename, ".json.gz") {
 		rdr, err := gzip.NewReader(bufio.NewReader(file))
 		if err != nil {
-			return uploadInfo{}, err
+			return nil, nil, err
+		}
+		if reader, _, err = skipBOM(rdr); err != nil {
+			return nil, nil, err
 		}
-		reader = &CountingReader{reader: rdr}
+		reader = &CountingReader{reader: reader}
 		bundleSize = func() int64 {
 			return reader.(*CountingReader).BytesRead
 		}
-	} else if strings.HasSuffix(bundleId.filename, ".json.bz2") {
-		reader = &CountingReader{reader: bzip2.NewReader(bufio.NewReader(file))}
+	} else if hasSuffixFold(bundleId.filename, ".json.bz2") {
+		if reader, _, err = skipBOM(bzip2.NewReader(bufio.NewReader(file))); err != nil {
+			return nil, nil, err
+		}
+		reader = &CountingReader{reader: reader}
 		bundleSize = func() int64 {
 			return reader.(*CountingReader).BytesRead
 		}
 	} else {
 		reader, err = NewFileChunkReader(file, bundleId.startBytes, bundleId.endBytes-bundleId.startBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if bundleId.startBytes == 0 {
+			if reader, bom, err = skipBOM(reader); err != nil {
+				return nil, nil, err
+			}
+		}
+		bundleSize = func() int64 {
+			return bundleId.endBytes - bundleId.startBytes - bom
+		}
+	}
+	return reader, bundleSize, nil
+}
+
+// skipBOM returns a reader of r without the UTF-8 byte order mark at its start
+// together with the number of bytes skipped.
+func skipBOM(r io.Reader) (io.Reader, int64, error) {
+	buffered := bufio.NewReader(r)
+	n, err := util.SkipBOM(buffered)
+	return buffered, int64(n), err
+}
+
+// Uploads a single bundle and returns either the status code of the response or
+// an error. The upload is aborted if ctx is cancelled. Bundles rejected as too
+// large are split up to --max-splits times. Bundles rejected with a conflict
+// are handled according to --on-conflict. With --verify-sample, resources of
+// successful uploads are read back and compared. The bundle is transformed by
+// --transform and piped through --pre-bundle-cmd before and the response into --post-bundle-cmd after the
+// upload.
+func uploadBundle(ctx context.Context, client *fhir.Client, bundleId *bundleIdentifier) (uploadInfo, error) {
+	file, err := os.Open(bundleId.filename)
+	if err != nil {
+		return uploadInfo{}, err
+	}
+	defer file.Close()
+
+	reader, bundleSize, err := openBundle(file, bundleId)
+	if err != nil {
+		return uploadInfo{}, err
+	}
+
+	var content []byte
+	var externalized externalizedAttachments
+	if uploadTransform != nil || preBundleCmd != "" || externalizeThreshold > 0 || uploadTag != nil {
+		content, err = io.ReadAll(reader)
 		if err != nil {
 			return uploadInfo{}, err
 		}
+		if uploadTransform != nil {
+			if content, err = applyTransform(uploadTransform, content, bundleId.filename, bundleId.bundleNumber); err != nil {
+				return uploadInfo{}, err
+			}
+		}
+		if preBundleCmd != "" {
+			if content, err = runPreBundleCmd(preBundleCmd, *bundleId, content); err != nil {
+				return uploadInfo{}, err
+			}
+		}
+		if externalizeThreshold > 0 {
+			content, externalized, err = externalizeAttachments(client, content, externalizeThreshold)
+			if err != nil {
+				return uploadInfo{}, fmt.Errorf("error while externalizing attachments: %w", err)
+			}
+		}
+		if uploadTag != nil {
+			if content, err = tagBundleResources(content, *uploadTag); err != nil {
+				return uploadInfo{}, fmt.Errorf("error while tagging resources: %w", err)
+			}
+		}
+		reader = bytes.NewReader(content)
 		bundleSize = func() int64 {
-			return bundleId.endBytes - bundleId.startBytes
+			return int64(len(content))
+		}
+	}
+
+	info, err := postBundle(ctx, client, reader, bundleSize)
+	if err != nil {
+		return uploadInfo{externalized: externalized}, err
+	}
+	if maxSplits > 0 && bundleTooLarge(info) {
+		if content == nil {
+			if content, err = readBundleContent(*bundleId); err != nil {
+				return uploadInfo{}, err
+			}
+		}
+		if info, err = uploadSplitBundle(ctx, client, content, info, maxSplits); err != nil {
+			info.externalized = externalized
+			return info, err
+		}
+	}
+	if isConflict(info.statusCode) {
+		switch onConflict {
+		case "skip":
+			info.conflict = onConflict
+		case "overwrite":
+			if content == nil {
+				if content, err = readBundleContent(*bundleId); err != nil {
+					return uploadInfo{}, err
+				}
+			}
+			overwriting, rewritten, err := overwritingBundle(client, content)
+			if err != nil {
+				return uploadInfo{}, fmt.Errorf("error while preparing the overwrite of a conflicting bundle: %w", err)
+			}
+			if rewritten > 0 {
+				if info, err = postBundle(ctx, client, bytes.NewReader(overwriting), func() int64 {
+					return int64(len(overwriting))
+				}); err != nil {
+					return uploadInfo{}, err
+				}
+				info.conflict = onConflict
+			}
+		}
+	}
+	if verifySample > 0 && info.statusCode == http.StatusOK {
+		if content == nil {
+			if content, err = readBundleContent(*bundleId); err != nil {
+				return uploadInfo{}, err
+			}
+		}
+		info.verified, info.mismatches = verifySampledEntries(client, content, info.idMappings, verifySample)
+	}
+	info.externalized = externalized
+	if postBundleCmd != "" {
+		if err := runPostBundleCmd(postBundleCmd, *bundleId, info.statusCode, info.response); err != nil {
+			return uploadInfo{}, err
 		}
 	}
+	return info, nil
+}
+
+// postBundle posts the bundle read from reader as transaction or batch.
+func postBundle(ctx context.Context, client *fhir.Client, reader io.Reader, bundleSize func() int64) (uploadInfo, error) {
+	// keep a copy of the uploaded bundle in order to map its entries to the
+	// locations assigned by the server
+	requestReader := reader
+	var requestBody bytes.Buffer
+	if idMapFile != "" || verifySample > 0 {
+		requestReader = io.TeeReader(reader, &requestBody)
+	}
 
-	req, err := client.NewTransactionRequest(reader)
+	req, err := client.NewTransactionRequest(requestReader)
 	if err != nil {
 		return uploadInfo{}, err
 	}
+	if uploadAsync {
+		req.Header.Set("Prefer", "respond-async, return="+uploadPrefer)
+	} else {
+		req.Header.Set("Prefer", "return="+uploadPrefer)
+	}
 
 	var requestStart time.Time
 	var processingStart time.Time
@@ -136,37 +402,79 @@ func uploadBundle(client *fhir.Client, bundleId *bundleIdentifier) (uploadInfo,
 			processingDuration = time.Since(processingStart)
 		},
 	}
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	ctx = httptrace.WithClientTrace(ctx, trace)
+	if uploadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, uploadTimeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return uploadInfo{}, fmt.Errorf("error while uploading: %w", err)
+		return uploadInfo{}, fmt.Errorf("error while uploading with correlation id %s: %w",
+			req.Header.Get(fhir.CorrelationIdHeader), err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 200 {
-		bodySize, err := io.Copy(io.Discard, resp.Body)
+	statusCode := resp.StatusCode
+	var body []byte
+	if uploadAsync && statusCode == http.StatusAccepted {
+		statusCode, body, err = awaitAsyncUpload(ctx, client, resp.Header.Get("Content-Location"),
+			retryAfter(resp, pollInterval), newPollTimeout())
 		if err != nil {
 			return uploadInfo{}, err
 		}
+	} else {
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			if statusCode == 200 {
+				return uploadInfo{}, err
+			}
+			return uploadInfo{}, fmt.Errorf("error while reading the FHIR error response: %v", err)
+		}
+	}
+
+	var response []byte
+	if postBundleCmd != "" {
+		response = body
+	}
+
+	if statusCode == 200 {
+		requestDuration := time.Since(requestStart)
+
+		// a response bundle which can't be parsed doesn't make the upload fail
+		entryStatusCodes, entryOutcomes, _ := readEntryOutcomes(body)
+
+		var idMappings []idMapping
+		if idMapFile != "" || verifySample > 0 {
+			idMappings, err = readIdMappings(requestBody.Bytes(), body)
+			if err != nil {
+				return uploadInfo{}, fmt.Errorf("error while mapping ids: %w", err)
+			}
+		}
 
 		return uploadInfo{
-			statusCode:         resp.StatusCode,
+			statusCode:         statusCode,
+			requestId:          resp.Header.Get(fhir.RequestIdHeader),
+			correlationId:      fhir.CorrelationId(resp),
 			bytesOut:           bundleSize(),
-			bytesIn:            bodySize,
-			requestDuration:    time.Since(requestStart),
+			bytesIn:            int64(len(body)),
+			requestDuration:    requestDuration,
 			processingDuration: processingDuration,
+			entryStatusCodes:   entryStatusCodes,
+			entryOutcomes:      entryOutcomes,
+			idMappings:         idMappings,
+			response:           response,
 		}, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return uploadInfo{}, fmt.Errorf("error while reading the FHIR error response: %v", err)
-	}
-
 	return uploadInfo{
-		statusCode:         resp.StatusCode,
+		statusCode:         statusCode,
+		requestId:          resp.Header.Get(fhir.RequestIdHeader),
+		correlationId:      fhir.CorrelationId(resp),
 		error:              body,
+		response:           response,
 		bytesOut:           bundleSize(),
 		bytesIn:            int64(len(body)),
 		requestDuration:    time.Since(requestStart),
@@ -174,72 +482,497 @@ func uploadBundle(client *fhir.Client, bundleId *bundleIdentifier) (uploadInfo,
 	}, nil
 }
 
+// bundleTooLarge returns whether the server rejected the uploaded bundle
+// because of its size, either with status 413 or with an OperationOutcome
+// holding an issue of code too-long.
+func bundleTooLarge(info uploadInfo) bool {
+	if info.statusCode == http.StatusRequestEntityTooLarge {
+		return true
+	}
+	if info.statusCode == http.StatusOK {
+		return false
+	}
+	operationOutcome, err := fm.UnmarshalOperationOutcome(info.error)
+	if err != nil {
+		return false
+	}
+	for _, issue := range operationOutcome.Issue {
+		if issue.Code == fm.IssueTypeTooLong {
+			return true
+		}
+	}
+	return false
+}
+
+// splitBundle splits the bundle in content into two bundles holding the first
+// and the second half of its entries. Returns the number of entries of the
+// first half. Bundles whose halves reference each other by fullUrl can't be
+// split, because the references would no longer resolve.
+func splitBundle(content []byte) ([2][]byte, int, error) {
+	var bundle map[string]json.RawMessage
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return [2][]byte{}, 0, err
+	}
+	var entries []json.RawMessage
+	if rawEntries, ok := bundle["entry"]; ok {
+		if err := json.Unmarshal(rawEntries, &entries); err != nil {
+			return [2][]byte{}, 0, err
+		}
+	}
+	if len(entries) < 2 {
+		return [2][]byte{}, 0, fmt.Errorf("it has %d entries", len(entries))
+	}
+
+	mid := len(entries) / 2
+	if referencesFullUrl(entries[:mid], entries[mid:]) || referencesFullUrl(entries[mid:], entries[:mid]) {
+		return [2][]byte{}, 0, errors.New("the entries of its halves reference each other by fullUrl")
+	}
+
+	var halves [2][]byte
+	for i, half := range [][]json.RawMessage{entries[:mid], entries[mid:]} {
+		rawEntries, err := json.Marshal(half)
+		if err != nil {
+			return [2][]byte{}, 0, err
+		}
+		bundle["entry"] = rawEntries
+		if halves[i], err = json.Marshal(bundle); err != nil {
+			return [2][]byte{}, 0, err
+		}
+	}
+	return halves, mid, nil
+}
+
+// referencesFullUrl returns whether one of the entries in referencing contains
+// the fullUrl of one of the entries in referenced as string.
+func referencesFullUrl(referencing []json.RawMessage, referenced []json.RawMessage) bool {
+	for _, rawEntry := range referenced {
+		var entry struct {
+			FullUrl string `json:"fullUrl"`
+		}
+		if err := json.Unmarshal(rawEntry, &entry); err != nil || entry.FullUrl == "" {
+			continue
+		}
+		fullUrl, _ := json.Marshal(entry.FullUrl)
+		for _, other := range referencing {
+			if bytes.Contains(other, fullUrl) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// uploadSplitBundle splits the bundle in content, which the server rejected as
+// too large with the response tooLarge, into halves and uploads them one after
+// the other. Halves which are still too large are split again up to depth
+// times in total. The upload stops at the first failing half. The results of
+// the halves are combined into one uploadInfo whose entries keep their index
+// in content. If the second half fails, the results of the first half, which
+// is already committed, are kept in the returned uploadInfo, also together
+// with an error.
+func uploadSplitBundle(ctx context.Context, client *fhir.Client, content []byte, tooLarge uploadInfo,
+	depth int) (uploadInfo, error) {
+	halves, mid, err := splitBundle(content)
+	if err != nil {
+		return uploadInfo{}, fmt.Errorf("the server rejected the bundle as too large with status %d and it can't be split: %w",
+			tooLarge.statusCode, err)
+	}
+
+	var infos [2]uploadInfo
+	for i, half := range halves {
+		infos[i], err = postBundle(ctx, client, bytes.NewReader(half), func() int64 {
+			return int64(len(half))
+		})
+		if err == nil && depth > 1 && bundleTooLarge(infos[i]) {
+			infos[i], err = uploadSplitBundle(ctx, client, half, infos[i], depth-1)
+		}
+		if err != nil || infos[i].statusCode != http.StatusOK {
+			if i == 0 {
+				infos[i].splits++
+				return infos[i], err
+			}
+			return combineSplitUploads(infos[0], infos[1], mid), err
+		}
+	}
+	return combineSplitUploads(infos[0], infos[1], mid), nil
+}
+
+// combineSplitUploads combines the results of the uploads of the two halves of
+// a split bundle. The entry indices of the second half are shifted by offset.
+func combineSplitUploads(first uploadInfo, second uploadInfo, offset int) uploadInfo {
+	combined := second
+	combined.bytesOut += first.bytesOut
+	combined.bytesIn += first.bytesIn
+	combined.requestDuration += first.requestDuration
+	combined.processingDuration += first.processingDuration
+	combined.splits += first.splits + 1
+
+	combined.entryStatusCodes = make(map[int]int)
+	combined.entryOutcomes = make(map[int]util.ErrorResponse)
+	for i, info := range []uploadInfo{first, second} {
+		for statusCode, freq := range info.entryStatusCodes {
+			combined.entryStatusCodes[statusCode] += freq
+		}
+		for entryIndex, outcome := range info.entryOutcomes {
+			combined.entryOutcomes[entryIndex+i*offset] = outcome
+		}
+	}
+
+	combined.idMappings = append([]idMapping{}, first.idMappings...)
+	for _, mapping := range second.idMappings {
+		mapping.entryIndex += offset
+		combined.idMappings = append(combined.idMappings, mapping)
+	}
+	return combined
+}
+
+// externalizedAttachments counts the attachments whose inline data was
+// uploaded as separate Binary resource.
+type externalizedAttachments struct {
+	count int
+	bytes int64
+}
+
+// externalizeAttachments uploads the inline data of all attachments of the
+// bundle in content which is larger than threshold bytes as Binary resources
+// and replaces the data by a reference to the Binary resource. Attachments are
+// detected as objects having a contentType and a data string. Returns content
+// unchanged if no attachment was externalized.
+func externalizeAttachments(client *fhir.Client, content []byte, threshold int64) ([]byte, externalizedAttachments, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var bundle any
+	if err := decoder.Decode(&bundle); err != nil {
+		return nil, externalizedAttachments{}, err
+	}
+
+	var externalized externalizedAttachments
+	err := visitAttachments(bundle, func(attachment map[string]any) error {
+		data, _ := attachment["data"].(string)
+		if int64(len(data)) <= threshold {
+			return nil
+		}
+		contentType, _ := attachment["contentType"].(string)
+		binary, err := json.Marshal(fm.Binary{ContentType: contentType, Data: &data})
+		if err != nil {
+			return err
+		}
+		id, err := createResource(client, "Binary", "", bytes.NewReader(binary), int64(len(binary)))
+		if err != nil {
+			return err
+		}
+		delete(attachment, "data")
+		attachment["url"] = "Binary/" + id
+		if _, ok := attachment["size"]; !ok {
+			if decoded, err := base64.StdEncoding.DecodeString(data); err == nil {
+				attachment["size"] = len(decoded)
+			}
+		}
+		externalized.count++
+		externalized.bytes += int64(len(data))
+		return nil
+	})
+	if err != nil || externalized.count == 0 {
+		return content, externalized, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(bundle); err != nil {
+		return nil, externalized, err
+	}
+	return buf.Bytes(), externalized, nil
+}
+
+// visitAttachments calls visit with every attachment found in the decoded
+// JSON value v. Binary resources themselves are skipped.
+func visitAttachments(v any, visit func(map[string]any) error) error {
+	switch value := v.(type) {
+	case map[string]any:
+		if value["resourceType"] == "Binary" {
+			return nil
+		}
+		_, hasContentType := value["contentType"].(string)
+		_, hasData := value["data"].(string)
+		if hasContentType && hasData {
+			return visit(value)
+		}
+		for _, child := range value {
+			if err := visitAttachments(child, visit); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, child := range value {
+			if err := visitAttachments(child, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// awaitAsyncUpload polls the status endpoint at location of an async
+// transaction until it completes. Returns the status code of the transaction
+// together with the transaction response bundle on success or its outcome on
+// failure. Polling stops if ctx is cancelled.
+func awaitAsyncUpload(ctx context.Context, client *fhir.Client, location string, wait time.Duration,
+	timeout <-chan time.Time) (int, []byte, error) {
+	select {
+	case <-ctx.Done():
+		return 0, nil, fmt.Errorf("stopped polling the status endpoint %s: %w", location, ctx.Err())
+	case <-timeout:
+		return 0, nil, fmt.Errorf("poll timeout of %s exceeded while waiting on status endpoint %s", pollTimeout, location)
+	case <-time.After(wait):
+		req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == 200 {
+			entries, err := readAsyncResponseEntries(resp.Body)
+			if err != nil {
+				return 0, nil, err
+			}
+			if len(entries) != 1 {
+				return 0, nil, fmt.Errorf("expected one entry in async response Bundle but was %d entries", len(entries))
+			}
+			errorResponse := entryErrorResponse(entries[0].Response)
+			if errorResponse.StatusCode == 0 {
+				return 0, nil, fmt.Errorf("error in async response Bundle of status endpoint %s:\n\n%s",
+					location, errorResponse.String())
+			}
+			if isSuccessfulStatus(errorResponse.StatusCode) {
+				return errorResponse.StatusCode, entries[0].Resource, nil
+			}
+			return errorResponse.StatusCode, entries[0].Response.Outcome, nil
+		} else if resp.StatusCode == 202 {
+			// exponential wait up to 10 seconds unless the server tells us otherwise
+			if wait < 10*time.Second {
+				wait *= 2
+			}
+			return awaitAsyncUpload(ctx, client, location, retryAfter(resp, wait), timeout)
+		} else {
+			return 0, nil, fmt.Errorf("error while polling the status endpoint %s:\n\n%w", location,
+				fhir.ReadHTTPStatusError(resp))
+		}
+	}
+}
+
 type bundleUploadResult struct {
 	id         bundleIdentifier
 	uploadInfo uploadInfo
 	err        error
-	duration   time.Duration
+}
+
+// entryIdentifier identifies a single entry of an uploaded bundle.
+type entryIdentifier struct {
+	bundleId   bundleIdentifier
+	entryIndex int
 }
 
 type aggregatedUploadResults struct {
 	totalProcessedBundles                 int
-	requestDurations, processingDurations []float64
+	requestDurations, processingDurations util.DurationDigest // without the warm-up
 	totalBytesIn, totalBytesOut           int64
 	errorResponses                        map[bundleIdentifier]util.ErrorResponse
 	errors                                map[bundleIdentifier]error
+	entryStatusCodes                      map[int]int
+	entryOutcomes                         map[entryIdentifier]util.ErrorResponse
+	slowBundles                           []slowBundle
+	externalized                          externalizedAttachments
+	idMapErr                              error
+	warmupRequests, warmupProcessings     int
+	splitBundles, splits                  int // bundles split because they were too large
+	skipped, overwritten                  int // bundles rejected with a conflict
+	skippedStatusCodes                    map[int]int
+	verified                              int
+	mismatches                            map[entryIdentifier]string
 }
 
+// slowBundle is a bundle whose upload took at least the --slow-threshold.
+type slowBundle struct {
+	id       bundleIdentifier
+	duration time.Duration
+	bytesOut int64
+}
+
+// slowestBundles returns the n slowest of bundles, slowest first.
+func slowestBundles(bundles []slowBundle, n int) []slowBundle {
+	sorted := make([]slowBundle, len(bundles))
+	copy(sorted, bundles)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].duration > sorted[j].duration
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// newUploadAggregator creates an aggregator of the results of bundle uploads
+// which also advances progress and writes the id mappings of all successful
+// uploads to idMapWriter if given.
+func newUploadAggregator(progress progress, idMapWriter *csv.Writer) *util.Aggregator[bundleUploadResult, aggregatedUploadResults] {
+	start := time.Now()
+	results := &aggregatedUploadResults{
+		errorResponses:   make(map[bundleIdentifier]util.ErrorResponse),
+		errors:           make(map[bundleIdentifier]error),
+		entryStatusCodes:   make(map[int]int),
+		entryOutcomes:      make(map[entryIdentifier]util.ErrorResponse),
+		mismatches:         make(map[entryIdentifier]string),
+		skippedStatusCodes: make(map[int]int),
+	}
+	return util.NewAggregator(results, func(results *aggregatedUploadResults, uploadResult bundleUploadResult) {
+		progress.increment(countSuccessful(uploadResult.uploadInfo.entryStatusCodes), uploadResult.uploadInfo.bytesOut)
+		results.add(uploadResult, statsWarmup.includes(results.totalProcessedBundles, time.Since(start)), idMapWriter)
+	}, (*aggregatedUploadResults).clone)
+}
+
+// add adds the result of one bundle upload. Durations of bundles uploaded
+// during the warm-up are only counted.
+func (results *aggregatedUploadResults) add(uploadResult bundleUploadResult, inWarmup bool, idMapWriter *csv.Writer) {
+	results.totalProcessedBundles += 1
+
+	// attachments externalized and splits done before an error count as well
+	results.externalized.count += uploadResult.uploadInfo.externalized.count
+	results.externalized.bytes += uploadResult.uploadInfo.externalized.bytes
+	if uploadResult.uploadInfo.splits > 0 {
+		results.splitBundles++
+		results.splits += uploadResult.uploadInfo.splits
+	}
+	if uploadResult.err != nil {
+		results.errors[uploadResult.id] = uploadResult.err
+		results.addEntries(uploadResult, idMapWriter)
+		results.totalBytesIn += uploadResult.uploadInfo.bytesIn
+		results.totalBytesOut += uploadResult.uploadInfo.bytesOut
+		return
+	}
+	if uploadResult.uploadInfo.statusCode == http.StatusOK {
+		if inWarmup {
+			results.warmupProcessings++
+		} else {
+			results.processingDurations.Add(uploadResult.uploadInfo.processingDuration.Seconds())
+		}
+		results.addEntries(uploadResult, idMapWriter)
+		if uploadResult.uploadInfo.conflict == "overwrite" {
+			results.overwritten++
+		}
+		results.verified += uploadResult.uploadInfo.verified
+		for entryIndex, mismatch := range uploadResult.uploadInfo.mismatches {
+			results.mismatches[entryIdentifier{bundleId: uploadResult.id, entryIndex: entryIndex}] = mismatch
+		}
+	} else if uploadResult.uploadInfo.conflict == "skip" {
+		results.skipped++
+		results.skippedStatusCodes[uploadResult.uploadInfo.statusCode]++
+	} else {
+		results.errorResponses[uploadResult.id] = util.ErrorResponse{
+			StatusCode:       uploadResult.uploadInfo.statusCode,
+			RequestId:        uploadResult.uploadInfo.requestId,
+			CorrelationId:    uploadResult.uploadInfo.correlationId,
+			OperationOutcome: util.ReadOperationOutcome(uploadResult.uploadInfo.error),
+		}
+		// entries of committed halves of a split bundle
+		results.addEntries(uploadResult, idMapWriter)
+	}
+	results.totalBytesIn += uploadResult.uploadInfo.bytesIn
+	results.totalBytesOut += uploadResult.uploadInfo.bytesOut
+	if inWarmup {
+		results.warmupRequests++
+	} else {
+		results.requestDurations.Add(uploadResult.uploadInfo.requestDuration.Seconds())
+	}
+	if slowThreshold > 0 && uploadResult.uploadInfo.requestDuration >= slowThreshold {
+		results.slowBundles = append(results.slowBundles, slowBundle{
+			id:       uploadResult.id,
+			duration: uploadResult.uploadInfo.requestDuration,
+			bytesOut: uploadResult.uploadInfo.bytesOut,
+		})
+	}
+}
+
+// addEntries adds the status codes, outcomes and id mappings of the entries of
+// uploadResult. Failed bundles have entries only if they were split and some
+// of their halves were committed before.
+func (results *aggregatedUploadResults) addEntries(uploadResult bundleUploadResult, idMapWriter *csv.Writer) {
+	for statusCode, freq := range uploadResult.uploadInfo.entryStatusCodes {
+		results.entryStatusCodes[statusCode] += freq
+	}
+	for entryIndex, outcome := range uploadResult.uploadInfo.entryOutcomes {
+		outcome.RequestId = uploadResult.uploadInfo.requestId
+		outcome.CorrelationId = uploadResult.uploadInfo.correlationId
+		results.entryOutcomes[entryIdentifier{bundleId: uploadResult.id, entryIndex: entryIndex}] = outcome
+	}
+	if idMapWriter != nil && results.idMapErr == nil && len(uploadResult.uploadInfo.idMappings) > 0 {
+		results.idMapErr = writeIdMappings(idMapWriter, uploadResult.id, uploadResult.uploadInfo.idMappings)
+	}
+}
+
+// clone returns a copy of results which doesn't share slices and maps.
+func (results *aggregatedUploadResults) clone() aggregatedUploadResults {
+	c := *results
+	c.requestDurations = results.requestDurations.Clone()
+	c.processingDurations = results.processingDurations.Clone()
+	c.slowBundles = slices.Clone(results.slowBundles)
+	c.errorResponses = maps.Clone(results.errorResponses)
+	c.errors = maps.Clone(results.errors)
+	c.entryStatusCodes = maps.Clone(results.entryStatusCodes)
+	c.entryOutcomes = maps.Clone(results.entryOutcomes)
+	c.mismatches = maps.Clone(results.mismatches)
+	c.skippedStatusCodes = maps.Clone(results.skippedStatusCodes)
+	return c
+}
+
+// aggregateUploadResults aggregates all results received from uploadResultCh
+// and sends the final statistics to aggregatedUploadResultsCh.
 func aggregateUploadResults(
 	uploadResultCh chan bundleUploadResult,
 	aggregatedUploadResultsCh chan aggregatedUploadResults,
-	progress progress) {
-
-	var totalProcessedBundles int
-	var requestDurations []float64
-	var processingDurations []float64
-	var totalBytesIn int64
-	var totalBytesOut int64
-	errorResponses := make(map[bundleIdentifier]util.ErrorResponse)
-	errs := make(map[bundleIdentifier]error)
+	progress progress,
+	idMapWriter *csv.Writer) {
 
+	aggregator := newUploadAggregator(progress, idMapWriter)
 	for uploadResult := range uploadResultCh {
-		progress.increment(uploadResult.duration)
-		totalProcessedBundles += 1
+		aggregator.Add(uploadResult)
+	}
+	aggregatedUploadResultsCh <- aggregator.Final()
+}
 
-		if uploadResult.err != nil {
-			errs[uploadResult.id] = uploadResult.err
-		} else {
-			if uploadResult.uploadInfo.statusCode == http.StatusOK {
-				processingDurations = append(processingDurations, uploadResult.uploadInfo.processingDuration.Seconds())
-			} else {
-				operationOutcome, err := fm.UnmarshalOperationOutcome(uploadResult.uploadInfo.error)
-				if err != nil {
-					errorResponses[uploadResult.id] = util.ErrorResponse{
-						StatusCode: uploadResult.uploadInfo.statusCode,
-						OtherError: string(uploadResult.uploadInfo.error),
-					}
-				} else {
-					errorResponses[uploadResult.id] = util.ErrorResponse{
-						StatusCode:       uploadResult.uploadInfo.statusCode,
-						OperationOutcome: &operationOutcome,
-					}
-				}
-			}
-			totalBytesIn += uploadResult.uploadInfo.bytesIn
-			totalBytesOut += uploadResult.uploadInfo.bytesOut
-			requestDurations = append(requestDurations, uploadResult.uploadInfo.requestDuration.Seconds())
+// failedEntries returns the number of bundle entries with a non-successful
+// status code.
+func (results aggregatedUploadResults) failedEntries() int {
+	var failed int
+	for statusCode, freq := range results.entryStatusCodes {
+		if !isSuccessfulStatus(statusCode) {
+			failed += freq
 		}
 	}
+	return failed
+}
 
-	aggregatedUploadResultsCh <- aggregatedUploadResults{
-		totalProcessedBundles: totalProcessedBundles,
-		requestDurations:      requestDurations,
-		processingDurations:   processingDurations,
-		totalBytesIn:          totalBytesIn,
-		totalBytesOut:         totalBytesOut,
-		errorResponses:        errorResponses,
-		errors:                errs,
+// uploadedResources returns the number of bundle entries with a successful
+// status code. As every entry of a transaction bundle holds one resource, that
+// is the number of resources uploaded.
+func (results aggregatedUploadResults) uploadedResources() int {
+	return countSuccessful(results.entryStatusCodes)
+}
+
+// countSuccessful sums up the frequencies of all successful status codes.
+func countSuccessful(statusCodeFrequencies map[int]int) int {
+	var count int
+	for statusCode, freq := range statusCodeFrequencies {
+		if isSuccessfulStatus(statusCode) {
+			count += freq
+		}
 	}
+	return count
 }
 
 type processableFiles struct {
@@ -247,7 +980,19 @@ type processableFiles struct {
 	multiBundleFiles  []string
 }
 
+// findProcessableFiles finds the bundle files in dir and all its
+// subdirectories. On Windows, dir is made absolute first, because only
+// absolute paths can exceed the limit of 260 characters.
 func findProcessableFiles(dir string) (processableFiles, error) {
+	if runtime.GOOS == "windows" {
+		if absDir, err := filepath.Abs(dir); err == nil {
+			dir = absDir
+		}
+	}
+	return walkProcessableFiles(dir)
+}
+
+func walkProcessableFiles(dir string) (processableFiles, error) {
 	dirEntries, err := os.ReadDir(dir)
 	if err != nil {
 		return processableFiles{}, err
@@ -258,7 +1003,7 @@ func findProcessableFiles(dir string) (processableFiles, error) {
 	for _, dirEntry := range dirEntries {
 		name := dirEntry.Name()
 		if dirEntry.IsDir() {
-			subProcFiles, err := findProcessableFiles(filepath.Join(dir, name))
+			subProcFiles, err := walkProcessableFiles(filepath.Join(dir, name))
 			if err != nil {
 				return procFiles, err
 			}
@@ -277,52 +1022,74 @@ func findProcessableFiles(dir string) (processableFiles, error) {
 }
 
 func isSingleBundleFile(name string) bool {
-	return strings.HasSuffix(name, ".json") ||
-		strings.HasSuffix(name, ".json.gz") ||
-		strings.HasSuffix(name, ".json.bz2")
+	return hasSuffixFold(name, ".json") ||
+		hasSuffixFold(name, ".json.gz") ||
+		hasSuffixFold(name, ".json.bz2")
 }
 
 func isMultiBundleFile(name string) bool {
-	return strings.HasSuffix(name, ".ndjson")
+	return hasSuffixFold(name, ".ndjson")
+}
+
+// hasSuffixFold returns whether name ends with suffix ignoring case, so that
+// files like BUNDLE.JSON created on Windows are found as well.
+func hasSuffixFold(name string, suffix string) bool {
+	return len(name) >= len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix)
 }
 
 type uploadBundleProductionSummary struct {
 	singleBundlesFiles int
 	multiBundlesFiles  int
-	bundles            []bundle
+	bundles            int
 }
 
 type uploadBundleProducer struct {
-	res chan bundle
+	res        chan bundle
+	progress   progress
+	numBundles atomic.Int64
+	// number of multi-bundle files inspected in parallel
+	inspectionConcurrency int
 }
 
-func newUploadBundleProducer() *uploadBundleProducer {
+func newUploadBundleProducer(progress progress, inspectionConcurrency int) *uploadBundleProducer {
 	return &uploadBundleProducer{
-		res: make(chan bundle),
+		res:                   make(chan bundle),
+		progress:              progress,
+		inspectionConcurrency: inspectionConcurrency,
 	}
 }
 
-func (ubp *uploadBundleProducer) createUploadBundles(f processableFiles) *uploadBundleProductionSummary {
+// createUploadBundles starts to inspect the files in f and publishes the found
+// bundles on the res channel of the producer as soon as they are known. That
+// way uploads can start while huge files are still being inspected. The
+// summary is published on the returned channel after the res channel is
+// closed.
+func (ubp *uploadBundleProducer) createUploadBundles(f processableFiles) <-chan uploadBundleProductionSummary {
+	summaryCh := make(chan uploadBundleProductionSummary, 1)
+
 	var producerWg sync.WaitGroup
 	producerWg.Add(2)
 	go ubp.createUploadBundlesFromSingleBundleFiles(f.singleBundleFiles, &producerWg)
 	go ubp.createUploadBundlesFromMultiBundleFiles(f.multiBundleFiles, &producerWg)
 
-	go func(wg *sync.WaitGroup, bundleCh chan<- bundle) {
+	go func(wg *sync.WaitGroup) {
 		wg.Wait()
-		close(bundleCh)
-	}(&producerWg, ubp.res)
+		close(ubp.res)
+		ubp.progress.doneAddingBundles()
+		summaryCh <- uploadBundleProductionSummary{
+			singleBundlesFiles: len(f.singleBundleFiles),
+			multiBundlesFiles:  len(f.multiBundleFiles),
+			bundles:            int(ubp.numBundles.Load()),
+		}
+	}(&producerWg)
 
-	var bundles []bundle
-	for bundle := range ubp.res {
-		bundles = append(bundles, bundle)
-	}
+	return summaryCh
+}
 
-	return &uploadBundleProductionSummary{
-		singleBundlesFiles: len(f.singleBundleFiles),
-		multiBundlesFiles:  len(f.multiBundleFiles),
-		bundles:            bundles,
-	}
+func (ubp *uploadBundleProducer) publish(b bundle) {
+	ubp.numBundles.Add(1)
+	ubp.progress.addBundle()
+	ubp.res <- b
 }
 
 func (ubp *uploadBundleProducer) createUploadBundlesFromSingleBundleFiles(files []string, wg *sync.WaitGroup) {
@@ -330,78 +1097,192 @@ func (ubp *uploadBundleProducer) createUploadBundlesFromSingleBundleFiles(files
 		func() {
 			f, err := os.Open(file)
 			if err != nil {
-				ubp.res <- bundle{id: bundleIdentifier{filename: file}, err: err}
+				ubp.publish(bundle{id: bundleIdentifier{filename: file}, err: err})
 				return
 			}
 			defer f.Close()
 
 			fInfo, err := f.Stat()
 			if err != nil {
-				ubp.res <- bundle{
+				ubp.publish(bundle{
 					id: bundleIdentifier{
 						filename:     file,
 						bundleNumber: 1,
 					},
 					err: err,
-				}
+				})
 				return
 			}
 
-			ubp.res <- bundle{
+			ubp.publish(bundle{
 				id: bundleIdentifier{
 					filename:     file,
 					bundleNumber: 1,
 					startBytes:   0,
 					endBytes:     fInfo.Size(),
-				}}
+				}})
 		}()
 	}
 	wg.Done()
 }
 
+// createUploadBundlesFromMultiBundleFiles inspects up to inspectionConcurrency
+// files in parallel.
 func (ubp *uploadBundleProducer) createUploadBundlesFromMultiBundleFiles(files []string, wg *sync.WaitGroup) {
+	pool := util.NewWorkerPool(context.Background(), ubp.inspectionConcurrency, 0)
+
 	for _, file := range files {
-		func() {
+		pool.Go(func(context.Context) error {
 			f, err := os.Open(file)
 			if err != nil {
-				ubp.res <- bundle{id: bundleIdentifier{filename: file}, err: err}
-				return
+				return err
 			}
 			defer f.Close()
 
-			reader := bufio.NewReader(f)
+			var size int64
+			if fInfo, err := f.Stat(); err == nil {
+				size = fInfo.Size()
+			}
+			// the chunks are counted in bytes of the file, so that the byte
+			// order mark is only detected here and skipped on upload
+			buffered := bufio.NewReader(f)
+			if _, err := util.DetectBOM(buffered); err != nil {
+				return err
+			}
+			scanReader, done := ubp.progress.trackFileScan(file, size, buffered)
+			defer done()
+
 			calcRes := make(chan util.FileChunkCalculationResult)
 
-			go util.CalculateFileChunks(reader, MultiBundleFileBundleDelimiter, calcRes)
+			go util.CalculateFileChunks(scanReader, MultiBundleFileBundleDelimiter, calcRes)
 
 			for res := range calcRes {
 				if res.Err != nil {
-					ubp.res <- bundle{
+					ubp.publish(bundle{
 						id: bundleIdentifier{
 							filename:     file,
 							bundleNumber: res.FileChunk.ChunkNumber,
 						},
 						err: res.Err,
-					}
+					})
 				} else {
 					if res.FileChunk.StartBytes == res.FileChunk.EndBytes {
 						continue
 					}
-					ubp.res <- bundle{
+					ubp.publish(bundle{
 						id: bundleIdentifier{
 							filename:     file,
 							bundleNumber: res.FileChunk.ChunkNumber,
 							startBytes:   res.FileChunk.StartBytes,
 							endBytes:     res.FileChunk.EndBytes,
 						},
-					}
+					})
 				}
 			}
-		}()
+			return nil
+		}, func(err error) {
+			if err != nil {
+				ubp.publish(bundle{id: bundleIdentifier{filename: file}, err: err})
+			}
+		})
 	}
+	pool.Wait()
 	wg.Done()
 }
 
+// readBundleContent reads the whole, possibly decompressed, content of the
+// bundle identified by bundleId.
+func readBundleContent(bundleId bundleIdentifier) ([]byte, error) {
+	file, err := os.Open(bundleId.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, _, err := openBundle(file, &bundleId)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+type localBundle struct {
+	ResourceType string `json:"resourceType"`
+	Type         string `json:"type"`
+}
+
+// validateLocalBundle checks that data is a JSON transaction or batch Bundle.
+// In case of an error, it also returns the line within data at which the
+// error was detected.
+func validateLocalBundle(data []byte) (int, error) {
+	var b localBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		var offset int64
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		} else if errors.As(err, &typeErr) {
+			offset = typeErr.Offset
+		}
+		if offset > int64(len(data)) {
+			offset = int64(len(data))
+		}
+		return 1 + bytes.Count(data[:offset], []byte{'\n'}), fmt.Errorf("invalid JSON: %w", err)
+	}
+	if b.ResourceType != "Bundle" {
+		return 1, fmt.Errorf("expected resourceType `Bundle` but was `%s`", b.ResourceType)
+	}
+	if b.Type != "transaction" && b.Type != "batch" {
+		return 1, fmt.Errorf("expected Bundle type `transaction` or `batch` but was `%s`", b.Type)
+	}
+	return 1, nil
+}
+
+// localValidationError describes a bundle that failed local validation.
+type localValidationError struct {
+	id   bundleIdentifier
+	line int
+	err  error
+}
+
+// validateLocalBundles reads all bundles from the channel and validates them
+// locally. Bundles which couldn't be identified in the first place are
+// returned unchanged, so that their errors are reported as upload errors.
+func validateLocalBundles(bundles <-chan bundle) ([]bundle, []localValidationError) {
+	var validBundles []bundle
+	var validationErrors []localValidationError
+	for b := range bundles {
+		if b.err != nil {
+			validBundles = append(validBundles, b)
+			continue
+		}
+		data, err := readBundleContent(b.id)
+		if err != nil {
+			validBundles = append(validBundles, bundle{id: b.id, err: err})
+			continue
+		}
+		line, err := validateLocalBundle(data)
+		if err != nil {
+			// each bundle of a multi-bundle file occupies exactly one line
+			if isMultiBundleFile(b.id.filename) {
+				line = b.id.bundleNumber
+			}
+			validationErrors = append(validationErrors, localValidationError{id: b.id, line: line, err: err})
+			continue
+		}
+		validBundles = append(validBundles, b)
+	}
+	sort.Slice(validationErrors, func(i, j int) bool {
+		a, b := validationErrors[i].id, validationErrors[j].id
+		if a.filename != b.filename {
+			return a.filename < b.filename
+		}
+		return a.bundleNumber < b.bundleNumber
+	})
+	return validBundles, validationErrors
+}
+
 type uploadBundleConsumer struct {
 	client        *fhir.Client
 	uploadResults chan<- bundleUploadResult
@@ -414,42 +1295,112 @@ func newUploadBundleConsumer(client *fhir.Client, uploadResults chan<- bundleUpl
 	}
 }
 
-func (consumer *uploadBundleConsumer) uploadBundles(uploadBundles []bundle, concurrency int, wg *sync.WaitGroup) {
-	limiter := make(chan bool, concurrency)
-
-	for _, queueItem := range uploadBundles {
-		limiter <- true
-		wg.Add(1)
-		go func(b bundle, limiter <-chan bool, wg *sync.WaitGroup) {
-			defer func() { <-limiter }()
-			if b.err != nil {
-				consumer.uploadResults <- bundleUploadResult{id: b.id, err: b.err}
+// uploadBundles uploads all bundles with the given concurrency. Stops taking
+// new bundles as soon as ctx is cancelled and returns after all running
+// uploads finished. A panic during an upload fails its bundle.
+func (consumer *uploadBundleConsumer) uploadBundles(ctx context.Context, uploadBundles <-chan bundle, concurrency int) {
+	pool := util.NewWorkerPool(ctx, concurrency, 0)
+	defer pool.Wait()
+
+	for {
+		var queueItem bundle
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case queueItem, ok = <-uploadBundles:
+			if !ok {
+				return
+			}
+		}
+		if queueItem.err != nil {
+			consumer.uploadResults <- bundleUploadResult{id: queueItem.id, err: queueItem.err}
+			continue
+		}
+		var info uploadInfo
+		pool.Go(func(ctx context.Context) error {
+			var err error
+			info, err = uploadBundle(ctx, consumer.client, &queueItem.id)
+			return err
+		}, func(err error) {
+			if err != nil {
+				// the uploadInfo holds the results of already committed halves of split bundles
+				consumer.uploadResults <- bundleUploadResult{id: queueItem.id, uploadInfo: info, err: err}
 			} else {
-				start := time.Now()
-				if uploadInfo, err := uploadBundle(consumer.client, &b.id); err != nil {
-					consumer.uploadResults <- bundleUploadResult{id: b.id, err: err, duration: time.Duration(time.Since(start).Nanoseconds() / int64(concurrency))}
-				} else {
-					consumer.uploadResults <- bundleUploadResult{id: b.id, uploadInfo: uploadInfo, duration: time.Duration(time.Since(start).Nanoseconds() / int64(concurrency))}
-				}
+				consumer.uploadResults <- bundleUploadResult{id: queueItem.id, uploadInfo: info}
 			}
-			wg.Done()
-		}(queueItem, limiter, wg)
+		})
 	}
 }
 
 type progress interface {
-	increment(duration time.Duration)
+	// addBundle increases the number of bundles to upload by one.
+	addBundle()
+	// doneAddingBundles signals that the number of bundles to upload is final.
+	doneAddingBundles()
+	// trackFileScan wraps r, which reads the file with the given name and size,
+	// in order to show the progress of inspecting the file. The returned
+	// function has to be called after the inspection ended.
+	trackFileScan(filename string, size int64, r io.Reader) (io.Reader, func())
+	increment(resources int, bytes int64)
+	// abort stops the progress before all bundles are uploaded, so that wait
+	// returns.
+	abort()
 	wait()
 }
 
 type realProgress struct {
-	progress *mpb.Progress
-	bar      *mpb.Bar
+	progress   *mpb.Progress
+	bar        *mpb.Bar
+	resources  *atomic.Int64
+	bytes      *atomic.Int64
+	totalMutex *sync.Mutex
+	total      *int64
 }
 
-func (rP realProgress) increment(duration time.Duration) {
+func (rP realProgress) addBundle() {
+	rP.totalMutex.Lock()
+	defer rP.totalMutex.Unlock()
+	*rP.total++
+	rP.bar.SetTotal(*rP.total, false)
+}
+
+func (rP realProgress) doneAddingBundles() {
+	rP.totalMutex.Lock()
+	defer rP.totalMutex.Unlock()
+	if *rP.total == 0 {
+		rP.bar.SetTotal(-1, true)
+	} else {
+		rP.bar.EnableTriggerComplete()
+	}
+}
+
+func (rP realProgress) trackFileScan(filename string, size int64, r io.Reader) (io.Reader, func()) {
+	if size <= 0 {
+		return r, func() {}
+	}
+	bar := rP.progress.AddBar(size,
+		mpb.BarRemoveOnComplete(),
+		mpb.PrependDecorators(
+			decor.Name("scan", decor.WC{W: 7, C: decor.DidentRight}),
+			decor.Name(filepath.Base(filename), decor.WC{W: len(filepath.Base(filename)) + 1, C: decor.DidentRight}),
+		),
+		mpb.AppendDecorators(
+			decor.Percentage(decor.WC{W: 5}),
+			decor.Counters(decor.UnitKiB, " % .1f / % .1f"),
+		),
+	)
+	return bar.ProxyReader(r), func() { bar.Abort(true) }
+}
+
+func (rP realProgress) increment(resources int, bytes int64) {
+	rP.resources.Add(int64(resources))
+	rP.bytes.Add(bytes)
 	rP.bar.Increment()
-	rP.bar.DecoratorEwmaUpdate(duration)
+}
+
+func (rP realProgress) abort() {
+	rP.bar.Abort(false)
 }
 
 func (rP realProgress) wait() {
@@ -459,37 +1410,302 @@ func (rP realProgress) wait() {
 type noopProgress struct {
 }
 
-func (nP noopProgress) increment(_ time.Duration) {
+func (nP noopProgress) addBundle() {
 	// nothing to do here
 }
 
+func (nP noopProgress) doneAddingBundles() {
+	// nothing to do here
+}
+
+func (nP noopProgress) trackFileScan(_ string, _ int64, r io.Reader) (io.Reader, func()) {
+	return r, func() {}
+}
+
+func (nP noopProgress) increment(_ int, _ int64) {
+	// nothing to do here
+}
+
+func (nP noopProgress) abort() {
+}
+
 func (nP noopProgress) wait() {
 	// nothing to do here
 }
 
-func createRealProgress(numBundles int) progress {
+// fmtThroughput formats the number of resources and bytes per second
+// transferred in elapsed. Returns an empty string if no time elapsed.
+func fmtThroughput(resources int64, bytes int64, elapsed time.Duration) string {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.0f res/s, %s/s", float64(resources)/seconds,
+		util.FmtBytesHumanReadable(float32(float64(bytes)/seconds)))
+}
+
+// throughputWindow is the time span over which the current throughput is
+// measured.
+const throughputWindow = 5 * time.Second
+
+// throughputSample is the number of resources and bytes transferred up to a
+// time.
+type throughputSample struct {
+	time      time.Time
+	resources int64
+	bytes     int64
+}
+
+// throughputMeter measures the current throughput over a sliding window of
+// samples of the counters, so that it follows stalls and slow starts instead
+// of averaging over the whole run.
+type throughputMeter struct {
+	mutex     sync.Mutex
+	now       func() time.Time
+	resources *atomic.Int64
+	bytes     *atomic.Int64
+	samples   []throughputSample
+}
+
+func newThroughputMeter(now func() time.Time, resources *atomic.Int64, bytes *atomic.Int64) *throughputMeter {
+	return &throughputMeter{now: now, resources: resources, bytes: bytes,
+		samples: []throughputSample{{now(), resources.Load(), bytes.Load()}}}
+}
+
+// rate samples the counters and formats the throughput since the newest
+// sample which is at least throughputWindow old, or since the oldest sample.
+func (m *throughputMeter) rate() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	sample := throughputSample{m.now(), m.resources.Load(), m.bytes.Load()}
+	m.samples = append(m.samples, sample)
+	for len(m.samples) > 2 && sample.time.Sub(m.samples[1].time) >= throughputWindow {
+		m.samples = m.samples[1:]
+	}
+	first := m.samples[0]
+	return fmtThroughput(sample.resources-first.resources, sample.bytes-first.bytes, sample.time.Sub(first.time))
+}
+
+// throughputDecorator displays the current number of resources and bytes
+// transferred per second measured by meter.
+func throughputDecorator(meter *throughputMeter) decor.Decorator {
+	return decor.Any(func(_ decor.Statistics) string {
+		return meter.rate()
+	}, decor.WC{W: 24})
+}
+
+// createRealProgress creates a progress with an upload bar whose total grows
+// with each added bundle.
+func createRealProgress() progress {
 	p := mpb.New()
+	resources := &atomic.Int64{}
+	bytes := &atomic.Int64{}
+	var total int64
 	return realProgress{progress: p,
-		bar: p.AddBar(int64(numBundles),
+		bar: p.AddBar(0,
 			mpb.BarRemoveOnComplete(),
 			mpb.PrependDecorators(
 				decor.Name("upload", decor.WC{W: 7, C: decor.DidentRight}),
-				decor.OnComplete(decor.EwmaETA(decor.ET_STYLE_GO, 60, decor.WC{W: 4}), "done"),
+				decor.OnComplete(decor.AverageETA(decor.ET_STYLE_GO, decor.WC{W: 4}), "done"),
+			),
+			mpb.AppendDecorators(
+				decor.Percentage(decor.WC{W: 5}),
+				throughputDecorator(newThroughputMeter(time.Now, resources, bytes)),
 			),
-			mpb.AppendDecorators(decor.Percentage()),
 		),
+		resources:  resources,
+		bytes:      bytes,
+		totalMutex: &sync.Mutex{},
+		total:      &total,
 	}
 }
 
-func createProgress(numBundles int) progress {
-	if noProgress {
-		return noopProgress{}
-	} else {
-		return createRealProgress(numBundles)
+// fmtStatusCodeFrequencies formats status code frequencies as comma separated
+// code:count pairs ordered by status code.
+func fmtStatusCodeFrequencies(frequencies map[int]int) string {
+	codes := make([]int, 0, len(frequencies))
+	for statusCode := range frequencies {
+		codes = append(codes, statusCode)
+	}
+	sort.Ints(codes)
+	pairs := make([]string, 0, len(codes))
+	for _, statusCode := range codes {
+		pairs = append(pairs, fmt.Sprintf("%d:%d", statusCode, frequencies[statusCode]))
 	}
+	return strings.Join(pairs, ", ")
 }
 
-var concurrency int
+// fmtSlowBundles formats the slow bundles one per line with their duration
+// and the number of bytes sent.
+func fmtSlowBundles(units util.UnitFormat, bundles []slowBundle) string {
+	builder := strings.Builder{}
+	for _, b := range bundles {
+		builder.WriteString(fmt.Sprintf("File: %s [Bundle: %d] : %s, %s\n", b.id.filename, b.id.bundleNumber,
+			units.Duration(b.duration), units.Bytes(float64(b.bytesOut))))
+	}
+	return builder.String()
+}
+
+// sortedEntryIdentifiers returns the keys of the given map ordered by
+// filename, bundle number and entry index.
+func sortedEntryIdentifiers[V any](entryOutcomes map[entryIdentifier]V) []entryIdentifier {
+	ids := make([]entryIdentifier, 0, len(entryOutcomes))
+	for id := range entryOutcomes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ids[i], ids[j]
+		if a.bundleId.filename != b.bundleId.filename {
+			return a.bundleId.filename < b.bundleId.filename
+		}
+		if a.bundleId.bundleNumber != b.bundleId.bundleNumber {
+			return a.bundleId.bundleNumber < b.bundleId.bundleNumber
+		}
+		return a.entryIndex < b.entryIndex
+	})
+	return ids
+}
+
+var inspectionConcurrency int
+var validateLocal bool
+var idMapFile string
+var uploadPrefer string
+var uploadAsync bool
+var uploadTimeout time.Duration
+var slowThreshold time.Duration
+var slowTop int
+var externalizeThreshold int64
+var maxSplits int
+
+// uploadTag is added to the meta of every uploaded resource if set.
+var uploadTag *fm.Coding
+
+// uploadPreferValues are the values of the return preference a server can be
+// asked for by the --prefer flag.
+var uploadPreferValues = []string{"minimal", "representation", "OperationOutcome"}
+
+func checkUploadPrefer(prefer string) error {
+	for _, value := range uploadPreferValues {
+		if prefer == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --prefer value `%s`, expected one of %s", prefer, strings.Join(uploadPreferValues, ", "))
+}
+
+// fmtUploadReport formats the statistics and error lists of an upload which
+// took duration.
+func fmtUploadReport(units util.UnitFormat, results aggregatedUploadResults, duration time.Duration) string {
+	builder := strings.Builder{}
+	fmt.Fprintf(&builder, "Uploads          [total, concurrency]                  %d, %d\n",
+		results.totalProcessedBundles, concurrency)
+	fmt.Fprintf(&builder, "Success          [ratio]                               %.2f %%\n",
+		float32(results.totalProcessedBundles-len(results.errors)-len(results.errorResponses))/float32(results.totalProcessedBundles)*100)
+	fmt.Fprintf(&builder, "Duration         [total]                               %s\n",
+		units.Duration(duration))
+	uploadedResources := results.uploadedResources()
+	fmt.Fprintf(&builder, "Resources        [total, rate]                         %d, %.2f/s\n",
+		uploadedResources, float64(uploadedResources)/duration.Seconds())
+
+	if statsWarmup.enabled() {
+		fmt.Fprintf(&builder, "Warm-up          [excluded, given]                     %d, %s\n", results.warmupRequests, statsWarmup)
+	}
+
+	if results.requestDurations.Count() > 0 {
+		requestStats := results.requestDurations.Statistics()
+		fmt.Fprintf(&builder, "Requ. Latencies  [min, mean, 50, 95, 99, max, stddev]  %s\n", fmtDurationStatistics(units, requestStats))
+	}
+
+	if results.processingDurations.Count() > 0 {
+		processingStats := results.processingDurations.Statistics()
+		fmt.Fprintf(&builder, "Proc. Latencies  [min, mean, 50, 95, 99, max, stddev]  %s\n", fmtDurationStatistics(units, processingStats))
+	}
+
+	totalTransfers := results.requestDurations.Count() + results.warmupRequests
+	fmt.Fprintf(&builder, "Bytes In         [total, mean]                         %s, %s\n", units.Bytes(float64(results.totalBytesIn)), units.Bytes(float64(results.totalBytesIn)/float64(totalTransfers)))
+	fmt.Fprintf(&builder, "Bytes Out        [total, mean]                         %s, %s\n", units.Bytes(float64(results.totalBytesOut)), units.Bytes(float64(results.totalBytesOut)/float64(totalTransfers)))
+
+	errorFrequencies := make(map[int]int)
+	for _, errorResponse := range results.errorResponses {
+		errorFrequencies[errorResponse.StatusCode]++
+	}
+	statusCodes := []string{fmt.Sprintf("200:%d", results.processingDurations.Count()+results.warmupProcessings)}
+	if len(errorFrequencies) > 0 {
+		statusCodes = append(statusCodes, fmtStatusCodeFrequencies(errorFrequencies))
+	}
+	// conflicts skipped with --on-conflict skip are requests sent as well
+	skippedCodes := make([]int, 0, len(results.skippedStatusCodes))
+	for statusCode := range results.skippedStatusCodes {
+		skippedCodes = append(skippedCodes, statusCode)
+	}
+	sort.Ints(skippedCodes)
+	for _, statusCode := range skippedCodes {
+		statusCodes = append(statusCodes, fmt.Sprintf("%d (skipped):%d", statusCode, results.skippedStatusCodes[statusCode]))
+	}
+	fmt.Fprintf(&builder, "Status Codes     [code:count]                          %s\n", strings.Join(statusCodes, ", "))
+
+	if len(results.entryStatusCodes) > 0 {
+		fmt.Fprintf(&builder, "Entry Statuses   [code:count]                          %s\n", fmtStatusCodeFrequencies(results.entryStatusCodes))
+	}
+	if externalizeThreshold > 0 {
+		fmt.Fprintf(&builder, "Attachments      [externalized, bytes]                 %d, %s\n", results.externalized.count, units.Bytes(float64(results.externalized.bytes)))
+	}
+	if onConflict == "skip" || onConflict == "overwrite" {
+		fmt.Fprintf(&builder, "Conflicts        [skipped, overwritten]                %d, %d\n", results.skipped, results.overwritten)
+	}
+	if verifySample > 0 {
+		fmt.Fprintf(&builder, "Verified         [total, mismatches]                   %d, %d\n", results.verified, len(results.mismatches))
+	}
+	if results.splitBundles > 0 {
+		fmt.Fprintf(&builder, "Split Bundles    [total, splits]                       %d, %d\n", results.splitBundles, results.splits)
+	}
+	if slowThreshold > 0 {
+		fmt.Fprintf(&builder, "Slow Bundles     [total, threshold]                    %d, %s\n", len(results.slowBundles), units.Duration(slowThreshold))
+	}
+
+	if len(results.slowBundles) > 0 {
+		builder.WriteString("\n")
+		fmt.Fprintln(&builder, "Slowest Bundles:")
+		builder.WriteString("\n")
+		builder.WriteString(fmtSlowBundles(units, slowestBundles(results.slowBundles, slowTop)))
+	}
+
+	if len(results.errorResponses) > 0 {
+		builder.WriteString("\n")
+		fmt.Fprintln(&builder, "Non-OK Responses:")
+		builder.WriteString("\n")
+		for bundleId, errorResponse := range results.errorResponses {
+			fmt.Fprintf(&builder, "File: %s [Bundle: %d]\n", bundleId.filename, bundleId.bundleNumber)
+			fmt.Fprintf(&builder, "%s", util.Indent(4, errorResponse.String()))
+		}
+	}
+	if len(results.entryOutcomes) > 0 {
+		builder.WriteString("\n")
+		fmt.Fprintln(&builder, "Entry Outcomes:")
+		builder.WriteString("\n")
+		for _, entryId := range sortedEntryIdentifiers(results.entryOutcomes) {
+			fmt.Fprintf(&builder, "File: %s [Bundle: %d, Entry: %d]\n", entryId.bundleId.filename, entryId.bundleId.bundleNumber, entryId.entryIndex)
+			errorResponse := results.entryOutcomes[entryId]
+			fmt.Fprintf(&builder, "%s", util.Indent(4, errorResponse.String()))
+		}
+	}
+	if len(results.mismatches) > 0 {
+		builder.WriteString("\n")
+		fmt.Fprintln(&builder, "Verification Mismatches:")
+		builder.WriteString("\n")
+		for _, entryId := range sortedEntryIdentifiers(results.mismatches) {
+			fmt.Fprintf(&builder, "File: %s [Bundle: %d, Entry: %d]\n", entryId.bundleId.filename, entryId.bundleId.bundleNumber, entryId.entryIndex)
+			fmt.Fprintf(&builder, "%s\n", util.Indent(4, results.mismatches[entryId]))
+		}
+	}
+	if len(results.errors) > 0 {
+		fmt.Fprintln(&builder, "\nErrors:")
+		for bundleId, err := range results.errors {
+			fmt.Fprintf(&builder, "File: %s [Bundle: %d] : %v\n", bundleId.filename, bundleId.bundleNumber, err.Error())
+		}
+	}
+	return builder.String()
+}
 
 // uploadCmd represents the upload command
 var uploadCmd = &cobra.Command{
@@ -500,6 +1716,84 @@ var uploadCmd = &cobra.Command{
 The upload will be parallel according to the --concurrency flag. A upload 
 statistic will be printed after the upload.
 
+By default, the server is asked to return minimal transaction responses
+which only contain the status and location of each entry. Use --prefer
+representation to get the created resources echoed back, for example while
+debugging, or --prefer OperationOutcome to get an outcome for each entry.
+
+With --async, the server is asked to process each transaction
+asynchronously. Its status endpoint is polled until the transaction is
+completed, so that very large bundles don't run into gateway timeouts. The
+number of outstanding async transactions is bounded by --concurrency.
+
+On interrupt (Ctrl-C), running uploads are aborted and the statistics of
+the bundles processed so far are printed. Use --summary-file to also write
+the statistics and error lists to a file.
+
+Bundles whose upload takes at least --slow-threshold are counted and the
+--slow-top slowest of them are listed in the final report together with
+their size. Use --upload-timeout to abort uploads which take too long.
+
+Bundles the server rejects as too large, either with status 413 or with an
+OperationOutcome of issue code too-long, are split into two halves which are
+uploaded one after the other. Halves which are still too large are split
+again, up to --max-splits times. Each half is a transaction of its own, so a
+failing half leaves the halves uploaded before it on the server. Bundles whose
+entries reference entries of the other half by fullUrl can't be split and
+fail. The report shows the number of split bundles and of splits needed.
+
+Bundles rejected with a conflict (status 409 or 412), for example because
+they were already imported, fail by default. With --on-conflict skip, such
+bundles are counted as skipped instead of failed. With --on-conflict
+overwrite, the entries of all resources with an id are retried once as
+updates conditioned on the current version of the resource on the server.
+
+With --verify-sample, up to the given number of randomly chosen resources of
+each successfully uploaded bundle are read back from the server and compared
+with the uploaded resources, detecting servers which silently change data.
+The comparison ignores id and meta, resolves references to the fullUrls of
+the bundle to the ids assigned by the server and ignores narratives the
+server generated for resources uploaded without one.
+
+With --warmup, the bundles uploaded during connection setup and server cache
+warm-up are excluded from the latency statistics, which otherwise skew short
+benchmark runs. The warm-up is either a number of bundles, like 10, or a
+duration since the start, like 30s. Totals and rates still cover all bundles.
+
+With --replay-versions, the NDJSON files of the directory contain resources
+instead of bundles, like the output of download or tail, possibly with more
+than one version of the same resource. The versions of each resource are
+ordered by meta.versionId, by meta.lastUpdated if the versionIds aren't
+numeric, or by their position in the files, and uploaded oldest first as
+individual updates, so that the server keeps an approximate version history
+instead of only the latest state. Versions with the same versionId are only
+uploaded once. Different resources are uploaded in parallel according to
+--concurrency. The replay of a resource stops at its first failed version.
+
+With --externalize-attachments, the inline data of attachments larger than
+the given number of bytes is uploaded as separate Binary resource before the
+bundle and replaced by a reference to it, keeping bundles under the size
+limits of the server. Binary resources stay on the server even if the upload
+of their bundle fails.
+
+With --pre-bundle-cmd, each bundle is piped through a shell command before
+the upload and its output is uploaded instead, allowing transformations like
+setting meta.source. With --post-bundle-cmd, the response of the server is
+piped into a shell command after the upload. For split bundles, that is the
+response of the last half. Both commands get the file and number of the
+bundle in the environment variables BLAZECTL_FILE and BLAZECTL_BUNDLE, the
+post-bundle command also the status code in BLAZECTL_STATUS. A command
+exiting with a non-zero status fails the bundle. --validate-local checks the
+bundles before the pre-bundle command.
+
+For simple substitutions, --transform takes a Go template file which is
+executed for each bundle, or resource with --replay-versions, before the
+pre-bundle command. Its output is uploaded. The template gets the JSON in
+.Content, the decoded JSON in .Value, the file in .File and the number of
+the bundle or the line of the resource in .Index. Besides the built-in
+functions of Go templates, replace, env and json are available, like in
+{{ .Content | replace "urn:old" "urn:new" }}.
+
 Example:
 
   blazectl upload my/bundles`,
@@ -519,11 +1813,36 @@ Example:
 		}
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		err := createClient()
+		if err := checkUploadPrefer(uploadPrefer); err != nil {
+			return err
+		}
+		if err := checkOnConflict(onConflict); err != nil {
+			return err
+		}
+		if replayVersions {
+			if err := checkReplayVersions(cmd); err != nil {
+				return err
+			}
+		}
+		var err error
+		if statsWarmup, err = parseWarmup(warmupFlag); err != nil {
+			return err
+		}
+		if transformFile != "" {
+			if uploadTransform, err = parseTransform(transformFile); err != nil {
+				return err
+			}
+		}
+
+		err = createClient()
 		if err != nil {
 			return err
 		}
 
+		if replayVersions {
+			return uploadVersions(args[0])
+		}
+
 		dir := args[0]
 
 		files, err := findProcessableFiles(dir)
@@ -532,92 +1851,112 @@ Example:
 			os.Exit(1)
 		}
 
+		summary := createSummaryFileOrDie()
+		if summary != nil {
+			defer summary.Close()
+		}
+
 		fmt.Printf("Starting Upload to %s ...\n", server)
 
 		// Aggregate results in one single goroutine
 		uploadResultCh := make(chan bundleUploadResult)
 		aggregatedUploadResultsCh := make(chan aggregatedUploadResults)
 
-		fmt.Printf("Inspecting files eligible for upload from %s... ", dir)
-		bundleProducer := newUploadBundleProducer()
-		uploadBundlesSummary := bundleProducer.createUploadBundles(files)
-		fmt.Println("DONE")
-
-		if len(uploadBundlesSummary.bundles) == 0 {
-			fmt.Println("Found no bundles to upload.")
-			os.Exit(0)
+		fmt.Printf("Inspecting and uploading files eligible for upload from %s...\n", dir)
+		progress, err := createProgress()
+		if err != nil {
+			return err
 		}
 
-		fmt.Printf("Found %d bundles in total (from %d JSON files and from %d NDJSON files)\n",
-			len(uploadBundlesSummary.bundles), uploadBundlesSummary.singleBundlesFiles, uploadBundlesSummary.multiBundlesFiles)
-
-		progress := createProgress(len(uploadBundlesSummary.bundles))
-
 		// Loop through bundles
-		var consumerWg sync.WaitGroup
 		start := time.Now()
+		bundleProducer := newUploadBundleProducer(progress, inspectionConcurrency)
+		uploadBundlesSummaryCh := bundleProducer.createUploadBundles(files)
 		bundleConsumer := newUploadBundleConsumer(client, uploadResultCh)
-		go aggregateUploadResults(uploadResultCh, aggregatedUploadResultsCh, progress)
+		var idMapWriter *csv.Writer
+		if idMapFile != "" {
+			file := createOutputFileOrDie(idMapFile)
+			defer file.Close()
+			idMapWriter = csv.NewWriter(file)
+			if err := idMapWriter.Write(idMapHeader); err != nil {
+				return err
+			}
+		}
+		go aggregateUploadResults(uploadResultCh, aggregatedUploadResultsCh, progress, idMapWriter)
+
+		var bundles <-chan bundle = bundleProducer.res
+		if validateLocal {
+			validBundles, validationErrors := validateLocalBundles(bundleProducer.res)
+			if len(validationErrors) > 0 {
+				fmt.Println("\nInvalid bundles:")
+				for _, validationError := range validationErrors {
+					fmt.Printf("File: %s [Line: %d] : %v\n", validationError.id.filename, validationError.line, validationError.err)
+				}
+				os.Exit(1)
+			}
+			bundleCh := make(chan bundle)
+			go func() {
+				for _, b := range validBundles {
+					bundleCh <- b
+				}
+				close(bundleCh)
+			}()
+			bundles = bundleCh
+		}
 
-		bundleConsumer.uploadBundles(uploadBundlesSummary.bundles, concurrency, &consumerWg)
+		// on interrupt, running uploads are aborted and no new ones are started,
+		// so that the statistics of the bundles processed so far can be reported
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
 
-		consumerWg.Wait()
+		bundleConsumer.uploadBundles(ctx, bundles, concurrency)
+
+		interrupted := ctx.Err() != nil
+		stop()
 		close(uploadResultCh)
+		if interrupted {
+			progress.abort()
+		}
 		progress.wait()
 		client.CloseIdleConnections()
 
 		aggResults := <-aggregatedUploadResultsCh
 
-		fmt.Printf("Uploads          [total, concurrency]     %d, %d\n",
-			aggResults.totalProcessedBundles, concurrency)
-		fmt.Printf("Success          [ratio]                  %.2f %%\n",
-			float32(aggResults.totalProcessedBundles-len(aggResults.errors)-len(aggResults.errorResponses))/float32(aggResults.totalProcessedBundles)*100)
-		fmt.Printf("Duration         [total]                  %s\n",
-			util.FmtDurationHumanReadable(time.Since(start)))
+		// the bundle producer isn't waited for on interrupt, because it would
+		// inspect all remaining files
+		if !interrupted {
+			uploadBundlesSummary := <-uploadBundlesSummaryCh
 
-		if len(aggResults.requestDurations) > 0 {
-			requestStats := util.CalculateDurationStatistics(aggResults.requestDurations)
-			fmt.Printf("Requ. Latencies  [mean, 50, 95, 99, max]  %s, %s, %s, %s %s\n",
-				requestStats.Mean, requestStats.Q50, requestStats.Q95, requestStats.Q99, requestStats.Max)
-		}
+			if uploadBundlesSummary.bundles == 0 {
+				fmt.Println("Found no bundles to upload.")
+				os.Exit(0)
+			}
 
-		if len(aggResults.processingDurations) > 0 {
-			processingStats := util.CalculateDurationStatistics(aggResults.requestDurations)
-			fmt.Printf("Proc. Latencies  [mean, 50, 95, 99, max]  %s, %s, %s, %s %s\n",
-				processingStats.Mean, processingStats.Q50, processingStats.Q95, processingStats.Q99, processingStats.Max)
+			fmt.Printf("Found %d bundles in total (from %d JSON files and from %d NDJSON files)\n",
+				uploadBundlesSummary.bundles, uploadBundlesSummary.singleBundlesFiles, uploadBundlesSummary.multiBundlesFiles)
 		}
 
-		totalTransfers := len(aggResults.requestDurations)
-		fmt.Printf("Bytes In         [total, mean]            %s, %s\n", util.FmtBytesHumanReadable(float32(aggResults.totalBytesIn)), util.FmtBytesHumanReadable(float32(aggResults.totalBytesIn)/float32(totalTransfers)))
-		fmt.Printf("Bytes Out        [total, mean]            %s, %s\n", util.FmtBytesHumanReadable(float32(aggResults.totalBytesOut)), util.FmtBytesHumanReadable(float32(aggResults.totalBytesOut)/float32(totalTransfers)))
-
-		errorFrequencies := make(map[int]int)
-		for _, errorResponse := range aggResults.errorResponses {
-			errorFrequencies[errorResponse.StatusCode]++
-		}
-		statusCodes := make([]string, 1, len(errorFrequencies)+1)
-		statusCodes[0] = fmt.Sprintf("200:%d", len(aggResults.processingDurations))
-		for statusCode, freq := range errorFrequencies {
-			statusCodes = append(statusCodes, fmt.Sprintf("%d:%d", statusCode, freq))
+		if idMapWriter != nil {
+			idMapWriter.Flush()
+			if aggResults.idMapErr == nil {
+				aggResults.idMapErr = idMapWriter.Error()
+			}
+			if aggResults.idMapErr != nil {
+				fmt.Printf("Error while writing the id map file %s: %v\n", idMapFile, aggResults.idMapErr)
+			}
 		}
-		fmt.Printf("Status Codes     [code:count]             %s\n", strings.Join(statusCodes, ", "))
 
-		if len(aggResults.errorResponses) > 0 {
-			fmt.Println()
-			fmt.Println("Non-OK Responses:")
-			fmt.Println()
-			for bundleId, errorResponse := range aggResults.errorResponses {
-				fmt.Printf("File: %s [Bundle: %d]\n", bundleId.filename, bundleId.bundleNumber)
-				fmt.Printf("%s", util.Indent(4, errorResponse.String()))
-			}
+		duration := time.Since(start)
+		report := fmtUploadReport(unitFormat(), aggResults, duration)
+		if interrupted {
+			report = "Upload interrupted. The statistics only cover the bundles processed so far.\n" + report
 		}
-		if len(aggResults.errors) > 0 {
-			fmt.Println("\nErrors:")
-			for bundleId, err := range aggResults.errors {
-				fmt.Printf("File: %s [Bundle: %d] : %v\n", bundleId.filename, bundleId.bundleNumber, err.Error())
-			}
+		writeSummary(os.Stdout, summary, report)
+
+		if interrupted {
+			os.Exit(interruptedExitCode)
 		}
-		if len(aggResults.errorResponses) > 0 || len(aggResults.errors) > 0 {
+		if len(aggResults.errorResponses) > 0 || len(aggResults.errors) > 0 || aggResults.failedEntries() > 0 {
 			os.Exit(1)
 		}
 		return nil
@@ -628,7 +1967,32 @@ func init() {
 	rootCmd.AddCommand(uploadCmd)
 
 	uploadCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
-	uploadCmd.Flags().IntVarP(&concurrency, "concurrency", "c", 2, "number of parallel uploads")
+	addConcurrencyFlag(uploadCmd, "number of parallel uploads")
+	uploadCmd.Flags().IntVar(&inspectionConcurrency, "inspection-concurrency", 4, "number of NDJSON files inspected in parallel")
+	uploadCmd.Flags().BoolVar(&validateLocal, "validate-local", false, "check that all bundles are JSON transaction or batch Bundles before uploading any of them")
+	uploadCmd.Flags().StringVar(&idMapFile, "id-map-file", "", "write a CSV file mapping the fullUrl and id of every uploaded entry to its server-assigned location")
+
+	uploadCmd.Flags().BoolVar(&uploadAsync, "async", false, "upload transactions asynchronously and poll their status endpoints")
+	uploadCmd.Flags().DurationVar(&pollInterval, "poll-interval", 100*time.Millisecond, "initial wait between polls of the async status endpoint")
+	uploadCmd.Flags().DurationVar(&pollTimeout, "poll-timeout", 0, "abort polling the async status endpoint after this duration (0 means no timeout)")
+	uploadCmd.Flags().DurationVar(&uploadTimeout, "upload-timeout", 0, "abort the upload of a single bundle after this duration (0 means no timeout)")
+	uploadCmd.Flags().DurationVar(&slowThreshold, "slow-threshold", 0, "report bundles whose upload takes at least this duration (0 means no reporting)")
+	uploadCmd.Flags().IntVar(&slowTop, "slow-top", 10, "number of the slowest bundles to list in the report")
+	uploadCmd.Flags().StringVar(&warmupFlag, "warmup", "", "exclude the first bundles or seconds, like 10 or 30s, from the latency statistics")
+	uploadCmd.Flags().StringVar(&onConflict, "on-conflict", "fail", "strategy for bundles rejected with status 409 or 412, one of fail, skip or overwrite")
+	uploadCmd.Flags().IntVar(&verifySample, "verify-sample", 0, "read back and compare this many random resources of each uploaded bundle (0 disables)")
+	uploadCmd.Flags().IntVar(&maxSplits, "max-splits", 3, "split bundles rejected as too large into halves up to this many times (0 disables)")
+	uploadCmd.Flags().Int64Var(&externalizeThreshold, "externalize-attachments", 0, "upload inline attachment data larger than this many bytes as separate Binary resources (0 disables)")
+	uploadCmd.Flags().StringVar(&transformFile, "transform", "", "transform each bundle or, with --replay-versions, resource with this Go template before the upload")
+	uploadCmd.Flags().StringVar(&preBundleCmd, "pre-bundle-cmd", "", "pipe each bundle through this shell command and upload its output instead")
+	uploadCmd.Flags().StringVar(&postBundleCmd, "post-bundle-cmd", "", "pipe the response to each bundle into this shell command")
+	uploadCmd.Flags().StringVar(&summaryFile, "summary-file", "", "also write the final or, on interrupt, partial statistics and error lists to this file")
+	uploadCmd.Flags().BoolVar(&replayVersions, "replay-versions", false, "upload the resources of NDJSON files as updates, replaying all versions of a resource oldest first")
+	uploadCmd.Flags().StringVar(&uploadPrefer, "prefer", "minimal", "return preference for transaction responses, one of minimal, representation or OperationOutcome")
 
 	_ = uploadCmd.MarkFlagRequired("server")
+	_ = uploadCmd.MarkFlagFilename("id-map-file", "csv")
+	_ = uploadCmd.RegisterFlagCompletionFunc("prefer", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return uploadPreferValues, cobra.ShellCompDirectiveNoFileComp
+	})
 }
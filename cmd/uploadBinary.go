@@ -0,0 +1,169 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var binaryContentType string
+var wrapBinary bool
+
+// idFromLocation returns the id of the resource of the given type from the
+// Location header of a create response, like
+// http://localhost:8080/fhir/Binary/DD7BYDLGQTG6DPRV/_history/1.
+func idFromLocation(location string, resourceType string) (string, error) {
+	segments := strings.Split(location, "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] == resourceType && segments[i+1] != "" {
+			return segments[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("missing %s id in the Location header `%s`", resourceType, location)
+}
+
+// createResource posts body with the given content type to the endpoint of
+// resourceType and returns the id of the created resource. An empty content
+// type keeps the media type of FHIR requests configured in client. The server
+// is asked to return no representation, so that large content isn't echoed
+// back.
+func createResource(client *fhir.Client, resourceType string, contentType string, body io.Reader,
+	contentLength int64) (string, error) {
+	req, err := client.NewCreateRequest(resourceType, body)
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Prefer", "return=minimal")
+	req.ContentLength = contentLength
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		errorResponse := util.ReadErrorResponse(resp)
+		return "", fmt.Errorf("error while creating a %s:\n\n%s", resourceType, errorResponse.String())
+	}
+	return idFromLocation(resp.Header.Get("Location"), resourceType)
+}
+
+// uploadBinary streams the content of the file at path with the given content
+// type to the Binary endpoint and returns the id of the created Binary
+// resource together with the size of the file.
+func uploadBinary(client *fhir.Client, path string, contentType string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := createResource(client, "Binary", contentType, file, info.Size())
+	return id, info.Size(), err
+}
+
+// createDocumentReference returns a DocumentReference with one attachment
+// referencing the Binary resource with the given id.
+func createDocumentReference(binaryId string, contentType string, size int64, title string) fm.DocumentReference {
+	url := "Binary/" + binaryId
+	intSize := int(size)
+	return fm.DocumentReference{
+		Status: fm.DocumentReferenceStatusCurrent,
+		Content: []fm.DocumentReferenceContent{
+			{
+				Attachment: fm.Attachment{
+					ContentType: &contentType,
+					Url:         &url,
+					Size:        &intSize,
+					Title:       &title,
+				},
+			},
+		},
+	}
+}
+
+var uploadBinaryCmd = &cobra.Command{
+	Use:   "upload-binary [file]",
+	Short: "Upload a file as Binary resource",
+	Long: `Uploads the content of a file as Binary resource with the given content
+type and prints the id of the created resource. The file is streamed to the
+server, so that large files don't have to fit into memory.
+
+With --wrap, a DocumentReference is created in addition, which references the
+Binary resource in its attachment together with content type, size and the
+name of the file as title.
+
+Examples:
+  blazectl upload-binary --server http://localhost:8080/fhir report.pdf --content-type application/pdf
+  blazectl upload-binary --server http://localhost:8080/fhir report.pdf --content-type application/pdf --wrap`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			return err
+		}
+
+		binaryId, size, err := uploadBinary(client, args[0], binaryContentType)
+		if err != nil {
+			printErrorAndExit(err)
+		}
+		fmt.Printf("Uploaded %s (%s) as Binary/%s\n", args[0], unitFormat().Bytes(float64(size)), binaryId)
+
+		if wrapBinary {
+			documentReference := createDocumentReference(binaryId, binaryContentType, size, filepath.Base(args[0]))
+			payload, err := json.Marshal(documentReference)
+			if err != nil {
+				return err
+			}
+			id, err := createResource(client, "DocumentReference", "application/fhir+json", bytes.NewReader(payload),
+				int64(len(payload)))
+			if err != nil {
+				printErrorAndExit(err)
+			}
+			fmt.Printf("Created DocumentReference/%s\n", id)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(uploadBinaryCmd)
+
+	uploadBinaryCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	uploadBinaryCmd.Flags().StringVar(&binaryContentType, "content-type", "", "the content type of the file, like application/pdf")
+	uploadBinaryCmd.Flags().BoolVar(&wrapBinary, "wrap", false, "also create a DocumentReference referencing the Binary resource")
+
+	_ = uploadBinaryCmd.MarkFlagRequired("server")
+	_ = uploadBinaryCmd.MarkFlagRequired("content-type")
+}
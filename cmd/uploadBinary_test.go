@@ -0,0 +1,90 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdFromLocation(t *testing.T) {
+	id, err := idFromLocation("http://localhost:8080/fhir/Binary/DD7BYDLGQTG6DPRV/_history/1", "Binary")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "DD7BYDLGQTG6DPRV", id)
+	}
+
+	_, err = idFromLocation("", "Binary")
+	assert.EqualError(t, err, "missing Binary id in the Location header ``")
+}
+
+func TestUploadBinary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(path, []byte("%PDF-1.7"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "/Binary", r.URL.Path)
+			assert.Equal(t, "application/pdf", r.Header.Get("Content-Type"))
+			assert.Equal(t, "return=minimal", r.Header.Get("Prefer"))
+			assert.Equal(t, int64(8), r.ContentLength)
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, "%PDF-1.7", string(body))
+
+			w.Header().Set("Location", "http://localhost:8080/fhir/Binary/0/_history/1")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer ts.Close()
+
+		baseURL, _ := url.ParseRequestURI(ts.URL)
+		id, size, err := uploadBinary(fhir.NewClient(*baseURL, nil), path, "application/pdf")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "0", id)
+			assert.Equal(t, int64(8), size)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}))
+		defer ts.Close()
+
+		baseURL, _ := url.ParseRequestURI(ts.URL)
+		_, _, err := uploadBinary(fhir.NewClient(*baseURL, nil), path, "application/pdf")
+
+		assert.ErrorContains(t, err, "error while creating a Binary")
+	})
+}
+
+func TestCreateDocumentReference(t *testing.T) {
+	documentReference := createDocumentReference("0", "application/pdf", 8, "report.pdf")
+
+	attachment := documentReference.Content[0].Attachment
+	assert.Equal(t, "Binary/0", *attachment.Url)
+	assert.Equal(t, "application/pdf", *attachment.ContentType)
+	assert.Equal(t, 8, *attachment.Size)
+	assert.Equal(t, "report.pdf", *attachment.Title)
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// conformanceResourceTypes are the resource types uploaded from packages in
+// dependency order. ValueSets refer to CodeSystems, StructureDefinitions bind
+// to ValueSets and SearchParameters refer to the types defined before.
+var conformanceResourceTypes = []string{"CodeSystem", "ValueSet", "StructureDefinition", "SearchParameter"}
+
+// conformanceResourceBatches groups the conformance resources of a package
+// into batches which have to be uploaded one after another. Resources of the
+// same type form one batch, except StructureDefinitions which are split further,
+// so that StructureDefinitions are uploaded after the StructureDefinitions of
+// the package they are derived from.
+func conformanceResourceBatches(resources []fhir.PackageResource) [][]fhir.PackageResource {
+	var batches [][]fhir.PackageResource
+	for _, resourceType := range conformanceResourceTypes {
+		var batch []fhir.PackageResource
+		for _, resource := range resources {
+			if resource.ResourceType == resourceType {
+				batch = append(batch, resource)
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if resourceType == "StructureDefinition" {
+			batches = append(batches, structureDefinitionBatches(batch)...)
+		} else {
+			batches = append(batches, batch)
+		}
+	}
+	return batches
+}
+
+// structureDefinitionBatches groups StructureDefinitions by the number of
+// their ancestors within the given StructureDefinitions.
+func structureDefinitionBatches(structureDefinitions []fhir.PackageResource) [][]fhir.PackageResource {
+	byUrl := make(map[string]fhir.PackageResource, len(structureDefinitions))
+	for _, sd := range structureDefinitions {
+		byUrl[sd.Url] = sd
+	}
+
+	var batches [][]fhir.PackageResource
+	for _, sd := range structureDefinitions {
+		depth := 0
+		visited := map[string]bool{sd.Url: true}
+		for base, ok := byUrl[sd.BaseDefinition]; ok && !visited[base.Url]; base, ok = byUrl[base.BaseDefinition] {
+			visited[base.Url] = true
+			depth++
+		}
+		for len(batches) <= depth {
+			batches = append(batches, nil)
+		}
+		batches[depth] = append(batches[depth], sd)
+	}
+	return batches
+}
+
+// createPackageTransaction creates a transaction Bundle which creates or
+// updates the given resources. Resources with an id are updated using PUT so
+// that uploading a package again doesn't create duplicates.
+func createPackageTransaction(resources []fhir.PackageResource) ([]byte, error) {
+	bundle := fm.Bundle{Type: fm.BundleTypeTransaction}
+	for _, resource := range resources {
+		entry := fm.BundleEntry{
+			Resource: resource.Data,
+			Request: &fm.BundleEntryRequest{
+				Method: fm.HTTPVerbPOST,
+				Url:    resource.ResourceType,
+			},
+		}
+		if resource.Id != "" {
+			entry.Request.Method = fm.HTTPVerbPUT
+			entry.Request.Url = resource.ResourceType + "/" + resource.Id
+		}
+		bundle.Entry = append(bundle.Entry, entry)
+	}
+	return json.Marshal(bundle)
+}
+
+// uploadTransaction uploads the given transaction Bundle.
+func uploadTransaction(client *fhir.Client, bundle []byte) error {
+	req, err := client.NewTransactionRequest(bytes.NewReader(bundle))
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	errorResponse := util.NewErrorResponse(resp, body)
+	return errors.New(errorResponse.String())
+}
+
+// uploadPackageCmd represents the upload-package command
+var uploadPackageCmd = &cobra.Command{
+	Use:   "upload-package [package.tgz|directory|id@version]",
+	Short: "Upload the conformance resources of a FHIR package",
+	Long: `Uploads the conformance resources of a FHIR package, like the one of an
+ImplementationGuide, to the server. The package is read from a .tgz file, from
+a directory containing an extracted package or, given as id@version, it is
+downloaded from the package registry.
+
+The CodeSystem, ValueSet, StructureDefinition and SearchParameter resources of
+the package are uploaded in that order using one transaction per resource type.
+StructureDefinitions are uploaded after the StructureDefinitions of the package
+they are derived from.
+
+Example:
+
+  blazectl upload-package --server http://localhost:8080/fhir hl7.fhir.us.core@6.1.0`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("requires exactly one package argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := createClient()
+		if err != nil {
+			return err
+		}
+
+		p, err := loadPackage(args[0], packageRegistry)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Uploading package %s@%s to %s ...\n", p.Name, p.Version, server)
+
+		for _, batch := range conformanceResourceBatches(p.Resources) {
+			bundle, err := createPackageTransaction(batch)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if err := uploadTransaction(client, bundle); err != nil {
+				fmt.Printf("Error while uploading %d %s resources:\n\n%s", len(batch), batch[0].ResourceType,
+					util.Indent(4, strings.TrimSuffix(err.Error(), "\n"))+"\n")
+				os.Exit(1)
+			}
+			fmt.Printf("Uploaded %d %s resources\n", len(batch), batch[0].ResourceType)
+		}
+
+		client.CloseIdleConnections()
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(uploadPackageCmd)
+
+	uploadPackageCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	uploadPackageCmd.Flags().StringVar(&packageRegistry, "package-registry", fhir.DefaultPackageRegistry, "the base URL of the FHIR package registry")
+
+	_ = uploadPackageCmd.MarkFlagRequired("server")
+}
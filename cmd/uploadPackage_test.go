@@ -0,0 +1,112 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestConformanceResourceBatches(t *testing.T) {
+	resources := []fhir.PackageResource{
+		{ResourceType: "SearchParameter", Id: "sp"},
+		{ResourceType: "StructureDefinition", Id: "derived", Url: "http://example.com/derived", BaseDefinition: "http://example.com/base"},
+		{ResourceType: "ImplementationGuide", Id: "ig"},
+		{ResourceType: "StructureDefinition", Id: "base", Url: "http://example.com/base", BaseDefinition: "http://hl7.org/fhir/StructureDefinition/Patient"},
+		{ResourceType: "ValueSet", Id: "vs"},
+		{ResourceType: "CodeSystem", Id: "cs"},
+		{ResourceType: "StructureDefinition", Id: "other", Url: "http://example.com/other", BaseDefinition: "http://hl7.org/fhir/StructureDefinition/Observation"},
+	}
+
+	batches := conformanceResourceBatches(resources)
+
+	var ids [][]string
+	for _, batch := range batches {
+		var batchIds []string
+		for _, resource := range batch {
+			batchIds = append(batchIds, resource.Id)
+		}
+		ids = append(ids, batchIds)
+	}
+	assert.Equal(t, [][]string{{"cs"}, {"vs"}, {"base", "other"}, {"derived"}, {"sp"}}, ids)
+}
+
+func TestStructureDefinitionBatchesWithCycle(t *testing.T) {
+	batches := structureDefinitionBatches([]fhir.PackageResource{
+		{Url: "http://example.com/a", BaseDefinition: "http://example.com/b"},
+		{Url: "http://example.com/b", BaseDefinition: "http://example.com/a"},
+	})
+
+	assert.Equal(t, 2, len(batches))
+}
+
+func TestCreatePackageTransaction(t *testing.T) {
+	payload, err := createPackageTransaction([]fhir.PackageResource{
+		{ResourceType: "CodeSystem", Id: "cs", Data: []byte(`{"resourceType":"CodeSystem","id":"cs"}`)},
+		{ResourceType: "CodeSystem", Data: []byte(`{"resourceType":"CodeSystem"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := fm.UnmarshalBundle(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fm.BundleTypeTransaction, bundle.Type)
+	assert.Equal(t, 2, len(bundle.Entry))
+	assert.Equal(t, fm.HTTPVerbPUT, bundle.Entry[0].Request.Method)
+	assert.Equal(t, "CodeSystem/cs", bundle.Entry[0].Request.Url)
+	assert.Equal(t, fm.HTTPVerbPOST, bundle.Entry[1].Request.Method)
+	assert.Equal(t, "CodeSystem", bundle.Entry[1].Request.Url)
+}
+
+func TestUploadTransaction(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "transaction-response"}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		assert.Nil(t, uploadTransaction(client, []byte(`{}`)))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/fhir+json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "invalid", "diagnostics": "invalid CodeSystem"}]}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		err := uploadTransaction(client, []byte(`{}`))
+
+		assert.ErrorContains(t, err, "StatusCode  : 400")
+		assert.ErrorContains(t, err, "Diagnostics : invalid CodeSystem")
+	})
+}
@@ -15,13 +15,44 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
+func TestFindProcessableFilesIgnoresCase(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"BUNDLE.JSON", "Bundles.NDJSON", "readme.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal("can't create a temp file")
+		}
+	}
+
+	files, err := findProcessableFiles(dir)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{filepath.Join(dir, "BUNDLE.JSON")}, files.singleBundleFiles)
+		assert.Equal(t, []string{filepath.Join(dir, "Bundles.NDJSON")}, files.multiBundleFiles)
+	}
+}
+
 func TestFindProcessableFiles(t *testing.T) {
 
 	for _, fileExt := range []string{"json", "json.gz", "json.bz2"} {
@@ -149,3 +180,902 @@ func TestFindProcessableFiles(t *testing.T) {
 		assert.Equal(t, bundlePath2, files.multiBundleFiles[1])
 	})
 }
+
+func TestReadEntryOutcomes(t *testing.T) {
+	t.Run("InvalidBundle", func(t *testing.T) {
+		_, _, err := readEntryOutcomes([]byte("{"))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("SuccessfulEntries", func(t *testing.T) {
+		statusCodes, outcomes, err := readEntryOutcomes([]byte(`{
+"resourceType": "Bundle",
+"type": "transaction-response",
+"entry": [
+  {"response": {"status": "201 Created"}},
+  {"response": {"status": "201"}},
+  {"response": {"status": "200 OK"}}
+]}`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[int]int{200: 1, 201: 2}, statusCodes)
+		assert.Empty(t, outcomes)
+	})
+
+	t.Run("EntriesWithOutcomes", func(t *testing.T) {
+		statusCodes, outcomes, err := readEntryOutcomes([]byte(`{
+"resourceType": "Bundle",
+"type": "batch-response",
+"entry": [
+  {"response": {"status": "201"}},
+  {"response": {"status": "400", "outcome": {"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "invalid", "diagnostics": "diagnostics-143221"}]}}},
+  {"response": {"status": "200", "outcome": {"resourceType": "OperationOutcome", "issue": [{"severity": "warning", "code": "informational"}]}}}
+]}`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[int]int{200: 1, 201: 1, 400: 1}, statusCodes)
+		assert.Equal(t, 2, len(outcomes))
+		assert.Equal(t, 400, outcomes[1].StatusCode)
+		assert.Equal(t, "diagnostics-143221", *outcomes[1].OperationOutcome.Issue[0].Diagnostics)
+		assert.Equal(t, 200, outcomes[2].StatusCode)
+	})
+}
+
+func TestCheckUploadPrefer(t *testing.T) {
+	assert.NoError(t, checkUploadPrefer("minimal"))
+	assert.NoError(t, checkUploadPrefer("representation"))
+	assert.NoError(t, checkUploadPrefer("OperationOutcome"))
+	assert.EqualError(t, checkUploadPrefer("full"), "invalid --prefer value `full`, expected one of minimal, representation, OperationOutcome")
+}
+
+func TestUploadBundle(t *testing.T) {
+	t.Run("PreferHeader", func(t *testing.T) {
+		var prefer string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			prefer = r.Header.Get("Prefer")
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "transaction-response"}`))
+		}))
+		defer server.Close()
+
+		dir := t.TempDir()
+		bundlePath := filepath.Join(dir, "bundle.json")
+		if err := os.WriteFile(bundlePath, []byte("{}"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		_, err := uploadBundle(context.Background(), client, &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: 2})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "return=minimal", prefer)
+		}
+
+		uploadPrefer = "representation"
+		defer func() { uploadPrefer = "minimal" }()
+
+		_, err = uploadBundle(context.Background(), client, &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: 2})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "return=representation", prefer)
+		}
+	})
+
+	t.Run("BatchResponseWithFailedEntry", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "batch-response", "entry": [
+  {"response": {"status": "201"}},
+  {"response": {"status": "422"}}
+]}`))
+		}))
+		defer server.Close()
+
+		dir := t.TempDir()
+		bundlePath := filepath.Join(dir, "bundle.json")
+		if err := os.WriteFile(bundlePath, []byte("{}"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		info, err := uploadBundle(context.Background(), client, &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: 2})
+		if err != nil {
+			t.Fatalf("error while uploading the bundle: %v", err)
+		}
+
+		assert.Equal(t, 200, info.statusCode)
+		assert.Equal(t, map[int]int{201: 1, 422: 1}, info.entryStatusCodes)
+		assert.Equal(t, 422, info.entryOutcomes[1].StatusCode)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		uploadTimeout = 10 * time.Millisecond
+		defer func() { uploadTimeout = 0 }()
+
+		dir := t.TempDir()
+		bundlePath := filepath.Join(dir, "bundle.json")
+		if err := os.WriteFile(bundlePath, []byte("{}"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		_, err := uploadBundle(context.Background(), client, &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: 2})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("ErrorResponseWithRequestId", func(t *testing.T) {
+		var correlationId string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			correlationId = r.Header.Get("X-Correlation-Id")
+			w.Header().Set("X-Request-Id", "id-171204")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		dir := t.TempDir()
+		bundlePath := filepath.Join(dir, "bundle.json")
+		if err := os.WriteFile(bundlePath, []byte("{}"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		info, err := uploadBundle(context.Background(), client, &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: 2})
+		if err != nil {
+			t.Fatalf("error while uploading the bundle: %v", err)
+		}
+
+		assert.Equal(t, 503, info.statusCode)
+		assert.Equal(t, "id-171204", info.requestId)
+		assert.NotEmpty(t, correlationId)
+		assert.Equal(t, correlationId, info.correlationId)
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("the test commands need a POSIX shell")
+		}
+
+		var uploaded string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			uploaded = string(body)
+			_, _ = w.Write([]byte(`{"resourceType": "Bundle", "type": "transaction-response"}`))
+		}))
+		defer server.Close()
+
+		dir := t.TempDir()
+		bundlePath := filepath.Join(dir, "bundle.json")
+		if err := os.WriteFile(bundlePath, []byte("{}"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+		responsePath := filepath.Join(dir, "response-$BLAZECTL_BUNDLE-$BLAZECTL_STATUS.json")
+
+		preBundleCmd = `sed 's/{}/{"resourceType": "Bundle"}/'`
+		postBundleCmd = "cat > " + responsePath
+		defer func() {
+			preBundleCmd = ""
+			postBundleCmd = ""
+		}()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		info, err := uploadBundle(context.Background(), client, &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: 2})
+		if assert.NoError(t, err) {
+			assert.Equal(t, `{"resourceType": "Bundle"}`, uploaded)
+			assert.Equal(t, int64(len(uploaded)), info.bytesOut)
+			assert.Equal(t, `{"resourceType": "Bundle", "type": "transaction-response"}`, readFile(t, filepath.Join(dir, "response-1-200.json")))
+		}
+	})
+}
+
+func TestUploadBundleAsync(t *testing.T) {
+	uploadAsync = true
+	pollInterval = time.Millisecond
+	defer func() {
+		uploadAsync = false
+		pollInterval = 100 * time.Millisecond
+	}()
+
+	newServer := func(t *testing.T, result string) *httptest.Server {
+		polls := 0
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			if r.Method == "POST" {
+				assert.Equal(t, "respond-async, return=minimal", r.Header.Get("Prefer"))
+				w.Header().Set("Content-Location", server.URL+"/__async-status/1")
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			assert.Equal(t, "/__async-status/1", r.URL.Path)
+			polls++
+			if polls < 2 {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			_, _ = w.Write([]byte(result))
+		}))
+		return server
+	}
+
+	upload := func(t *testing.T, server *httptest.Server) uploadInfo {
+		dir := t.TempDir()
+		bundlePath := filepath.Join(dir, "bundle.json")
+		if err := os.WriteFile(bundlePath, []byte("{}"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		info, err := uploadBundle(context.Background(), client, &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: 2})
+		if err != nil {
+			t.Fatalf("error while uploading the bundle: %v", err)
+		}
+		return info
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		server := newServer(t, `{"resourceType": "Bundle", "type": "batch-response", "entry": [
+  {"resource": {"resourceType": "Bundle", "type": "transaction-response", "entry": [
+    {"response": {"status": "201"}},
+    {"response": {"status": "201"}}
+  ]}, "response": {"status": "200"}}
+]}`)
+		defer server.Close()
+
+		info := upload(t, server)
+
+		assert.Equal(t, 200, info.statusCode)
+		assert.Equal(t, map[int]int{201: 2}, info.entryStatusCodes)
+	})
+
+	t.Run("FailedTransaction", func(t *testing.T) {
+		server := newServer(t, `{"resourceType": "Bundle", "type": "batch-response", "entry": [
+  {"response": {"status": "400", "outcome": {"resourceType": "OperationOutcome", "issue": [
+    {"severity": "error", "code": "invalid", "diagnostics": "invalid bundle"}
+  ]}}}
+]}`)
+		defer server.Close()
+
+		info := upload(t, server)
+
+		assert.Equal(t, 400, info.statusCode)
+		assert.Contains(t, string(info.error), "invalid bundle")
+	})
+}
+
+func TestReadIdMappings(t *testing.T) {
+	requestBody := []byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"fullUrl": "urn:uuid:a", "resource": {"resourceType": "Patient"}, "request": {"method": "POST", "url": "Patient"}},
+  {"fullUrl": "http://localhost/Observation/b", "resource": {"resourceType": "Observation", "id": "b"}, "request": {"method": "PUT", "url": "Observation/b"}}
+]}`)
+
+	t.Run("Success", func(t *testing.T) {
+		mappings, err := readIdMappings(requestBody, []byte(`{"resourceType": "Bundle", "type": "transaction-response", "entry": [
+  {"response": {"status": "201", "location": "Patient/1/_history/1"}},
+  {"response": {"status": "200", "location": "Observation/b/_history/2"}}
+]}`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []idMapping{
+			{entryIndex: 0, fullUrl: "urn:uuid:a", resourceType: "Patient", location: "Patient/1/_history/1"},
+			{entryIndex: 1, fullUrl: "http://localhost/Observation/b", resourceType: "Observation", id: "b", location: "Observation/b/_history/2"},
+		}, mappings)
+	})
+
+	t.Run("EntryCountMismatch", func(t *testing.T) {
+		_, err := readIdMappings(requestBody, []byte(`{"resourceType": "Bundle", "type": "transaction-response"}`))
+
+		assert.ErrorContains(t, err, "expected 2 entries in the response bundle but got 0")
+	})
+}
+
+func TestBundleTooLarge(t *testing.T) {
+	assert.True(t, bundleTooLarge(uploadInfo{statusCode: 413}))
+	assert.True(t, bundleTooLarge(uploadInfo{statusCode: 400, error: []byte(`{"resourceType": "OperationOutcome", "issue": [
+  {"severity": "error", "code": "too-long"}
+]}`)}))
+	assert.False(t, bundleTooLarge(uploadInfo{statusCode: 400, error: []byte(`{"resourceType": "OperationOutcome", "issue": [
+  {"severity": "error", "code": "invalid"}
+]}`)}))
+	assert.False(t, bundleTooLarge(uploadInfo{statusCode: 503}))
+	assert.False(t, bundleTooLarge(uploadInfo{statusCode: 200}))
+}
+
+func TestSplitBundle(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		halves, mid, err := splitBundle([]byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"fullUrl": "urn:uuid:a", "resource": {"resourceType": "Patient"}},
+  {"fullUrl": "urn:uuid:b", "resource": {"resourceType": "Patient"}},
+  {"fullUrl": "urn:uuid:c", "resource": {"resourceType": "Patient"}}
+]}`))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, mid)
+			assert.JSONEq(t, `{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"fullUrl": "urn:uuid:a", "resource": {"resourceType": "Patient"}}
+]}`, string(halves[0]))
+			assert.JSONEq(t, `{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"fullUrl": "urn:uuid:b", "resource": {"resourceType": "Patient"}},
+  {"fullUrl": "urn:uuid:c", "resource": {"resourceType": "Patient"}}
+]}`, string(halves[1]))
+		}
+	})
+
+	t.Run("CrossReference", func(t *testing.T) {
+		_, _, err := splitBundle([]byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"fullUrl": "urn:uuid:a", "resource": {"resourceType": "Patient"}},
+  {"fullUrl": "urn:uuid:b", "resource": {"resourceType": "Observation", "subject": {"reference": "urn:uuid:a"}}}
+]}`))
+
+		assert.EqualError(t, err, "the entries of its halves reference each other by fullUrl")
+	})
+
+	t.Run("SingleEntry", func(t *testing.T) {
+		_, _, err := splitBundle([]byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"fullUrl": "urn:uuid:a", "resource": {"resourceType": "Patient"}}
+]}`))
+
+		assert.EqualError(t, err, "it has 1 entries")
+	})
+}
+
+func TestUploadBundleSplit(t *testing.T) {
+	// the server accepts bundles with only one entry
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var bundle requestBundle
+		if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(bundle.Entry) > 1 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"resourceType": "Bundle", "type": "transaction-response", "entry": [
+  {"response": {"status": "201", "location": "Patient/%s/_history/1"}}
+]}`, bundle.Entry[0].Resource.Id)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	bundlePath := filepath.Join(dir, "bundle.json")
+	content := []byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "Patient", "id": "0"}, "request": {"method": "PUT", "url": "Patient/0"}},
+  {"resource": {"resourceType": "Patient", "id": "1"}, "request": {"method": "PUT", "url": "Patient/1"}},
+  {"resource": {"resourceType": "Patient", "id": "2"}, "request": {"method": "PUT", "url": "Patient/2"}}
+]}`)
+	if err := os.WriteFile(bundlePath, content, 0644); err != nil {
+		t.Fatal("can't create a temp json file")
+	}
+	bundleId := &bundleIdentifier{filename: bundlePath, bundleNumber: 1, endBytes: int64(len(content))}
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	t.Run("Success", func(t *testing.T) {
+		requests = 0
+		idMapFile = "ids.csv"
+		defer func() { idMapFile = "" }()
+
+		info, err := uploadBundle(context.Background(), client, bundleId)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 200, info.statusCode)
+			assert.Equal(t, 2, info.splits)
+			assert.Equal(t, map[int]int{201: 3}, info.entryStatusCodes)
+			assert.Equal(t, 5, requests)
+			if assert.Len(t, info.idMappings, 3) {
+				assert.Equal(t, 2, info.idMappings[2].entryIndex)
+				assert.Equal(t, "Patient/2/_history/1", info.idMappings[2].location)
+			}
+		}
+	})
+
+	t.Run("MaxSplitsReached", func(t *testing.T) {
+		maxSplits = 1
+		idMapFile = "ids.csv"
+		defer func() { maxSplits, idMapFile = 3, "" }()
+
+		info, err := uploadBundle(context.Background(), client, bundleId)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 413, info.statusCode)
+			assert.Equal(t, 1, info.splits)
+			// the first half with one entry is committed
+			assert.Equal(t, map[int]int{201: 1}, info.entryStatusCodes)
+			if assert.Len(t, info.idMappings, 1) {
+				assert.Equal(t, "Patient/0/_history/1", info.idMappings[0].location)
+			}
+			assert.Positive(t, info.bytesOut)
+		}
+	})
+
+	t.Run("SecondHalfConnectionError", func(t *testing.T) {
+		idMapFile = "ids.csv"
+		defer func() { idMapFile = "" }()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var bundle requestBundle
+			if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if len(bundle.Entry) > 1 {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			if bundle.Entry[0].Resource.Id != "0" {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"resourceType": "Bundle", "type": "transaction-response", "entry": [
+  {"response": {"status": "201", "location": "Patient/0/_history/1"}}
+]}`)
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		info, err := uploadBundle(context.Background(), fhir.NewClient(*baseURL, nil), bundleId)
+
+		assert.Error(t, err)
+		assert.Equal(t, map[int]int{201: 1}, info.entryStatusCodes)
+		assert.Len(t, info.idMappings, 1)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		maxSplits = 0
+		defer func() { maxSplits = 3 }()
+
+		info, err := uploadBundle(context.Background(), client, bundleId)
+		if assert.NoError(t, err) {
+			assert.Equal(t, 413, info.statusCode)
+			assert.Equal(t, 0, info.splits)
+		}
+	})
+}
+
+func TestExternalizeAttachments(t *testing.T) {
+	bundle := []byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"resource": {"resourceType": "DocumentReference", "status": "current", "content": [
+    {"attachment": {"contentType": "text/plain", "data": "bGFyZ2UgY29udGVudA=="}},
+    {"attachment": {"contentType": "text/plain", "data": "c21hbGw="}}]},
+   "request": {"method": "POST", "url": "DocumentReference"}},
+  {"resource": {"resourceType": "Binary", "contentType": "text/plain", "data": "bGFyZ2UgY29udGVudA=="},
+   "request": {"method": "POST", "url": "Binary"}}
+]}`)
+
+	t.Run("Success", func(t *testing.T) {
+		var binaries []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/Binary", r.URL.Path)
+			assert.Equal(t, "application/fhir+json", r.Header.Get("Content-Type"))
+			body, _ := io.ReadAll(r.Body)
+			binaries = append(binaries, string(body))
+			w.Header().Set("Location", "http://localhost/Binary/0/_history/1")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer ts.Close()
+
+		baseURL, _ := url.ParseRequestURI(ts.URL)
+		content, externalized, err := externalizeAttachments(fhir.NewClient(*baseURL, nil), bundle, 10)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, externalizedAttachments{count: 1, bytes: 20}, externalized)
+			assert.Equal(t, []string{`{"contentType":"text/plain","data":"bGFyZ2UgY29udGVudA==","resourceType":"Binary"}`}, binaries)
+			assert.Contains(t, string(content), `{"contentType":"text/plain","size":13,"url":"Binary/0"}`)
+			assert.Contains(t, string(content), `{"contentType":"text/plain","data":"c21hbGw="}`)
+			assert.Contains(t, string(content), `"resourceType":"Binary"`)
+		}
+	})
+
+	t.Run("ConfiguredMediaType", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/fhir+json; fhirVersion=4.0", r.Header.Get("Content-Type"))
+			w.Header().Set("Location", "http://localhost/Binary/0/_history/1")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer ts.Close()
+
+		baseURL, _ := url.ParseRequestURI(ts.URL)
+		client := fhir.NewClient(*baseURL, nil)
+		client.SetMediaTypes("", "application/fhir+json; fhirVersion=4.0")
+		_, externalized, err := externalizeAttachments(client, bundle, 10)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, externalized.count)
+		}
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		content, externalized, err := externalizeAttachments(nil, bundle, 100)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, externalizedAttachments{}, externalized)
+			assert.Equal(t, bundle, content)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}))
+		defer ts.Close()
+
+		baseURL, _ := url.ParseRequestURI(ts.URL)
+		_, _, err := externalizeAttachments(fhir.NewClient(*baseURL, nil), bundle, 10)
+
+		assert.ErrorContains(t, err, "error while creating a Binary")
+	})
+}
+
+func TestWriteIdMappings(t *testing.T) {
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+
+	err := writeIdMappings(w, bundleIdentifier{filename: "bundle.json", bundleNumber: 1}, []idMapping{
+		{entryIndex: 0, fullUrl: "urn:uuid:a", resourceType: "Patient", location: "Patient/1/_history/1"},
+	})
+	w.Flush()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "bundle.json,1,0,urn:uuid:a,Patient,,Patient/1/_history/1\n", buf.String())
+}
+
+func TestAggregatedUploadResults(t *testing.T) {
+	results := aggregatedUploadResults{
+		entryStatusCodes: map[int]int{200: 2, 201: 3, 400: 1, 0: 1},
+	}
+
+	assert.Equal(t, 5, results.uploadedResources())
+	assert.Equal(t, 2, results.failedEntries())
+}
+
+func TestSlowBundles(t *testing.T) {
+	bundles := []slowBundle{
+		{id: bundleIdentifier{filename: "a.json", bundleNumber: 1}, duration: 2 * time.Second, bytesOut: 1024},
+		{id: bundleIdentifier{filename: "b.ndjson", bundleNumber: 7}, duration: 5 * time.Second, bytesOut: 2048},
+		{id: bundleIdentifier{filename: "c.json", bundleNumber: 1}, duration: 3 * time.Second, bytesOut: 512},
+	}
+
+	t.Run("slowest first", func(t *testing.T) {
+		slowest := slowestBundles(bundles, 2)
+
+		assert.Equal(t, "b.ndjson", slowest[0].id.filename)
+		assert.Equal(t, "c.json", slowest[1].id.filename)
+		assert.Equal(t, "a.json", bundles[0].id.filename)
+	})
+
+	t.Run("fewer than n", func(t *testing.T) {
+		assert.Len(t, slowestBundles(bundles, 10), 3)
+	})
+
+	t.Run("format", func(t *testing.T) {
+		assert.Equal(t, "File: b.ndjson [Bundle: 7] : 5.000, 2048\n",
+			fmtSlowBundles(util.UnitFormat{Raw: true}, slowestBundles(bundles, 1)))
+	})
+}
+
+func TestAggregateUploadResultsSlowBundles(t *testing.T) {
+	slowThreshold = time.Second
+	defer func() { slowThreshold = 0 }()
+
+	uploadResultCh := make(chan bundleUploadResult)
+	aggregatedUploadResultsCh := make(chan aggregatedUploadResults)
+	go aggregateUploadResults(uploadResultCh, aggregatedUploadResultsCh, noopProgress{}, nil)
+
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "fast.json"},
+		uploadInfo: uploadInfo{statusCode: 200, requestDuration: 100 * time.Millisecond}}
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "slow.json"},
+		uploadInfo: uploadInfo{statusCode: 200, requestDuration: 2 * time.Second, bytesOut: 42}}
+	close(uploadResultCh)
+
+	results := <-aggregatedUploadResultsCh
+	assert.Equal(t, []slowBundle{{id: bundleIdentifier{filename: "slow.json"}, duration: 2 * time.Second, bytesOut: 42}},
+		results.slowBundles)
+}
+
+func TestAggregateUploadResultsPartiallyCommitted(t *testing.T) {
+	uploadResultCh := make(chan bundleUploadResult)
+	aggregatedUploadResultsCh := make(chan aggregatedUploadResults)
+	go aggregateUploadResults(uploadResultCh, aggregatedUploadResultsCh, noopProgress{}, nil)
+
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "failed.json"},
+		uploadInfo: uploadInfo{statusCode: 400, entryStatusCodes: map[int]int{201: 2}, bytesOut: 100, splits: 1}}
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "error.json"},
+		uploadInfo: uploadInfo{entryStatusCodes: map[int]int{201: 3}, bytesOut: 200, splits: 2,
+			externalized: externalizedAttachments{count: 1, bytes: 1024}}, err: errors.New("connection reset")}
+	close(uploadResultCh)
+
+	results := <-aggregatedUploadResultsCh
+	assert.Equal(t, 5, results.uploadedResources())
+	assert.Equal(t, int64(300), results.totalBytesOut)
+	assert.Len(t, results.errorResponses, 1)
+	assert.Len(t, results.errors, 1)
+	assert.Equal(t, 2, results.splitBundles)
+	assert.Equal(t, 3, results.splits)
+	assert.Equal(t, externalizedAttachments{count: 1, bytes: 1024}, results.externalized)
+}
+
+func TestAggregateUploadResultsWarmup(t *testing.T) {
+	statsWarmup = warmup{samples: 1}
+	defer func() { statsWarmup = warmup{} }()
+
+	uploadResultCh := make(chan bundleUploadResult)
+	aggregatedUploadResultsCh := make(chan aggregatedUploadResults)
+	go aggregateUploadResults(uploadResultCh, aggregatedUploadResultsCh, noopProgress{}, nil)
+
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "cold.json"},
+		uploadInfo: uploadInfo{statusCode: 200, requestDuration: 5 * time.Second}}
+	uploadResultCh <- bundleUploadResult{id: bundleIdentifier{filename: "warm.json"},
+		uploadInfo: uploadInfo{statusCode: 200, requestDuration: time.Second}}
+	close(uploadResultCh)
+
+	results := <-aggregatedUploadResultsCh
+	assert.Equal(t, 1, results.warmupRequests)
+	assert.Equal(t, 1, results.warmupProcessings)
+
+	report := fmtUploadReport(util.UnitFormat{Raw: true}, results, 6*time.Second)
+	assert.Contains(t, report, "Warm-up          [excluded, given]                     1, 1\n")
+	assert.Contains(t, report, "Requ. Latencies  [min, mean, 50, 95, 99, max, stddev]  1.000, 1.000, 1.000, 1.000, 1.000, 1.000, 0.000\n")
+}
+
+func TestUploadBundlesInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	uploadResultCh := make(chan bundleUploadResult, 1)
+	bundles := make(chan bundle)
+	consumer := newUploadBundleConsumer(nil, uploadResultCh)
+
+	consumer.uploadBundles(ctx, bundles, 2)
+
+	assert.Empty(t, uploadResultCh)
+}
+
+func TestFmtUploadReport(t *testing.T) {
+	results := aggregatedUploadResults{
+		totalProcessedBundles: 2,
+		requestDurations:      util.NewDurationDigest(1, 2),
+		processingDurations:   util.NewDurationDigest(0.5),
+		errorResponses: map[bundleIdentifier]util.ErrorResponse{
+			{filename: "b.json", bundleNumber: 1}: {StatusCode: 400, OperationOutcome: util.NewErrorOutcome("invalid")},
+		},
+		errors:           map[bundleIdentifier]error{},
+		entryStatusCodes: map[int]int{201: 3},
+	}
+
+	report := fmtUploadReport(util.UnitFormat{Raw: true}, results, 4*time.Second)
+
+	assert.Contains(t, report, "Uploads          [total, concurrency]                  2, ")
+	assert.Contains(t, report, "Success          [ratio]                               50.00 %\n")
+	assert.Contains(t, report, "Resources        [total, rate]                         3, 0.75/s\n")
+	assert.Contains(t, report, "Non-OK Responses:\n\nFile: b.json [Bundle: 1]\n")
+	assert.NotContains(t, report, "Split Bundles")
+
+	results.splitBundles = 1
+	results.splits = 3
+	report = fmtUploadReport(util.UnitFormat{Raw: true}, results, 4*time.Second)
+
+	assert.Contains(t, report, "Split Bundles    [total, splits]                       1, 3\n")
+}
+
+func TestOpenBundle(t *testing.T) {
+	dir := t.TempDir()
+
+	open := func(t *testing.T, bundleId bundleIdentifier) (string, int64, error) {
+		file, err := os.Open(bundleId.filename)
+		if err != nil {
+			t.Fatalf("error while opening %s: %v", bundleId.filename, err)
+		}
+		defer file.Close()
+		reader, bundleSize, err := openBundle(file, &bundleId)
+		if err != nil {
+			return "", 0, err
+		}
+		content, err := io.ReadAll(reader)
+		return string(content), bundleSize(), err
+	}
+
+	t.Run("BOM", func(t *testing.T) {
+		path := filepath.Join(dir, "bom.json")
+		if err := os.WriteFile(path, []byte("\xEF\xBB\xBF{}"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+
+		content, size, err := open(t, bundleIdentifier{filename: path, bundleNumber: 1, endBytes: 5})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "{}", content)
+			assert.Equal(t, int64(2), size)
+		}
+	})
+
+	t.Run("BOM In Multi Bundle File", func(t *testing.T) {
+		path := filepath.Join(dir, "bom.ndjson")
+		if err := os.WriteFile(path, []byte("\xEF\xBB\xBF{}\n{}"), 0644); err != nil {
+			t.Fatal("can't create a temp ndjson file")
+		}
+
+		first, _, err := open(t, bundleIdentifier{filename: path, bundleNumber: 1, endBytes: 6})
+		assert.NoError(t, err)
+		second, _, err := open(t, bundleIdentifier{filename: path, bundleNumber: 2, startBytes: 6, endBytes: 8})
+		assert.NoError(t, err)
+
+		assert.Equal(t, "{}\n", first)
+		assert.Equal(t, "{}", second)
+	})
+
+	t.Run("BOM In Compressed File", func(t *testing.T) {
+		path := filepath.Join(dir, "bom.json.gz")
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, _ = w.Write([]byte("\xEF\xBB\xBF{}"))
+		_ = w.Close()
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal("can't create a temp gzip file")
+		}
+
+		content, size, err := open(t, bundleIdentifier{filename: path, bundleNumber: 1})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "{}", content)
+			assert.Equal(t, int64(2), size)
+		}
+	})
+
+	t.Run("UTF-16", func(t *testing.T) {
+		path := filepath.Join(dir, "utf16.json")
+		if err := os.WriteFile(path, []byte("\xFF\xFE{\x00}\x00"), 0644); err != nil {
+			t.Fatal("can't create a temp json file")
+		}
+
+		_, _, err := open(t, bundleIdentifier{filename: path, bundleNumber: 1, endBytes: 6})
+
+		assert.ErrorIs(t, err, util.ErrUTF16)
+	})
+}
+
+func TestUploadBundleProducerUTF16(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundles.ndjson")
+	if err := os.WriteFile(path, []byte("{\x00}\x00\n\x00{\x00}\x00"), 0644); err != nil {
+		t.Fatal("can't create a temp ndjson file")
+	}
+
+	producer := newUploadBundleProducer(noopProgress{}, 1)
+	summaryCh := producer.createUploadBundles(processableFiles{multiBundleFiles: []string{path}})
+
+	var bundles []bundle
+	for b := range producer.res {
+		bundles = append(bundles, b)
+	}
+	<-summaryCh
+
+	if assert.Len(t, bundles, 1) {
+		assert.Equal(t, path, bundles[0].id.filename)
+		assert.ErrorIs(t, bundles[0].err, util.ErrUTF16)
+	}
+}
+
+func TestUploadBundleProducer(t *testing.T) {
+	dir := t.TempDir()
+	singleBundlePath := filepath.Join(dir, "bundle.json")
+	if err := os.WriteFile(singleBundlePath, []byte("{}"), 0644); err != nil {
+		t.Fatal("can't create a temp json file")
+	}
+	multiBundlePath := filepath.Join(dir, "bundles.ndjson")
+	if err := os.WriteFile(multiBundlePath, []byte("{}\n{}\n\n{}"), 0644); err != nil {
+		t.Fatal("can't create a temp ndjson file")
+	}
+
+	producer := newUploadBundleProducer(noopProgress{}, 2)
+	summaryCh := producer.createUploadBundles(processableFiles{
+		singleBundleFiles: []string{singleBundlePath},
+		multiBundleFiles:  []string{multiBundlePath},
+	})
+
+	var bundles []bundle
+	for b := range producer.res {
+		bundles = append(bundles, b)
+	}
+	summary := <-summaryCh
+
+	assert.Equal(t, 4, len(bundles))
+	assert.Equal(t, uploadBundleProductionSummary{singleBundlesFiles: 1, multiBundlesFiles: 1, bundles: 4}, summary)
+}
+
+func TestRealProgress(t *testing.T) {
+	t.Run("NoBundles", func(t *testing.T) {
+		progress := createRealProgress()
+		progress.doneAddingBundles()
+		progress.wait()
+	})
+
+	t.Run("BundlesAddedWhileUploading", func(t *testing.T) {
+		progress := createRealProgress()
+		progress.addBundle()
+		progress.increment(1, 100)
+		progress.addBundle()
+		progress.doneAddingBundles()
+		progress.increment(1, 100)
+		progress.wait()
+	})
+
+	t.Run("AbortedFileScan", func(t *testing.T) {
+		progress := createRealProgress()
+		_, done := progress.trackFileScan("bundles.ndjson", 100, strings.NewReader(""))
+		done()
+		progress.doneAddingBundles()
+		progress.wait()
+	})
+}
+
+func TestValidateLocalBundle(t *testing.T) {
+	t.Run("Transaction", func(t *testing.T) {
+		_, err := validateLocalBundle([]byte(`{"resourceType": "Bundle", "type": "transaction"}`))
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		_, err := validateLocalBundle([]byte(`{"resourceType": "Bundle", "type": "batch"}`))
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("InvalidJson", func(t *testing.T) {
+		line, err := validateLocalBundle([]byte("{\n\"resourceType\": \"Bundle\",\n\"type\": }"))
+
+		assert.Equal(t, 3, line)
+		assert.ErrorContains(t, err, "invalid JSON")
+	})
+
+	t.Run("OtherResourceType", func(t *testing.T) {
+		_, err := validateLocalBundle([]byte(`{"resourceType": "Patient"}`))
+
+		assert.EqualError(t, err, "expected resourceType `Bundle` but was `Patient`")
+	})
+
+	t.Run("Collection", func(t *testing.T) {
+		_, err := validateLocalBundle([]byte(`{"resourceType": "Bundle", "type": "collection"}`))
+
+		assert.EqualError(t, err, "expected Bundle type `transaction` or `batch` but was `collection`")
+	})
+}
+
+func TestValidateLocalBundles(t *testing.T) {
+	dir := t.TempDir()
+	multiBundlePath := filepath.Join(dir, "bundles.ndjson")
+	content := `{"resourceType": "Bundle", "type": "transaction"}` + "\n" + `{"resourceType": "Bundle"` + "\n"
+	if err := os.WriteFile(multiBundlePath, []byte(content), 0644); err != nil {
+		t.Fatal("can't create a temp ndjson file")
+	}
+
+	producer := newUploadBundleProducer(noopProgress{}, 1)
+	producer.createUploadBundles(processableFiles{multiBundleFiles: []string{multiBundlePath}})
+
+	validBundles, validationErrors := validateLocalBundles(producer.res)
+
+	assert.Equal(t, 1, len(validBundles))
+	assert.Equal(t, 1, validBundles[0].id.bundleNumber)
+	assert.Equal(t, 1, len(validationErrors))
+	assert.Equal(t, 2, validationErrors[0].line)
+	assert.ErrorContains(t, validationErrors[0].err, "invalid JSON")
+}
@@ -0,0 +1,355 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+// validationInput is a single resource to validate together with its origin.
+type validationInput struct {
+	filename string
+	// line of the resource in NDJSON files, zero otherwise
+	line int
+	data []byte
+}
+
+func (input validationInput) String() string {
+	if input.line > 0 {
+		return fmt.Sprintf("File: %s [Line: %d]", input.filename, input.line)
+	}
+	return fmt.Sprintf("File: %s", input.filename)
+}
+
+// readValidationInputs reads all resources of the given files and of the
+// files found in the given directories.
+func readValidationInputs(paths []string) ([]validationInput, error) {
+	var inputs []validationInput
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		var files processableFiles
+		if info.IsDir() {
+			files, err = findProcessableFiles(path)
+			if err != nil {
+				return nil, err
+			}
+		} else if isMultiBundleFile(path) {
+			files.multiBundleFiles = []string{path}
+		} else {
+			files.singleBundleFiles = []string{path}
+		}
+
+		for _, file := range files.singleBundleFiles {
+			data, err := readBundleContent(bundleIdentifier{filename: file})
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, validationInput{filename: file, data: data})
+		}
+		for _, file := range files.multiBundleFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if data, err = util.StripBOM(data); err != nil {
+				return nil, fmt.Errorf("error while reading %s: %w", file, err)
+			}
+			for i, line := range bytes.Split(data, []byte{MultiBundleFileBundleDelimiter}) {
+				if len(bytes.TrimSpace(line)) > 0 {
+					inputs = append(inputs, validationInput{filename: file, line: i + 1, data: line})
+				}
+			}
+		}
+	}
+	return inputs, nil
+}
+
+// validateOffline validates the structure of the resource of input and
+// validates it against the given profiles and the profiles of its meta.profile
+// that are available in the given profiles by URL. Returns the issues found,
+// one per line, or an empty string if the resource is valid.
+func validateOffline(input validationInput, profiles []fhir.Profile, availableProfiles map[string]fhir.Profile) string {
+	issues, err := fhir.ValidateStructure(input.data)
+	if err != nil {
+		return err.Error() + "\n"
+	}
+	builder := strings.Builder{}
+	for _, issue := range issues {
+		builder.WriteString(issue.String())
+		builder.WriteString("\n")
+	}
+
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+		Meta         struct {
+			Profile []string `json:"profile"`
+		} `json:"meta"`
+	}
+	_ = json.Unmarshal(input.data, &resource)
+	applicableProfiles := make([]fhir.Profile, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.Type == resource.ResourceType {
+			applicableProfiles = append(applicableProfiles, profile)
+		}
+	}
+	for _, url := range resource.Meta.Profile {
+		if profile, ok := availableProfiles[url]; ok {
+			applicableProfiles = append(applicableProfiles, profile)
+		}
+	}
+
+	for _, profile := range applicableProfiles {
+		issues, err := fhir.ValidateProfile(input.data, profile)
+		if err != nil {
+			return err.Error() + "\n"
+		}
+		for _, issue := range issues {
+			builder.WriteString(fmt.Sprintf("%s (profile %s)\n", issue, profile.Url))
+		}
+	}
+	return builder.String()
+}
+
+// loadPackage loads a package given as path to a .tgz file or directory or as
+// id@version from the package registry.
+func loadPackage(spec string, registry string) (*fhir.Package, error) {
+	if _, err := os.Stat(spec); err == nil {
+		return fhir.LoadPackage(spec)
+	} else if id, version, found := strings.Cut(spec, "@"); found {
+		return fhir.FetchPackage(registry, id, version)
+	} else {
+		return nil, fmt.Errorf("package `%s` is neither a local file or directory nor of the form id@version", spec)
+	}
+}
+
+// loadPackages loads the given packages using loadPackage. Returns all
+// profiles of the packages by URL.
+func loadPackages(specs []string, registry string) (map[string]fhir.Profile, error) {
+	availableProfiles := make(map[string]fhir.Profile)
+	for _, spec := range specs {
+		p, err := loadPackage(spec, registry)
+		if err != nil {
+			return nil, err
+		}
+		for url, sd := range p.Profiles {
+			availableProfiles[url] = sd
+		}
+	}
+	return availableProfiles, nil
+}
+
+// resolveProfiles looks up the profiles with the given URLs.
+func resolveProfiles(urls []string, availableProfiles map[string]fhir.Profile) ([]fhir.Profile, error) {
+	profiles := make([]fhir.Profile, 0, len(urls))
+	for _, url := range urls {
+		profile, ok := availableProfiles[url]
+		if !ok {
+			return nil, fmt.Errorf("profile `%s` not found in the given packages", url)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// validateOnServer validates the resource of input using the $validate
+// operation of the server. Returns the issues of the returned OperationOutcome
+// or an empty string if the resource is valid.
+func validateOnServer(client *fhir.Client, input validationInput) (string, error) {
+	var resource struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(input.data, &resource); err != nil {
+		return err.Error() + "\n", nil
+	}
+	if resource.ResourceType == "" {
+		return "missing resourceType\n", nil
+	}
+
+	req, err := client.NewPostTypeOperationRequest(resource.ResourceType, "validate", bytes.NewReader(input.data))
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	outcome, err := fm.UnmarshalOperationOutcome(body)
+	if err != nil {
+		return "", fmt.Errorf("error while reading the OperationOutcome returned by $validate (status %d): %w", resp.StatusCode, err)
+	}
+	if isSuccessfulStatus(resp.StatusCode) && !hasErrorIssue(outcome) {
+		return "", nil
+	}
+	return util.FmtOperationOutcomes([]*fm.OperationOutcome{&outcome}), nil
+}
+
+func hasErrorIssue(outcome fm.OperationOutcome) bool {
+	for _, issue := range outcome.Issue {
+		if issue.Severity == fm.IssueSeverityError || issue.Severity == fm.IssueSeverityFatal {
+			return true
+		}
+	}
+	return false
+}
+
+// validationResult are the issues found in one resource or the error which
+// prevented its validation.
+type validationResult struct {
+	issues string
+	err    error
+}
+
+// validateInputs validates all inputs with validate, up to concurrency inputs
+// in parallel. The results are in the order of the inputs.
+func validateInputs(inputs []validationInput, concurrency int, validate func(input validationInput) (string, error)) []validationResult {
+	results := make([]validationResult, len(inputs))
+	indices := make([]int, len(inputs))
+	for i := range indices {
+		indices[i] = i
+	}
+	util.ForEach(indices, concurrency, func(i int) {
+		results[i].issues, results[i].err = validate(inputs[i])
+	})
+	return results
+}
+
+var offline bool
+var packages []string
+var packageRegistry string
+var profiles []string
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [file|directory]...",
+	Short: "Validate resources",
+	Long: `Validates resources from JSON and NDJSON files. Directories are searched
+for files in the same way as the upload command does.
+
+By default, each resource is validated using the $validate operation of the
+server. With --offline, the structure of each resource is validated locally
+instead. The offline validation finds missing required elements, wrong
+cardinalities, unknown elements and codes without a server round-trip.
+
+Up to --concurrency resources are validated in parallel. The results are
+printed in the order of the resources.
+
+With --package, FHIR packages like the ones of ImplementationGuides are loaded
+from .tgz files, directories or, given as id@version, from the package
+registry. Resources are then validated client-side against the profiles given
+by --profile and against the profiles of their meta.profile found in the
+packages.
+
+Example:
+
+  blazectl validate --server http://localhost:8080/fhir my/resources
+  blazectl validate --offline my/resources/patients.ndjson
+  blazectl validate --package hl7.fhir.us.core@6.1.0 \
+    --profile http://hl7.org/fhir/us/core/StructureDefinition/us-core-patient \
+    my/resources`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("requires at least one file or directory argument")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(profiles) > 0 && len(packages) == 0 {
+			return errors.New("--profile requires at least one --package")
+		}
+		clientSide := offline || len(packages) > 0
+		if !clientSide {
+			if server == "" {
+				return errors.New("requires either --server or --offline")
+			}
+			if err := createClient(); err != nil {
+				return err
+			}
+		}
+
+		availableProfiles, err := loadPackages(packages, packageRegistry)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		selectedProfiles, err := resolveProfiles(profiles, availableProfiles)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		inputs, err := readValidationInputs(args)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		results := validateInputs(inputs, concurrency, func(input validationInput) (string, error) {
+			if clientSide {
+				return validateOffline(input, selectedProfiles, availableProfiles), nil
+			}
+			return validateOnServer(client, input)
+		})
+
+		var invalid int
+		for i, result := range results {
+			if result.err != nil {
+				fmt.Printf("%s : %v\n", inputs[i], result.err)
+				os.Exit(1)
+			}
+			if result.issues != "" {
+				invalid++
+				fmt.Println(inputs[i])
+				fmt.Print(util.Indent(4, strings.TrimSuffix(result.issues, "\n")) + "\n")
+			}
+		}
+
+		fmt.Printf("Validated %d resources, %d invalid.\n", len(inputs), invalid)
+		if invalid > 0 {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+	addConcurrencyFlag(validateCmd, "number of resources validated in parallel")
+	validateCmd.Flags().BoolVar(&offline, "offline", false, "validate the structure of resources locally without a server")
+	validateCmd.Flags().StringArrayVar(&packages, "package", nil, "a FHIR package as .tgz file, directory or id@version to validate against client-side")
+	validateCmd.Flags().StringVar(&packageRegistry, "package-registry", fhir.DefaultPackageRegistry, "the base URL of the FHIR package registry")
+	validateCmd.Flags().StringArrayVar(&profiles, "profile", nil, "the canonical URL of a profile from the given packages to validate against")
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadValidationInputs(t *testing.T) {
+	dir := t.TempDir()
+	singlePath := filepath.Join(dir, "patient.json")
+	if err := os.WriteFile(singlePath, []byte(`{"resourceType": "Patient"}`), 0644); err != nil {
+		t.Fatal("can't create a temp json file")
+	}
+	multiPath := filepath.Join(dir, "observations.ndjson")
+	if err := os.WriteFile(multiPath, []byte("{\"resourceType\": \"Observation\"}\n\n{}\n"), 0644); err != nil {
+		t.Fatal("can't create a temp ndjson file")
+	}
+
+	t.Run("Directory", func(t *testing.T) {
+		inputs, err := readValidationInputs([]string{dir})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(inputs))
+		assert.Equal(t, "File: "+singlePath, inputs[0].String())
+		assert.Equal(t, "File: "+multiPath+" [Line: 1]", inputs[1].String())
+		assert.Equal(t, "File: "+multiPath+" [Line: 3]", inputs[2].String())
+	})
+
+	t.Run("File", func(t *testing.T) {
+		inputs, err := readValidationInputs([]string{multiPath})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(inputs))
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		_, err := readValidationInputs([]string{filepath.Join(dir, "missing.json")})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("BOM", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "patients.ndjson")
+		if err := os.WriteFile(path, []byte("\xEF\xBB\xBF{\"resourceType\": \"Patient\"}\n{}\n"), 0644); err != nil {
+			t.Fatal("can't create a temp ndjson file")
+		}
+
+		inputs, err := readValidationInputs([]string{path})
+
+		if assert.NoError(t, err) && assert.Len(t, inputs, 2) {
+			assert.Equal(t, `{"resourceType": "Patient"}`, string(inputs[0].data))
+		}
+	})
+
+	t.Run("UTF-16", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "patients.ndjson")
+		if err := os.WriteFile(path, []byte("\xFF\xFE{\x00}\x00"), 0644); err != nil {
+			t.Fatal("can't create a temp ndjson file")
+		}
+
+		_, err := readValidationInputs([]string{path})
+
+		assert.EqualError(t, err, "error while reading "+path+": "+util.ErrUTF16.Error())
+	})
+}
+
+func TestValidateOffline(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		issues := validateOffline(validationInput{data: []byte(`{"resourceType": "Patient"}`)}, nil, nil)
+
+		assert.Empty(t, issues)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		issues := validateOffline(validationInput{data: []byte(`{"resourceType": "Patient", "name": {}}`)}, nil, nil)
+
+		assert.Equal(t, "Patient.name: expected an array\n", issues)
+	})
+}
+
+func TestValidateOfflineWithProfiles(t *testing.T) {
+	min := 1
+	profile := fhir.Profile{
+		Url:  "http://example.com/StructureDefinition/patient",
+		Type: "Patient",
+		Differential: &fhir.ProfileElements{Element: []fhir.ProfileElement{
+			{Id: "Patient.gender", Path: "Patient.gender", Min: &min},
+		}},
+	}
+	availableProfiles := map[string]fhir.Profile{profile.Url: profile}
+
+	t.Run("GivenProfile", func(t *testing.T) {
+		issues := validateOffline(validationInput{data: []byte(`{"resourceType": "Patient"}`)}, []fhir.Profile{profile}, availableProfiles)
+
+		assert.Equal(t, "Patient.gender: expected at least 1 values but found 0 (profile http://example.com/StructureDefinition/patient)\n", issues)
+	})
+
+	t.Run("GivenProfileOfOtherType", func(t *testing.T) {
+		issues := validateOffline(validationInput{data: []byte(`{"resourceType": "Observation", "status": "final", "code": {}}`)}, []fhir.Profile{profile}, availableProfiles)
+
+		assert.Empty(t, issues)
+	})
+
+	t.Run("MetaProfile", func(t *testing.T) {
+		issues := validateOffline(validationInput{data: []byte(`{"resourceType": "Patient", "meta": {"profile": ["http://example.com/StructureDefinition/patient"]}}`)}, nil, availableProfiles)
+
+		assert.Contains(t, issues, "Patient.gender")
+	})
+
+	t.Run("UnknownMetaProfile", func(t *testing.T) {
+		issues := validateOffline(validationInput{data: []byte(`{"resourceType": "Patient", "meta": {"profile": ["http://example.com/other"]}}`)}, nil, availableProfiles)
+
+		assert.Empty(t, issues)
+	})
+}
+
+func TestResolveProfiles(t *testing.T) {
+	availableProfiles := map[string]fhir.Profile{"http://example.com/a": {Url: "http://example.com/a"}}
+
+	t.Run("Found", func(t *testing.T) {
+		profiles, err := resolveProfiles([]string{"http://example.com/a"}, availableProfiles)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(profiles))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := resolveProfiles([]string{"http://example.com/b"}, availableProfiles)
+
+		assert.EqualError(t, err, "profile `http://example.com/b` not found in the given packages")
+	})
+}
+
+func TestValidateOnServer(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "/Patient/$validate", r.URL.Path)
+
+			w.Header().Set("Content-Type", "application/fhir+json")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "information", "code": "informational"}]}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		issues, err := validateOnServer(client, validationInput{data: []byte(`{"resourceType": "Patient"}`)})
+
+		assert.Nil(t, err)
+		assert.Empty(t, issues)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/fhir+json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "invalid", "diagnostics": "name is wrong"}]}`))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := fhir.NewClient(*baseURL, nil)
+
+		issues, err := validateOnServer(client, validationInput{data: []byte(`{"resourceType": "Patient"}`)})
+
+		assert.Nil(t, err)
+		assert.Contains(t, issues, "Diagnostics : name is wrong")
+	})
+
+	t.Run("MissingResourceType", func(t *testing.T) {
+		issues, err := validateOnServer(nil, validationInput{data: []byte(`{}`)})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "missing resourceType\n", issues)
+	})
+}
+
+func TestValidateInputs(t *testing.T) {
+	inputs := []validationInput{{filename: "a"}, {filename: "b"}, {filename: "c"}}
+
+	results := validateInputs(inputs, 2, func(input validationInput) (string, error) {
+		if input.filename == "b" {
+			return "invalid\n", nil
+		}
+		return "", nil
+	})
+
+	assert.Equal(t, []validationResult{{}, {issues: "invalid\n"}, {}}, results)
+}
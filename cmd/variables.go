@@ -0,0 +1,62 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/samply/blazectl/data"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var setFlags []string
+
+// templateVars are the variables given by --set, which are substituted into
+// measure files and CQL libraries.
+var templateVars map[string]string
+
+// addSetFlag adds the --set flag to cmd.
+func addSetFlag(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&setFlags, "set", nil, "substitute {{ .key }} in the measure file and CQL libraries by value, given as key=value, can be repeated")
+}
+
+// parseVariables parses the key=value pairs of --set. Values may contain
+// commas and equal signs. Later values replace earlier ones.
+func parseVariables(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	vars := make(map[string]string, len(values))
+	for _, value := range values {
+		key, v, found := strings.Cut(value, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid --set value `%s`, expected key=value", value)
+		}
+		vars[key] = v
+	}
+	return vars, nil
+}
+
+// readCqlLibrary reads the CQL library file with the given name and
+// substitutes the variables given by --set.
+func readCqlLibrary(filename string) ([]byte, error) {
+	cql, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return data.Substitute(filepath.Base(filename), cql, templateVars)
+}
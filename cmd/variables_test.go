@@ -0,0 +1,61 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseVariables(t *testing.T) {
+	t.Run("None", func(t *testing.T) {
+		vars, err := parseVariables(nil)
+
+		assert.NoError(t, err)
+		assert.Nil(t, vars)
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		vars, err := parseVariables([]string{"codes='E10', 'E11'", "filter=a=b", "empty=", "codes=E12"})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]string{"codes": "E12", "filter": "a=b", "empty": ""}, vars)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := parseVariables([]string{"foo"})
+
+		assert.EqualError(t, err, "invalid --set value `foo`, expected key=value")
+	})
+}
+
+func TestReadCqlLibrary(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "library.cql")
+	if err := os.WriteFile(filename, []byte("define Codes: { {{ .codes }} }"), 0644); err != nil {
+		t.Fatal("can't create a temp CQL file")
+	}
+
+	templateVars = map[string]string{"codes": "'E10', 'E11'"}
+	defer func() { templateVars = nil }()
+
+	cql, err := readCqlLibrary(filename)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "define Codes: { 'E10', 'E11' }", string(cql))
+	}
+}
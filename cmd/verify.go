@@ -0,0 +1,202 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"io"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+var verifySample int
+
+// canonicalResource decodes resource and removes the parts a server assigns
+// itself, which are id and meta. References to the fullUrls of the uploaded
+// bundle are replaced by the references given in references.
+func canonicalResource(resource []byte, references map[string]string) (map[string]interface{}, error) {
+	var canonical map[string]interface{}
+	if err := json.Unmarshal(resource, &canonical); err != nil {
+		return nil, err
+	}
+	delete(canonical, "id")
+	delete(canonical, "meta")
+	return replaceReferences(canonical, references).(map[string]interface{}), nil
+}
+
+func replaceReferences(value interface{}, references map[string]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			v[key] = replaceReferences(nested, references)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = replaceReferences(nested, references)
+		}
+	case string:
+		if reference, ok := references[v]; ok {
+			return reference
+		}
+	}
+	return value
+}
+
+// firstDifference returns the path of the first element in which actual
+// differs from expected. Returns an empty string if both are equal.
+func firstDifference(expected interface{}, actual interface{}, path string) string {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return path
+		}
+		keys := make([]string, 0, len(e)+len(a))
+		for key := range e {
+			keys = append(keys, key)
+		}
+		for key := range a {
+			if _, ok := e[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if difference := firstDifference(e[key], a[key], path+"."+key); difference != "" {
+				return difference
+			}
+		}
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			return path
+		}
+		for i := range e {
+			if difference := firstDifference(e[i], a[i], fmt.Sprintf("%s[%d]", path, i)); difference != "" {
+				return difference
+			}
+		}
+	default:
+		if !reflect.DeepEqual(expected, actual) {
+			return path
+		}
+	}
+	return ""
+}
+
+// verifyResource compares the resource read back from the server with the
+// uploaded resource. Returns a description of the first difference or an
+// empty string if both are equal. A narrative the server generated for a
+// resource uploaded without one is ignored.
+func verifyResource(uploaded []byte, readBack []byte, references map[string]string) (string, error) {
+	expected, err := canonicalResource(uploaded, references)
+	if err != nil {
+		return "", fmt.Errorf("error while reading the uploaded resource: %w", err)
+	}
+	actual, err := canonicalResource(readBack, references)
+	if err != nil {
+		return "", fmt.Errorf("error while reading the resource read back: %w", err)
+	}
+	if _, ok := expected["text"]; !ok {
+		delete(actual, "text")
+	}
+	if difference := firstDifference(expected, actual, fmt.Sprint(expected["resourceType"])); difference != "" {
+		return fmt.Sprintf("differs at %s", difference), nil
+	}
+	return "", nil
+}
+
+// readBack reads the resource with the given type and id.
+func readBack(client *fhir.Client, resourceType string, id string) ([]byte, error) {
+	req, err := client.NewReadRequest(resourceType, id)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return nil, fmt.Errorf("error while reading %s/%s:\n\n%s", resourceType, id, errorResponse.String())
+	}
+	return body, nil
+}
+
+// verifySampledEntries reads back the resources of up to n randomly chosen
+// entries of the uploaded bundle in content and compares them with the
+// uploaded resources. The entries are identified by the locations in
+// mappings. Returns the number of verified entries together with a
+// description of each mismatch by entry index.
+func verifySampledEntries(client *fhir.Client, content []byte, mappings []idMapping, n int) (int, map[int]string) {
+	var bundle struct {
+		Entry []struct {
+			Resource json.RawMessage `json:"resource"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return 0, map[int]string{0: fmt.Sprintf("error while reading the uploaded bundle: %v", err)}
+	}
+
+	// references to fullUrls are resolved by the server to the assigned ids
+	references := make(map[string]string)
+	var candidates []idMapping
+	for _, mapping := range mappings {
+		if mapping.location == "" || mapping.entryIndex >= len(bundle.Entry) || len(bundle.Entry[mapping.entryIndex].Resource) == 0 {
+			continue
+		}
+		id, err := idFromLocation(mapping.location, mapping.resourceType)
+		if err != nil {
+			continue
+		}
+		if mapping.fullUrl != "" {
+			references[mapping.fullUrl] = mapping.resourceType + "/" + id
+		}
+		mapping.id = id
+		candidates = append(candidates, mapping)
+	}
+
+	mismatches := make(map[int]string)
+	sample := rand.Perm(len(candidates))
+	if len(sample) > n {
+		sample = sample[:n]
+	}
+	for _, i := range sample {
+		mapping := candidates[i]
+		resource, err := readBack(client, mapping.resourceType, mapping.id)
+		if err != nil {
+			mismatches[mapping.entryIndex] = err.Error()
+			continue
+		}
+		difference, err := verifyResource(bundle.Entry[mapping.entryIndex].Resource, resource, references)
+		if err != nil {
+			mismatches[mapping.entryIndex] = err.Error()
+		} else if difference != "" {
+			mismatches[mapping.entryIndex] = fmt.Sprintf("%s/%s %s", mapping.resourceType, mapping.id, difference)
+		}
+	}
+	return len(sample), mismatches
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFirstDifference(t *testing.T) {
+	expected := map[string]interface{}{
+		"name":   []interface{}{map[string]interface{}{"family": "Doe"}},
+		"active": true,
+	}
+
+	t.Run("Equal", func(t *testing.T) {
+		actual := map[string]interface{}{
+			"active": true,
+			"name":   []interface{}{map[string]interface{}{"family": "Doe"}},
+		}
+		assert.Empty(t, firstDifference(expected, actual, "Patient"))
+	})
+
+	t.Run("ChangedValue", func(t *testing.T) {
+		actual := map[string]interface{}{
+			"active": true,
+			"name":   []interface{}{map[string]interface{}{"family": "Do"}},
+		}
+		assert.Equal(t, "Patient.name[0].family", firstDifference(expected, actual, "Patient"))
+	})
+
+	t.Run("MissingElement", func(t *testing.T) {
+		actual := map[string]interface{}{
+			"name": []interface{}{map[string]interface{}{"family": "Doe"}},
+		}
+		assert.Equal(t, "Patient.active", firstDifference(expected, actual, "Patient"))
+	})
+
+	t.Run("AddedElement", func(t *testing.T) {
+		actual := map[string]interface{}{
+			"active":    true,
+			"birthDate": "2000",
+			"name":      []interface{}{map[string]interface{}{"family": "Doe"}},
+		}
+		assert.Equal(t, "Patient.birthDate", firstDifference(expected, actual, "Patient"))
+	})
+
+	t.Run("DifferentLength", func(t *testing.T) {
+		actual := map[string]interface{}{
+			"active": true,
+			"name":   []interface{}{},
+		}
+		assert.Equal(t, "Patient.name", firstDifference(expected, actual, "Patient"))
+	})
+}
+
+func TestVerifyResource(t *testing.T) {
+	references := map[string]string{"urn:uuid:a": "Patient/1"}
+	uploaded := []byte(`{"resourceType": "Observation", "status": "final", "subject": {"reference": "urn:uuid:a"},
+  "valueQuantity": {"value": 1.50}}`)
+
+	t.Run("Equal", func(t *testing.T) {
+		difference, err := verifyResource(uploaded, []byte(`{"resourceType": "Observation", "id": "2",
+  "meta": {"versionId": "1"}, "text": {"status": "generated"}, "status": "final",
+  "subject": {"reference": "Patient/1"}, "valueQuantity": {"value": 1.5}}`), references)
+
+		if assert.NoError(t, err) {
+			assert.Empty(t, difference)
+		}
+	})
+
+	t.Run("Mangled", func(t *testing.T) {
+		difference, err := verifyResource(uploaded, []byte(`{"resourceType": "Observation", "id": "2",
+  "status": "final", "subject": {"reference": "Patient/1"}, "valueQuantity": {"value": 1}}`), references)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "differs at Observation.valueQuantity.value", difference)
+		}
+	})
+}
+
+func TestVerifySampledEntries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Patient/1":
+			_, _ = w.Write([]byte(`{"resourceType": "Patient", "id": "1", "gender": "female"}`))
+		case "/Observation/2":
+			_, _ = w.Write([]byte(`{"resourceType": "Observation", "id": "2", "subject": {"reference": "Patient/1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := fhir.NewClient(*baseURL, nil)
+
+	content := []byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [
+  {"fullUrl": "urn:uuid:a", "resource": {"resourceType": "Patient", "gender": "male"}},
+  {"fullUrl": "urn:uuid:b", "resource": {"resourceType": "Observation", "subject": {"reference": "urn:uuid:a"}}},
+  {"fullUrl": "urn:uuid:c", "resource": {"resourceType": "Observation"}},
+  {"request": {"method": "DELETE", "url": "Observation/4"}}
+]}`)
+	mappings := []idMapping{
+		{entryIndex: 0, fullUrl: "urn:uuid:a", resourceType: "Patient", location: "Patient/1/_history/1"},
+		{entryIndex: 1, fullUrl: "urn:uuid:b", resourceType: "Observation", location: server.URL + "/Observation/2/_history/1"},
+		{entryIndex: 2, fullUrl: "urn:uuid:c", resourceType: "Observation", location: "Observation/3/_history/1"},
+		{entryIndex: 3},
+	}
+
+	t.Run("All", func(t *testing.T) {
+		verified, mismatches := verifySampledEntries(client, content, mappings, 10)
+
+		assert.Equal(t, 3, verified)
+		assert.Equal(t, "Patient/1 differs at Patient.gender", mismatches[0])
+		assert.NotContains(t, mismatches, 1)
+		assert.Contains(t, mismatches[2], "error while reading Observation/3")
+	})
+
+	t.Run("Sample", func(t *testing.T) {
+		verified, mismatches := verifySampledEntries(client, content, mappings, 1)
+
+		assert.Equal(t, 1, verified)
+		assert.LessOrEqual(t, len(mismatches), 1)
+	})
+}
+
+func TestFmtUploadReportVerified(t *testing.T) {
+	verifySample = 2
+	defer func() { verifySample = 0 }()
+
+	results := aggregatedUploadResults{
+		totalProcessedBundles: 1,
+		requestDurations:      util.NewDurationDigest(1),
+		processingDurations:   util.NewDurationDigest(0.5),
+		errorResponses:        map[bundleIdentifier]util.ErrorResponse{},
+		errors:                map[bundleIdentifier]error{},
+		verified:              2,
+		mismatches: map[entryIdentifier]string{
+			{bundleId: bundleIdentifier{filename: "a.json", bundleNumber: 1}, entryIndex: 3}: "Patient/1 differs at Patient.gender",
+		},
+	}
+
+	report := fmtUploadReport(util.UnitFormat{Raw: true}, results, time.Second)
+
+	assert.Contains(t, report, "Verified         [total, mismatches]                   2, 1\n")
+	assert.Contains(t, report, "Verification Mismatches:\n\nFile: a.json [Bundle: 1, Entry: 3]\n    Patient/1 differs at Patient.gender\n")
+}
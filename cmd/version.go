@@ -0,0 +1,107 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/spf13/cobra"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func fetchCapabilityStatement(client *fhir.Client) (fm.CapabilityStatement, error) {
+	req, err := client.NewCapabilitiesRequest()
+	if err != nil {
+		return fm.CapabilityStatement{}, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fm.CapabilityStatement{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fm.CapabilityStatement{}, fmt.Errorf("non-OK status while fetching the capability statement: %s", resp.Status)
+	}
+	return fhir.ReadCapabilityStatement(resp.Body)
+}
+
+// fmtServerSoftware formats the software and implementation information of
+// capabilityStatement. Values not given by the server are omitted.
+func fmtServerSoftware(capabilityStatement fm.CapabilityStatement) string {
+	builder := strings.Builder{}
+	if software := capabilityStatement.Software; software != nil {
+		builder.WriteString(fmt.Sprintf("Software    : %s\n", software.Name))
+		if software.Version != nil {
+			builder.WriteString(fmt.Sprintf("Version     : %s\n", *software.Version))
+		}
+		if software.ReleaseDate != nil {
+			builder.WriteString(fmt.Sprintf("Released    : %s\n", *software.ReleaseDate))
+		}
+	}
+	if implementation := capabilityStatement.Implementation; implementation != nil {
+		if implementation.Description != "" {
+			builder.WriteString(fmt.Sprintf("Description : %s\n", implementation.Description))
+		}
+		if implementation.Url != nil {
+			builder.WriteString(fmt.Sprintf("URL         : %s\n", *implementation.Url))
+		}
+	}
+	builder.WriteString(fmt.Sprintf("FHIR Version: %s\n", capabilityStatement.FhirVersion.Code()))
+	return builder.String()
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Prints the version of blazectl and a server",
+	Long: `Prints the version of blazectl. With --server, the name, version and release
+date of the server software are fetched from its CapabilityStatement and
+printed as well. Please include this information in bug reports.
+
+Examples:
+  blazectl version
+  blazectl version --server "http://localhost:8080/fhir"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Printf("blazectl    : %s\n", rootCmd.Version)
+		if server == "" {
+			return nil
+		}
+
+		err := createClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		capabilityStatement, err := fetchCapabilityStatement(client)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nServer %s\n\n", server)
+		fmt.Print(fmtServerSoftware(capabilityStatement))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+
+	versionCmd.Flags().StringVar(&server, "server", "", "the base URL of the server to use")
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestFetchServerSoftware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/metadata", r.URL.Path)
+		w.Header().Set("Content-Type", "application/fhir+json")
+		_, _ = w.Write([]byte(`{
+  "resourceType": "CapabilityStatement",
+  "status": "active",
+  "kind": "instance",
+  "fhirVersion": "4.0.1",
+  "software": {"name": "Blaze", "version": "0.30.0", "releaseDate": "2024-09-01"},
+  "implementation": {"description": "Blaze", "url": "http://localhost:8080/fhir"}
+}`))
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	capabilityStatement, err := fetchCapabilityStatement(fhir.NewClient(*baseURL, nil))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `Software    : Blaze
+Version     : 0.30.0
+Released    : 2024-09-01
+Description : Blaze
+URL         : http://localhost:8080/fhir
+FHIR Version: 4.0.1
+`, fmtServerSoftware(capabilityStatement))
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samply/blazectl/fhir"
+	"github.com/samply/blazectl/util"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var replayVersions bool
+
+// resourceVersion is the location of one version of a resource in an NDJSON
+// file.
+type resourceVersion struct {
+	versionId   string
+	lastUpdated time.Time
+	path        string
+	line        int
+	offset      int64
+	length      int
+	// the position of the version in the input, used if neither the versionId
+	// nor lastUpdated order the versions
+	ordinal int
+}
+
+// before returns whether v is older than other. Numeric versionIds, like the
+// ones of Blaze, are compared by their number, otherwise the versions are
+// ordered by lastUpdated and finally by their position in the input.
+func (v resourceVersion) before(other resourceVersion) bool {
+	a, errA := strconv.ParseInt(v.versionId, 10, 64)
+	b, errB := strconv.ParseInt(other.versionId, 10, 64)
+	if errA == nil && errB == nil && a != b {
+		return a < b
+	}
+	if !v.lastUpdated.IsZero() && !other.lastUpdated.IsZero() && !v.lastUpdated.Equal(other.lastUpdated) {
+		return v.lastUpdated.Before(other.lastUpdated)
+	}
+	return v.ordinal < other.ordinal
+}
+
+// versionChain are all versions of one resource, the oldest first.
+type versionChain struct {
+	resourceType string
+	id           string
+	versions     []resourceVersion
+}
+
+func (c versionChain) key() string {
+	return c.resourceType + "/" + c.id
+}
+
+// readVersionChains reads the resources of all NDJSON files and groups them
+// by type and id into version chains, ordered by key. Versions with the same
+// versionId are only replayed once. Only the location of each version is
+// kept in memory.
+func readVersionChains(files []string) ([]versionChain, int, error) {
+	chains := make(map[string]*versionChain)
+	var ordinal int
+	for _, path := range files {
+		if err := readVersions(path, func(resourceType string, id string, version resourceVersion) {
+			version.ordinal = ordinal
+			ordinal++
+			key := resourceType + "/" + id
+			chain, ok := chains[key]
+			if !ok {
+				chain = &versionChain{resourceType: resourceType, id: id}
+				chains[key] = chain
+			}
+			chain.versions = append(chain.versions, version)
+		}); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var versions int
+	result := make([]versionChain, 0, len(chains))
+	for _, chain := range chains {
+		sort.SliceStable(chain.versions, func(i, j int) bool {
+			return chain.versions[i].before(chain.versions[j])
+		})
+		unique := chain.versions[:0]
+		for i, version := range chain.versions {
+			if i > 0 && version.versionId != "" && version.versionId == unique[len(unique)-1].versionId {
+				continue
+			}
+			unique = append(unique, version)
+		}
+		chain.versions = unique
+		versions += len(unique)
+		result = append(result, *chain)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].key() < result[j].key()
+	})
+	return result, versions, nil
+}
+
+// readVersions calls add for each resource of the NDJSON file at path.
+func readVersions(path string, add func(resourceType string, id string, version resourceVersion)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	bom, err := util.SkipBOM(reader)
+	if err != nil {
+		return fmt.Errorf("error while reading %s: %w", path, err)
+	}
+	offset := int64(bom)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var resource struct {
+				ResourceType string `json:"resourceType"`
+				Id           string `json:"id"`
+				Meta         struct {
+					VersionId   string `json:"versionId"`
+					LastUpdated string `json:"lastUpdated"`
+				} `json:"meta"`
+			}
+			if err := json.Unmarshal(line, &resource); err != nil {
+				return fmt.Errorf("error while reading the resource in file %s at line %d: %v", path, lineNumber, err)
+			}
+			if resource.ResourceType == "" || resource.Id == "" {
+				return fmt.Errorf("the resource in file %s at line %d has no type or id", path, lineNumber)
+			}
+			lastUpdated, _ := parseInstant(resource.Meta.LastUpdated)
+			add(resource.ResourceType, resource.Id, resourceVersion{
+				versionId:   resource.Meta.VersionId,
+				lastUpdated: lastUpdated,
+				path:        path,
+				line:        lineNumber,
+				offset:      offset,
+				length:      len(line),
+			})
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readVersion reads the resource of version from its file.
+func readVersion(version resourceVersion) ([]byte, error) {
+	file, err := os.Open(version.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content := make([]byte, version.length)
+	if _, err := file.ReadAt(content, version.offset); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// replayResult is the outcome of replaying one version chain. Replaying
+// stops at the first failed version, so that the versions on the server stay
+// in order.
+type replayResult struct {
+	chain    versionChain
+	replayed int
+	err      error
+}
+
+// replayChain updates the resource of chain with each of its versions, the
+// oldest first.
+func replayChain(ctx context.Context, client *fhir.Client, chain versionChain) replayResult {
+	result := replayResult{chain: chain}
+	for _, version := range chain.versions {
+		if err := ctx.Err(); err != nil {
+			result.err = err
+			return result
+		}
+		if err := replayVersion(ctx, client, chain, version); err != nil {
+			result.err = fmt.Errorf("error while replaying version %d of %s: %w", result.replayed+1, chain.key(), err)
+			return result
+		}
+		result.replayed++
+	}
+	return result
+}
+
+func replayVersion(ctx context.Context, client *fhir.Client, chain versionChain, version resourceVersion) error {
+	content, err := readVersion(version)
+	if err != nil {
+		return err
+	}
+	if uploadTransform != nil {
+		if content, err = applyTransform(uploadTransform, content, version.path, version.line); err != nil {
+			return err
+		}
+	}
+	req, err := client.NewUpdateRequest(chain.resourceType, chain.id, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		errorResponse := util.NewErrorResponse(resp, body)
+		return errors.New(errorResponse.String())
+	}
+	return nil
+}
+
+// replayChains replays all chains with the given concurrency. Different
+// resources are replayed in parallel, the versions of one resource one after
+// the other. Stops taking new chains as soon as ctx is cancelled.
+func replayChains(ctx context.Context, client *fhir.Client, chains []versionChain, concurrency int) []replayResult {
+	pool := util.NewWorkerPool(ctx, concurrency, 0)
+	var mutex sync.Mutex
+	var results []replayResult
+	for _, chain := range chains {
+		var result replayResult
+		if !pool.Go(func(ctx context.Context) error {
+			result = replayChain(ctx, client, chain)
+			return nil
+		}, func(err error) {
+			if err != nil {
+				result = replayResult{chain: chain, replayed: result.replayed, err: err}
+			}
+			mutex.Lock()
+			results = append(results, result)
+			mutex.Unlock()
+		}) {
+			break
+		}
+	}
+	pool.Wait()
+	return results
+}
+
+func fmtReplayReport(units util.UnitFormat, chains []versionChain, versions int, results []replayResult, duration time.Duration) string {
+	var replayed, failed int
+	for _, result := range results {
+		replayed += result.replayed
+		if result.err != nil {
+			failed++
+		}
+	}
+
+	builder := strings.Builder{}
+	fmt.Fprintf(&builder, "Resources        [total, failed]                       %d, %d\n", len(chains), failed)
+	fmt.Fprintf(&builder, "Versions         [total, replayed]                     %d, %d\n", versions, replayed)
+	fmt.Fprintf(&builder, "Duration         [total]                               %s\n", units.Duration(duration))
+	fmt.Fprintf(&builder, "Versions         [rate]                                %.2f/s\n", float64(replayed)/duration.Seconds())
+
+	if failed > 0 {
+		builder.WriteString("\nErrors:\n")
+		sort.Slice(results, func(i, j int) bool {
+			return results[i].chain.key() < results[j].chain.key()
+		})
+		for _, result := range results {
+			if result.err != nil && !errors.Is(result.err, context.Canceled) {
+				fmt.Fprintf(&builder, "%v\n", result.err)
+			}
+		}
+	}
+	return builder.String()
+}
+
+// uploadVersions replays the versions of all resources in the NDJSON files of
+// dir. Exits on failures.
+func uploadVersions(dir string) error {
+	files, err := findProcessableFiles(dir)
+	if err != nil {
+		return err
+	}
+	chains, versions, err := readVersionChains(files.multiBundleFiles)
+	if err != nil {
+		return err
+	}
+	if len(chains) == 0 {
+		fmt.Println("Found no resources to upload.")
+		return nil
+	}
+
+	summary := createSummaryFileOrDie()
+	if summary != nil {
+		defer summary.Close()
+	}
+
+	fmt.Printf("Replaying %d versions of %d resources to %s ...\n", versions, len(chains), server)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	results := replayChains(ctx, client, chains, concurrency)
+	interrupted := ctx.Err() != nil
+	stop()
+	client.CloseIdleConnections()
+
+	report := fmtReplayReport(unitFormat(), chains, versions, results, time.Since(start))
+	if interrupted {
+		report = "Upload interrupted. The statistics only cover the resources processed so far.\n" + report
+	}
+	writeSummary(os.Stdout, summary, report)
+
+	if interrupted {
+		os.Exit(interruptedExitCode)
+	}
+	for _, result := range results {
+		if result.err != nil {
+			os.Exit(1)
+		}
+	}
+	return nil
+}
+
+// checkReplayVersions returns an error if a flag which only applies to the
+// upload of bundles is given together with --replay-versions.
+func checkReplayVersions(cmd *cobra.Command) error {
+	for _, flag := range []string{"async", "id-map-file", "validate-local", "verify-sample", "externalize-attachments", "on-conflict",
+		"pre-bundle-cmd", "post-bundle-cmd"} {
+		if cmd.Flags().Changed(flag) {
+			return fmt.Errorf("the flags --replay-versions and --%s can't be used together", flag)
+		}
+	}
+	return nil
+}
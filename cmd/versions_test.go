@@ -0,0 +1,172 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"github.com/samply/blazectl/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+)
+
+func writeVersionsFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "history.ndjson")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal("can't create a temp file")
+	}
+	return path
+}
+
+func TestResourceVersionBefore(t *testing.T) {
+	t.Run("NumericVersionIds", func(t *testing.T) {
+		assert.True(t, resourceVersion{versionId: "9", ordinal: 1}.before(resourceVersion{versionId: "10", ordinal: 0}))
+	})
+
+	t.Run("LastUpdated", func(t *testing.T) {
+		older, _ := parseInstant("2024-01-01T00:00:00Z")
+		newer, _ := parseInstant("2024-01-02T00:00:00Z")
+		assert.True(t, resourceVersion{versionId: "b", lastUpdated: older, ordinal: 1}.before(resourceVersion{versionId: "a", lastUpdated: newer}))
+	})
+
+	t.Run("Ordinal", func(t *testing.T) {
+		assert.True(t, resourceVersion{ordinal: 0}.before(resourceVersion{ordinal: 1}))
+		assert.False(t, resourceVersion{ordinal: 1}.before(resourceVersion{ordinal: 0}))
+	})
+}
+
+func TestReadVersionChains(t *testing.T) {
+	path := writeVersionsFile(t, `{"resourceType":"Patient","id":"0","meta":{"versionId":"2"}}
+{"resourceType":"Observation","id":"0","meta":{"versionId":"1"}}
+{"resourceType":"Patient","id":"0","meta":{"versionId":"1"}}
+
+{"resourceType":"Patient","id":"0","meta":{"versionId":"2"}}
+`)
+
+	chains, versions, err := readVersionChains([]string{path})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, versions)
+		if assert.Len(t, chains, 2) {
+			assert.Equal(t, "Observation/0", chains[0].key())
+			assert.Equal(t, "Patient/0", chains[1].key())
+			if assert.Len(t, chains[1].versions, 2) {
+				content, _ := readVersion(chains[1].versions[0])
+				assert.Equal(t, "{\"resourceType\":\"Patient\",\"id\":\"0\",\"meta\":{\"versionId\":\"1\"}}\n", string(content))
+				assert.Equal(t, "2", chains[1].versions[1].versionId)
+			}
+		}
+	}
+
+	t.Run("WithoutId", func(t *testing.T) {
+		path := writeVersionsFile(t, "{\"resourceType\":\"Patient\"}\n")
+
+		_, _, err := readVersionChains([]string{path})
+
+		assert.EqualError(t, err, "the resource in file "+path+" at line 1 has no type or id")
+	})
+}
+
+func TestReplayChains(t *testing.T) {
+	path := writeVersionsFile(t, `{"resourceType":"Patient","id":"0","meta":{"versionId":"2"},"active":true}
+{"resourceType":"Patient","id":"0","meta":{"versionId":"1"}}
+{"resourceType":"Patient","id":"1","meta":{"versionId":"1"}}
+{"resourceType":"Patient","id":"1","meta":{"versionId":"2"}}
+`)
+	chains, _, err := readVersionChains([]string{path})
+	if err != nil {
+		t.Fatalf("error while reading the versions: %v", err)
+	}
+
+	var mutex sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		mutex.Unlock()
+
+		if r.URL.Path == "/Patient/1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	results := replayChains(context.Background(), fhir.NewClient(*baseURL, nil), chains, 2)
+
+	if assert.Len(t, results, 2) {
+		for _, result := range results {
+			switch result.chain.id {
+			case "0":
+				assert.Equal(t, 2, result.replayed)
+				assert.NoError(t, result.err)
+			case "1":
+				assert.Equal(t, 0, result.replayed)
+				assert.Error(t, result.err)
+			}
+		}
+	}
+	assert.Contains(t, requests, "PUT /Patient/0 {\"resourceType\":\"Patient\",\"id\":\"0\",\"meta\":{\"versionId\":\"1\"}}\n")
+	assert.Len(t, requests, 3)
+
+	var patient0 []string
+	for _, request := range requests {
+		if strings.HasPrefix(request, "PUT /Patient/0") {
+			patient0 = append(patient0, request)
+		}
+	}
+	assert.Equal(t, []string{
+		"PUT /Patient/0 {\"resourceType\":\"Patient\",\"id\":\"0\",\"meta\":{\"versionId\":\"1\"}}\n",
+		"PUT /Patient/0 {\"resourceType\":\"Patient\",\"id\":\"0\",\"meta\":{\"versionId\":\"2\"},\"active\":true}\n",
+	}, patient0)
+}
+
+func TestReplayVersionTransform(t *testing.T) {
+	path := writeVersionsFile(t, "\n{\"resourceType\":\"Patient\",\"id\":\"0\"}\n")
+	chains, _, err := readVersionChains([]string{path})
+	if err != nil {
+		t.Fatalf("error while reading the versions: %v", err)
+	}
+
+	var request string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		request = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	uploadTransform = template.Must(template.New("transform").Funcs(templateFuncs).
+		Parse(`{"resourceType":"Patient","id":{{ json .Value.id }},"line":{{ .Index }}}`))
+	defer func() { uploadTransform = nil }()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	err = replayVersion(context.Background(), fhir.NewClient(*baseURL, nil), chains[0], chains[0].versions[0])
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"resourceType":"Patient","id":"0","line":2}`, request)
+	}
+}
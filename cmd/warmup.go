@@ -0,0 +1,67 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var warmupFlag string
+
+// warmup is the warm-up phase whose samples are excluded from latency
+// statistics. It is either given as number of samples, like bundles or pages,
+// or as duration since the start.
+type warmup struct {
+	samples  int
+	duration time.Duration
+}
+
+// statsWarmup is the warm-up parsed from --warmup.
+var statsWarmup warmup
+
+// parseWarmup parses a warm-up given either as number of samples like 10 or as
+// duration like 30s. An empty value means no warm-up.
+func parseWarmup(value string) (warmup, error) {
+	if value == "" {
+		return warmup{}, nil
+	}
+	if samples, err := strconv.Atoi(value); err == nil && samples >= 0 {
+		return warmup{samples: samples}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return warmup{duration: duration}, nil
+	}
+	return warmup{}, fmt.Errorf("invalid --warmup value `%s`, expected a number of samples like 10 or a duration like 30s", value)
+}
+
+// enabled returns whether the warm-up excludes any samples.
+func (w warmup) enabled() bool {
+	return w.samples > 0 || w.duration > 0
+}
+
+// includes returns whether the sample with the given zero-based index, taken
+// elapsed after the start, belongs to the warm-up.
+func (w warmup) includes(index int, elapsed time.Duration) bool {
+	return index < w.samples || elapsed < w.duration
+}
+
+func (w warmup) String() string {
+	if w.duration > 0 {
+		return w.duration.String()
+	}
+	return strconv.Itoa(w.samples)
+}
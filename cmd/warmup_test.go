@@ -0,0 +1,63 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseWarmup(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		w, err := parseWarmup("")
+
+		if assert.NoError(t, err) {
+			assert.False(t, w.enabled())
+		}
+	})
+
+	t.Run("samples", func(t *testing.T) {
+		w, err := parseWarmup("10")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, warmup{samples: 10}, w)
+			assert.Equal(t, "10", w.String())
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		w, err := parseWarmup("30s")
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, warmup{duration: 30 * time.Second}, w)
+			assert.Equal(t, "30s", w.String())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseWarmup("-1")
+
+		assert.ErrorContains(t, err, "invalid --warmup value `-1`")
+	})
+}
+
+func TestWarmupIncludes(t *testing.T) {
+	assert.True(t, warmup{samples: 2}.includes(1, time.Hour))
+	assert.False(t, warmup{samples: 2}.includes(2, 0))
+	assert.True(t, warmup{duration: time.Second}.includes(100, 500*time.Millisecond))
+	assert.False(t, warmup{duration: time.Second}.includes(0, time.Second))
+	assert.False(t, warmup{}.includes(0, 0))
+}
@@ -1,6 +1,12 @@
 package data
 
 type Population struct {
+	Code            string
+	Expression      string
+	AggregateMethod string `yaml:"aggregateMethod"`
+}
+
+type StratifierComponent struct {
 	Code       string
 	Expression string
 }
@@ -8,6 +14,7 @@ type Population struct {
 type Stratifier struct {
 	Code       string
 	Expression string
+	Component  []StratifierComponent
 }
 
 type Group struct {
@@ -16,7 +23,14 @@ type Group struct {
 	Stratifier []Stratifier
 }
 
+type ValueSet struct {
+	Url  string
+	File string
+}
+
 type Measure struct {
-	Library string
-	Group   []Group
+	Library  string
+	Scoring  string
+	ValueSet []ValueSet `yaml:"valueSet"`
+	Group    []Group
 }
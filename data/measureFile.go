@@ -0,0 +1,197 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MeasureFileError lists all problems found in a measure file.
+type MeasureFileError struct {
+	Filename string
+	// Problems in the form "line N: message"
+	Problems []string
+}
+
+func (err *MeasureFileError) Error() string {
+	return fmt.Sprintf("invalid measure file %s:\n  %s", err.Filename, strings.Join(err.Problems, "\n  "))
+}
+
+// ReadMeasureFile reads and validates the measure file with the given name.
+// Unknown keys, values of wrong type and missing required values are reported
+// together with their line in a MeasureFileError. The paths of the library and
+// the code lists of value sets are resolved relative to the directory of the
+// measure file.
+func ReadMeasureFile(filename string) (*Measure, error) {
+	return ReadMeasureFileWith(filename, nil)
+}
+
+// ReadMeasureFileWith reads the measure file with the given name like
+// ReadMeasureFile after substituting vars. See Substitute.
+func ReadMeasureFileWith(filename string, vars map[string]string) (*Measure, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = Substitute(filepath.Base(filename), content, vars); err != nil {
+		return nil, fmt.Errorf("invalid measure file %s: %w", filename, err)
+	}
+
+	measure, problems := parseMeasure(content)
+	if measure != nil && measure.Library != "" && !filepath.IsAbs(measure.Library) {
+		measure.Library = filepath.Join(filepath.Dir(filename), measure.Library)
+	}
+	if measure != nil && measure.Library != "" {
+		if _, err := os.Stat(measure.Library); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: library file `%s` doesn't exist", measure.libraryLine, measure.Library))
+		}
+	}
+	if measure != nil {
+		for i := range measure.ValueSet {
+			valueSet := &measure.ValueSet[i]
+			if valueSet.File == "" {
+				continue
+			}
+			if !filepath.IsAbs(valueSet.File) {
+				valueSet.File = filepath.Join(filepath.Dir(filename), valueSet.File)
+			}
+			if _, err := os.Stat(valueSet.File); err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: code list file `%s` doesn't exist", measure.valueSetFileLines[i], valueSet.File))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &MeasureFileError{Filename: filename, Problems: problems}
+	}
+	return &measure.Measure, nil
+}
+
+type parsedMeasure struct {
+	Measure
+	libraryLine int
+	// the lines of the code list filenames by value set
+	valueSetFileLines []int
+}
+
+// parseMeasure parses the content of a measure file. Returns the measure, if
+// the content could be decoded, together with all problems found.
+func parseMeasure(content []byte) (*parsedMeasure, []string) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+	if len(root.Content) == 0 {
+		return nil, []string{"line 1: empty measure file"}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	var measure parsedMeasure
+	if err := decoder.Decode(&measure.Measure); err != nil {
+		var typeError *yaml.TypeError
+		if errors.As(err, &typeError) {
+			return nil, typeError.Errors
+		}
+		return nil, []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	var problems []string
+	addProblem := func(node *yaml.Node, format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf("line %d: %s", node.Line, fmt.Sprintf(format, a...)))
+	}
+	document := root.Content[0]
+	library := mappingValue(document, "library")
+	if library == nil || library.Value == "" {
+		addProblem(document, "missing CQL library filename")
+	} else {
+		measure.libraryLine = library.Line
+	}
+	if valueSets := mappingValue(document, "valueSet"); valueSets != nil {
+		for i, valueSet := range valueSets.Content {
+			if url := mappingValue(valueSet, "url"); url == nil || url.Value == "" {
+				addProblem(valueSet, "valueSet[%d]: missing url", i)
+			}
+			file := mappingValue(valueSet, "file")
+			if file == nil || file.Value == "" {
+				addProblem(valueSet, "valueSet[%d]: missing code list filename", i)
+				measure.valueSetFileLines = append(measure.valueSetFileLines, valueSet.Line)
+			} else {
+				measure.valueSetFileLines = append(measure.valueSetFileLines, file.Line)
+			}
+		}
+	}
+	groups := mappingValue(document, "group")
+	if groups == nil || len(groups.Content) == 0 {
+		addProblem(document, "missing group")
+		return &measure, problems
+	}
+	for i, group := range groups.Content {
+		populations := mappingValue(group, "population")
+		if populations == nil || len(populations.Content) == 0 {
+			addProblem(group, "group[%d]: missing population", i)
+		} else {
+			for j, population := range populations.Content {
+				if expression := mappingValue(population, "expression"); expression == nil || expression.Value == "" {
+					addProblem(population, "group[%d].population[%d]: missing expression name", i, j)
+				}
+			}
+		}
+		if stratifiers := mappingValue(group, "stratifier"); stratifiers != nil {
+			for j, stratifier := range stratifiers.Content {
+				if code := mappingValue(stratifier, "code"); code == nil || code.Value == "" {
+					addProblem(stratifier, "group[%d].stratifier[%d]: missing code", i, j)
+				}
+				expression := mappingValue(stratifier, "expression")
+				components := mappingValue(stratifier, "component")
+				if components != nil && len(components.Content) > 0 {
+					if expression != nil {
+						addProblem(stratifier, "group[%d].stratifier[%d]: expression and component can't be used together", i, j)
+					}
+					for k, component := range components.Content {
+						if code := mappingValue(component, "code"); code == nil || code.Value == "" {
+							addProblem(component, "group[%d].stratifier[%d].component[%d]: missing code", i, j, k)
+						}
+						if expression := mappingValue(component, "expression"); expression == nil || expression.Value == "" {
+							addProblem(component, "group[%d].stratifier[%d].component[%d]: missing expression name", i, j, k)
+						}
+					}
+				} else if expression == nil || expression.Value == "" {
+					addProblem(stratifier, "group[%d].stratifier[%d]: missing expression name", i, j)
+				}
+			}
+		}
+	}
+	return &measure, problems
+}
+
+// mappingValue returns the value of the given key of a mapping node or nil if
+// there is no such key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
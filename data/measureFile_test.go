@@ -0,0 +1,237 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeMeasureFile(t *testing.T, content string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "library.cql"), []byte("library Retrieve"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "measure.yml")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func measureFileProblems(t *testing.T, err error) []string {
+	var measureFileError *MeasureFileError
+	if !assert.ErrorAs(t, err, &measureFileError) {
+		return nil
+	}
+	return measureFileError.Problems
+}
+
+func TestReadMeasureFile(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: library.cql
+group:
+- type: Condition
+  population:
+  - expression: InInitialPopulation
+  stratifier:
+  - code: code
+    expression: Code
+`)
+
+		measure, err := ReadMeasureFile(filename)
+
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(filepath.Dir(filename), "library.cql"), measure.Library)
+		assert.Equal(t, "Condition", measure.Group[0].Type)
+		assert.Equal(t, "Code", measure.Group[0].Stratifier[0].Expression)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: library.cql
+group:
+- population:
+  - expresion: InInitialPopulation
+`)
+
+		_, err := ReadMeasureFile(filename)
+
+		assert.Equal(t, []string{"line 4: field expresion not found in type data.Population"}, measureFileProblems(t, err))
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: library.cql
+group:
+  population: foo
+`)
+
+		_, err := ReadMeasureFile(filename)
+
+		assert.Equal(t, []string{"line 3: cannot unmarshal !!map into []data.Group"}, measureFileProblems(t, err))
+	})
+
+	t.Run("missing values", func(t *testing.T) {
+		filename := writeMeasureFile(t, `group:
+- population:
+  - code: foo
+  stratifier:
+  - expression: Code
+- type: Patient
+`)
+
+		_, err := ReadMeasureFile(filename)
+
+		assert.Equal(t, []string{
+			"line 1: missing CQL library filename",
+			"line 3: group[0].population[0]: missing expression name",
+			"line 5: group[0].stratifier[0]: missing code",
+			"line 6: group[1]: missing population",
+		}, measureFileProblems(t, err))
+	})
+
+	t.Run("stratifier with components", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: library.cql
+scoring: proportion
+group:
+- population:
+  - expression: InInitialPopulation
+  - code: numerator
+    expression: InNumerator
+  stratifier:
+  - code: age-gender
+    component:
+    - code: age
+      expression: AgeClass
+    - code: gender
+      expression: Gender
+`)
+
+		m, err := ReadMeasureFile(filename)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		assert.Equal(t, "proportion", m.Scoring)
+		assert.Equal(t, "numerator", m.Group[0].Population[1].Code)
+		assert.Equal(t, []StratifierComponent{
+			{Code: "age", Expression: "AgeClass"},
+			{Code: "gender", Expression: "Gender"},
+		}, m.Group[0].Stratifier[0].Component)
+	})
+
+	t.Run("invalid stratifier components", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: library.cql
+group:
+- population:
+  - expression: InInitialPopulation
+  stratifier:
+  - code: age-gender
+    expression: AgeClass
+    component:
+    - code: gender
+    - expression: Gender
+`)
+
+		_, err := ReadMeasureFile(filename)
+
+		assert.Equal(t, []string{
+			"line 6: group[0].stratifier[0]: expression and component can't be used together",
+			"line 9: group[0].stratifier[0].component[0]: missing expression name",
+			"line 10: group[0].stratifier[0].component[1]: missing code",
+		}, measureFileProblems(t, err))
+	})
+
+	t.Run("missing library file", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: other.cql
+group:
+- population:
+  - expression: InInitialPopulation
+`)
+
+		_, err := ReadMeasureFile(filename)
+
+		assert.Equal(t, []string{"line 1: library file `" + filepath.Join(filepath.Dir(filename), "other.cql") + "` doesn't exist"},
+			measureFileProblems(t, err))
+	})
+
+	t.Run("invalid YAML", func(t *testing.T) {
+		filename := writeMeasureFile(t, "library: [")
+
+		_, err := ReadMeasureFile(filename)
+
+		assert.Len(t, measureFileProblems(t, err), 1)
+	})
+
+	t.Run("with variables", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: {{ .library }}
+group:
+- population:
+  - expression: {{ .expression }}
+`)
+
+		measure, err := ReadMeasureFileWith(filename, map[string]string{"library": "library.cql", "expression": "Diabetes"})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Diabetes", measure.Group[0].Population[0].Expression)
+		}
+	})
+
+	t.Run("unknown variable", func(t *testing.T) {
+		filename := writeMeasureFile(t, "library: {{ .lib }}\n")
+
+		_, err := ReadMeasureFileWith(filename, map[string]string{"library": "library.cql"})
+
+		assert.ErrorContains(t, err, "invalid measure file "+filename+": error while substituting variables: ")
+	})
+
+	t.Run("value sets", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: library.cql
+valueSet:
+- url: http://example.com/ValueSet/diabetes
+  file: library.cql
+group:
+- population:
+  - expression: InInitialPopulation
+`)
+
+		measure, err := ReadMeasureFile(filename)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []ValueSet{{Url: "http://example.com/ValueSet/diabetes", File: filepath.Join(filepath.Dir(filename), "library.cql")}},
+				measure.ValueSet)
+		}
+	})
+
+	t.Run("invalid value sets", func(t *testing.T) {
+		filename := writeMeasureFile(t, `library: library.cql
+valueSet:
+- file: codes.csv
+- url: http://example.com/ValueSet/diabetes
+group:
+- population:
+  - expression: InInitialPopulation
+`)
+
+		_, err := ReadMeasureFile(filename)
+
+		assert.Equal(t, []string{
+			"line 3: valueSet[0]: missing url",
+			"line 4: valueSet[1]: missing code list filename",
+			"line 3: code list file `" + filepath.Join(filepath.Dir(filename), "codes.csv") + "` doesn't exist",
+		}, measureFileProblems(t, err))
+	})
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// Substitute executes content as Go template with vars, so that {{ .name }}
+// is replaced by the value of the variable name. Unknown variables are an
+// error. Returns content unchanged if there are no variables, because CQL
+// list selectors like {{1}} would be mistaken for actions otherwise.
+func Substitute(name string, content []byte, vars map[string]string) ([]byte, error) {
+	if len(vars) == 0 {
+		return content, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error while substituting variables: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("error while substituting variables: %w", err)
+	}
+	return buf.Bytes(), nil
+}
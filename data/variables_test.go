@@ -0,0 +1,44 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	t.Run("without variables", func(t *testing.T) {
+		content, err := Substitute("library.cql", []byte("define Codes: {{1, 2}}"), nil)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "define Codes: {{1, 2}}", string(content))
+		}
+	})
+
+	t.Run("with variables", func(t *testing.T) {
+		content, err := Substitute("library.cql", []byte("define Start: @{{ .start }}"), map[string]string{"start": "2023-01-01"})
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "define Start: @2023-01-01", string(content))
+		}
+	})
+
+	t.Run("unknown variable", func(t *testing.T) {
+		_, err := Substitute("library.cql", []byte("{{ .end }}"), map[string]string{"start": "2023-01-01"})
+
+		assert.ErrorContains(t, err, "error while substituting variables: ")
+	})
+}
@@ -16,15 +16,14 @@ package fhir
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/samply/blazectl/util"
 	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 )
 
@@ -32,9 +31,11 @@ import (
 // a FHIR server. At minimum, the BaseURL has to be set. HttpClient can be left at
 // its default value.
 type Client struct {
-	httpClient http.Client
-	baseURL    url.URL
-	auth       Auth
+	httpClient       http.Client
+	baseURL          url.URL
+	auth             Auth
+	acceptMediaType  string
+	contentMediaType string
 }
 
 type Auth interface {
@@ -74,30 +75,17 @@ func NewClientInsecure(fhirServerBaseUrl url.URL, auth Auth) *Client {
 	return createClient(fhirServerBaseUrl, auth, true)
 }
 
+// NewClientCa creates a new Client as NewClient does but trusts only the
+// certificates of caCertFilename, which is either a PEM file or a directory
+// of PEM files, instead of the system roots.
 func NewClientCa(fhirServerBaseUrl url.URL, auth Auth, caCertFilename string) (*Client, error) {
-	caCert, err := os.ReadFile(caCertFilename)
+	pool, err := ReadCertPool(caCertFilename, false)
 	if err != nil {
 		return nil, err
 	}
-
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	tlsConfig := &tls.Config{
-		RootCAs: caCertPool,
-	}
-
-	t := http.DefaultTransport.(*http.Transport).Clone()
-	t.MaxIdleConns = 100
-	t.MaxConnsPerHost = 100
-	t.MaxIdleConnsPerHost = 100
-	t.TLSClientConfig = tlsConfig
-
-	return &Client{
-		httpClient: http.Client{Transport: t},
-		baseURL:    fhirServerBaseUrl,
-		auth:       auth,
-	}, nil
+	client := createClient(fhirServerBaseUrl, auth, false)
+	client.SetRootCAs(pool)
+	return client, nil
 }
 
 func createClient(fhirServerBaseUrl url.URL, auth Auth, insecure bool) *Client {
@@ -116,6 +104,39 @@ func createClient(fhirServerBaseUrl url.URL, auth Auth, insecure bool) *Client {
 
 const fhirJson = "application/fhir+json"
 
+// SetMediaTypes sets the values of the Accept and Content-Type headers sent
+// with FHIR JSON requests. Some servers need a versioned media type like
+// `application/fhir+json; fhirVersion=4.0` or plain `application/json`. Empty
+// values keep the default `application/fhir+json`. The Content-Type of form
+// encoded searches isn't affected.
+func (c *Client) SetMediaTypes(accept string, contentType string) {
+	c.acceptMediaType = accept
+	c.contentMediaType = contentType
+}
+
+func (c *Client) accept() string {
+	if c.acceptMediaType != "" {
+		return c.acceptMediaType
+	}
+	return fhirJson
+}
+
+func (c *Client) contentType() string {
+	if c.contentMediaType != "" {
+		return c.contentMediaType
+	}
+	return fhirJson
+}
+
+// CorrelationIdHeader is the header carrying the id which is generated for
+// every request, so that requests can be correlated with the logs of the
+// server. It's defined in util, which renders it in error responses.
+const CorrelationIdHeader = util.CorrelationIdHeader
+
+// RequestIdHeader is the header in which servers return their own id of a
+// request.
+const RequestIdHeader = util.RequestIdHeader
+
 // NewCapabilitiesRequest creates a new capabilities interaction request. Uses
 // the base URL from the FHIR client and sets JSON Accept header. Otherwise it's
 // identical to http.NewRequest.
@@ -124,7 +145,7 @@ func (c *Client) NewCapabilitiesRequest() (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", fhirJson)
+	req.Header.Add("Accept", c.accept())
 	return req, nil
 }
 
@@ -136,8 +157,8 @@ func (c *Client) NewTransactionRequest(body io.Reader) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error while creating a transaction request: %w", err)
 	}
-	req.Header.Add("Accept", fhirJson)
-	req.Header.Add("Content-Type", fhirJson)
+	req.Header.Add("Accept", c.accept())
+	req.Header.Add("Content-Type", c.contentType())
 	return req, nil
 }
 
@@ -150,7 +171,7 @@ func (c *Client) NewSearchTypeRequest(resourceType string, searchQuery url.Value
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", fhirJson)
+	req.Header.Add("Accept", c.accept())
 	return req, nil
 }
 
@@ -162,7 +183,7 @@ func (c *Client) NewPostSearchTypeRequest(resourceType string, searchQuery url.V
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", fhirJson)
+	req.Header.Add("Accept", c.accept())
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	return req, nil
 }
@@ -176,7 +197,67 @@ func (c *Client) NewSearchSystemRequest(searchQuery url.Values) (*http.Request,
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", fhirJson)
+	req.Header.Add("Accept", c.accept())
+	return req, nil
+}
+
+// NewHistoryRequest creates a new history interaction request with the given
+// query params, like _since. An empty resource type requests the history of
+// the whole system.
+func (c *Client) NewHistoryRequest(resourceType string, query url.Values) (*http.Request, error) {
+	_url := c.baseURL.JoinPath(resourceType, "_history")
+	_url.RawQuery = query.Encode()
+	req, err := http.NewRequest("GET", _url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", c.accept())
+	return req, nil
+}
+
+// NewCreateRequest creates a new create interaction request. Uses the base URL
+// from the FHIR client and sets JSON Accept and Content-Type headers.
+func (c *Client) NewCreateRequest(resourceType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest("POST", c.baseURL.JoinPath(resourceType).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", c.accept())
+	req.Header.Add("Content-Type", c.contentType())
+	return req, nil
+}
+
+// NewReadRequest creates a new read interaction request. Uses the base URL from
+// the FHIR client and sets JSON Accept header.
+func (c *Client) NewReadRequest(resourceType string, id string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", c.baseURL.JoinPath(resourceType, id).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", c.accept())
+	return req, nil
+}
+
+// NewUpdateRequest creates a new update interaction request. Uses the base URL
+// from the FHIR client and sets JSON Accept and Content-Type headers.
+func (c *Client) NewUpdateRequest(resourceType string, id string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest("PUT", c.baseURL.JoinPath(resourceType, id).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", c.accept())
+	req.Header.Add("Content-Type", c.contentType())
+	return req, nil
+}
+
+// NewDeleteRequest creates a new delete interaction request. Uses the base URL
+// from the FHIR client and sets JSON Accept header.
+func (c *Client) NewDeleteRequest(resourceType string, id string) (*http.Request, error) {
+	req, err := http.NewRequest("DELETE", c.baseURL.JoinPath(resourceType, id).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", c.accept())
 	return req, nil
 }
 
@@ -188,7 +269,7 @@ func (c *Client) NewPaginatedRequest(paginationURL *url.URL) (*http.Request, err
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", fhirJson)
+	req.Header.Add("Accept", c.accept())
 	return req, nil
 }
 
@@ -202,8 +283,8 @@ func (c *Client) NewPostSystemOperationRequest(operationName string, async bool,
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", fhirJson)
-	req.Header.Add("Content-Type", fhirJson)
+	req.Header.Add("Accept", c.accept())
+	req.Header.Add("Content-Type", c.contentType())
 	if async {
 		req.Header.Add("Prefer", "respond-async")
 	}
@@ -218,20 +299,80 @@ func (c *Client) NewTypeOperationRequest(resourceType string, operationName stri
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", fhirJson)
+	req.Header.Add("Accept", c.accept())
 	if async {
 		req.Header.Add("Prefer", "respond-async")
 	}
 	return req, nil
 }
 
-// Do calls Do on the HTTP client of the FHIR client.
+// NewPostTypeOperationRequest creates a new operation request that will use POST with body as resource or
+// Parameters.
+func (c *Client) NewPostTypeOperationRequest(resourceType string, operationName string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest("POST", c.baseURL.JoinPath(resourceType, "$"+operationName).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", c.accept())
+	req.Header.Add("Content-Type", c.contentType())
+	return req, nil
+}
+
+// NewPostInstanceOperationRequest creates a new operation request on the resource with the given type and id that
+// will use POST with body as Parameters.
+func (c *Client) NewPostInstanceOperationRequest(resourceType string, id string, operationName string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest("POST", c.baseURL.JoinPath(resourceType, id, "$"+operationName).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", c.accept())
+	req.Header.Add("Content-Type", c.contentType())
+	return req, nil
+}
+
+// Do calls Do on the HTTP client of the FHIR client. A random correlation id
+// is set as X-Correlation-Id header unless req already has one. Failed
+// verifications of the server certificate are returned as
+// TLSCertificateError.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	if c.auth != nil {
 		c.auth.setAuth(req)
 	}
+	if req.Header.Get(CorrelationIdHeader) == "" {
+		req.Header.Set(CorrelationIdHeader, uuid.NewString())
+	}
 
-	return c.httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, withCertificateChain(err)
+	}
+	return resp, nil
+}
+
+// CorrelationId returns the correlation id sent with the request of resp.
+func CorrelationId(resp *http.Response) string {
+	if resp.Request == nil {
+		return ""
+	}
+	return resp.Request.Header.Get(CorrelationIdHeader)
+}
+
+// BaseURL returns the base URL of the FHIR server.
+func (c *Client) BaseURL() url.URL {
+	return c.baseURL
+}
+
+// Admin returns a FHIR client for the admin API of Blaze which is available
+// under the path __admin of the base URL. The returned client shares the HTTP
+// client and authentication with c.
+func (c *Client) Admin() *Client {
+	return &Client{
+		httpClient:       c.httpClient,
+		baseURL:          *c.baseURL.JoinPath("__admin"),
+		auth:             c.auth,
+		acceptMediaType:  c.acceptMediaType,
+		contentMediaType: c.contentMediaType,
+	}
 }
 
 // CloseIdleConnections calls CloseIdleConnections on the HTTP client of the
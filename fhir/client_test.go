@@ -29,6 +29,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 )
@@ -163,6 +164,161 @@ func TestNewTypeOperationRequest(t *testing.T) {
 	assert.Equal(t, "application/fhir+json", req.Header.Get("Accept"))
 }
 
+func TestNewPostTypeOperationRequest(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+	client := NewClient(*parsedUrl, nil)
+
+	req, err := client.NewPostTypeOperationRequest("some-type", "some-operation", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("could not create a post type operation request: %v", err)
+	}
+
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/some-path/some-type/$some-operation", req.URL.Path)
+	assert.Equal(t, "application/fhir+json", req.Header.Get("Accept"))
+	assert.Equal(t, "application/fhir+json", req.Header.Get("Content-Type"))
+}
+
+func TestNewPostInstanceOperationRequest(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+	client := NewClient(*parsedUrl, nil)
+
+	req, err := client.NewPostInstanceOperationRequest("some-type", "some-id", "some-operation", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("could not create a post instance operation request: %v", err)
+	}
+
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/some-path/some-type/some-id/$some-operation", req.URL.Path)
+	assert.Equal(t, "application/fhir+json", req.Header.Get("Accept"))
+	assert.Equal(t, "application/fhir+json", req.Header.Get("Content-Type"))
+}
+
+func TestNewHistoryRequest(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+	client := NewClient(*parsedUrl, nil)
+
+	t.Run("type", func(t *testing.T) {
+		req, err := client.NewHistoryRequest("some-type", url.Values{"_since": []string{"2024-01-02T03:04:05Z"}})
+		if err != nil {
+			t.Fatalf("could not create a history request: %v", err)
+		}
+
+		assert.Equal(t, "GET", req.Method)
+		assert.Equal(t, "/some-path/some-type/_history", req.URL.Path)
+		assert.Equal(t, "2024-01-02T03:04:05Z", req.URL.Query().Get("_since"))
+	})
+
+	t.Run("system", func(t *testing.T) {
+		req, err := client.NewHistoryRequest("", url.Values{})
+		if err != nil {
+			t.Fatalf("could not create a history request: %v", err)
+		}
+
+		assert.Equal(t, "/some-path/_history", req.URL.Path)
+	})
+}
+
+func TestNewCreateRequest(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+	client := NewClient(*parsedUrl, nil)
+
+	req, err := client.NewCreateRequest("some-type", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("could not create a create request: %v", err)
+	}
+
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/some-path/some-type", req.URL.Path)
+	assert.Equal(t, "application/fhir+json", req.Header.Get("Content-Type"))
+}
+
+func TestNewReadRequest(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+	client := NewClient(*parsedUrl, nil)
+
+	req, err := client.NewReadRequest("some-type", "some-id")
+	if err != nil {
+		t.Fatalf("could not create a read request: %v", err)
+	}
+
+	assert.Equal(t, "GET", req.Method)
+	assert.Equal(t, "/some-path/some-type/some-id", req.URL.Path)
+	assert.Equal(t, "application/fhir+json", req.Header.Get("Accept"))
+}
+
+func TestNewUpdateRequest(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+	client := NewClient(*parsedUrl, nil)
+
+	req, err := client.NewUpdateRequest("some-type", "some-id", nil)
+	if err != nil {
+		t.Fatalf("could not create an update request: %v", err)
+	}
+
+	assert.Equal(t, "PUT", req.Method)
+	assert.Equal(t, "/some-path/some-type/some-id", req.URL.Path)
+	assert.Equal(t, "application/fhir+json", req.Header.Get("Content-Type"))
+}
+
+func TestNewDeleteRequest(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+	client := NewClient(*parsedUrl, nil)
+
+	req, err := client.NewDeleteRequest("some-type", "some-id")
+	if err != nil {
+		t.Fatalf("could not create a delete request: %v", err)
+	}
+
+	assert.Equal(t, "DELETE", req.Method)
+	assert.Equal(t, "/some-path/some-type/some-id", req.URL.Path)
+}
+
+func TestSetMediaTypes(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
+
+	t.Run("default", func(t *testing.T) {
+		client := NewClient(*parsedUrl, nil)
+
+		req, _ := client.NewTransactionRequest(bytes.NewReader([]byte{}))
+
+		assert.Equal(t, "application/fhir+json", req.Header.Get("Accept"))
+		assert.Equal(t, "application/fhir+json", req.Header.Get("Content-Type"))
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		client := NewClient(*parsedUrl, nil)
+		client.SetMediaTypes("application/fhir+json; fhirVersion=4.0, application/json;q=0.9", "application/json")
+
+		req, _ := client.NewTransactionRequest(bytes.NewReader([]byte{}))
+		assert.Equal(t, "application/fhir+json; fhirVersion=4.0, application/json;q=0.9", req.Header.Get("Accept"))
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		req, _ = client.Admin().NewReadRequest("some-type", "some-id")
+		assert.Equal(t, "application/fhir+json; fhirVersion=4.0, application/json;q=0.9", req.Header.Get("Accept"))
+	})
+
+	t.Run("form encoded search keeps its content type", func(t *testing.T) {
+		client := NewClient(*parsedUrl, nil)
+		client.SetMediaTypes("application/json", "application/json")
+
+		req, _ := client.NewPostSearchTypeRequest("some-type", url.Values{})
+
+		assert.Equal(t, "application/x-www-form-urlencoded", req.Header.Get("Content-Type"))
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/fhir")
+	client := NewClient(*parsedUrl, TokenAuth{Token: "foo"})
+
+	admin := client.Admin()
+
+	assert.Equal(t, "http://localhost:8080/fhir/__admin", admin.baseURL.String())
+	assert.Equal(t, client.auth, admin.auth)
+	assert.Equal(t, "http://localhost:8080/fhir", client.baseURL.String())
+}
+
 func TestNewAsyncTypeOperationRequest(t *testing.T) {
 	parsedUrl, _ := url.ParseRequestURI("http://localhost:8080/some-path")
 	client := NewClient(*parsedUrl, nil)
@@ -249,3 +405,42 @@ func createSelfSignedCertificate() (*x509.Certificate, *ecdsa.PrivateKey, error)
 
 	return selfSignedCertificate, privateKey, nil
 }
+
+func TestCorrelationId(t *testing.T) {
+	t.Run("generated", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			assert.NotEmpty(t, req.Header.Get(CorrelationIdHeader))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := NewClient(*baseURL, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, req.Header.Get(CorrelationIdHeader), CorrelationId(resp))
+	})
+
+	t.Run("kept", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			assert.Equal(t, "id-171857", req.Header.Get(CorrelationIdHeader))
+		}))
+		defer server.Close()
+
+		baseURL, _ := url.ParseRequestURI(server.URL)
+		client := NewClient(*baseURL, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set(CorrelationIdHeader, "id-171857")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/samply/blazectl/util"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrNonFHIRResponse is wrapped by an HTTPStatusError if the body of the
+// response isn't a FHIR OperationOutcome.
+var ErrNonFHIRResponse = errors.New("non-FHIR response")
+
+// An OperationOutcomeError is an error reported by the server in form of an
+// OperationOutcome.
+type OperationOutcomeError struct {
+	outcome *fm.OperationOutcome
+}
+
+// NewOperationOutcomeError creates an OperationOutcomeError from outcome.
+func NewOperationOutcomeError(outcome *fm.OperationOutcome) *OperationOutcomeError {
+	return &OperationOutcomeError{outcome: outcome}
+}
+
+func (err *OperationOutcomeError) Error() string {
+	return util.FmtOperationOutcomes([]*fm.OperationOutcome{err.outcome})
+}
+
+// Outcome returns the OperationOutcome reported by the server.
+func (err *OperationOutcomeError) Outcome() *fm.OperationOutcome {
+	return err.outcome
+}
+
+// Issues returns the issues of the OperationOutcome reported by the server.
+func (err *OperationOutcomeError) Issues() []fm.OperationOutcomeIssue {
+	return err.outcome.Issue
+}
+
+// Retryable returns true if at least one of the issues is of a transient
+// kind, so that repeating the request may succeed.
+func (err *OperationOutcomeError) Retryable() bool {
+	for _, issue := range err.outcome.Issue {
+		if isTransient(issue) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTransient(issue fm.OperationOutcomeIssue) bool {
+	switch issue.Code {
+	case fm.IssueTypeTransient,
+		fm.IssueTypeLockError,
+		fm.IssueTypeNoStore,
+		fm.IssueTypeException,
+		fm.IssueTypeTimeout,
+		fm.IssueTypeIncomplete,
+		fm.IssueTypeThrottled:
+		return true
+	default:
+		return false
+	}
+}
+
+// An HTTPStatusError is returned if the server responded with an unexpected
+// status code. It wraps either an OperationOutcomeError or ErrNonFHIRResponse,
+// so that errors.As and errors.Is can be used to branch on the kind of
+// response.
+type HTTPStatusError struct {
+	StatusCode int
+	Body       []byte
+	// RequestId is the X-Request-Id header of the response, if any
+	RequestId string
+	// CorrelationId is the X-Correlation-Id header of the request, if any
+	CorrelationId string
+	err           error
+}
+
+// NewHTTPStatusError creates an HTTPStatusError from the status code, the
+// Content-Type header and the body of a response.
+func NewHTTPStatusError(statusCode int, contentType string, body []byte) *HTTPStatusError {
+	if strings.HasPrefix(contentType, fhirJson) {
+		outcome := fm.OperationOutcome{}
+		if err := json.Unmarshal(body, &outcome); err == nil {
+			return &HTTPStatusError{StatusCode: statusCode, Body: body, err: NewOperationOutcomeError(&outcome)}
+		}
+	}
+	return &HTTPStatusError{StatusCode: statusCode, Body: body, err: ErrNonFHIRResponse}
+}
+
+// ReadHTTPStatusError reads the body of resp and returns an HTTPStatusError
+// which also carries the request id of the server and the correlation id of
+// the request. Returns the error of reading the body instead if that fails.
+func ReadHTTPStatusError(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	statusErr := NewHTTPStatusError(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	statusErr.RequestId = resp.Header.Get(RequestIdHeader)
+	statusErr.CorrelationId = CorrelationId(resp)
+	return statusErr
+}
+
+// Error returns the formatted OperationOutcome or the body of non-FHIR
+// responses as is, followed by the request and correlation id if known.
+func (err *HTTPStatusError) Error() string {
+	var message string
+	var outcomeErr *OperationOutcomeError
+	if errors.As(err.err, &outcomeErr) {
+		message = outcomeErr.Error()
+	} else {
+		message = string(err.Body)
+	}
+	ids := util.FmtRequestIds(err.RequestId, err.CorrelationId)
+	if ids != "" && message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	return message + ids
+}
+
+func (err *HTTPStatusError) Unwrap() error {
+	return err.err
+}
+
+// OperationOutcome returns the OperationOutcome of the response or nil if the
+// response was no FHIR response.
+func (err *HTTPStatusError) OperationOutcome() *fm.OperationOutcome {
+	var outcomeErr *OperationOutcomeError
+	if errors.As(err.err, &outcomeErr) {
+		return outcomeErr.Outcome()
+	}
+	return nil
+}
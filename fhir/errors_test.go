@@ -0,0 +1,102 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"errors"
+	"fmt"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOperationOutcomeError(t *testing.T) {
+	diagnostics := "timeout"
+	outcome := fm.OperationOutcome{Issue: []fm.OperationOutcomeIssue{{
+		Severity:    fm.IssueSeverityError,
+		Code:        fm.IssueTypeTimeout,
+		Diagnostics: &diagnostics,
+	}}}
+	err := NewOperationOutcomeError(&outcome)
+
+	assert.Same(t, &outcome, err.Outcome())
+	assert.Len(t, err.Issues(), 1)
+	assert.True(t, err.Retryable())
+	assert.Contains(t, err.Error(), "Diagnostics : timeout")
+
+	t.Run("not retryable", func(t *testing.T) {
+		err := NewOperationOutcomeError(&fm.OperationOutcome{Issue: []fm.OperationOutcomeIssue{{
+			Severity: fm.IssueSeverityError,
+			Code:     fm.IssueTypeInvalid,
+		}}})
+
+		assert.False(t, err.Retryable())
+	})
+}
+
+func TestNewHTTPStatusError(t *testing.T) {
+	t.Run("OperationOutcome", func(t *testing.T) {
+		body := `{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "invalid", "diagnostics": "msg-104519"}]}`
+		err := NewHTTPStatusError(http.StatusBadRequest, "application/fhir+json;charset=utf-8", []byte(body))
+
+		assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+		assert.Contains(t, err.Error(), "Diagnostics : msg-104519")
+		assert.NotNil(t, err.OperationOutcome())
+		assert.False(t, errors.Is(err, ErrNonFHIRResponse))
+
+		var outcomeErr *OperationOutcomeError
+		if assert.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &outcomeErr)) {
+			assert.Equal(t, fm.IssueTypeInvalid, outcomeErr.Issues()[0].Code)
+		}
+	})
+
+	t.Run("non-FHIR content type", func(t *testing.T) {
+		err := NewHTTPStatusError(http.StatusBadGateway, "text/plain", []byte("bad gateway"))
+
+		assert.Equal(t, "bad gateway", err.Error())
+		assert.Nil(t, err.OperationOutcome())
+		assert.True(t, errors.Is(err, ErrNonFHIRResponse))
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		err := NewHTTPStatusError(http.StatusInternalServerError, "application/fhir+json", []byte("{"))
+
+		assert.Equal(t, "{", err.Error())
+		assert.True(t, errors.Is(err, ErrNonFHIRResponse))
+	})
+}
+
+func TestReadHTTPStatusError(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}, "X-Request-Id": []string{"id-172012"}},
+		Body:       io.NopCloser(strings.NewReader("unavailable")),
+		Request:    &http.Request{Header: http.Header{"X-Correlation-Id": []string{"id-172037"}}},
+	}
+
+	err := ReadHTTPStatusError(resp)
+
+	var statusErr *HTTPStatusError
+	if assert.True(t, errors.As(err, &statusErr)) {
+		assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+		assert.Equal(t, []byte("unavailable"), statusErr.Body)
+		assert.Equal(t, "id-172012", statusErr.RequestId)
+		assert.Equal(t, "id-172037", statusErr.CorrelationId)
+	}
+	assert.Equal(t, "unavailable\nRequestId   : id-172012\nCorrelation : id-172037\n", err.Error())
+}
@@ -0,0 +1,605 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Path is a parsed FHIRPath expression, which can be evaluated against
+// resources in JSON format without contacting a server.
+//
+// Paths, indexers, the operators of the FHIRPath specification and the
+// functions in pathFunctions are supported. Values are the values of the
+// decoded JSON, so that dates and times are strings and compared as such.
+// Quantities with units, resolve() and the terminology functions aren't
+// supported.
+type Path struct {
+	expression string
+	root       pathNode
+}
+
+// ParsePath parses the FHIRPath expression.
+func ParsePath(expression string) (*Path, error) {
+	tokens, err := tokenizePath(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FHIRPath expression `%s`: %w", expression, err)
+	}
+	parser := pathParser{tokens: tokens}
+	root, err := parser.parseExpression(0)
+	if err == nil && parser.peek().kind != tokenEOF {
+		err = parser.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid FHIRPath expression `%s`: %w", expression, err)
+	}
+	return &Path{expression: expression, root: root}, nil
+}
+
+func (p *Path) String() string {
+	return p.expression
+}
+
+// Evaluate evaluates the path against resource, which is decoded JSON like
+// the result of DecodeResource, and returns the resulting collection.
+func (p *Path) Evaluate(resource interface{}) ([]interface{}, error) {
+	input := []interface{}{resource}
+	env := &pathEnv{resource: resource, this: input}
+	result, err := p.root.eval(env, input)
+	if err != nil {
+		return nil, fmt.Errorf("error while evaluating `%s`: %w", p.expression, err)
+	}
+	return result, nil
+}
+
+// DecodeResource decodes the resource in JSON format for Path.Evaluate.
+// Numbers are kept as json.Number, so that they aren't rounded.
+func DecodeResource(decoder *json.Decoder) (interface{}, error) {
+	decoder.UseNumber()
+	var resource interface{}
+	if err := decoder.Decode(&resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// pathEnv is the environment an expression is evaluated in.
+type pathEnv struct {
+	// resource is the resource the whole expression is evaluated against
+	resource interface{}
+	// this is the item iterated over by functions like where
+	this []interface{}
+	// index is the index of this
+	index int
+}
+
+type pathNode interface {
+	// eval evaluates the node with input as focus.
+	eval(env *pathEnv, input []interface{}) ([]interface{}, error)
+}
+
+type literalNode struct {
+	values []interface{}
+}
+
+func (n *literalNode) eval(_ *pathEnv, _ []interface{}) ([]interface{}, error) {
+	return n.values, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(env *pathEnv, _ []interface{}) ([]interface{}, error) {
+	switch n.name {
+	case "$this":
+		return env.this, nil
+	case "$index":
+		return []interface{}{json.Number(strconv.Itoa(env.index))}, nil
+	case "%resource", "%rootResource", "%context":
+		return []interface{}{env.resource}, nil
+	case "%ucum":
+		return []interface{}{"http://unitsofmeasure.org"}, nil
+	case "%sct":
+		return []interface{}{"http://snomed.info/sct"}, nil
+	case "%loinc":
+		return []interface{}{"http://loinc.org"}, nil
+	default:
+		return nil, fmt.Errorf("unknown variable %s", n.name)
+	}
+}
+
+// memberNode accesses the elements with name of the items of the target or,
+// without target, of the focus.
+type memberNode struct {
+	target pathNode
+	name   string
+}
+
+func (n *memberNode) eval(env *pathEnv, input []interface{}) ([]interface{}, error) {
+	items, err := evalTarget(env, input, n.target)
+	if err != nil {
+		return nil, err
+	}
+	var result []interface{}
+	for _, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if n.target == nil && isTypeName(n.name) && object["resourceType"] == n.name {
+			result = append(result, object)
+			continue
+		}
+		if value, ok := object[n.name]; ok {
+			result = appendFlattened(result, value)
+			continue
+		}
+		// choice types like value[x] are accessed without their type suffix
+		for key, value := range object {
+			if len(key) > len(n.name) && strings.HasPrefix(key, n.name) && unicode.IsUpper(rune(key[len(n.name)])) {
+				result = appendFlattened(result, value)
+			}
+		}
+	}
+	return result, nil
+}
+
+// isTypeName returns true if name starts with an uppercase letter like the
+// names of resource types. Element names start with lowercase letters.
+func isTypeName(name string) bool {
+	return name != "" && unicode.IsUpper(rune(name[0]))
+}
+
+// appendFlattened appends value to result. The items of arrays are appended
+// one by one.
+func appendFlattened(result []interface{}, value interface{}) []interface{} {
+	if array, ok := value.([]interface{}); ok {
+		for _, item := range array {
+			if item != nil {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+	if value == nil {
+		return result
+	}
+	return append(result, value)
+}
+
+// evalTarget evaluates the target of an invocation. Without target, the
+// invocation applies to the focus.
+func evalTarget(env *pathEnv, input []interface{}, target pathNode) ([]interface{}, error) {
+	if target == nil {
+		return input, nil
+	}
+	return target.eval(env, input)
+}
+
+type indexNode struct {
+	target pathNode
+	index  pathNode
+}
+
+func (n *indexNode) eval(env *pathEnv, input []interface{}) ([]interface{}, error) {
+	items, err := n.target.eval(env, input)
+	if err != nil {
+		return nil, err
+	}
+	index, ok, err := integerArg(env, input, n.index)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if index < 0 || index >= len(items) {
+		return nil, nil
+	}
+	return items[index : index+1], nil
+}
+
+type negationNode struct {
+	operand pathNode
+}
+
+func (n *negationNode) eval(env *pathEnv, input []interface{}) ([]interface{}, error) {
+	operand, err := n.operand.eval(env, input)
+	if err != nil || len(operand) == 0 {
+		return nil, err
+	}
+	number, err := singletonNumber(operand, "-")
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{formatNumber(-number)}, nil
+}
+
+// typeNode is an is or as operation.
+type typeNode struct {
+	operator string
+	operand  pathNode
+	typeName string
+}
+
+func (n *typeNode) eval(env *pathEnv, input []interface{}) ([]interface{}, error) {
+	operand, err := n.operand.eval(env, input)
+	if err != nil || len(operand) == 0 {
+		return nil, err
+	}
+	if len(operand) > 1 {
+		return nil, fmt.Errorf("the operator %s expects a single item, but got %d", n.operator, len(operand))
+	}
+	matches := isOfType(operand[0], n.typeName)
+	if n.operator == "is" {
+		return []interface{}{matches}, nil
+	}
+	if matches {
+		return operand, nil
+	}
+	return nil, nil
+}
+
+// isOfType returns true if value is of the type with name. Resources are
+// matched by their resourceType and primitives by their JSON type.
+func isOfType(value interface{}, name string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v["resourceType"] == name
+	case string:
+		switch name {
+		case "string", "String", "code", "id", "uri", "url", "canonical", "markdown", "oid", "uuid",
+			"base64Binary", "date", "dateTime", "instant", "time", "Date", "DateTime", "Time":
+			return true
+		}
+	case bool:
+		return name == "boolean" || name == "Boolean"
+	case json.Number:
+		if name == "decimal" || name == "Decimal" {
+			return true
+		}
+		if name == "integer" || name == "Integer" || name == "positiveInt" || name == "unsignedInt" {
+			_, err := strconv.ParseInt(string(v), 10, 64)
+			return err == nil
+		}
+	}
+	return false
+}
+
+type binaryNode struct {
+	operator    string
+	left, right pathNode
+}
+
+func (n *binaryNode) eval(env *pathEnv, input []interface{}) ([]interface{}, error) {
+	left, err := n.left.eval(env, input)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env, input)
+	if err != nil {
+		return nil, err
+	}
+	switch n.operator {
+	case "and", "or", "xor", "implies":
+		return evalLogic(n.operator, left, right)
+	case "=", "!=":
+		if len(left) == 0 || len(right) == 0 {
+			return nil, nil
+		}
+		return []interface{}{collectionsEqual(left, right, valuesEqual) == (n.operator == "=")}, nil
+	case "~", "!~":
+		return []interface{}{collectionsEqual(left, right, valuesEquivalent) == (n.operator == "~")}, nil
+	case "<", "<=", ">", ">=":
+		return evalComparison(n.operator, left, right)
+	case "|":
+		return union(left, right), nil
+	case "in":
+		return evalMembership(left, right)
+	case "contains":
+		return evalMembership(right, left)
+	case "&":
+		l, err := singletonString(left, "&")
+		if err != nil {
+			return nil, err
+		}
+		r, err := singletonString(right, "&")
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{l + r}, nil
+	default:
+		return evalArithmetic(n.operator, left, right)
+	}
+}
+
+// toBoolean evaluates collection as boolean. Returns nil for the empty
+// collection. A single item which isn't a boolean is true.
+func toBoolean(collection []interface{}) (*bool, error) {
+	switch len(collection) {
+	case 0:
+		return nil, nil
+	case 1:
+		b, ok := collection[0].(bool)
+		if !ok {
+			b = true
+		}
+		return &b, nil
+	default:
+		return nil, fmt.Errorf("expected a single boolean, but got %d items", len(collection))
+	}
+}
+
+func booleanResult(b *bool) []interface{} {
+	if b == nil {
+		return nil
+	}
+	return []interface{}{*b}
+}
+
+// evalLogic evaluates the logical operators with three-valued logic, where
+// the empty collection means unknown.
+func evalLogic(operator string, left []interface{}, right []interface{}) ([]interface{}, error) {
+	l, err := toBoolean(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toBoolean(right)
+	if err != nil {
+		return nil, err
+	}
+	t, f := true, false
+	switch operator {
+	case "and":
+		if (l != nil && !*l) || (r != nil && !*r) {
+			return booleanResult(&f), nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return booleanResult(&t), nil
+	case "or":
+		if (l != nil && *l) || (r != nil && *r) {
+			return booleanResult(&t), nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return booleanResult(&f), nil
+	case "xor":
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		result := *l != *r
+		return booleanResult(&result), nil
+	default: // implies
+		if l != nil && !*l {
+			return booleanResult(&t), nil
+		}
+		if r != nil && *r {
+			return booleanResult(&t), nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return booleanResult(&f), nil
+	}
+}
+
+// collectionsEqual returns true if both collections have the same items in
+// the same order according to equal.
+func collectionsEqual(left []interface{}, right []interface{}, equal func(a, b interface{}) bool) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for i := range left {
+		if !equal(left[i], right[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func valuesEqual(a interface{}, b interface{}) bool {
+	if x, ok := a.(json.Number); ok {
+		if y, ok := b.(json.Number); ok {
+			return toFloat(x) == toFloat(y)
+		}
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// valuesEquivalent compares strings ignoring case and differences in
+// whitespace and everything else like valuesEqual.
+func valuesEquivalent(a interface{}, b interface{}) bool {
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.EqualFold(strings.Join(strings.Fields(x), " "), strings.Join(strings.Fields(y), " "))
+		}
+	}
+	return valuesEqual(a, b)
+}
+
+func evalComparison(operator string, left []interface{}, right []interface{}) ([]interface{}, error) {
+	if len(left) == 0 || len(right) == 0 {
+		return nil, nil
+	}
+	if len(left) > 1 || len(right) > 1 {
+		return nil, fmt.Errorf("the operator %s expects single items", operator)
+	}
+	var cmp int
+	switch l := left[0].(type) {
+	case json.Number:
+		r, ok := right[0].(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("can't compare %v with %v", left[0], right[0])
+		}
+		x, y := toFloat(l), toFloat(r)
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	case string:
+		r, ok := right[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("can't compare %v with %v", left[0], right[0])
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("can't compare %v with %v", left[0], right[0])
+	}
+	switch operator {
+	case "<":
+		return []interface{}{cmp < 0}, nil
+	case "<=":
+		return []interface{}{cmp <= 0}, nil
+	case ">":
+		return []interface{}{cmp > 0}, nil
+	default:
+		return []interface{}{cmp >= 0}, nil
+	}
+}
+
+// union returns the items of both collections without duplicates.
+func union(left []interface{}, right []interface{}) []interface{} {
+	return distinct(append(append([]interface{}{}, left...), right...))
+}
+
+func distinct(collection []interface{}) []interface{} {
+	var result []interface{}
+	for _, item := range collection {
+		if !containsValue(result, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func containsValue(collection []interface{}, value interface{}) bool {
+	for _, item := range collection {
+		if valuesEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// evalMembership returns whether the single item of element is in
+// collection.
+func evalMembership(element []interface{}, collection []interface{}) ([]interface{}, error) {
+	if len(element) == 0 {
+		return nil, nil
+	}
+	if len(element) > 1 {
+		return nil, fmt.Errorf("the membership operators expect a single item, but got %d", len(element))
+	}
+	return []interface{}{containsValue(collection, element[0])}, nil
+}
+
+func evalArithmetic(operator string, left []interface{}, right []interface{}) ([]interface{}, error) {
+	if len(left) == 0 || len(right) == 0 {
+		return nil, nil
+	}
+	if operator == "+" {
+		if l, ok := left[0].(string); ok && len(left) == 1 {
+			r, err := singletonString(right, "+")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{l + r}, nil
+		}
+	}
+	l, err := singletonNumber(left, operator)
+	if err != nil {
+		return nil, err
+	}
+	r, err := singletonNumber(right, operator)
+	if err != nil {
+		return nil, err
+	}
+	var result float64
+	switch operator {
+	case "+":
+		result = l + r
+	case "-":
+		result = l - r
+	case "*":
+		result = l * r
+	case "/":
+		if r == 0 {
+			return nil, nil
+		}
+		result = l / r
+	case "div":
+		if r == 0 {
+			return nil, nil
+		}
+		result = math.Trunc(l / r)
+	case "mod":
+		if r == 0 {
+			return nil, nil
+		}
+		result = math.Mod(l, r)
+	}
+	return []interface{}{formatNumber(result)}, nil
+}
+
+func toFloat(n json.Number) float64 {
+	f, _ := n.Float64()
+	return f
+}
+
+// formatNumber formats f as json.Number without exponent, so that integers
+// stay integers.
+func formatNumber(f float64) json.Number {
+	return json.Number(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// singletonNumber returns the single number of collection, which is an
+// operand of operator.
+func singletonNumber(collection []interface{}, operator string) (float64, error) {
+	if len(collection) != 1 {
+		return 0, fmt.Errorf("the operator %s expects a single number, but got %d items", operator, len(collection))
+	}
+	n, ok := collection[0].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("the operator %s expects a number, but got %v", operator, collection[0])
+	}
+	return toFloat(n), nil
+}
+
+// singletonString returns the single string of collection, which is an
+// operand of operator. The empty collection is the empty string.
+func singletonString(collection []interface{}, operator string) (string, error) {
+	switch len(collection) {
+	case 0:
+		return "", nil
+	case 1:
+		s, ok := collection[0].(string)
+		if !ok {
+			return "", fmt.Errorf("the operator %s expects a string, but got %v", operator, collection[0])
+		}
+		return s, nil
+	default:
+		return "", fmt.Errorf("the operator %s expects a single string, but got %d items", operator, len(collection))
+	}
+}
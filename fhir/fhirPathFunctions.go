@@ -0,0 +1,619 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pathFunction is a FHIRPath function taking between minArgs and maxArgs
+// arguments.
+type pathFunction struct {
+	minArgs, maxArgs int
+	eval             func(c *pathCall) ([]interface{}, error)
+}
+
+// pathCall is the call of a function with name on focus. Arguments are
+// evaluated on input, the focus of the whole invocation, apart from the
+// criteria of functions like where which are evaluated on each item of focus.
+type pathCall struct {
+	env   *pathEnv
+	name  string
+	input []interface{}
+	focus []interface{}
+	args  []pathNode
+}
+
+var pathFunctions map[string]pathFunction
+
+func init() {
+	pathFunctions = map[string]pathFunction{
+		// existence
+		"empty":  {0, 0, func(c *pathCall) ([]interface{}, error) { return []interface{}{len(c.focus) == 0}, nil }},
+		"exists": {0, 1, exists},
+		"all":    {1, 1, all},
+		"count": {0, 0, func(c *pathCall) ([]interface{}, error) {
+			return []interface{}{json.Number(strconv.Itoa(len(c.focus)))}, nil
+		}},
+		"distinct": {0, 0, func(c *pathCall) ([]interface{}, error) { return distinct(c.focus), nil }},
+		"hasValue": {0, 0, hasValue},
+
+		// filtering and projection
+		"where":  {1, 1, where},
+		"select": {1, 1, selectFunction},
+		"ofType": {1, 1, ofType},
+		"is":     {1, 1, isFunction},
+		"as":     {1, 1, asFunction},
+
+		// subsetting
+		"single": {0, 0, single},
+		"first": {0, 0, func(c *pathCall) ([]interface{}, error) {
+			return subset(c.focus, 0, 1), nil
+		}},
+		"last": {0, 0, func(c *pathCall) ([]interface{}, error) {
+			return subset(c.focus, len(c.focus)-1, len(c.focus)), nil
+		}},
+		"tail": {0, 0, func(c *pathCall) ([]interface{}, error) {
+			return subset(c.focus, 1, len(c.focus)), nil
+		}},
+		"skip": {1, 1, skip},
+		"take": {1, 1, take},
+
+		// combining
+		"union":   {1, 1, unionFunction},
+		"combine": {1, 1, combine},
+
+		// boolean logic
+		"not": {0, 0, not},
+		"iif": {2, 3, iif},
+
+		// strings
+		"startsWith": {1, 1, stringPredicate(strings.HasPrefix)},
+		"endsWith":   {1, 1, stringPredicate(strings.HasSuffix)},
+		"contains":   {1, 1, stringPredicate(strings.Contains)},
+		"matches":    {1, 1, matches},
+		"replace":    {2, 2, replace},
+		"indexOf":    {1, 1, indexOf},
+		"substring":  {1, 2, substring},
+		"length":     {0, 0, stringFunction(func(s string) interface{} { return json.Number(strconv.Itoa(len([]rune(s)))) })},
+		"lower":      {0, 0, stringFunction(func(s string) interface{} { return strings.ToLower(s) })},
+		"upper":      {0, 0, stringFunction(func(s string) interface{} { return strings.ToUpper(s) })},
+		"trim":       {0, 0, stringFunction(func(s string) interface{} { return strings.TrimSpace(s) })},
+		"split":      {1, 1, split},
+		"join":       {0, 1, join},
+
+		// conversion
+		"toString":  {0, 0, toStringFunction},
+		"toInteger": {0, 0, toInteger},
+
+		// tree navigation
+		"children":    {0, 0, func(c *pathCall) ([]interface{}, error) { return children(c.focus), nil }},
+		"descendants": {0, 0, descendants},
+		"extension":   {1, 1, extension},
+
+		// utility
+		"today": {0, 0, func(_ *pathCall) ([]interface{}, error) {
+			return []interface{}{time.Now().Format("2006-01-02")}, nil
+		}},
+		"now": {0, 0, func(_ *pathCall) ([]interface{}, error) {
+			return []interface{}{time.Now().Format(time.RFC3339)}, nil
+		}},
+	}
+}
+
+type functionNode struct {
+	target pathNode
+	name   string
+	args   []pathNode
+}
+
+func (n *functionNode) eval(env *pathEnv, input []interface{}) ([]interface{}, error) {
+	focus, err := evalTarget(env, input, n.target)
+	if err != nil {
+		return nil, err
+	}
+	result, err := pathFunctions[n.name].eval(&pathCall{env: env, name: n.name, input: input, focus: focus, args: n.args})
+	if err != nil {
+		return nil, fmt.Errorf("error in function %s: %w", n.name, err)
+	}
+	return result, nil
+}
+
+// arg evaluates the argument with index i on the input of the call.
+func (c *pathCall) arg(i int) ([]interface{}, error) {
+	return c.args[i].eval(c.env, c.input)
+}
+
+// lambda evaluates the argument with index i on item, which is the item with
+// index of focus, available as $this and $index.
+func (c *pathCall) lambda(i int, item interface{}, index int) ([]interface{}, error) {
+	this := []interface{}{item}
+	env := &pathEnv{resource: c.env.resource, this: this, index: index}
+	return c.args[i].eval(env, this)
+}
+
+// criterion evaluates the argument with index i on item as boolean. An empty
+// result is false.
+func (c *pathCall) criterion(i int, item interface{}, index int) (bool, error) {
+	result, err := c.lambda(i, item, index)
+	if err != nil {
+		return false, err
+	}
+	b, err := toBoolean(result)
+	if err != nil {
+		return false, err
+	}
+	return b != nil && *b, nil
+}
+
+// stringArg evaluates the argument with index i as single string. Returns
+// false if the argument is empty.
+func (c *pathCall) stringArg(i int) (string, bool, error) {
+	arg, err := c.arg(i)
+	if err != nil || len(arg) == 0 {
+		return "", false, err
+	}
+	if len(arg) > 1 {
+		return "", false, fmt.Errorf("expected a single string as argument, but got %d items", len(arg))
+	}
+	s, ok := arg[0].(string)
+	if !ok {
+		return "", false, fmt.Errorf("expected a string as argument, but got %v", arg[0])
+	}
+	return s, true, nil
+}
+
+// integerArg evaluates node on input as single integer. Returns false if the
+// result is empty.
+func integerArg(env *pathEnv, input []interface{}, node pathNode) (int, bool, error) {
+	arg, err := node.eval(env, input)
+	if err != nil || len(arg) == 0 {
+		return 0, false, err
+	}
+	if len(arg) > 1 {
+		return 0, false, fmt.Errorf("expected a single integer, but got %d items", len(arg))
+	}
+	n, ok := arg[0].(json.Number)
+	if !ok {
+		return 0, false, fmt.Errorf("expected an integer, but got %v", arg[0])
+	}
+	i, err := strconv.Atoi(string(n))
+	if err != nil {
+		return 0, false, fmt.Errorf("expected an integer, but got %s", n)
+	}
+	return i, true, nil
+}
+
+// focusString returns the single string of the focus. Returns false if the
+// focus is empty.
+func (c *pathCall) focusString() (string, bool, error) {
+	if len(c.focus) == 0 {
+		return "", false, nil
+	}
+	if len(c.focus) > 1 {
+		return "", false, fmt.Errorf("expected a single string, but got %d items", len(c.focus))
+	}
+	s, ok := c.focus[0].(string)
+	if !ok {
+		return "", false, fmt.Errorf("expected a string, but got %v", c.focus[0])
+	}
+	return s, true, nil
+}
+
+// typeNameArg returns the type name given as argument with index i like
+// Patient or FHIR.Patient.
+func (c *pathCall) typeNameArg(i int) (string, error) {
+	if member, ok := c.args[i].(*memberNode); ok {
+		return member.name, nil
+	}
+	return "", fmt.Errorf("expected a type name as argument")
+}
+
+func exists(c *pathCall) ([]interface{}, error) {
+	if len(c.args) == 0 {
+		return []interface{}{len(c.focus) > 0}, nil
+	}
+	for i, item := range c.focus {
+		matches, err := c.criterion(0, item, i)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			return []interface{}{true}, nil
+		}
+	}
+	return []interface{}{false}, nil
+}
+
+func all(c *pathCall) ([]interface{}, error) {
+	for i, item := range c.focus {
+		matches, err := c.criterion(0, item, i)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			return []interface{}{false}, nil
+		}
+	}
+	return []interface{}{true}, nil
+}
+
+func hasValue(c *pathCall) ([]interface{}, error) {
+	if len(c.focus) != 1 {
+		return []interface{}{false}, nil
+	}
+	_, complex := c.focus[0].(map[string]interface{})
+	return []interface{}{!complex}, nil
+}
+
+func where(c *pathCall) ([]interface{}, error) {
+	var result []interface{}
+	for i, item := range c.focus {
+		matches, err := c.criterion(0, item, i)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func selectFunction(c *pathCall) ([]interface{}, error) {
+	var result []interface{}
+	for i, item := range c.focus {
+		projection, err := c.lambda(0, item, i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, projection...)
+	}
+	return result, nil
+}
+
+func ofType(c *pathCall) ([]interface{}, error) {
+	typeName, err := c.typeNameArg(0)
+	if err != nil {
+		return nil, err
+	}
+	var result []interface{}
+	for _, item := range c.focus {
+		if isOfType(item, typeName) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func isFunction(c *pathCall) ([]interface{}, error) {
+	typeName, err := c.typeNameArg(0)
+	if err != nil || len(c.focus) == 0 {
+		return nil, err
+	}
+	if len(c.focus) > 1 {
+		return nil, fmt.Errorf("expected a single item, but got %d", len(c.focus))
+	}
+	return []interface{}{isOfType(c.focus[0], typeName)}, nil
+}
+
+func asFunction(c *pathCall) ([]interface{}, error) {
+	result, err := isFunction(c)
+	if err != nil || len(result) == 0 || !result[0].(bool) {
+		return nil, err
+	}
+	return c.focus, nil
+}
+
+func single(c *pathCall) ([]interface{}, error) {
+	if len(c.focus) > 1 {
+		return nil, fmt.Errorf("expected a single item, but got %d", len(c.focus))
+	}
+	return c.focus, nil
+}
+
+// subset returns the items of collection from start to end, both clamped to
+// the bounds of collection.
+func subset(collection []interface{}, start int, end int) []interface{} {
+	start = max(0, min(start, len(collection)))
+	end = max(start, min(end, len(collection)))
+	return collection[start:end]
+}
+
+func skip(c *pathCall) ([]interface{}, error) {
+	n, ok, err := integerArg(c.env, c.input, c.args[0])
+	if err != nil || !ok {
+		return nil, err
+	}
+	return subset(c.focus, n, len(c.focus)), nil
+}
+
+func take(c *pathCall) ([]interface{}, error) {
+	n, ok, err := integerArg(c.env, c.input, c.args[0])
+	if err != nil || !ok {
+		return nil, err
+	}
+	return subset(c.focus, 0, n), nil
+}
+
+func unionFunction(c *pathCall) ([]interface{}, error) {
+	other, err := c.arg(0)
+	if err != nil {
+		return nil, err
+	}
+	return union(c.focus, other), nil
+}
+
+func combine(c *pathCall) ([]interface{}, error) {
+	other, err := c.arg(0)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]interface{}{}, c.focus...), other...), nil
+}
+
+func not(c *pathCall) ([]interface{}, error) {
+	b, err := toBoolean(c.focus)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return []interface{}{!*b}, nil
+}
+
+func iif(c *pathCall) ([]interface{}, error) {
+	criterion, err := c.arg(0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toBoolean(criterion)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil && *b {
+		return c.arg(1)
+	}
+	if len(c.args) > 2 {
+		return c.arg(2)
+	}
+	return nil, nil
+}
+
+// stringPredicate returns a function testing the string of the focus against
+// the string argument with predicate.
+func stringPredicate(predicate func(s string, arg string) bool) func(c *pathCall) ([]interface{}, error) {
+	return func(c *pathCall) ([]interface{}, error) {
+		s, ok, err := c.focusString()
+		if err != nil || !ok {
+			return nil, err
+		}
+		arg, ok, err := c.stringArg(0)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []interface{}{predicate(s, arg)}, nil
+	}
+}
+
+// stringFunction returns a function applying f to the string of the focus.
+func stringFunction(f func(s string) interface{}) func(c *pathCall) ([]interface{}, error) {
+	return func(c *pathCall) ([]interface{}, error) {
+		s, ok, err := c.focusString()
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []interface{}{f(s)}, nil
+	}
+}
+
+func matches(c *pathCall) ([]interface{}, error) {
+	s, ok, err := c.focusString()
+	if err != nil || !ok {
+		return nil, err
+	}
+	pattern, ok, err := c.stringArg(0)
+	if err != nil || !ok {
+		return nil, err
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression `%s`: %w", pattern, err)
+	}
+	return []interface{}{regex.MatchString(s)}, nil
+}
+
+func replace(c *pathCall) ([]interface{}, error) {
+	s, ok, err := c.focusString()
+	if err != nil || !ok {
+		return nil, err
+	}
+	pattern, ok, err := c.stringArg(0)
+	if err != nil || !ok {
+		return nil, err
+	}
+	substitution, ok, err := c.stringArg(1)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return []interface{}{strings.ReplaceAll(s, pattern, substitution)}, nil
+}
+
+func indexOf(c *pathCall) ([]interface{}, error) {
+	s, ok, err := c.focusString()
+	if err != nil || !ok {
+		return nil, err
+	}
+	substring, ok, err := c.stringArg(0)
+	if err != nil || !ok {
+		return nil, err
+	}
+	index := strings.Index(s, substring)
+	if index > 0 {
+		index = len([]rune(s[:index]))
+	}
+	return []interface{}{json.Number(strconv.Itoa(index))}, nil
+}
+
+func substring(c *pathCall) ([]interface{}, error) {
+	s, ok, err := c.focusString()
+	if err != nil || !ok {
+		return nil, err
+	}
+	runes := []rune(s)
+	start, ok, err := integerArg(c.env, c.input, c.args[0])
+	if err != nil || !ok || start < 0 || start >= len(runes) {
+		return nil, err
+	}
+	end := len(runes)
+	if len(c.args) > 1 {
+		length, ok, err := integerArg(c.env, c.input, c.args[1])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			end = max(start, min(end, start+length))
+		}
+	}
+	return []interface{}{string(runes[start:end])}, nil
+}
+
+func split(c *pathCall) ([]interface{}, error) {
+	s, ok, err := c.focusString()
+	if err != nil || !ok {
+		return nil, err
+	}
+	separator, ok, err := c.stringArg(0)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var result []interface{}
+	for _, part := range strings.Split(s, separator) {
+		result = append(result, part)
+	}
+	return result, nil
+}
+
+func join(c *pathCall) ([]interface{}, error) {
+	separator := ""
+	if len(c.args) > 0 {
+		var err error
+		if separator, _, err = c.stringArg(0); err != nil {
+			return nil, err
+		}
+	}
+	parts := make([]string, 0, len(c.focus))
+	for _, item := range c.focus {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected strings, but got %v", item)
+		}
+		parts = append(parts, s)
+	}
+	return []interface{}{strings.Join(parts, separator)}, nil
+}
+
+func toStringFunction(c *pathCall) ([]interface{}, error) {
+	if len(c.focus) != 1 {
+		return nil, nil
+	}
+	switch v := c.focus[0].(type) {
+	case string:
+		return []interface{}{v}, nil
+	case json.Number:
+		return []interface{}{string(v)}, nil
+	case bool:
+		return []interface{}{strconv.FormatBool(v)}, nil
+	}
+	return nil, nil
+}
+
+func toInteger(c *pathCall) ([]interface{}, error) {
+	if len(c.focus) != 1 {
+		return nil, nil
+	}
+	var s string
+	switch v := c.focus[0].(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = string(v)
+	case bool:
+		if v {
+			return []interface{}{json.Number("1")}, nil
+		}
+		return []interface{}{json.Number("0")}, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, nil
+	}
+	return []interface{}{json.Number(strconv.Itoa(i))}, nil
+}
+
+// children returns the elements of all items of collection ordered by their
+// names.
+func children(collection []interface{}) []interface{} {
+	var result []interface{}
+	for _, item := range collection {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			if key != "resourceType" {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			result = appendFlattened(result, object[key])
+		}
+	}
+	return result
+}
+
+func descendants(c *pathCall) ([]interface{}, error) {
+	var result []interface{}
+	for level := children(c.focus); len(level) > 0; level = children(level) {
+		result = append(result, level...)
+	}
+	return result, nil
+}
+
+func extension(c *pathCall) ([]interface{}, error) {
+	url, ok, err := c.stringArg(0)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var result []interface{}
+	for _, item := range c.focus {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, ext := range appendFlattened(nil, object["extension"]) {
+			if e, ok := ext.(map[string]interface{}); ok && e["url"] == url {
+				result = append(result, e)
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,421 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type pathTokenKind int
+
+const (
+	tokenEOF pathTokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenDateTime
+	tokenVariable
+	tokenOperator
+)
+
+// pathToken is a token of a FHIRPath expression. Delimited identifiers and
+// strings are unescaped. Keywords like and or div are identifiers.
+type pathToken struct {
+	kind pathTokenKind
+	text string
+	pos  int
+	// delimited is true for identifiers in backticks, which are never keywords
+	delimited bool
+}
+
+// pathOperators are the symbolic operators and punctuation, longest first.
+var pathOperators = []string{"!=", "!~", "<=", ">=", ".", "[", "]", "(", ")", "{", "}", ",", "=", "~", "<", ">",
+	"|", "+", "-", "*", "/", "&"}
+
+// tokenizePath splits expression into tokens.
+func tokenizePath(expression string) ([]pathToken, error) {
+	var tokens []pathToken
+	i := 0
+	for i < len(expression) {
+		r, size := utf8.DecodeRuneInString(expression[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case strings.HasPrefix(expression[i:], "//"):
+			end := strings.IndexByte(expression[i:], '\n')
+			if end < 0 {
+				i = len(expression)
+			} else {
+				i += end
+			}
+		case strings.HasPrefix(expression[i:], "/*"):
+			end := strings.Index(expression[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at position %d", i)
+			}
+			i += end + 4
+		case r == '\'' || r == '`':
+			text, n, err := unescapePathString(expression[i:], byte(r))
+			if err != nil {
+				return nil, fmt.Errorf("%v at position %d", err, i)
+			}
+			kind := tokenString
+			if r == '`' {
+				kind = tokenIdentifier
+			}
+			tokens = append(tokens, pathToken{kind: kind, text: text, pos: i, delimited: r == '`'})
+			i += n
+		case r == '@':
+			n := 1
+			for n < len(expression)-i && strings.ContainsRune("0123456789-:.TZ+", rune(expression[i+n])) {
+				n++
+			}
+			if n == 1 {
+				return nil, fmt.Errorf("missing date or time after @ at position %d", i)
+			}
+			tokens = append(tokens, pathToken{kind: tokenDateTime, text: expression[i+1 : i+n], pos: i})
+			i += n
+		case r >= '0' && r <= '9':
+			n := 0
+			for n < len(expression)-i && expression[i+n] >= '0' && expression[i+n] <= '9' {
+				n++
+			}
+			if n+1 < len(expression)-i && expression[i+n] == '.' && expression[i+n+1] >= '0' && expression[i+n+1] <= '9' {
+				n++
+				for n < len(expression)-i && expression[i+n] >= '0' && expression[i+n] <= '9' {
+					n++
+				}
+			}
+			tokens = append(tokens, pathToken{kind: tokenNumber, text: expression[i : i+n], pos: i})
+			i += n
+		case r == '$' || r == '%' || r == '_' || unicode.IsLetter(r):
+			n := size
+			for n < len(expression)-i {
+				next, nextSize := utf8.DecodeRuneInString(expression[i+n:])
+				if next != '_' && !unicode.IsLetter(next) && !unicode.IsDigit(next) {
+					break
+				}
+				n += nextSize
+			}
+			kind := tokenIdentifier
+			if r == '$' || r == '%' {
+				kind = tokenVariable
+				if n == 1 {
+					return nil, fmt.Errorf("missing variable name after %c at position %d", r, i)
+				}
+			}
+			tokens = append(tokens, pathToken{kind: kind, text: expression[i : i+n], pos: i})
+			i += n
+		default:
+			operator := ""
+			for _, op := range pathOperators {
+				if strings.HasPrefix(expression[i:], op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character `%c` at position %d", r, i)
+			}
+			tokens = append(tokens, pathToken{kind: tokenOperator, text: operator, pos: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, pathToken{kind: tokenEOF, pos: len(expression)}), nil
+}
+
+// unescapePathString unescapes the string or delimited identifier at the
+// start of s, which starts with quote. Returns the unescaped text and the
+// number of bytes consumed.
+func unescapePathString(s string, quote byte) (string, int, error) {
+	builder := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return builder.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				builder.WriteByte('\n')
+			case 'r':
+				builder.WriteByte('\r')
+			case 't':
+				builder.WriteByte('\t')
+			case 'f':
+				builder.WriteByte('\f')
+			case 'u':
+				if i+4 >= len(s) {
+					return "", 0, fmt.Errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid unicode escape")
+				}
+				builder.WriteRune(rune(code))
+				i += 4
+			default:
+				builder.WriteByte(s[i])
+			}
+		default:
+			builder.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// pathPrecedences are the precedences of the binary operators. Operators with
+// a higher precedence bind stronger.
+var pathPrecedences = map[string]int{
+	"*": 10, "/": 10, "div": 10, "mod": 10,
+	"+": 9, "-": 9, "&": 9,
+	"is": 8, "as": 8,
+	"|": 7,
+	"<": 6, "<=": 6, ">": 6, ">=": 6,
+	"=": 5, "~": 5, "!=": 5, "!~": 5,
+	"in": 4, "contains": 4,
+	"and": 3,
+	"or":  2, "xor": 2,
+	"implies": 1,
+}
+
+type pathParser struct {
+	tokens []pathToken
+	pos    int
+}
+
+func (p *pathParser) peek() pathToken {
+	return p.tokens[p.pos]
+}
+
+func (p *pathParser) next() pathToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *pathParser) isOperator(text string) bool {
+	token := p.peek()
+	return token.kind == tokenOperator && token.text == text
+}
+
+func (p *pathParser) expect(text string) error {
+	if !p.isOperator(text) {
+		return p.unexpected()
+	}
+	p.next()
+	return nil
+}
+
+func (p *pathParser) unexpected() error {
+	return unexpectedToken(p.peek())
+}
+
+func unexpectedToken(token pathToken) error {
+	if token.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected `%s` at position %d", token.text, token.pos)
+}
+
+// binaryOperator returns the binary operator of the next token, if any.
+func (p *pathParser) binaryOperator() (string, bool) {
+	token := p.peek()
+	if token.kind != tokenOperator && (token.kind != tokenIdentifier || token.delimited) {
+		return "", false
+	}
+	_, ok := pathPrecedences[token.text]
+	return token.text, ok
+}
+
+// parseExpression parses binary operations with at least minPrecedence by
+// precedence climbing. All binary operators are left associative.
+func (p *pathParser) parseExpression(minPrecedence int) (pathNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.binaryOperator()
+		if !ok || pathPrecedences[operator] < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		if operator == "is" || operator == "as" {
+			typeName, err := p.parseTypeName()
+			if err != nil {
+				return nil, err
+			}
+			left = &typeNode{operator: operator, operand: left, typeName: typeName}
+			continue
+		}
+		right, err := p.parseExpression(pathPrecedences[operator] + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+// parseTypeName parses a type specifier like Patient or FHIR.string. The
+// namespace is dropped.
+func (p *pathParser) parseTypeName() (string, error) {
+	token := p.next()
+	if token.kind != tokenIdentifier {
+		return "", unexpectedToken(token)
+	}
+	name := token.text
+	if p.isOperator(".") && p.tokens[p.pos+1].kind == tokenIdentifier {
+		p.next()
+		name = p.next().text
+	}
+	return name, nil
+}
+
+func (p *pathParser) parseUnary() (pathNode, error) {
+	if p.isOperator("-") || p.isOperator("+") {
+		operator := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operator == "+" {
+			return operand, nil
+		}
+		return &negationNode{operand: operand}, nil
+	}
+	return p.parseInvocations()
+}
+
+// parseInvocations parses a term followed by any number of member accesses,
+// function calls and indexers.
+func (p *pathParser) parseInvocations() (pathNode, error) {
+	node, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOperator("."):
+			p.next()
+			token := p.next()
+			if token.kind != tokenIdentifier {
+				return nil, unexpectedToken(token)
+			}
+			if node, err = p.parseMemberOrFunction(node, token.text); err != nil {
+				return nil, err
+			}
+		case p.isOperator("["):
+			p.next()
+			index, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			node = &indexNode{target: node, index: index}
+		default:
+			return node, nil
+		}
+	}
+}
+
+// parseMemberOrFunction parses the function call with name if the next token
+// opens its arguments and returns the member access of name otherwise.
+func (p *pathParser) parseMemberOrFunction(target pathNode, name string) (pathNode, error) {
+	if !p.isOperator("(") {
+		return &memberNode{target: target, name: name}, nil
+	}
+	p.next()
+	var args []pathNode
+	for !p.isOperator(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	function, ok := pathFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) < function.minArgs || len(args) > function.maxArgs {
+		expected := strconv.Itoa(function.minArgs)
+		if function.maxArgs > function.minArgs {
+			expected = fmt.Sprintf("%d to %d", function.minArgs, function.maxArgs)
+		}
+		return nil, fmt.Errorf("the function %s expects %s arguments, but got %d", name, expected, len(args))
+	}
+	return &functionNode{target: target, name: name, args: args}, nil
+}
+
+func (p *pathParser) parseTerm() (pathNode, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenString:
+		return &literalNode{values: []interface{}{token.text}}, nil
+	case tokenNumber:
+		return &literalNode{values: []interface{}{json.Number(token.text)}}, nil
+	case tokenDateTime:
+		return &literalNode{values: []interface{}{token.text}}, nil
+	case tokenVariable:
+		return &variableNode{name: token.text}, nil
+	case tokenIdentifier:
+		if !token.delimited {
+			switch token.text {
+			case "true":
+				return &literalNode{values: []interface{}{true}}, nil
+			case "false":
+				return &literalNode{values: []interface{}{false}}, nil
+			}
+		}
+		return p.parseMemberOrFunction(nil, token.text)
+	case tokenOperator:
+		switch token.text {
+		case "(":
+			node, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "{":
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			return &literalNode{}, nil
+		}
+	}
+	return nil, unexpectedToken(token)
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const pathTestPatient = `{
+  "resourceType": "Patient",
+  "id": "0",
+  "active": true,
+  "gender": "female",
+  "birthDate": "1990-05-12",
+  "name": [
+    {"use": "official", "family": "Doe", "given": ["Jane", "Marie"]},
+    {"use": "nickname", "given": ["Janie"]}
+  ],
+  "extension": [
+    {"url": "http://example.com/ext", "valueString": "foo"}
+  ],
+  "multipleBirthInteger": 2
+}`
+
+func evaluatePath(t *testing.T, expression string) ([]interface{}, error) {
+	resource, err := DecodeResource(json.NewDecoder(strings.NewReader(pathTestPatient)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := ParsePath(expression)
+	if err != nil {
+		return nil, err
+	}
+	return path.Evaluate(resource)
+}
+
+func TestPath_Evaluate(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   []interface{}
+	}{
+		{"Patient.id", []interface{}{"0"}},
+		{"id", []interface{}{"0"}},
+		{"Observation.id", nil},
+		{"name.given", []interface{}{"Jane", "Marie", "Janie"}},
+		{"name.given[1]", []interface{}{"Marie"}},
+		{"name.given[5]", nil},
+		{"name.where(use = 'official').family", []interface{}{"Doe"}},
+		{"name.given.where($this.startsWith('Ja'))", []interface{}{"Jane", "Janie"}},
+		{"name.select(given.first())", []interface{}{"Jane", "Janie"}},
+		{"name.given.count()", []interface{}{json.Number("3")}},
+		{"name.exists(use = 'nickname')", []interface{}{true}},
+		{"name.all(given.exists())", []interface{}{true}},
+		{"telecom.empty()", []interface{}{true}},
+		{"multipleBirth", []interface{}{json.Number("2")}},
+		{"multipleBirth + 1", []interface{}{json.Number("3")}},
+		{"multipleBirth / 4", []interface{}{json.Number("0.5")}},
+		{"7 div 2 = 3 and 7 mod 2 = 1", []interface{}{true}},
+		{"-multipleBirth", []interface{}{json.Number("-2")}},
+		{"2 + 3 * 4", []interface{}{json.Number("14")}},
+		{"(2 + 3) * 4", []interface{}{json.Number("20")}},
+		{"birthDate < @2000-01-01", []interface{}{true}},
+		{"gender = 'male'", []interface{}{false}},
+		{"gender != 'male'", []interface{}{true}},
+		{"gender ~ 'FEMALE'", []interface{}{true}},
+		{"deceased = true", nil},
+		{"deceased.exists() or active", []interface{}{true}},
+		{"{} and false", []interface{}{false}},
+		{"{} and true", nil},
+		{"false implies {}", []interface{}{true}},
+		{"true xor false", []interface{}{true}},
+		{"active.not()", []interface{}{false}},
+		{"name.given | name.family", []interface{}{"Jane", "Marie", "Janie", "Doe"}},
+		{"name.given.union('Jane')", []interface{}{"Jane", "Marie", "Janie"}},
+		{"name.given.combine('Jane').count()", []interface{}{json.Number("4")}},
+		{"'Jane' in name.given", []interface{}{true}},
+		{"name.given contains 'Joe'", []interface{}{false}},
+		{"name.family & ', ' & name.given.first()", []interface{}{"Doe, Jane"}},
+		{"name.given.first() + ' ' + name.family", []interface{}{"Jane Doe"}},
+		{"name.given.skip(1).take(1)", []interface{}{"Marie"}},
+		{"name.given.last()", []interface{}{"Janie"}},
+		{"name.given.tail().distinct()", []interface{}{"Marie", "Janie"}},
+		{"name.family.single()", []interface{}{"Doe"}},
+		{"name.family.upper().lower().length()", []interface{}{json.Number("3")}},
+		{"name.family.substring(1)", []interface{}{"oe"}},
+		{"name.family.substring(0, 2)", []interface{}{"Do"}},
+		{"name.family.indexOf('e')", []interface{}{json.Number("2")}},
+		{"name.family.matches('^D')", []interface{}{true}},
+		{"name.family.replace('D', 'R')", []interface{}{"Roe"}},
+		{"' a '.trim()", []interface{}{"a"}},
+		{"birthDate.split('-')", []interface{}{"1990", "05", "12"}},
+		{"name.given.join(',')", []interface{}{"Jane,Marie,Janie"}},
+		{"multipleBirth.toString()", []interface{}{"2"}},
+		{"'42'.toInteger()", []interface{}{json.Number("42")}},
+		{"'x'.toInteger()", nil},
+		{"iif(active, 'yes', 'no')", []interface{}{"yes"}},
+		{"extension('http://example.com/ext').value", []interface{}{"foo"}},
+		{"extension.where(url = 'http://example.com/ext').valueString", []interface{}{"foo"}},
+		{"gender.hasValue()", []interface{}{true}},
+		{"name.first().children().count()", []interface{}{json.Number("4")}},
+		{"descendants().ofType(string).count() > 5", []interface{}{true}},
+		{"%resource is Patient", []interface{}{true}},
+		{"(%resource as Observation).id", nil},
+		{"%resource.is(FHIR.Patient)", []interface{}{true}},
+		{"name.given.where($index = 1)", []interface{}{"Marie"}},
+		{"`gender`", []interface{}{"female"}},
+		{"name.family // the family name", []interface{}{"Doe"}},
+		{"'a\\'b'", []interface{}{"a'b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			result, err := evaluatePath(t, test.expression)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestPath_EvaluateErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"name.given.single()", "error while evaluating `name.given.single()`: error in function single: expected a single item, but got 3"},
+		{"name.given + 1", "error while evaluating `name.given + 1`: the operator + expects a single number, but got 3 items"},
+		{"gender < 1", "error while evaluating `gender < 1`: can't compare female with 1"},
+		{"%foo", "error while evaluating `%foo`: unknown variable %foo"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := evaluatePath(t, test.expression)
+
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"name.", "invalid FHIRPath expression `name.`: unexpected end of expression"},
+		{"name.foo()", "invalid FHIRPath expression `name.foo()`: unknown function foo"},
+		{"name.where()", "invalid FHIRPath expression `name.where()`: the function where expects 1 arguments, but got 0"},
+		{"name given", "invalid FHIRPath expression `name given`: unexpected `given` at position 5"},
+		{"'foo", "invalid FHIRPath expression `'foo`: unterminated string at position 0"},
+		{"name # 1", "invalid FHIRPath expression `name # 1`: unexpected character `#` at position 5"},
+		{"name.substring(1, 2, 3)", "invalid FHIRPath expression `name.substring(1, 2, 3)`: the function substring expects 1 to 2 arguments, but got 3"},
+		{"(name", "invalid FHIRPath expression `(name`: unexpected end of expression"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := ParsePath(test.expression)
+
+			assert.EqualError(t, err, test.err)
+		})
+	}
+
+	t.Run("String", func(t *testing.T) {
+		path, err := ParsePath("Patient.name")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Patient.name", path.String())
+	})
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build ignore
+
+// This program generates resourceTypes.go. It can be invoked by running
+// go generate in the fhir package.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"go/format"
+	"log"
+	"os"
+)
+
+// abstractTypes are the resource types no resource can have.
+var abstractTypes = map[string]bool{"Resource": true, "DomainResource": true}
+
+func main() {
+	var buf bytes.Buffer
+	buf.WriteString(`// Code generated by genResourceTypes.go; DO NOT EDIT.
+
+package fhir
+
+import (
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"reflect"
+)
+
+// resourceTypes maps the names of all concrete FHIR R4 resource types to the
+// types of their models which are generated from the R4 StructureDefinitions.
+var resourceTypes = map[string]reflect.Type{
+`)
+	for i := 0; fm.ResourceType(i).Code() != "<unknown>"; i++ {
+		name := fm.ResourceType(i).Code()
+		if abstractTypes[name] {
+			continue
+		}
+		fmt.Fprintf(&buf, "\t%q: reflect.TypeOf(fm.%s{}),\n", name, name)
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("resourceTypes.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
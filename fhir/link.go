@@ -0,0 +1,178 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A Link is a single link of an HTTP Link header as defined in RFC 8288.
+type Link struct {
+	Target *url.URL
+	// Rel contains all relation types of the link in lower case.
+	Rel []string
+	// Params contains all other target attributes keyed by their lower case
+	// name. Only the first occurrence of an attribute is kept.
+	Params map[string]string
+}
+
+// HasRel returns true iff the link has the given relation type.
+func (l Link) HasRel(rel string) bool {
+	for _, r := range l.Rel {
+		if r == strings.ToLower(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLinkHeader parses the values of one or more HTTP Link headers according
+// to RFC 8288. Quoted parameter values and multiple relation types per link are
+// supported. Relative target URLs are resolved against base.
+func ParseLinkHeader(values []string, base *url.URL) ([]Link, error) {
+	var links []Link
+	for _, value := range values {
+		p := linkParser{s: value}
+		for {
+			p.skip(" \t,")
+			if p.eof() {
+				break
+			}
+			link, err := p.parseLink(base)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Link header `%s`: %w", value, err)
+			}
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// NextLink returns the target of the first link with relation type next or nil
+// if there is no such link.
+func NextLink(links []Link) *url.URL {
+	for _, link := range links {
+		if link.HasRel("next") {
+			return link.Target
+		}
+	}
+	return nil
+}
+
+type linkParser struct {
+	s   string
+	pos int
+}
+
+func (p *linkParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *linkParser) skip(chars string) {
+	for !p.eof() && strings.IndexByte(chars, p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *linkParser) parseLink(base *url.URL) (Link, error) {
+	if p.s[p.pos] != '<' {
+		return Link{}, fmt.Errorf("expected `<` at position %d", p.pos)
+	}
+	end := strings.IndexByte(p.s[p.pos:], '>')
+	if end < 0 {
+		return Link{}, fmt.Errorf("missing `>` after position %d", p.pos)
+	}
+	target, err := url.Parse(strings.TrimSpace(p.s[p.pos+1 : p.pos+end]))
+	if err != nil {
+		return Link{}, err
+	}
+	if base != nil {
+		target = base.ResolveReference(target)
+	}
+	p.pos += end + 1
+
+	link := Link{Target: target, Params: make(map[string]string)}
+	for {
+		p.skip(" \t")
+		if p.eof() || p.s[p.pos] == ',' {
+			return link, nil
+		}
+		if p.s[p.pos] != ';' {
+			return Link{}, fmt.Errorf("expected `;` or `,` at position %d", p.pos)
+		}
+		p.pos++
+		name, value, err := p.parseParam()
+		if err != nil {
+			return Link{}, err
+		}
+		if name == "rel" {
+			if link.Rel == nil {
+				link.Rel = strings.Fields(strings.ToLower(value))
+			}
+		} else if _, ok := link.Params[name]; name != "" && !ok {
+			link.Params[name] = value
+		}
+	}
+}
+
+func (p *linkParser) parseParam() (string, string, error) {
+	p.skip(" \t")
+	start := p.pos
+	for !p.eof() && strings.IndexByte("=;, \t", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	name := strings.ToLower(p.s[start:p.pos])
+	p.skip(" \t")
+	if p.eof() || p.s[p.pos] != '=' {
+		return name, "", nil
+	}
+	p.pos++
+	p.skip(" \t")
+	if !p.eof() && p.s[p.pos] == '"' {
+		value, err := p.parseQuotedString()
+		return name, value, err
+	}
+	start = p.pos
+	for !p.eof() && strings.IndexByte(";, \t", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	return name, p.s[start:p.pos], nil
+}
+
+func (p *linkParser) parseQuotedString() (string, error) {
+	start := p.pos
+	p.pos++
+	var value strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return value.String(), nil
+		case '\\':
+			p.pos++
+			if p.eof() {
+				return "", fmt.Errorf("unterminated quoted string at position %d", start)
+			}
+			value.WriteByte(p.s[p.pos])
+		default:
+			value.WriteByte(c)
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("unterminated quoted string at position %d", start)
+}
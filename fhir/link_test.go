@@ -0,0 +1,102 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestParseLinkHeader(t *testing.T) {
+	base, _ := url.Parse("http://localhost:8080/fhir/Patient?_count=10")
+
+	t.Run("Empty", func(t *testing.T) {
+		links, err := ParseLinkHeader([]string{""}, base)
+
+		assert.Nil(t, err)
+		assert.Empty(t, links)
+	})
+
+	t.Run("SingleLink", func(t *testing.T) {
+		links, err := ParseLinkHeader([]string{`<http://localhost:8080/fhir/__page/1>; rel="next"`}, base)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(links))
+		assert.Equal(t, "http://localhost:8080/fhir/__page/1", links[0].Target.String())
+		assert.Equal(t, []string{"next"}, links[0].Rel)
+	})
+
+	t.Run("UrlWithCommasAndSemicolons", func(t *testing.T) {
+		links, err := ParseLinkHeader([]string{`<http://localhost/Patient?code=a,b;c>; rel=next, <http://localhost/self>; rel=self`}, base)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(links))
+		assert.Equal(t, "http://localhost/Patient?code=a,b;c", links[0].Target.String())
+		assert.Equal(t, "http://localhost/self", links[1].Target.String())
+		assert.True(t, links[1].HasRel("self"))
+	})
+
+	t.Run("QuotedParamWithSeparators", func(t *testing.T) {
+		links, err := ParseLinkHeader([]string{`<http://localhost/a>; title="a, b; \"c\""; rel="next"`}, base)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(links))
+		assert.Equal(t, `a, b; "c"`, links[0].Params["title"])
+		assert.True(t, links[0].HasRel("next"))
+	})
+
+	t.Run("MultipleRelValues", func(t *testing.T) {
+		links, err := ParseLinkHeader([]string{`<http://localhost/a>; rel="Next last"`}, base)
+
+		assert.Nil(t, err)
+		assert.True(t, links[0].HasRel("next"))
+		assert.True(t, links[0].HasRel("last"))
+	})
+
+	t.Run("RelativeUrl", func(t *testing.T) {
+		links, err := ParseLinkHeader([]string{`<__page/2>; rel=next`}, base)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://localhost:8080/fhir/__page/2", NextLink(links).String())
+	})
+
+	t.Run("MultipleHeaderValues", func(t *testing.T) {
+		links, err := ParseLinkHeader([]string{`</self>; rel=self`, `</next>; rel=next`}, base)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://localhost:8080/next", NextLink(links).String())
+	})
+
+	t.Run("MissingClosingBracket", func(t *testing.T) {
+		_, err := ParseLinkHeader([]string{`<http://localhost/a; rel=next`}, base)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("UnterminatedQuotedString", func(t *testing.T) {
+		_, err := ParseLinkHeader([]string{`<http://localhost/a>; rel="next`}, base)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNextLink(t *testing.T) {
+	t.Run("NoNextLink", func(t *testing.T) {
+		links, _ := ParseLinkHeader([]string{`</self>; rel=self`}, nil)
+
+		assert.Nil(t, NextLink(links))
+	})
+}
@@ -0,0 +1,270 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultPackageRegistry is the base URL of the public FHIR package registry.
+const DefaultPackageRegistry = "https://packages.fhir.org"
+
+// A Package is a FHIR NPM package like the one of an ImplementationGuide.
+type Package struct {
+	Name    string
+	Version string
+	// StructureDefinitions keyed by their canonical URL
+	Profiles map[string]Profile
+	// all resources of the package in the order they were read
+	Resources []PackageResource
+}
+
+// A PackageResource is a resource of a Package together with its raw JSON
+// representation.
+type PackageResource struct {
+	ResourceType string
+	Id           string
+	Url          string
+	// BaseDefinition is only set for StructureDefinitions
+	BaseDefinition string
+	Data           []byte
+}
+
+// A Profile is the part of a FHIR StructureDefinition needed to validate
+// resources against it.
+type Profile struct {
+	Url          string           `json:"url"`
+	Name         string           `json:"name"`
+	Type         string           `json:"type"`
+	Snapshot     *ProfileElements `json:"snapshot,omitempty"`
+	Differential *ProfileElements `json:"differential,omitempty"`
+}
+
+// ProfileElements are the elements of a snapshot or differential.
+type ProfileElements struct {
+	Element []ProfileElement `json:"element"`
+}
+
+// Elements returns the snapshot elements of sd or the differential elements
+// if sd has no snapshot.
+func (sd Profile) Elements() []ProfileElement {
+	if sd.Snapshot != nil {
+		return sd.Snapshot.Element
+	}
+	if sd.Differential != nil {
+		return sd.Differential.Element
+	}
+	return nil
+}
+
+// A ProfileElement is the part of a FHIR ElementDefinition needed to validate
+// resources against it.
+type ProfileElement struct {
+	Id        string
+	Path      string
+	SliceName string
+	Min       *int
+	Max       string
+	// Fixed is the raw JSON value of the fixed[x] element
+	Fixed json.RawMessage
+	// Pattern is the raw JSON value of the pattern[x] element
+	Pattern json.RawMessage
+}
+
+// UnmarshalJSON unmarshals a ProfileElement collecting the value of the
+// fixed[x] and pattern[x] elements regardless of their type.
+func (e *ProfileElement) UnmarshalJSON(data []byte) error {
+	var elements map[string]json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	for name, value := range elements {
+		var err error
+		switch {
+		case name == "id":
+			err = json.Unmarshal(value, &e.Id)
+		case name == "path":
+			err = json.Unmarshal(value, &e.Path)
+		case name == "sliceName":
+			err = json.Unmarshal(value, &e.SliceName)
+		case name == "min":
+			err = json.Unmarshal(value, &e.Min)
+		case name == "max":
+			err = json.Unmarshal(value, &e.Max)
+		case isChoiceElement(name, "fixed"):
+			e.Fixed = value
+		case isChoiceElement(name, "pattern"):
+			e.Pattern = value
+		}
+		if err != nil {
+			return fmt.Errorf("invalid element `%s`: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// isChoiceElement returns true iff name is a type specific name of the choice
+// element base, like fixedCode for fixed[x].
+func isChoiceElement(name string, base string) bool {
+	return len(name) > len(base) && strings.HasPrefix(name, base) &&
+		name[len(base)] >= 'A' && name[len(base)] <= 'Z'
+}
+
+type packageManifest struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func newPackage() *Package {
+	return &Package{Profiles: make(map[string]Profile)}
+}
+
+// addFile adds the content of the package file with the given name to the
+// package. Files other than package.json and resources are ignored.
+func (p *Package) addFile(name string, data []byte) error {
+	if filepath.Base(name) == "package.json" {
+		var manifest packageManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("error while reading %s: %w", name, err)
+		}
+		p.Name = manifest.Name
+		p.Version = manifest.Version
+		return nil
+	}
+
+	var resource struct {
+		ResourceType   string `json:"resourceType"`
+		Id             string `json:"id"`
+		Url            string `json:"url"`
+		BaseDefinition string `json:"baseDefinition"`
+	}
+	if err := json.Unmarshal(data, &resource); err != nil || resource.ResourceType == "" {
+		return nil
+	}
+	p.Resources = append(p.Resources, PackageResource{
+		ResourceType:   resource.ResourceType,
+		Id:             resource.Id,
+		Url:            resource.Url,
+		BaseDefinition: resource.BaseDefinition,
+		Data:           data,
+	})
+	if resource.ResourceType != "StructureDefinition" {
+		return nil
+	}
+	var sd Profile
+	if err := json.Unmarshal(data, &sd); err != nil {
+		return fmt.Errorf("error while reading the StructureDefinition %s: %w", name, err)
+	}
+	p.Profiles[sd.Url] = sd
+	return nil
+}
+
+// ReadPackage reads a package in the gzip compressed tar format.
+func ReadPackage(r io.Reader) (*Package, error) {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the package: %w", err)
+	}
+	defer gzipReader.Close()
+
+	p := newPackage()
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return p, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error while reading the package: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".json") {
+			continue
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading %s: %w", header.Name, err)
+		}
+		if err := p.addFile(header.Name, data); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// LoadPackage loads a package either from a .tgz file or from a directory
+// containing the extracted package.
+func LoadPackage(path string) (*Package, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return ReadPackage(file)
+	}
+
+	p := newPackage()
+	err = filepath.WalkDir(path, func(name string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return p.addFile(name, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// FetchPackage downloads the package with the given id and version from the
+// package registry with the given base URL.
+func FetchPackage(registry string, id string, version string) (*Package, error) {
+	registryURL, err := url.Parse(registry)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the package registry URL: %w", err)
+	}
+
+	resp, err := http.Get(registryURL.JoinPath(id, version).String())
+	if err != nil {
+		return nil, fmt.Errorf("error while downloading the package %s@%s: %w", id, version, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error while downloading the package %s@%s: %s", id, version, resp.Status)
+	}
+	return ReadPackage(resp.Body)
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testProfile = `{
+  "resourceType": "StructureDefinition",
+  "url": "http://example.com/StructureDefinition/patient",
+  "name": "TestPatient",
+  "type": "Patient",
+  "differential": {
+    "element": [
+      {"id": "Patient", "path": "Patient"},
+      {"id": "Patient.gender", "path": "Patient.gender", "min": 1, "fixedCode": "female"}
+    ]
+  }
+}`
+
+func testPackageFiles() map[string]string {
+	return map[string]string{
+		"package/package.json":                     `{"name": "example.fhir", "version": "1.0.0"}`,
+		"package/StructureDefinition-patient.json": testProfile,
+		"package/ValueSet-gender.json":             `{"resourceType": "ValueSet"}`,
+		"package/other/README.md":                  "readme",
+	}
+}
+
+func createTestPackage(t *testing.T) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range testPackageFiles() {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func assertTestPackage(t *testing.T, p *Package) {
+	assert.Equal(t, "example.fhir", p.Name)
+	assert.Equal(t, "1.0.0", p.Version)
+	assert.Equal(t, 1, len(p.Profiles))
+	assert.Equal(t, 2, len(p.Resources))
+	profile := p.Profiles["http://example.com/StructureDefinition/patient"]
+	assert.Equal(t, "Patient", profile.Type)
+	assert.Equal(t, 2, len(profile.Elements()))
+	assert.Equal(t, `"female"`, string(profile.Elements()[1].Fixed))
+	assert.Equal(t, 1, *profile.Elements()[1].Min)
+}
+
+func TestReadPackage(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		p, err := ReadPackage(bytes.NewReader(createTestPackage(t)))
+
+		assert.Nil(t, err)
+		assertTestPackage(t, p)
+	})
+
+	t.Run("NoGzip", func(t *testing.T) {
+		_, err := ReadPackage(bytes.NewReader([]byte("foo")))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestLoadPackage(t *testing.T) {
+	t.Run("Tgz", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "package.tgz")
+		if err := os.WriteFile(path, createTestPackage(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := LoadPackage(path)
+
+		assert.Nil(t, err)
+		assertTestPackage(t, p)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		for name, content := range testPackageFiles() {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p, err := LoadPackage(dir)
+
+		assert.Nil(t, err)
+		assertTestPackage(t, p)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := LoadPackage(filepath.Join(t.TempDir(), "missing.tgz"))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestFetchPackage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.fhir/1.0.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(createTestPackage(t))
+	}))
+	defer server.Close()
+
+	t.Run("Found", func(t *testing.T) {
+		p, err := FetchPackage(server.URL, "example.fhir", "1.0.0")
+
+		assert.Nil(t, err)
+		assertTestPackage(t, p)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := FetchPackage(server.URL, "example.fhir", "2.0.0")
+
+		assert.ErrorContains(t, err, "404 Not Found")
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ValidateProfile validates a FHIR resource in JSON format against the
+// profile sd without contacting a server. The cardinalities of all elements
+// as well as fixed[x] and pattern[x] values are checked. Slices are not
+// checked.
+//
+// Returns an error only if data isn't a JSON object.
+func ValidateProfile(data []byte, sd Profile) ([]StructureIssue, error) {
+	resource, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the resource: %w", err)
+	}
+	object, ok := resource.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error while reading the resource: expected a JSON object")
+	}
+	if object["resourceType"] != sd.Type {
+		return []StructureIssue{{Path: pathOrRoot(""), Message: fmt.Sprintf("expected resource type `%s` of profile `%s` but was `%v`", sd.Type, sd.Url, object["resourceType"])}}, nil
+	}
+
+	var issues []StructureIssue
+	for _, element := range sd.Elements() {
+		if element.SliceName != "" || strings.Contains(element.Id, ":") {
+			continue
+		}
+		segments := strings.Split(element.Path, ".")
+		if len(segments) < 2 {
+			continue
+		}
+		issues = append(issues, validateElement(object, segments[1:], element)...)
+	}
+	return issues, nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+func validateElement(resource map[string]interface{}, segments []string, element ProfileElement) []StructureIssue {
+	parents := []interface{}{resource}
+	for _, segment := range segments[:len(segments)-1] {
+		parents = childValues(parents, segment)
+	}
+
+	var fixed, pattern interface{}
+	if element.Fixed != nil {
+		fixed, _ = decodeJSON(element.Fixed)
+	}
+	if element.Pattern != nil {
+		pattern, _ = decodeJSON(element.Pattern)
+	}
+
+	var issues []StructureIssue
+	addIssue := func(format string, a ...interface{}) {
+		issues = append(issues, StructureIssue{Path: element.Path, Message: fmt.Sprintf(format, a...)})
+	}
+	for _, parent := range parents {
+		values := childValues([]interface{}{parent}, segments[len(segments)-1])
+		if element.Min != nil && len(values) < *element.Min {
+			addIssue("expected at least %d values but found %d", *element.Min, len(values))
+		}
+		if max, err := strconv.Atoi(element.Max); err == nil && len(values) > max {
+			addIssue("expected at most %d values but found %d", max, len(values))
+		}
+		for _, value := range values {
+			if fixed != nil && !reflect.DeepEqual(value, fixed) {
+				addIssue("value doesn't equal the fixed value %s", element.Fixed)
+			}
+			if pattern != nil && !matchesPattern(value, pattern) {
+				addIssue("value doesn't match the pattern %s", element.Pattern)
+			}
+		}
+	}
+	return issues
+}
+
+// childValues returns the values of the child elements with the given name of
+// all parents. Arrays are flattened. Names of choice elements like value[x]
+// match all type specific names like valueQuantity.
+func childValues(parents []interface{}, name string) []interface{} {
+	var values []interface{}
+	for _, parent := range parents {
+		object, ok := parent.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range object {
+			if key == name || (strings.HasSuffix(name, "[x]") && isChoiceElement(key, strings.TrimSuffix(name, "[x]"))) {
+				if array, ok := value.([]interface{}); ok {
+					values = append(values, array...)
+				} else {
+					values = append(values, value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// matchesPattern returns true iff value contains all elements of pattern. For
+// arrays, each element of pattern has to match at least one element of value.
+func matchesPattern(value interface{}, pattern interface{}) bool {
+	switch pattern := pattern.(type) {
+	case map[string]interface{}:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for name, patternValue := range pattern {
+			if !matchesPattern(object[name], patternValue) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		array, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, patternElement := range pattern {
+			found := false
+			for _, element := range array {
+				if matchesPattern(element, patternElement) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(value, pattern)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateProfile(t *testing.T) {
+	var profile Profile
+	if err := json.Unmarshal([]byte(`{
+  "url": "http://example.com/StructureDefinition/observation",
+  "type": "Observation",
+  "snapshot": {
+    "element": [
+      {"id": "Observation", "path": "Observation", "min": 0, "max": "*"},
+      {"id": "Observation.code", "path": "Observation.code", "min": 1, "max": "1",
+       "patternCodeableConcept": {"coding": [{"system": "http://loinc.org", "code": "8480-6"}]}},
+      {"id": "Observation.category", "path": "Observation.category", "min": 0, "max": "1"},
+      {"id": "Observation.category:vs", "path": "Observation.category", "sliceName": "vs", "min": 1, "max": "1"},
+      {"id": "Observation.value[x]", "path": "Observation.value[x]", "min": 1, "max": "1"},
+      {"id": "Observation.value[x].unit", "path": "Observation.value[x].unit", "min": 0, "max": "1", "fixedString": "mmHg"}
+    ]
+  }
+}`), &profile); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		issues, err := ValidateProfile([]byte(`{"resourceType": "Observation",
+  "code": {"coding": [{"system": "http://loinc.org", "code": "8480-6", "display": "Systolic"}]},
+  "valueQuantity": {"value": 120, "unit": "mmHg"}}`), profile)
+
+		assert.Nil(t, err)
+		assert.Empty(t, issues)
+	})
+
+	t.Run("WrongResourceType", func(t *testing.T) {
+		issues, err := ValidateProfile([]byte(`{"resourceType": "Patient"}`), profile)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(issues))
+	})
+
+	t.Run("Cardinalities", func(t *testing.T) {
+		issues, err := ValidateProfile([]byte(`{"resourceType": "Observation",
+  "code": {"coding": [{"system": "http://loinc.org", "code": "8480-6"}]},
+  "category": [{"text": "a"}, {"text": "b"}]}`), profile)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []StructureIssue{
+			{Path: "Observation.category", Message: "expected at most 1 values but found 2"},
+			{Path: "Observation.value[x]", Message: "expected at least 1 values but found 0"},
+		}, issues)
+	})
+
+	t.Run("PatternAndFixedValue", func(t *testing.T) {
+		issues, err := ValidateProfile([]byte(`{"resourceType": "Observation",
+  "code": {"coding": [{"system": "http://loinc.org", "code": "8462-4"}]},
+  "valueQuantity": {"value": 80, "unit": "mm[Hg]"}}`), profile)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(issues))
+		assert.Equal(t, "Observation.code", issues[0].Path)
+		assert.Contains(t, issues[0].Message, "doesn't match the pattern")
+		assert.Equal(t, "Observation.value[x].unit", issues[1].Path)
+		assert.Equal(t, `value doesn't equal the fixed value "mmHg"`, issues[1].Message)
+	})
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A recordedExchange is a request together with its response as stored on
+// disk by the recording transport.
+type recordedExchange struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// Record makes the client store every response in the directory dir, so
+// that it can be served by a client using Replay later. The directory is
+// created if it doesn't exist.
+func (c *Client) Record(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error while creating the record directory: %w", err)
+	}
+	next := c.httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.httpClient.Transport = &recordingTransport{next: next, dir: dir, counts: make(map[string]int)}
+	return nil
+}
+
+// Replay makes the client serve all responses from the directory dir
+// written by a client using Record. No request reaches the network.
+// Requests which were repeated during recording, like polls of an async
+// status endpoint, get their responses in the recorded order. Requests which
+// weren't recorded fail.
+func (c *Client) Replay(dir string) {
+	c.httpClient.Transport = &replayingTransport{dir: dir, counts: make(map[string]int)}
+}
+
+// exchangeKey identifies a request by its method, URL and body. Headers are
+// ignored because they contain values like correlation ids which differ
+// between runs.
+func exchangeKey(req *http.Request) (string, []byte, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return "", nil, err
+		}
+		_ = req.Body.Close()
+	}
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + " " + req.URL.String() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))[:32], body, nil
+}
+
+func exchangeFilename(dir string, key string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%d.json", key, n))
+}
+
+type recordingTransport struct {
+	next   http.RoundTripper
+	dir    string
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, requestBody, err := exchangeKey(req)
+	if err != nil {
+		return nil, err
+	}
+	if requestBody != nil {
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	exchange, err := json.Marshal(recordedExchange{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	n := t.counts[key]
+	t.counts[key]++
+	t.mu.Unlock()
+
+	if err := os.WriteFile(exchangeFilename(t.dir, key, n), exchange, 0644); err != nil {
+		return nil, fmt.Errorf("error while recording the response: %w", err)
+	}
+	return resp, nil
+}
+
+type replayingTransport struct {
+	dir    string
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (t *replayingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, _, err := exchangeKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	n := t.counts[key]
+	t.counts[key]++
+	t.mu.Unlock()
+
+	content, err := os.ReadFile(exchangeFilename(t.dir, key, n))
+	if errors.Is(err, os.ErrNotExist) && n > 0 {
+		// requests repeated more often than recorded get the last response
+		content, err = os.ReadFile(exchangeFilename(t.dir, key, n-1))
+		t.mu.Lock()
+		t.counts[key] = n
+		t.mu.Unlock()
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var exchange recordedExchange
+	if err := json.Unmarshal(content, &exchange); err != nil {
+		return nil, fmt.Errorf("error while reading the recorded response for %s %s: %w", req.Method, req.URL, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Header,
+		Body:          io.NopCloser(bytes.NewReader(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
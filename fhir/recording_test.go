@@ -0,0 +1,107 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func doRequest(t *testing.T, client *Client, method string, url string, body string) (int, string) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	responseBody, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(responseBody)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status":
+			polls++
+			if polls == 1 {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Header().Set("Content-Type", "application/fhir+json")
+			_, _ = w.Write([]byte("done"))
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(body)
+		}
+	}))
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	dir := t.TempDir()
+
+	recordingClient := NewClient(*baseURL, nil)
+	if err := recordingClient.Record(dir); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, recordingClient, http.MethodGet, server.URL+"/status", "")
+	doRequest(t, recordingClient, http.MethodGet, server.URL+"/status", "")
+	doRequest(t, recordingClient, http.MethodPost, server.URL+"/echo", "a")
+	doRequest(t, recordingClient, http.MethodPost, server.URL+"/echo", "b")
+	server.Close()
+
+	replayingClient := NewClient(*baseURL, nil)
+	replayingClient.Replay(dir)
+
+	t.Run("repeated requests in recorded order", func(t *testing.T) {
+		statusCode, _ := doRequest(t, replayingClient, http.MethodGet, server.URL+"/status", "")
+		assert.Equal(t, http.StatusAccepted, statusCode)
+		statusCode, body := doRequest(t, replayingClient, http.MethodGet, server.URL+"/status", "")
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "done", body)
+	})
+
+	t.Run("more repetitions than recorded", func(t *testing.T) {
+		statusCode, body := doRequest(t, replayingClient, http.MethodGet, server.URL+"/status", "")
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "done", body)
+	})
+
+	t.Run("requests distinguished by body", func(t *testing.T) {
+		_, body := doRequest(t, replayingClient, http.MethodPost, server.URL+"/echo", "b")
+		assert.Equal(t, "b", body)
+		_, body = doRequest(t, replayingClient, http.MethodPost, server.URL+"/echo", "a")
+		assert.Equal(t, "a", body)
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/status", nil)
+		resp, err := replayingClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, "application/fhir+json", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("unknown request", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/other", nil)
+		_, err := replayingClient.Do(req)
+		assert.ErrorContains(t, err, "no recorded response for GET")
+	})
+}
@@ -0,0 +1,159 @@
+// Code generated by genResourceTypes.go; DO NOT EDIT.
+
+package fhir
+
+import (
+	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"reflect"
+)
+
+// resourceTypes maps the names of all concrete FHIR R4 resource types to the
+// types of their models which are generated from the R4 StructureDefinitions.
+var resourceTypes = map[string]reflect.Type{
+	"Account":                           reflect.TypeOf(fm.Account{}),
+	"ActivityDefinition":                reflect.TypeOf(fm.ActivityDefinition{}),
+	"AdverseEvent":                      reflect.TypeOf(fm.AdverseEvent{}),
+	"AllergyIntolerance":                reflect.TypeOf(fm.AllergyIntolerance{}),
+	"Appointment":                       reflect.TypeOf(fm.Appointment{}),
+	"AppointmentResponse":               reflect.TypeOf(fm.AppointmentResponse{}),
+	"AuditEvent":                        reflect.TypeOf(fm.AuditEvent{}),
+	"Basic":                             reflect.TypeOf(fm.Basic{}),
+	"Binary":                            reflect.TypeOf(fm.Binary{}),
+	"BiologicallyDerivedProduct":        reflect.TypeOf(fm.BiologicallyDerivedProduct{}),
+	"BodyStructure":                     reflect.TypeOf(fm.BodyStructure{}),
+	"Bundle":                            reflect.TypeOf(fm.Bundle{}),
+	"CapabilityStatement":               reflect.TypeOf(fm.CapabilityStatement{}),
+	"CarePlan":                          reflect.TypeOf(fm.CarePlan{}),
+	"CareTeam":                          reflect.TypeOf(fm.CareTeam{}),
+	"CatalogEntry":                      reflect.TypeOf(fm.CatalogEntry{}),
+	"ChargeItem":                        reflect.TypeOf(fm.ChargeItem{}),
+	"ChargeItemDefinition":              reflect.TypeOf(fm.ChargeItemDefinition{}),
+	"Claim":                             reflect.TypeOf(fm.Claim{}),
+	"ClaimResponse":                     reflect.TypeOf(fm.ClaimResponse{}),
+	"ClinicalImpression":                reflect.TypeOf(fm.ClinicalImpression{}),
+	"CodeSystem":                        reflect.TypeOf(fm.CodeSystem{}),
+	"Communication":                     reflect.TypeOf(fm.Communication{}),
+	"CommunicationRequest":              reflect.TypeOf(fm.CommunicationRequest{}),
+	"CompartmentDefinition":             reflect.TypeOf(fm.CompartmentDefinition{}),
+	"Composition":                       reflect.TypeOf(fm.Composition{}),
+	"ConceptMap":                        reflect.TypeOf(fm.ConceptMap{}),
+	"Condition":                         reflect.TypeOf(fm.Condition{}),
+	"Consent":                           reflect.TypeOf(fm.Consent{}),
+	"Contract":                          reflect.TypeOf(fm.Contract{}),
+	"Coverage":                          reflect.TypeOf(fm.Coverage{}),
+	"CoverageEligibilityRequest":        reflect.TypeOf(fm.CoverageEligibilityRequest{}),
+	"CoverageEligibilityResponse":       reflect.TypeOf(fm.CoverageEligibilityResponse{}),
+	"DetectedIssue":                     reflect.TypeOf(fm.DetectedIssue{}),
+	"Device":                            reflect.TypeOf(fm.Device{}),
+	"DeviceDefinition":                  reflect.TypeOf(fm.DeviceDefinition{}),
+	"DeviceMetric":                      reflect.TypeOf(fm.DeviceMetric{}),
+	"DeviceRequest":                     reflect.TypeOf(fm.DeviceRequest{}),
+	"DeviceUseStatement":                reflect.TypeOf(fm.DeviceUseStatement{}),
+	"DiagnosticReport":                  reflect.TypeOf(fm.DiagnosticReport{}),
+	"DocumentManifest":                  reflect.TypeOf(fm.DocumentManifest{}),
+	"DocumentReference":                 reflect.TypeOf(fm.DocumentReference{}),
+	"EffectEvidenceSynthesis":           reflect.TypeOf(fm.EffectEvidenceSynthesis{}),
+	"Encounter":                         reflect.TypeOf(fm.Encounter{}),
+	"Endpoint":                          reflect.TypeOf(fm.Endpoint{}),
+	"EnrollmentRequest":                 reflect.TypeOf(fm.EnrollmentRequest{}),
+	"EnrollmentResponse":                reflect.TypeOf(fm.EnrollmentResponse{}),
+	"EpisodeOfCare":                     reflect.TypeOf(fm.EpisodeOfCare{}),
+	"EventDefinition":                   reflect.TypeOf(fm.EventDefinition{}),
+	"Evidence":                          reflect.TypeOf(fm.Evidence{}),
+	"EvidenceVariable":                  reflect.TypeOf(fm.EvidenceVariable{}),
+	"ExampleScenario":                   reflect.TypeOf(fm.ExampleScenario{}),
+	"ExplanationOfBenefit":              reflect.TypeOf(fm.ExplanationOfBenefit{}),
+	"FamilyMemberHistory":               reflect.TypeOf(fm.FamilyMemberHistory{}),
+	"Flag":                              reflect.TypeOf(fm.Flag{}),
+	"Goal":                              reflect.TypeOf(fm.Goal{}),
+	"GraphDefinition":                   reflect.TypeOf(fm.GraphDefinition{}),
+	"Group":                             reflect.TypeOf(fm.Group{}),
+	"GuidanceResponse":                  reflect.TypeOf(fm.GuidanceResponse{}),
+	"HealthcareService":                 reflect.TypeOf(fm.HealthcareService{}),
+	"ImagingStudy":                      reflect.TypeOf(fm.ImagingStudy{}),
+	"Immunization":                      reflect.TypeOf(fm.Immunization{}),
+	"ImmunizationEvaluation":            reflect.TypeOf(fm.ImmunizationEvaluation{}),
+	"ImmunizationRecommendation":        reflect.TypeOf(fm.ImmunizationRecommendation{}),
+	"ImplementationGuide":               reflect.TypeOf(fm.ImplementationGuide{}),
+	"InsurancePlan":                     reflect.TypeOf(fm.InsurancePlan{}),
+	"Invoice":                           reflect.TypeOf(fm.Invoice{}),
+	"Library":                           reflect.TypeOf(fm.Library{}),
+	"Linkage":                           reflect.TypeOf(fm.Linkage{}),
+	"List":                              reflect.TypeOf(fm.List{}),
+	"Location":                          reflect.TypeOf(fm.Location{}),
+	"Measure":                           reflect.TypeOf(fm.Measure{}),
+	"MeasureReport":                     reflect.TypeOf(fm.MeasureReport{}),
+	"Media":                             reflect.TypeOf(fm.Media{}),
+	"Medication":                        reflect.TypeOf(fm.Medication{}),
+	"MedicationAdministration":          reflect.TypeOf(fm.MedicationAdministration{}),
+	"MedicationDispense":                reflect.TypeOf(fm.MedicationDispense{}),
+	"MedicationKnowledge":               reflect.TypeOf(fm.MedicationKnowledge{}),
+	"MedicationRequest":                 reflect.TypeOf(fm.MedicationRequest{}),
+	"MedicationStatement":               reflect.TypeOf(fm.MedicationStatement{}),
+	"MedicinalProduct":                  reflect.TypeOf(fm.MedicinalProduct{}),
+	"MedicinalProductAuthorization":     reflect.TypeOf(fm.MedicinalProductAuthorization{}),
+	"MedicinalProductContraindication":  reflect.TypeOf(fm.MedicinalProductContraindication{}),
+	"MedicinalProductIndication":        reflect.TypeOf(fm.MedicinalProductIndication{}),
+	"MedicinalProductIngredient":        reflect.TypeOf(fm.MedicinalProductIngredient{}),
+	"MedicinalProductInteraction":       reflect.TypeOf(fm.MedicinalProductInteraction{}),
+	"MedicinalProductManufactured":      reflect.TypeOf(fm.MedicinalProductManufactured{}),
+	"MedicinalProductPackaged":          reflect.TypeOf(fm.MedicinalProductPackaged{}),
+	"MedicinalProductPharmaceutical":    reflect.TypeOf(fm.MedicinalProductPharmaceutical{}),
+	"MedicinalProductUndesirableEffect": reflect.TypeOf(fm.MedicinalProductUndesirableEffect{}),
+	"MessageDefinition":                 reflect.TypeOf(fm.MessageDefinition{}),
+	"MessageHeader":                     reflect.TypeOf(fm.MessageHeader{}),
+	"MolecularSequence":                 reflect.TypeOf(fm.MolecularSequence{}),
+	"NamingSystem":                      reflect.TypeOf(fm.NamingSystem{}),
+	"NutritionOrder":                    reflect.TypeOf(fm.NutritionOrder{}),
+	"Observation":                       reflect.TypeOf(fm.Observation{}),
+	"ObservationDefinition":             reflect.TypeOf(fm.ObservationDefinition{}),
+	"OperationDefinition":               reflect.TypeOf(fm.OperationDefinition{}),
+	"OperationOutcome":                  reflect.TypeOf(fm.OperationOutcome{}),
+	"Organization":                      reflect.TypeOf(fm.Organization{}),
+	"OrganizationAffiliation":           reflect.TypeOf(fm.OrganizationAffiliation{}),
+	"Parameters":                        reflect.TypeOf(fm.Parameters{}),
+	"Patient":                           reflect.TypeOf(fm.Patient{}),
+	"PaymentNotice":                     reflect.TypeOf(fm.PaymentNotice{}),
+	"PaymentReconciliation":             reflect.TypeOf(fm.PaymentReconciliation{}),
+	"Person":                            reflect.TypeOf(fm.Person{}),
+	"PlanDefinition":                    reflect.TypeOf(fm.PlanDefinition{}),
+	"Practitioner":                      reflect.TypeOf(fm.Practitioner{}),
+	"PractitionerRole":                  reflect.TypeOf(fm.PractitionerRole{}),
+	"Procedure":                         reflect.TypeOf(fm.Procedure{}),
+	"Provenance":                        reflect.TypeOf(fm.Provenance{}),
+	"Questionnaire":                     reflect.TypeOf(fm.Questionnaire{}),
+	"QuestionnaireResponse":             reflect.TypeOf(fm.QuestionnaireResponse{}),
+	"RelatedPerson":                     reflect.TypeOf(fm.RelatedPerson{}),
+	"RequestGroup":                      reflect.TypeOf(fm.RequestGroup{}),
+	"ResearchDefinition":                reflect.TypeOf(fm.ResearchDefinition{}),
+	"ResearchElementDefinition":         reflect.TypeOf(fm.ResearchElementDefinition{}),
+	"ResearchStudy":                     reflect.TypeOf(fm.ResearchStudy{}),
+	"ResearchSubject":                   reflect.TypeOf(fm.ResearchSubject{}),
+	"RiskAssessment":                    reflect.TypeOf(fm.RiskAssessment{}),
+	"RiskEvidenceSynthesis":             reflect.TypeOf(fm.RiskEvidenceSynthesis{}),
+	"Schedule":                          reflect.TypeOf(fm.Schedule{}),
+	"SearchParameter":                   reflect.TypeOf(fm.SearchParameter{}),
+	"ServiceRequest":                    reflect.TypeOf(fm.ServiceRequest{}),
+	"Slot":                              reflect.TypeOf(fm.Slot{}),
+	"Specimen":                          reflect.TypeOf(fm.Specimen{}),
+	"SpecimenDefinition":                reflect.TypeOf(fm.SpecimenDefinition{}),
+	"StructureDefinition":               reflect.TypeOf(fm.StructureDefinition{}),
+	"StructureMap":                      reflect.TypeOf(fm.StructureMap{}),
+	"Subscription":                      reflect.TypeOf(fm.Subscription{}),
+	"Substance":                         reflect.TypeOf(fm.Substance{}),
+	"SubstanceNucleicAcid":              reflect.TypeOf(fm.SubstanceNucleicAcid{}),
+	"SubstancePolymer":                  reflect.TypeOf(fm.SubstancePolymer{}),
+	"SubstanceProtein":                  reflect.TypeOf(fm.SubstanceProtein{}),
+	"SubstanceReferenceInformation":     reflect.TypeOf(fm.SubstanceReferenceInformation{}),
+	"SubstanceSourceMaterial":           reflect.TypeOf(fm.SubstanceSourceMaterial{}),
+	"SubstanceSpecification":            reflect.TypeOf(fm.SubstanceSpecification{}),
+	"SupplyDelivery":                    reflect.TypeOf(fm.SupplyDelivery{}),
+	"SupplyRequest":                     reflect.TypeOf(fm.SupplyRequest{}),
+	"Task":                              reflect.TypeOf(fm.Task{}),
+	"TerminologyCapabilities":           reflect.TypeOf(fm.TerminologyCapabilities{}),
+	"TestReport":                        reflect.TypeOf(fm.TestReport{}),
+	"TestScript":                        reflect.TypeOf(fm.TestScript{}),
+	"ValueSet":                          reflect.TypeOf(fm.ValueSet{}),
+	"VerificationResult":                reflect.TypeOf(fm.VerificationResult{}),
+	"VisionPrescription":                reflect.TypeOf(fm.VisionPrescription{}),
+}
@@ -0,0 +1,272 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+//go:generate go run genResourceTypes.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A StructureIssue describes a violation of the structure of a FHIR resource
+// like a missing required element or a wrong cardinality.
+type StructureIssue struct {
+	// Path is the location of the issue, e.g. Patient.name[0].given
+	Path    string
+	Message string
+}
+
+func (issue StructureIssue) String() string {
+	return fmt.Sprintf("%s: %s", issue.Path, issue.Message)
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	jsonNumberType      = reflect.TypeOf(json.Number(""))
+	jsonRawMessageType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// ValidateStructure validates the structure of a FHIR R4 resource in JSON
+// format without contacting a server. The rules are derived from the models
+// generated from the R4 StructureDefinitions. Elements with a minimum
+// cardinality of one are required and only elements with a maximum
+// cardinality greater than one may be arrays. Unknown elements, wrong
+// primitive types and unknown codes of required bindings are reported as well.
+//
+// Returns an error only if data isn't a JSON object.
+func ValidateStructure(data []byte) ([]StructureIssue, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var resource map[string]interface{}
+	if err := decoder.Decode(&resource); err != nil {
+		return nil, fmt.Errorf("error while reading the resource: %w", err)
+	}
+	v := structureValidator{}
+	v.validateResource("", resource)
+	sort.Slice(v.issues, func(i, j int) bool {
+		if v.issues[i].Path != v.issues[j].Path {
+			return v.issues[i].Path < v.issues[j].Path
+		}
+		return v.issues[i].Message < v.issues[j].Message
+	})
+	return v.issues, nil
+}
+
+type structureValidator struct {
+	issues []StructureIssue
+}
+
+func (v *structureValidator) addIssue(path string, format string, a ...interface{}) {
+	v.issues = append(v.issues, StructureIssue{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+// ResourceTypes returns the names of all concrete FHIR R4 resource types in
+// alphabetical order.
+func ResourceTypes() []string {
+	names := make([]string, 0, len(resourceTypes))
+	for name := range resourceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateResource validates a resource found at path. The path of the root
+// resource is empty.
+func (v *structureValidator) validateResource(path string, resource map[string]interface{}) {
+	resourceType, ok := resource["resourceType"].(string)
+	if !ok {
+		v.addIssue(pathOrRoot(path), "missing resourceType")
+		return
+	}
+	t, ok := resourceTypes[resourceType]
+	if !ok {
+		v.addIssue(pathOrRoot(path), "unknown resource type `%s`", resourceType)
+		return
+	}
+	if path == "" {
+		path = resourceType
+	}
+	v.validateObject(path, resource, t, true)
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "Resource"
+	}
+	return path
+}
+
+func (v *structureValidator) validateObject(path string, object map[string]interface{}, t reflect.Type, isResource bool) {
+	fields := jsonFields(t)
+
+	for name, value := range object {
+		if isResource && name == "resourceType" {
+			continue
+		}
+		// primitive extensions like _birthDate
+		if strings.HasPrefix(name, "_") {
+			if _, ok := fields[name[1:]]; !ok {
+				v.addIssue(path+"."+name, "unknown element")
+			}
+			continue
+		}
+		// the models don't contain contained resources
+		if isResource && name == "contained" {
+			v.validateContained(path+".contained", value)
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			v.addIssue(path+"."+name, "unknown element")
+			continue
+		}
+		v.validateValue(path+"."+name, value, field.Type)
+	}
+
+	for name, field := range fields {
+		if field.required {
+			if _, ok := object[name]; !ok {
+				if _, ok := object["_"+name]; !ok {
+					v.addIssue(path+"."+name, "missing required element")
+				}
+			}
+		}
+	}
+}
+
+func (v *structureValidator) validateContained(path string, value interface{}) {
+	resources, ok := value.([]interface{})
+	if !ok {
+		v.addIssue(path, "expected an array")
+		return
+	}
+	for i, resource := range resources {
+		v.validateEmbeddedResource(fmt.Sprintf("%s[%d]", path, i), resource)
+	}
+}
+
+func (v *structureValidator) validateEmbeddedResource(path string, value interface{}) {
+	resource, ok := value.(map[string]interface{})
+	if !ok {
+		v.addIssue(path, "expected a resource")
+		return
+	}
+	v.validateResource(path, resource)
+}
+
+func (v *structureValidator) validateValue(path string, value interface{}, t reflect.Type) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if value == nil {
+		v.addIssue(path, "null is not allowed")
+		return
+	}
+
+	if t == jsonRawMessageType {
+		v.validateEmbeddedResource(path, value)
+		return
+	}
+
+	if t.Kind() == reflect.Slice {
+		values, ok := value.([]interface{})
+		if !ok {
+			v.addIssue(path, "expected an array")
+			return
+		}
+		if len(values) == 0 {
+			v.addIssue(path, "arrays must not be empty")
+		}
+		for i, value := range values {
+			v.validateValue(fmt.Sprintf("%s[%d]", path, i), value, t.Elem())
+		}
+		return
+	}
+
+	if _, ok := value.([]interface{}); ok {
+		v.addIssue(path, "expected a single value but found an array")
+		return
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.addIssue(path, "expected an object")
+			return
+		}
+		v.validateObject(path, object, t, false)
+	case t.Kind() != reflect.String && reflect.PointerTo(t).Implements(jsonUnmarshalerType):
+		// codes of required bindings
+		s, ok := value.(string)
+		if !ok {
+			v.addIssue(path, "expected a string")
+			return
+		}
+		raw, _ := json.Marshal(s)
+		if err := reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(raw); err != nil {
+			v.addIssue(path, "%v", err)
+		}
+	case t == jsonNumberType:
+		if _, ok := value.(json.Number); !ok {
+			v.addIssue(path, "expected a number")
+		}
+	case t.Kind() == reflect.String:
+		if _, ok := value.(string); !ok {
+			v.addIssue(path, "expected a string")
+		}
+	case t.Kind() == reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			v.addIssue(path, "expected a boolean")
+		}
+	case t.Kind() == reflect.Int:
+		n, ok := value.(json.Number)
+		if !ok {
+			v.addIssue(path, "expected an integer")
+			return
+		}
+		if _, err := n.Int64(); err != nil {
+			v.addIssue(path, "expected an integer but was %s", n)
+		}
+	}
+}
+
+type jsonField struct {
+	reflect.Type
+	required bool
+}
+
+// jsonFields returns the fields of the struct type t keyed by their JSON name.
+// Fields without omitempty are required.
+func jsonFields(t reflect.Type) map[string]jsonField {
+	fields := make(map[string]jsonField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fields[name] = jsonField{Type: field.Type, required: options != "omitempty"}
+	}
+	return fields
+}
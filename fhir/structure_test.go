@@ -0,0 +1,120 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestResourceTypes(t *testing.T) {
+	types := ResourceTypes()
+
+	assert.IsIncreasing(t, types)
+	assert.Contains(t, types, "Patient")
+	assert.Contains(t, types, "Parameters")
+	assert.NotContains(t, types, "Resource")
+	assert.NotContains(t, types, "DomainResource")
+}
+
+func TestValidateStructure(t *testing.T) {
+	t.Run("ValidPatient", func(t *testing.T) {
+		issues, err := ValidateStructure([]byte(`{"resourceType": "Patient", "name": [{"family": "Doe"}], "gender": "female", "_birthDate": {"extension": []}}`))
+
+		assert.Nil(t, err)
+		assert.Empty(t, issues)
+	})
+
+	t.Run("NoJsonObject", func(t *testing.T) {
+		_, err := ValidateStructure([]byte(`[]`))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("MissingResourceType", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{}`))
+
+		assert.Equal(t, []StructureIssue{{Path: "Resource", Message: "missing resourceType"}}, issues)
+	})
+
+	t.Run("UnknownResourceType", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Foo"}`))
+
+		assert.Equal(t, []StructureIssue{{Path: "Resource", Message: "unknown resource type `Foo`"}}, issues)
+	})
+
+	t.Run("AbstractResourceType", func(t *testing.T) {
+		for _, resourceType := range []string{"Resource", "DomainResource"} {
+			issues, _ := ValidateStructure([]byte(`{"resourceType": "` + resourceType + `"}`))
+
+			assert.Equal(t, []StructureIssue{{Path: "Resource", Message: "unknown resource type `" + resourceType + "`"}}, issues)
+		}
+	})
+
+	t.Run("MissingRequiredElements", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Observation"}`))
+
+		assert.Equal(t, []StructureIssue{
+			{Path: "Observation.code", Message: "missing required element"},
+			{Path: "Observation.status", Message: "missing required element"},
+		}, issues)
+	})
+
+	t.Run("WrongCardinalities", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Patient", "name": {"family": "Doe"}, "gender": ["male"]}`))
+
+		assert.Equal(t, []StructureIssue{
+			{Path: "Patient.gender", Message: "expected a single value but found an array"},
+			{Path: "Patient.name", Message: "expected an array"},
+		}, issues)
+	})
+
+	t.Run("WrongPrimitiveTypes", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Patient", "active": "true", "birthDate": 2000, "multipleBirthInteger": 1.5}`))
+
+		assert.Equal(t, []StructureIssue{
+			{Path: "Patient.active", Message: "expected a boolean"},
+			{Path: "Patient.birthDate", Message: "expected a string"},
+			{Path: "Patient.multipleBirthInteger", Message: "expected an integer but was 1.5"},
+		}, issues)
+	})
+
+	t.Run("UnknownElementAndCode", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Patient", "foo": 1, "gender": "foo"}`))
+
+		assert.Equal(t, []StructureIssue{
+			{Path: "Patient.foo", Message: "unknown element"},
+			{Path: "Patient.gender", Message: "unknown AdministrativeGender code `foo`"},
+		}, issues)
+	})
+
+	t.Run("EmptyArray", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Patient", "name": []}`))
+
+		assert.Equal(t, []StructureIssue{{Path: "Patient.name", Message: "arrays must not be empty"}}, issues)
+	})
+
+	t.Run("BundleEntryResource", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Bundle", "type": "transaction", "entry": [{"resource": {"resourceType": "Observation", "status": "final"}, "request": {"method": "POST", "url": "Observation"}}]}`))
+
+		assert.Equal(t, []StructureIssue{{Path: "Bundle.entry[0].resource.code", Message: "missing required element"}}, issues)
+	})
+
+	t.Run("ContainedResource", func(t *testing.T) {
+		issues, _ := ValidateStructure([]byte(`{"resourceType": "Patient", "contained": [{"resourceType": "Organization", "active": 1}]}`))
+
+		assert.Equal(t, []StructureIssue{{Path: "Patient.contained[0].active", Message: "expected a boolean"}}, issues)
+	})
+}
@@ -0,0 +1,362 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A TLSCertificateError is returned by Do if the certificate of the server
+// can't be verified. Its message contains the certificate chain presented by
+// the server.
+type TLSCertificateError struct {
+	err   error
+	chain []*x509.Certificate
+}
+
+func (err *TLSCertificateError) Error() string {
+	return err.err.Error() + "\n\nCertificate chain presented by the server:\n\n" + FmtCertificateChain(err.chain, time.Now())
+}
+
+func (err *TLSCertificateError) Unwrap() error {
+	return err.err
+}
+
+// Chain returns the certificate chain presented by the server.
+func (err *TLSCertificateError) Chain() []*x509.Certificate {
+	return err.chain
+}
+
+// withCertificateChain returns a TLSCertificateError if err is caused by a
+// failed verification of the server certificate and err otherwise.
+func withCertificateChain(err error) error {
+	var verificationErr *tls.CertificateVerificationError
+	if errors.As(err, &verificationErr) {
+		return &TLSCertificateError{err: err, chain: verificationErr.UnverifiedCertificates}
+	}
+	return err
+}
+
+// FmtCertificateChain formats subject, issuer, SANs and validity of each
+// certificate of chain. The expiry is given relative to now.
+func FmtCertificateChain(chain []*x509.Certificate, now time.Time) string {
+	builder := strings.Builder{}
+	for i, cert := range chain {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(fmt.Sprintf("Certificate %d\n", i))
+		builder.WriteString(fmt.Sprintf("  Subject     : %s\n", cert.Subject))
+		builder.WriteString(fmt.Sprintf("  Issuer      : %s\n", cert.Issuer))
+		if len(cert.DNSNames) > 0 {
+			builder.WriteString(fmt.Sprintf("  DNS Names   : %s\n", strings.Join(cert.DNSNames, ", ")))
+		}
+		if len(cert.IPAddresses) > 0 {
+			ips := make([]string, 0, len(cert.IPAddresses))
+			for _, ip := range cert.IPAddresses {
+				ips = append(ips, ip.String())
+			}
+			builder.WriteString(fmt.Sprintf("  IP Addresses: %s\n", strings.Join(ips, ", ")))
+		}
+		if len(cert.RawSubjectPublicKeyInfo) > 0 {
+			builder.WriteString(fmt.Sprintf("  Pin SHA-256 : %s\n", publicKeyPin(cert)))
+		}
+		builder.WriteString(fmt.Sprintf("  Not Before  : %s\n", cert.NotBefore.UTC().Format(time.RFC3339)))
+		builder.WriteString(fmt.Sprintf("  Not After   : %s (%s)\n", cert.NotAfter.UTC().Format(time.RFC3339), fmtExpiry(cert.NotAfter, now)))
+	}
+	return builder.String()
+}
+
+func fmtExpiry(notAfter time.Time, now time.Time) string {
+	days := int(notAfter.Sub(now).Hours() / 24)
+	switch {
+	case notAfter.Before(now):
+		return fmt.Sprintf("expired %d days ago", -days)
+	case days == 1:
+		return "expires in 1 day"
+	default:
+		return fmt.Sprintf("expires in %d days", days)
+	}
+}
+
+// TLSInfo describes a TLS connection to a server.
+type TLSInfo struct {
+	Version     uint16
+	CipherSuite uint16
+	ALPN        string
+	Chain       []*x509.Certificate
+	// VerifyErr is the error of verifying the certificate chain or nil if it
+	// could be verified
+	VerifyErr error
+}
+
+// FetchTLSInfo connects to the server of baseURL and returns the negotiated
+// protocol version and cipher suite together with the certificate chain of
+// the server. The chain is verified against rootCAs, or the system roots if
+// rootCAs is nil, but the connection is established in any case.
+func FetchTLSInfo(baseURL url.URL, rootCAs *x509.CertPool, timeout time.Duration) (*TLSInfo, error) {
+	if baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("expected a base URL with scheme https but was `%s`", baseURL.Scheme)
+	}
+	host := baseURL.Hostname()
+	port := baseURL.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	info := &TLSInfo{
+		Version:     state.Version,
+		CipherSuite: state.CipherSuite,
+		ALPN:        state.NegotiatedProtocol,
+		Chain:       state.PeerCertificates,
+	}
+	if len(state.PeerCertificates) > 0 {
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, info.VerifyErr = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       host,
+			Roots:         rootCAs,
+			Intermediates: intermediates,
+		})
+	}
+	return info, nil
+}
+
+// String formats the TLSInfo using FmtCertificateChain for the chain.
+func (info *TLSInfo) String() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Protocol    : %s\n", tls.VersionName(info.Version)))
+	builder.WriteString(fmt.Sprintf("Cipher      : %s\n", tls.CipherSuiteName(info.CipherSuite)))
+	if info.ALPN != "" {
+		builder.WriteString(fmt.Sprintf("ALPN        : %s\n", info.ALPN))
+	}
+	if info.VerifyErr != nil {
+		builder.WriteString(fmt.Sprintf("Verification: failed: %v\n", info.VerifyErr))
+	} else {
+		builder.WriteString("Verification: ok\n")
+	}
+	builder.WriteString("\n")
+	builder.WriteString(FmtCertificateChain(info.Chain, time.Now()))
+	return builder.String()
+}
+
+// tlsVersions are the TLS versions which can be given as minimum version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version like 1.2.
+func ParseTLSVersion(s string) (uint16, error) {
+	if version, ok := tlsVersions[s]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("invalid TLS version `%s`, expected one of 1.0, 1.1, 1.2 or 1.3", s)
+}
+
+// ParseCipherSuites parses the IANA names of cipher suites, like
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Cipher suites with security issues,
+// which are disabled by default, are accepted as well.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite `%s`", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// tlsConfig returns the TLS config of the transport of the client. Has to be
+// called before the transport is wrapped by recording, replaying or tracing.
+func (c *Client) tlsConfig() *tls.Config {
+	t := c.httpClient.Transport.(*http.Transport)
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	return t.TLSClientConfig
+}
+
+// SetTLSVersionAndCiphers sets the minimum TLS version and the cipher suites
+// offered for TLS 1.2 and below. A zero version and empty cipher suites keep
+// the defaults of Go. The cipher suites of TLS 1.3 can't be changed.
+func (c *Client) SetTLSVersionAndCiphers(minVersion uint16, cipherSuites []uint16) {
+	config := c.tlsConfig()
+	if minVersion != 0 {
+		config.MinVersion = minVersion
+	}
+	if len(cipherSuites) > 0 {
+		config.CipherSuites = cipherSuites
+	}
+}
+
+// isCertFile returns whether the file name has one of the usual extensions of
+// PEM encoded certificates.
+func isCertFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pem", ".crt", ".cer":
+		return true
+	}
+	return false
+}
+
+// ReadCertPool reads the PEM encoded certificates of the file at path or, if
+// path is a directory, of all .pem, .crt and .cer files in it. With system,
+// the certificates are added to the system roots instead of a new pool.
+func ReadCertPool(path string, system bool) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if system {
+		var err error
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("error while loading the system roots: %w", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() && isCertFile(entry.Name()) {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	var found bool
+	for _, file := range files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if pool.AppendCertsFromPEM(pem) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("found no PEM encoded certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// SetRootCAs sets the certificates the certificate of the server is verified
+// against.
+func (c *Client) SetRootCAs(pool *x509.CertPool) {
+	c.tlsConfig().RootCAs = pool
+}
+
+// publicKeyPin returns the base64 encoded SHA-256 hash of the public key of
+// cert, as used by --pin-sha256.
+func publicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// A CertificatePin is the SHA-256 hash of either the public key or the whole
+// certificate of a server.
+type CertificatePin struct {
+	hash      []byte
+	publicKey bool
+}
+
+// ParseCertificatePin parses the base64 encoded SHA-256 hash of the
+// SubjectPublicKeyInfo of a certificate, optionally prefixed with sha256/,
+// or the hex encoded SHA-256 fingerprint of a certificate, optionally with
+// colons, like the one shown by openssl x509 -fingerprint -sha256.
+func ParseCertificatePin(s string) (CertificatePin, error) {
+	if hash, err := hex.DecodeString(strings.ReplaceAll(s, ":", "")); err == nil && len(hash) == sha256.Size {
+		return CertificatePin{hash: hash}, nil
+	}
+	if hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "sha256/")); err == nil && len(hash) == sha256.Size {
+		return CertificatePin{hash: hash, publicKey: true}, nil
+	}
+	return CertificatePin{}, fmt.Errorf("invalid pin `%s`, expected the base64 encoded SHA-256 hash of a public key or the hex encoded SHA-256 fingerprint of a certificate", s)
+}
+
+func (pin CertificatePin) matches(cert *x509.Certificate) bool {
+	var hash [sha256.Size]byte
+	if pin.publicKey {
+		hash = sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	} else {
+		hash = sha256.Sum256(cert.Raw)
+	}
+	return bytes.Equal(pin.hash, hash[:])
+}
+
+// SetCertificatePins only accepts connections to servers with a certificate
+// chain which contains a certificate matching one of pins. The pins are
+// checked in addition to the verification of the chain, also if that
+// verification is disabled. A mismatch is reported as TLSCertificateError.
+func (c *Client) SetCertificatePins(pins []CertificatePin) {
+	if len(pins) == 0 {
+		return
+	}
+	c.tlsConfig().VerifyConnection = func(state tls.ConnectionState) error {
+		for _, cert := range state.PeerCertificates {
+			for _, pin := range pins {
+				if pin.matches(cert) {
+					return nil
+				}
+			}
+		}
+		return &tls.CertificateVerificationError{
+			UnverifiedCertificates: state.PeerCertificates,
+			Err:                    errors.New("no certificate of the server matches a pinned hash"),
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFmtCertificateChain(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "blaze.example.com"},
+		Issuer:      pkix.Name{CommonName: "Example CA"},
+		DNSNames:    []string{"blaze.example.com", "example.com"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:    time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	assert.Equal(t, `Certificate 0
+  Subject     : CN=blaze.example.com
+  Issuer      : CN=Example CA
+  DNS Names   : blaze.example.com, example.com
+  IP Addresses: 127.0.0.1
+  Not Before  : 2024-01-01T00:00:00Z
+  Not After   : 2024-07-01T00:00:00Z (expires in 30 days)
+`, FmtCertificateChain([]*x509.Certificate{cert}, now))
+
+	t.Run("expired", func(t *testing.T) {
+		assert.Equal(t, "expired 3 days ago", fmtExpiry(now.Add(-72*time.Hour), now))
+	})
+}
+
+func TestTLSCertificateError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	client := NewClient(*baseURL, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	_, err := client.Do(req)
+
+	var certErr *TLSCertificateError
+	if assert.True(t, errors.As(err, &certErr)) {
+		assert.Len(t, certErr.Chain(), 1)
+		assert.Contains(t, err.Error(), "certificate signed by unknown authority")
+		assert.Contains(t, err.Error(), "DNS Names   : example.com")
+	}
+}
+
+func TestFetchTLSInfo(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	baseURL, _ := url.ParseRequestURI(server.URL)
+
+	t.Run("unknown authority", func(t *testing.T) {
+		info, err := FetchTLSInfo(*baseURL, nil, time.Second)
+
+		if assert.NoError(t, err) {
+			assert.Len(t, info.Chain, 1)
+			assert.Error(t, info.VerifyErr)
+			assert.Contains(t, info.String(), "Verification: failed")
+		}
+	})
+
+	t.Run("trusted", func(t *testing.T) {
+		roots := x509.NewCertPool()
+		roots.AddCert(server.Certificate())
+
+		info, err := FetchTLSInfo(*baseURL, roots, time.Second)
+
+		if assert.NoError(t, err) {
+			assert.NoError(t, info.VerifyErr)
+			assert.Contains(t, info.String(), "Protocol    : TLS 1.3\n")
+			assert.Contains(t, info.String(), "Verification: ok\n")
+		}
+	})
+
+	t.Run("plain HTTP", func(t *testing.T) {
+		_, err := FetchTLSInfo(url.URL{Scheme: "http", Host: "localhost"}, nil, time.Second)
+
+		assert.EqualError(t, err, "expected a base URL with scheme https but was `http`")
+	})
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	version, err := ParseTLSVersion("1.3")
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(tls.VersionTLS13), version)
+	}
+
+	_, err = ParseTLSVersion("1.4")
+
+	assert.EqualError(t, err, "invalid TLS version `1.4`, expected one of 1.0, 1.1, 1.2 or 1.3")
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_128_CBC_SHA"})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA}, suites)
+	}
+
+	_, err = ParseCipherSuites([]string{"foo"})
+
+	assert.EqualError(t, err, "unknown cipher suite `foo`")
+}
+
+func TestSetTLSVersionAndCiphers(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	baseURL, _ := url.ParseRequestURI(server.URL)
+
+	t.Run("TLS 1.3 only", func(t *testing.T) {
+		client := NewClientInsecure(*baseURL, nil)
+		client.SetTLSVersionAndCiphers(tls.VersionTLS13, nil)
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		_, err := client.Do(req)
+
+		assert.ErrorContains(t, err, "protocol version")
+	})
+
+	t.Run("cipher suite", func(t *testing.T) {
+		client := NewClientInsecure(*baseURL, nil)
+		client.SetTLSVersionAndCiphers(0, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384})
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, uint16(tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384), resp.TLS.CipherSuite)
+		}
+	})
+}
+
+func writeCertificate(t *testing.T, dir string, name string, cert *x509.Certificate) {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal("can't create a temp certificate file")
+	}
+}
+
+func TestReadCertPool(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCertificate(t, dir, "server.crt", server.Certificate())
+		if err := os.WriteFile(filepath.Join(dir, "README.txt"), []byte("foo"), 0644); err != nil {
+			t.Fatal("can't create a temp file")
+		}
+
+		pool, err := ReadCertPool(dir, false)
+		if err != nil {
+			t.Fatalf("error while reading the certificates: %v", err)
+		}
+		client := NewClient(*baseURL, nil)
+		client.SetRootCAs(pool)
+
+		resp, err := client.Do(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	})
+
+	t.Run("with system roots", func(t *testing.T) {
+		if _, err := x509.SystemCertPool(); err != nil {
+			t.Skip("no system roots available")
+		}
+		dir := t.TempDir()
+		writeCertificate(t, dir, "server.pem", server.Certificate())
+
+		pool, err := ReadCertPool(filepath.Join(dir, "server.pem"), true)
+		if err != nil {
+			t.Fatalf("error while reading the certificates: %v", err)
+		}
+		client := NewClient(*baseURL, nil)
+		client.SetRootCAs(pool)
+
+		resp, err := client.Do(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	})
+
+	t.Run("without certificates", func(t *testing.T) {
+		dir := t.TempDir()
+
+		_, err := ReadCertPool(dir, false)
+
+		assert.EqualError(t, err, "found no PEM encoded certificates in "+dir)
+	})
+}
+
+func TestParseCertificatePin(t *testing.T) {
+	t.Run("public key", func(t *testing.T) {
+		pin, err := ParseCertificatePin("sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+
+		if assert.NoError(t, err) {
+			assert.True(t, pin.publicKey)
+			assert.True(t, pin.matches(&x509.Certificate{}))
+		}
+	})
+
+	t.Run("fingerprint", func(t *testing.T) {
+		pin, err := ParseCertificatePin("E3:B0:C4:42:98:FC:1C:14:9A:FB:F4:C8:99:6F:B9:24:27:AE:41:E4:64:9B:93:4C:A4:95:99:1B:78:52:B8:55")
+
+		if assert.NoError(t, err) {
+			assert.False(t, pin.publicKey)
+			assert.True(t, pin.matches(&x509.Certificate{}))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ParseCertificatePin("foo")
+
+		assert.EqualError(t, err, "invalid pin `foo`, expected the base64 encoded SHA-256 hash of a public key or the hex encoded SHA-256 fingerprint of a certificate")
+	})
+}
+
+func TestSetCertificatePins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	baseURL, _ := url.ParseRequestURI(server.URL)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	t.Run("matching public key", func(t *testing.T) {
+		pin, _ := ParseCertificatePin(publicKeyPin(server.Certificate()))
+		client := NewClientInsecure(*baseURL, nil)
+		client.SetCertificatePins([]CertificatePin{pin})
+
+		resp, err := client.Do(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		pin, _ := ParseCertificatePin("sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+		client := NewClientInsecure(*baseURL, nil)
+		client.SetCertificatePins([]CertificatePin{pin})
+
+		_, err := client.Do(req)
+
+		var certErr *TLSCertificateError
+		if assert.True(t, errors.As(err, &certErr)) {
+			assert.Contains(t, err.Error(), "no certificate of the server matches a pinned hash")
+			assert.Contains(t, err.Error(), "Pin SHA-256 : "+publicKeyPin(server.Certificate()))
+		}
+	})
+}
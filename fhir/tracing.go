@@ -0,0 +1,210 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const tracerName = "github.com/samply/blazectl/fhir"
+
+// Span attributes describing the FHIR interaction of a request.
+const (
+	ResourceTypeKey = attribute.Key("fhir.resource_type")
+	InteractionKey  = attribute.Key("fhir.interaction")
+)
+
+// EnableTracing makes the client emit a span for each request using a tracer
+// of tracerProvider. Spans carry the FHIR resource type and interaction, the
+// HTTP status and the number of bytes sent and received. A span ends when the
+// body of its response is closed.
+func (c *Client) EnableTracing(tracerProvider trace.TracerProvider) {
+	next := c.httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.httpClient.Transport = &tracingTransport{
+		next:    next,
+		tracer:  tracerProvider.Tracer(tracerName),
+		baseURL: c.baseURL,
+	}
+}
+
+type tracingTransport struct {
+	next    http.RoundTripper
+	tracer  trace.Tracer
+	baseURL url.URL
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resourceType, interaction := classifyRequest(t.baseURL, req)
+	name := req.Method
+	if interaction != "" {
+		name = interaction
+		if resourceType != "" {
+			name += " " + resourceType
+		}
+	}
+
+	ctx, span := t.tracer.Start(req.Context(), name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(req.Method), semconv.URLFull(req.URL.String())))
+	if resourceType != "" {
+		span.SetAttributes(ResourceTypeKey.String(resourceType))
+	}
+	if interaction != "" {
+		span.SetAttributes(InteractionKey.String(interaction))
+	}
+
+	req = req.Clone(ctx)
+	var requestBody *countingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		requestBody = &countingBody{ReadCloser: req.Body}
+		req.Body = requestBody
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if requestBody != nil {
+		span.SetAttributes(semconv.HTTPRequestBodySize(int(requestBody.count.Load())))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	if requestId := resp.Header.Get(RequestIdHeader); requestId != "" {
+		span.SetAttributes(attribute.String("http.response.header.x-request-id", requestId))
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, onClose: func(count int64) {
+		span.SetAttributes(semconv.HTTPResponseBodySize(int(count)))
+		span.End()
+	}}
+	return resp, nil
+}
+
+// countingBody counts the bytes read from a body and calls onClose, if set,
+// with that count the first time the body is closed.
+type countingBody struct {
+	io.ReadCloser
+	count   atomic.Int64
+	onClose func(count int64)
+	once    sync.Once
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count.Add(int64(n))
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.onClose != nil {
+		b.once.Do(func() { b.onClose(b.count.Load()) })
+	}
+	return err
+}
+
+// classifyRequest returns the resource type and the FHIR interaction of req
+// relative to baseURL. Both are empty if the request doesn't target the FHIR
+// API under baseURL, like requests to async status endpoints.
+func classifyRequest(baseURL url.URL, req *http.Request) (resourceType string, interaction string) {
+	basePath := strings.TrimSuffix(baseURL.Path, "/")
+	path, found := strings.CutPrefix(req.URL.Path, basePath)
+	if req.URL.Host != baseURL.Host || !found || (path != "" && !strings.HasPrefix(path, "/")) {
+		return "", ""
+	}
+	path = strings.Trim(path, "/")
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+	if len(segments) > 0 && strings.HasPrefix(segments[0], "__") {
+		return "", ""
+	}
+	if len(segments) > 0 && strings.HasPrefix(segments[len(segments)-1], "$") {
+		if !strings.HasPrefix(segments[0], "$") {
+			resourceType = segments[0]
+		}
+		return resourceType, "operation"
+	}
+
+	switch len(segments) {
+	case 0:
+		switch req.Method {
+		case http.MethodPost:
+			return "", "transaction"
+		case http.MethodGet:
+			return "", "search-system"
+		}
+	case 1:
+		if segments[0] == "metadata" {
+			return "", "capabilities"
+		}
+		if segments[0] == "_history" {
+			return "", "history-system"
+		}
+		switch req.Method {
+		case http.MethodGet:
+			return segments[0], "search-type"
+		case http.MethodPost:
+			return segments[0], "create"
+		case http.MethodPut:
+			return segments[0], "conditional-update"
+		case http.MethodDelete:
+			return segments[0], "conditional-delete"
+		}
+	case 2:
+		if segments[1] == "_search" {
+			return segments[0], "search-type"
+		}
+		if segments[1] == "_history" {
+			return segments[0], "history-type"
+		}
+		switch req.Method {
+		case http.MethodGet:
+			return segments[0], "read"
+		case http.MethodPut:
+			return segments[0], "update"
+		case http.MethodPatch:
+			return segments[0], "patch"
+		case http.MethodDelete:
+			return segments[0], "delete"
+		}
+	case 3:
+		if segments[2] == "_history" {
+			return segments[0], "history-instance"
+		}
+	case 4:
+		if segments[2] == "_history" {
+			return segments[0], "vread"
+		}
+	}
+	return "", ""
+}
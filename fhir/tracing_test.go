@@ -0,0 +1,110 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fhir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEnableTracing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("X-Request-Id", "id-093512")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"resourceType": "Patient"}`))
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	baseURL, _ := url.ParseRequestURI(server.URL + "/fhir")
+	client := NewClient(*baseURL, nil)
+	client.EnableTracing(tracerProvider)
+
+	req, _ := client.NewCreateRequest("Patient", strings.NewReader(`{"resourceType": "Patient"}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, recorder.Ended())
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "create Patient", spans[0].Name())
+		attributes := attribute.NewSet(spans[0].Attributes()...)
+		value, _ := attributes.Value(ResourceTypeKey)
+		assert.Equal(t, "Patient", value.AsString())
+		value, _ = attributes.Value(InteractionKey)
+		assert.Equal(t, "create", value.AsString())
+		value, _ = attributes.Value("http.response.status_code")
+		assert.Equal(t, int64(201), value.AsInt64())
+		value, _ = attributes.Value("http.request.body.size")
+		assert.Equal(t, int64(27), value.AsInt64())
+		value, _ = attributes.Value("http.response.body.size")
+		assert.Equal(t, int64(27), value.AsInt64())
+		value, _ = attributes.Value("http.response.header.x-request-id")
+		assert.Equal(t, "id-093512", value.AsString())
+	}
+}
+
+func TestClassifyRequest(t *testing.T) {
+	baseURL, _ := url.ParseRequestURI("http://localhost:8080/fhir")
+
+	tests := []struct {
+		method       string
+		url          string
+		resourceType string
+		interaction  string
+	}{
+		{http.MethodGet, "http://localhost:8080/fhir/metadata", "", "capabilities"},
+		{http.MethodPost, "http://localhost:8080/fhir", "", "transaction"},
+		{http.MethodGet, "http://localhost:8080/fhir?_type=Patient", "", "search-system"},
+		{http.MethodGet, "http://localhost:8080/fhir/Patient?_count=10", "Patient", "search-type"},
+		{http.MethodPost, "http://localhost:8080/fhir/Patient/_search", "Patient", "search-type"},
+		{http.MethodPost, "http://localhost:8080/fhir/Patient", "Patient", "create"},
+		{http.MethodGet, "http://localhost:8080/fhir/Patient/0", "Patient", "read"},
+		{http.MethodPut, "http://localhost:8080/fhir/Patient/0", "Patient", "update"},
+		{http.MethodDelete, "http://localhost:8080/fhir/Patient/0", "Patient", "delete"},
+		{http.MethodGet, "http://localhost:8080/fhir/Patient/0/_history", "Patient", "history-instance"},
+		{http.MethodGet, "http://localhost:8080/fhir/Patient/0/_history/1", "Patient", "vread"},
+		{http.MethodGet, "http://localhost:8080/fhir/Measure/$evaluate-measure", "Measure", "operation"},
+		{http.MethodPost, "http://localhost:8080/fhir/$compact", "", "operation"},
+		{http.MethodGet, "http://localhost:8080/fhir/__async-status/0", "", ""},
+		{http.MethodGet, "http://localhost:8080/fhirx/Patient", "", ""},
+		{http.MethodGet, "http://example.com/fhir/Patient", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.url, func(t *testing.T) {
+			req, _ := http.NewRequest(test.method, test.url, nil)
+
+			resourceType, interaction := classifyRequest(*baseURL, req)
+
+			assert.Equal(t, test.resourceType, resourceType)
+			assert.Equal(t, test.interaction, interaction)
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package update finds, verifies and installs releases of blazectl published
+// on GitHub.
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LatestReleaseURL is the GitHub API endpoint of the latest release of
+// blazectl.
+const LatestReleaseURL = "https://api.github.com/repos/samply/blazectl/releases/latest"
+
+// PublicKey is the base64 encoded Ed25519 public key the checksums of
+// releases are signed with. It's set at build time by build-releases.sh with
+// -ldflags "-X github.com/samply/blazectl/update.PublicKey=...". Builds
+// without key can't update themselves.
+var PublicKey string
+
+type Asset struct {
+	Name        string `json:"name"`
+	DownloadURL string `json:"browser_download_url"`
+}
+
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Version returns the version of the release without the leading v of the
+// tag name.
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.TagName, "v")
+}
+
+// Asset returns the asset with name.
+func (r *Release) Asset(name string) (*Asset, bool) {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i], true
+		}
+	}
+	return nil, false
+}
+
+// FetchLatestRelease fetches the latest release from url which is normally
+// LatestReleaseURL.
+func FetchLatestRelease(client *http.Client, url string) (*Release, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-OK status while fetching the latest release: %s", resp.Status)
+	}
+	var release Release
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("error while reading the latest release: %v", err)
+	}
+	return &release, nil
+}
+
+// IsNewer returns true if the dot separated version latest is greater than
+// current. Non-numeric parts are compared as zero.
+func IsNewer(current, latest string) bool {
+	c := strings.Split(current, ".")
+	l := strings.Split(latest, ".")
+	for i := 0; i < len(c) || i < len(l); i++ {
+		cn, ln := versionPart(c, i), versionPart(l, i)
+		if ln != cn {
+			return ln > cn
+		}
+	}
+	return false
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[i])
+	return n
+}
+
+// ArchiveName returns the name of the release archive for the platform given
+// by goos and goarch as built by build-releases.sh.
+func ArchiveName(version, goos, goarch string) string {
+	if goos == "windows" {
+		return fmt.Sprintf("blazectl-%s-%s-%s.zip", version, goos, goarch)
+	}
+	return fmt.Sprintf("blazectl-%s-%s-%s.tar.gz", version, goos, goarch)
+}
+
+// ChecksumsName returns the name of the file containing the SHA-256 checksums
+// of all archives of a release.
+func ChecksumsName(version string) string {
+	return fmt.Sprintf("blazectl-%s-checksums.txt", version)
+}
+
+// SignatureName returns the name of the file containing the base64 encoded
+// Ed25519 signature of the checksums file of a release.
+func SignatureName(version string) string {
+	return ChecksumsName(version) + ".sig"
+}
+
+// ParsePublicKey parses the base64 encoded Ed25519 public key s.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	if s == "" {
+		return nil, errors.New("this build of blazectl has no key to verify releases, please update manually")
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid key to verify releases, please update manually")
+	}
+	return key, nil
+}
+
+// VerifySignature verifies that signature is a valid base64 encoded Ed25519
+// signature of checksums by publicKey.
+func VerifySignature(checksums []byte, signature []byte, publicKey ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || !ed25519.Verify(publicKey, checksums, sig) {
+		return errors.New("the signature of the checksums is invalid")
+	}
+	return nil
+}
+
+// Download returns the content at url.
+func Download(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-OK status while downloading %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// VerifyChecksum verifies that the SHA-256 checksum of the archive with name
+// matches the one listed in checksums which has the format of sha256sum.
+func VerifyChecksum(name string, archive []byte, checksums []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := sha256.Sum256(archive)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(fields[0]) {
+			return fmt.Errorf("the checksum of %s doesn't match", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("missing checksum of %s", name)
+}
+
+// ExtractBinary returns the blazectl binary contained in the archive with
+// name.
+func ExtractBinary(name string, archive []byte) ([]byte, error) {
+	if strings.HasSuffix(name, ".zip") {
+		return extractZip(archive)
+	}
+	return extractTarGz(archive)
+}
+
+func extractTarGz(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("missing blazectl binary in archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Base(header.Name) == "blazectl" {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+func extractZip(archive []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range zr.File {
+		if filepath.Base(file.Name) == "blazectl.exe" {
+			r, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		}
+	}
+	return nil, errors.New("missing blazectl.exe binary in archive")
+}
+
+// ReplaceExecutable replaces the file at path with binary. The new binary is
+// written next to path first, so that a failed write leaves the old binary
+// intact. The old binary is renamed before, because Windows doesn't allow to
+// overwrite a running executable.
+func ReplaceExecutable(path string, binary []byte) error {
+	newPath := path + ".new"
+	oldPath := path + ".old"
+	if err := os.WriteFile(newPath, binary, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(path, oldPath); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+	if err := os.Rename(newPath, path); err != nil {
+		_ = os.Rename(oldPath, path)
+		os.Remove(newPath)
+		return err
+	}
+	// removing fails on Windows as long as the old binary is running
+	_ = os.Remove(oldPath)
+	return nil
+}
+
+// Install downloads the archive of release for the platform given by goos and
+// goarch, verifies the signature of the checksums with publicKey and the
+// checksum of the archive and replaces the executable at path with the
+// contained binary.
+func Install(client *http.Client, release *Release, publicKey ed25519.PublicKey, goos, goarch, path string) error {
+	archiveName := ArchiveName(release.Version(), goos, goarch)
+	archiveAsset, ok := release.Asset(archiveName)
+	if !ok {
+		return fmt.Errorf("release %s has no archive %s", release.TagName, archiveName)
+	}
+	checksumsAsset, ok := release.Asset(ChecksumsName(release.Version()))
+	if !ok {
+		return fmt.Errorf("release %s has no checksums, please update manually", release.TagName)
+	}
+	signatureAsset, ok := release.Asset(SignatureName(release.Version()))
+	if !ok {
+		return fmt.Errorf("release %s has no signature, please update manually", release.TagName)
+	}
+
+	checksums, err := Download(client, checksumsAsset.DownloadURL)
+	if err != nil {
+		return err
+	}
+	signature, err := Download(client, signatureAsset.DownloadURL)
+	if err != nil {
+		return err
+	}
+	if err := VerifySignature(checksums, signature, publicKey); err != nil {
+		return err
+	}
+	archive, err := Download(client, archiveAsset.DownloadURL)
+	if err != nil {
+		return err
+	}
+	if err := VerifyChecksum(archiveName, archive, checksums); err != nil {
+		return err
+	}
+	binary, err := ExtractBinary(archiveName, archive)
+	if err != nil {
+		return err
+	}
+	return ReplaceExecutable(path, binary)
+}
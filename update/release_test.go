@@ -0,0 +1,281 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tarGz(t *testing.T, name string, content []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func checksumLine(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+}
+
+// signingKey is the key the checksums of the test releases are signed with.
+var signingKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+
+func sign(content []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, content))
+}
+
+func TestIsNewer(t *testing.T) {
+	assert.True(t, IsNewer("0.17.0", "0.18.0"))
+	assert.True(t, IsNewer("0.17.0", "0.17.1"))
+	assert.True(t, IsNewer("0.9.0", "0.10.0"))
+	assert.True(t, IsNewer("0.17", "0.17.1"))
+	assert.False(t, IsNewer("0.17.0", "0.17.0"))
+	assert.False(t, IsNewer("0.17.0", "0.16.5"))
+	assert.False(t, IsNewer("1.0.0", "0.99.0"))
+}
+
+func TestArchiveName(t *testing.T) {
+	assert.Equal(t, "blazectl-0.18.0-linux-amd64.tar.gz", ArchiveName("0.18.0", "linux", "amd64"))
+	assert.Equal(t, "blazectl-0.18.0-windows-amd64.zip", ArchiveName("0.18.0", "windows", "amd64"))
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	archive := []byte("archive")
+	checksums := []byte(checksumLine("a.tar.gz", []byte("other")) + checksumLine("b.tar.gz", archive))
+
+	t.Run("match", func(t *testing.T) {
+		assert.NoError(t, VerifyChecksum("b.tar.gz", archive, checksums))
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		assert.EqualError(t, VerifyChecksum("a.tar.gz", archive, checksums), "the checksum of a.tar.gz doesn't match")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		assert.EqualError(t, VerifyChecksum("c.tar.gz", archive, checksums), "missing checksum of c.tar.gz")
+	})
+}
+
+func TestParsePublicKey(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(signingKey.Public().(ed25519.PublicKey)))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, signingKey.Public(), key)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := ParsePublicKey("")
+
+		assert.EqualError(t, err, "this build of blazectl has no key to verify releases, please update manually")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ParsePublicKey("Zm9v")
+
+		assert.EqualError(t, err, "invalid key to verify releases, please update manually")
+	})
+}
+
+func TestVerifySignature(t *testing.T) {
+	checksums := []byte(checksumLine("a.tar.gz", []byte("archive")))
+	publicKey := signingKey.Public().(ed25519.PublicKey)
+
+	t.Run("valid", func(t *testing.T) {
+		assert.NoError(t, VerifySignature(checksums, []byte(sign(checksums)+"\n"), publicKey))
+	})
+
+	t.Run("tampered checksums", func(t *testing.T) {
+		tampered := []byte(checksumLine("a.tar.gz", []byte("tampered")))
+
+		assert.EqualError(t, VerifySignature(tampered, []byte(sign(checksums)), publicKey), "the signature of the checksums is invalid")
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+
+		assert.EqualError(t, VerifySignature(checksums, []byte(sign(checksums)), otherKey), "the signature of the checksums is invalid")
+	})
+
+	t.Run("no base64", func(t *testing.T) {
+		assert.EqualError(t, VerifySignature(checksums, []byte("%"), publicKey), "the signature of the checksums is invalid")
+	})
+}
+
+func TestExtractBinary(t *testing.T) {
+	t.Run("tar.gz", func(t *testing.T) {
+		binary, err := ExtractBinary("blazectl-0.18.0-linux-amd64.tar.gz", tarGz(t, "blazectl", []byte("binary")))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("binary"), binary)
+		}
+	})
+
+	t.Run("tar.gz without binary", func(t *testing.T) {
+		_, err := ExtractBinary("blazectl-0.18.0-linux-amd64.tar.gz", tarGz(t, "README.md", []byte("readme")))
+		assert.EqualError(t, err, "missing blazectl binary in archive")
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, _ := zw.Create("blazectl.exe")
+		_, _ = w.Write([]byte("binary"))
+		assert.NoError(t, zw.Close())
+
+		binary, err := ExtractBinary("blazectl-0.18.0-windows-amd64.zip", buf.Bytes())
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("binary"), binary)
+		}
+	})
+}
+
+func TestInstall(t *testing.T) {
+	archiveName := "blazectl-0.18.0-linux-amd64.tar.gz"
+	archive := tarGz(t, "blazectl", []byte("new binary"))
+
+	newRelease := func(serverURL string) *Release {
+		return &Release{
+			TagName: "v0.18.0",
+			Assets: []Asset{
+				{Name: archiveName, DownloadURL: serverURL + "/archive"},
+				{Name: "blazectl-0.18.0-checksums.txt", DownloadURL: serverURL + "/checksums"},
+				{Name: "blazectl-0.18.0-checksums.txt.sig", DownloadURL: serverURL + "/signature"},
+			},
+		}
+	}
+
+	publicKey := signingKey.Public().(ed25519.PublicKey)
+	checksums := []byte(checksumLine(archiveName, archive))
+
+	t.Run("success", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/archive":
+				_, _ = w.Write(archive)
+			case "/checksums":
+				_, _ = w.Write(checksums)
+			case "/signature":
+				_, _ = w.Write([]byte(sign(checksums)))
+			}
+		}))
+		defer server.Close()
+
+		path := filepath.Join(t.TempDir(), "blazectl")
+		assert.NoError(t, os.WriteFile(path, []byte("old binary"), 0755))
+
+		if assert.NoError(t, Install(server.Client(), newRelease(server.URL), publicKey, "linux", "amd64", path)) {
+			content, _ := os.ReadFile(path)
+			assert.Equal(t, "new binary", string(content))
+			_, err := os.Stat(path + ".old")
+			assert.True(t, os.IsNotExist(err))
+		}
+	})
+
+	t.Run("checksum mismatch keeps the old binary", func(t *testing.T) {
+		tampered := []byte(checksumLine(archiveName, []byte("tampered")))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/archive":
+				_, _ = w.Write(archive)
+			case "/checksums":
+				_, _ = w.Write(tampered)
+			case "/signature":
+				_, _ = w.Write([]byte(sign(tampered)))
+			}
+		}))
+		defer server.Close()
+
+		path := filepath.Join(t.TempDir(), "blazectl")
+		assert.NoError(t, os.WriteFile(path, []byte("old binary"), 0755))
+
+		err := Install(server.Client(), newRelease(server.URL), publicKey, "linux", "amd64", path)
+		assert.EqualError(t, err, "the checksum of blazectl-0.18.0-linux-amd64.tar.gz doesn't match")
+		content, _ := os.ReadFile(path)
+		assert.Equal(t, "old binary", string(content))
+	})
+
+	t.Run("checksums signed by another key keep the old binary", func(t *testing.T) {
+		otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/archive":
+				_, _ = w.Write(archive)
+			case "/checksums":
+				_, _ = w.Write(checksums)
+			case "/signature":
+				_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, checksums))))
+			}
+		}))
+		defer server.Close()
+
+		path := filepath.Join(t.TempDir(), "blazectl")
+		assert.NoError(t, os.WriteFile(path, []byte("old binary"), 0755))
+
+		err := Install(server.Client(), newRelease(server.URL), publicKey, "linux", "amd64", path)
+		assert.EqualError(t, err, "the signature of the checksums is invalid")
+		content, _ := os.ReadFile(path)
+		assert.Equal(t, "old binary", string(content))
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		release := newRelease("http://localhost")
+		release.Assets = release.Assets[:2]
+
+		err := Install(http.DefaultClient, release, publicKey, "linux", "amd64", "blazectl")
+		assert.EqualError(t, err, "release v0.18.0 has no signature, please update manually")
+	})
+
+	t.Run("missing archive for platform", func(t *testing.T) {
+		err := Install(http.DefaultClient, newRelease("http://localhost"), publicKey, "plan9", "386", "blazectl")
+		assert.EqualError(t, err, "release v0.18.0 has no archive blazectl-0.18.0-plan9-386.tar.gz")
+	})
+}
+
+func TestFetchLatestRelease(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/vnd.github+json", r.Header.Get("Accept"))
+		_, _ = w.Write([]byte(`{"tag_name": "v0.18.0", "assets": [{"name": "a", "browser_download_url": "http://a"}]}`))
+	}))
+	defer server.Close()
+
+	release, err := FetchLatestRelease(server.Client(), server.URL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "0.18.0", release.Version())
+		asset, ok := release.Asset("a")
+		assert.True(t, ok)
+		assert.Equal(t, "http://a", asset.DownloadURL)
+	}
+}
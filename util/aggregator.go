@@ -0,0 +1,60 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "sync"
+
+// Aggregator folds a stream of results of type R into statistics of type S.
+// All methods are safe for concurrent use, so that results can be added by
+// several goroutines while interim statistics are taken, for example on
+// interrupt.
+type Aggregator[R any, S any] struct {
+	mutex sync.Mutex
+	stats *S
+	add   func(stats *S, result R)
+	clone func(stats *S) S
+	final bool
+}
+
+// NewAggregator creates an aggregator which adds results to stats using add.
+// Snapshots are taken with clone, which has to copy everything add modifies
+// later, like slices and maps.
+func NewAggregator[R any, S any](stats *S, add func(stats *S, result R), clone func(stats *S) S) *Aggregator[R, S] {
+	return &Aggregator[R, S]{stats: stats, add: add, clone: clone}
+}
+
+// Add adds result to the statistics. Results added after Final are ignored.
+func (a *Aggregator[R, S]) Add(result R) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !a.final {
+		a.add(a.stats, result)
+	}
+}
+
+// Snapshot returns a copy of the current statistics.
+func (a *Aggregator[R, S]) Snapshot() S {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.clone(a.stats)
+}
+
+// Final returns the statistics and ignores all results added afterwards.
+func (a *Aggregator[R, S]) Final() S {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.final = true
+	return *a.stats
+}
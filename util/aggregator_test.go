@@ -0,0 +1,61 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+type testStats struct {
+	count  int
+	values []int
+}
+
+func newTestAggregator() *Aggregator[int, testStats] {
+	return NewAggregator(&testStats{}, func(stats *testStats, value int) {
+		stats.count++
+		stats.values = append(stats.values, value)
+	}, func(stats *testStats) testStats {
+		return testStats{count: stats.count, values: append([]int(nil), stats.values...)}
+	})
+}
+
+func TestAggregator(t *testing.T) {
+	aggregator := newTestAggregator()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			aggregator.Add(i)
+		}()
+	}
+	wg.Wait()
+
+	snapshot := aggregator.Snapshot()
+	aggregator.Add(10)
+
+	assert.Equal(t, 10, snapshot.count)
+	assert.Len(t, snapshot.values, 10)
+
+	final := aggregator.Final()
+	aggregator.Add(11)
+
+	assert.Equal(t, 11, final.count)
+	assert.Equal(t, 11, aggregator.Snapshot().count)
+}
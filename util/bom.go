@@ -0,0 +1,77 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// utf8BOM is the byte order mark some Windows tools put in front of UTF-8
+// encoded files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// ErrUTF16 is returned for UTF-16 encoded content, which Windows tools like
+// PowerShell produce by default.
+var ErrUTF16 = errors.New("the content is UTF-16 encoded, only UTF-8 is supported, please convert it to UTF-8")
+
+// isUTF16 returns whether content starting with head is UTF-16 encoded,
+// either because of its byte order mark or because one of the first two
+// bytes is zero, which can't happen for JSON encoded in UTF-8.
+func isUTF16(head []byte) bool {
+	if len(head) < 2 {
+		return false
+	}
+	return bytes.HasPrefix(head, []byte{0xFE, 0xFF}) || bytes.HasPrefix(head, []byte{0xFF, 0xFE}) ||
+		(head[0] == 0) != (head[1] == 0)
+}
+
+// DetectBOM returns the length of the UTF-8 byte order mark at the start of r,
+// which is zero without byte order mark, without consuming anything. Returns
+// ErrUTF16 for UTF-16 encoded content.
+func DetectBOM(r *bufio.Reader) (int, error) {
+	head, err := r.Peek(len(utf8BOM))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if isUTF16(head) {
+		return 0, ErrUTF16
+	}
+	if bytes.HasPrefix(head, utf8BOM) {
+		return len(utf8BOM), nil
+	}
+	return 0, nil
+}
+
+// SkipBOM skips the UTF-8 byte order mark at the start of r and returns the
+// number of bytes skipped. Returns ErrUTF16 for UTF-16 encoded content.
+func SkipBOM(r *bufio.Reader) (int, error) {
+	n, err := DetectBOM(r)
+	if err != nil {
+		return 0, err
+	}
+	return r.Discard(n)
+}
+
+// StripBOM returns content without its UTF-8 byte order mark. Returns ErrUTF16
+// for UTF-16 encoded content.
+func StripBOM(content []byte) ([]byte, error) {
+	if isUTF16(content) {
+		return nil, ErrUTF16
+	}
+	return bytes.TrimPrefix(content, utf8BOM), nil
+}
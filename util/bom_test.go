@@ -0,0 +1,82 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestSkipBOM(t *testing.T) {
+	t.Run("UTF-8 BOM", func(t *testing.T) {
+		r := bufio.NewReader(bytes.NewReader([]byte("\xEF\xBB\xBF{\"resourceType\":\"Bundle\"}")))
+
+		n, err := SkipBOM(r)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, 3, n)
+			rest, _ := io.ReadAll(r)
+			assert.Equal(t, "{\"resourceType\":\"Bundle\"}", string(rest))
+		}
+	})
+
+	t.Run("Without BOM", func(t *testing.T) {
+		r := bufio.NewReader(bytes.NewReader([]byte("{}")))
+
+		n, err := SkipBOM(r)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, n)
+			rest, _ := io.ReadAll(r)
+			assert.Equal(t, "{}", string(rest))
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		n, err := SkipBOM(bufio.NewReader(bytes.NewReader(nil)))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("UTF-16", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"LE BOM":   "\xFF\xFE{\x00}\x00",
+			"BE BOM":   "\xFE\xFF\x00{\x00}",
+			"LE":       "{\x00}\x00",
+			"BE":       "\x00{\x00}",
+			"BOM Only": "\xFF\xFE",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := SkipBOM(bufio.NewReader(bytes.NewReader([]byte(content))))
+
+				assert.ErrorIs(t, err, ErrUTF16)
+			})
+		}
+	})
+}
+
+func TestStripBOM(t *testing.T) {
+	content, err := StripBOM([]byte("\xEF\xBB\xBF{}"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "{}", string(content))
+	}
+
+	_, err = StripBOM([]byte("{\x00}\x00"))
+	assert.ErrorIs(t, err, ErrUTF16)
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// digestCompression bounds the number of centroids of a DurationDigest. Higher
+// values give more accurate percentiles at the cost of memory.
+const digestCompression = 100
+
+// digestBufferSize is the number of durations buffered before they are merged
+// into the centroids of a DurationDigest.
+const digestBufferSize = 5 * digestCompression
+
+// centroid is the mean of weight durations.
+type centroid struct {
+	mean, weight float64
+}
+
+// DurationDigest is a t-digest of durations in seconds. It provides the
+// DurationStatistics of an unbounded number of durations with constant
+// memory. Min, max, mean and standard deviation are exact. Percentiles are
+// exact up to digestBufferSize durations and approximated afterwards, with
+// the best accuracy at the tails.
+//
+// The zero value is an empty digest.
+type DurationDigest struct {
+	centroids []centroid // sorted by mean, empty until the first merge
+	buffer    []float64  // durations not merged into the centroids yet
+	count     int
+	min, max  float64
+	mean, m2  float64 // running mean and sum of squared deviations
+}
+
+// NewDurationDigest returns a digest of the given durations in seconds.
+func NewDurationDigest(seconds ...float64) DurationDigest {
+	var d DurationDigest
+	for _, s := range seconds {
+		d.Add(s)
+	}
+	return d
+}
+
+// Add adds a duration in seconds.
+func (d *DurationDigest) Add(seconds float64) {
+	if d.count == 0 || seconds < d.min {
+		d.min = seconds
+	}
+	if d.count == 0 || seconds > d.max {
+		d.max = seconds
+	}
+	d.count++
+	delta := seconds - d.mean
+	d.mean += delta / float64(d.count)
+	d.m2 += delta * (seconds - d.mean)
+
+	d.buffer = append(d.buffer, seconds)
+	if len(d.buffer) >= digestBufferSize {
+		d.centroids = compressCentroids(d.centroids, d.buffer)
+		d.buffer = nil
+	}
+}
+
+// Merge adds all durations of other.
+func (d *DurationDigest) Merge(other *DurationDigest) {
+	if other.count == 0 {
+		return
+	}
+	if d.count == 0 || other.min < d.min {
+		d.min = other.min
+	}
+	if d.count == 0 || other.max > d.max {
+		d.max = other.max
+	}
+	count := float64(d.count + other.count)
+	delta := other.mean - d.mean
+	d.m2 += other.m2 + delta*delta*float64(d.count)*float64(other.count)/count
+	d.mean += delta * float64(other.count) / count
+	d.count += other.count
+
+	if len(other.centroids) == 0 && len(d.buffer)+len(other.buffer) < digestBufferSize {
+		d.buffer = slices.Concat(d.buffer, other.buffer)
+		return
+	}
+	d.centroids = compressCentroids(slices.Concat(d.centroids, other.centroids), slices.Concat(d.buffer, other.buffer))
+	d.buffer = nil
+}
+
+// Count returns the number of durations added.
+func (d *DurationDigest) Count() int {
+	return d.count
+}
+
+// Clone returns a copy of d which doesn't share memory with d.
+func (d *DurationDigest) Clone() DurationDigest {
+	c := *d
+	c.centroids = slices.Clone(d.centroids)
+	c.buffer = slices.Clone(d.buffer)
+	return c
+}
+
+// Statistics returns the DurationStatistics of all durations added. Like
+// CalculateDurationStatistics, the standard deviation is the sample standard
+// deviation.
+func (d *DurationDigest) Statistics() DurationStatistics {
+	if len(d.centroids) == 0 {
+		return CalculateDurationStatistics(slices.Clone(d.buffer))
+	}
+
+	centroids := compressCentroids(d.centroids, d.buffer)
+	var stdDev float64
+	if d.count > 1 {
+		stdDev = math.Sqrt(d.m2 / float64(d.count-1))
+	}
+	return DurationStatistics{
+		Min:    secondsToDuration(d.min),
+		Mean:   secondsToDuration(d.mean),
+		Q50:    secondsToDuration(d.quantile(centroids, 0.50)),
+		Q95:    secondsToDuration(d.quantile(centroids, 0.95)),
+		Q99:    secondsToDuration(d.quantile(centroids, 0.99)),
+		Max:    secondsToDuration(d.max),
+		StdDev: secondsToDuration(stdDev),
+	}
+}
+
+// quantile interpolates the p quantile linearly between the centers of the
+// neighbouring centroids, using the exact min and max at both ends.
+func (d *DurationDigest) quantile(centroids []centroid, p float64) float64 {
+	target := p * float64(d.count)
+	prevCenter, prevMean := 0.0, d.min
+	var cumulated float64
+	for _, c := range centroids {
+		center := cumulated + c.weight/2
+		if target < center {
+			return prevMean + (target-prevCenter)/(center-prevCenter)*(c.mean-prevMean)
+		}
+		prevCenter, prevMean = center, c.mean
+		cumulated += c.weight
+	}
+	return math.Min(prevMean+(target-prevCenter)/(cumulated-prevCenter)*(d.max-prevMean), d.max)
+}
+
+// digestScale is the k1 scale function of the t-digest, which maps the
+// quantile q to an index whose steps of one bound the size of centroids.
+// Centroids are small at the tails and large around the median.
+func digestScale(q float64) float64 {
+	return digestCompression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// digestQuantile is the inverse of digestScale.
+func digestQuantile(k float64) float64 {
+	return (math.Sin(math.Min(k, digestCompression/4)*2*math.Pi/digestCompression) + 1) / 2
+}
+
+// compressCentroids merges centroids and durations into as few centroids as
+// the scale function allows.
+func compressCentroids(centroids []centroid, durations []float64) []centroid {
+	all := make([]centroid, 0, len(centroids)+len(durations))
+	all = append(all, centroids...)
+	for _, duration := range durations {
+		all = append(all, centroid{mean: duration, weight: 1})
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].mean < all[j].mean
+	})
+
+	var total float64
+	for _, c := range all {
+		total += c.weight
+	}
+
+	merged := all[:1]
+	var mergedWeight float64 // weight of all merged centroids except the last
+	limit := total * digestQuantile(digestScale(0)+1)
+	for _, c := range all[1:] {
+		last := &merged[len(merged)-1]
+		if mergedWeight+last.weight+c.weight <= limit {
+			last.weight += c.weight
+			last.mean += (c.mean - last.mean) * c.weight / last.weight
+		} else {
+			mergedWeight += last.weight
+			limit = total * digestQuantile(digestScale(mergedWeight/total)+1)
+			merged = append(merged, c)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestDurationDigest(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		var d DurationDigest
+		assert.Equal(t, 0, d.Count())
+		assert.Equal(t, DurationStatistics{}, d.Statistics())
+	})
+
+	t.Run("Exact Small Sample", func(t *testing.T) {
+		d := NewDurationDigest(4, 1, 3, 2)
+		assert.Equal(t, 4, d.Count())
+		assert.Equal(t, CalculateDurationStatistics([]float64{4, 1, 3, 2}), d.Statistics())
+	})
+
+	t.Run("Large Sample", func(t *testing.T) {
+		var d DurationDigest
+		for _, i := range rand.New(rand.NewSource(1)).Perm(100000) {
+			d.Add(float64(i+1) / 1000)
+		}
+
+		statistics := d.Statistics()
+		assert.Equal(t, 100000, d.Count())
+		assert.LessOrEqual(t, len(d.centroids), 2*digestCompression)
+		assert.LessOrEqual(t, len(d.buffer), digestBufferSize)
+		assert.Equal(t, time.Millisecond, statistics.Min)
+		assert.Equal(t, 100*time.Second, statistics.Max)
+		assert.InDelta(t, 50*time.Second, statistics.Mean, float64(time.Millisecond))
+		assert.InDelta(t, 50*time.Second, statistics.Q50, float64(500*time.Millisecond))
+		assert.InDelta(t, 95*time.Second, statistics.Q95, float64(100*time.Millisecond))
+		assert.InDelta(t, 99*time.Second, statistics.Q99, float64(50*time.Millisecond))
+		assert.InDelta(t, 28868*time.Millisecond, statistics.StdDev, float64(time.Millisecond))
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		var a, b, all DurationDigest
+		for i := 0; i < 3000; i++ {
+			a.Add(float64(i%7) + 1)
+			all.Add(float64(i%7) + 1)
+		}
+		for i := 0; i < 20; i++ {
+			b.Add(float64(i))
+			all.Add(float64(i))
+		}
+
+		a.Merge(&b)
+
+		assert.Equal(t, all.Count(), a.Count())
+		expected, actual := all.Statistics(), a.Statistics()
+		assert.Equal(t, expected.Min, actual.Min)
+		assert.Equal(t, expected.Max, actual.Max)
+		assert.InDelta(t, expected.Mean, actual.Mean, float64(time.Millisecond))
+		assert.InDelta(t, expected.StdDev, actual.StdDev, float64(time.Millisecond))
+		assert.InDelta(t, expected.Q99, actual.Q99, float64(500*time.Millisecond))
+	})
+
+	t.Run("Merge Exact", func(t *testing.T) {
+		a := NewDurationDigest(4, 1)
+		b := NewDurationDigest(3, 2)
+
+		a.Merge(&b)
+
+		assert.Equal(t, CalculateDurationStatistics([]float64{4, 1, 3, 2}), a.Statistics())
+	})
+
+	t.Run("Clone", func(t *testing.T) {
+		d := NewDurationDigest(1)
+		c := d.Clone()
+
+		d.Add(2)
+
+		assert.Equal(t, 1, c.Count())
+		assert.Equal(t, time.Second, c.Statistics().Max)
+	})
+}
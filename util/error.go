@@ -17,32 +17,124 @@ package util
 import (
 	"fmt"
 	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
+	"io"
+	"net/http"
 	"strings"
 	"text/template"
+	"unicode/utf8"
 )
 
+// CorrelationIdHeader is the header carrying the id which is generated for
+// every request, so that requests can be correlated with the logs of the
+// server.
+const CorrelationIdHeader = "X-Correlation-Id"
+
+// RequestIdHeader is the header in which servers return their own id of a
+// request.
+const RequestIdHeader = "X-Request-Id"
+
 // ErrorResponse represents an error returned from the FHIR server.
 type ErrorResponse struct {
-	StatusCode       int
+	StatusCode int
+	// RequestId is the X-Request-Id header of the response, if any
+	RequestId string
+	// CorrelationId is the X-Correlation-Id header of the request, if any
+	CorrelationId string
+	// OperationOutcome describes the error. Errors which aren't reported as
+	// OperationOutcome, like non-FHIR responses of proxies, are represented by
+	// an OperationOutcome with a single issue carrying the error message.
 	OperationOutcome *fm.OperationOutcome
-	OtherError       string
+}
+
+// maxBodySnippetLength is the number of bytes of non-FHIR response bodies
+// kept in an ErrorResponse.
+const maxBodySnippetLength = 1024
+
+// NewErrorResponse creates an ErrorResponse from resp and its already read
+// body.
+func NewErrorResponse(resp *http.Response, body []byte) ErrorResponse {
+	errorResponse := ErrorResponse{
+		StatusCode:       resp.StatusCode,
+		RequestId:        resp.Header.Get(RequestIdHeader),
+		OperationOutcome: ReadOperationOutcome(body),
+	}
+	if resp.Request != nil {
+		errorResponse.CorrelationId = resp.Request.Header.Get(CorrelationIdHeader)
+	}
+	return errorResponse
+}
+
+// ReadErrorResponse reads the body of resp and creates an ErrorResponse from
+// it. A body which can't be read is reported in the OperationOutcome.
+func ReadErrorResponse(resp *http.Response) ErrorResponse {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errorResponse := NewErrorResponse(resp, nil)
+		errorResponse.OperationOutcome = NewErrorOutcome(fmt.Sprintf("error while reading the response body: %v", err))
+		return errorResponse
+	}
+	return NewErrorResponse(resp, body)
+}
+
+// ReadOperationOutcome returns body as OperationOutcome if possible. Otherwise
+// an OperationOutcome with the beginning of body as message is returned.
+func ReadOperationOutcome(body []byte) *fm.OperationOutcome {
+	if operationOutcome, err := fm.UnmarshalOperationOutcome(body); err == nil {
+		return &operationOutcome
+	}
+	return NewErrorOutcome(bodySnippet(body))
+}
+
+// NewErrorOutcome returns an OperationOutcome with a single issue of code
+// exception carrying message as diagnostics.
+func NewErrorOutcome(message string) *fm.OperationOutcome {
+	issue := fm.OperationOutcomeIssue{Severity: fm.IssueSeverityError, Code: fm.IssueTypeException}
+	if message != "" {
+		issue.Diagnostics = &message
+	}
+	return &fm.OperationOutcome{Issue: []fm.OperationOutcomeIssue{issue}}
+}
+
+func bodySnippet(body []byte) string {
+	if len(body) <= maxBodySnippetLength {
+		return string(body)
+	}
+	snippet := body[:maxBodySnippetLength]
+	for len(snippet) > 0 && !utf8.Valid(snippet) {
+		snippet = snippet[:len(snippet)-1]
+	}
+	return fmt.Sprintf("%s... (%d bytes omitted)", snippet, len(body)-len(snippet))
 }
 
 // String returns the ErrorResponse in a default formatted way.
 func (errRes *ErrorResponse) String() string {
 	builder := strings.Builder{}
 	builder.WriteString(fmt.Sprintf("StatusCode  : %d\n", errRes.StatusCode))
+	builder.WriteString(FmtRequestIds(errRes.RequestId, errRes.CorrelationId))
 	if errRes.OperationOutcome != nil {
 		builder.WriteString(FmtOperationOutcomes([]*fm.OperationOutcome{errRes.OperationOutcome}))
 	}
-	if len(errRes.OtherError) > 0 {
-		builder.WriteString(fmt.Sprintf("Error       : %s\n", IndentExceptFirstLine(14, errRes.OtherError)))
+	return builder.String()
+}
+
+// FmtRequestIds formats the non-empty ones of the request id returned by the
+// server and the correlation id sent with the request, one per line.
+func FmtRequestIds(requestId string, correlationId string) string {
+	builder := strings.Builder{}
+	if requestId != "" {
+		builder.WriteString(fmt.Sprintf("RequestId   : %s\n", requestId))
+	}
+	if correlationId != "" {
+		builder.WriteString(fmt.Sprintf("Correlation : %s\n", correlationId))
 	}
 	return builder.String()
 }
 
 var outcomeTemplate, _ = template.New("outcomes").
-	Funcs(template.FuncMap{"join": strings.Join}).
+	Funcs(template.FuncMap{
+		"join":   strings.Join,
+		"indent": func(v string) string { return IndentExceptFirstLine(14, v) },
+	}).
 	Parse(`{{ define "issue" -}}
 Severity    : {{ .Severity.Display }}
 Code        : {{ .Code.Definition }}
@@ -57,7 +149,7 @@ Details     : {{ . }}
 {{ end -}}
 {{ end -}}
 {{ with .Diagnostics -}}
-Diagnostics : {{ . }}
+Diagnostics : {{ indent . }}
 {{ end -}}
 {{ with .Expression -}}
 Expression  : {{ join . ", " }}
@@ -3,6 +3,9 @@ package util
 import (
 	fm "github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -21,25 +24,42 @@ func TestString(t *testing.T) {
 
 	t.Run("Other Error", func(t *testing.T) {
 		errorResponse := &ErrorResponse{
-			StatusCode: 400,
-			OtherError: "other error",
+			StatusCode:       400,
+			OperationOutcome: NewErrorOutcome("other error"),
 		}
 		assert.Equal(t, `StatusCode  : 400
-Error       : other error
+Severity    : Error
+Code        : An unexpected internal error has occurred.
+Diagnostics : other error
 `, errorResponse.String())
 	})
 
 	t.Run("Other Error with Newline", func(t *testing.T) {
 		errorResponse := &ErrorResponse{
-			StatusCode: 400,
-			OtherError: "other\nerror",
+			StatusCode:       400,
+			OperationOutcome: NewErrorOutcome("other\nerror"),
 		}
 		assert.Equal(t, `StatusCode  : 400
-Error       : other
+Severity    : Error
+Code        : An unexpected internal error has occurred.
+Diagnostics : other
               error
 `, errorResponse.String())
 	})
 
+	t.Run("With RequestId and CorrelationId", func(t *testing.T) {
+		errorResponse := &ErrorResponse{
+			StatusCode:       400,
+			RequestId:        "id-162312",
+			CorrelationId:    "id-172304",
+			OperationOutcome: &fm.OperationOutcome{},
+		}
+		assert.Equal(t, `StatusCode  : 400
+RequestId   : id-162312
+Correlation : id-172304
+`, errorResponse.String())
+	})
+
 	t.Run("WithOneIssue", func(t *testing.T) {
 		errorResponse := &ErrorResponse{
 			StatusCode: 400,
@@ -145,3 +165,51 @@ Code        : Content invalid against the specification or a profile.
 `, errorResponse.String())
 	})
 }
+
+func TestNewErrorResponse(t *testing.T) {
+	t.Run("OperationOutcome", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: 400,
+			Header:     http.Header{"X-Request-Id": []string{"id-162650"}},
+			Request:    &http.Request{Header: http.Header{"X-Correlation-Id": []string{"id-172411"}}},
+		}
+		errorResponse := NewErrorResponse(resp, []byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "error", "code": "invalid"}]}`))
+
+		assert.Equal(t, 400, errorResponse.StatusCode)
+		assert.Equal(t, "id-162650", errorResponse.RequestId)
+		assert.Equal(t, "id-172411", errorResponse.CorrelationId)
+		assert.Len(t, errorResponse.OperationOutcome.Issue, 1)
+		assert.Equal(t, fm.IssueTypeInvalid, errorResponse.OperationOutcome.Issue[0].Code)
+	})
+
+	t.Run("Other Error", func(t *testing.T) {
+		errorResponse := NewErrorResponse(&http.Response{StatusCode: 502}, []byte("Bad Gateway"))
+
+		assert.Equal(t, 502, errorResponse.StatusCode)
+		assert.Empty(t, errorResponse.RequestId)
+		assert.Equal(t, fm.IssueTypeException, errorResponse.OperationOutcome.Issue[0].Code)
+		assert.Equal(t, "Bad Gateway", *errorResponse.OperationOutcome.Issue[0].Diagnostics)
+	})
+
+	t.Run("Large Other Error", func(t *testing.T) {
+		body := strings.Repeat("a", maxBodySnippetLength+10)
+		errorResponse := NewErrorResponse(&http.Response{StatusCode: 502}, []byte(body))
+
+		assert.Equal(t, body[:maxBodySnippetLength]+"... (10 bytes omitted)",
+			*errorResponse.OperationOutcome.Issue[0].Diagnostics)
+	})
+}
+
+func TestReadErrorResponse(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: 503,
+		Header:     http.Header{"X-Request-Id": []string{"id-163012"}},
+		Body:       io.NopCloser(strings.NewReader("unavailable")),
+	}
+
+	errorResponse := ReadErrorResponse(resp)
+
+	assert.Equal(t, 503, errorResponse.StatusCode)
+	assert.Equal(t, "id-163012", errorResponse.RequestId)
+	assert.Equal(t, "unavailable", *errorResponse.OperationOutcome.Issue[0].Diagnostics)
+}
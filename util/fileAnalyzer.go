@@ -15,11 +15,22 @@
 package util
 
 import (
+	"bytes"
 	"io"
+	"sync"
 )
 
 // Size of the buffer used for calculating file chunks.
-const chunksCalculationBufferSizeBytes = 4096
+const chunksCalculationBufferSizeBytes = 256 * 1024
+
+// Pool of buffers used for calculating file chunks, so that inspecting many
+// files doesn't allocate a new buffer for each file.
+var chunksCalculationBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, chunksCalculationBufferSizeBytes)
+		return &buf
+	},
+}
 
 // FileChunk describes a chunk within a file with its starting position and end
 // position in bytes. Both are given as bytes counted from the file's beginning.
@@ -48,7 +59,9 @@ func CalculateFileChunks(r io.Reader, delimiter byte, res chan<- FileChunkCalcul
 	var lastSeenDelimiterTokenOffsetBytes int64 = 0
 	alreadyReadBytes := int64(0)
 	chunkNumber := 0
-	buf := make([]byte, 0, chunksCalculationBufferSizeBytes)
+	pooledBuf := chunksCalculationBufferPool.Get().(*[]byte)
+	defer chunksCalculationBufferPool.Put(pooledBuf)
+	buf := (*pooledBuf)[:0]
 	for {
 		n, err := r.Read(buf[:cap(buf)])
 		buf = buf[:n]
@@ -79,18 +92,23 @@ func CalculateFileChunks(r io.Reader, delimiter byte, res chan<- FileChunkCalcul
 			}
 		}
 
-		for idx, b := range buf {
-			if b == delimiter {
-				chunkNumber++
-				res <- FileChunkCalculationResult{
-					FileChunk: FileChunk{
-						ChunkNumber: chunkNumber,
-						StartBytes:  lastSeenDelimiterTokenOffsetBytes,
-						EndBytes:    alreadyReadBytes + int64(idx),
-					},
-				}
-				lastSeenDelimiterTokenOffsetBytes = alreadyReadBytes + int64(idx) + 1
+		// bytes.IndexByte uses optimized assembly on most platforms
+		for idx := 0; ; {
+			i := bytes.IndexByte(buf[idx:], delimiter)
+			if i < 0 {
+				break
+			}
+			idx += i
+			chunkNumber++
+			res <- FileChunkCalculationResult{
+				FileChunk: FileChunk{
+					ChunkNumber: chunkNumber,
+					StartBytes:  lastSeenDelimiterTokenOffsetBytes,
+					EndBytes:    alreadyReadBytes + int64(idx),
+				},
 			}
+			lastSeenDelimiterTokenOffsetBytes = alreadyReadBytes + int64(idx) + 1
+			idx++
 		}
 
 		alreadyReadBytes += int64(n)
@@ -109,3 +109,39 @@ func TestCalculateFileChunksMultipleConsecutiveDelimiters(t *testing.T) {
 	assert.Equal(t, int64(11), resultPool[3].FileChunk.StartBytes)
 	assert.Equal(t, reader.Size(), resultPool[3].FileChunk.EndBytes)
 }
+
+func TestCalculateFileChunksSpanningMultipleBuffers(t *testing.T) {
+	res := make(chan FileChunkCalculationResult)
+	line := strings.Repeat("a", chunksCalculationBufferSizeBytes+10)
+	reader := strings.NewReader(line + "\n" + line + "\n")
+
+	resultPool := make([]FileChunkCalculationResult, 0, 2)
+	go CalculateFileChunks(reader, byte('\n'), res)
+
+	for chunk := range res {
+		resultPool = append(resultPool, chunk)
+	}
+
+	assert.Equal(t, 2, len(resultPool))
+	assert.Equal(t, int64(0), resultPool[0].FileChunk.StartBytes)
+	assert.Equal(t, int64(len(line)), resultPool[0].FileChunk.EndBytes)
+	assert.Equal(t, int64(len(line)+1), resultPool[1].FileChunk.StartBytes)
+	assert.Equal(t, int64(2*len(line)+1), resultPool[1].FileChunk.EndBytes)
+}
+
+func TestCalculateFileChunksWithConsecutiveDelimiters(t *testing.T) {
+	res := make(chan FileChunkCalculationResult)
+	reader := strings.NewReader("a\n\nb\n")
+
+	resultPool := make([]FileChunkCalculationResult, 0, 3)
+	go CalculateFileChunks(reader, byte('\n'), res)
+
+	for chunk := range res {
+		resultPool = append(resultPool, chunk)
+	}
+
+	assert.Equal(t, 3, len(resultPool))
+	assert.Equal(t, FileChunk{ChunkNumber: 1, StartBytes: 0, EndBytes: 1}, resultPool[0].FileChunk)
+	assert.Equal(t, FileChunk{ChunkNumber: 2, StartBytes: 2, EndBytes: 2}, resultPool[1].FileChunk)
+	assert.Equal(t, FileChunk{ChunkNumber: 3, StartBytes: 3, EndBytes: 4}, resultPool[2].FileChunk)
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// A PanicError is the error of a task of a WorkerPool which panicked.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", err.Value, err.Stack)
+}
+
+// WorkerPool runs tasks in their own goroutines with at most a fixed number
+// of them at the same time. Panics of tasks are recovered and reported as
+// PanicError. Once the context of the pool is cancelled, no new tasks are
+// started and running tasks see their context cancelled, so that the pool
+// drains gracefully.
+type WorkerPool struct {
+	ctx     context.Context
+	timeout time.Duration
+	limiter chan struct{}
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	errs    []error
+}
+
+// NewWorkerPool creates a pool running up to concurrency tasks, at least one,
+// at the same time. With a positive timeout, the context of each task is
+// cancelled after that duration.
+func NewWorkerPool(ctx context.Context, concurrency int, timeout time.Duration) *WorkerPool {
+	return &WorkerPool{ctx: ctx, timeout: timeout, limiter: make(chan struct{}, max(1, concurrency))}
+}
+
+// Go runs task as soon as less than concurrency tasks are running and calls
+// done with its error afterwards. Without done, errors are returned by Wait.
+// Returns false without running task if the context of the pool is cancelled.
+func (p *WorkerPool) Go(task func(ctx context.Context) error, done func(err error)) bool {
+	if p.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case p.limiter <- struct{}{}:
+	case <-p.ctx.Done():
+		return false
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.limiter }()
+		err := p.run(task)
+		if done != nil {
+			done(err)
+		} else if err != nil {
+			p.mutex.Lock()
+			p.errs = append(p.errs, err)
+			p.mutex.Unlock()
+		}
+	}()
+	return true
+}
+
+func (p *WorkerPool) run(task func(ctx context.Context) error) (err error) {
+	ctx := p.ctx
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if value := recover(); value != nil {
+			err = &PanicError{Value: value, Stack: debug.Stack()}
+		}
+	}()
+	return task(ctx)
+}
+
+// Wait waits for all started tasks and returns the joined errors of the tasks
+// without done function.
+func (p *WorkerPool) Wait() error {
+	p.wg.Wait()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return errors.Join(p.errs...)
+}
+
+// ForEach calls fn with each of the items using at most concurrency
+// goroutines at the same time, at least one. Returns after all calls
+// finished. A panic of fn is raised again in the calling goroutine.
+func ForEach[T any](items []T, concurrency int, fn func(item T)) {
+	pool := NewWorkerPool(context.Background(), concurrency, 0)
+	for _, item := range items {
+		pool.Go(func(context.Context) error {
+			fn(item)
+			return nil
+		}, nil)
+	}
+	if err := pool.Wait(); err != nil {
+		panic(err)
+	}
+}
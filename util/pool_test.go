@@ -0,0 +1,134 @@
+// Copyright 2019 - 2024 The Samply Community
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEach(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	var mutex sync.Mutex
+	var visited []int
+
+	ForEach([]int{0, 1, 2, 3, 4, 5}, 2, func(item int) {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		mutex.Lock()
+		visited = append(visited, item)
+		mutex.Unlock()
+		running.Add(-1)
+	})
+
+	sort.Ints(visited)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, visited)
+	assert.LessOrEqual(t, maxRunning.Load(), int32(2))
+
+	t.Run("without items", func(t *testing.T) {
+		ForEach([]int{}, 2, func(item int) {
+			t.Fatal("expected no call")
+		})
+	})
+
+	t.Run("zero concurrency", func(t *testing.T) {
+		var calls int
+		ForEach([]int{0, 1}, 0, func(item int) {
+			calls++
+		})
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestForEachPanic(t *testing.T) {
+	assert.Panics(t, func() {
+		ForEach([]int{0, 1}, 2, func(item int) {
+			if item == 1 {
+				panic("boom")
+			}
+		})
+	})
+}
+
+func TestWorkerPool(t *testing.T) {
+	t.Run("errors and panics", func(t *testing.T) {
+		pool := NewWorkerPool(context.Background(), 2, 0)
+
+		pool.Go(func(ctx context.Context) error { return errors.New("failed") }, nil)
+		pool.Go(func(ctx context.Context) error { panic("boom") }, nil)
+		pool.Go(func(ctx context.Context) error { return nil }, nil)
+
+		err := pool.Wait()
+		assert.ErrorContains(t, err, "failed")
+		var panicErr *PanicError
+		if assert.True(t, errors.As(err, &panicErr)) {
+			assert.Equal(t, "boom", panicErr.Value)
+			assert.Contains(t, panicErr.Error(), "panic: boom")
+		}
+	})
+
+	t.Run("done", func(t *testing.T) {
+		pool := NewWorkerPool(context.Background(), 1, 0)
+		var doneErr error
+
+		pool.Go(func(ctx context.Context) error { panic("boom") }, func(err error) { doneErr = err })
+
+		assert.NoError(t, pool.Wait())
+		assert.ErrorContains(t, doneErr, "panic: boom")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		pool := NewWorkerPool(context.Background(), 1, 10*time.Millisecond)
+
+		pool.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, nil)
+
+		assert.ErrorIs(t, pool.Wait(), context.DeadlineExceeded)
+	})
+
+	t.Run("drain on cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		pool := NewWorkerPool(ctx, 1, 0)
+		started := make(chan struct{})
+
+		assert.True(t, pool.Go(func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return nil
+		}, nil))
+		<-started
+		go cancel()
+
+		// blocks until the pool is cancelled, because the only worker is busy
+		assert.False(t, pool.Go(func(ctx context.Context) error {
+			t.Error("expected the task not to run")
+			return nil
+		}, nil))
+		assert.NoError(t, pool.Wait())
+	})
+}
@@ -16,42 +16,61 @@ package util
 
 import (
 	"fmt"
-	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
+	"math"
 	"sort"
+	"strconv"
 	"time"
 )
 
 // DurationStatistics represents statistics about measured durations.
-// Comprises information about the mean and max as well as different
-// percentiles (50, 95 and 99).
+// Comprises information about the min, mean and max, the standard deviation
+// as well as different percentiles (50, 95 and 99).
 type DurationStatistics struct {
-	Mean, Q50, Q95, Q99, Max time.Duration
+	Min, Mean, Q50, Q95, Q99, Max, StdDev time.Duration
 }
 
-// Calculates the DurationStatistics for a set of given durations.
+// Calculates the DurationStatistics for a set of given durations in seconds.
+// Percentiles are linearly interpolated between the nearest durations, so
+// that they aren't biased for small samples. The standard deviation is the
+// sample standard deviation, which is zero for less than two durations.
+//
+// The durations are sorted in place.
 func CalculateDurationStatistics(durations []float64) DurationStatistics {
 	if len(durations) == 0 {
 		return DurationStatistics{}
 	}
 
 	sort.Float64s(durations)
+	mean, stdDev := stat.MeanStdDev(durations, nil)
+	if len(durations) < 2 {
+		stdDev = 0
+	}
 	return DurationStatistics{
-		Mean: time.Duration(floats.Sum(durations)/float64(len(durations))*1000) * time.Millisecond,
-		Q50:  time.Duration(durations[len(durations)/2]*1000) * time.Millisecond,
-		Q95:  time.Duration(durations[int(float32(len(durations))*0.95)]*1000) * time.Millisecond,
-		Q99:  time.Duration(durations[int(float32(len(durations))*0.99)]*1000) * time.Millisecond,
-		Max:  time.Duration(durations[len(durations)-1]*1000) * time.Millisecond,
+		Min:    secondsToDuration(durations[0]),
+		Mean:   secondsToDuration(mean),
+		Q50:    secondsToDuration(stat.Quantile(0.50, stat.LinInterp, durations, nil)),
+		Q95:    secondsToDuration(stat.Quantile(0.95, stat.LinInterp, durations, nil)),
+		Q99:    secondsToDuration(stat.Quantile(0.99, stat.LinInterp, durations, nil)),
+		Max:    secondsToDuration(durations[len(durations)-1]),
+		StdDev: secondsToDuration(stdDev),
 	}
 }
 
+// secondsToDuration converts seconds into a duration with millisecond
+// precision.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds*1000) * time.Millisecond
+}
+
 // FmtBytesHumanReadable takes an amount of bytes and returns them in a human readable form
-// up to a unit of PiB.
+// up to a unit of PiB. The output doesn't depend on the locale.
 func FmtBytesHumanReadable(bytes float32) string {
 	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
 
 	var unitIdx int
 	for {
-		if bytes <= 1024 || (unitIdx+1) > len(units)-1 {
+		if bytes < 1024 || (unitIdx+1) > len(units)-1 {
 			break
 		}
 
@@ -73,3 +92,47 @@ func FmtDurationHumanReadable(d time.Duration) string {
 		return fmt.Sprintf("%s", d.Round(time.Second))
 	}
 }
+
+// FmtDurationSeconds returns the duration in seconds with millisecond precision
+// as plain number without unit, like 1.234, which is easy to process in scripts.
+func FmtDurationSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Round(time.Millisecond).Seconds(), 'f', 3, 64)
+}
+
+// FmtBytesPlain returns the amount of bytes rounded to whole bytes as plain
+// number without unit, which is easy to process in scripts.
+func FmtBytesPlain(bytes float64) string {
+	return strconv.FormatFloat(math.Round(bytes), 'f', 0, 64)
+}
+
+// UnitFormat formats durations and amounts of bytes either human readable or,
+// if Raw is true, as plain numbers of seconds and bytes.
+type UnitFormat struct {
+	Raw bool
+}
+
+// Duration formats d using FmtDurationSeconds or FmtDurationHumanReadable.
+func (f UnitFormat) Duration(d time.Duration) string {
+	if f.Raw {
+		return FmtDurationSeconds(d)
+	}
+	return FmtDurationHumanReadable(d)
+}
+
+// Latency formats a latency from DurationStatistics using FmtDurationSeconds
+// or the default format of time.Duration.
+func (f UnitFormat) Latency(d time.Duration) string {
+	if f.Raw {
+		return FmtDurationSeconds(d)
+	}
+	return d.String()
+}
+
+// Bytes formats an amount of bytes using FmtBytesPlain or
+// FmtBytesHumanReadable.
+func (f UnitFormat) Bytes(bytes float64) string {
+	if f.Raw {
+		return FmtBytesPlain(bytes)
+	}
+	return FmtBytesHumanReadable(float32(bytes))
+}
@@ -83,3 +83,67 @@ func TestFmtDurationHumanReadable(t *testing.T) {
 		})
 	}
 }
+
+func TestFmtBytesHumanReadableBoundary(t *testing.T) {
+	assert.Equal(t, "1023.00 B", FmtBytesHumanReadable(1023))
+	assert.Equal(t, "1.00 KiB", FmtBytesHumanReadable(1024))
+	assert.Equal(t, "1.50 MiB", FmtBytesHumanReadable(1.5*1024*1024))
+}
+
+func TestFmtDurationSeconds(t *testing.T) {
+	assert.Equal(t, "0.000", FmtDurationSeconds(0))
+	assert.Equal(t, "0.512", FmtDurationSeconds(512*time.Millisecond))
+	assert.Equal(t, "62.001", FmtDurationSeconds(62*time.Second+1234*time.Microsecond))
+}
+
+func TestFmtBytesPlain(t *testing.T) {
+	assert.Equal(t, "0", FmtBytesPlain(0))
+	assert.Equal(t, "1536", FmtBytesPlain(1535.6))
+	assert.Equal(t, "64738201", FmtBytesPlain(64738201))
+}
+
+func TestUnitFormat(t *testing.T) {
+	t.Run("Humanized", func(t *testing.T) {
+		units := UnitFormat{}
+		assert.Equal(t, "1m2s", units.Duration(62*time.Second))
+		assert.Equal(t, "1.5s", units.Latency(1500*time.Millisecond))
+		assert.Equal(t, "2.00 KiB", units.Bytes(2048))
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		units := UnitFormat{Raw: true}
+		assert.Equal(t, "62.000", units.Duration(62*time.Second))
+		assert.Equal(t, "1.500", units.Latency(1500*time.Millisecond))
+		assert.Equal(t, "2048", units.Bytes(2048))
+	})
+}
+
+func TestCalculateDurationStatisticsInterpolated(t *testing.T) {
+	t.Run("Small Sample", func(t *testing.T) {
+		statistics := CalculateDurationStatistics([]float64{4, 1, 3, 2})
+		assert.Equal(t, 1*time.Second, statistics.Min)
+		assert.Equal(t, 2500*time.Millisecond, statistics.Mean)
+		assert.Equal(t, 2*time.Second, statistics.Q50)
+		assert.Equal(t, 3800*time.Millisecond, statistics.Q95)
+		assert.Equal(t, 3960*time.Millisecond, statistics.Q99)
+		assert.Equal(t, 4*time.Second, statistics.Max)
+		assert.Equal(t, 1290*time.Millisecond, statistics.StdDev)
+	})
+
+	t.Run("Percentiles Never Exceed Max", func(t *testing.T) {
+		durations := make([]float64, 100)
+		for i := range durations {
+			durations[i] = float64(i + 1)
+		}
+		statistics := CalculateDurationStatistics(durations)
+		assert.Equal(t, 95*time.Second, statistics.Q95)
+		assert.Equal(t, 99*time.Second, statistics.Q99)
+		assert.Equal(t, 100*time.Second, statistics.Max)
+	})
+
+	t.Run("No Standard Deviation Of One Duration", func(t *testing.T) {
+		statistics := CalculateDurationStatistics([]float64{1.0})
+		assert.Equal(t, time.Duration(0), statistics.StdDev)
+		assert.Equal(t, 1*time.Second, statistics.Min)
+	})
+}